@@ -10,7 +10,12 @@
 package accounting_gm
 
 import (
+	"bytes"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/couchbase/query/accounting"
 )
 
 func TestGoMetrics(t *testing.T) {
@@ -51,3 +56,45 @@ func TestGoMetrics(t *testing.T) {
 	acctstore.MetricRegistry().Histogram("response_count")
 	acctstore.MetricRegistry().Timer("request_time")
 }
+
+func TestPrometheus(t *testing.T) {
+	acctstore := NewAccountingStore()
+	mr := acctstore.MetricRegistry()
+
+	mr.Counter("selects").Inc(3)
+	mr.Counter("inserts").Inc(1)
+	mr.Counter("prom_errors").Inc(2)
+	mr.Timer("prom_timer").Update(2 * time.Second)
+
+	vitals, err := acctstore.Vitals()
+	if err != nil {
+		t.Fatalf("Failed to get vitals: %v", err)
+	}
+
+	var b bytes.Buffer
+	if err := accounting.WritePrometheus(&b, mr, vitals); err != nil {
+		t.Fatalf("Failed to write metrics: %v", err)
+	}
+	out := b.String()
+
+	for _, line := range []string{
+		"# TYPE n1ql_statements counter",
+		"n1ql_statements{type=\"insert\"} 1",
+		"n1ql_statements{type=\"select\"} 3",
+		"# TYPE n1ql_prom_errors counter",
+		"n1ql_prom_errors 2",
+		"# TYPE n1ql_prom_timer_seconds summary",
+		"n1ql_prom_timer_seconds{quantile=\"0.5\"} 2",
+		"n1ql_prom_timer_seconds_sum 2",
+		"n1ql_prom_timer_seconds_count 1",
+		"# TYPE n1ql_vitals_cores gauge",
+		"# TYPE n1ql_vitals_uptime_seconds gauge",
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Errorf("Expected %q in output:\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, "n1ql_vitals_version") {
+		t.Errorf("Expected version to be left out of output:\n%s", out)
+	}
+}
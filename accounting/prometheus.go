@@ -0,0 +1,212 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package accounting
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const PROMETHEUS_PREFIX = "n1ql_"
+
+// Prometheus metric types
+const (
+	_PROM_COUNTER = "counter"
+	_PROM_GAUGE   = "gauge"
+	_PROM_SUMMARY = "summary"
+)
+
+// Counters that are reported as a single family, told apart by a label
+type promLabel struct {
+	family string
+	label  string
+	value  string
+}
+
+var promLabels = map[string]promLabel{
+	_SELECTS:      {"statements", "type", "select"},
+	_UPDATES:      {"statements", "type", "update"},
+	_INSERTS:      {"statements", "type", "insert"},
+	_DELETES:      {"statements", "type", "delete"},
+	_TRANSACTIONS: {"statements", "type", "start_transaction"},
+
+	_UNBOUNDED: {"scan_consistency", "level", "not_bounded"},
+	_AT_PLUS:   {"scan_consistency", "level", "at_plus"},
+	_SCAN_PLUS: {"scan_consistency", "level", "request_plus"},
+
+	_REQUESTS_250MS:  {"slow_requests", "threshold", "250ms"},
+	_REQUESTS_500MS:  {"slow_requests", "threshold", "500ms"},
+	_REQUESTS_1000MS: {"slow_requests", "threshold", "1000ms"},
+	_REQUESTS_5000MS: {"slow_requests", "threshold", "5000ms"},
+}
+
+// Counters that go up and down
+var promGauges = map[string]bool{
+	_ACTIVE_REQUESTS: true,
+	_QUEUED_REQUESTS: true,
+}
+
+var promQuantiles = []float64{0.5, 0.75, 0.95, 0.99, 0.999}
+
+type promSample struct {
+	labels string
+	value  float64
+}
+
+type promFamily struct {
+	name    string
+	mtype   string
+	samples []promSample
+}
+
+type promWriter struct {
+	families map[string]*promFamily
+}
+
+func (this *promWriter) add(name, mtype, labels string, value float64) {
+	family := this.families[name]
+	if family == nil {
+		family = &promFamily{name: name, mtype: mtype}
+		this.families[name] = family
+	}
+	family.samples = append(family.samples, promSample{labels, value})
+}
+
+func (this *promWriter) addSummary(name string, percentiles []float64, sum, count int64, scale float64) {
+	for i, p := range promQuantiles {
+		this.add(name, _PROM_SUMMARY, "{quantile=\""+strconv.FormatFloat(p, 'g', -1, 64)+"\"}",
+			percentiles[i]*scale)
+	}
+	this.add(name, _PROM_SUMMARY, "_sum", float64(sum)*scale)
+	this.add(name, _PROM_SUMMARY, "_count", float64(count))
+}
+
+func (this *promWriter) write(w io.Writer) error {
+	names := make([]string, 0, len(this.families))
+	for name := range this.families {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	b := bufio.NewWriter(w)
+	for _, name := range names {
+		family := this.families[name]
+		sort.SliceStable(family.samples, func(i, j int) bool {
+			return family.samples[i].labels < family.samples[j].labels
+		})
+		fmt.Fprintf(b, "# TYPE %s %s\n", name, family.mtype)
+		for _, s := range family.samples {
+			fmt.Fprintf(b, "%s%s %s\n", name, s.labels, promValue(s.value))
+		}
+	}
+	return b.Flush()
+}
+
+/*
+Write all the metrics in the registry, and the vital signs, in the
+Prometheus text exposition format. Timers are reported in seconds.
+*/
+func WritePrometheus(w io.Writer, registry MetricRegistry, vitals interface{}) error {
+	pw := &promWriter{families: make(map[string]*promFamily)}
+
+	for name, metric := range registry.Counters() {
+		if l, ok := promLabels[name]; ok {
+			pw.add(PROMETHEUS_PREFIX+l.family, _PROM_COUNTER, "{"+l.label+"=\""+l.value+"\"}",
+				float64(metric.Count()))
+		} else if promGauges[name] {
+			pw.add(promName(name), _PROM_GAUGE, "", float64(metric.Count()))
+		} else {
+			pw.add(promName(name), _PROM_COUNTER, "", float64(metric.Count()))
+		}
+	}
+	for name, metric := range registry.Gauges() {
+		pw.add(promName(name), _PROM_GAUGE, "", float64(metric.Value()))
+	}
+	for name, metric := range registry.Meters() {
+		name = promName(name)
+		pw.add(name, _PROM_COUNTER, "", float64(metric.Count()))
+		pw.add(name+"_rate1m", _PROM_GAUGE, "", metric.Rate1())
+		pw.add(name+"_rate5m", _PROM_GAUGE, "", metric.Rate5())
+		pw.add(name+"_rate15m", _PROM_GAUGE, "", metric.Rate15())
+		pw.add(name+"_rate_mean", _PROM_GAUGE, "", metric.RateMean())
+	}
+	for name, metric := range registry.Timers() {
+		name = promName(name)
+		pw.addSummary(name+"_seconds", metric.Percentiles(promQuantiles), metric.Sum(), metric.Count(),
+			1/float64(time.Second))
+		pw.add(name+"_rate1m", _PROM_GAUGE, "", metric.Rate1())
+		pw.add(name+"_rate5m", _PROM_GAUGE, "", metric.Rate5())
+		pw.add(name+"_rate15m", _PROM_GAUGE, "", metric.Rate15())
+		pw.add(name+"_rate_mean", _PROM_GAUGE, "", metric.RateMean())
+	}
+	for name, metric := range registry.Histograms() {
+		pw.addSummary(promName(name), metric.Percentiles(promQuantiles), metric.Sum(), metric.Count(), 1)
+	}
+
+	if vitals != nil {
+		err := addPromVitals(pw, vitals)
+		if err != nil {
+			return err
+		}
+	}
+
+	return pw.write(w)
+}
+
+/*
+Vital signs are reported as gauges. Durations are reported in seconds,
+and values that are neither numbers nor durations are left out.
+*/
+func addPromVitals(pw *promWriter, vitals interface{}) error {
+	bytes, err := json.Marshal(vitals)
+	if err != nil {
+		return err
+	}
+
+	var fields map[string]interface{}
+	err = json.Unmarshal(bytes, &fields)
+	if err != nil {
+		return err
+	}
+
+	for name, val := range fields {
+		name = promName("vitals_" + name)
+		switch val := val.(type) {
+		case float64:
+			pw.add(name, _PROM_GAUGE, "", val)
+		case string:
+			d, err := time.ParseDuration(val)
+			if err == nil {
+				pw.add(name+"_seconds", _PROM_GAUGE, "", d.Seconds())
+			}
+		}
+	}
+	return nil
+}
+
+// metric names only allow letters, digits and underscores
+func promName(name string) string {
+	return PROMETHEUS_PREFIX + strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '_' {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+func promValue(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
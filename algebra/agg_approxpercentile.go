@@ -0,0 +1,157 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util/sketch"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function APPROX_PERCENTILE(expr, fraction).
+It returns an estimate of the value at the fraction of the sorted number
+values, from a KLL sketch of the values, which needs little memory
+whatever the number of values. The fraction is a number or an array of
+numbers between 0 and 1, for an array of estimates.
+*/
+
+type ApproxPercentile struct {
+	AggregateBase
+}
+
+/*
+The function NewApproxPercentile calls NewAggregateBase to
+create an aggregate function named ApproxPercentile.
+*/
+func NewApproxPercentile(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &ApproxPercentile{
+		*NewAggregateBase("approx_percentile", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *ApproxPercentile) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *ApproxPercentile) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewApproxPercentile with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *ApproxPercentile) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewApproxPercentile(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *ApproxPercentile) Copy() expression.Expression {
+	rv := &ApproxPercentile{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It returns a value of type JSON, a number or an array of numbers.
+*/
+func (this *ApproxPercentile) Type() value.Type { return value.JSON }
+
+func (this *ApproxPercentile) MinArgs() int { return 2 }
+func (this *ApproxPercentile) MaxArgs() int { return 2 }
+
+/*
+If no input to the ApproxPercentile function, then the default value
+returned is a null.
+*/
+func (this *ApproxPercentile) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands, and adding the number
+values to the sketch. The fraction is evaluated for the first value.
+*/
+func (this *ApproxPercentile) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	val, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if val.Type() != value.NUMBER {
+		return cumulative, nil
+	}
+
+	cumulative, e = sketchAdd(val, cumulative, newKLLSketch(sketch.KLL_DEFAULT_K), addKLLSketch)
+	if e != nil {
+		return nil, e
+	}
+
+	av := cumulative.(value.AnnotatedValue)
+	if av.GetAttachment("fraction") == nil {
+		fraction, e := evaluateFractions(this.Name(), this.Operands()[1], item, context)
+		if e != nil {
+			return nil, e
+		}
+		av.SetAttachment("fraction", fraction)
+	}
+	return av, nil
+}
+
+/*
+Aggregates intermediate results by merging the sketches.
+*/
+func (this *ApproxPercentile) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateSketches(part, cumulative)
+}
+
+/*
+Compute the Final. Return the estimates of the sketch, or NULL if there
+are no number values.
+*/
+func (this *ApproxPercentile) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	kll, ok := getSketch(cumulative).(*sketch.KLL)
+	if !ok || kll.Count() == 0 {
+		return value.NULL_VALUE, nil
+	}
+
+	fraction, ok := cumulative.(value.AnnotatedValue).GetAttachment("fraction").(value.Value)
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	return kllQuantiles(kll, fraction), nil
+}
@@ -115,7 +115,9 @@ func (this *Avg) CumulateInitial(item, cumulative value.Value, context Context)
 		return cumulative, nil
 	}
 
-	if this.Distinct() {
+	if this.Incremental() && this.Distinct() {
+		return distinctAdd(item, cumulative, true), nil
+	} else if this.Distinct() {
 		return setAdd(item, cumulative, true), nil
 	} else {
 		part := value.NewValue(map[string]interface{}{"sum": item, "count": value.ONE_VALUE})
@@ -147,7 +149,15 @@ func (this *Avg) ComputeFinal(cumulative value.Value, context Context) (value.Va
 	count := float64(0)
 	sum := value.ZERO_NUMBER
 
-	if this.Distinct() {
+	if this.Incremental() && this.Distinct() {
+		dv, e := getDistinctValues(cumulative)
+		if e != nil {
+			return nil, e
+		}
+
+		count = float64(dv.set.Len())
+		sum = dv.sum
+	} else if this.Distinct() {
 		av := cumulative.(value.AnnotatedValue)
 		set := av.GetAttachment("set").(*value.Set)
 		count = float64(set.Len())
@@ -183,14 +193,14 @@ func (this *Avg) ComputeFinal(cumulative value.Value, context Context) (value.Va
 
 /*
 Used for Incremental Aggregation.
-For Distinct aggregate this method will not be called.
+Distinct aggregate removes from the distinct values of window aggregate.
 Cumulative must be NUMBER because it has been added earlier.
 Remove the Numbered input data by evaluating operands from Aggregate.
 */
 
 func (this *Avg) CumulateRemove(item, cumulative value.Value, context Context) (value.Value, error) {
 	if this.Distinct() {
-		return nil, fmt.Errorf("Invalid %v.CumulateRemove() for DISTINCT values.", this.Name())
+		return this.cumulateDistinctRemove(item, cumulative, true, context)
 	}
 
 	item, e := this.Operands()[0].Evaluate(item, context)
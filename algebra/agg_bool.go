@@ -0,0 +1,232 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+Base of the aggregate functions BOOL_AND() and BOOL_OR(). They
+aggregate the counts of the TRUE and FALSE values, skipping the
+values that are not booleans, which allows incremental aggregation.
+*/
+
+type boolAggregateBase struct {
+	AggregateBase
+}
+
+/*
+It returns a value of type BOOLEAN.
+*/
+func (this *boolAggregateBase) Type() value.Type { return value.BOOLEAN }
+
+/*
+If no input to the function, then the default value
+returned is a null.
+*/
+func (this *boolAggregateBase) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands.
+*/
+func (this *boolAggregateBase) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	part, e := evaluateBoolPart(this.Operands(), item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	return cumulateBoolCounts(this.Name(), part, cumulative, false)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *boolAggregateBase) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateBoolCounts(this.Name(), part, cumulative, false)
+}
+
+/*
+Used for Incremental Aggregation.
+Remove the input value from the counts.
+*/
+func (this *boolAggregateBase) CumulateRemove(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	part, e := evaluateBoolPart(this.Operands(), item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	return cumulateBoolCounts(this.Name(), part, cumulative, true)
+}
+
+/*
+This represents the Aggregate function BOOL_AND(expr). It returns TRUE
+if all the boolean values are TRUE.
+*/
+
+type BoolAnd struct {
+	boolAggregateBase
+}
+
+/*
+The function NewBoolAnd calls NewAggregateBase to create an
+aggregate function named BOOL_AND.
+*/
+func NewBoolAnd(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &BoolAnd{
+		boolAggregateBase{*NewAggregateBase("bool_and", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *BoolAnd) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *BoolAnd) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewBoolAnd with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *BoolAnd) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewBoolAnd(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *BoolAnd) Copy() expression.Expression {
+	rv := &BoolAnd{
+		boolAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Compute the Final. Return NULL if there are no boolean values.
+*/
+func (this *BoolAnd) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	trues, falses, e := getBoolCounts(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	} else if trues+falses <= 0.0 {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(falses <= 0.0), nil
+}
+
+/*
+This represents the Aggregate function BOOL_OR(expr). It returns TRUE
+if any of the boolean values is TRUE.
+*/
+
+type BoolOr struct {
+	boolAggregateBase
+}
+
+/*
+The function NewBoolOr calls NewAggregateBase to create an
+aggregate function named BOOL_OR.
+*/
+func NewBoolOr(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &BoolOr{
+		boolAggregateBase{*NewAggregateBase("bool_or", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *BoolOr) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *BoolOr) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewBoolOr with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *BoolOr) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewBoolOr(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *BoolOr) Copy() expression.Expression {
+	rv := &BoolOr{
+		boolAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Compute the Final. Return NULL if there are no boolean values.
+*/
+func (this *BoolOr) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	trues, falses, e := getBoolCounts(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	} else if trues+falses <= 0.0 {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(trues > 0.0), nil
+}
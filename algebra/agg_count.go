@@ -131,7 +131,9 @@ func (this *Count) CumulateInitial(item, cumulative value.Value, context Context
 		}
 	}
 
-	if this.Distinct() {
+	if this.Incremental() && this.Distinct() {
+		return distinctAdd(item, cumulative, false), nil
+	} else if this.Distinct() {
 		return setAdd(item, cumulative, false), nil
 	} else {
 		return this.cumulatePart(value.ONE_VALUE, cumulative, context)
@@ -162,6 +164,8 @@ func (this *Count) ComputeFinal(cumulative value.Value, context Context) (value.
 	if this.Distinct() {
 		if cumulative == value.ZERO_VALUE {
 			return cumulative, nil
+		} else if this.Incremental() {
+			return distinctCount(cumulative)
 		}
 
 		av := cumulative.(value.AnnotatedValue)
@@ -174,14 +178,14 @@ func (this *Count) ComputeFinal(cumulative value.Value, context Context) (value.
 
 /*
 Used for Incremental Aggregation.
-For Distinct aggregate this method will not be called.
+Distinct aggregate removes from the distinct values of window aggregate.
 Cumulative must be NUMBER because it has been added earlier.
 Remove the Numbered input data by evaluating operands from Aggregate.
 */
 
 func (this *Count) CumulateRemove(item, cumulative value.Value, context Context) (value.Value, error) {
 	if this.Distinct() {
-		return nil, fmt.Errorf("Invalid %v.CumulateRemove() for DISTINCT values.", this.Name())
+		return this.cumulateDistinctRemove(item, cumulative, false, context)
 	}
 
 	ops := this.Operands()
@@ -114,7 +114,9 @@ func (this *Countn) CumulateInitial(item, cumulative value.Value, context Contex
 		return cumulative, nil
 	}
 
-	if this.Distinct() {
+	if this.Incremental() && this.Distinct() {
+		return distinctAdd(item, cumulative, true), nil
+	} else if this.Distinct() {
 		return setAdd(item, cumulative, true), nil
 	} else {
 		return this.cumulatePart(value.ONE_VALUE, cumulative, context)
@@ -145,6 +147,8 @@ func (this *Countn) ComputeFinal(cumulative value.Value, context Context) (value
 	if this.Distinct() {
 		if cumulative == value.ZERO_VALUE {
 			return cumulative, nil
+		} else if this.Incremental() {
+			return distinctCount(cumulative)
 		}
 
 		av := cumulative.(value.AnnotatedValue)
@@ -157,14 +161,14 @@ func (this *Countn) ComputeFinal(cumulative value.Value, context Context) (value
 
 /*
 Used for Incremental Aggregation.
-For Distinct aggregate this method will not be called.
+Distinct aggregate removes from the distinct values of window aggregate.
 Cumulative must be NUMBER because it has been added earlier.
 Remove the Numbered input data by evaluating operands from Aggregate.
 */
 
 func (this *Countn) CumulateRemove(item, cumulative value.Value, context Context) (value.Value, error) {
 	if this.Distinct() {
-		return nil, fmt.Errorf("Invalid %v.CumulateRemove() for DISTINCT values.", this.Name())
+		return this.cumulateDistinctRemove(item, cumulative, true, context)
 	}
 
 	item, e := this.Operands()[0].Evaluate(item, context)
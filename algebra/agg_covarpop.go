@@ -0,0 +1,88 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function COVAR_POP(y, x). It returns the
+population covariance of the pairs where both y and x are numbers.
+Type CovarPop is a struct that inherits from regrAggregateBase.
+*/
+
+type CovarPop struct {
+	regrAggregateBase
+}
+
+/*
+The function NewCovarPop calls NewAggregateBase to create an
+aggregate function named COVAR_POP.
+*/
+func NewCovarPop(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &CovarPop{
+		regrAggregateBase{*NewAggregateBase("covar_pop", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *CovarPop) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *CovarPop) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewCovarPop with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *CovarPop) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewCovarPop(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *CovarPop) Copy() expression.Expression {
+	rv := &CovarPop{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Compute the Final. Return NULL if there are no pairs.
+*/
+func (this *CovarPop) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		return value.NewValue(sums.sxy() / sums.count)
+	})
+}
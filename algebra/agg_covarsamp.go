@@ -0,0 +1,91 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function COVAR_SAMP(y, x). It returns the
+sample covariance of the pairs where both y and x are numbers.
+Type CovarSamp is a struct that inherits from regrAggregateBase.
+*/
+
+type CovarSamp struct {
+	regrAggregateBase
+}
+
+/*
+The function NewCovarSamp calls NewAggregateBase to create an
+aggregate function named COVAR_SAMP.
+*/
+func NewCovarSamp(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &CovarSamp{
+		regrAggregateBase{*NewAggregateBase("covar_samp", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *CovarSamp) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *CovarSamp) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewCovarSamp with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *CovarSamp) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewCovarSamp(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *CovarSamp) Copy() expression.Expression {
+	rv := &CovarSamp{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Compute the Final. Return NULL if there are fewer than two pairs.
+*/
+func (this *CovarSamp) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		if sums.count < 2.0 {
+			return value.NULL_VALUE
+		}
+		return value.NewValue(sums.sxy() / (sums.count - 1.0))
+	})
+}
@@ -0,0 +1,305 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+Base of the ordered-set aggregate functions PERCENTILE_CONT(fraction) and
+PERCENTILE_DISC(fraction) WITHIN GROUP (ORDER BY expr). They collect the
+values of the ORDER BY expression, skipping NULL and MISSING, along with
+the fraction, which must be a number between 0 and 1.
+*/
+
+type percentileAggregateBase struct {
+	AggregateBase
+}
+
+/*
+If no input to the function, then the default value
+returned is a null.
+*/
+func (this *percentileAggregateBase) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating the ORDER BY expression, and the
+fraction for the first value.
+*/
+func (this *percentileAggregateBase) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	order := this.Order()
+	if len(order) != 1 {
+		return nil, fmt.Errorf("Invalid %s() WITHIN GROUP clause.", strings.ToUpper(this.Name()))
+	}
+
+	val, e := order[0].Expression().Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if val.Type() <= value.NULL || (this.Name() == "percentile_cont" && val.Type() != value.NUMBER) {
+		return cumulative, nil
+	}
+
+	av := listAdd(val, cumulative)
+	if av.GetAttachment("fraction") == nil {
+		fraction, e := this.Operands()[0].Evaluate(item, context)
+		if e != nil {
+			return nil, e
+		}
+
+		if fraction.Type() != value.NUMBER || fraction.(value.NumberValue).Float64() < 0.0 ||
+			fraction.(value.NumberValue).Float64() > 1.0 {
+			return nil, fmt.Errorf("%s() fraction must be a number between 0 and 1: %v.",
+				strings.ToUpper(this.Name()), fraction.Actual())
+		}
+		av.SetAttachment("fraction", fraction)
+	}
+
+	return av, nil
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *percentileAggregateBase) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	if part == value.NULL_VALUE || cumulative == value.NULL_VALUE {
+		return cumulateListParts(part, cumulative)
+	}
+
+	av, e := cumulateLists(part, cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	if av.GetAttachment("fraction") == nil {
+		av.SetAttachment("fraction", part.(value.AnnotatedValue).GetAttachment("fraction"))
+	}
+	return av, nil
+}
+
+/*
+Return the values sorted in the ORDER BY direction and the fraction, or no
+values if there was no input data.
+*/
+func (this *percentileAggregateBase) sortedValues(cumulative value.Value) (value.Values, float64, error) {
+	if cumulative == value.NULL_VALUE {
+		return nil, 0.0, nil
+	}
+
+	vals, e := copyListValues(cumulative)
+	if e != nil || len(vals) == 0 {
+		return nil, 0.0, e
+	}
+
+	fraction, ok := cumulative.(value.AnnotatedValue).GetAttachment("fraction").(value.NumberValue)
+	if !ok {
+		return nil, 0.0, fmt.Errorf("Missing %s() fraction.", strings.ToUpper(this.Name()))
+	}
+
+	sortValues(vals, this.Order())
+	return vals, fraction.Float64(), nil
+}
+
+/*
+This represents the ordered-set Aggregate function
+PERCENTILE_CONT(fraction) WITHIN GROUP (ORDER BY expr). It returns the
+value at the fraction of the sorted number values, interpolating between
+the adjacent values.
+*/
+
+type PercentileCont struct {
+	percentileAggregateBase
+}
+
+/*
+The function NewPercentileCont calls NewAggregateBase to create an
+aggregate function named PERCENTILE_CONT.
+*/
+func NewPercentileCont(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &PercentileCont{
+		percentileAggregateBase{*NewAggregateBase("percentile_cont", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *PercentileCont) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *PercentileCont) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewPercentileCont with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *PercentileCont) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewPercentileCont(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *PercentileCont) Copy() expression.Expression {
+	rv := &PercentileCont{
+		percentileAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.SetOrder(this.Order().Copy(), this.WithinGroup())
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *PercentileCont) Type() value.Type { return value.NUMBER }
+
+/*
+Compute the Final. Interpolate linearly between the values around the
+position of the fraction. Return NULL if there are no number values.
+*/
+func (this *PercentileCont) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	vals, fraction, e := this.sortedValues(cumulative)
+	if e != nil {
+		return nil, e
+	} else if len(vals) == 0 {
+		return value.NULL_VALUE, nil
+	}
+
+	pos := fraction * float64(len(vals)-1)
+	lo := math.Floor(pos)
+	hi := math.Ceil(pos)
+	lv := vals[int(lo)].(value.NumberValue).Float64()
+	if lo == hi {
+		return value.NewValue(lv), nil
+	}
+
+	hv := vals[int(hi)].(value.NumberValue).Float64()
+	return value.NewValue(lv + (pos-lo)*(hv-lv)), nil
+}
+
+/*
+This represents the ordered-set Aggregate function
+PERCENTILE_DISC(fraction) WITHIN GROUP (ORDER BY expr). It returns the
+first of the sorted values whose position is at or after the fraction.
+*/
+
+type PercentileDisc struct {
+	percentileAggregateBase
+}
+
+/*
+The function NewPercentileDisc calls NewAggregateBase to create an
+aggregate function named PERCENTILE_DISC.
+*/
+func NewPercentileDisc(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &PercentileDisc{
+		percentileAggregateBase{*NewAggregateBase("percentile_disc", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *PercentileDisc) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *PercentileDisc) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewPercentileDisc with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *PercentileDisc) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewPercentileDisc(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *PercentileDisc) Copy() expression.Expression {
+	rv := &PercentileDisc{
+		percentileAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.SetOrder(this.Order().Copy(), this.WithinGroup())
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It returns a value of type JSON.
+*/
+func (this *PercentileDisc) Type() value.Type { return value.JSON }
+
+/*
+Compute the Final. Return NULL if there are no values.
+*/
+func (this *PercentileDisc) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	vals, fraction, e := this.sortedValues(cumulative)
+	if e != nil {
+		return nil, e
+	} else if len(vals) == 0 {
+		return value.NULL_VALUE, nil
+	}
+
+	pos := int(math.Ceil(fraction*float64(len(vals)))) - 1
+	if pos < 0 {
+		pos = 0
+	}
+	return vals[pos], nil
+}
@@ -67,6 +67,10 @@ const (
 	AGGREGATE_WINDOW_FROMLAST
 	AGGREGATE_WINDOW_2ND_POSINT
 	AGGREGATE_WINDOW_2ND_DYNAMIC
+	AGGREGATE_ALLOWS_ORDER
+	AGGREGATE_ALLOWS_WITHIN_GROUP
+	AGGREGATE_REQUIRES_WITHIN_GROUP
+	AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL
 )
 
 /*
@@ -80,6 +84,9 @@ const (
 	AGGREGATE_ALLOWS_FL              = AGGREGATE_ALLOWS_WINDOW | AGGREGATE_ALLOWS_WINDOW_FRAME | AGGREGATE_WINDOW_RESPECTNULLS | AGGREGATE_WINDOW_IGNORENULLS
 	AGGREGATE_ALLOWS_NTH             = AGGREGATE_ALLOWS_FL | AGGREGATE_WINDOW_FROMFIRST | AGGREGATE_WINDOW_FROMLAST | AGGREGATE_WINDOW_2ND_POSINT | AGGREGATE_WINDOW_2ND_DYNAMIC
 	AGGREGATE_ALLOWS_LAGLEAD         = AGGREGATE_ALLOWS_WINDOW | AGGREGATE_WINDOW_ORDER | AGGREGATE_WINDOW_RESPECTNULLS | AGGREGATE_WINDOW_IGNORENULLS | AGGREGATE_WINDOW_2ND_POSINT
+	AGGREGATE_ALLOWS_NODISTINCT      = AGGREGATE_ALLOWS_REGULAR | AGGREGATE_ALLOWS_WINDOW | AGGREGATE_ALLOWS_WINDOW_FRAME | AGGREGATE_ALLOWS_FILTER
+	AGGREGATE_ALLOWS_NODISTINCT_INCR = AGGREGATE_ALLOWS_NODISTINCT | AGGREGATE_ALLOWS_INCREMENTAL
+	AGGREGATE_ORDERED_SET            = AGGREGATE_ALLOWS_NODISTINCT | AGGREGATE_ALLOWS_WITHIN_GROUP | AGGREGATE_REQUIRES_WITHIN_GROUP
 )
 
 /*
@@ -118,17 +125,17 @@ Aggregate functions. The variable represents a map from string to Aggregate Func
 
 var _AGGREGATES = map[string]*AggregateRegistry{
 	"array_agg":       &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &ArrayAgg{}},
-	"avg":             &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL, agg: &Avg{}},
-	"count":           &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL, agg: &Count{}},
-	"countn":          &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL, agg: &Countn{}},
+	"avg":             &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL | AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL, agg: &Avg{}},
+	"count":           &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL | AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL, agg: &Count{}},
+	"countn":          &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL | AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL, agg: &Countn{}},
 	"max":             &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &Max{}},
-	"mean":            &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL, agg: &Avg{}},
+	"mean":            &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL | AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL, agg: &Avg{}},
 	"median":          &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &Median{}},
 	"min":             &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &Min{}},
 	"stddev":          &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &Stddev{}},
 	"stddev_pop":      &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &StddevPop{}},
 	"stddev_samp":     &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &StddevSamp{}},
-	"sum":             &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL, agg: &Sum{}},
+	"sum":             &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL_INCREMENTAL | AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL, agg: &Sum{}},
 	"variance":        &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &Variance{}},
 	"var_pop":         &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &VarPop{}},
 	"variance_pop":    &AggregateRegistry{property: AGGREGATE_ALLOWS_ALL, agg: &VarPop{}},
@@ -146,4 +153,29 @@ var _AGGREGATES = map[string]*AggregateRegistry{
 	"nth_value":       &AggregateRegistry{property: AGGREGATE_ALLOWS_NTH, agg: &NthValue{}},
 	"lag":             &AggregateRegistry{property: AGGREGATE_ALLOWS_LAGLEAD, agg: &Lag{}},
 	"lead":            &AggregateRegistry{property: AGGREGATE_ALLOWS_LAGLEAD, agg: &Lead{}},
+	"string_agg":      &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT | AGGREGATE_ALLOWS_ORDER, agg: &StringAgg{}},
+	"percentile_cont": &AggregateRegistry{property: AGGREGATE_ORDERED_SET, agg: &PercentileCont{}},
+	"percentile_disc": &AggregateRegistry{property: AGGREGATE_ORDERED_SET, agg: &PercentileDisc{}},
+	"mode":            &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT | AGGREGATE_ALLOWS_WITHIN_GROUP, agg: &Mode{}},
+	"corr":            &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &Corr{}},
+	"covar_pop":       &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &CovarPop{}},
+	"covar_samp":      &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &CovarSamp{}},
+	"regr_count":      &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrCount{}},
+	"regr_avgx":       &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrAvgx{}},
+	"regr_avgy":       &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrAvgy{}},
+	"regr_sxx":        &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrSxx{}},
+	"regr_syy":        &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrSyy{}},
+	"regr_sxy":        &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrSxy{}},
+	"regr_slope":      &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrSlope{}},
+	"regr_intercept":  &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrIntercept{}},
+	"regr_r2":         &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &RegrR2{}},
+	"bool_and":        &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &BoolAnd{}},
+	"bool_or":         &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT_INCR, agg: &BoolOr{}},
+
+	// approximate aggregates and sketches
+	"approx_count_distinct": &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT, agg: &ApproxCountDistinct{}},
+	"approx_percentile":     &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT, agg: &ApproxPercentile{}},
+	"sketch_hll":            &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT, agg: &SketchHll{}},
+	"sketch_kll":            &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT, agg: &SketchKll{}},
+	"sketch_merge":          &AggregateRegistry{property: AGGREGATE_ALLOWS_NODISTINCT, agg: &SketchMerge{}},
 }
@@ -0,0 +1,762 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+Base of the aggregate functions of pairs of numbers, CORR(y, x),
+COVAR_POP(y, x), COVAR_SAMP(y, x) and the REGR_*(y, x) functions.
+They aggregate the count and the sums of the pairs where both y and x
+are numbers, which allows incremental aggregation.
+*/
+
+type regrAggregateBase struct {
+	AggregateBase
+}
+
+func (this *regrAggregateBase) MinArgs() int { return 2 }
+func (this *regrAggregateBase) MaxArgs() int { return 2 }
+
+/*
+It returns a value of type NUMBER.
+*/
+func (this *regrAggregateBase) Type() value.Type { return value.NUMBER }
+
+/*
+If no input to the function, then the default value
+returned is a null.
+*/
+func (this *regrAggregateBase) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Pairs where either
+value is not a number are skipped.
+*/
+func (this *regrAggregateBase) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	part, e := evaluateRegrPart(this.Operands(), item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	return cumulateRegrSums(this.Name(), part, cumulative, false)
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *regrAggregateBase) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateRegrSums(this.Name(), part, cumulative, false)
+}
+
+/*
+Used for Incremental Aggregation.
+Remove the pair of the input data from the sums.
+*/
+func (this *regrAggregateBase) CumulateRemove(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	part, e := evaluateRegrPart(this.Operands(), item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	return cumulateRegrSums(this.Name(), part, cumulative, true)
+}
+
+/*
+This represents the Aggregate function REGR_COUNT(y, x). It returns
+the number of pairs where both y and x are numbers.
+*/
+
+type RegrCount struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrCount calls NewAggregateBase to create an
+aggregate function named REGR_COUNT.
+*/
+func NewRegrCount(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrCount{
+		regrAggregateBase{*NewAggregateBase("regr_count", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrCount) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrCount) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrCount with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrCount) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrCount(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrCount) Copy() expression.Expression {
+	rv := &RegrCount{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the REGR_COUNT function, then the default value
+returned is 0.
+*/
+func (this *RegrCount) Default(item value.Value, context Context) (value.Value, error) {
+	return value.ZERO_NUMBER, nil
+}
+
+/*
+Return the number of pairs.
+*/
+func (this *RegrCount) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	sums, e := getRegrSums(this.Name(), cumulative)
+	if e != nil {
+		return nil, e
+	} else if sums == nil {
+		return value.ZERO_NUMBER, nil
+	}
+	return value.NewValue(sums.count), nil
+}
+
+/*
+This represents the Aggregate function REGR_AVGX(y, x). It returns
+the average of x over the pairs where both y and x are numbers.
+*/
+
+type RegrAvgx struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrAvgx calls NewAggregateBase to create an
+aggregate function named REGR_AVGX.
+*/
+func NewRegrAvgx(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrAvgx{
+		regrAggregateBase{*NewAggregateBase("regr_avgx", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrAvgx) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrAvgx) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrAvgx with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrAvgx) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrAvgx(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrAvgx) Copy() expression.Expression {
+	rv := &RegrAvgx{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the average of x, or NULL if there are no pairs.
+*/
+func (this *RegrAvgx) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		return value.NewValue(sums.sumx / sums.count)
+	})
+}
+
+/*
+This represents the Aggregate function REGR_AVGY(y, x). It returns
+the average of y over the pairs where both y and x are numbers.
+*/
+
+type RegrAvgy struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrAvgy calls NewAggregateBase to create an
+aggregate function named REGR_AVGY.
+*/
+func NewRegrAvgy(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrAvgy{
+		regrAggregateBase{*NewAggregateBase("regr_avgy", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrAvgy) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrAvgy) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrAvgy with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrAvgy) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrAvgy(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrAvgy) Copy() expression.Expression {
+	rv := &RegrAvgy{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the average of y, or NULL if there are no pairs.
+*/
+func (this *RegrAvgy) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		return value.NewValue(sums.sumy / sums.count)
+	})
+}
+
+/*
+This represents the Aggregate function REGR_SXX(y, x). It returns
+the sum of the squares of the deviations of x from its average.
+*/
+
+type RegrSxx struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrSxx calls NewAggregateBase to create an
+aggregate function named REGR_SXX.
+*/
+func NewRegrSxx(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrSxx{
+		regrAggregateBase{*NewAggregateBase("regr_sxx", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrSxx) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrSxx) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrSxx with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrSxx) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrSxx(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrSxx) Copy() expression.Expression {
+	rv := &RegrSxx{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the sum of squares of x, or NULL if there are no pairs.
+*/
+func (this *RegrSxx) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		return value.NewValue(sums.sxx())
+	})
+}
+
+/*
+This represents the Aggregate function REGR_SYY(y, x). It returns
+the sum of the squares of the deviations of y from its average.
+*/
+
+type RegrSyy struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrSyy calls NewAggregateBase to create an
+aggregate function named REGR_SYY.
+*/
+func NewRegrSyy(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrSyy{
+		regrAggregateBase{*NewAggregateBase("regr_syy", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrSyy) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrSyy) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrSyy with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrSyy) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrSyy(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrSyy) Copy() expression.Expression {
+	rv := &RegrSyy{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the sum of squares of y, or NULL if there are no pairs.
+*/
+func (this *RegrSyy) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		return value.NewValue(sums.syy())
+	})
+}
+
+/*
+This represents the Aggregate function REGR_SXY(y, x). It returns
+the sum of the products of the deviations of x and y from their averages.
+*/
+
+type RegrSxy struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrSxy calls NewAggregateBase to create an
+aggregate function named REGR_SXY.
+*/
+func NewRegrSxy(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrSxy{
+		regrAggregateBase{*NewAggregateBase("regr_sxy", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrSxy) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrSxy) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrSxy with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrSxy) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrSxy(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrSxy) Copy() expression.Expression {
+	rv := &RegrSxy{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the sum of products, or NULL if there are no pairs.
+*/
+func (this *RegrSxy) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		return value.NewValue(sums.sxy())
+	})
+}
+
+/*
+This represents the Aggregate function REGR_SLOPE(y, x). It returns
+the slope of the least-squares line fitted to the pairs.
+*/
+
+type RegrSlope struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrSlope calls NewAggregateBase to create an
+aggregate function named REGR_SLOPE.
+*/
+func NewRegrSlope(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrSlope{
+		regrAggregateBase{*NewAggregateBase("regr_slope", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrSlope) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrSlope) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrSlope with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrSlope) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrSlope(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrSlope) Copy() expression.Expression {
+	rv := &RegrSlope{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the slope, or NULL if there are no pairs or x is constant.
+*/
+func (this *RegrSlope) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		sxx := sums.sxx()
+		if sxx == 0.0 {
+			return value.NULL_VALUE
+		}
+		return value.NewValue(sums.sxy() / sxx)
+	})
+}
+
+/*
+This represents the Aggregate function REGR_INTERCEPT(y, x). It returns
+the y-intercept of the least-squares line fitted to the pairs.
+*/
+
+type RegrIntercept struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrIntercept calls NewAggregateBase to create an
+aggregate function named REGR_INTERCEPT.
+*/
+func NewRegrIntercept(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrIntercept{
+		regrAggregateBase{*NewAggregateBase("regr_intercept", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrIntercept) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrIntercept) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrIntercept with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrIntercept) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrIntercept(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrIntercept) Copy() expression.Expression {
+	rv := &RegrIntercept{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the intercept, or NULL if there are no pairs or x is constant.
+*/
+func (this *RegrIntercept) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		sxx := sums.sxx()
+		if sxx == 0.0 {
+			return value.NULL_VALUE
+		}
+		return value.NewValue((sums.sumy - sums.sumx*sums.sxy()/sxx) / sums.count)
+	})
+}
+
+/*
+This represents the Aggregate function REGR_R2(y, x). It returns
+the coefficient of determination of the least-squares line fitted to the pairs.
+*/
+
+type RegrR2 struct {
+	regrAggregateBase
+}
+
+/*
+The function NewRegrR2 calls NewAggregateBase to create an
+aggregate function named REGR_R2.
+*/
+func NewRegrR2(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &RegrR2{
+		regrAggregateBase{*NewAggregateBase("regr_r2", operands, flags, filter, wTerm)},
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *RegrR2) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *RegrR2) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewRegrR2 with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *RegrR2) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewRegrR2(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *RegrR2) Copy() expression.Expression {
+	rv := &RegrR2{
+		regrAggregateBase{*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm()))},
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Return the coefficient of determination, NULL if there are no pairs or x is
+constant, and 1 if y is constant.
+*/
+func (this *RegrR2) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	return computeRegrFinal(this.Name(), cumulative, func(sums *regrSums) value.Value {
+		sxx := sums.sxx()
+		syy := sums.syy()
+		if sxx == 0.0 {
+			return value.NULL_VALUE
+		} else if syy == 0.0 {
+			return value.ONE_VALUE
+		}
+		sxy := sums.sxy()
+		return value.NewValue((sxy * sxy) / (sxx * syy))
+	})
+}
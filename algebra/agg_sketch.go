@@ -0,0 +1,391 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util/sketch"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function SKETCH_HLL(expr [, precision]). It
+returns the HyperLogLog sketch of the values other than NULL and MISSING
+as an object, which can be stored, merged with SKETCH_MERGE() or
+SKETCH_UNION(), and estimated with SKETCH_COUNT_DISTINCT(). The precision
+is between 4 and 18, 14 by default.
+*/
+
+type SketchHll struct {
+	AggregateBase
+}
+
+/*
+The function NewSketchHll calls NewAggregateBase to
+create an aggregate function named SketchHll.
+*/
+func NewSketchHll(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &SketchHll{
+		*NewAggregateBase("sketch_hll", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *SketchHll) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *SketchHll) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewSketchHll with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *SketchHll) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewSketchHll(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *SketchHll) Copy() expression.Expression {
+	rv := &SketchHll{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It returns a value of type OBJECT.
+*/
+func (this *SketchHll) Type() value.Type { return value.OBJECT }
+
+func (this *SketchHll) MaxArgs() int { return 2 }
+
+/*
+If no input to the SketchHll function, then the default value
+returned is a null.
+*/
+func (this *SketchHll) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands, and adding the values
+to the sketch. The size of the sketch is evaluated for the first value.
+*/
+func (this *SketchHll) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	val, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if val.Type() <= value.NULL {
+		return cumulative, nil
+	}
+
+	return sketchAdd(val, cumulative, func() (sketch.Sketch, error) {
+		size, e := evaluateSketchSize(this.Name(), this.Operands(), item, context, sketch.HLL_DEFAULT_PRECISION)
+		if e != nil {
+			return nil, e
+		}
+		return newHLLSketch(size)()
+	}, addHLLSketch)
+}
+
+/*
+Aggregates intermediate results by merging the sketches.
+*/
+func (this *SketchHll) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateSketches(part, cumulative)
+}
+
+/*
+Compute the Final. Return the sketch as an object, or NULL if there
+are no values.
+*/
+func (this *SketchHll) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	s := getSketch(cumulative)
+	if s == nil {
+		return value.NULL_VALUE, nil
+	}
+	return sketch.ToValue(s), nil
+}
+
+/*
+This represents the Aggregate function SKETCH_KLL(expr [, k]). It returns
+the KLL sketch of the number values as an object, which can be stored,
+merged with SKETCH_MERGE() or SKETCH_UNION(), and estimated with
+SKETCH_PERCENTILE(). Larger k give more accurate estimates, 200 by default.
+*/
+
+type SketchKll struct {
+	AggregateBase
+}
+
+/*
+The function NewSketchKll calls NewAggregateBase to
+create an aggregate function named SketchKll.
+*/
+func NewSketchKll(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &SketchKll{
+		*NewAggregateBase("sketch_kll", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *SketchKll) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *SketchKll) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewSketchKll with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *SketchKll) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewSketchKll(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *SketchKll) Copy() expression.Expression {
+	rv := &SketchKll{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It returns a value of type OBJECT.
+*/
+func (this *SketchKll) Type() value.Type { return value.OBJECT }
+
+func (this *SketchKll) MaxArgs() int { return 2 }
+
+/*
+If no input to the SketchKll function, then the default value
+returned is a null.
+*/
+func (this *SketchKll) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands, and adding the values
+to the sketch. The size of the sketch is evaluated for the first value.
+*/
+func (this *SketchKll) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	val, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if val.Type() != value.NUMBER {
+		return cumulative, nil
+	}
+
+	return sketchAdd(val, cumulative, func() (sketch.Sketch, error) {
+		size, e := evaluateSketchSize(this.Name(), this.Operands(), item, context, sketch.KLL_DEFAULT_K)
+		if e != nil {
+			return nil, e
+		}
+		return newKLLSketch(size)()
+	}, addKLLSketch)
+}
+
+/*
+Aggregates intermediate results by merging the sketches.
+*/
+func (this *SketchKll) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateSketches(part, cumulative)
+}
+
+/*
+Compute the Final. Return the sketch as an object, or NULL if there
+are no values.
+*/
+func (this *SketchKll) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	s := getSketch(cumulative)
+	if s == nil {
+		return value.NULL_VALUE, nil
+	}
+	return sketch.ToValue(s), nil
+}
+
+/*
+This represents the Aggregate function SKETCH_MERGE(sketch). It returns
+the merge of the sketches of the group, which must be of the same kind.
+Values that are not sketches are skipped.
+*/
+
+type SketchMerge struct {
+	AggregateBase
+}
+
+/*
+The function NewSketchMerge calls NewAggregateBase to
+create an aggregate function named SketchMerge.
+*/
+func NewSketchMerge(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &SketchMerge{
+		*NewAggregateBase("sketch_merge", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *SketchMerge) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *SketchMerge) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewSketchMerge with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *SketchMerge) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewSketchMerge(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *SketchMerge) Copy() expression.Expression {
+	rv := &SketchMerge{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It returns a value of type OBJECT.
+*/
+func (this *SketchMerge) Type() value.Type { return value.OBJECT }
+
+/*
+If no input to the SketchMerge function, then the default value
+returned is a null.
+*/
+func (this *SketchMerge) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands, and merging the sketches.
+*/
+func (this *SketchMerge) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	val, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	s, e := sketch.FromValue(val)
+	if e != nil || s == nil {
+		return cumulative, e
+	}
+
+	av := value.NewAnnotatedValue(value.NULL_VALUE)
+	av.SetAttachment("sketch", s)
+	return cumulateSketches(av, cumulative)
+}
+
+/*
+Aggregates intermediate results by merging the sketches.
+*/
+func (this *SketchMerge) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateSketches(part, cumulative)
+}
+
+/*
+Compute the Final. Return the sketch as an object, or NULL if there
+are no sketches.
+*/
+func (this *SketchMerge) ComputeFinal(cumulative value.Value, context Context) (value.Value, error) {
+	s := getSketch(cumulative)
+	if s == nil {
+		return value.NULL_VALUE, nil
+	}
+	return sketch.ToValue(s), nil
+}
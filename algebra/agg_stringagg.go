@@ -0,0 +1,197 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the Aggregate function STRING_AGG(expr, separator [ORDER BY ...]).
+It returns the string values of the group concatenated, each value after the
+first one preceded by its separator, in the order of the ORDER BY clause.
+Type StringAgg is a struct that inherits from AggregateBase.
+*/
+
+type StringAgg struct {
+	AggregateBase
+}
+
+/*
+The function NewStringAgg calls NewAggregateBase to
+create an aggregate function named StringAgg with
+two expressions as input.
+*/
+func NewStringAgg(operands expression.Expressions, flags uint32, filter expression.Expression, wTerm *WindowTerm) Aggregate {
+	rv := &StringAgg{
+		*NewAggregateBase("string_agg", operands, flags, filter, wTerm),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+It calls the VisitFunction method by passing in the receiver to
+and returns the interface. It is a visitor pattern.
+*/
+func (this *StringAgg) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+/*
+It returns a value of type STRING.
+*/
+func (this *StringAgg) Type() value.Type { return value.STRING }
+
+func (this *StringAgg) MinArgs() int { return 2 }
+func (this *StringAgg) MaxArgs() int { return 2 }
+
+/*
+Calls the evaluate method for aggregate functions and passes in the
+receiver, current item and current context.
+*/
+func (this *StringAgg) Evaluate(item value.Value, context expression.Context) (result value.Value, e error) {
+	return this.evaluate(this, item, context)
+}
+
+/*
+The constructor returns a NewStringAgg with the input operands
+cast to a Function as the FunctionConstructor.
+*/
+func (this *StringAgg) Constructor() expression.FunctionConstructor {
+	return func(operands ...expression.Expression) expression.Function {
+		return NewStringAgg(operands, uint32(0), nil, nil)
+	}
+}
+
+/*
+Copy of the aggregate function
+*/
+
+func (this *StringAgg) Copy() expression.Expression {
+	rv := &StringAgg{
+		*NewAggregateBase(this.Name(), expression.CopyExpressions(this.Operands()),
+			this.Flags(), expression.Copy(this.Filter()), CopyWindowTerm(this.WindowTerm())),
+	}
+
+	rv.SetOrder(this.Order().Copy(), this.WithinGroup())
+	rv.BaseCopy(this)
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+If no input to the StringAgg function, then the default value
+returned is a null.
+*/
+func (this *StringAgg) Default(item value.Value, context Context) (value.Value, error) {
+	return value.NULL_VALUE, nil
+}
+
+/*
+Aggregates input data by evaluating operands. Values other than
+strings are skipped, and a separator other than a string is empty.
+Each string value is collected with its separator and its sort keys.
+*/
+func (this *StringAgg) CumulateInitial(item, cumulative value.Value, context Context) (value.Value, error) {
+	// apply filter if any
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	val, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if val.Type() != value.STRING {
+		return cumulative, nil
+	}
+
+	sep, e := this.Operands()[1].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if sep.Type() != value.STRING {
+		sep = value.EMPTY_STRING_VALUE
+	}
+
+	entry := make([]interface{}, 0, 2+len(this.Order()))
+	entry = append(entry, val, sep)
+	for _, term := range this.Order() {
+		key, e := term.Expression().Evaluate(item, context)
+		if e != nil {
+			return nil, e
+		}
+		entry = append(entry, key)
+	}
+
+	return listAdd(value.NewValue(entry), cumulative), nil
+}
+
+/*
+Aggregates intermediate results and return them.
+*/
+func (this *StringAgg) CumulateIntermediate(part, cumulative value.Value, context Context) (value.Value, error) {
+	return cumulateListParts(part, cumulative)
+}
+
+/*
+Compute the Final. Sort the collected values by their sort keys and
+concatenate them. Return NULL if there are no string values.
+*/
+func (this *StringAgg) ComputeFinal(cumulative value.Value, context Context) (c value.Value, e error) {
+	if cumulative == value.NULL_VALUE {
+		return cumulative, nil
+	}
+
+	vals, e := copyListValues(cumulative)
+	if e != nil {
+		return nil, e
+	} else if len(vals) == 0 {
+		return value.NULL_VALUE, nil
+	}
+
+	entries := make([]value.Values, len(vals))
+	for i, v := range vals {
+		entry, ok := v.Actual().([]interface{})
+		if !ok || len(entry) != 2+len(this.Order()) {
+			return nil, fmt.Errorf("Invalid partial STRING_AGG %v of type %T.", v.Actual(), v.Actual())
+		}
+
+		entries[i] = make(value.Values, len(entry))
+		for j, ev := range entry {
+			entries[i][j] = value.NewValue(ev)
+		}
+	}
+
+	if order := this.Order(); len(order) > 0 {
+		sort.SliceStable(entries, func(i, j int) bool {
+			return collateSortKeys(order, entries[i][2:], entries[j][2:]) < 0
+		})
+	}
+
+	var buf bytes.Buffer
+	for i, entry := range entries {
+		if i > 0 {
+			buf.WriteString(entry[1].Actual().(string))
+		}
+		buf.WriteString(entry[0].Actual().(string))
+	}
+
+	return value.NewValue(buf.String()), nil
+}
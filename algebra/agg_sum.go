@@ -115,7 +115,9 @@ func (this *Sum) CumulateInitial(item, cumulative value.Value, context Context)
 		return cumulative, nil
 	}
 
-	if this.Distinct() {
+	if this.Incremental() && this.Distinct() {
+		return distinctAdd(item, cumulative, true), nil
+	} else if this.Distinct() {
 		return setAdd(item, cumulative, true), nil
 	} else {
 		return this.cumulatePart(item, cumulative, context)
@@ -146,7 +148,7 @@ func (this *Sum) ComputeFinal(cumulative value.Value, context Context) (value.Va
 
 func (this *Sum) CumulateRemove(item, cumulative value.Value, context Context) (value.Value, error) {
 	if this.Distinct() {
-		return nil, fmt.Errorf("Invalid %v.CumulateRemove() for DISTINCT values.", this.Name())
+		return this.cumulateDistinctRemove(item, cumulative, true, context)
 	}
 
 	item, e := this.Operands()[0].Evaluate(item, context)
@@ -192,6 +194,8 @@ func (this *Sum) cumulatePart(part, cumulative value.Value, context Context) (va
 func (this *Sum) computeDistinctFinal(cumulative value.Value, context Context) (c value.Value, e error) {
 	if cumulative == value.NULL_VALUE {
 		return cumulative, nil
+	} else if this.Incremental() {
+		return distinctSum(cumulative)
 	}
 
 	av := cumulative.(value.AnnotatedValue)
@@ -0,0 +1,295 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+	"math"
+	"testing"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util/sketch"
+	"github.com/couchbase/query/value"
+)
+
+// pairs of numbers (y, x) with some that are skipped, booleans b, strings s and modal values m
+var _AGG_ITEMS = []string{
+	`{"y": 1, "x": 1, "b": true, "s": "c", "m": 2}`,
+	`{"y": 2, "x": 2, "b": true, "s": "a", "m": 1}`,
+	`{"y": 4, "x": 3, "b": false, "s": "b", "m": 2}`,
+	`{"y": "a", "x": 4, "b": "x", "s": 1, "m": 1}`,
+	`{"y": null, "x": 5, "s": null, "m": 2}`,
+}
+
+func aggItems(items []string) value.Values {
+	rv := make(value.Values, len(items))
+	for i, item := range items {
+		rv[i] = value.NewValue([]byte(item))
+	}
+	return rv
+}
+
+func newOrderedAgg(agg Aggregate, order string, withinGroup bool) Aggregate {
+	agg.SetOrder(SortTerms{NewSortTerm(expression.NewIdentifier(order), false, false)}, withinGroup)
+	return agg
+}
+
+func cumulateAgg(t *testing.T, agg Aggregate, items value.Values) value.Value {
+	cumulative, err := agg.Default(nil, nil)
+	if err != nil {
+		t.Fatalf("%v: failed to get the default: %v", agg, err)
+	}
+	for _, item := range items {
+		if cumulative, err = agg.CumulateInitial(item, cumulative, nil); err != nil {
+			t.Fatalf("%v: failed to cumulate %v: %v", agg, item, err)
+		}
+	}
+	return cumulative
+}
+
+func computeAgg(t *testing.T, agg Aggregate, cumulative value.Value) value.Value {
+	rv, err := agg.ComputeFinal(cumulative, nil)
+	if err != nil {
+		t.Fatalf("%v: failed to compute the final value: %v", agg, err)
+	}
+	return rv
+}
+
+func checkAgg(t *testing.T, what string, agg Aggregate, actual value.Value, expected interface{}) {
+	ev := value.NewValue(expected)
+	if ev.Type() == value.NUMBER && actual.Type() == value.NUMBER {
+		if math.Abs(ev.(value.NumberValue).Float64()-actual.(value.NumberValue).Float64()) < 1.0e-9 {
+			return
+		}
+	} else if ev.Equals(actual).Truth() || (ev.Type() == value.NULL && actual.Type() == value.NULL) {
+		return
+	}
+	t.Errorf("%v %v: expected %v, got %v", agg, what, expected, actual)
+}
+
+func TestNewAggregates(t *testing.T) {
+	y := expression.NewIdentifier("y")
+	x := expression.NewIdentifier("x")
+	b := expression.NewIdentifier("b")
+	pair := expression.Expressions{y, x}
+	fraction := expression.Expressions{expression.NewConstant(0.25)}
+
+	for _, c := range []struct {
+		agg         Aggregate
+		final       interface{}
+		empty       interface{}
+		incremental bool
+	}{
+		{NewRegrCount(pair, 0, nil, nil), 3, 0, true},
+		{NewRegrAvgx(pair, 0, nil, nil), 2.0, nil, true},
+		{NewRegrAvgy(pair, 0, nil, nil), 7.0 / 3.0, nil, true},
+		{NewRegrSxx(pair, 0, nil, nil), 2.0, nil, true},
+		{NewRegrSyy(pair, 0, nil, nil), 14.0 / 3.0, nil, true},
+		{NewRegrSxy(pair, 0, nil, nil), 3.0, nil, true},
+		{NewRegrSlope(pair, 0, nil, nil), 1.5, nil, true},
+		{NewRegrIntercept(pair, 0, nil, nil), -2.0 / 3.0, nil, true},
+		{NewRegrR2(pair, 0, nil, nil), 27.0 / 28.0, nil, true},
+		{NewCorr(pair, 0, nil, nil), 3.0 / math.Sqrt(28.0/3.0), nil, true},
+		{NewCovarPop(pair, 0, nil, nil), 1.0, nil, true},
+		{NewCovarSamp(pair, 0, nil, nil), 1.5, nil, true},
+		{NewBoolAnd(expression.Expressions{b}, 0, nil, nil), false, nil, true},
+		{NewBoolOr(expression.Expressions{b}, 0, nil, nil), true, nil, true},
+		{newOrderedAgg(NewStringAgg(expression.Expressions{expression.NewIdentifier("s"),
+			expression.NewConstant(",")}, 0, nil, nil), "s", false), "a,b,c", nil, false},
+		{NewMode(expression.Expressions{expression.NewIdentifier("m")}, 0, nil, nil), 2, nil, false},
+		{newOrderedAgg(NewMode(nil, 0, nil, nil), "m", true), 2, nil, false},
+		{newOrderedAgg(NewPercentileCont(fraction, 0, nil, nil), "y", true), 1.5, nil, false},
+		{newOrderedAgg(NewPercentileDisc(fraction, 0, nil, nil), "y", true), 1, nil, false},
+	} {
+		items := aggItems(_AGG_ITEMS)
+
+		// no input
+		checkAgg(t, "empty", c.agg, computeAgg(t, c.agg, cumulateAgg(t, c.agg, nil)), c.empty)
+		if c.incremental {
+			checkAgg(t, "skipped", c.agg, computeAgg(t, c.agg, cumulateAgg(t, c.agg, items[3:])), c.empty)
+		}
+
+		checkAgg(t, "final", c.agg, computeAgg(t, c.agg, cumulateAgg(t, c.agg, items)), c.final)
+
+		// partial aggregates in any order
+		for i := 0; i <= len(items); i++ {
+			part := cumulateAgg(t, c.agg, items[:i])
+			cumulative := cumulateAgg(t, c.agg, items[i:])
+			cumulative, err := c.agg.CumulateIntermediate(part, cumulative, nil)
+			if err != nil {
+				t.Fatalf("%v: failed to cumulate the intermediate values: %v", c.agg, err)
+			}
+			checkAgg(t, "intermediate", c.agg, computeAgg(t, c.agg, cumulative), c.final)
+		}
+
+		if !c.incremental {
+			continue
+		}
+
+		// removing the items brings back the aggregate of the rest
+		cumulative := cumulateAgg(t, c.agg, items)
+		for i, item := range items {
+			var err error
+			if cumulative, err = c.agg.CumulateRemove(item, cumulative, nil); err != nil {
+				t.Fatalf("%v: failed to remove %v: %v", c.agg, item, err)
+			}
+			expected := computeAgg(t, c.agg, cumulateAgg(t, c.agg, items[i+1:]))
+			checkAgg(t, "remove", c.agg, computeAgg(t, c.agg, cumulative), expected.Actual())
+		}
+		checkAgg(t, "removed", c.agg, computeAgg(t, c.agg, cumulative), c.empty)
+	}
+}
+
+// distinct values of window aggregates are counted, so they only leave with their last duplicate
+func TestDistinctValues(t *testing.T) {
+	cumulative := value.Value(value.NULL_VALUE)
+	for _, v := range []interface{}{1, 1, 2.5, "a", 2.5} {
+		cumulative = distinctAdd(value.NewValue(v), cumulative, false)
+	}
+
+	check := func(what string, count, sum interface{}) {
+		c, err := distinctCount(cumulative)
+		if err != nil {
+			t.Fatalf("%v: failed to count: %v", what, err)
+		}
+		s, err := distinctSum(cumulative)
+		if err != nil {
+			t.Fatalf("%v: failed to sum: %v", what, err)
+		}
+		if !c.EquivalentTo(value.NewValue(count)) || !s.EquivalentTo(value.NewValue(sum)) {
+			t.Errorf("%v: expected a count of %v and a sum of %v, got %v and %v", what, count, sum, c, s)
+		}
+	}
+	check("added", 3, 3.5)
+
+	for _, c := range []struct {
+		remove interface{}
+		count  interface{}
+		sum    interface{}
+	}{
+		{1, 3, 3.5},
+		{1, 2, 2.5},
+		{2.5, 2, 2.5},
+		{"a", 1, 2.5},
+		{2.5, 0, nil},
+	} {
+		var err error
+		if cumulative, err = distinctRemove("count", value.NewValue(c.remove), cumulative); err != nil {
+			t.Fatalf("Failed to remove %v: %v", c.remove, err)
+		}
+		check(fmt.Sprintf("removed %v", c.remove), c.count, c.sum)
+	}
+
+	if _, err := distinctRemove("count", value.NewValue(1), cumulative); err == nil {
+		t.Errorf("Expected removing a value that is not there to fail")
+	}
+	if _, err := distinctRemove("count", value.NewValue(1), value.ZERO_VALUE); err == nil {
+		t.Errorf("Expected removing from a value without distinct values to fail")
+	}
+}
+
+// cumulates the items in two partial aggregates, merged as in the intermediate phase
+func splitAgg(t *testing.T, agg Aggregate, items value.Values, split int) value.Value {
+	part := cumulateAgg(t, agg, items[:split])
+	cumulative, err := agg.CumulateIntermediate(part, cumulateAgg(t, agg, items[split:]), nil)
+	if err != nil {
+		t.Fatalf("%v: failed to cumulate the intermediate values: %v", agg, err)
+	}
+	return cumulative
+}
+
+func checkEstimate(t *testing.T, what string, agg Aggregate, actual value.Value, expected, bound float64) {
+	n, ok := actual.Actual().(float64)
+	if !ok {
+		if i, ok := actual.Actual().(int64); ok {
+			n = float64(i)
+		} else {
+			t.Errorf("%v %v: expected a number, got %v", agg, what, actual)
+			return
+		}
+	}
+	if math.Abs(n-expected) > bound {
+		t.Errorf("%v %v: expected %v within %v, got %v", agg, what, expected, bound, n)
+	}
+}
+
+// the sketches of partial aggregates merge into estimates within their error bounds
+func TestApproxAggregates(t *testing.T) {
+	const n = 20000
+
+	// 5000 distinct values, numbers and strings, in both halves of the input, and n different numbers
+	items := make(value.Values, 0, n+2)
+	for i := 0; i < n; i++ {
+		var v interface{} = i % 2500
+		if i%5000 >= 2500 {
+			v = fmt.Sprintf("s%d", i%2500)
+		}
+		items = append(items, value.NewValue(map[string]interface{}{"v": v, "p": i}))
+	}
+	items = append(items, value.NewValue(map[string]interface{}{"v": nil, "p": "a"}),
+		value.NewValue(map[string]interface{}{}))
+
+	v := expression.NewIdentifier("v")
+	p := expression.NewIdentifier("p")
+	countDistinct := NewApproxCountDistinct(expression.Expressions{v}, 0, nil, nil)
+	median := NewApproxPercentile(expression.Expressions{p, expression.NewConstant(0.5)}, 0, nil, nil)
+	deciles := NewApproxPercentile(expression.Expressions{p,
+		expression.NewConstant([]interface{}{0.1, 0.9})}, 0, nil, nil)
+
+	checkAgg(t, "empty", countDistinct, computeAgg(t, countDistinct, cumulateAgg(t, countDistinct, nil)), 0)
+	checkAgg(t, "empty", median, computeAgg(t, median, cumulateAgg(t, median, nil)), nil)
+
+	// standard error of 1.04 / sqrt(2^precision) for count distinct, rank error of 1.65 / k for percentiles
+	countBound := 3 * 1.04 / math.Sqrt(float64(int(1)<<sketch.HLL_DEFAULT_PRECISION)) * 5000
+	rankBound := 2 * 1.65 / sketch.KLL_DEFAULT_K * n
+	for _, split := range []int{0, n / 3, n / 2, n} {
+		what := fmt.Sprintf("split at %d", split)
+		checkEstimate(t, what, countDistinct, computeAgg(t, countDistinct, splitAgg(t, countDistinct, items, split)),
+			5000, countBound)
+		checkEstimate(t, what, median, computeAgg(t, median, splitAgg(t, median, items, split)), n/2, rankBound)
+
+		rv := computeAgg(t, deciles, splitAgg(t, deciles, items, split))
+		estimates, ok := rv.Actual().([]interface{})
+		if !ok || len(estimates) != 2 {
+			t.Errorf("%v %v: expected 2 estimates, got %v", deciles, what, rv)
+			continue
+		}
+		checkEstimate(t, what, deciles, value.NewValue(estimates[0]), n/10, rankBound)
+		checkEstimate(t, what, deciles, value.NewValue(estimates[1]), n*9/10, rankBound)
+	}
+
+	// sketches of the halves, stored and merged later
+	hll := NewSketchHll(expression.Expressions{v}, 0, nil, nil)
+	kll := NewSketchKll(expression.Expressions{p}, 0, nil, nil)
+	merge := NewSketchMerge(expression.Expressions{expression.NewIdentifier("s")}, 0, nil, nil)
+	for _, c := range []struct {
+		agg      Aggregate
+		estimate func(expression.Expression) expression.Expression
+		expected float64
+		bound    float64
+	}{
+		{hll, func(e expression.Expression) expression.Expression { return expression.NewSketchCountDistinct(e) },
+			5000, countBound},
+		{kll, func(e expression.Expression) expression.Expression {
+			return expression.NewSketchPercentile(e, expression.NewConstant(0.5))
+		}, n / 2, rankBound},
+	} {
+		sketches := value.Values{
+			value.NewValue(map[string]interface{}{"s": computeAgg(t, c.agg, cumulateAgg(t, c.agg, items[:n/2]))}),
+			value.NewValue(map[string]interface{}{"s": computeAgg(t, c.agg, cumulateAgg(t, c.agg, items[n/2:]))}),
+		}
+		merged := computeAgg(t, merge, splitAgg(t, merge, sketches, 1))
+		rv, err := c.estimate(expression.NewConstant(merged)).Evaluate(nil, nil)
+		if err != nil {
+			t.Fatalf("%v: failed to estimate from the merged sketch: %v", c.agg, err)
+		}
+		checkEstimate(t, "merged", c.agg, rv, c.expected, c.bound)
+	}
+}
@@ -11,9 +11,12 @@ package algebra
 
 import (
 	"fmt"
+	"math"
 	"sort"
+	"strings"
 
 	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util/sketch"
 	"github.com/couchbase/query/value"
 )
 
@@ -112,6 +115,123 @@ func getSet(item value.Value) (*value.Set, error) {
 	}
 }
 
+/*
+Distinct values of incremental window aggregates. Rows enter and leave
+the window frame, so the set keeps the number of rows of each distinct
+value, and the value leaves the set with its last row. The sum of the
+numeric distinct values is kept as they enter and leave the set.
+*/
+type distinctValues struct {
+	set *value.Set
+	sum value.NumberValue
+}
+
+/*
+Add input item to the cumulative distinct values. If they have not
+been initialized yet, create a new set with capacity _OBJECT_CAP.
+*/
+func distinctAdd(item, cumulative value.Value, numeric bool) value.AnnotatedValue {
+	av, ok := cumulative.(value.AnnotatedValue)
+	if !ok {
+		av = value.NewAnnotatedValue(cumulative)
+	}
+
+	dv, e := getDistinctValues(av)
+	if e != nil {
+		dv = &distinctValues{set: value.NewSet(_OBJECT_CAP, true, numeric), sum: value.ZERO_NUMBER}
+		av.SetAttachment("distinct", dv)
+	}
+
+	count := int64(0)
+	if c, ok := dv.set.Get(item); ok {
+		count = value.AsNumberValue(c).Int64()
+	} else if item.Type() == value.NUMBER {
+		dv.sum = dv.sum.Add(value.AsNumberValue(item))
+	}
+
+	dv.set.Put(item, value.NewValue(count+1))
+	return av
+}
+
+/*
+Remove input item from the cumulative distinct values. The item must
+have been added earlier.
+*/
+func distinctRemove(name string, item, cumulative value.Value) (value.Value, error) {
+	dv, e := getDistinctValues(cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	c, ok := dv.set.Get(item)
+	if !ok {
+		return nil, fmt.Errorf("Invalid %v.CumulateRemove() for DISTINCT value %v.", name, item.Actual())
+	}
+
+	count := value.AsNumberValue(c).Int64() - 1
+	if count > 0 {
+		dv.set.Put(item, value.NewValue(count))
+		return cumulative, nil
+	}
+
+	dv.set.Remove(item)
+	if dv.set.Len() == 0 {
+		// start over, no rounding errors left behind
+		dv.sum = value.ZERO_NUMBER
+	} else if item.Type() == value.NUMBER {
+		dv.sum = dv.sum.Sub(value.AsNumberValue(item))
+	}
+
+	return cumulative, nil
+}
+
+/*
+Count of the distinct values of incremental window aggregates.
+*/
+func distinctCount(cumulative value.Value) (value.Value, error) {
+	dv, e := getDistinctValues(cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	return value.NewValue(dv.set.Len()), nil
+}
+
+/*
+Sum of the distinct values of incremental window aggregates. NULL when
+there are none.
+*/
+func distinctSum(cumulative value.Value) (value.Value, error) {
+	dv, e := getDistinctValues(cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	if dv.set.Len() == 0 {
+		return value.NULL_VALUE, nil
+	}
+
+	return dv.sum, nil
+}
+
+/*
+Retrieve the distinct values of incremental window aggregates.
+*/
+func getDistinctValues(item value.Value) (*distinctValues, error) {
+	switch item := item.(type) {
+	case value.AnnotatedValue:
+		dv := item.GetAttachment("distinct")
+		switch dv := dv.(type) {
+		case *distinctValues:
+			return dv, nil
+		default:
+			return nil, fmt.Errorf("Invalid DISTINCT values %v of type %T.", dv, dv)
+		}
+	default:
+		return nil, fmt.Errorf("Invalid DISTINCT %v of type %T.", item, item)
+	}
+}
+
 /*
 Add input item to the cumulative list. Get the list. If
 no errors encountered, add the item to the list and return
@@ -480,3 +600,411 @@ func compute_nth_value(item, cumpart value.Value, expr expression.Expression, ni
 	}
 	return nil
 }
+
+/*
+Aggregate intermediate lists, where either value may be a NULL when
+no input data was received.
+*/
+func cumulateListParts(part, cumulative value.Value) (value.Value, error) {
+	if part == value.NULL_VALUE {
+		return cumulative, nil
+	} else if cumulative == value.NULL_VALUE {
+		return part, nil
+	}
+	return cumulateLists(part, cumulative)
+}
+
+/*
+Copy of the values in the cumulative list, for the final computation
+to sort them without changing the cumulative value.
+*/
+func copyListValues(cumulative value.Value) (value.Values, error) {
+	list, e := getList(cumulative)
+	if e != nil {
+		return nil, e
+	}
+
+	vals := make(value.Values, list.Len())
+	copy(vals, list.Values())
+	return vals, nil
+}
+
+/*
+Compare the sort keys of two aggregated values as ORDER BY of the
+aggregate does. Returns a negative number, zero or a positive number
+when a sorts before, together with or after b.
+*/
+func collateSortKeys(order SortTerms, a, b value.Values) int {
+	for i, term := range order {
+		var c int
+		if !term.NullsPos() || ((a[i].Type() <= value.NULL) == (b[i].Type() <= value.NULL)) {
+			c = a[i].Collate(b[i])
+		} else if a[i].Type() <= value.NULL {
+			c = 1
+		} else {
+			c = -1
+		}
+
+		if term.Descending() {
+			c = -c
+		}
+
+		if c != 0 {
+			return c
+		}
+	}
+
+	return 0
+}
+
+/*
+Sort the values in the direction of the only ORDER BY term, or in
+ascending order if there is none.
+*/
+func sortValues(vals value.Values, order SortTerms) {
+	descending := len(order) > 0 && order[0].Descending()
+	sort.SliceStable(vals, func(i, j int) bool {
+		c := vals[i].Collate(vals[j])
+		if descending {
+			return c > 0
+		}
+		return c < 0
+	})
+}
+
+/*
+Sums of the pairs of numbers aggregated by the CORR(), COVAR_*() and
+REGR_*() functions, from which they are computed.
+*/
+var _REGR_SUMS = []string{"count", "sumx", "sumy", "sumxx", "sumyy", "sumxy"}
+
+type regrSums struct {
+	count float64
+	sumx  float64
+	sumy  float64
+	sumxx float64
+	sumyy float64
+	sumxy float64
+}
+
+/*
+Evaluate the dependent and independent variables of the aggregate for
+the item, and return their sums, or a NULL if either is not a number.
+*/
+func evaluateRegrPart(operands expression.Expressions, item value.Value, context Context) (value.Value, error) {
+	y, e := operands[0].Evaluate(item, context)
+	if e != nil || y.Type() != value.NUMBER {
+		return value.NULL_VALUE, e
+	}
+
+	x, e := operands[1].Evaluate(item, context)
+	if e != nil || x.Type() != value.NUMBER {
+		return value.NULL_VALUE, e
+	}
+
+	yf := y.(value.NumberValue).Float64()
+	xf := x.(value.NumberValue).Float64()
+	return value.NewValue(map[string]interface{}{
+		"count": 1.0,
+		"sumx":  xf,
+		"sumy":  yf,
+		"sumxx": xf * xf,
+		"sumyy": yf * yf,
+		"sumxy": xf * yf,
+	}), nil
+}
+
+/*
+Add the partial sums to the cumulative sums, or subtract them when
+removing an item for incremental aggregation. A cumulative value that
+is not an object has no input data.
+*/
+func cumulateRegrSums(name string, part, cumulative value.Value, remove bool) (value.Value, error) {
+	if part.Type() != value.OBJECT {
+		return cumulative, nil
+	} else if cumulative.Type() != value.OBJECT {
+		if remove {
+			return nil, fmt.Errorf("Invalid %v.CumulateRemove() for %v value.", name, cumulative.Actual())
+		}
+		return part, nil
+	}
+
+	for _, f := range _REGR_SUMS {
+		pv, _ := part.Field(f)
+		cv, _ := cumulative.Field(f)
+		if pv.Type() != value.NUMBER || cv.Type() != value.NUMBER {
+			return nil, fmt.Errorf("Missing or invalid %s in %s: %v, %v.", f, name, pv.Actual(), cv.Actual())
+		}
+
+		if remove {
+			cumulative.SetField(f, value.AsNumberValue(cv).Sub(value.AsNumberValue(pv)))
+		} else {
+			cumulative.SetField(f, value.AsNumberValue(cv).Add(value.AsNumberValue(pv)))
+		}
+	}
+
+	return cumulative, nil
+}
+
+/*
+Return the sums in the cumulative value, or nil if there was no input data.
+*/
+func getRegrSums(name string, cumulative value.Value) (*regrSums, error) {
+	if cumulative.Type() != value.OBJECT {
+		return nil, nil
+	}
+
+	var sums [6]float64
+	for i, f := range _REGR_SUMS {
+		v, _ := cumulative.Field(f)
+		if v.Type() != value.NUMBER {
+			return nil, fmt.Errorf("Missing or invalid %s in %s: %v.", f, name, v.Actual())
+		}
+		sums[i] = v.(value.NumberValue).Float64()
+	}
+
+	if sums[0] <= 0.0 {
+		return nil, nil
+	}
+
+	return &regrSums{sums[0], sums[1], sums[2], sums[3], sums[4], sums[5]}, nil
+}
+
+/*
+Sums of the squares and the products of the deviations from the averages.
+Rounding can make the sums of squares slightly negative.
+*/
+func (this *regrSums) sxx() float64 {
+	return math.Max(this.sumxx-this.sumx*this.sumx/this.count, 0.0)
+}
+
+func (this *regrSums) syy() float64 {
+	return math.Max(this.sumyy-this.sumy*this.sumy/this.count, 0.0)
+}
+
+func (this *regrSums) sxy() float64 {
+	return this.sumxy - this.sumx*this.sumy/this.count
+}
+
+/*
+Compute the final value of the aggregate from the sums, NULL if there
+was no input data.
+*/
+func computeRegrFinal(name string, cumulative value.Value, final func(sums *regrSums) value.Value) (value.Value, error) {
+	sums, e := getRegrSums(name, cumulative)
+	if e != nil {
+		return nil, e
+	} else if sums == nil {
+		return value.NULL_VALUE, nil
+	}
+	return final(sums), nil
+}
+
+/*
+Count the TRUE and FALSE values aggregated by BOOL_AND() and BOOL_OR().
+*/
+func evaluateBoolPart(operands expression.Expressions, item value.Value, context Context) (value.Value, error) {
+	item, e := operands[0].Evaluate(item, context)
+	if e != nil || item.Type() != value.BOOLEAN {
+		return value.NULL_VALUE, e
+	}
+
+	if item.Truth() {
+		return value.NewValue(map[string]interface{}{"true": 1.0, "false": 0.0}), nil
+	}
+	return value.NewValue(map[string]interface{}{"true": 0.0, "false": 1.0}), nil
+}
+
+/*
+Add the partial counts to the cumulative counts, or subtract them when
+removing an item for incremental aggregation.
+*/
+func cumulateBoolCounts(name string, part, cumulative value.Value, remove bool) (value.Value, error) {
+	if part.Type() != value.OBJECT {
+		return cumulative, nil
+	} else if cumulative.Type() != value.OBJECT {
+		if remove {
+			return nil, fmt.Errorf("Invalid %v.CumulateRemove() for %v value.", name, cumulative.Actual())
+		}
+		return part, nil
+	}
+
+	for _, f := range []string{"true", "false"} {
+		pv, _ := part.Field(f)
+		cv, _ := cumulative.Field(f)
+		if pv.Type() != value.NUMBER || cv.Type() != value.NUMBER {
+			return nil, fmt.Errorf("Missing or invalid %s count in %s: %v, %v.", f, name, pv.Actual(), cv.Actual())
+		}
+
+		if remove {
+			cumulative.SetField(f, value.AsNumberValue(cv).Sub(value.AsNumberValue(pv)))
+		} else {
+			cumulative.SetField(f, value.AsNumberValue(cv).Add(value.AsNumberValue(pv)))
+		}
+	}
+
+	return cumulative, nil
+}
+
+/*
+Return the TRUE and FALSE counts in the cumulative value.
+*/
+func getBoolCounts(name string, cumulative value.Value) (trues, falses float64, err error) {
+	if cumulative.Type() != value.OBJECT {
+		return
+	}
+
+	tv, _ := cumulative.Field("true")
+	fv, _ := cumulative.Field("false")
+	if tv.Type() != value.NUMBER || fv.Type() != value.NUMBER {
+		err = fmt.Errorf("Missing or invalid counts in %s: %v, %v.", name, tv.Actual(), fv.Actual())
+		return
+	}
+
+	return tv.(value.NumberValue).Float64(), fv.(value.NumberValue).Float64(), nil
+}
+
+/*
+Retrieve the sketch of an annotated value, nil if there is none.
+*/
+func getSketch(item value.Value) sketch.Sketch {
+	if av, ok := item.(value.AnnotatedValue); ok {
+		if s, ok := av.GetAttachment("sketch").(sketch.Sketch); ok {
+			return s
+		}
+	}
+	return nil
+}
+
+/*
+Add input item to the sketch of the cumulative value, creating the
+sketch if it has not been initialized yet.
+*/
+func sketchAdd(item, cumulative value.Value, newSketch func() (sketch.Sketch, error),
+	add func(s sketch.Sketch, item value.Value)) (value.Value, error) {
+	s := getSketch(cumulative)
+	if s == nil {
+		var e error
+		s, e = newSketch()
+		if e != nil {
+			return nil, e
+		}
+
+		av := value.NewAnnotatedValue(cumulative)
+		av.SetAttachment("sketch", s)
+		cumulative = av
+	}
+
+	add(s, item)
+	return cumulative, nil
+}
+
+/*
+Merge the sketch of the intermediate result into the cumulative sketch.
+*/
+func cumulateSketches(part, cumulative value.Value) (value.Value, error) {
+	ps := getSketch(part)
+	if ps == nil {
+		return cumulative, nil
+	}
+
+	cs := getSketch(cumulative)
+	if cs == nil {
+		return part, nil
+	}
+
+	return cumulative, cs.Merge(ps)
+}
+
+/*
+Evaluate the fraction of APPROX_PERCENTILE(), a number or an array of
+numbers between 0 and 1.
+*/
+func evaluateFractions(name string, expr expression.Expression, item value.Value, context Context) (value.Value, error) {
+	fraction, e := expr.Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	fractions := []interface{}{fraction}
+	if fraction.Type() == value.ARRAY {
+		fractions = fraction.Actual().([]interface{})
+	}
+
+	for _, f := range fractions {
+		fv := value.NewValue(f)
+		if fv.Type() != value.NUMBER || fv.(value.NumberValue).Float64() < 0.0 ||
+			fv.(value.NumberValue).Float64() > 1.0 {
+			return nil, fmt.Errorf("%s() fraction must be a number or an array of numbers between 0 and 1: %v.",
+				strings.ToUpper(name), fraction.Actual())
+		}
+	}
+	return fraction, nil
+}
+
+/*
+Estimate the values at the fractions of a KLL sketch, a number or an
+array of numbers like the fraction.
+*/
+func kllQuantiles(kll *sketch.KLL, fraction value.Value) value.Value {
+	if fraction.Type() != value.ARRAY {
+		return value.NewValue(kll.Quantile(fraction.(value.NumberValue).Float64()))
+	}
+
+	fractions := fraction.Actual().([]interface{})
+	fs := make([]float64, len(fractions))
+	for i, f := range fractions {
+		fs[i] = value.NewValue(f).(value.NumberValue).Float64()
+	}
+
+	quantiles := kll.Quantiles(fs)
+	rv := make([]interface{}, len(quantiles))
+	for i, q := range quantiles {
+		rv[i] = q
+	}
+	return value.NewValue(rv)
+}
+
+/*
+Constructors of the sketches of approximate aggregates, and the
+functions adding values to them.
+*/
+func newHLLSketch(precision int) func() (sketch.Sketch, error) {
+	return func() (sketch.Sketch, error) {
+		return sketch.NewHLL(precision)
+	}
+}
+
+func newKLLSketch(k int) func() (sketch.Sketch, error) {
+	return func() (sketch.Sketch, error) {
+		return sketch.NewKLL(k)
+	}
+}
+
+func addHLLSketch(s sketch.Sketch, item value.Value) {
+	s.(*sketch.HLL).AddHash(sketch.HashValue(item))
+}
+
+func addKLLSketch(s sketch.Sketch, item value.Value) {
+	s.(*sketch.KLL).Add(item.(value.NumberValue).Float64())
+}
+
+/*
+Evaluate the optional size argument of a sketch, which must be an integer.
+*/
+func evaluateSketchSize(name string, operands expression.Expressions, item value.Value, context Context,
+	defaultSize int) (int, error) {
+	if len(operands) < 2 {
+		return defaultSize, nil
+	}
+
+	size, e := operands[1].Evaluate(item, context)
+	if e != nil {
+		return 0, e
+	}
+
+	if size.Type() != value.NUMBER || !value.IsInt(size.(value.NumberValue).Float64()) {
+		return 0, fmt.Errorf("%s() second argument must be an integer: %v.", strings.ToUpper(name), size.Actual())
+	}
+	return int(size.(value.NumberValue).Int64()), nil
+}
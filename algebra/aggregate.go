@@ -71,6 +71,21 @@ type Aggregate interface {
 
 	Filter() expression.Expression
 
+	/*
+	   Set ORDER BY of the aggregated values, in the arguments or in WITHIN GROUP.
+	*/
+	SetOrder(order SortTerms, withinGroup bool)
+
+	/*
+	   ORDER BY of the aggregated values
+	*/
+	Order() SortTerms
+
+	/*
+	   ORDER BY is in WITHIN GROUP clause
+	*/
+	WithinGroup() bool
+
 	/*
 	   Aggregate allows incremental operation.
 	*/
@@ -119,15 +134,19 @@ It inherits from expressions FunctionBase, and has
      flags          which represents the modifers/flags
                          DISTINCT, INCREMENTAL, RESPECT|IGNORE NULLS, FROM FIRST|LAST
      filter         include those objects that filter condition is true in aggregation
+     order          order of the aggregated values, ORDER BY in arguments or WITHIN GROUP
+     withinGroup    order is WITHIN GROUP clause
      windowTerm     which represents the Window information
 */
 
 type AggregateBase struct {
 	expression.FunctionBase
-	text       string
-	flags      uint32
-	filter     expression.Expression
-	windowTerm *WindowTerm
+	text        string
+	flags       uint32
+	filter      expression.Expression
+	order       SortTerms
+	withinGroup bool
+	windowTerm  *WindowTerm
 }
 
 /*
@@ -173,12 +192,25 @@ func (this *AggregateBase) SetAggregateModifiers(flags uint32, filter expression
 	this.filter = filter
 	this.windowTerm = wTerm
 
-	// Aggregate allows incremental operation set the flags
-	if !this.Distinct() && AggregateHasProperty(name, AGGREGATE_ALLOWS_INCREMENTAL) {
+	// Aggregate allows incremental operation set the flags. DISTINCT only as window aggregate
+	if AggregateHasProperty(name, AGGREGATE_ALLOWS_INCREMENTAL) && (!this.Distinct() ||
+		(wTerm != nil && AggregateHasProperty(name, AGGREGATE_ALLOWS_DISTINCT_INCREMENTAL))) {
 		this.AddFlags(AGGREGATE_INCREMENTAL)
 	}
 }
 
+/*
+Sets order of the aggregated values
+*/
+func (this *AggregateBase) SetOrder(order SortTerms, withinGroup bool) {
+	if len(order) == 0 {
+		order = nil
+		withinGroup = false
+	}
+	this.order = order
+	this.withinGroup = withinGroup
+}
+
 /*
 Helper functions
 */
@@ -190,12 +222,14 @@ func (this *AggregateBase) Flags() uint32                 { return this.flags }
 func (this *AggregateBase) MinArgs() int                  { return 1 }
 func (this *AggregateBase) MaxArgs() int                  { return 1 }
 func (this *AggregateBase) Filter() expression.Expression { return this.filter }
+func (this *AggregateBase) Order() SortTerms              { return this.order }
+func (this *AggregateBase) WithinGroup() bool             { return this.withinGroup }
 
 /*
 If Incremental aggregation is possible or not
 */
 func (this *AggregateBase) Incremental() bool {
-	return this.HasFlags(AGGREGATE_INCREMENTAL) && !this.Distinct()
+	return this.HasFlags(AGGREGATE_INCREMENTAL)
 }
 
 /*
@@ -243,6 +277,17 @@ func (this *AggregateBase) String() string {
 		}
 	}
 
+	// Handle ORDER BY in arguments and WITHIN GROUP
+	if len(this.order) > 0 {
+		if this.withinGroup {
+			buf.WriteString(") WITHIN GROUP (")
+		} else if len(this.Operands()) > 0 {
+			buf.WriteString(" ")
+		}
+		buf.WriteString("ORDER BY ")
+		buf.WriteString(this.order.String())
+	}
+
 	buf.WriteString(")")
 
 	if this.Filter() != nil {
@@ -347,6 +392,7 @@ func EqualAggregateModifiers(agg1, agg2 Aggregate) bool {
 	return agg1.Flags() == agg2.Flags() &&
 		expression.Equivalent(agg1.Filter(), agg2.Filter()) &&
 		expression.Equivalents(agg1.Operands(), agg2.Operands()) &&
+		agg1.WithinGroup() == agg2.WithinGroup() && agg1.Order().String() == agg2.Order().String() &&
 		((wTerm1 == wTerm2) || (wTerm1 != nil && wTerm2 != nil && wTerm1.String() == wTerm2.String()))
 }
 
@@ -373,6 +419,10 @@ func (this *AggregateBase) Children() expression.Expressions {
 		rv = append(rv, this.Filter())
 	}
 
+	if len(this.order) > 0 {
+		rv = append(rv, this.order.Expressions()...)
+	}
+
 	wTerm := this.WindowTerm()
 	if wTerm != nil {
 		exprs := wTerm.Expressions()
@@ -412,6 +462,13 @@ func (this *AggregateBase) MapChildren(mapper expression.Mapper) error {
 		this.filter = expr
 	}
 
+	if len(this.order) > 0 {
+		err := this.order.MapExpressions(mapper)
+		if err != nil {
+			return err
+		}
+	}
+
 	wTerm := this.WindowTerm()
 	if wTerm != nil {
 		return wTerm.MapExpressions(mapper)
@@ -452,3 +509,27 @@ func (this *AggregateBase) evaluateFilter(item value.Value, context Context) (bo
 	return val.Truth(), nil
 
 }
+
+/*
+Remove the item of DISTINCT incremental window aggregates. The item is
+filtered and evaluated as it was when added, and numeric aggregates
+only added numbers.
+*/
+func (this *AggregateBase) cumulateDistinctRemove(item, cumulative value.Value, numeric bool,
+	context Context) (value.Value, error) {
+
+	if ok, e := this.evaluateFilter(item, context); e != nil || !ok {
+		return cumulative, e
+	}
+
+	item, e := this.Operands()[0].Evaluate(item, context)
+	if e != nil {
+		return nil, e
+	}
+
+	if item.Type() <= value.NULL || (numeric && item.Type() != value.NUMBER) {
+		return cumulative, nil
+	}
+
+	return distinctRemove(this.Name(), item, cumulative)
+}
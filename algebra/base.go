@@ -31,8 +31,14 @@ func subqueryPrivileges(exprs expression.Expressions) (*auth.Privileges, errors.
 
 	privileges := auth.NewPrivileges()
 	for _, s := range subqueries {
-		sub := s.(*Subquery)
-		sp, e := sub.Select().Privileges()
+		var query *Select
+		switch sub := s.(type) {
+		case *With:
+			query = sub.Select()
+		default:
+			query = sub.(*Subquery).Select()
+		}
+		sp, e := query.Privileges()
 		if e != nil {
 			return nil, e
 		}
@@ -76,6 +76,7 @@ type SimpleFromTerm interface {
 	SetJoinHint(joinHint JoinHint)
 	PreferHash() bool
 	PreferNL() bool
+	PreferMerge() bool
 }
 
 type JoinTerm interface {
@@ -230,6 +230,13 @@ func (this *ExpressionTerm) PreferNL() bool {
 	return this.joinHint == USE_NL
 }
 
+/*
+Join hint prefers merge join
+*/
+func (this *ExpressionTerm) PreferMerge() bool {
+	return this.joinHint == USE_MERGE
+}
+
 /*
 Returns the property.
 */
@@ -176,6 +176,20 @@ func (this *AnsiJoin) Right() SimpleFromTerm {
 	return this.right
 }
 
+/*
+Set left source, when reordering joins
+*/
+func (this *AnsiJoin) SetLeft(left FromTerm) {
+	this.left = left
+}
+
+/*
+Set right source, when reordering joins
+*/
+func (this *AnsiJoin) SetRight(right SimpleFromTerm) {
+	this.right = right
+}
+
 /*
 Returns boolean value based on if it is
 an outer or inner JOIN.
@@ -171,6 +171,8 @@ func (this *KeyspaceTerm) String() (s string) {
 		s += " use hash(probe)"
 	case USE_NL:
 		s += " use nl"
+	case USE_MERGE:
+		s += " use merge"
 	}
 
 	return s
@@ -329,7 +331,7 @@ func (this *KeyspaceTerm) JoinKeys() expression.Expression {
 }
 
 /*
-Returns the join hint (USE HASH or USE NL).
+Returns the join hint (USE HASH, USE NL or USE MERGE).
 */
 func (this *KeyspaceTerm) JoinHint() JoinHint {
 	return this.joinHint
@@ -349,6 +351,13 @@ func (this *KeyspaceTerm) PreferNL() bool {
 	return this.joinHint == USE_NL
 }
 
+/*
+Join hint prefers merge join
+*/
+func (this *KeyspaceTerm) PreferMerge() bool {
+	return this.joinHint == USE_MERGE
+}
+
 /*
 Returns the property.
 */
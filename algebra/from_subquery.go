@@ -140,6 +140,13 @@ func (this *SubqueryTerm) PreferNL() bool {
 	return this.joinHint == USE_NL
 }
 
+/*
+Join hint prefers merge join
+*/
+func (this *SubqueryTerm) PreferMerge() bool {
+	return this.joinHint == USE_MERGE
+}
+
 /*
 Returns the property.
 */
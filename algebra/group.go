@@ -24,6 +24,7 @@ BY clauses. Having specifies a condition.
 */
 type Group struct {
 	by      expression.Expressions `json:by`
+	sets    [][]int                `json:"sets"`
 	letting expression.Bindings    `json:"letting"`
 	having  expression.Expression  `json:"having"`
 }
@@ -31,13 +32,15 @@ type Group struct {
 /*
 The function NewGroup returns a pointer to the Group
 struct that has its field sort terms set to the input
-argument expressions.
+argument expressions. ROLLUP, CUBE and GROUPING SETS
+terms are expanded into grouping sets, which refer to
+the group by expressions by position.
 */
 func NewGroup(by GroupTerms, letting expression.Bindings, having expression.Expression) *Group {
 	rv := &Group{
-		by:     by.Expressions(),
 		having: having,
 	}
+	rv.by, rv.sets = by.groupingSets()
 
 	var byAlias expression.Bindings
 	for _, g := range by {
@@ -109,6 +112,25 @@ func (this *Group) MapExpressions(mapper expression.Mapper) (err error) {
 	return
 }
 
+/*
+This method maps the letting and having clauses only, leaving
+the group by expressions alone.
+*/
+func (this *Group) MapGroupedExpressions(mapper expression.Mapper) (err error) {
+	if this.letting != nil {
+		err = this.letting.MapExpressions(mapper)
+		if err != nil {
+			return
+		}
+	}
+
+	if this.having != nil {
+		this.having, err = mapper.Map(this.having)
+	}
+
+	return
+}
+
 /*
    Returns all contained Expressions.
 */
@@ -136,7 +158,26 @@ func (this *Group) Expressions() expression.Expressions {
 func (this *Group) String() string {
 	s := ""
 
-	if this.by != nil {
+	if this.sets != nil {
+		s += " group by grouping sets ("
+
+		for i, set := range this.sets {
+			if i > 0 {
+				s += ", "
+			}
+
+			s += "("
+			for j, k := range set {
+				if j > 0 {
+					s += ", "
+				}
+
+				s += this.by[k].String()
+			}
+			s += ")"
+		}
+		s += ")"
+	} else if this.by != nil {
 		s += " group by "
 
 		for i, b := range this.by {
@@ -166,6 +207,15 @@ func (this *Group) By() expression.Expressions {
 	return this.by
 }
 
+/*
+Returns the grouping sets, as positions in the group by
+expressions, or nil if there are no ROLLUP, CUBE or
+GROUPING SETS terms.
+*/
+func (this *Group) GroupingSets() [][]int {
+	return this.sets
+}
+
 /*
 Returns the letting expression bindings.
 */
@@ -183,18 +233,102 @@ func (this *Group) Having() expression.Expression {
 type GroupTerms []*GroupTerm
 
 func (this GroupTerms) Expressions() expression.Expressions {
-	exprs := make(expression.Expressions, len(this))
+	exprs := make(expression.Expressions, 0, len(this))
 
-	for i, b := range this {
-		exprs[i] = b.expr
+	for _, b := range this {
+		if b.expr != nil {
+			exprs = append(exprs, b.expr)
+		}
 	}
 
 	return exprs
 }
 
+/*
+Expand the group terms into grouping sets. Each term contributes
+its own sets, and the grouping sets are the cross product of these.
+*/
+func (this GroupTerms) groupingSets() (expression.Expressions, [][]int) {
+	hasSets := false
+	for _, b := range this {
+		if b.sets != nil {
+			hasSets = true
+			break
+		}
+	}
+
+	if !hasSets {
+		return this.Expressions(), nil
+	}
+
+	keys := make(expression.Expressions, 0, len(this))
+	position := func(expr expression.Expression) int {
+		for i, k := range keys {
+			if k.EquivalentTo(expr) {
+				return i
+			}
+		}
+		keys = append(keys, expr)
+		return len(keys) - 1
+	}
+
+	sets := [][]int{[]int{}}
+	for _, b := range this {
+		termSets := b.sets
+		if termSets == nil {
+			termSets = []expression.Expressions{expression.Expressions{b.expr}}
+		}
+
+		next := make([][]int, 0, len(sets)*len(termSets))
+		for _, set := range sets {
+			for _, termSet := range termSets {
+				newSet := append(make([]int, 0, len(set)+len(termSet)), set...)
+			outer:
+				for _, expr := range termSet {
+					pos := position(expr)
+					for _, p := range newSet {
+						if p == pos {
+							continue outer
+						}
+					}
+					newSet = append(newSet, pos)
+				}
+				next = append(next, newSet)
+			}
+		}
+		sets = next
+	}
+
+	return keys, sets
+}
+
+/*
+Returns the number of grouping sets the terms expand to.
+*/
+func (this GroupTerms) GroupingSetsCount() int {
+	rv := 1
+	for _, b := range this {
+		if b.sets != nil {
+			rv *= len(b.sets)
+		}
+	}
+	return rv
+}
+
+const (
+	_GROUP_TERM_EXPR = iota
+	_GROUP_TERM_ROLLUP
+	_GROUP_TERM_CUBE
+	_GROUP_TERM_SETS
+)
+
+const MAX_GROUPING_SETS = 4096
+
 type GroupTerm struct {
-	expr expression.Expression `json:"expr"`
-	as   string                `json:"as"`
+	expr     expression.Expression    `json:"expr"`
+	as       string                   `json:"as"`
+	termType int                      `json:"term_type"`
+	sets     []expression.Expressions `json:"sets"`
 }
 
 func NewGroupTerm(expr expression.Expression, as string) *GroupTerm {
@@ -204,6 +338,50 @@ func NewGroupTerm(expr expression.Expression, as string) *GroupTerm {
 	}
 }
 
+/*
+ROLLUP(a, b, c) is GROUPING SETS ((a, b, c), (a, b), (a), ()).
+*/
+func NewRollupTerm(exprs expression.Expressions) *GroupTerm {
+	sets := make([]expression.Expressions, 0, len(exprs)+1)
+	for i := len(exprs); i >= 0; i-- {
+		sets = append(sets, exprs[:i])
+	}
+
+	return &GroupTerm{
+		termType: _GROUP_TERM_ROLLUP,
+		sets:     sets,
+	}
+}
+
+/*
+CUBE(a, b) is GROUPING SETS ((a, b), (a), (b), ()).
+*/
+func NewCubeTerm(exprs expression.Expressions) *GroupTerm {
+	n := len(exprs)
+	sets := make([]expression.Expressions, 0, 1<<uint(n))
+	for mask := (1 << uint(n)) - 1; mask >= 0; mask-- {
+		set := make(expression.Expressions, 0, n)
+		for i, expr := range exprs {
+			if mask&(1<<uint(n-1-i)) != 0 {
+				set = append(set, expr)
+			}
+		}
+		sets = append(sets, set)
+	}
+
+	return &GroupTerm{
+		termType: _GROUP_TERM_CUBE,
+		sets:     sets,
+	}
+}
+
+func NewGroupingSetsTerm(sets []expression.Expressions) *GroupTerm {
+	return &GroupTerm{
+		termType: _GROUP_TERM_SETS,
+		sets:     sets,
+	}
+}
+
 func (this *GroupTerm) MapExpression(mapper expression.Mapper) (err error) {
 	if this.expr != nil {
 		this.expr, err = mapper.Map(this.expr)
@@ -215,8 +393,24 @@ func (this *GroupTerm) MapExpression(mapper expression.Mapper) (err error) {
 func (this *GroupTerm) String() string {
 	s := ""
 
-	if this.expr != nil {
-		s = this.expr.String()
+	switch this.termType {
+	case _GROUP_TERM_ROLLUP:
+		s = "rollup(" + stringExpressions(this.sets[0]) + ")"
+	case _GROUP_TERM_CUBE:
+		s = "cube(" + stringExpressions(this.sets[0]) + ")"
+	case _GROUP_TERM_SETS:
+		s = "grouping sets ("
+		for i, set := range this.sets {
+			if i > 0 {
+				s += ", "
+			}
+			s += "(" + stringExpressions(set) + ")"
+		}
+		s += ")"
+	default:
+		if this.expr != nil {
+			s = this.expr.String()
+		}
 	}
 
 	if this.as != "" {
@@ -226,6 +420,17 @@ func (this *GroupTerm) String() string {
 	return s
 }
 
+func stringExpressions(exprs expression.Expressions) string {
+	s := ""
+	for i, expr := range exprs {
+		if i > 0 {
+			s += ", "
+		}
+		s += expr.String()
+	}
+	return s
+}
+
 func (this *GroupTerm) Expression() expression.Expression {
 	return this.expr
 }
@@ -0,0 +1,91 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+This represents the GROUPING(expr, ...) function. It returns a
+bit mask with a bit set for each operand that is aggregated over
+in the current grouping set, that is, a group by key that is not
+part of it. The first operand is the most significant bit.
+
+Group values produced for grouping sets carry the aggregated keys
+as an attachment; operands refer to group keys through covers.
+*/
+type Grouping struct {
+	expression.FunctionBase
+}
+
+func NewGrouping(operands ...expression.Expression) expression.Function {
+	rv := &Grouping{
+		*expression.NewFunctionBase("grouping", operands...),
+	}
+
+	rv.SetExpr(rv)
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *Grouping) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *Grouping) Type() value.Type { return value.NUMBER }
+
+func (this *Grouping) Evaluate(item value.Value, context expression.Context) (value.Value, error) {
+	var aggregated map[string]bool
+	if av, ok := item.(value.AnnotatedValue); ok {
+		aggregated, _ = av.GetAttachment("grouping").(map[string]bool)
+	}
+
+	rv := int64(0)
+	for _, op := range this.Operands() {
+		rv <<= 1
+		if aggregated[GroupingKeyText(op)] {
+			rv |= 1
+		}
+	}
+
+	return value.NewValue(rv), nil
+}
+
+/*
+GROUPING depends on the group, not on a document.
+*/
+func (this *Grouping) Indexable() bool {
+	return false
+}
+
+func (this *Grouping) MinArgs() int { return 1 }
+
+func (this *Grouping) MaxArgs() int { return 63 }
+
+/*
+Factory method pattern.
+*/
+func (this *Grouping) Constructor() expression.FunctionConstructor {
+	return NewGrouping
+}
+
+/*
+The text under which a group key is covered in group values.
+*/
+func GroupingKeyText(key expression.Expression) string {
+	if cover, ok := key.(*expression.Cover); ok {
+		return cover.Text()
+	}
+	return key.String()
+}
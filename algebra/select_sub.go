@@ -416,6 +416,12 @@ func stringBindings(bindings expression.Bindings) string {
 
 func withBindings(bindings expression.Bindings) string {
 	s := " WITH "
+	for _, b := range bindings {
+		if _, ok := b.Expression().(*With); ok {
+			s += "RECURSIVE "
+			break
+		}
+	}
 
 	for i, b := range bindings {
 		if i > 0 {
@@ -425,6 +431,9 @@ func withBindings(bindings expression.Bindings) string {
 		s += "`" + b.Variable() + "` AS ( "
 		s += b.Expression().String()
 		s += " ) "
+		if with, ok := b.Expression().(*With); ok {
+			s += with.ClausesString() + " "
+		}
 	}
 
 	return s
@@ -18,6 +18,7 @@ const (
 	USE_HASH_BUILD
 	USE_HASH_PROBE
 	USE_NL
+	USE_MERGE
 )
 
 var EMPTY_USE = NewUse(nil, nil, JOIN_HINT_NONE)
@@ -61,8 +62,9 @@ func (this *Use) SetJoinHint(joinHint JoinHint) {
 // Hint Errors
 
 const (
-	HASH_JOIN_EE_ONLY     = "HASH JOIN is not supported in Community Edition"
-	HASH_NEST_EE_ONLY     = "HASH NEST is not supported in Community Edition"
-	USE_NL_NOT_FOLLOWED   = "USE NL hint cannot be followed"
-	USE_HASH_NOT_FOLLOWED = "USE HASH hint cannot be followed"
+	HASH_JOIN_EE_ONLY      = "HASH JOIN is not supported in Community Edition"
+	HASH_NEST_EE_ONLY      = "HASH NEST is not supported in Community Edition"
+	USE_NL_NOT_FOLLOWED    = "USE NL hint cannot be followed"
+	USE_HASH_NOT_FOLLOWED  = "USE HASH hint cannot be followed"
+	USE_MERGE_NOT_FOLLOWED = "USE MERGE hint cannot be followed"
 )
@@ -389,6 +389,7 @@ const (
 	WINDOW_FRAME_EXCLUDE_GROUP
 	WINDOW_FRAME_EXCLUDE_TIES
 	WINDOW_FRAME_EXCLUDE_NO_OTHERS
+	WINDOW_FRAME_VALUE_INTERVAL
 )
 
 /*
@@ -574,6 +575,10 @@ func (this WindowFrameExtents) Copy() WindowFrameExtents {
  String representation of window frame extent
 */
 func (this *WindowFrameExtent) String() (s string) {
+	if this.Interval() {
+		s += " INTERVAL"
+	}
+
 	if this.HasModifier(WINDOW_FRAME_VALUE_PRECEDING) {
 		s += " " + this.ValueExpression().String() + " PRECEDING"
 	} else if this.HasModifier(WINDOW_FRAME_VALUE_FOLLOWING) {
@@ -624,3 +629,10 @@ func (this *WindowFrameExtent) Modifiers() uint32 {
 func (this *WindowFrameExtent) HasModifier(modifier uint32) bool {
 	return (this.modifiers & modifier) != 0
 }
+
+/*
+ Window frame extent value expression is an interval of date parts
+*/
+func (this *WindowFrameExtent) Interval() bool {
+	return this.HasModifier(WINDOW_FRAME_VALUE_INTERVAL)
+}
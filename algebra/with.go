@@ -0,0 +1,218 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package algebra
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+/*
+Represents a recursive common table expression, as in
+
+	WITH RECURSIVE alias AS (anchor UNION [ALL] recursive)
+		[OPTIONS {"levels": n, "documents": n}] [CYCLE expr, ... RESTRICT]
+
+It is a subquery over the whole union, so that it formalizes, checks
+privileges and prints like one, but it is never evaluated as such: the
+RecursiveWith operator evaluates the anchor once, then the recursive
+member with the alias bound to the rows produced by the previous
+iteration, until no new rows are produced.
+*/
+type With struct {
+	Subquery
+	alias     string
+	anchor    *Select
+	recursive *Select
+	unionAll  bool
+	options   expression.Expression
+	cycle     expression.Expressions
+}
+
+/*
+The function NewRecursiveWith splits a UNION or UNION ALL query
+into its anchor and recursive members. The recursive member is
+the last term of the union.
+*/
+func NewRecursiveWith(alias string, query *Select, options expression.Expression,
+	cycle expression.Expressions) (*With, error) {
+	var first, second Subresult
+	unionAll := false
+
+	switch union := query.Subresult().(type) {
+	case *Union:
+		first, second = union.First(), union.Second()
+	case *UnionAll:
+		first, second = union.First(), union.Second()
+		unionAll = true
+	default:
+		return nil, errors.NewRecursiveWithSemanticError(
+			fmt.Sprintf("%s must be a UNION or UNION ALL of an anchor and a recursive member", alias))
+	}
+
+	if query.Order() != nil || query.Offset() != nil || query.Limit() != nil {
+		return nil, errors.NewRecursiveWithSemanticError(
+			fmt.Sprintf("ORDER BY, OFFSET and LIMIT are not allowed on the UNION of %s", alias))
+	}
+
+	rv := &With{
+		alias:     alias,
+		anchor:    NewSelect(first, nil, nil, nil),
+		recursive: NewSelect(second, nil, nil, nil),
+		unionAll:  unionAll,
+		options:   options,
+		cycle:     cycle,
+	}
+	rv.query = query
+	rv.SetExpr(rv)
+
+	if query.IsCorrelated() {
+		rv.anchor.SetCorrelated()
+	}
+	rv.recursive.SetCorrelated()
+	return rv, nil
+}
+
+/*
+Visitor pattern.
+*/
+func (this *With) Accept(visitor expression.Visitor) (interface{}, error) {
+	return visitor.VisitSubquery(this)
+}
+
+/*
+Recursive common table expressions are evaluated by the
+RecursiveWith operator only.
+*/
+func (this *With) Evaluate(item value.Value, context expression.Context) (value.Value, error) {
+	return nil, errors.NewRecursiveWithSemanticError(
+		fmt.Sprintf("%s can only be used as a WITH RECURSIVE term", this.alias))
+}
+
+/*
+Return this expression.
+*/
+func (this *With) Copy() expression.Expression {
+	return this
+}
+
+/*
+The alias is visible to the recursive member as a common table
+expression, bound to the rows of the previous iteration.
+The recursive member is always correlated, so that its results
+are never cached across iterations.
+*/
+func (this *With) Formalize(parent *expression.Formalizer) error {
+	withs := parent.SaveWiths()
+	defer parent.RestoreWiths(withs)
+	parent.SetPermanentWiths(expression.Bindings{expression.NewSimpleBinding(this.alias, nil)})
+
+	err := this.query.FormalizeSubquery(parent)
+	if err != nil {
+		return err
+	}
+
+	this.anchor.correlated = this.anchor.subresult.IsCorrelated()
+	this.recursive.SetCorrelated()
+	return nil
+}
+
+/*
+Return the common table expression alias.
+*/
+func (this *With) Alias() string {
+	return this.alias
+}
+
+/*
+Return the anchor member, which is evaluated once.
+*/
+func (this *With) Anchor() *Select {
+	return this.anchor
+}
+
+/*
+Return the recursive member, which references the alias.
+*/
+func (this *With) Recursive() *Select {
+	return this.recursive
+}
+
+/*
+Returns true for UNION ALL, in which case duplicate rows
+are not removed.
+*/
+func (this *With) UnionAll() bool {
+	return this.unionAll
+}
+
+/*
+Return the OPTIONS object, if any.
+*/
+func (this *With) Options() expression.Expression {
+	return this.options
+}
+
+/*
+Return the CYCLE expressions, if any. Rows with the same values
+for these expressions as a previous row are discarded.
+*/
+func (this *With) Cycle() expression.Expressions {
+	return this.cycle
+}
+
+/*
+Return the maximum number of recursive iterations, 0 if unbounded.
+*/
+func (this *With) Levels() int64 {
+	return this.option("levels")
+}
+
+/*
+Return the maximum number of documents produced, 0 if unbounded.
+*/
+func (this *With) Documents() int64 {
+	return this.option("documents")
+}
+
+func (this *With) option(name string) int64 {
+	if this.options == nil || this.options.Value() == nil {
+		return 0
+	}
+	v, ok := this.options.Value().Field(name)
+	if !ok || v.Type() != value.NUMBER {
+		return 0
+	}
+	return value.AsNumberValue(v).Int64()
+}
+
+/*
+Representation of the OPTIONS and CYCLE clauses as a N1QL string.
+*/
+func (this *With) ClausesString() string {
+	var s string
+	if this.options != nil {
+		s += " OPTIONS " + this.options.String()
+	}
+	if len(this.cycle) > 0 {
+		s += " CYCLE "
+		for i, e := range this.cycle {
+			if i > 0 {
+				s += ", "
+			}
+			s += e.String()
+		}
+		s += " RESTRICT"
+	}
+	return s
+}
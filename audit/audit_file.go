@@ -0,0 +1,379 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/accounting"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/logging"
+)
+
+// The file auditor writes audit records as JSON lines to a local file, for
+// deployments without an audit daemon.
+// Which events are audited is determined by the event descriptors: events
+// that are enabled by default are always audited, as are events that can't
+// be filtered, and further events can be enabled explicitly.
+// Records are queued and written by a single worker through a buffer, which
+// is flushed whenever the queue drains, so that requests don't wait on disk.
+
+const _AUDIT_FILE = "audit.log"
+const _AUDIT_PREFIX = "audit."
+const _AUDIT_SUFFIX = ".log"
+const _AUDIT_TIME_FORMAT = "2006-01-02T15-04-05.000000000"
+
+const DEF_AUDIT_FILE_SIZE = 64 * 1024 * 1024
+const DEF_AUDIT_FILES = 10
+
+// how long records can sit in the buffer while the queue is busy
+const _AUDIT_FLUSH_INTERVAL = time.Second
+
+// how long shutdown waits for queued records to be written
+const _AUDIT_CLOSE_TIMEOUT = 10 * time.Second
+
+type eventDescriptor struct {
+	Id                 uint32 `json:"id"`
+	Name               string `json:"name"`
+	Description        string `json:"description"`
+	Enabled            bool   `json:"enabled"`
+	FilteringPermitted bool   `json:"filtering_permitted"`
+}
+
+type fileAuditor struct {
+	auditRecordQueue chan auditQueueEntry
+	closeRequests    chan chan bool
+	descriptors      map[uint32]*eventDescriptor
+
+	dir      string
+	fileSize int64
+	files    int
+	current  *os.File
+	writer   *bufio.Writer
+	size     int64
+
+	auditInfoLock sync.RWMutex
+	info          *datastore.AuditInfo
+}
+
+func (fa *fileAuditor) auditInfo() *datastore.AuditInfo {
+	fa.auditInfoLock.RLock()
+	ret := fa.info
+	fa.auditInfoLock.RUnlock()
+	return ret
+}
+
+func (fa *fileAuditor) setAuditInfo(info *datastore.AuditInfo) {
+	fa.auditInfoLock.Lock()
+	fa.info = info
+	fa.auditInfoLock.Unlock()
+}
+
+func (fa *fileAuditor) submit(entry auditQueueEntry) {
+	// As with the standard auditor, block if the queue is full.
+	fa.auditRecordQueue <- entry
+}
+
+// Start auditing to files in dir, rather than to the audit daemon.
+// descriptorFile is the audit event descriptor file, events the ids of the events
+// to enable in addition to the default ones, or "all".
+func StartFileAuditService(dir string, descriptorFile string, events string, fileSize int64, files int,
+	numServicers int) error {
+
+	descriptors, err := loadDescriptors(descriptorFile)
+	if err != nil {
+		return err
+	}
+	info, err := fileAuditInfo(descriptors, events)
+	if err != nil {
+		return err
+	}
+
+	dir, err = filepath.Abs(dir)
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		return err
+	}
+	if fileSize <= 0 {
+		fileSize = DEF_AUDIT_FILE_SIZE
+	}
+	if files <= 0 {
+		files = DEF_AUDIT_FILES
+	}
+
+	auditor := &fileAuditor{
+		descriptors:      descriptors,
+		dir:              dir,
+		fileSize:         fileSize,
+		files:            files,
+		info:             info,
+		auditRecordQueue: make(chan auditQueueEntry, numServicers*25),
+		closeRequests:    make(chan chan bool),
+	}
+	err = auditor.open()
+	if err != nil {
+		return err
+	}
+	go fileAuditWorker(auditor, 1)
+
+	_AUDITOR = auditor
+	logging.Infof("Auditing to %v", dir)
+	return nil
+}
+
+// Write out the records queued so far and close the audit file, before the
+// service exits. Records submitted afterwards reopen the file.
+func CloseAuditService() {
+	auditor, ok := _AUDITOR.(*fileAuditor)
+	if !ok {
+		return
+	}
+	done := make(chan bool, 1)
+	timer := time.NewTimer(_AUDIT_CLOSE_TIMEOUT)
+	defer timer.Stop()
+	select {
+	case auditor.closeRequests <- done:
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+		}
+	case <-timer.C:
+	}
+	logging.Errorf("Timed out writing audit records")
+}
+
+func loadDescriptors(descriptorFile string) (map[uint32]*eventDescriptor, error) {
+	bytes, err := ioutil.ReadFile(descriptorFile)
+	if err != nil {
+		return nil, err
+	}
+	var module struct {
+		Events []*eventDescriptor `json:"events"`
+	}
+	err = json.Unmarshal(bytes, &module)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid audit descriptor file %v: %v", descriptorFile, err)
+	}
+	descriptors := make(map[uint32]*eventDescriptor, len(module.Events))
+	for _, d := range module.Events {
+		descriptors[d.Id] = d
+	}
+	return descriptors, nil
+}
+
+func fileAuditInfo(descriptors map[uint32]*eventDescriptor, events string) (*datastore.AuditInfo, error) {
+	enabled := make(map[uint32]bool, len(descriptors))
+	if events == "all" {
+		for id, _ := range descriptors {
+			enabled[id] = true
+		}
+	} else if events != "" {
+		for _, e := range strings.Split(events, ",") {
+			id, err := strconv.ParseUint(strings.TrimSpace(e), 10, 32)
+			if err != nil || descriptors[uint32(id)] == nil {
+				return nil, fmt.Errorf("Invalid audit event %v", e)
+			}
+			enabled[uint32(id)] = true
+		}
+	}
+
+	info := &datastore.AuditInfo{
+		AuditEnabled:    true,
+		EventDisabled:   make(map[uint32]bool, len(descriptors)),
+		UserWhitelisted: make(map[datastore.UserInfo]bool),
+	}
+	for id, d := range descriptors {
+		info.EventDisabled[id] = !d.Enabled && d.FilteringPermitted && !enabled[id]
+	}
+	return info, nil
+}
+
+func fileAuditWorker(auditor *fileAuditor, num int) {
+	// If this audit worker panics, start up a replacement.
+	defer func() {
+		r := recover()
+		if r != nil {
+			logging.Errorf("File audit worker %d: Panic: %v. Starting a replacement.", num, r)
+			go fileAuditWorker(auditor, num+1)
+		}
+	}()
+	logging.Infof("Starting file audit worker %d", num)
+
+	ticker := time.NewTicker(_AUDIT_FLUSH_INTERVAL)
+	defer ticker.Stop()
+	for {
+		select {
+		case entry := <-auditor.auditRecordQueue:
+			auditor.record(entry, num)
+
+			// nothing else to write for now
+			if len(auditor.auditRecordQueue) == 0 {
+				auditor.flush()
+			}
+		case <-ticker.C:
+			auditor.flush()
+		case done := <-auditor.closeRequests:
+			auditor.drain(num)
+			auditor.close()
+			done <- true
+		}
+	}
+}
+
+func (fa *fileAuditor) record(entry auditQueueEntry, num int) {
+	accounting.UpdateCounter(accounting.AUDIT_ACTIONS)
+	err := fa.write(entry)
+	if err != nil {
+		accounting.UpdateCounter(accounting.AUDIT_ACTIONS_FAILED)
+		logging.Errorf("File audit worker %d: unable to write audit record: %v", num, err)
+	}
+}
+
+// write whatever is in the queue, without waiting for more
+func (fa *fileAuditor) drain(num int) {
+	for {
+		select {
+		case entry := <-fa.auditRecordQueue:
+			fa.record(entry, num)
+		default:
+			return
+		}
+	}
+}
+
+// records are written as the audit daemon does, with the event id, name and description first
+func (fa *fileAuditor) write(entry auditQueueEntry) error {
+	var record []byte
+	var err error
+
+	if entry.isQueryType {
+		record, err = json.Marshal(entry.queryAuditRecord)
+	} else {
+		record, err = json.Marshal(entry.apiAuditRecord)
+	}
+	if err != nil {
+		return err
+	}
+	header := map[string]interface{}{"id": entry.eventId}
+	if d := fa.descriptors[entry.eventId]; d != nil {
+		header["name"] = d.Name
+		header["description"] = d.Description
+	}
+	line, err := json.Marshal(header)
+	if err != nil {
+		return err
+	}
+	if len(record) > 2 {
+		line = append(line[:len(line)-1], ',')
+		line = append(line, record[1:]...)
+	}
+	line = append(line, '\n')
+
+	if fa.writer == nil {
+		err = fa.open()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := fa.writer.Write(line)
+	fa.size += int64(n)
+	if err != nil {
+		return err
+	}
+	if fa.size >= fa.fileSize {
+		return fa.rotate()
+	}
+	return nil
+}
+
+func (fa *fileAuditor) flush() {
+	if fa.writer != nil {
+		err := fa.writer.Flush()
+		if err != nil {
+			logging.Errorf("Unable to write audit records: %v", err)
+		}
+	}
+}
+
+func (fa *fileAuditor) close() {
+	if fa.writer == nil {
+		return
+	}
+	err := fa.writer.Flush()
+	if err == nil {
+		err = fa.current.Sync()
+	}
+	if err != nil {
+		logging.Errorf("Unable to write audit records: %v", err)
+	}
+	fa.current.Close()
+	fa.current = nil
+	fa.writer = nil
+	fa.size = 0
+}
+
+func (fa *fileAuditor) open() error {
+	f, err := os.OpenFile(filepath.Join(fa.dir, _AUDIT_FILE), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	fa.current = f
+	fa.writer = bufio.NewWriter(f)
+	fa.size = info.Size()
+	return nil
+}
+
+// move the current file aside, and remove the oldest files in excess of the retention limit
+func (fa *fileAuditor) rotate() error {
+	err := fa.writer.Flush()
+	fa.current.Close()
+	fa.current = nil
+	fa.writer = nil
+	fa.size = 0
+	if err != nil {
+		return err
+	}
+
+	rotated := _AUDIT_PREFIX + time.Now().Format(_AUDIT_TIME_FORMAT) + _AUDIT_SUFFIX
+	err = os.Rename(filepath.Join(fa.dir, _AUDIT_FILE), filepath.Join(fa.dir, rotated))
+	if err != nil {
+		return err
+	}
+
+	// the timestamps sort in time order
+	names, err := filepath.Glob(filepath.Join(fa.dir, _AUDIT_PREFIX+"*"+_AUDIT_SUFFIX))
+	if err != nil {
+		return err
+	}
+	sort.Strings(names)
+	for len(names) > fa.files {
+		os.Remove(names[0])
+		names = names[1:]
+	}
+	return fa.open()
+}
@@ -1,6 +1,11 @@
 package audit
 
 import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 	"time"
 
@@ -355,3 +360,85 @@ func TestWhitelistedUsers(t *testing.T) {
 		}
 	}
 }
+
+func TestFileAuditor(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	// SELECT is not enabled by default, INSERT is not enabled at all
+	err = StartFileAuditService(dir, "../etc/audit_descriptor.json", "28672", 1024, 2, 1)
+	if err != nil {
+		t.Fatalf("Unable to start file auditor: %v", err)
+	}
+	defer func() { _AUDITOR = nil }()
+
+	auditable := &simpleAuditable{eventType: "SELECT", statement: "SELECT 1"}
+	for i := 0; i < 20; i++ {
+		Submit(auditable)
+	}
+	auditable = &simpleAuditable{eventType: "INSERT", statement: "INSERT INTO b VALUES (\"k\", 1)"}
+	Submit(auditable)
+
+	// the queued records are written out on close
+	CloseAuditService()
+
+	names, _ := filepath.Glob(filepath.Join(dir, "audit*.log"))
+	if len(names) != 3 {
+		t.Fatalf("Expected the current and 2 rotated files, found %v", names)
+	}
+
+	count := 0
+	for _, name := range names {
+		f, err := os.Open(name)
+		if err != nil {
+			t.Fatalf("Unable to open %v: %v", name, err)
+		}
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			var record map[string]interface{}
+			err = json.Unmarshal(scanner.Bytes(), &record)
+			if err != nil {
+				t.Fatalf("Invalid record %s: %v", scanner.Text(), err)
+			}
+			if record["id"] != float64(28672) || record["name"] != "SELECT statement" || record["statement"] != "SELECT 1" {
+				t.Fatalf("Unexpected record %v", record)
+			}
+			count++
+		}
+		f.Close()
+	}
+	if count == 0 || count >= 20 {
+		t.Fatalf("Expected some records to be rotated out, found %v", count)
+	}
+
+	// the file is reopened for records submitted after close
+	Submit(&simpleAuditable{eventType: "SELECT", statement: "SELECT 1"})
+	CloseAuditService()
+	if info, err := os.Stat(filepath.Join(dir, _AUDIT_FILE)); err != nil || info.Size() == 0 {
+		t.Fatalf("Expected a record to be written after close: %v", err)
+	}
+}
+
+func TestFileAuditorErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "audit")
+	if err != nil {
+		t.Fatalf("Unable to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	if err = StartFileAuditService(dir, filepath.Join(dir, "missing.json"), "", 1024, 2, 1); err == nil {
+		t.Errorf("Expected a missing descriptor file to fail")
+	}
+	if err = StartFileAuditService(dir, "../etc/audit_descriptor.json", "none", 1024, 2, 1); err == nil {
+		t.Errorf("Expected invalid event ids to fail")
+	}
+	if _AUDITOR != nil {
+		t.Errorf("Expected the auditor not to be started")
+	}
+
+	// nothing to close
+	CloseAuditService()
+}
@@ -24,9 +24,11 @@ import (
 	"path/filepath"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/couchbase/query/auth"
 	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/statistics"
 	"github.com/couchbase/query/datastore/virtual"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/expression"
@@ -119,7 +121,7 @@ func (s *store) Inferencers() ([]datastore.Inferencer, errors.Error) {
 }
 
 func (s *store) StatUpdater() (datastore.StatUpdater, errors.Error) {
-	return nil, errors.NewOtherNotImplementedError(nil, "UPDATE STATISTICS")
+	return statistics.NewStatUpdater(s), nil
 }
 
 func (s *store) SetConnectionSecurityConfig(conSecConfig *datastore.ConnectionSecurityConfig) {
@@ -165,36 +167,44 @@ func (s *store) GetRolesAll() ([]datastore.Role, errors.Error) {
 	}, nil
 }
 
-func (s *store) CreateSystemCBOStats(requestId string) errors.Error {
-	return nil
-}
-
-func (s *store) GetSystemCBOStats() (datastore.Keyspace, errors.Error) {
-	return nil, nil
-}
-
-func (s *store) HasSystemCBOStats() (bool, errors.Error) {
-	return false, nil
-}
+// optimizer statistics are kept in a keyspace of the default namespace,
+// which is not listed amongst the namespace's keyspaces
+const _SYSTEM_CBO_STATS = "N1QL_CBO_STATS"
 
-func (s *store) StartTransaction(stmtAtomicity bool, context datastore.QueryContext) (map[string]bool, errors.Error) {
-	return nil, errors.NewTranDatastoreNotSupportedError("file")
-}
-
-func (s *store) CommitTransaction(stmtAtomicity bool, context datastore.QueryContext) errors.Error {
-	return errors.NewTranDatastoreNotSupportedError("file")
+func (s *store) systemNamespace() (*namespace, errors.Error) {
+	if p, ok := s.namespaces["DEFAULT"]; ok {
+		return p, nil
+	}
+	if len(s.namespaceNames) > 0 {
+		return s.namespaces[strings.ToUpper(s.namespaceNames[0])], nil
+	}
+	return nil, errors.NewFileNamespaceNotFoundError(nil, "default")
 }
 
-func (s *store) RollbackTransaction(stmtAtomicity bool, context datastore.QueryContext, sname string) errors.Error {
-	return errors.NewTranDatastoreNotSupportedError("file")
+func (s *store) CreateSystemCBOStats(requestId string) errors.Error {
+	p, e := s.systemNamespace()
+	if e != nil {
+		return e
+	}
+	return p.createCBOStats()
 }
 
-func (s *store) SetSavepoint(stmtAtomicity bool, context datastore.QueryContext, sname string) errors.Error {
-	return errors.NewTranDatastoreNotSupportedError("file")
+func (s *store) GetSystemCBOStats() (datastore.Keyspace, errors.Error) {
+	p, e := s.systemNamespace()
+	if e != nil {
+		return nil, e
+	}
+	p.RLock()
+	defer p.RUnlock()
+	if p.cboStats == nil {
+		return nil, nil
+	}
+	return p.cboStats, nil
 }
 
-func (s *store) TransactionDeltaKeyScan(keyspace string, conn *datastore.IndexConnection) {
-	defer conn.Sender().Close()
+func (s *store) HasSystemCBOStats() (bool, errors.Error) {
+	b, e := s.GetSystemCBOStats()
+	return b != nil, e
 }
 
 // NewStore creates a new file-based store for the given filepath.
@@ -206,6 +216,11 @@ func NewDatastore(path string) (s datastore.Datastore, e errors.Error) {
 
 	fs := &store{path: path, users: make(map[string]*datastore.User, 4)}
 
+	e = fs.recoverTransactions()
+	if e != nil {
+		return
+	}
+
 	e = fs.loadNamespaces()
 	if e != nil {
 		return
@@ -254,10 +269,14 @@ func (s *store) loadNamespaces() (e errors.Error) {
 
 // namespace represents a file-based Namespace.
 type namespace struct {
+	sync.RWMutex
 	store         *store
 	name          string
 	keyspaces     map[string]*keyspace
 	keyspaceNames []string
+	buckets       map[string]*bucket
+	bucketNames   []string
+	cboStats      *keyspace
 }
 
 func (p *namespace) DatastoreId() string {
@@ -277,16 +296,27 @@ func (p *namespace) KeyspaceIds() ([]string, errors.Error) {
 }
 
 func (p *namespace) KeyspaceNames() ([]string, errors.Error) {
+	p.RLock()
+	defer p.RUnlock()
 	return p.keyspaceNames, nil
 }
 
 func (p *namespace) Objects(preload bool) ([]datastore.Object, errors.Error) {
-	rv := make([]datastore.Object, len(p.keyspaceNames))
+	p.RLock()
+	defer p.RUnlock()
+	rv := make([]datastore.Object, len(p.keyspaceNames)+len(p.bucketNames))
 	i := 0
 	for _, k := range p.keyspaceNames {
 		rv[i] = datastore.Object{Id: k, Name: k, IsKeyspace: true}
 		i++
 	}
+
+	// a bucket is also a keyspace if it has a default collection
+	for _, n := range p.bucketNames {
+		dks, _ := p.buckets[strings.ToUpper(n)].DefaultKeyspace()
+		rv[i] = datastore.Object{Id: n, Name: n, IsKeyspace: dks != nil, IsBucket: true}
+		i++
+	}
 	return rv, nil
 }
 
@@ -295,12 +325,18 @@ func (p *namespace) KeyspaceById(id string) (b datastore.Keyspace, e errors.Erro
 }
 
 func (p *namespace) KeyspaceByName(name string) (b datastore.Keyspace, e errors.Error) {
+	p.RLock()
+	defer p.RUnlock()
 	b, ok := p.keyspaces[strings.ToUpper(name)]
-	if !ok {
-		e = errors.NewFileKeyspaceNotFoundError(nil, name)
+	if ok {
+		return
 	}
 
-	return
+	// a bucket name refers to its default collection
+	if bu, ok := p.buckets[strings.ToUpper(name)]; ok {
+		return bu.DefaultKeyspace()
+	}
+	return nil, errors.NewFileKeyspaceNotFoundError(nil, name)
 }
 
 func (p *namespace) VirtualKeyspaceByName(path []string) (datastore.Keyspace, errors.Error) {
@@ -337,16 +373,44 @@ func (p *namespace) loadKeyspaces() (e errors.Error) {
 
 	p.keyspaces = make(map[string]*keyspace, len(dirEntries))
 	p.keyspaceNames = make([]string, 0, len(dirEntries))
+	p.buckets = make(map[string]*bucket)
+	p.bucketNames = make([]string, 0)
 
 	var b *keyspace
+	var bu *bucket
 	for _, dirEntry := range dirEntries {
 		if dirEntry.IsDir() {
 			diru := strings.ToUpper(dirEntry.Name())
+			if diru == _SYSTEM_CBO_STATS {
+				p.cboStats, e = newKeyspace(p, nil, dirEntry.Name())
+				if e != nil {
+					return
+				}
+				continue
+			}
 			if _, ok := p.keyspaces[diru]; ok {
 				return errors.NewFileDuplicateKeyspaceError(nil, dirEntry.Name())
 			}
+			if _, ok := p.buckets[diru]; ok {
+				return errors.NewFileDuplicateKeyspaceError(nil, dirEntry.Name())
+			}
+
+			isBucket, er := isBucketDir(filepath.Join(p.path(), dirEntry.Name()))
+			if er != nil {
+				return errors.NewFileDatastoreError(er, "")
+			}
+			if isBucket {
+				bu, e = newBucket(p, dirEntry.Name())
+				if e != nil {
+					return
+				}
 
-			b, e = newKeyspace(p, dirEntry.Name())
+				p.buckets[diru] = bu
+				p.bucketNames = append(p.bucketNames, bu.Name())
+				continue
+			}
+
+			b, e = newKeyspace(p, nil, dirEntry.Name())
 			if e != nil {
 				return
 			}
@@ -359,27 +423,60 @@ func (p *namespace) loadKeyspaces() (e errors.Error) {
 	return
 }
 
+// create the optimizer statistics keyspace directory, if it does not exist yet
+func (p *namespace) createCBOStats() errors.Error {
+	p.Lock()
+	defer p.Unlock()
+
+	if p.cboStats != nil {
+		return nil
+	}
+
+	er := os.Mkdir(filepath.Join(p.path(), _SYSTEM_CBO_STATS), 0777)
+	if er != nil && !os.IsExist(er) {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	b, e := newKeyspace(p, nil, _SYSTEM_CBO_STATS)
+	if e != nil {
+		return e
+	}
+	p.cboStats = b
+	return nil
+}
+
 func (p *namespace) BucketIds() ([]string, errors.Error) {
-	return datastore.NO_STRINGS, nil
+	return p.BucketNames()
 }
 
 func (p *namespace) BucketNames() ([]string, errors.Error) {
-	return datastore.NO_STRINGS, nil
+	p.RLock()
+	defer p.RUnlock()
+	return p.bucketNames, nil
 }
 
-func (p *namespace) BucketById(name string) (datastore.Bucket, errors.Error) {
-	return nil, errors.NewOtherNoBuckets("file")
+func (p *namespace) BucketById(id string) (datastore.Bucket, errors.Error) {
+	return p.BucketByName(id)
 }
 
 func (p *namespace) BucketByName(name string) (datastore.Bucket, errors.Error) {
-	return nil, errors.NewOtherNoBuckets("file")
+	p.RLock()
+	defer p.RUnlock()
+	bu, ok := p.buckets[strings.ToUpper(name)]
+	if !ok {
+		return nil, errors.NewFileBucketNotFoundError(nil, p.name+":"+name)
+	}
+	return bu, nil
 }
 
 // keyspace is a file-based keyspace.
+// It is either a directory directly under a namespace, or a collection
+// within a scope.
 type keyspace struct {
 	namespace *namespace
+	scope     *scope
 	name      string
-	fi        datastore.Indexer
+	fi        *fileIndexer
 	fileLock  sync.Mutex
 }
 
@@ -404,22 +501,50 @@ func (b *keyspace) Uid() string {
 }
 
 func (b *keyspace) QualifiedName() string {
+	if b.scope != nil {
+		return b.namespace.name + ":" + b.scope.bucket.name + "." + b.scope.name + "." + b.name
+	}
 	return b.namespace.name + ":" + b.name
 }
 
 func (b *keyspace) AuthKey() string {
+	if b.scope != nil {
+
+		// the default collection is authorized as the bucket
+		if b.scope.name == _DEFAULT_NAME && b.name == _DEFAULT_NAME {
+			return b.scope.bucket.name
+		}
+		return b.scope.bucket.name + ":" + b.scope.name + ":" + b.name
+	}
 	return b.name
 }
 
 func (b *keyspace) Scope() datastore.Scope {
-	return nil
+	if b.scope == nil {
+		return nil
+	}
+	return b.scope
 }
 
 func (b *keyspace) ScopeId() string {
-	return ""
+	if b.scope == nil {
+		return ""
+	}
+	return b.scope.Id()
 }
 
+func (b *keyspace) bucketId() string {
+	if b.scope == nil {
+		return ""
+	}
+	return b.scope.BucketId()
+}
+
+// a collection shares the version of its bucket
 func (b *keyspace) MetadataVersion() uint64 {
+	if b.scope != nil {
+		return b.scope.bucket.MetadataVersion()
+	}
 	return 0
 }
 
@@ -476,7 +601,18 @@ func (b *keyspace) Fetch(keys []string, keysMap map[string]value.AnnotatedValue,
 	context datastore.QueryContext, subPaths []string) []errors.Error {
 	var errs []errors.Error
 
+	_, txn := getTransaction(context)
 	for _, k := range keys {
+		if txn != nil {
+			// the transaction's own writes take precedence
+			if item, found := txn.fetch(b, k); found {
+				if item != nil {
+					keysMap[k] = item
+				}
+				continue
+			}
+		}
+
 		item, e := b.fetchOne(k)
 
 		if e != nil {
@@ -515,6 +651,7 @@ const (
 	INSERT = 0x01
 	UPDATE = 0x02
 	UPSERT = 0x04
+	DELETE = 0x08
 )
 
 func opToString(op int) string {
@@ -526,6 +663,8 @@ func opToString(op int) string {
 		return "update"
 	case UPSERT:
 		return "upsert"
+	case DELETE:
+		return "delete"
 	}
 
 	return "unknown operation"
@@ -533,6 +672,10 @@ func opToString(op int) string {
 
 func (b *keyspace) performOp(op int, kvPairs []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
 
+	if _, txn := getTransaction(context); txn != nil {
+		return txn.performOp(b, op, kvPairs)
+	}
+
 	if len(kvPairs) == 0 {
 		return nil, errors.NewFileNoKeysInsertError(nil, "keyspace "+b.Name())
 	}
@@ -587,6 +730,7 @@ func (b *keyspace) performOp(op int, kvPairs []value.Pair, context datastore.Que
 			returnErr = errors.NewFileDMLError(returnErr, opToString(op)+" Failed "+err.Error())
 		} else {
 			insertedKeys = append(insertedKeys, kv)
+			b.fi.documentChanged(key, value)
 		}
 	}
 
@@ -607,9 +751,16 @@ func (b *keyspace) Upsert(upserts []value.Pair, context datastore.QueryContext)
 }
 
 func (b *keyspace) Delete(deletes []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	if _, txn := getTransaction(context); txn != nil {
+		return txn.performOp(b, DELETE, deletes)
+	}
 
 	var fileError []string
 	var deleted []value.Pair
+
+	b.fileLock.Lock()
+	defer b.fileLock.Unlock()
+
 	for _, pair := range deletes {
 		key := pair.Name
 		filename := filepath.Join(b.path(), key+".json")
@@ -619,6 +770,7 @@ func (b *keyspace) Delete(deletes []value.Pair, context datastore.QueryContext)
 			}
 		} else {
 			deleted = append(deleted, pair)
+			b.fi.documentDeleted(key)
 		}
 	}
 
@@ -646,17 +798,21 @@ func (b *keyspace) Flush() errors.Error {
 }
 
 func (b *keyspace) IsBucket() bool {
-	return true
+	return b.scope == nil
 }
 
 func (b *keyspace) path() string {
+	if b.scope != nil {
+		return filepath.Join(b.scope.path(), b.name)
+	}
 	return filepath.Join(b.namespace.path(), b.name)
 }
 
-// newKeyspace creates a new keyspace.
-func newKeyspace(p *namespace, dir string) (b *keyspace, e errors.Error) {
+// newKeyspace creates a new keyspace, or a new collection if the scope is not nil.
+func newKeyspace(p *namespace, s *scope, dir string) (b *keyspace, e errors.Error) {
 	b = new(keyspace)
 	b.namespace = p
+	b.scope = s
 	b.name = dir
 
 	fi, er := os.Stat(b.path())
@@ -670,17 +826,23 @@ func newKeyspace(p *namespace, dir string) (b *keyspace, e errors.Error) {
 
 	b.fi = newFileIndexer(b)
 	b.fi.CreatePrimaryIndex("", "#primary", nil)
+	e = b.fi.loadDefinitions()
+	if e != nil {
+		return nil, e
+	}
 
 	return
 }
 
 type fileIndexer struct {
+	sync.RWMutex
 	keyspace *keyspace
 	indexes  map[string]datastore.Index
 	primary  datastore.PrimaryIndex
+	version  uint64 // bumped whenever an index is created, built or dropped
 }
 
-func newFileIndexer(keyspace *keyspace) datastore.Indexer {
+func newFileIndexer(keyspace *keyspace) *fileIndexer {
 
 	return &fileIndexer{
 		keyspace: keyspace,
@@ -689,11 +851,11 @@ func newFileIndexer(keyspace *keyspace) datastore.Indexer {
 }
 
 func (fi *fileIndexer) BucketId() string {
-	return ""
+	return fi.keyspace.bucketId()
 }
 
 func (fi *fileIndexer) ScopeId() string {
-	return ""
+	return fi.keyspace.ScopeId()
 }
 
 func (fi *fileIndexer) KeyspaceId() string {
@@ -705,14 +867,12 @@ func (fi *fileIndexer) Name() datastore.IndexType {
 }
 
 func (fi *fileIndexer) IndexIds() ([]string, errors.Error) {
-	rv := make([]string, 0, len(fi.indexes))
-	for name, _ := range fi.indexes {
-		rv = append(rv, name)
-	}
-	return rv, nil
+	return fi.IndexNames()
 }
 
 func (fi *fileIndexer) IndexNames() ([]string, errors.Error) {
+	fi.RLock()
+	defer fi.RUnlock()
 	rv := make([]string, 0, len(fi.indexes))
 	for name, _ := range fi.indexes {
 		rv = append(rv, name)
@@ -725,6 +885,8 @@ func (fi *fileIndexer) IndexById(id string) (datastore.Index, errors.Error) {
 }
 
 func (fi *fileIndexer) IndexByName(name string) (datastore.Index, errors.Error) {
+	fi.RLock()
+	defer fi.RUnlock()
 	index, ok := fi.indexes[name]
 	if !ok {
 		return nil, errors.NewFileIdxNotFound(nil, name)
@@ -737,11 +899,19 @@ func (fi *fileIndexer) PrimaryIndexes() ([]datastore.PrimaryIndex, errors.Error)
 }
 
 func (fi *fileIndexer) Indexes() ([]datastore.Index, errors.Error) {
-	return []datastore.Index{fi.primary}, nil
+	fi.RLock()
+	defer fi.RUnlock()
+	rv := make([]datastore.Index, 0, len(fi.indexes))
+	for _, index := range fi.indexes {
+		rv = append(rv, index)
+	}
+	return rv, nil
 }
 
 func (fi *fileIndexer) CreatePrimaryIndex(requestId, name string, with value.Value) (
 	datastore.PrimaryIndex, errors.Error) {
+	fi.Lock()
+	defer fi.Unlock()
 	if fi.primary == nil {
 		pi := new(primaryIndex)
 		fi.primary = pi
@@ -749,18 +919,149 @@ func (fi *fileIndexer) CreatePrimaryIndex(requestId, name string, with value.Val
 		pi.name = name
 		pi.indexer = fi
 		fi.indexes[pi.name] = pi
+		atomic.AddUint64(&fi.version, 1)
 	}
 
 	return fi.primary, nil
 }
 
-func (b *fileIndexer) CreateIndex(requestId, name string, seekKey, rangeKey expression.Expressions,
+func (fi *fileIndexer) CreateIndex(requestId, name string, seekKey, rangeKey expression.Expressions,
 	where expression.Expression, with value.Value) (datastore.Index, errors.Error) {
-	return nil, errors.NewFileNotSupported(nil, "CREATE INDEX is not supported for file-based datastore.")
+	indexKeys := make(datastore.IndexKeys, len(rangeKey))
+	for i, expr := range rangeKey {
+		indexKeys[i] = &datastore.IndexKey{Expr: expr, Attributes: datastore.IK_NONE}
+	}
+	return fi.CreateIndex2(requestId, name, seekKey, indexKeys, where, with)
 }
 
-func (b *fileIndexer) BuildIndexes(requestId string, names ...string) errors.Error {
-	return errors.NewFileNotSupported(nil, "BUILD INDEXES is not supported for file-based datastore.")
+// Secondary indexes are held in memory and built synchronously,
+// unless created WITH {"defer_build": true}
+func (fi *fileIndexer) CreateIndex2(requestId, name string, seekKey expression.Expressions,
+	rangeKey datastore.IndexKeys, where expression.Expression, with value.Value) (
+	datastore.Index, errors.Error) {
+
+	if len(seekKey) > 0 {
+		return nil, errors.NewFileNotSupported(nil, "Seek keys are not supported for file-based indexes.")
+	}
+	if len(rangeKey) == 0 {
+		return nil, errors.NewFileNotSupported(nil, "File-based indexes require at least one index key.")
+	}
+
+	deferred := false
+	if with != nil {
+		if v, ok := with.Field("defer_build"); ok && v.Type() == value.BOOLEAN {
+			deferred = v.Truth()
+		}
+	}
+
+	fi.Lock()
+	if _, ok := fi.indexes[name]; ok {
+		fi.Unlock()
+		return nil, errors.NewIndexAlreadyExistsError(name)
+	}
+	si := newSecondaryIndex(fi, name, rangeKey, where)
+	fi.indexes[name] = si
+	atomic.AddUint64(&fi.version, 1)
+	err := fi.saveDefinitions()
+	fi.Unlock()
+	if err != nil {
+		fi.dropIndex(si)
+		return nil, err
+	}
+
+	if !deferred {
+		if err = fi.buildIndex(si); err != nil {
+			return nil, err
+		}
+	}
+	return si, nil
+}
+
+func (fi *fileIndexer) BuildIndexes(requestId string, names ...string) errors.Error {
+	indexes := make([]*secondaryIndex, 0, len(names))
+	for _, name := range names {
+		index, err := fi.IndexByName(name)
+		if err != nil {
+			return err
+		}
+		si, ok := index.(*secondaryIndex)
+		if !ok {
+			continue
+		}
+		if state, _, _ := si.State(); state == datastore.DEFERRED {
+			indexes = append(indexes, si)
+		}
+	}
+
+	for _, si := range indexes {
+		if err := fi.buildIndex(si); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mutations are held off while an index is being populated,
+// so that no document changes are missed
+func (fi *fileIndexer) buildIndex(si *secondaryIndex) errors.Error {
+	fi.keyspace.fileLock.Lock()
+	err := si.build()
+	fi.keyspace.fileLock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	fi.Lock()
+	defer fi.Unlock()
+	atomic.AddUint64(&fi.version, 1)
+	return fi.saveDefinitions()
+}
+
+func (fi *fileIndexer) dropIndex(si *secondaryIndex) errors.Error {
+	fi.Lock()
+	defer fi.Unlock()
+	if fi.indexes[si.name] != si {
+		return errors.NewFileIdxNotFound(nil, si.name)
+	}
+	delete(fi.indexes, si.name)
+	atomic.AddUint64(&fi.version, 1)
+	return fi.saveDefinitions()
+}
+
+func (fi *fileIndexer) secondaryIndexes() []*secondaryIndex {
+	fi.RLock()
+	defer fi.RUnlock()
+	rv := make([]*secondaryIndex, 0, len(fi.indexes))
+	for _, index := range fi.indexes {
+		if si, ok := index.(*secondaryIndex); ok {
+			if state, _, _ := si.State(); state == datastore.ONLINE {
+				rv = append(rv, si)
+			}
+		}
+	}
+	return rv
+}
+
+// update the secondary indexes after a document has been written
+func (fi *fileIndexer) documentChanged(key string, bytes []byte) {
+	indexes := fi.secondaryIndexes()
+	if len(indexes) == 0 {
+		return
+	}
+
+	doc := value.NewAnnotatedValue(value.NewValue(bytes))
+	doc.SetId(key)
+	context := expression.NewIndexContext()
+	for _, si := range indexes {
+		si.update(key, doc, context)
+	}
+}
+
+// update the secondary indexes after a document has been removed
+func (fi *fileIndexer) documentDeleted(key string) {
+	for _, si := range fi.secondaryIndexes() {
+		si.delete(key)
+	}
 }
 
 func (b *fileIndexer) Refresh() errors.Error {
@@ -768,7 +1069,7 @@ func (b *fileIndexer) Refresh() errors.Error {
 }
 
 func (b *fileIndexer) MetadataVersion() uint64 {
-	return 0
+	return atomic.LoadUint64(&b.version)
 }
 
 func (b *fileIndexer) SetLogLevel(level logging.Level) {
@@ -787,11 +1088,11 @@ type primaryIndex struct {
 }
 
 func (pi *primaryIndex) BucketId() string {
-	return ""
+	return pi.keyspace.bucketId()
 }
 
 func (pi *primaryIndex) ScopeId() string {
-	return ""
+	return pi.keyspace.ScopeId()
 }
 
 func (pi *primaryIndex) KeyspaceId() string {
@@ -0,0 +1,374 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+/*
+Buckets, scopes and collections are nested directories:
+
+	<namespace>/<bucket>/<scope>/<collection>/<key>.json
+
+A directory under a namespace is a bucket if it contains subdirectories
+and no documents, otherwise it is a plain keyspace, as before. An empty
+directory is a keyspace.
+
+Every subdirectory of a bucket is a scope, and every subdirectory of a
+scope is a collection. The _default collection of the _default scope, if
+present, is the bucket's default collection, and is what the bucket name
+refers to when used as a keyspace.
+*/
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+)
+
+const _DEFAULT_NAME = "_default"
+
+// a directory is a bucket if it only holds directories
+func isBucketDir(path string) (bool, error) {
+	dirEntries, er := ioutil.ReadDir(path)
+	if er != nil {
+		return false, er
+	}
+
+	dirs := 0
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			return false, nil
+		}
+		dirs++
+	}
+	return dirs > 0, nil
+}
+
+// bucket is a file-based Bucket.
+type bucket struct {
+	sync.RWMutex
+	namespace  *namespace
+	name       string
+	scopes     map[string]*scope
+	scopeNames []string
+	version    uint64
+}
+
+func newBucket(p *namespace, dir string) (*bucket, errors.Error) {
+	bu := &bucket{namespace: p, name: dir}
+
+	dirEntries, er := ioutil.ReadDir(bu.path())
+	if er != nil {
+		return nil, errors.NewFileDatastoreError(er, "")
+	}
+
+	bu.scopes = make(map[string]*scope, len(dirEntries))
+	bu.scopeNames = make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		diru := strings.ToUpper(dirEntry.Name())
+		if _, ok := bu.scopes[diru]; ok {
+			return nil, errors.NewFileDuplicateScopeError(nil, bu.fullName(dirEntry.Name()))
+		}
+
+		s, e := newScope(bu, dirEntry.Name())
+		if e != nil {
+			return nil, e
+		}
+		bu.scopes[diru] = s
+		bu.scopeNames = append(bu.scopeNames, s.Name())
+	}
+	return bu, nil
+}
+
+func (bu *bucket) Id() string {
+	return bu.Name()
+}
+
+func (bu *bucket) Name() string {
+	return bu.name
+}
+
+func (bu *bucket) AuthKey() string {
+	return bu.name
+}
+
+func (bu *bucket) Uid() string {
+	return bu.name
+}
+
+func (bu *bucket) NamespaceId() string {
+	return bu.namespace.Id()
+}
+
+func (bu *bucket) Namespace() datastore.Namespace {
+	return bu.namespace
+}
+
+// bucket implements KeyspaceMetadata
+// the version changes whenever a scope or a collection is created or dropped
+func (bu *bucket) MetadataVersion() uint64 {
+	return atomic.LoadUint64(&bu.version)
+}
+
+func (bu *bucket) MetadataId() string {
+	return bu.fullName()
+}
+
+func (bu *bucket) metadataChanged() {
+	atomic.AddUint64(&bu.version, 1)
+}
+
+func (bu *bucket) DefaultKeyspace() (datastore.Keyspace, errors.Error) {
+	bu.RLock()
+	s, ok := bu.scopes[strings.ToUpper(_DEFAULT_NAME)]
+	bu.RUnlock()
+	if ok {
+		s.RLock()
+		b, ok := s.keyspaces[strings.ToUpper(_DEFAULT_NAME)]
+		s.RUnlock()
+		if ok {
+			return b, nil
+		}
+	}
+	return nil, errors.NewBucketNoDefaultCollectionError(bu.fullName())
+}
+
+func (bu *bucket) ScopeIds() ([]string, errors.Error) {
+	return bu.ScopeNames()
+}
+
+func (bu *bucket) ScopeNames() ([]string, errors.Error) {
+	bu.RLock()
+	defer bu.RUnlock()
+	return bu.scopeNames, nil
+}
+
+func (bu *bucket) ScopeById(id string) (datastore.Scope, errors.Error) {
+	return bu.ScopeByName(id)
+}
+
+func (bu *bucket) ScopeByName(name string) (datastore.Scope, errors.Error) {
+	bu.RLock()
+	defer bu.RUnlock()
+	s, ok := bu.scopes[strings.ToUpper(name)]
+	if !ok {
+		return nil, errors.NewFileScopeNotFoundError(nil, bu.fullName(name))
+	}
+	return s, nil
+}
+
+func (bu *bucket) CreateScope(name string) errors.Error {
+	bu.Lock()
+	defer bu.Unlock()
+
+	nameu := strings.ToUpper(name)
+	if _, ok := bu.scopes[nameu]; ok {
+		return errors.NewFileDuplicateScopeError(nil, bu.fullName(name))
+	}
+
+	er := os.Mkdir(filepath.Join(bu.path(), name), 0777)
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	s, e := newScope(bu, name)
+	if e != nil {
+		return e
+	}
+	bu.scopes[nameu] = s
+	bu.scopeNames = append(bu.scopeNames, s.Name())
+	bu.metadataChanged()
+	return nil
+}
+
+func (bu *bucket) DropScope(name string) errors.Error {
+	bu.Lock()
+	defer bu.Unlock()
+
+	nameu := strings.ToUpper(name)
+	s, ok := bu.scopes[nameu]
+	if !ok {
+		return errors.NewFileScopeNotFoundError(nil, bu.fullName(name))
+	}
+
+	er := os.RemoveAll(s.path())
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	delete(bu.scopes, nameu)
+	bu.scopeNames = removeName(bu.scopeNames, s.name)
+	bu.metadataChanged()
+	return nil
+}
+
+func (bu *bucket) path() string {
+	return filepath.Join(bu.namespace.path(), bu.name)
+}
+
+func (bu *bucket) fullName(elems ...string) string {
+	rv := bu.namespace.name + ":" + bu.name
+	for _, e := range elems {
+		rv += "." + e
+	}
+	return rv
+}
+
+// scope is a file-based Scope.
+type scope struct {
+	sync.RWMutex
+	bucket        *bucket
+	name          string
+	keyspaces     map[string]*keyspace
+	keyspaceNames []string
+}
+
+func newScope(bu *bucket, dir string) (*scope, errors.Error) {
+	s := &scope{bucket: bu, name: dir}
+
+	dirEntries, er := ioutil.ReadDir(s.path())
+	if er != nil {
+		return nil, errors.NewFileDatastoreError(er, "")
+	}
+
+	s.keyspaces = make(map[string]*keyspace, len(dirEntries))
+	s.keyspaceNames = make([]string, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+
+		// index definitions live alongside the collections
+		if !dirEntry.IsDir() {
+			continue
+		}
+		diru := strings.ToUpper(dirEntry.Name())
+		if _, ok := s.keyspaces[diru]; ok {
+			return nil, errors.NewFileDuplicateKeyspaceError(nil, bu.fullName(dir, dirEntry.Name()))
+		}
+
+		b, e := newKeyspace(bu.namespace, s, dirEntry.Name())
+		if e != nil {
+			return nil, e
+		}
+		s.keyspaces[diru] = b
+		s.keyspaceNames = append(s.keyspaceNames, b.Name())
+	}
+	return s, nil
+}
+
+func (s *scope) Id() string {
+	return s.Name()
+}
+
+func (s *scope) Name() string {
+	return s.name
+}
+
+func (s *scope) AuthKey() string {
+	return s.bucket.name + ":" + s.name
+}
+
+func (s *scope) BucketId() string {
+	return s.bucket.Id()
+}
+
+func (s *scope) Bucket() datastore.Bucket {
+	return s.bucket
+}
+
+func (s *scope) KeyspaceIds() ([]string, errors.Error) {
+	return s.KeyspaceNames()
+}
+
+func (s *scope) KeyspaceNames() ([]string, errors.Error) {
+	s.RLock()
+	defer s.RUnlock()
+	return s.keyspaceNames, nil
+}
+
+func (s *scope) KeyspaceById(id string) (datastore.Keyspace, errors.Error) {
+	return s.KeyspaceByName(id)
+}
+
+func (s *scope) KeyspaceByName(name string) (datastore.Keyspace, errors.Error) {
+	s.RLock()
+	defer s.RUnlock()
+	b, ok := s.keyspaces[strings.ToUpper(name)]
+	if !ok {
+		return nil, errors.NewFileKeyspaceNotFoundError(nil, s.bucket.fullName(s.name, name))
+	}
+	return b, nil
+}
+
+func (s *scope) CreateCollection(name string) errors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	nameu := strings.ToUpper(name)
+	if _, ok := s.keyspaces[nameu]; ok {
+		return errors.NewFileDuplicateKeyspaceError(nil, s.bucket.fullName(s.name, name))
+	}
+
+	er := os.Mkdir(filepath.Join(s.path(), name), 0777)
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	b, e := newKeyspace(s.bucket.namespace, s, name)
+	if e != nil {
+		return e
+	}
+	s.keyspaces[nameu] = b
+	s.keyspaceNames = append(s.keyspaceNames, b.Name())
+	s.bucket.metadataChanged()
+	return nil
+}
+
+func (s *scope) DropCollection(name string) errors.Error {
+	s.Lock()
+	defer s.Unlock()
+
+	nameu := strings.ToUpper(name)
+	b, ok := s.keyspaces[nameu]
+	if !ok {
+		return errors.NewFileKeyspaceNotFoundError(nil, s.bucket.fullName(s.name, name))
+	}
+
+	b.fileLock.Lock()
+	er := os.RemoveAll(b.path())
+	b.fileLock.Unlock()
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+	os.Remove(b.fi.definitionsPath())
+
+	delete(s.keyspaces, nameu)
+	s.keyspaceNames = removeName(s.keyspaceNames, b.name)
+	s.bucket.metadataChanged()
+	return nil
+}
+
+func (s *scope) path() string {
+	return filepath.Join(s.bucket.path(), s.name)
+}
+
+func removeName(names []string, name string) []string {
+	for i, n := range names {
+		if n == name {
+			return append(names[:i:i], names[i+1:]...)
+		}
+	}
+	return names
+}
@@ -0,0 +1,174 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/value"
+)
+
+func TestFileCollections(t *testing.T) {
+	dir, er := ioutil.TempDir("", "filecoll")
+	if er != nil {
+		t.Fatalf("failed to create directory: %v", er)
+	}
+	defer os.RemoveAll(dir)
+
+	docs := map[string]string{
+		"default/people/ann.json":                     `{"name":"ann"}`,
+		"default/travel/_default/_default/bob.json":   `{"name":"bob"}`,
+		"default/travel/inventory/airline/klm.json":   `{"name":"klm"}`,
+		"default/travel/inventory/airport/lhr.json":   `{"name":"lhr"}`,
+		"default/travel/inventory/route/unused.json":  `{}`,
+		"default/travel/tenant/users/cat.json":        `{"name":"cat"}`,
+		"default/travel/tenant/users/untested/x.json": `{}`,
+	}
+	for name, doc := range docs {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if er = os.MkdirAll(filepath.Dir(path), 0777); er != nil {
+			t.Fatalf("failed to create directory: %v", er)
+		}
+		if er = ioutil.WriteFile(path, []byte(doc), 0666); er != nil {
+			t.Fatalf("failed to write document: %v", er)
+		}
+	}
+
+	store, err := NewDatastore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	namespace, _ := store.NamespaceByName("default")
+
+	if names, _ := namespace.KeyspaceNames(); len(names) != 1 || names[0] != "people" {
+		t.Errorf("expected keyspace people, found %v", names)
+	}
+	if names, _ := namespace.BucketNames(); len(names) != 1 || names[0] != "travel" {
+		t.Errorf("expected bucket travel, found %v", names)
+	}
+
+	// the bucket name refers to the default collection
+	ks, err := namespace.KeyspaceByName("travel")
+	if err != nil {
+		t.Fatalf("failed to get default collection: %v", err)
+	}
+	if ks.QualifiedName() != "default:travel._default._default" || ks.AuthKey() != "travel" {
+		t.Errorf("unexpected default collection %v, %v", ks.QualifiedName(), ks.AuthKey())
+	}
+	checkFetch(t, ks.(*keyspace), datastore.NULL_QUERY_CONTEXT, map[string]bool{"bob": true})
+
+	bucket, err := namespace.BucketByName("travel")
+	if err != nil {
+		t.Fatalf("failed to get bucket: %v", err)
+	}
+	scope, err := bucket.ScopeByName("inventory")
+	if err != nil {
+		t.Fatalf("failed to get scope: %v", err)
+	}
+	if names, _ := scope.KeyspaceNames(); len(names) != 3 {
+		t.Errorf("expected 3 collections, found %v", names)
+	}
+	ks, err = scope.KeyspaceByName("airline")
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+	if ks.QualifiedName() != "default:travel.inventory.airline" || ks.Scope().BucketId() != "travel" {
+		t.Errorf("unexpected collection %v", ks.QualifiedName())
+	}
+	checkFetch(t, ks.(*keyspace), datastore.NULL_QUERY_CONTEXT, map[string]bool{"klm": true, "bob": false})
+
+	// a keyspace with a subdirectory in it is still a collection
+	scope, _ = bucket.ScopeByName("tenant")
+	if _, err = scope.KeyspaceByName("users"); err != nil {
+		t.Errorf("failed to get collection users: %v", err)
+	}
+
+	// DDL
+	if err = bucket.CreateScope("sales"); err != nil {
+		t.Fatalf("failed to create scope: %v", err)
+	}
+	if err = bucket.CreateScope("sales"); err == nil {
+		t.Errorf("expected duplicate scope creation to fail")
+	}
+	scope, _ = bucket.ScopeByName("sales")
+	if err = scope.CreateCollection("orders"); err != nil {
+		t.Fatalf("failed to create collection: %v", err)
+	}
+	ks, _ = scope.KeyspaceByName("orders")
+	order := value.Pair{Name: "o1", Value: value.NewValue(map[string]interface{}{"total": 10})}
+	if _, err = ks.Insert([]value.Pair{order}, datastore.NULL_QUERY_CONTEXT); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, er = os.Stat(filepath.Join(dir, "default", "travel", "sales", "orders", "o1.json")); er != nil {
+		t.Errorf("expected document file: %v", er)
+	}
+
+	scope, _ = bucket.ScopeByName("inventory")
+	version := bucket.(datastore.KeyspaceMetadata).MetadataVersion()
+	if err = scope.DropCollection("route"); err != nil {
+		t.Fatalf("failed to drop collection: %v", err)
+	}
+	if bucket.(datastore.KeyspaceMetadata).MetadataVersion() == version {
+		t.Errorf("expected collection drop to change the bucket metadata version")
+	}
+	if _, er = os.Stat(filepath.Join(dir, "default", "travel", "inventory", "route")); !os.IsNotExist(er) {
+		t.Errorf("expected collection directory to be removed")
+	}
+	if err = bucket.DropScope("tenant"); err != nil {
+		t.Fatalf("failed to drop scope: %v", err)
+	}
+	if _, err = bucket.ScopeByName("tenant"); err == nil {
+		t.Errorf("expected scope tenant to be dropped")
+	}
+
+	// the optimizer statistics keyspace is not visible to queries
+	if err = store.CreateSystemCBOStats(""); err != nil {
+		t.Fatalf("failed to create the system stats keyspace: %v", err)
+	}
+	checkHiddenCBOStats(t, store)
+
+	// the layout survives a restart
+	store, err = NewDatastore(dir)
+	if err != nil {
+		t.Fatalf("failed to reopen store: %v", err)
+	}
+	checkHiddenCBOStats(t, store)
+	namespace, _ = store.NamespaceByName("default")
+	bucket, _ = namespace.BucketByName("travel")
+	if names, _ := bucket.ScopeNames(); len(names) != 3 {
+		t.Errorf("expected 3 scopes, found %v", names)
+	}
+	scope, _ = bucket.ScopeByName("sales")
+	ks, err = scope.KeyspaceByName("orders")
+	if err != nil {
+		t.Fatalf("failed to get collection: %v", err)
+	}
+	checkFetch(t, ks.(*keyspace), datastore.NULL_QUERY_CONTEXT, map[string]bool{"o1": true})
+}
+
+func checkHiddenCBOStats(t *testing.T, store datastore.Datastore) {
+	if ok, _ := store.HasSystemCBOStats(); !ok {
+		t.Errorf("expected the system stats keyspace to exist")
+	}
+	namespace, _ := store.NamespaceByName("default")
+	if _, err := namespace.KeyspaceByName(_SYSTEM_CBO_STATS); err == nil {
+		t.Errorf("expected the system stats keyspace not to be visible")
+	}
+	names, _ := namespace.KeyspaceNames()
+	for _, name := range names {
+		if name == _SYSTEM_CBO_STATS {
+			t.Errorf("expected the system stats keyspace not to be listed")
+		}
+	}
+}
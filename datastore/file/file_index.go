@@ -0,0 +1,671 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/expression/parser"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/value"
+)
+
+// secondaryIndex is an in-process secondary index on a file-based keyspace.
+// Entries are kept in a slice ordered by index key collation (honouring DESC
+// keys), then by document key. The index is maintained by the keyspace
+// mutation methods, so scans are always consistent with the keyspace
+// directory, as long as documents are not modified behind the datastore's back.
+type secondaryIndex struct {
+	sync.RWMutex
+	name      string
+	keyspace  *keyspace
+	indexer   *fileIndexer
+	rangeKeys datastore.IndexKeys
+	where     expression.Expression
+	state     datastore.IndexState
+	entries   []*indexEntry
+	docs      map[string][]*indexEntry
+}
+
+type indexEntry struct {
+	keys   value.Values
+	docKey string
+}
+
+func newSecondaryIndex(indexer *fileIndexer, name string, rangeKeys datastore.IndexKeys,
+	where expression.Expression) *secondaryIndex {
+	return &secondaryIndex{
+		name:      name,
+		keyspace:  indexer.keyspace,
+		indexer:   indexer,
+		rangeKeys: rangeKeys,
+		where:     where,
+		state:     datastore.DEFERRED,
+		docs:      make(map[string][]*indexEntry),
+	}
+}
+
+func (si *secondaryIndex) BucketId() string {
+	return si.keyspace.bucketId()
+}
+
+func (si *secondaryIndex) ScopeId() string {
+	return si.keyspace.ScopeId()
+}
+
+func (si *secondaryIndex) KeyspaceId() string {
+	return si.keyspace.Id()
+}
+
+func (si *secondaryIndex) Id() string {
+	return si.Name()
+}
+
+func (si *secondaryIndex) Name() string {
+	return si.name
+}
+
+func (si *secondaryIndex) Type() datastore.IndexType {
+	return datastore.DEFAULT
+}
+
+func (si *secondaryIndex) Indexer() datastore.Indexer {
+	return si.indexer
+}
+
+func (si *secondaryIndex) SeekKey() expression.Expressions {
+	return nil
+}
+
+func (si *secondaryIndex) RangeKey() expression.Expressions {
+	rv := make(expression.Expressions, len(si.rangeKeys))
+	for i, k := range si.rangeKeys {
+		rv[i] = k.Expr
+	}
+	return rv
+}
+
+func (si *secondaryIndex) RangeKey2() datastore.IndexKeys {
+	return si.rangeKeys
+}
+
+func (si *secondaryIndex) Condition() expression.Expression {
+	return si.where
+}
+
+func (si *secondaryIndex) IsPrimary() bool {
+	return false
+}
+
+func (si *secondaryIndex) State() (state datastore.IndexState, msg string, err errors.Error) {
+	si.RLock()
+	state = si.state
+	si.RUnlock()
+	return state, "", nil
+}
+
+func (si *secondaryIndex) Statistics(requestId string, span *datastore.Span) (
+	datastore.Statistics, errors.Error) {
+	return nil, nil
+}
+
+func (si *secondaryIndex) Drop(requestId string) errors.Error {
+	return si.indexer.dropIndex(si)
+}
+
+// Index API1 scan: spans are composite bounds on the leading index keys
+func (si *secondaryIndex) Scan(requestId string, span *datastore.Span, distinct bool, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	if !si.online(conn) {
+		return
+	}
+
+	seen := map[string]bool{}
+	var n int64
+	for _, e := range si.snapshot() {
+		if limit > 0 && n >= limit {
+			break
+		}
+		if !span1Matches(e.keys, span) {
+			continue
+		}
+		if distinct {
+			if seen[e.docKey] {
+				continue
+			}
+			seen[e.docKey] = true
+		}
+		if !conn.Sender().SendEntry(&datastore.IndexEntry{EntryKey: e.keys, PrimaryKey: e.docKey}) {
+			return
+		}
+		n++
+	}
+}
+
+// Index API2 scan: a span has one range per index key, and entries
+// matching any of the spans are returned once, in index order
+func (si *secondaryIndex) Scan2(requestId string, spans datastore.Spans2, reverse, distinctAfterProjection,
+	ordered bool, projection *datastore.IndexProjection, offset, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	if !si.online(conn) {
+		return
+	}
+
+	entries := si.snapshot()
+	start, end := si.bounds(entries, spans)
+	seen := map[string]bool{}
+	var n int64
+
+	for i := start; i < end; i++ {
+		if limit > 0 && n >= limit {
+			break
+		}
+		e := entries[i]
+		if reverse {
+			e = entries[end-1-(i-start)]
+		}
+		if !spans2Match(e.keys, spans) {
+			continue
+		}
+
+		keys := projectKeys(e.keys, projection)
+		if distinctAfterProjection {
+			k := distinctKey(keys, e.docKey, projection)
+			if seen[k] {
+				continue
+			}
+			seen[k] = true
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if !conn.Sender().SendEntry(&datastore.IndexEntry{EntryKey: keys, PrimaryKey: e.docKey}) {
+			return
+		}
+		n++
+	}
+}
+
+func (si *secondaryIndex) online(conn *datastore.IndexConnection) bool {
+	state, _, _ := si.State()
+	if state != datastore.ONLINE {
+		conn.Error(errors.NewFileDatastoreError(nil, "index "+si.name+" is "+string(state)))
+		return false
+	}
+	return true
+}
+
+// scans work on a copy of the entry list, so that they neither see their own
+// mutations nor hold the index lock while the consumer is busy
+func (si *secondaryIndex) snapshot() []*indexEntry {
+	si.RLock()
+	rv := make([]*indexEntry, len(si.entries))
+	copy(rv, si.entries)
+	si.RUnlock()
+	return rv
+}
+
+// narrow the part of the entry list to be checked using the leading key ranges
+func (si *secondaryIndex) bounds(entries []*indexEntry, spans datastore.Spans2) (int, int) {
+	start, end := 0, len(entries)
+	if len(si.rangeKeys) == 0 || si.rangeKeys[0].HasAttribute(datastore.IK_DESC) {
+		return start, end
+	}
+
+	var low, high value.Value
+	for i, span := range spans {
+		if len(span.Ranges) == 0 || span.Ranges[0].Low == nil {
+			low = nil
+			break
+		}
+		if i == 0 || span.Ranges[0].Low.Collate(low) < 0 {
+			low = span.Ranges[0].Low
+		}
+	}
+	for i, span := range spans {
+		if len(span.Ranges) == 0 || span.Ranges[0].High == nil {
+			high = nil
+			break
+		}
+		if i == 0 || span.Ranges[0].High.Collate(high) > 0 {
+			high = span.Ranges[0].High
+		}
+	}
+
+	if low != nil {
+		start = sort.Search(len(entries), func(i int) bool {
+			return entries[i].keys[0].Collate(low) >= 0
+		})
+	}
+	if high != nil {
+		end = sort.Search(len(entries), func(i int) bool {
+			return entries[i].keys[0].Collate(high) > 0
+		})
+	}
+	if end < start {
+		end = start
+	}
+	return start, end
+}
+
+func span1Matches(keys value.Values, span *datastore.Span) bool {
+	if span == nil {
+		return true
+	}
+	if len(span.Seek) > 0 && collatePrefix(keys, span.Seek) != 0 {
+		return false
+	}
+	if len(span.Range.Low) > 0 {
+		c := collatePrefix(keys, span.Range.Low)
+		if c < 0 || (c == 0 && (span.Range.Inclusion&datastore.LOW) == 0) {
+			return false
+		}
+	}
+	if len(span.Range.High) > 0 {
+		c := collatePrefix(keys, span.Range.High)
+		if c > 0 || (c == 0 && (span.Range.Inclusion&datastore.HIGH) == 0) {
+			return false
+		}
+	}
+	return true
+}
+
+// collate the leading keys against a bound with possibly fewer values
+func collatePrefix(keys, bound value.Values) int {
+	for i, b := range bound {
+		if i >= len(keys) {
+			return -1
+		}
+		if c := keys[i].Collate(b); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
+
+func spans2Match(keys value.Values, spans datastore.Spans2) bool {
+	if len(spans) == 0 {
+		return true
+	}
+	for _, span := range spans {
+		if rangesMatch(keys, span.Ranges) {
+			return true
+		}
+	}
+	return false
+}
+
+func rangesMatch(keys value.Values, ranges datastore.Ranges2) bool {
+	for i, rg := range ranges {
+		if i >= len(keys) {
+			break
+		}
+		if rg.Low != nil {
+			c := keys[i].Collate(rg.Low)
+			if c < 0 || (c == 0 && (rg.Inclusion&datastore.LOW) == 0) {
+				return false
+			}
+		}
+		if rg.High != nil {
+			c := keys[i].Collate(rg.High)
+			if c > 0 || (c == 0 && (rg.Inclusion&datastore.HIGH) == 0) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func projectKeys(keys value.Values, projection *datastore.IndexProjection) value.Values {
+	if projection == nil {
+		return keys
+	}
+	rv := make(value.Values, 0, len(projection.EntryKeys))
+	for _, k := range projection.EntryKeys {
+		if k >= 0 && k < len(keys) {
+			rv = append(rv, keys[k])
+		}
+	}
+	return rv
+}
+
+func distinctKey(keys value.Values, docKey string, projection *datastore.IndexProjection) string {
+	var b strings.Builder
+	for _, k := range keys {
+		b.WriteString(k.String())
+		b.WriteByte(0)
+	}
+	if projection == nil || projection.PrimaryKey {
+		b.WriteString(docKey)
+	}
+	return b.String()
+}
+
+// collation of index entries
+func (si *secondaryIndex) less(a, b *indexEntry) bool {
+	for i, k := range si.rangeKeys {
+		c := a.keys[i].Collate(b.keys[i])
+		if c != 0 {
+			if k.HasAttribute(datastore.IK_DESC) {
+				return c > 0
+			}
+			return c < 0
+		}
+	}
+	return a.docKey < b.docKey
+}
+
+// compute the entries for a document
+// no entries are produced if the document does not satisfy the index
+// condition, or if the leading key is MISSING and the index does not
+// include MISSING leading keys
+// an array index key produces one entry per element
+func (si *secondaryIndex) evaluate(docKey string, doc value.AnnotatedValue,
+	context expression.Context) ([]*indexEntry, error) {
+
+	if si.where != nil {
+		cond, err := si.where.Evaluate(doc, context)
+		if err != nil {
+			return nil, err
+		}
+		if !cond.Truth() {
+			return nil, nil
+		}
+	}
+
+	arrayPos := -1
+	var arrayVals value.Values
+	keys := make(value.Values, len(si.rangeKeys))
+	for i, k := range si.rangeKeys {
+		v, vals, err := k.Expr.EvaluateForIndex(doc, context)
+		if err != nil {
+			return nil, err
+		}
+		if isArray, distinct := k.Expr.IsArrayIndexKey(); isArray && arrayPos < 0 {
+			arrayPos = i
+			arrayVals = vals
+			if vals == nil {
+				arrayVals = value.Values{v}
+			}
+			if distinct {
+				arrayVals = distinctValues(arrayVals)
+			}
+			v = value.MISSING_VALUE
+		}
+		keys[i] = v
+	}
+
+	if arrayPos < 0 {
+		if keys[0].Type() == value.MISSING && !si.rangeKeys[0].HasAttribute(datastore.IK_MISSING) {
+			return nil, nil
+		}
+		return []*indexEntry{&indexEntry{keys: keys, docKey: docKey}}, nil
+	}
+
+	rv := make([]*indexEntry, 0, len(arrayVals))
+	for _, av := range arrayVals {
+		if arrayPos == 0 && av.Type() == value.MISSING && !si.rangeKeys[0].HasAttribute(datastore.IK_MISSING) {
+			continue
+		}
+		entryKeys := make(value.Values, len(keys))
+		copy(entryKeys, keys)
+		entryKeys[arrayPos] = av
+		rv = append(rv, &indexEntry{keys: entryKeys, docKey: docKey})
+	}
+	return rv, nil
+}
+
+func distinctValues(vals value.Values) value.Values {
+	rv := make(value.Values, 0, len(vals))
+outer:
+	for _, v := range vals {
+		for _, r := range rv {
+			if v.EquivalentTo(r) {
+				continue outer
+			}
+		}
+		rv = append(rv, v)
+	}
+	return rv
+}
+
+// (re)index a document
+func (si *secondaryIndex) update(docKey string, doc value.AnnotatedValue, context expression.Context) {
+	entries, err := si.evaluate(docKey, doc, context)
+	if err != nil {
+		logging.Infof("File index %v: unable to index document <ud>%v</ud>: %v", si.name, docKey, err)
+	}
+
+	si.Lock()
+	defer si.Unlock()
+
+	si.remove(docKey)
+	if len(entries) == 0 {
+		return
+	}
+	for _, e := range entries {
+		pos := sort.Search(len(si.entries), func(i int) bool {
+			return !si.less(si.entries[i], e)
+		})
+		si.entries = append(si.entries, nil)
+		copy(si.entries[pos+1:], si.entries[pos:])
+		si.entries[pos] = e
+	}
+	si.docs[docKey] = entries
+}
+
+// remove a document from the index
+func (si *secondaryIndex) delete(docKey string) {
+	si.Lock()
+	si.remove(docKey)
+	si.Unlock()
+}
+
+// must be called with the index lock held
+func (si *secondaryIndex) remove(docKey string) {
+	for _, e := range si.docs[docKey] {
+		pos := sort.Search(len(si.entries), func(i int) bool {
+			return !si.less(si.entries[i], e)
+		})
+		for ; pos < len(si.entries); pos++ {
+			if si.entries[pos] == e {
+				si.entries = append(si.entries[:pos], si.entries[pos+1:]...)
+				break
+			}
+		}
+	}
+	delete(si.docs, docKey)
+}
+
+// populate the index from the keyspace directory
+func (si *secondaryIndex) build() errors.Error {
+	si.Lock()
+	si.state = datastore.BUILDING
+	si.Unlock()
+
+	dirEntries, er := ioutil.ReadDir(si.keyspace.path())
+	if er != nil {
+		si.Lock()
+		si.state = datastore.OFFLINE
+		si.Unlock()
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	context := expression.NewIndexContext()
+	entries := make([]*indexEntry, 0, len(dirEntries))
+	docs := make(map[string][]*indexEntry, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() {
+			continue
+		}
+		docKey := documentPathToId(dirEntry.Name())
+		doc, err := si.keyspace.fetchOne(docKey)
+		if err != nil {
+			logging.Infof("File index %v: unable to read document <ud>%v</ud>: %v", si.name, docKey, err)
+			continue
+		}
+		docEntries, er := si.evaluate(docKey, doc, context)
+		if er != nil {
+			logging.Infof("File index %v: unable to index document <ud>%v</ud>: %v", si.name, docKey, er)
+			continue
+		}
+		if len(docEntries) > 0 {
+			entries = append(entries, docEntries...)
+			docs[docKey] = docEntries
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return si.less(entries[i], entries[j])
+	})
+
+	si.Lock()
+	si.entries = entries
+	si.docs = docs
+	si.state = datastore.ONLINE
+	si.Unlock()
+	return nil
+}
+
+// index definitions are persisted in a file next to the keyspace directory,
+// and reloaded when the datastore is opened
+
+const _INDEX_DEFINITIONS_SUFFIX = ".indexes.json"
+
+type indexDefinition struct {
+	Name     string               `json:"name"`
+	Keys     []indexKeyDefinition `json:"keys"`
+	Where    string               `json:"where,omitempty"`
+	Deferred bool                 `json:"deferred,omitempty"`
+}
+
+type indexKeyDefinition struct {
+	Expr    string `json:"expr"`
+	Desc    bool   `json:"desc,omitempty"`
+	Missing bool   `json:"missing,omitempty"`
+}
+
+func (fi *fileIndexer) definitionsPath() string {
+	return filepath.Join(filepath.Dir(fi.keyspace.path()), fi.keyspace.name+_INDEX_DEFINITIONS_SUFFIX)
+}
+
+// must be called with the indexer lock held
+func (fi *fileIndexer) saveDefinitions() errors.Error {
+	names := make([]string, 0, len(fi.indexes))
+	for name, index := range fi.indexes {
+		if _, ok := index.(*secondaryIndex); ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	path := fi.definitionsPath()
+	if len(names) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return errors.NewFileDatastoreError(err, "")
+		}
+		return nil
+	}
+
+	defs := make([]indexDefinition, 0, len(names))
+	for _, name := range names {
+		si := fi.indexes[name].(*secondaryIndex)
+		def := indexDefinition{Name: si.name, Keys: make([]indexKeyDefinition, len(si.rangeKeys))}
+		for i, k := range si.rangeKeys {
+			def.Keys[i] = indexKeyDefinition{
+				Expr:    k.Expr.String(),
+				Desc:    k.HasAttribute(datastore.IK_DESC),
+				Missing: k.HasAttribute(datastore.IK_MISSING),
+			}
+		}
+		if si.where != nil {
+			def.Where = si.where.String()
+		}
+		state, _, _ := si.State()
+		def.Deferred = state == datastore.DEFERRED
+		defs = append(defs, def)
+	}
+
+	bytes, err := json.MarshalIndent(defs, "", "    ")
+	if err == nil {
+		err = ioutil.WriteFile(path+".tmp", bytes, 0666)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return errors.NewFileDatastoreError(err, "")
+	}
+	return nil
+}
+
+func (fi *fileIndexer) loadDefinitions() errors.Error {
+	bytes, err := ioutil.ReadFile(fi.definitionsPath())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return errors.NewFileDatastoreError(err, "")
+	}
+
+	var defs []indexDefinition
+	if err = json.Unmarshal(bytes, &defs); err != nil {
+		return errors.NewFileDatastoreError(err, fi.definitionsPath())
+	}
+
+	for _, def := range defs {
+		rangeKeys := make(datastore.IndexKeys, len(def.Keys))
+		for i, k := range def.Keys {
+			expr, err := parser.Parse(k.Expr)
+			if err != nil {
+				return errors.NewFileDatastoreError(err, "index "+def.Name+" key "+k.Expr)
+			}
+			attrs := datastore.IK_NONE
+			if k.Desc {
+				attrs |= datastore.IK_DESC
+			}
+			if k.Missing {
+				attrs |= datastore.IK_MISSING
+			}
+			rangeKeys[i] = &datastore.IndexKey{Expr: expr, Attributes: datastore.IkAttributes(attrs)}
+		}
+
+		var where expression.Expression
+		if def.Where != "" {
+			where, err = parser.Parse(def.Where)
+			if err != nil {
+				return errors.NewFileDatastoreError(err, "index "+def.Name+" condition "+def.Where)
+			}
+		}
+
+		si := newSecondaryIndex(fi, def.Name, rangeKeys, where)
+		fi.indexes[si.name] = si
+		if !def.Deferred {
+			if err := si.build(); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
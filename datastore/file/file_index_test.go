@@ -0,0 +1,158 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+func TestFileSecondaryIndex(t *testing.T) {
+	dir, er := ioutil.TempDir("", "fileindex")
+	if er != nil {
+		t.Fatalf("failed to create directory: %v", er)
+	}
+	defer os.RemoveAll(dir)
+
+	ksPath := filepath.Join(dir, "default", "people")
+	if er = os.MkdirAll(ksPath, 0777); er != nil {
+		t.Fatalf("failed to create keyspace: %v", er)
+	}
+	docs := map[string]string{
+		"ann":  `{"name":"ann","age":31}`,
+		"bob":  `{"name":"bob","age":25}`,
+		"cat":  `{"name":"cat","age":40}`,
+		"dave": `{"name":"dave"}`,
+	}
+	for k, v := range docs {
+		if er = ioutil.WriteFile(filepath.Join(ksPath, k+".json"), []byte(v), 0666); er != nil {
+			t.Fatalf("failed to write document: %v", er)
+		}
+	}
+
+	keyspace := openTestKeyspace(t, dir)
+	indexer := keyspace.fi
+	version := indexer.MetadataVersion()
+	index, err := indexer.CreateIndex2("", "ix_age", nil,
+		datastore.IndexKeys{&datastore.IndexKey{Expr: expression.NewIdentifier("age")}}, nil, nil)
+	if err != nil {
+		t.Fatalf("failed to create index: %v", err)
+	}
+	if indexer.MetadataVersion() == version {
+		t.Errorf("expected index creation to change the metadata version")
+	}
+
+	checkScan(t, index.(datastore.Index2), nil, 0, 0, false, []string{"bob", "ann", "cat"})
+	checkScan(t, index.(datastore.Index2), nil, 0, 0, true, []string{"cat", "ann", "bob"})
+	checkScan(t, index.(datastore.Index2), datastore.Spans2{&datastore.Span2{
+		Ranges: datastore.Ranges2{&datastore.Range2{
+			Low: value.NewValue(25), High: value.NewValue(40), Inclusion: datastore.LOW}}}},
+		1, 0, false, []string{"ann"})
+
+	// mutations are reflected in the index
+	_, err = keyspace.Upsert([]value.Pair{value.Pair{Name: "dave",
+		Value: value.NewValue(map[string]interface{}{"name": "dave", "age": 20})}},
+		datastore.NULL_QUERY_CONTEXT)
+	if err != nil {
+		t.Fatalf("failed to upsert: %v", err)
+	}
+	_, err = keyspace.Delete([]value.Pair{value.Pair{Name: "cat"}}, datastore.NULL_QUERY_CONTEXT)
+	if err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+	checkScan(t, index.(datastore.Index2), nil, 0, 0, false, []string{"dave", "bob", "ann"})
+
+	// deferred indexes are built on request
+	_, err = indexer.CreateIndex("", "ix_name", nil, expression.Expressions{expression.NewIdentifier("name")},
+		nil, value.NewValue(map[string]interface{}{"defer_build": true}))
+	if err != nil {
+		t.Fatalf("failed to create deferred index: %v", err)
+	}
+	if _, err = indexer.CreateIndex("", "ix_name", nil, expression.Expressions{expression.NewIdentifier("name")},
+		nil, nil); err == nil {
+		t.Errorf("expected duplicate index creation to fail")
+	}
+
+	// definitions survive a restart
+	keyspace = openTestKeyspace(t, dir)
+	indexes, _ := keyspace.fi.Indexes()
+	if len(indexes) != 3 {
+		t.Fatalf("expected 3 indexes after reload, found %d", len(indexes))
+	}
+	index, _ = keyspace.fi.IndexByName("ix_name")
+	if state, _, _ := index.State(); state != datastore.DEFERRED {
+		t.Errorf("expected ix_name to be deferred, found %v", state)
+	}
+	if err = keyspace.fi.BuildIndexes("", "ix_name"); err != nil {
+		t.Fatalf("failed to build index: %v", err)
+	}
+	checkScan(t, index.(datastore.Index2), nil, 0, 0, false, []string{"ann", "bob", "dave"})
+
+	index, _ = keyspace.fi.IndexByName("ix_age")
+	checkScan(t, index.(datastore.Index2), nil, 0, 0, false, []string{"dave", "bob", "ann"})
+	version = keyspace.fi.MetadataVersion()
+	if err = index.Drop(""); err != nil {
+		t.Fatalf("failed to drop index: %v", err)
+	}
+	if keyspace.fi.MetadataVersion() == version {
+		t.Errorf("expected index drop to change the metadata version")
+	}
+	if _, err = keyspace.fi.IndexByName("ix_age"); err == nil {
+		t.Errorf("expected ix_age to have been dropped")
+	}
+}
+
+func openTestKeyspace(t *testing.T, dir string) *keyspace {
+	store, err := NewDatastore(dir)
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+	namespace, err := store.NamespaceByName("default")
+	if err != nil {
+		t.Fatalf("failed to get namespace: %v", err)
+	}
+	ks, err := namespace.KeyspaceByName("people")
+	if err != nil {
+		t.Fatalf("failed to get keyspace: %v", err)
+	}
+	return ks.(*keyspace)
+}
+
+func checkScan(t *testing.T, index datastore.Index2, spans datastore.Spans2, offset, limit int64,
+	reverse bool, expected []string) {
+	conn := datastore.NewIndexConnection(&testingContext{t})
+	go index.Scan2("", spans, reverse, false, true, nil, offset, limit, datastore.UNBOUNDED, nil, conn)
+
+	var keys []string
+	for {
+		entry, ok := conn.Sender().GetEntry()
+		if !ok || entry == nil {
+			break
+		}
+		keys = append(keys, entry.PrimaryKey)
+	}
+
+	if len(keys) != len(expected) {
+		t.Errorf("index %v: expected %v, got %v", index.Name(), expected, keys)
+		return
+	}
+	for i := range keys {
+		if keys[i] != expected[i] {
+			t.Errorf("index %v: expected %v, got %v", index.Name(), expected, keys)
+			return
+		}
+	}
+}
@@ -0,0 +1,538 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+/*
+Local transactions for the file-based datastore.
+
+Mutations made within a transaction are not written to the keyspace
+directories, but are appended to a per transaction log, which is held
+in the transaction context. Fetches and delta keyspace scans consult the
+log first, so that a transaction sees its own writes.
+
+Savepoints and statement atomicity are positions in the log: rolling back
+to one simply truncates the log.
+
+On commit, the net effect of the log is written to a journal file in the
+namespace directory, which is renamed into place once complete. The rename
+is the commit point: the mutations are then applied to the keyspaces and
+the journal is removed. Journals left behind by a crash are replayed when
+the datastore is next opened.
+*/
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/transactions"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+const _JOURNAL_SUFFIX = ".journal"
+
+type fileTransaction struct {
+	sync.RWMutex
+	id         string
+	implicit   bool
+	log        []*txMutation
+	deltas     map[string]map[string]*txMutation // latest mutation by keyspace and key
+	savepoints map[string]int
+	stmtStart  int
+}
+
+type txMutation struct {
+	keyspace *keyspace
+	key      string
+	op       int
+	doc      []byte // nil for deletes
+	fresh    bool   // the document did not exist when the transaction first modified it
+}
+
+func newFileTransaction(id string, implicit bool) *fileTransaction {
+	return &fileTransaction{
+		id:         id,
+		implicit:   implicit,
+		deltas:     make(map[string]map[string]*txMutation),
+		savepoints: make(map[string]int),
+	}
+}
+
+// the file transaction, if any, a query context is executing in
+func getTransaction(context datastore.QueryContext) (*transactions.TranContext, *fileTransaction) {
+	if context == nil {
+		return nil, nil
+	}
+	txContext, _ := context.GetTxContext().(*transactions.TranContext)
+	if txContext == nil {
+		return nil, nil
+	}
+	txn, _ := txContext.TxMutations().(*fileTransaction)
+	return txContext, txn
+}
+
+func (s *store) StartTransaction(stmtAtomicity bool, context datastore.QueryContext) (map[string]bool, errors.Error) {
+	txContext, txn := getTransaction(context)
+	if txContext == nil {
+		return nil, nil
+	}
+
+	if txContext.TxExpired() {
+		return nil, errors.NewTransactionExpired(nil)
+	}
+
+	if stmtAtomicity {
+		dks := make(map[string]bool, 8)
+		if txn != nil {
+			txn.startStatement(dks)
+		}
+		return dks, nil
+	}
+
+	id, err := util.UUIDV3()
+	if err != nil {
+		return nil, errors.NewStartTransactionError(err, nil)
+	}
+	txContext.SetTxMutations(newFileTransaction(id, txContext.TxImplicit()))
+	txContext.SetTxId(id, txContext.TxTimeout())
+	return nil, nil
+}
+
+func (s *store) CommitTransaction(stmtAtomicity bool, context datastore.QueryContext) errors.Error {
+	txContext, txn := getTransaction(context)
+	if txn == nil {
+		return nil
+	}
+
+	if txContext.TxExpired() {
+		return errors.NewTransactionExpired(nil)
+	}
+
+	if stmtAtomicity {
+		txn.endStatement()
+		return nil
+	}
+
+	txContext.SetTxMutations(nil)
+	return s.commit(txn)
+}
+
+func (s *store) RollbackTransaction(stmtAtomicity bool, context datastore.QueryContext, sname string) errors.Error {
+	txContext, txn := getTransaction(context)
+	if txn == nil {
+		return nil
+	}
+
+	if txContext.TxExpired() {
+		return errors.NewTransactionExpired(nil)
+	}
+
+	if !txn.implicit && (stmtAtomicity || sname != "") {
+		return txn.rollback(stmtAtomicity, sname)
+	}
+
+	txContext.SetTxMutations(nil)
+	return nil
+}
+
+func (s *store) SetSavepoint(stmtAtomicity bool, context datastore.QueryContext, sname string) errors.Error {
+	if sname == "" {
+		return nil
+	}
+
+	txContext, txn := getTransaction(context)
+	if txn == nil {
+		return nil
+	}
+
+	if txContext.TxExpired() {
+		return errors.NewTransactionExpired(nil)
+	}
+
+	txn.Lock()
+	txn.savepoints[sname] = len(txn.log)
+	txn.Unlock()
+	return nil
+}
+
+// Delta keyspace scan
+// deleted keys are flagged with non nil metadata, so that they are only excluded from the index scan
+func (s *store) TransactionDeltaKeyScan(keyspace string, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	_, txn := getTransaction(conn.QueryContext())
+	if txn == nil || txn.implicit {
+		return
+	}
+
+	txn.RLock()
+	keys := make(map[string]bool, len(txn.deltas[keyspace]))
+	for k, m := range txn.deltas[keyspace] {
+		keys[k] = (m.doc == nil)
+	}
+	txn.RUnlock()
+
+	for k, deleted := range keys {
+		ie := &datastore.IndexEntry{PrimaryKey: k}
+		if deleted {
+			ie.MetaData = value.NULL_VALUE
+		}
+		if !conn.Sender().SendEntry(ie) {
+			return
+		}
+	}
+}
+
+func (txn *fileTransaction) startStatement(dks map[string]bool) {
+	txn.Lock()
+	defer txn.Unlock()
+
+	txn.stmtStart = len(txn.log)
+	if txn.implicit {
+		return
+	}
+	for ks, keys := range txn.deltas {
+		if len(keys) > 0 {
+			dks[ks] = true
+		}
+	}
+}
+
+func (txn *fileTransaction) endStatement() {
+	txn.Lock()
+	txn.stmtStart = len(txn.log)
+	txn.Unlock()
+}
+
+// undo the mutations of the current statement, or back to a savepoint
+func (txn *fileTransaction) rollback(stmtAtomicity bool, sname string) errors.Error {
+	txn.Lock()
+	defer txn.Unlock()
+
+	pos := txn.stmtStart
+	if !stmtAtomicity {
+		var ok bool
+		pos, ok = txn.savepoints[sname]
+		if !ok {
+			return errors.NewNoSavepointError(sname)
+		}
+	}
+	if pos >= len(txn.log) {
+		return nil
+	}
+
+	for i := pos; i < len(txn.log); i++ {
+		txn.log[i] = nil
+	}
+	txn.log = txn.log[:pos]
+	for s, p := range txn.savepoints {
+		if p > pos {
+			delete(txn.savepoints, s)
+		}
+	}
+	if txn.stmtStart > pos {
+		txn.stmtStart = pos
+	}
+
+	txn.deltas = make(map[string]map[string]*txMutation, len(txn.deltas))
+	for _, m := range txn.log {
+		txn.setDelta(m)
+	}
+	return nil
+}
+
+// must be called with the transaction lock held
+func (txn *fileTransaction) setDelta(m *txMutation) {
+	name := m.keyspace.QualifiedName()
+	keys := txn.deltas[name]
+	if keys == nil {
+		keys = make(map[string]*txMutation)
+		txn.deltas[name] = keys
+	}
+	keys[m.key] = m
+}
+
+// the transaction's view of a document
+// found is false if the transaction has not modified the document
+func (txn *fileTransaction) fetch(b *keyspace, key string) (item value.AnnotatedValue, found bool) {
+	txn.RLock()
+	m := txn.deltas[b.QualifiedName()][key]
+	txn.RUnlock()
+
+	if m == nil {
+		return nil, false
+	}
+	if m.doc == nil {
+		return nil, true
+	}
+	item = value.NewAnnotatedValue(value.NewValue(m.doc))
+	item.SetId(key)
+	return item, true
+}
+
+// must be called with the transaction lock held
+func (txn *fileTransaction) exists(b *keyspace, key string) (exists, fresh bool) {
+	if m := txn.deltas[b.QualifiedName()][key]; m != nil {
+		return m.doc != nil, m.fresh
+	}
+	_, err := os.Stat(filepath.Join(b.path(), key+".json"))
+	return err == nil, err != nil
+}
+
+func (txn *fileTransaction) performOp(b *keyspace, op int, kvPairs []value.Pair) ([]value.Pair, errors.Error) {
+	if len(kvPairs) == 0 && op != DELETE {
+		return nil, errors.NewFileNoKeysInsertError(nil, "keyspace "+b.Name())
+	}
+
+	txn.Lock()
+	defer txn.Unlock()
+
+	mutated := make([]value.Pair, 0, len(kvPairs))
+	var returnErr errors.Error
+	for _, kv := range kvPairs {
+		exists, fresh := txn.exists(b, kv.Name)
+
+		var err error
+		switch op {
+		case INSERT:
+			if exists {
+				err = errors.NewFileKeyExists(nil, "Key (File) "+kv.Name)
+			}
+		case UPDATE:
+			if !exists {
+				err = fmt.Errorf("key %v does not exist", kv.Name)
+			}
+		case DELETE:
+			if !exists {
+				continue
+			}
+		}
+		if err != nil {
+			returnErr = errors.NewFileDMLError(returnErr, opToString(op)+" Failed "+err.Error())
+			continue
+		}
+
+		m := &txMutation{keyspace: b, key: kv.Name, op: op, fresh: fresh}
+		if op != DELETE {
+			m.doc, err = json.Marshal(kv.Value.Actual())
+			if err != nil {
+				returnErr = errors.NewFileDMLError(returnErr, opToString(op)+" Failed "+err.Error())
+				continue
+			}
+		}
+		txn.log = append(txn.log, m)
+		txn.setDelta(m)
+		mutated = append(mutated, kv)
+	}
+
+	return mutated, returnErr
+}
+
+// journal format
+
+type journal struct {
+	Id        string            `json:"id"`
+	Mutations []journalMutation `json:"mutations"`
+}
+
+type journalMutation struct {
+	Namespace string          `json:"namespace"`
+	Bucket    string          `json:"bucket,omitempty"`
+	Scope     string          `json:"scope,omitempty"`
+	Keyspace  string          `json:"keyspace"`
+	Key       string          `json:"key"`
+	Doc       json.RawMessage `json:"doc,omitempty"`
+}
+
+func (s *store) commit(txn *fileTransaction) errors.Error {
+	txn.Lock()
+	defer txn.Unlock()
+
+	// the net effect of the transaction, in a stable order
+	mutations := make([]*txMutation, 0, len(txn.log))
+	keyspaces := make(map[string]*keyspace, len(txn.deltas))
+	for _, m := range txn.log {
+		if txn.deltas[m.keyspace.QualifiedName()][m.key] == m {
+			mutations = append(mutations, m)
+			keyspaces[m.keyspace.QualifiedName()] = m.keyspace
+		}
+	}
+	if len(mutations) == 0 {
+		return nil
+	}
+
+	// hold off other writers while we validate and apply
+	names := make([]string, 0, len(keyspaces))
+	for name, _ := range keyspaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		keyspaces[name].fileLock.Lock()
+		defer keyspaces[name].fileLock.Unlock()
+	}
+
+	j := &journal{Id: txn.id, Mutations: make([]journalMutation, 0, len(mutations))}
+	for _, m := range mutations {
+		if m.fresh && m.doc != nil {
+			if _, err := os.Stat(filepath.Join(m.keyspace.path(), m.key+".json")); err == nil {
+				return errors.NewCommitTransactionError(errors.NewFileKeyExists(nil, "Key (File) "+m.key), nil)
+			}
+		}
+		jm := journalMutation{Namespace: m.keyspace.namespace.name, Keyspace: m.keyspace.name,
+			Key: m.key, Doc: m.doc}
+		if m.keyspace.scope != nil {
+			jm.Bucket = m.keyspace.scope.bucket.name
+			jm.Scope = m.keyspace.scope.name
+		}
+		j.Mutations = append(j.Mutations, jm)
+	}
+
+	path := filepath.Join(mutations[0].keyspace.namespace.path(), txn.id+_JOURNAL_SUFFIX)
+	if err := writeJournal(path, j); err != nil {
+		return errors.NewCommitTransactionError(err, nil)
+	}
+
+	// the transaction is now durable: errors from here on are repaired on restart
+	var applyErr error
+	for _, m := range mutations {
+		if err := applyMutation(filepath.Join(m.keyspace.path(), m.key+".json"), m.doc); err != nil {
+			applyErr = err
+			continue
+		}
+		if m.doc == nil {
+			m.keyspace.fi.documentDeleted(m.key)
+		} else {
+			m.keyspace.fi.documentChanged(m.key, m.doc)
+		}
+	}
+	if applyErr != nil {
+		return errors.NewPostCommitTransactionError(applyErr, nil)
+	}
+
+	if err := os.Remove(path); err != nil {
+		logging.Infof("File datastore: unable to remove transaction journal %v: %v", path, err)
+	}
+	txn.log = nil
+	txn.deltas = nil
+	return nil
+}
+
+func writeJournal(path string, j *journal) error {
+	bytes, err := json.Marshal(j)
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(bytes)
+	if err == nil {
+		err = file.Sync()
+	}
+	if cerr := file.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+// write or, if doc is nil, remove a document
+func applyMutation(filename string, doc []byte) error {
+	if doc == nil {
+		err := os.Remove(filename)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+		return err
+	}
+	return ioutil.WriteFile(filename, doc, 0666)
+}
+
+// replay the journals of transactions that committed, but were not
+// completely applied, and discard incomplete ones
+// this happens before keyspaces are loaded, so indexes are built
+// from the recovered documents
+func (s *store) recoverTransactions() errors.Error {
+	dirEntries, er := ioutil.ReadDir(s.path)
+	if er != nil {
+		return errors.NewFileDatastoreError(er, "")
+	}
+
+	for _, dirEntry := range dirEntries {
+		if !dirEntry.IsDir() {
+			continue
+		}
+		nsPath := filepath.Join(s.path, dirEntry.Name())
+		files, er := ioutil.ReadDir(nsPath)
+		if er != nil {
+			return errors.NewFileDatastoreError(er, "")
+		}
+		for _, f := range files {
+			path := filepath.Join(nsPath, f.Name())
+			switch {
+			case f.IsDir():
+			case strings.HasSuffix(f.Name(), _JOURNAL_SUFFIX+".tmp"):
+				os.Remove(path)
+			case strings.HasSuffix(f.Name(), _JOURNAL_SUFFIX):
+				if err := s.replayJournal(path); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (s *store) replayJournal(path string) errors.Error {
+	bytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.NewFileDatastoreError(err, path)
+	}
+
+	var j journal
+	if err = json.Unmarshal(bytes, &j); err != nil {
+		return errors.NewFileDatastoreError(err, path)
+	}
+
+	logging.Infof("File datastore: recovering transaction %v", j.Id)
+	for _, m := range j.Mutations {
+		filename := filepath.Join(s.path, m.Namespace, m.Bucket, m.Scope, m.Keyspace, m.Key+".json")
+		var doc []byte
+		if len(m.Doc) > 0 {
+			doc = m.Doc
+		}
+		if err = applyMutation(filename, doc); err != nil {
+			return errors.NewFileDatastoreError(err, path)
+		}
+	}
+
+	if err = os.Remove(path); err != nil {
+		return errors.NewFileDatastoreError(err, path)
+	}
+	return nil
+}
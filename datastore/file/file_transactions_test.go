@@ -0,0 +1,167 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package file
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/transactions"
+	"github.com/couchbase/query/value"
+)
+
+func TestFileTransactions(t *testing.T) {
+	dir, er := ioutil.TempDir("", "filetxn")
+	if er != nil {
+		t.Fatalf("failed to create directory: %v", er)
+	}
+	defer os.RemoveAll(dir)
+
+	ksPath := filepath.Join(dir, "default", "people")
+	if er = os.MkdirAll(ksPath, 0777); er != nil {
+		t.Fatalf("failed to create keyspace: %v", er)
+	}
+	if er = ioutil.WriteFile(filepath.Join(ksPath, "ann.json"), []byte(`{"name":"ann"}`), 0666); er != nil {
+		t.Fatalf("failed to write document: %v", er)
+	}
+
+	keyspace := openTestKeyspace(t, dir)
+	store := keyspace.namespace.store
+	context := &txQueryContext{testingContext: testingContext{t}}
+	context.txContext = transactions.NewTxContext(false, nil, time.Minute, 0, 0,
+		datastore.DEF_DURABILITY_LEVEL, datastore.IL_READ_COMMITTED, datastore.UNBOUNDED, "", 0, 0)
+
+	if _, err := store.StartTransaction(false, context); err != nil {
+		t.Fatalf("failed to start transaction: %v", err)
+	}
+	if context.txContext.TxId() == "" {
+		t.Errorf("expected a transaction id")
+	}
+
+	bob := value.Pair{Name: "bob", Value: value.NewValue(map[string]interface{}{"name": "bob"})}
+	if _, err := keyspace.Insert([]value.Pair{bob}, context); err != nil {
+		t.Fatalf("failed to insert: %v", err)
+	}
+	if _, err := keyspace.Delete([]value.Pair{value.Pair{Name: "ann"}}, context); err != nil {
+		t.Fatalf("failed to delete: %v", err)
+	}
+
+	// read your own writes, without touching the keyspace directory
+	checkFetch(t, keyspace, context, map[string]bool{"ann": false, "bob": true})
+	checkFetch(t, keyspace, datastore.NULL_QUERY_CONTEXT, map[string]bool{"ann": true, "bob": false})
+
+	conn := datastore.NewIndexConnection(context)
+	go store.TransactionDeltaKeyScan(keyspace.QualifiedName(), conn)
+	deltas := map[string]bool{}
+	for {
+		entry, ok := conn.Sender().GetEntry()
+		if !ok || entry == nil {
+			break
+		}
+		deltas[entry.PrimaryKey] = entry.MetaData != nil
+	}
+	if len(deltas) != 2 || deltas["bob"] || !deltas["ann"] {
+		t.Errorf("unexpected delta keys %v", deltas)
+	}
+
+	// savepoints and statement atomicity
+	if err := store.SetSavepoint(false, context, "s1"); err != nil {
+		t.Fatalf("failed to set savepoint: %v", err)
+	}
+	store.StartTransaction(true, context)
+	cat := value.Pair{Name: "cat", Value: value.NewValue(map[string]interface{}{"name": "cat"})}
+	keyspace.Upsert([]value.Pair{cat}, context)
+	store.RollbackTransaction(true, context, "")
+	checkFetch(t, keyspace, context, map[string]bool{"cat": false})
+
+	keyspace.Upsert([]value.Pair{cat}, context)
+	checkFetch(t, keyspace, context, map[string]bool{"cat": true})
+	if err := store.RollbackTransaction(false, context, "s1"); err != nil {
+		t.Fatalf("failed to roll back to savepoint: %v", err)
+	}
+	checkFetch(t, keyspace, context, map[string]bool{"ann": false, "bob": true, "cat": false})
+	if err := store.RollbackTransaction(false, context, "s2"); err == nil {
+		t.Errorf("expected rollback to an unknown savepoint to fail")
+	}
+
+	if err := store.CommitTransaction(false, context); err != nil {
+		t.Fatalf("failed to commit: %v", err)
+	}
+	checkFetch(t, keyspace, datastore.NULL_QUERY_CONTEXT, map[string]bool{"ann": false, "bob": true, "cat": false})
+
+	files, _ := ioutil.ReadDir(filepath.Join(dir, "default"))
+	for _, f := range files {
+		if !f.IsDir() {
+			t.Errorf("unexpected file %v left in namespace directory", f.Name())
+		}
+	}
+
+	// journals are replayed on restart
+	if er = writeJournal(filepath.Join(dir, "default", "t1"+_JOURNAL_SUFFIX), &journal{Id: "t1",
+		Mutations: []journalMutation{
+			journalMutation{Namespace: "default", Keyspace: "people", Key: "bob"},
+			journalMutation{Namespace: "default", Keyspace: "people", Key: "dave", Doc: []byte(`{"name":"dave"}`)},
+		}}); er != nil {
+		t.Fatalf("failed to write journal: %v", er)
+	}
+	keyspace = openTestKeyspace(t, dir)
+	checkFetch(t, keyspace, datastore.NULL_QUERY_CONTEXT, map[string]bool{"bob": false, "dave": true})
+}
+
+func checkFetch(t *testing.T, keyspace *keyspace, context datastore.QueryContext, expected map[string]bool) {
+	keys := make([]string, 0, len(expected))
+	for k, _ := range expected {
+		keys = append(keys, k)
+	}
+	docs := make(map[string]value.AnnotatedValue, len(keys))
+	if errs := keyspace.Fetch(keys, docs, context, nil); len(errs) > 0 {
+		t.Fatalf("failed to fetch: %v", errs)
+	}
+	for k, exists := range expected {
+		if _, ok := docs[k]; ok != exists {
+			t.Errorf("document %v: expected presence %v, got %v", k, exists, ok)
+		}
+	}
+}
+
+// a scan context that is also a query context executing in a transaction
+type txQueryContext struct {
+	testingContext
+	txContext *transactions.TranContext
+}
+
+func (this *txQueryContext) Credentials() *auth.Credentials {
+	return auth.NewCredentials()
+}
+
+func (this *txQueryContext) AuthenticatedUsers() []string {
+	return nil
+}
+
+func (this *txQueryContext) GetTxContext() interface{} {
+	return this.txContext
+}
+
+func (this *txQueryContext) SetTxContext(tc interface{}) {
+	this.txContext, _ = tc.(*transactions.TranContext)
+}
+
+func (this *txQueryContext) Datastore() datastore.Datastore {
+	return nil
+}
+
+func (this *txQueryContext) TxDataVal() value.Value {
+	return nil
+}
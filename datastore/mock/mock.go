@@ -23,9 +23,11 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"github.com/couchbase/query/auth"
 	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/statistics"
 	"github.com/couchbase/query/datastore/virtual"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/expression"
@@ -46,6 +48,8 @@ type store struct {
 	namespaces     map[string]*namespace
 	namespaceNames []string
 	params         map[string]int
+	cboStats       *keyspace
+	cboStatsLock   sync.Mutex
 }
 
 func (s *store) Id() string {
@@ -105,7 +109,7 @@ func (s *store) Inferencers() ([]datastore.Inferencer, errors.Error) {
 }
 
 func (s *store) StatUpdater() (datastore.StatUpdater, errors.Error) {
-	return nil, errors.NewOtherNotImplementedError(nil, "UPDATE STATISTICS")
+	return statistics.NewStatUpdater(s), nil
 }
 
 func (s *store) SetConnectionSecurityConfig(conSecConfig *datastore.ConnectionSecurityConfig) {
@@ -149,18 +153,6 @@ func (s *store) GetRolesAll() ([]datastore.Role, errors.Error) {
 	return nil, errors.NewOtherNotImplementedError(nil, "GetRolesAll")
 }
 
-func (s *store) CreateSystemCBOStats(requestId string) errors.Error {
-	return nil
-}
-
-func (s *store) GetSystemCBOStats() (datastore.Keyspace, errors.Error) {
-	return nil, nil
-}
-
-func (s *store) HasSystemCBOStats() (bool, errors.Error) {
-	return false, nil
-}
-
 func (s *store) StartTransaction(stmtAtomicity bool, context datastore.QueryContext) (map[string]bool, errors.Error) {
 	return nil, errors.NewTranDatastoreNotSupportedError("mock")
 }
@@ -266,6 +258,7 @@ type keyspace struct {
 	name      string
 	nitems    int
 	mi        datastore.Indexer
+	docs      *documents // stored documents, for keyspaces that are not generated
 }
 
 func (b *keyspace) NamespaceId() string {
@@ -330,6 +323,9 @@ func (this *keyspace) Stats(context datastore.QueryContext, which []datastore.Ke
 }
 
 func (b *keyspace) Count(context datastore.QueryContext) (int64, errors.Error) {
+	if b.docs != nil {
+		return b.docs.count(), nil
+	}
 	return int64(b.nitems), nil
 }
 
@@ -349,6 +345,11 @@ func (b *keyspace) Fetch(keys []string, keysMap map[string]value.AnnotatedValue,
 	context datastore.QueryContext, subPaths []string) []errors.Error {
 	var errs []errors.Error
 
+	if b.docs != nil {
+		b.docs.fetch(keys, keysMap)
+		return nil
+	}
+
 	for _, k := range keys {
 		item, e := b.fetchOne(k)
 		if e != nil {
@@ -390,21 +391,33 @@ func genItem(i int, nitems int) (value.AnnotatedValue, errors.Error) {
 }
 
 func (b *keyspace) Insert(inserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	if b.docs != nil {
+		return b.docs.performOp(_INSERT, inserts)
+	}
 	// FIXME
 	return nil, errors.NewOtherNotImplementedError(nil, "for Mock datastore")
 }
 
 func (b *keyspace) Update(updates []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	if b.docs != nil {
+		return b.docs.performOp(_UPDATE, updates)
+	}
 	// FIXME
 	return nil, errors.NewOtherNotImplementedError(nil, "for Mock datastore")
 }
 
 func (b *keyspace) Upsert(upserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	if b.docs != nil {
+		return b.docs.performOp(_UPSERT, upserts)
+	}
 	// FIXME
 	return nil, errors.NewOtherNotImplementedError(nil, "for Mock datastore")
 }
 
 func (b *keyspace) Delete(deletes []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	if b.docs != nil {
+		return b.docs.performOp(_DELETE, deletes)
+	}
 	// FIXME
 	return nil, errors.NewOtherNotImplementedError(nil, "for Mock datastore")
 }
@@ -712,6 +725,17 @@ func (pi *primaryIndex) ScanEntries(requestId string, limit int64, cons datastor
 	vector timestamp.Vector, conn *datastore.IndexConnection) {
 	defer conn.Sender().Close()
 
+	if pi.keyspace.docs != nil {
+		for i, key := range pi.keyspace.docs.keys() {
+			if limit > 0 && int64(i) >= limit {
+				break
+			}
+			entry := datastore.IndexEntry{PrimaryKey: key}
+			conn.Sender().SendEntry(&entry)
+		}
+		return
+	}
+
 	if limit == 0 {
 		limit = int64(pi.keyspace.nitems)
 	}
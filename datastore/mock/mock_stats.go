@@ -0,0 +1,132 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package mock
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/value"
+)
+
+// optimizer statistics are kept in an in-memory keyspace of the first namespace,
+// which is not listed amongst the namespace's keyspaces
+const _SYSTEM_CBO_STATS = "N1QL_CBO_STATS"
+
+func (s *store) CreateSystemCBOStats(requestId string) errors.Error {
+	s.cboStatsLock.Lock()
+	defer s.cboStatsLock.Unlock()
+
+	if s.cboStats != nil {
+		return nil
+	}
+	if len(s.namespaceNames) == 0 {
+		return errors.NewOtherNamespaceNotFoundError(nil, "for Mock datastore")
+	}
+
+	b := &keyspace{namespace: s.namespaces[s.namespaceNames[0]], name: _SYSTEM_CBO_STATS,
+		docs: &documents{docs: make(map[string]value.Value)}}
+	b.mi = newMockIndexer(b)
+	b.mi.CreatePrimaryIndex("", "#primary", nil)
+	s.cboStats = b
+	return nil
+}
+
+func (s *store) GetSystemCBOStats() (datastore.Keyspace, errors.Error) {
+	s.cboStatsLock.Lock()
+	defer s.cboStatsLock.Unlock()
+
+	if s.cboStats == nil {
+		return nil, nil
+	}
+	return s.cboStats, nil
+}
+
+func (s *store) HasSystemCBOStats() (bool, errors.Error) {
+	s.cboStatsLock.Lock()
+	defer s.cboStatsLock.Unlock()
+	return s.cboStats != nil, nil
+}
+
+const (
+	_INSERT = iota
+	_UPDATE
+	_UPSERT
+	_DELETE
+)
+
+// documents held in memory
+type documents struct {
+	sync.RWMutex
+	docs map[string]value.Value
+}
+
+func (this *documents) count() int64 {
+	this.RLock()
+	defer this.RUnlock()
+	return int64(len(this.docs))
+}
+
+func (this *documents) keys() []string {
+	this.RLock()
+	rv := make([]string, 0, len(this.docs))
+	for k, _ := range this.docs {
+		rv = append(rv, k)
+	}
+	this.RUnlock()
+	sort.Strings(rv)
+	return rv
+}
+
+func (this *documents) fetch(keys []string, keysMap map[string]value.AnnotatedValue) {
+	this.RLock()
+	defer this.RUnlock()
+
+	for _, k := range keys {
+		doc, ok := this.docs[k]
+		if !ok {
+			continue
+		}
+		item := value.NewAnnotatedValue(doc.CopyForUpdate())
+		item.SetId(k)
+		keysMap[k] = item
+	}
+}
+
+func (this *documents) performOp(op int, pairs []value.Pair) ([]value.Pair, errors.Error) {
+	this.Lock()
+	defer this.Unlock()
+
+	rv := make([]value.Pair, 0, len(pairs))
+	for _, pair := range pairs {
+		_, exists := this.docs[pair.Name]
+		switch op {
+		case _INSERT:
+			if exists {
+				return rv, errors.NewOtherDatastoreError(nil, "Duplicate key "+pair.Name)
+			}
+		case _UPDATE:
+			if !exists {
+				return rv, errors.NewOtherKeyNotFoundError(nil, pair.Name)
+			}
+		case _DELETE:
+			if exists {
+				delete(this.docs, pair.Name)
+				rv = append(rv, pair)
+			}
+			continue
+		}
+		this.docs[pair.Name] = pair.Value.CopyForUpdate()
+		rv = append(rv, pair)
+	}
+	return rv, nil
+}
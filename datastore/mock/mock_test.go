@@ -15,7 +15,9 @@ import (
 	"time"
 
 	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/statistics"
 	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/value"
 )
 
@@ -264,3 +266,68 @@ func doIndexScan(t *testing.T, b datastore.Keyspace, span *datastore.Span) (
 
 	return
 }
+
+func TestMockUpdateStatistics(t *testing.T) {
+	s, err := NewDatastore("mock:items=500")
+	if err != nil {
+		t.Fatalf("failed to create store: %v", err)
+	}
+
+	p, _ := s.NamespaceById("p0")
+	b, _ := p.KeyspaceById("b0")
+
+	updater, err := s.StatUpdater()
+	if err != nil {
+		t.Fatalf("failed to get stat updater: %v", err)
+	}
+
+	key := expression.NewIdentifier("i")
+	conn := datastore.NewValueConnection(datastore.NULL_CONTEXT)
+	go updater.UpdateStatistics(b, nil, expression.Expressions{key},
+		value.NewValue(map[string]interface{}{"resolution": 5}), conn, nil, false)
+	for range conn.ValueChannel() {
+	}
+
+	if ok, _ := s.HasSystemCBOStats(); !ok {
+		t.Fatalf("expected the system stats keyspace to have been created")
+	}
+
+	h, err := statistics.GetHistogram(s, b.QualifiedName(), key)
+	if err != nil || h == nil {
+		t.Fatalf("expected a histogram for i: %v", err)
+	}
+	if h.SampleSize() != 500 || h.DocCount() != 500 {
+		t.Errorf("unexpected sample size %v or document count %v", h.SampleSize(), h.DocCount())
+	}
+	if len(h.Distrib()) != 20 || len(h.Ovrflow()) != 0 {
+		t.Errorf("expected 20 distribution bins, got %v and %v overflow bins", len(h.Distrib()), len(h.Ovrflow()))
+	}
+	if max := h.Distrib()[len(h.Distrib())-1].Max(); !max.Equals(value.NewValue(499)).Truth() {
+		t.Errorf("unexpected maximum %v", max)
+	}
+
+	// the sample is drawn from the whole keyspace, not just the first keys scanned
+	conn = datastore.NewValueConnection(datastore.NULL_CONTEXT)
+	go updater.UpdateStatistics(b, nil, expression.Expressions{key},
+		value.NewValue(map[string]interface{}{"sample_size": 100}), conn, nil, false)
+	for range conn.ValueChannel() {
+	}
+	h, err = statistics.GetHistogram(s, b.QualifiedName(), key)
+	if err != nil || h == nil {
+		t.Fatalf("expected a histogram for i: %v", err)
+	}
+	if h.SampleSize() != 100 || h.DocCount() != 500 {
+		t.Errorf("unexpected sample size %v or document count %v", h.SampleSize(), h.DocCount())
+	}
+	if max := h.Distrib()[len(h.Distrib())-1].Max(); max.Collate(value.NewValue(99)) <= 0 {
+		t.Errorf("expected a random sample, got maximum %v", max)
+	}
+
+	conn = datastore.NewValueConnection(datastore.NULL_CONTEXT)
+	go updater.DeleteStatistics(b, nil, conn, nil)
+	for range conn.ValueChannel() {
+	}
+	if h, _ = statistics.GetHistogram(s, b.QualifiedName(), key); h != nil {
+		t.Errorf("expected the histogram to have been deleted")
+	}
+}
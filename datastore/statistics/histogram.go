@@ -0,0 +1,233 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package statistics
+
+import (
+	"sort"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/value"
+)
+
+// values of each key over the sampled documents
+type samples struct {
+	keys    expression.Expressions
+	arrays  []bool
+	values  []value.Values
+	arrLens []int64
+	missing []int64 // documents where an array key is MISSING or not an array
+	empty   []int64 // documents where an array key is empty
+	docs    int64
+	context expression.Context
+}
+
+func newSamples(keys expression.Expressions) *samples {
+	rv := &samples{
+		keys:    keys,
+		arrays:  make([]bool, len(keys)),
+		values:  make([]value.Values, len(keys)),
+		arrLens: make([]int64, len(keys)),
+		missing: make([]int64, len(keys)),
+		empty:   make([]int64, len(keys)),
+		context: expression.NewIndexContext(),
+	}
+	for i, key := range keys {
+		rv.arrays[i], _ = key.IsArrayIndexKey()
+	}
+	return rv
+}
+
+func (this *samples) add(doc value.AnnotatedValue) {
+	this.docs++
+	for i, key := range this.keys {
+		v, vals, err := key.EvaluateForIndex(doc, this.context)
+		if err != nil {
+			logging.Debugf("UPDATE STATISTICS: unable to evaluate %v: %v", key, err)
+			continue
+		}
+
+		if !this.arrays[i] {
+			if v != nil && v.Type() != value.MISSING {
+				this.values[i] = append(this.values[i], v)
+			}
+			continue
+		}
+
+		if vals == nil && v != nil && v.Type() != value.MISSING {
+			vals = value.Values{v}
+		}
+		switch {
+		case vals == nil:
+			this.missing[i]++
+		case len(vals) == 0:
+			this.empty[i]++
+		}
+		this.arrLens[i] += int64(len(vals))
+		for _, av := range vals {
+			if av.Type() != value.MISSING {
+				this.values[i] = append(this.values[i], av)
+			}
+		}
+	}
+}
+
+// build the histogram of the i-th key
+// bin sizes are fractions of the sampled documents: values occurring more
+// often than the nominal bin size get an overflow bin of their own, the
+// rest are split into distribution bins of about equal size, never
+// splitting a value across bins
+func (this *samples) histogram(i int, keyspace string, docCount int64, resolution float64) *datastore.Histogram {
+	vals := this.values[i]
+	sort.Slice(vals, func(a, b int) bool {
+		return vals[a].Collate(vals[b]) < 0
+	})
+
+	type run struct {
+		val   value.Value
+		count int
+	}
+	runs := make([]run, 0, len(vals))
+	for _, v := range vals {
+		if n := len(runs); n > 0 && runs[n-1].val.Collate(v) == 0 {
+			runs[n-1].count++
+		} else {
+			runs = append(runs, run{val: v, count: 1})
+		}
+	}
+
+	var distrib datastore.DistBins
+	var ovrflow datastore.OverflowBins
+	var fdistincts float64
+	if this.docs > 0 && len(runs) > 0 {
+		docs := float64(this.docs)
+		distincts := float64(len(runs))
+		binSize := float64(len(vals)) * resolution / 100.0
+		fdistincts = distincts / float64(len(vals))
+
+		var size, distinct int
+		for n, r := range runs {
+			if float64(r.count) > binSize {
+				ovrflow = append(ovrflow, datastore.NewOverflowBin(float64(r.count)/docs, r.val))
+			} else {
+				size += r.count
+				distinct++
+			}
+			if distinct > 0 && (float64(size) >= binSize || n == len(runs)-1) {
+				distrib = append(distrib, datastore.NewDistBin(float64(size)/docs,
+					float64(distinct)/distincts, r.val))
+				size, distinct = 0, 0
+			}
+		}
+	}
+
+	var avgArrayLen, missingArr, emptyArr float64
+	if this.arrays[i] && this.docs > 0 {
+		docs := float64(this.docs)
+		if nonMissing := this.docs - this.missing[i]; nonMissing > 0 {
+			avgArrayLen = float64(this.arrLens[i]) / float64(nonMissing)
+		}
+		missingArr = float64(this.missing[i]) / docs
+		emptyArr = float64(this.empty[i]) / docs
+	}
+
+	h := &datastore.Histogram{}
+	h.SetHistogram(datastore.HISTOGRAM_VERSION, keyspace, this.keys[i], docCount, this.docs, resolution,
+		fdistincts, avgArrayLen, missingArr, emptyArr, distrib, ovrflow)
+	return h
+}
+
+// stored form of a histogram
+func encodeHistogram(h *datastore.Histogram) map[string]interface{} {
+	distrib := make([]interface{}, len(h.Distrib()))
+	for i, b := range h.Distrib() {
+		distrib[i] = map[string]interface{}{"size": b.Size(), "distinct": b.Distinct(), "max": b.Max()}
+	}
+	ovrflow := make([]interface{}, len(h.Ovrflow()))
+	for i, b := range h.Ovrflow() {
+		ovrflow[i] = map[string]interface{}{"size": b.Size(), "val": b.Val()}
+	}
+
+	rv := map[string]interface{}{
+		"version":    int64(h.Version()),
+		"key":        h.Key().String(),
+		"docCount":   h.DocCount(),
+		"sampleSize": h.SampleSize(),
+		"resolution": h.Resolution(),
+		"fdistincts": h.Fdistincts(),
+		"distrib":    distrib,
+		"ovrflow":    ovrflow,
+	}
+	if ai := h.ArrayInfo(); ai != nil {
+		rv["avgArrayLen"] = ai.AvgArrayLen()
+		rv["missingArr"] = ai.MissingArray()
+		rv["emptyArr"] = ai.EmptyArray()
+	}
+	if h.IsInternal() {
+		rv["internal"] = true
+	}
+	return rv
+}
+
+func decodeHistogram(keyspace string, key expression.Expression, encoded value.Value) (
+	*datastore.Histogram, errors.Error) {
+
+	if encoded.Type() != value.OBJECT {
+		return nil, errors.NewUpdateStatisticsError("Invalid histogram for " + key.String() + " in " + keyspace)
+	}
+
+	number := func(v value.Value, name string) float64 {
+		f, ok := v.Field(name)
+		if !ok || f.Type() != value.NUMBER {
+			return 0.0
+		}
+		return value.AsNumberValue(f).Float64()
+	}
+	field := func(v value.Value, name string) value.Value {
+		f, _ := v.Field(name)
+		return f
+	}
+
+	version := int32(number(encoded, "version"))
+	if version > datastore.HISTOGRAM_VERSION {
+		return nil, errors.NewUpdateStatisticsError("Unsupported histogram version for " + key.String())
+	}
+
+	var distrib datastore.DistBins
+	if bins, ok := field(encoded, "distrib").Actual().([]interface{}); ok {
+		distrib = make(datastore.DistBins, 0, len(bins))
+		for _, b := range bins {
+			bv := value.NewValue(b)
+			distrib = append(distrib, datastore.NewDistBin(number(bv, "size"), number(bv, "distinct"),
+				field(bv, "max")))
+		}
+	}
+
+	var ovrflow datastore.OverflowBins
+	if bins, ok := field(encoded, "ovrflow").Actual().([]interface{}); ok {
+		ovrflow = make(datastore.OverflowBins, 0, len(bins))
+		for _, b := range bins {
+			bv := value.NewValue(b)
+			ovrflow = append(ovrflow, datastore.NewOverflowBin(number(bv, "size"), field(bv, "val")))
+		}
+	}
+
+	h := &datastore.Histogram{}
+	h.SetHistogram(version, keyspace, key, int64(number(encoded, "docCount")),
+		int64(number(encoded, "sampleSize")), number(encoded, "resolution"), number(encoded, "fdistincts"),
+		number(encoded, "avgArrayLen"), number(encoded, "missingArr"), number(encoded, "emptyArr"),
+		distrib, ovrflow)
+	if internal, ok := encoded.Field("internal"); ok && internal.Truth() {
+		h.SetInternal()
+	}
+	return h, nil
+}
@@ -0,0 +1,426 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+/*
+Package statistics provides a generic StatUpdater, for datastores that
+do not have one of their own.
+
+UPDATE STATISTICS takes a uniform random sample of documents through a
+primary index of the keyspace, builds a histogram for each term or index
+key, and stores the histograms in the datastore's system CBO stats
+keyspace, one document per keyspace, keyed by the keyspace's qualified
+name.
+*/
+package statistics
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"time"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+)
+
+const (
+	_DEF_RESOLUTION    = 1.0
+	_MIN_RESOLUTION    = 0.02
+	_MAX_RESOLUTION    = 5.0
+	_SAMPLES_PER_BIN   = 100
+	_MIN_SAMPLE_SIZE   = 1000
+	_FETCH_BATCH_SIZE  = 256
+	_HISTOGRAMS_FIELD  = "histograms"
+	_KEYSPACE_FIELD    = "keyspace"
+	_UPDSTAT_GENERIC   = datastore.StatUpdaterType("generic")
+	_STATS_REQUEST_ID  = "update_statistics"
+	_OPT_RESOLUTION    = "resolution"
+	_OPT_SAMPLE_SIZE   = "sample_size"
+	_OPT_UPDATE_TIMOUT = "update_statistics_timeout"
+	_OPT_BATCH_SIZE    = "batch_size"
+)
+
+type statUpdater struct {
+	store datastore.Datastore
+}
+
+// NewStatUpdater returns a StatUpdater storing histograms in the
+// system CBO stats keyspace of store, which is created on first use
+func NewStatUpdater(store datastore.Datastore) datastore.StatUpdater {
+	return &statUpdater{store: store}
+}
+
+func (this *statUpdater) Name() datastore.StatUpdaterType {
+	return _UPDSTAT_GENERIC
+}
+
+func (this *statUpdater) UpdateStatistics(ks datastore.Keyspace, indexes []datastore.Index,
+	terms expression.Expressions, with value.Value, conn *datastore.ValueConnection,
+	exContext interface{}, internal bool) {
+	defer close(conn.ValueChannel())
+
+	resolution, sampleSize, err := getOptions(with)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+
+	keys := statisticsKeys(indexes, terms)
+	if len(keys) == 0 {
+		return
+	}
+
+	context := queryContext(exContext)
+	statsKeyspace, err := this.statsKeyspace(true)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+
+	docCount, err := ks.Count(context)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+	if sampleSize <= 0 {
+		sampleSize = int64(math.Ceil(100.0/resolution)) * _SAMPLES_PER_BIN
+		if sampleSize < _MIN_SAMPLE_SIZE {
+			sampleSize = _MIN_SAMPLE_SIZE
+		}
+	}
+	if sampleSize > docCount {
+		sampleSize = docCount
+	}
+
+	samples := newSamples(keys)
+	err = sampleKeyspace(ks, sampleSize, exContext, context, conn.StopChannel(), samples.add)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+
+	histograms := make(map[string]*datastore.Histogram, len(keys))
+	for i, key := range keys {
+		h := samples.histogram(i, ks.QualifiedName(), docCount, resolution)
+		if internal {
+			h.SetInternal()
+		}
+		histograms[key.String()] = h
+	}
+
+	// stats are not part of any transaction the request may be in
+	err = storeHistograms(statsKeyspace, ks.QualifiedName(), histograms, nil, datastore.NULL_QUERY_CONTEXT)
+	if err != nil {
+		conn.Error(err)
+	}
+}
+
+func (this *statUpdater) DeleteStatistics(ks datastore.Keyspace, terms expression.Expressions,
+	conn *datastore.ValueConnection, exContext interface{}) {
+	defer close(conn.ValueChannel())
+
+	statsKeyspace, err := this.statsKeyspace(false)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+	if statsKeyspace == nil {
+		return
+	}
+
+	context := datastore.NULL_QUERY_CONTEXT
+	if len(terms) == 0 {
+		_, err = statsKeyspace.Delete([]value.Pair{value.Pair{Name: ks.QualifiedName()}}, context)
+	} else {
+		deletes := make([]string, len(terms))
+		for i, term := range terms {
+			deletes[i] = term.String()
+		}
+		err = storeHistograms(statsKeyspace, ks.QualifiedName(), nil, deletes, context)
+	}
+	if err != nil {
+		conn.Error(err)
+	}
+}
+
+func (this *statUpdater) statsKeyspace(create bool) (datastore.Keyspace, errors.Error) {
+	ok, err := this.store.HasSystemCBOStats()
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		if !create {
+			return nil, nil
+		}
+		if err = this.store.CreateSystemCBOStats(_STATS_REQUEST_ID); err != nil {
+			return nil, err
+		}
+	}
+
+	ks, err := this.store.GetSystemCBOStats()
+	if err == nil && ks == nil {
+		err = errors.NewMissingSystemCBOStatsError()
+	}
+	return ks, err
+}
+
+func getOptions(with value.Value) (resolution float64, sampleSize int64, err errors.Error) {
+	resolution = _DEF_RESOLUTION
+	if with == nil {
+		return
+	}
+	if with.Type() != value.OBJECT {
+		return 0, 0, errors.NewUpdateStatisticsError("WITH clause must be an object")
+	}
+
+	for name, _ := range with.Fields() {
+		v, _ := with.Field(name)
+		switch name {
+		case _OPT_RESOLUTION:
+			if v.Type() != value.NUMBER {
+				return 0, 0, errors.NewUpdateStatisticsError(_OPT_RESOLUTION + " must be a number")
+			}
+			r := value.AsNumberValue(v).Float64()
+			if r < _MIN_RESOLUTION || r > _MAX_RESOLUTION {
+				return 0, 0, errors.NewUpdateStatisticsError(fmt.Sprintf(
+					"%s must be a number between %v and %v", _OPT_RESOLUTION, _MIN_RESOLUTION, _MAX_RESOLUTION))
+			}
+			resolution = r
+		case _OPT_SAMPLE_SIZE:
+			if v.Type() != value.NUMBER {
+				return 0, 0, errors.NewUpdateStatisticsError(_OPT_SAMPLE_SIZE + " must be a positive integer")
+			}
+			s := value.AsNumberValue(v).Float64()
+			if s <= 0 || s != math.Trunc(s) {
+				return 0, 0, errors.NewUpdateStatisticsError(_OPT_SAMPLE_SIZE + " must be a positive integer")
+			}
+			sampleSize = int64(s)
+		case _OPT_UPDATE_TIMOUT, _OPT_BATCH_SIZE:
+			// accepted for compatibility, sampling is local
+		default:
+			return 0, 0, errors.NewUpdateStatisticsError("Invalid option in WITH clause: " + name)
+		}
+	}
+	return
+}
+
+// the keys to collect statistics for, without duplicates
+func statisticsKeys(indexes []datastore.Index, terms expression.Expressions) expression.Expressions {
+	keys := make(expression.Expressions, 0, len(terms))
+	seen := make(map[string]bool, len(terms))
+	add := func(key expression.Expression) {
+		s := key.String()
+		if !seen[s] {
+			seen[s] = true
+			keys = append(keys, key)
+		}
+	}
+
+	for _, term := range terms {
+		add(term)
+	}
+	for _, index := range indexes {
+		for _, key := range index.RangeKey() {
+			add(key)
+		}
+	}
+	return keys
+}
+
+func queryContext(exContext interface{}) datastore.QueryContext {
+	if context, ok := exContext.(datastore.QueryContext); ok {
+		return context
+	}
+	return datastore.NULL_QUERY_CONTEXT
+}
+
+// scan the keys of the first online primary index, keeping a uniform random
+// sample of sampleSize keys (reservoir sampling), and fetch the sampled documents
+// in batches
+func sampleKeyspace(ks datastore.Keyspace, sampleSize int64, exContext interface{},
+	context datastore.QueryContext, stop datastore.StopChannel, add func(doc value.AnnotatedValue)) errors.Error {
+	if sampleSize <= 0 {
+		return nil
+	}
+
+	keys, err := sampleKeys(ks, sampleSize, exContext, stop)
+	if err != nil || len(keys) == 0 {
+		return err
+	}
+
+	for len(keys) > 0 {
+		select {
+		case <-stop:
+			return nil
+		default:
+		}
+
+		batch := keys
+		if len(batch) > _FETCH_BATCH_SIZE {
+			batch = batch[:_FETCH_BATCH_SIZE]
+		}
+		keys = keys[len(batch):]
+
+		docs := make(map[string]value.AnnotatedValue, len(batch))
+		if errs := ks.Fetch(batch, docs, context, nil); len(errs) > 0 {
+			return errs[0]
+		}
+		for _, key := range batch {
+			if doc, ok := docs[key]; ok && doc != nil {
+				add(doc)
+			}
+		}
+	}
+	return nil
+}
+
+func sampleKeys(ks datastore.Keyspace, sampleSize int64, exContext interface{},
+	stop datastore.StopChannel) ([]string, errors.Error) {
+	primary, err := primaryIndex(ks)
+	if err != nil {
+		return nil, err
+	}
+
+	scanContext, ok := exContext.(datastore.Context)
+	if !ok {
+		scanContext = datastore.NULL_CONTEXT
+	}
+	conn := datastore.NewIndexConnection(scanContext)
+	defer conn.Dispose()
+	defer conn.SendStop()
+	go primary.ScanEntries(_STATS_REQUEST_ID, math.MaxInt64, datastore.UNBOUNDED, nil, conn)
+
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	keys := make([]string, 0, sampleSize)
+	for n := int64(0); ; n++ {
+		select {
+		case <-stop:
+			return nil, nil
+		default:
+		}
+
+		entry, ok := conn.Sender().GetEntry()
+		if !ok || entry == nil {
+			break
+		}
+
+		// the n-th key replaces a random sample with probability sampleSize/(n+1)
+		if n < sampleSize {
+			keys = append(keys, entry.PrimaryKey)
+		} else if r := random.Int63n(n + 1); r < sampleSize {
+			keys[r] = entry.PrimaryKey
+		}
+	}
+	return keys, nil
+}
+
+func primaryIndex(ks datastore.Keyspace) (datastore.PrimaryIndex, errors.Error) {
+	indexers, err := ks.Indexers()
+	if err != nil {
+		return nil, err
+	}
+	for _, indexer := range indexers {
+		primaries, err := indexer.PrimaryIndexes()
+		if err != nil {
+			continue
+		}
+		for _, primary := range primaries {
+			if state, _, err := primary.State(); err == nil && state == datastore.ONLINE {
+				return primary, nil
+			}
+		}
+	}
+	return nil, errors.NewUpdateStatisticsError("No online primary index on " + ks.QualifiedName())
+}
+
+// merge new histograms into, and remove deleted ones from, the keyspace's stats document
+func storeHistograms(statsKeyspace datastore.Keyspace, keyspace string,
+	histograms map[string]*datastore.Histogram, deletes []string, context datastore.QueryContext) errors.Error {
+
+	docs := make(map[string]value.AnnotatedValue, 1)
+	if errs := statsKeyspace.Fetch([]string{keyspace}, docs, context, nil); len(errs) > 0 {
+		return errs[0]
+	}
+
+	stored := make(map[string]interface{}, len(histograms))
+	if doc, ok := docs[keyspace]; ok && doc != nil {
+		if h, ok := doc.Field(_HISTOGRAMS_FIELD); ok && h.Type() == value.OBJECT {
+			for k, v := range h.Fields() {
+				stored[k] = v
+			}
+		}
+	}
+	for k, h := range histograms {
+		stored[k] = encodeHistogram(h)
+	}
+	for _, k := range deletes {
+		delete(stored, k)
+	}
+
+	if len(stored) == 0 {
+		_, err := statsKeyspace.Delete([]value.Pair{value.Pair{Name: keyspace}}, context)
+		return err
+	}
+
+	doc := value.NewValue(map[string]interface{}{
+		_KEYSPACE_FIELD:   keyspace,
+		_HISTOGRAMS_FIELD: stored,
+	})
+	_, err := statsKeyspace.Upsert([]value.Pair{value.Pair{Name: keyspace, Value: doc}}, context)
+	return err
+}
+
+// GetHistogram returns the stored histogram for a key of a keyspace, or nil
+// if there is none. The key is in the form of an index key.
+func GetHistogram(store datastore.Datastore, keyspace string, key expression.Expression) (
+	*datastore.Histogram, errors.Error) {
+
+	h, err := getHistograms(store, keyspace)
+	if err != nil || h == nil {
+		return nil, err
+	}
+	encoded, ok := h.Field(key.String())
+	if !ok {
+		return nil, nil
+	}
+	return decodeHistogram(keyspace, key, encoded)
+}
+
+// HasStatistics reports whether statistics have been gathered for a keyspace
+func HasStatistics(store datastore.Datastore, keyspace string) (bool, errors.Error) {
+	h, err := getHistograms(store, keyspace)
+	return h != nil, err
+}
+
+func getHistograms(store datastore.Datastore, keyspace string) (value.Value, errors.Error) {
+	ok, err := store.HasSystemCBOStats()
+	if err != nil || !ok {
+		return nil, err
+	}
+	statsKeyspace, err := store.GetSystemCBOStats()
+	if err != nil || statsKeyspace == nil {
+		return nil, err
+	}
+
+	docs := make(map[string]value.AnnotatedValue, 1)
+	if errs := statsKeyspace.Fetch([]string{keyspace}, docs, datastore.NULL_QUERY_CONTEXT, nil); len(errs) > 0 {
+		return nil, errs[0]
+	}
+	doc, ok := docs[keyspace]
+	if !ok || doc == nil {
+		return nil, nil
+	}
+	h, ok := doc.Field(_HISTOGRAMS_FIELD)
+	if !ok || h.Type() != value.OBJECT {
+		return nil, nil
+	}
+	return h, nil
+}
@@ -42,6 +42,7 @@ const KEYSPACE_NAME_FUNCTIONS = "functions"
 const KEYSPACE_NAME_DICTIONARY_CACHE = "dictionary_cache"
 const KEYSPACE_NAME_DICTIONARY = "dictionary"
 const KEYSPACE_NAME_REQUESTS = "completed_requests"
+const KEYSPACE_NAME_REQUESTS_HISTORY = "completed_requests_history"
 const KEYSPACE_NAME_ACTIVE = "active_requests"
 const KEYSPACE_NAME_USER_INFO = "user_info"
 const KEYSPACE_NAME_MY_USER_INFO = "my_user_info"
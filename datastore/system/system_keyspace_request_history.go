@@ -0,0 +1,215 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package system
+
+import (
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/server"
+	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/value"
+)
+
+// the completed requests archive of the local node
+type requestHistoryKeyspace struct {
+	keyspaceBase
+	indexer datastore.Indexer
+}
+
+func (b *requestHistoryKeyspace) Release(close bool) {
+}
+
+func (b *requestHistoryKeyspace) NamespaceId() string {
+	return b.namespace.Id()
+}
+
+func (b *requestHistoryKeyspace) Id() string {
+	return b.Name()
+}
+
+func (b *requestHistoryKeyspace) Name() string {
+	return b.name
+}
+
+func (b *requestHistoryKeyspace) Count(context datastore.QueryContext) (int64, errors.Error) {
+	return server.RequestsArchiveCount()
+}
+
+func (b *requestHistoryKeyspace) Size(context datastore.QueryContext) (int64, errors.Error) {
+	return -1, nil
+}
+
+func (b *requestHistoryKeyspace) Indexer(name datastore.IndexType) (datastore.Indexer, errors.Error) {
+	return b.indexer, nil
+}
+
+func (b *requestHistoryKeyspace) Indexers() ([]datastore.Indexer, errors.Error) {
+	return []datastore.Indexer{b.indexer}, nil
+}
+
+func (b *requestHistoryKeyspace) Fetch(keys []string, keysMap map[string]value.AnnotatedValue,
+	context datastore.QueryContext, subPaths []string) (errs []errors.Error) {
+	for _, key := range keys {
+		doc, err := server.RequestsArchiveFetch(key)
+		if err != nil {
+			if errs == nil {
+				errs = make([]errors.Error, 0, 1)
+			}
+			errs = append(errs, err)
+			continue
+		}
+		if doc == nil {
+			continue
+		}
+
+		plan, hasPlan := doc["plan"]
+		estimates, hasEstimates := doc["optimizerEstimates"]
+		delete(doc, "plan")
+		delete(doc, "optimizerEstimates")
+
+		item := value.NewAnnotatedValue(doc)
+		meta := item.NewMeta()
+		meta["keyspace"] = b.fullName
+		if hasPlan {
+			meta["plan"] = plan
+		}
+		if hasEstimates {
+			meta["optimizerEstimates"] = estimates
+		}
+		item.SetId(key)
+		keysMap[key] = item
+	}
+	return
+}
+
+func (b *requestHistoryKeyspace) Insert(inserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	// FIXME
+	return nil, errors.NewSystemNotImplementedError(nil, "")
+}
+
+func (b *requestHistoryKeyspace) Update(updates []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	// FIXME
+	return nil, errors.NewSystemNotImplementedError(nil, "")
+}
+
+func (b *requestHistoryKeyspace) Upsert(upserts []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	// FIXME
+	return nil, errors.NewSystemNotImplementedError(nil, "")
+}
+
+func (b *requestHistoryKeyspace) Delete(deletes []value.Pair, context datastore.QueryContext) ([]value.Pair, errors.Error) {
+	// FIXME
+	return nil, errors.NewSystemNotImplementedError(nil, "")
+}
+
+func newRequestsHistoryKeyspace(p *namespace) (*requestHistoryKeyspace, errors.Error) {
+	b := new(requestHistoryKeyspace)
+	setKeyspaceBase(&b.keyspaceBase, p, KEYSPACE_NAME_REQUESTS_HISTORY)
+
+	primary := &requestHistoryIndex{name: "#primary", keyspace: b}
+	b.indexer = newSystemIndexer(b, primary)
+	setIndexBase(&primary.indexBase, b.indexer)
+
+	return b, nil
+}
+
+type requestHistoryIndex struct {
+	indexBase
+	name     string
+	keyspace *requestHistoryKeyspace
+}
+
+func (pi *requestHistoryIndex) KeyspaceId() string {
+	return pi.keyspace.Id()
+}
+
+func (pi *requestHistoryIndex) Id() string {
+	return pi.Name()
+}
+
+func (pi *requestHistoryIndex) Name() string {
+	return pi.name
+}
+
+func (pi *requestHistoryIndex) Type() datastore.IndexType {
+	return datastore.SYSTEM
+}
+
+func (pi *requestHistoryIndex) SeekKey() expression.Expressions {
+	return nil
+}
+
+func (pi *requestHistoryIndex) RangeKey() expression.Expressions {
+	return nil
+}
+
+func (pi *requestHistoryIndex) Condition() expression.Expression {
+	return nil
+}
+
+func (pi *requestHistoryIndex) IsPrimary() bool {
+	return true
+}
+
+func (pi *requestHistoryIndex) State() (state datastore.IndexState, msg string, err errors.Error) {
+	return datastore.ONLINE, "", nil
+}
+
+func (pi *requestHistoryIndex) Statistics(requestId string, span *datastore.Span) (
+	datastore.Statistics, errors.Error) {
+	return nil, nil
+}
+
+func (pi *requestHistoryIndex) Drop(requestId string) errors.Error {
+	return errors.NewSystemIdxNoDropError(nil, pi.Name())
+}
+
+func (pi *requestHistoryIndex) Scan(requestId string, span *datastore.Span, distinct bool, limit int64,
+	cons datastore.ScanConsistency, vector timestamp.Vector, conn *datastore.IndexConnection) {
+	if span == nil {
+		pi.ScanEntries(requestId, limit, cons, vector, conn)
+		return
+	}
+	defer conn.Sender().Close()
+
+	spanEvaluator, err := compileSpan(span)
+	if err != nil {
+		conn.Error(err)
+		return
+	}
+	pi.scan(limit, conn, spanEvaluator.evaluate)
+}
+
+func (pi *requestHistoryIndex) ScanEntries(requestId string, limit int64, cons datastore.ScanConsistency,
+	vector timestamp.Vector, conn *datastore.IndexConnection) {
+	defer conn.Sender().Close()
+
+	pi.scan(limit, conn, nil)
+}
+
+func (pi *requestHistoryIndex) scan(limit int64, conn *datastore.IndexConnection, filter func(string) bool) {
+	var count int64
+
+	err := server.RequestsArchiveForeach(func(key string) bool {
+		if filter != nil && !filter(key) {
+			return true
+		}
+		entry := datastore.IndexEntry{PrimaryKey: key}
+		if !sendSystemKey(conn, &entry) {
+			return false
+		}
+		count++
+		return limit <= 0 || count < limit
+	})
+	if err != nil {
+		conn.Error(err)
+	}
+}
@@ -103,91 +103,10 @@ func (b *requestLogKeyspace) Fetch(keys []string, keysMap map[string]value.Annot
 
 			// local entry
 			server.RequestDo(localKey, func(entry *server.RequestLogEntry) {
-				item := value.NewAnnotatedValue(map[string]interface{}{
-					"requestId":       localKey,
-					"state":           entry.State,
-					"elapsedTime":     entry.ElapsedTime.String(),
-					"serviceTime":     entry.ServiceTime.String(),
-					"resultCount":     entry.ResultCount,
-					"resultSize":      entry.ResultSize,
-					"errorCount":      entry.ErrorCount,
-					"requestTime":     entry.Time.Format(expression.DEFAULT_FORMAT),
-					"scanConsistency": entry.ScanConsistency,
-				})
+				item := value.NewAnnotatedValue(entry.Format())
 				if node != "" {
 					item.SetField("node", node)
 				}
-				if entry.ClientId != "" {
-					item.SetField("clientContextID", entry.ClientId)
-				}
-				if entry.Statement != "" {
-					item.SetField("statement", entry.Statement)
-				}
-				if entry.QueryContext != "" {
-					item.SetField("queryContext", entry.QueryContext)
-				}
-				if entry.UseFts {
-					item.SetField("useFts", entry.UseFts)
-				}
-				if entry.UseCBO {
-					item.SetField("useCBO", entry.UseCBO)
-				}
-				if entry.TxId != "" {
-					item.SetField("txid", entry.TxId)
-				}
-				if entry.TransactionElapsedTime > 0 {
-					item.SetField("transactionElapsedTime", entry.TransactionElapsedTime.String())
-				}
-				if entry.TransactionRemainingTime > 0 {
-					item.SetField("transactionRemainingTime", entry.TransactionRemainingTime.String())
-				}
-				if entry.PreparedName != "" {
-					item.SetField("preparedName", entry.PreparedName)
-					item.SetField("preparedText", entry.PreparedText)
-				}
-				if entry.Mutations != 0 {
-					item.SetField("mutations", entry.Mutations)
-				}
-				if entry.PhaseTimes != nil {
-					item.SetField("phaseTimes", entry.PhaseTimes)
-				}
-				if entry.PhaseCounts != nil {
-					item.SetField("phaseCounts", entry.PhaseCounts)
-				}
-				if entry.PhaseOperators != nil {
-					item.SetField("phaseOperators", entry.PhaseOperators)
-				}
-				if entry.UsedMemory != 0 {
-					item.SetField("usedMemory", entry.UsedMemory)
-				}
-				if entry.PositionalArgs != nil {
-					item.SetField("positionalArgs", entry.PositionalArgs)
-				}
-				if entry.NamedArgs != nil {
-					item.SetField("namedArgs", entry.NamedArgs)
-				}
-				if entry.Users != "" {
-					item.SetField("users", entry.Users)
-				}
-				if entry.RemoteAddr != "" {
-					item.SetField("remoteAddr", entry.RemoteAddr)
-				}
-				if entry.UserAgent != "" {
-					item.SetField("userAgent", entry.UserAgent)
-				}
-				if entry.Tag != "" {
-					item.SetField("~tag", entry.Tag)
-				}
-				if entry.MemoryQuota != 0 {
-					item.SetField("memoryQuota", entry.MemoryQuota)
-				}
-				if entry.Errors != nil {
-					errors := make([]value.Value, len(entry.Errors))
-					for i, e := range entry.Errors {
-						errors[i] = value.NewValue(e.Object())
-					}
-					item.SetField("errors", errors)
-				}
 
 				meta := item.NewMeta()
 				meta["keyspace"] = b.fullName
@@ -211,6 +211,12 @@ func (p *namespace) loadKeyspaces() (e errors.Error) {
 	}
 	p.keyspaces[reqs.Name()] = reqs
 
+	reqsHistory, e := newRequestsHistoryKeyspace(p)
+	if e != nil {
+		return e
+	}
+	p.keyspaces[reqsHistory.Name()] = reqsHistory
+
 	actives, e := newActiveRequestsKeyspace(p)
 	if e != nil {
 		return e
@@ -162,3 +162,18 @@ func NewAdminBodyError(e error) Error {
 	return &err{level: EXCEPTION, ICode: 2220, IKey: "admin.accounting.bad_body", ICause: e,
 		InternalMsg: "Error getting request body", InternalCaller: CallerN(1)}
 }
+
+func NewCompletedArchiveError(e error, path string) Error {
+	return &err{level: EXCEPTION, ICode: 2230, IKey: "admin.accounting.completed.archive", ICause: e,
+		InternalMsg: fmt.Sprintf("Error accessing completed requests archive in %s", path), InternalCaller: CallerN(1)}
+}
+
+func NewInvalidSchemaError(keyspace string, e error) Error {
+	return &err{level: EXCEPTION, ICode: 2240, IKey: "admin.schemas.invalid", ICause: e,
+		InternalMsg: fmt.Sprintf("Invalid validation schema for %s", keyspace), InternalCaller: CallerN(1)}
+}
+
+func NewSchemaPersistError(e error, path string) Error {
+	return &err{level: EXCEPTION, ICode: 2241, IKey: "admin.schemas.persist", ICause: e,
+		InternalMsg: fmt.Sprintf("Unable to persist validation schemas in %s", path), InternalCaller: CallerN(1)}
+}
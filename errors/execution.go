@@ -299,6 +299,36 @@ func NewIndexNotFoundError(name string, ikey string, e error) Error {
 		InternalCaller: CallerN(1)}
 }
 
+func NewRecursiveWithLimitError(alias string, limit int64) Error {
+	return &err{level: EXCEPTION, ICode: 5420, IKey: "execution.recursive_with.limit",
+		InternalMsg:    fmt.Sprintf("Recursive WITH %s exceeded %d levels without reaching a fixpoint", alias, limit),
+		InternalCaller: CallerN(1)}
+}
+
+func NewSpillError(e error, what string) Error {
+	return &err{level: EXCEPTION, ICode: 5421, IKey: "execution.spill.error", ICause: e,
+		InternalMsg:    fmt.Sprintf("Error spilling %s to disk", what),
+		InternalCaller: CallerN(1)}
+}
+
+func NewSpillLimitError(limit int64) Error {
+	return &err{level: EXCEPTION, ICode: 5422, IKey: "execution.spill.limit",
+		InternalMsg:    fmt.Sprintf("Spill files have exceeded the spill limit of %d bytes", limit),
+		InternalCaller: CallerN(1)}
+}
+
+func NewSchemaValidationError(keyspace, key string, violations []interface{}, e error) Error {
+	c := make(map[string]interface{}, 3)
+	c["keyspace"] = keyspace
+	c["key"] = key
+	if len(violations) > 0 {
+		c["errors"] = violations
+	}
+	return &err{level: EXCEPTION, ICode: 5423, IKey: "execution.schema_validation", ICause: e,
+		InternalMsg:    fmt.Sprintf("Document %s does not conform to the validation schema of %s", key, keyspace),
+		InternalCaller: CallerN(1), cause: c}
+}
+
 func NewMemoryQuotaExceededError() Error {
 	return &err{level: EXCEPTION, ICode: 5500, IKey: "execution.memory_quota.exceeded",
 		InternalMsg:    "Request has exceeded memory quota",
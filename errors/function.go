@@ -110,3 +110,11 @@ func NewFunctionExecutionError(what string, name string, reason error) Error {
 		InternalMsg:    fmt.Sprintf("Error executing function %v %v: %v", name, what, reason),
 		InternalCaller: CallerN(1)}
 }
+
+const MISSING_LIBRARY = 10110
+
+func NewMissingLibraryError(l string) Error {
+	return &err{level: EXCEPTION, ICode: MISSING_LIBRARY, IKey: "function.library.missing.error",
+		InternalMsg:    fmt.Sprintf("Library not found %v", l),
+		InternalCaller: CallerN(1)}
+}
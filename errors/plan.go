@@ -105,6 +105,11 @@ func NewPredefinedPreparedNameError(msg string) Error {
 		InternalMsg: fmt.Sprintf("Prepared name %s is predefined (reserved). ", msg), InternalCaller: CallerN(1)}
 }
 
+func NewPreparedPersistError(e error, path string) Error {
+	return &err{level: EXCEPTION, ICode: 4093, IKey: "plan.build_prepared.persist",
+		ICause: e, InternalMsg: fmt.Sprintf("Unable to persist prepared statements in %s", path), InternalCaller: CallerN(1)}
+}
+
 const NO_INDEX_JOIN = 4100
 
 func NewNoIndexJoinError(alias, op string) Error {
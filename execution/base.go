@@ -138,6 +138,8 @@ type base struct {
 	batch          []value.AnnotatedValue
 	timePhase      timePhases
 	startTime      util.Time
+	activeTime     time.Time
+	traced         bool
 	execPhase      Phases
 	phaseTimes     func(time.Duration)
 	execTime       time.Duration
@@ -146,6 +148,8 @@ type base struct {
 	inDocs         int64
 	outDocs        int64
 	phaseSwitches  int64
+	spills         int64
+	spillSize      int64
 	stopped        bool
 	isRoot         bool
 	bit            uint8
@@ -200,6 +204,7 @@ func newBase(dest *base, context *Context) {
 	dest.doSend = parallelSend
 	dest.closeConsumer = false
 	dest.quota = context.ProducerThrottleQuota()
+	dest.traced = context.TraceParent() != ""
 }
 
 // The output of this operator will be redirected elsewhere, so we
@@ -230,6 +235,7 @@ func newSerializedBase(dest *base, context *Context) {
 	dest.closeConsumer = false
 	dest.serializable = true
 	dest.quota = context.ProducerThrottleQuota()
+	dest.traced = context.TraceParent() != ""
 }
 
 func (this *base) setInline() {
@@ -254,6 +260,7 @@ func (this *base) copy(dest *base) {
 	dest.doSend = parallelSend
 	dest.closeConsumer = false
 	dest.quota = this.quota
+	dest.traced = this.traced
 }
 
 // reset the operator to an initial state
@@ -1352,6 +1359,11 @@ func (this *base) switchPhase(p timePhases) {
 	// starting or restarting after a stop
 	// either way, no time to accrue as of yet
 	if oldPhase == _NOTIME {
+
+		// operator spans start when the operator first starts
+		if this.traced && this.activeTime.IsZero() {
+			this.activeTime = time.Now()
+		}
 		return
 	}
 
@@ -1416,6 +1428,10 @@ func (this *base) marshalTimes(r map[string]interface{}) {
 	if this.phaseSwitches != 0 {
 		stats["#phaseSwitches"] = this.phaseSwitches
 	}
+	if this.spills != 0 {
+		stats["#spills"] = this.spills
+		stats["spillSize"] = this.spillSize
+	}
 
 	execTime := this.execTime
 	chanTime := this.chanTime
@@ -1442,6 +1458,9 @@ func (this *base) marshalTimes(r map[string]interface{}) {
 	if servTime != 0 {
 		stats["servTime"] = servTime.String()
 	}
+	if !this.activeTime.IsZero() {
+		stats["startTime"] = this.activeTime.Format(time.RFC3339Nano)
+	}
 
 	if this.valueExchange.beatYields > 0 {
 		stats["#heartbeatYields"] = this.valueExchange.beatYields
@@ -1475,9 +1494,14 @@ func (this *base) accrueTime(copy *base) {
 	this.inDocs += copy.inDocs
 	this.outDocs += copy.outDocs
 	this.phaseSwitches += copy.phaseSwitches
+	this.spills += copy.spills
+	this.spillSize += copy.spillSize
 	this.execTime += copy.execTime
 	this.chanTime += copy.chanTime
 	this.servTime += copy.servTime
+	if !copy.activeTime.IsZero() && (this.activeTime.IsZero() || copy.activeTime.Before(this.activeTime)) {
+		this.activeTime = copy.activeTime
+	}
 }
 
 // 2- descend children: default for childless operators
@@ -0,0 +1,33 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"strings"
+	"testing"
+)
+
+// operators only record when they started for the spans of traced requests
+func TestOperatorStartTime(t *testing.T) {
+	stmt := "SELECT RAW d FROM [1, 2, 3] AS d"
+
+	context := newMockContext(t, &testOutput{})
+	_, _, profile := runPipeline(t, context, stmt)
+	if strings.Contains(string(profile), `"startTime"`) {
+		t.Errorf("Expected no start times without a span: %s", profile)
+	}
+
+	context = newMockContext(t, &testOutput{})
+	context.SetTraceParent("00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01")
+	_, _, profile = runPipeline(t, context, stmt)
+	if !strings.Contains(string(profile), `"startTime"`) {
+		t.Errorf("Expected start times with a span: %s", profile)
+	}
+}
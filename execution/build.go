@@ -287,6 +287,26 @@ func (this *builder) VisitHashNest(plan *plan.HashNest) (interface{}, error) {
 	return checkOp(NewHashNest(plan, this.context, c.(Operator), this.aliasMap), this.context)
 }
 
+func (this *builder) VisitMergeJoin(plan *plan.MergeJoin) (interface{}, error) {
+	child := plan.Child()
+	c, e := child.Accept(this)
+	if e != nil {
+		return nil, e
+	}
+
+	return checkOp(NewMergeJoin(plan, this.context, c.(Operator), this.aliasMap), this.context)
+}
+
+func (this *builder) VisitMergeNest(plan *plan.MergeNest) (interface{}, error) {
+	child := plan.Child()
+	c, e := child.Accept(this)
+	if e != nil {
+		return nil, e
+	}
+
+	return checkOp(NewMergeNest(plan, this.context, c.(Operator), this.aliasMap), this.context)
+}
+
 func (this *builder) VisitUnnest(plan *plan.Unnest) (interface{}, error) {
 	return checkOp(NewUnnest(plan, this.context), this.context)
 }
@@ -306,6 +326,15 @@ func (this *builder) VisitWith(plan *plan.With) (interface{}, error) {
 	return checkOp(NewWith(plan, this.context, c.(Operator)), this.context)
 }
 
+func (this *builder) VisitRecursiveWith(plan *plan.RecursiveWith) (interface{}, error) {
+	child := plan.Child()
+	c, e := child.Accept(this)
+	if e != nil {
+		return nil, e
+	}
+	return checkOp(NewRecursiveWith(plan, this.context, c.(Operator)), this.context)
+}
+
 // Filter
 func (this *builder) VisitFilter(plan *plan.Filter) (interface{}, error) {
 	return checkOp(NewFilter(plan, this.context, this.aliasMap), this.context)
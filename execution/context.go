@@ -47,10 +47,12 @@ const (
 	INDEX_JOIN
 	NL_JOIN
 	HASH_JOIN
+	MERGE_JOIN
 	NEST
 	INDEX_NEST
 	NL_NEST
 	HASH_NEST
+	MERGE_NEST
 	COUNT
 	INDEX_COUNT
 	FILTER
@@ -63,6 +65,7 @@ const (
 	INFER
 	FTS_SEARCH
 	UPDATE_STAT
+	SPILL
 
 	// Expression layer
 	ADVISOR
@@ -89,10 +92,12 @@ var _PHASE_NAMES = []string{
 	INDEX_JOIN:   "indexJoin",
 	NL_JOIN:      "nestedLoopJoin",
 	HASH_JOIN:    "hashJoin",
+	MERGE_JOIN:   "mergeJoin",
 	NEST:         "nest",
 	INDEX_NEST:   "indexNest",
 	NL_NEST:      "nestedLoopNest",
 	HASH_NEST:    "hashNest",
+	MERGE_NEST:   "mergeNest",
 	COUNT:        "count",
 	INDEX_COUNT:  "indexCount",
 	SORT:         "sort",
@@ -105,6 +110,7 @@ var _PHASE_NAMES = []string{
 	INFER:        "inferKeySpace",
 	FTS_SEARCH:   "ftsSearch",
 	UPDATE_STAT:  "updateStatistics",
+	SPILL:        "spill",
 
 	ADVISOR: "advisor",
 
@@ -178,6 +184,7 @@ type Context struct {
 	authenticatedUsers  auth.AuthenticatedUsers
 	mutex               sync.RWMutex
 	whitelist           map[string]interface{}
+	traceParent         string
 	inlistHashMap       map[*expression.In]*expression.InlistHash
 	inlistHashLock      sync.RWMutex
 	memoryQuota         uint64
@@ -280,6 +287,7 @@ func (this *Context) Copy() *Context {
 		numAtrs:             this.numAtrs,
 		flags:               this.flags,
 		reqTimeout:          this.reqTimeout,
+		traceParent:         this.traceParent,
 	}
 
 	rv.SetDurability(this.DurabilityLevel(), this.DurabilityTimeout())
@@ -329,6 +337,15 @@ func (this *Context) GetWhitelist() map[string]interface{} {
 	return this.whitelist
 }
 
+// the traceparent of the request span, passed on to the services the request calls
+func (this *Context) SetTraceParent(traceParent string) {
+	this.traceParent = traceParent
+}
+
+func (this *Context) TraceParent() string {
+	return this.traceParent
+}
+
 func (this *Context) Optimizer() planner.Optimizer {
 	return this.optimizer
 }
@@ -0,0 +1,90 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"encoding/json"
+	"sync/atomic"
+	"testing"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/mock"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/value"
+)
+
+// records errors and the number of operators that spilled
+type testOutput struct {
+	internalOutput
+	spills uint64
+}
+
+func (this *testOutput) AddPhaseOperator(p Phases) {
+	if p == SPILL {
+		atomic.AddUint64(&this.spills, 1)
+	}
+}
+
+type noScanVectors struct{}
+
+func (this *noScanVectors) ScanVector(namespace_id string, keyspace_name string) timestamp.Vector {
+	return nil
+}
+
+func (this *noScanVectors) Type() int32 {
+	return timestamp.NO_VECTORS
+}
+
+// keyspaces are resolved against the global datastore
+func newTestContext(store datastore.Datastore, namespace string, output Output) *Context {
+	datastore.SetDatastore(store)
+	return NewContext("test", store, nil, namespace, true, 1, 0, 0, 0, nil, nil, nil,
+		datastore.UNBOUNDED, &noScanVectors{}, output, nil, 4, 0, "", false, false, nil, 0, 0)
+}
+
+func newMockContext(t *testing.T, output Output) *Context {
+	store, err := mock.NewDatastore("mock:")
+	if err != nil {
+		t.Fatalf("Failed to create datastore: %v", err)
+	}
+	return newTestContext(store, "p0", output)
+}
+
+// plans and runs a read only statement
+func runStatement(t *testing.T, context *Context, stmt string) (value.Value, *plan.Prepared) {
+	results, prepared, _ := runPipeline(t, context, stmt)
+	return results, prepared
+}
+
+// as runStatement, also returning the profile of the executed pipeline
+func runPipeline(t *testing.T, context *Context, stmt string) (value.Value, *plan.Prepared, []byte) {
+	prepared, _, err := context.PrepareStatement(stmt, nil, nil, true, true, false)
+	if err != nil {
+		t.Fatalf("Failed to prepare %s: %v", stmt, err)
+	}
+	pipeline, err := Build(prepared, context)
+	if err != nil {
+		t.Fatalf("Failed to build %s: %v", stmt, err)
+	}
+
+	collect := NewCollect(plan.NewCollect(), context)
+	sequence := NewSequence(plan.NewSequence(), context, pipeline, collect)
+	sequence.RunOnce(context, nil)
+	collect.waitComplete()
+	results := collect.ValuesOnce()
+	profile, _ := json.Marshal(sequence)
+	sequence.Done()
+
+	if output, ok := context.output.(*testOutput); ok && output.err != nil {
+		t.Fatalf("Failed to run %s: %v", stmt, output.err)
+	}
+	return results, prepared, profile
+}
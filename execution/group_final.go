@@ -20,8 +20,12 @@ import (
 
 type FinalGroup struct {
 	base
-	plan   *plan.FinalGroup
-	groups map[string]value.AnnotatedValue
+	plan      *plan.FinalGroup
+	groups    map[string]value.AnnotatedValue
+	size      uint64
+	threshold uint64
+	noStream  bool
+	sent      map[string]bool
 }
 
 func NewFinalGroup(plan *plan.FinalGroup, context *Context) *FinalGroup {
@@ -59,7 +63,9 @@ func (this *FinalGroup) RunOnce(context *Context, parent value.Value) {
 func (this *FinalGroup) processItem(item value.AnnotatedValue, context *Context) bool {
 	// Generate the group key
 	var gk string
-	if len(this.plan.Keys()) > 0 {
+	if this.plan.GroupingSets() != nil {
+		gk = groupingSetKey(item, this.plan.Keys())
+	} else if len(this.plan.Keys()) > 0 {
 		var e error
 		gk, e = groupKey(item, this.plan.Keys(), context)
 		if e != nil {
@@ -71,7 +77,7 @@ func (this *FinalGroup) processItem(item value.AnnotatedValue, context *Context)
 
 	// Get or seed the group value
 	gv := this.groups[gk]
-	if gv != nil {
+	if gv != nil || this.sent[gk] {
 		context.Fatal(errors.NewDuplicateFinalGroupError())
 		item.Recycle()
 		return false
@@ -96,7 +102,7 @@ func (this *FinalGroup) processItem(item value.AnnotatedValue, context *Context)
 			aggregates[agg.String()] = v
 		}
 
-		return true
+		return this.checkStream(gk, gv, context)
 	default:
 		context.Fatal(errors.NewInvalidValueError(fmt.Sprintf(
 			"Invalid or missing aggregates of type %T.", aggregates)))
@@ -112,22 +118,83 @@ func (this *FinalGroup) afterItems(context *Context) {
 		}
 	}
 
+	if len(this.groups) > 0 || len(this.sent) > 0 {
+		return
+	}
+
 	// Mo matching inputs, so send default values
-	if len(this.plan.Keys()) == 0 && len(this.groups) == 0 {
-		av := value.NewAnnotatedValue(nil)
-		aggregates := make(map[string]value.Value, len(this.plan.Aggregates()))
-		av.SetAttachment("aggregates", aggregates)
-		for _, agg := range this.plan.Aggregates() {
-			aggregates[agg.String()], _ = agg.Default(nil, context)
+	if this.plan.GroupingSets() != nil {
+
+		// Empty grouping sets have one group, even without inputs
+		for index, set := range this.plan.GroupingSets() {
+			if len(set) == 0 {
+				av := this.defaultValue(context)
+				setGroupingSet(av, this.plan.Keys(), nil, set, index)
+				if !this.sendDefault(av, context) {
+					return
+				}
+			}
 		}
+	} else if len(this.plan.Keys()) == 0 {
+		this.sendDefault(this.defaultValue(context), context)
+	}
+}
+
+/*
+Once groups exceed the spill threshold, they are sent as soon as
+they are complete, and only their keys are kept, to check for
+duplicates.
+*/
+func (this *FinalGroup) checkStream(gk string, gv value.AnnotatedValue, context *Context) bool {
+	if this.sent != nil {
+		delete(this.groups, gk)
+		this.sent[gk] = true
+		return this.sendItem(gv)
+	}
+
+	if this.noStream {
+		return true
+	}
+
+	if this.threshold == 0 {
+		this.threshold = spillThreshold(context)
+		this.noStream = this.threshold == 0
+	}
 
-		if context.UseRequestQuota() && context.TrackValueSize(av.Size()) {
-			context.Error(errors.NewMemoryQuotaExceededError())
-			av.Recycle()
-		} else {
-			this.sendItem(av)
+	this.size += gv.Size()
+	if this.noStream || this.size <= this.threshold {
+		return true
+	}
+
+	this.sent = make(map[string]bool, len(this.groups))
+	groups := this.groups
+	this.groups = make(map[string]value.AnnotatedValue)
+	for k, av := range groups {
+		this.sent[k] = true
+		if !this.sendItem(av) {
+			return false
 		}
 	}
+	return true
+}
+
+func (this *FinalGroup) defaultValue(context *Context) value.AnnotatedValue {
+	av := value.NewAnnotatedValue(nil)
+	aggregates := make(map[string]value.Value, len(this.plan.Aggregates()))
+	av.SetAttachment("aggregates", aggregates)
+	for _, agg := range this.plan.Aggregates() {
+		aggregates[agg.String()], _ = agg.Default(nil, context)
+	}
+	return av
+}
+
+func (this *FinalGroup) sendDefault(av value.AnnotatedValue, context *Context) bool {
+	if context.UseRequestQuota() && context.TrackValueSize(av.Size()) {
+		context.Error(errors.NewMemoryQuotaExceededError())
+		av.Recycle()
+		return false
+	}
+	return this.sendItem(av)
 }
 
 func (this *FinalGroup) MarshalJSON() ([]byte, error) {
@@ -140,5 +207,7 @@ func (this *FinalGroup) MarshalJSON() ([]byte, error) {
 func (this *FinalGroup) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.groups = make(map[string]value.AnnotatedValue)
+	this.sent = nil
+	this.size = 0
 	return rv
 }
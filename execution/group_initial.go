@@ -21,8 +21,11 @@ import (
 // Grouping of input data.
 type InitialGroup struct {
 	base
-	plan   *plan.InitialGroup
-	groups map[string]value.AnnotatedValue
+	plan      *plan.InitialGroup
+	groups    map[string]value.AnnotatedValue
+	size      uint64
+	threshold uint64
+	noFlush   bool
 }
 
 func NewInitialGroup(plan *plan.InitialGroup, context *Context) *InitialGroup {
@@ -58,6 +61,10 @@ func (this *InitialGroup) RunOnce(context *Context, parent value.Value) {
 }
 
 func (this *InitialGroup) processItem(item value.AnnotatedValue, context *Context) bool {
+	if this.plan.GroupingSets() != nil {
+		return this.processGroupingSets(item, context)
+	}
+
 	// Generate the group key
 	var gk string
 	if len(this.plan.Keys()) > 0 {
@@ -70,23 +77,74 @@ func (this *InitialGroup) processItem(item value.AnnotatedValue, context *Contex
 		}
 	}
 
+	seeded, ok := this.cumulate(gk, item, item, context)
+	if ok && !seeded && context.UseRequestQuota() {
+		context.ReleaseValueSize(item.Size())
+	}
+	item.Recycle()
+	return ok && this.checkFlush(context)
+}
+
+/*
+Each item contributes to one group for each grouping set.
+*/
+func (this *InitialGroup) processGroupingSets(item value.AnnotatedValue, context *Context) bool {
+	defer item.Recycle()
+
+	keys := this.plan.Keys()
+	kvs := make([]value.Value, len(keys))
+	for i, key := range keys {
+		var e error
+		kvs[i], e = key.Evaluate(item, context)
+		if e != nil {
+			context.Fatal(errors.NewEvaluationError(e, "GROUP key"))
+			return false
+		}
+	}
+
+	for index, set := range this.plan.GroupingSets() {
+		seed := item.Copy().(value.AnnotatedValue)
+		setGroupingSet(seed, keys, kvs, set, index)
+
+		seeded, ok := this.cumulate(groupingSetKey(seed, keys), seed, item, context)
+		if !seeded {
+			seed.Recycle()
+		} else if context.UseRequestQuota() && context.TrackValueSize(seed.Size()) {
+			context.Fatal(errors.NewMemoryQuotaExceededError())
+			return false
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if context.UseRequestQuota() {
+		context.ReleaseValueSize(item.Size())
+	}
+	return this.checkFlush(context)
+}
+
+/*
+Seed the group value with the seed if this is the first item of
+the group, and cumulate the aggregates of the item.
+*/
+func (this *InitialGroup) cumulate(gk string, seed, item value.AnnotatedValue, context *Context) (bool, bool) {
 	// Get or seed the group value
 	gv := this.groups[gk]
-	handleQuota := false
-	if gv == nil {
+	seeded := gv == nil
+	if seeded {
 
 		// avoid recycling of seeding values
-		item.Track()
-		gv = item
+		seed.Track()
+		gv = seed
 		this.groups[gk] = gv
+		this.size += seed.Size()
 
 		aggregates := make(map[string]value.Value, len(this.plan.Aggregates()))
 		gv.SetAttachment("aggregates", aggregates)
 		for _, agg := range this.plan.Aggregates() {
 			aggregates[agg.String()], _ = agg.Default(nil, context)
 		}
-	} else {
-		handleQuota = context.UseRequestQuota()
 	}
 
 	// Cumulate aggregates
@@ -94,25 +152,49 @@ func (this *InitialGroup) processItem(item value.AnnotatedValue, context *Contex
 	if !ok {
 		context.Fatal(errors.NewInvalidValueError(
 			fmt.Sprintf("Invalid aggregates %v of type %T", aggregates, aggregates)))
-		item.Recycle()
-		return false
+		return seeded, false
 	}
 
 	for _, agg := range this.plan.Aggregates() {
 		v, e := agg.CumulateInitial(item, aggregates[agg.String()], context)
 		if e != nil {
 			context.Fatal(errors.NewGroupUpdateError(e, "Error updating initial GROUP value."))
-			item.Recycle()
-			return false
+			return seeded, false
 		}
 
 		aggregates[agg.String()] = v
 	}
-	if handleQuota {
-		context.ReleaseValueSize(item.Size())
+
+	return seeded, true
+}
+
+/*
+Initial groups hold partial aggregates, which the intermediate group
+merges: once they exceed the spill threshold, they are sent on rather
+than kept in memory.
+*/
+func (this *InitialGroup) checkFlush(context *Context) bool {
+	if this.noFlush {
+		return true
+	}
+
+	if this.threshold == 0 {
+		this.threshold = spillThreshold(context)
+		this.noFlush = this.threshold == 0
+	}
+
+	if this.noFlush || this.size <= this.threshold {
+		return true
 	}
-	item.Recycle()
 
+	groups := this.groups
+	this.groups = make(map[string]value.AnnotatedValue)
+	this.size = 0
+	for _, av := range groups {
+		if !this.sendItem(av) {
+			return false
+		}
+	}
 	return true
 }
 
@@ -134,5 +216,6 @@ func (this *InitialGroup) MarshalJSON() ([]byte, error) {
 func (this *InitialGroup) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.groups = make(map[string]value.AnnotatedValue)
+	this.size = 0
 	return rv
 }
@@ -15,14 +15,19 @@ import (
 
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
 
 // Grouping of groups. Recursable.
 type IntermediateGroup struct {
 	base
-	plan   *plan.IntermediateGroup
-	groups map[string]value.AnnotatedValue
+	plan       *plan.IntermediateGroup
+	groups     map[string]value.AnnotatedValue
+	size       uint64
+	threshold  uint64
+	noSpill    bool
+	partitions []*spillFile
 }
 
 func NewIntermediateGroup(plan *plan.IntermediateGroup, context *Context) *IntermediateGroup {
@@ -54,13 +59,16 @@ func (this *IntermediateGroup) PlanOp() plan.Operator {
 }
 
 func (this *IntermediateGroup) RunOnce(context *Context, parent value.Value) {
+	defer this.closePartitions()
 	this.runConsumer(this, context, parent)
 }
 
 func (this *IntermediateGroup) processItem(item value.AnnotatedValue, context *Context) bool {
 	// Generate the group key
 	var gk string
-	if len(this.plan.Keys()) > 0 {
+	if this.plan.GroupingSets() != nil {
+		gk = groupingSetKey(item, this.plan.Keys())
+	} else if len(this.plan.Keys()) > 0 {
 		var e error
 		gk, e = groupKey(item, this.plan.Keys(), context)
 		if e != nil {
@@ -70,6 +78,15 @@ func (this *IntermediateGroup) processItem(item value.AnnotatedValue, context *C
 		}
 	}
 
+	if this.groups[gk] == nil {
+		this.groups[gk] = item
+		return this.checkSpill(item, context)
+	}
+
+	return this.cumulate(gk, item, context)
+}
+
+func (this *IntermediateGroup) cumulate(gk string, item value.AnnotatedValue, context *Context) bool {
 	// Get or seed the group value
 	gv := this.groups[gk]
 	if gv == nil {
@@ -117,6 +134,11 @@ func (this *IntermediateGroup) processItem(item value.AnnotatedValue, context *C
 }
 
 func (this *IntermediateGroup) afterItems(context *Context) {
+	if this.partitions != nil {
+		this.mergePartitions(context)
+		return
+	}
+
 	for _, av := range this.groups {
 		if !this.sendItem(av) {
 			return
@@ -124,6 +146,122 @@ func (this *IntermediateGroup) afterItems(context *Context) {
 	}
 }
 
+/*
+Once groups exceed the spill threshold, they are hash partitioned
+on the group key and written to disk. Each partition is merged back
+in memory once all values are in.
+Aggregates that keep sets, lists or sketches of values are not spilled.
+*/
+func (this *IntermediateGroup) checkSpill(item value.AnnotatedValue, context *Context) bool {
+	if this.noSpill {
+		return true
+	}
+
+	if this.threshold == 0 {
+		this.threshold = spillThreshold(context)
+		this.noSpill = this.threshold == 0
+		for _, agg := range this.plan.Aggregates() {
+			if !spillableAggregate(agg) {
+				this.noSpill = true
+			}
+		}
+		if this.noSpill {
+			return true
+		}
+	}
+
+	this.size += item.Size()
+	if this.size <= this.threshold {
+		return true
+	}
+
+	if this.partitions == nil {
+		if _, ok := encodeSpillValue(item); !ok {
+			this.noSpill = true
+			return true
+		}
+		this.partitions = make([]*spillFile, _GROUP_SPILL_PARTITIONS)
+	}
+	return this.spillGroups(context)
+}
+
+func (this *IntermediateGroup) spillGroups(context *Context) bool {
+	for gk, gv := range this.groups {
+		p := util.HashString(gk, len(this.partitions))
+		if this.partitions[p] == nil {
+			var err errors.Error
+			this.partitions[p], err = this.newSpillFile("group partition", context)
+			if err != nil {
+				context.Fatal(err)
+				return false
+			}
+		}
+
+		gv.SetAttachment("group_key", gk)
+		err := this.partitions[p].write(gv, context)
+		if err != nil {
+			context.Fatal(err)
+			return false
+		}
+		gv.Recycle()
+	}
+
+	this.groups = make(map[string]value.AnnotatedValue)
+	this.size = 0
+	return true
+}
+
+func (this *IntermediateGroup) mergePartitions(context *Context) {
+	if !this.spillGroups(context) {
+		return
+	}
+
+	for i, part := range this.partitions {
+		if part == nil {
+			continue
+		}
+
+		err := part.rewind()
+		if err != nil {
+			context.Fatal(err)
+			return
+		}
+
+		for {
+			item, err := part.read(context)
+			if err != nil {
+				context.Fatal(err)
+				return
+			}
+			if item == nil {
+				break
+			}
+
+			gk, _ := item.GetAttachment("group_key").(string)
+			item.RemoveAttachment("group_key")
+			if !this.cumulate(gk, item, context) {
+				return
+			}
+		}
+
+		part.close()
+		this.partitions[i] = nil
+
+		for _, av := range this.groups {
+			if !this.sendItem(av) {
+				return
+			}
+		}
+		this.groups = make(map[string]value.AnnotatedValue)
+	}
+}
+
+func (this *IntermediateGroup) closePartitions() {
+	closeSpillFiles(this.partitions)
+	this.partitions = nil
+	this.size = 0
+}
+
 func (this *IntermediateGroup) MarshalJSON() ([]byte, error) {
 	r := this.plan.MarshalBase(func(r map[string]interface{}) {
 		this.marshalTimes(r)
@@ -134,5 +272,6 @@ func (this *IntermediateGroup) MarshalJSON() ([]byte, error) {
 func (this *IntermediateGroup) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.groups = make(map[string]value.AnnotatedValue)
+	this.closePartitions()
 	return rv
 }
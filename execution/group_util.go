@@ -10,6 +10,9 @@
 package execution
 
 import (
+	"strconv"
+
+	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
@@ -34,4 +37,54 @@ func groupKey(item value.Value, keys expression.Expressions, context *Context) (
 	return string(bytes), nil
 }
 
+/*
+Values produced for a grouping set carry the group keys as covers,
+NULL for the keys aggregated over, and the grouping set, so that the
+group key is the grouping set and the keys that are part of it.
+*/
+func groupingSetKey(item value.AnnotatedValue, keys expression.Expressions) string {
+	set, _ := item.GetAttachment("grouping_set").(int)
+
+	kvs := _GROUP_KEY_POOL.GetCapped(len(keys))
+	defer _GROUP_KEY_POOL.Put(kvs)
+
+	for i, key := range keys {
+		k := item.GetCover(algebra.GroupingKeyText(key))
+		if k != nil && k.Type() != value.MISSING {
+			kvs[string(rune(i))] = k
+		}
+	}
+
+	bytes, _ := value.NewValue(kvs).MarshalJSON()
+	return strconv.Itoa(set) + string(bytes)
+}
+
+/*
+Set the covers and attachments of a value for a grouping set.
+*/
+func setGroupingSet(item value.AnnotatedValue, keys expression.Expressions, kvs []value.Value,
+	set []int, index int) {
+	grouped := make([]bool, len(keys))
+	for _, k := range set {
+		grouped[k] = true
+	}
+
+	aggregated := make(map[string]bool, len(keys))
+	for i, key := range keys {
+		text := algebra.GroupingKeyText(key)
+		if grouped[i] {
+			item.SetCover(text, kvs[i])
+		} else {
+			item.SetCover(text, value.NULL_VALUE)
+			aggregated[text] = true
+		}
+	}
+
+	item.SetAttachment("grouping_set", index)
+	item.SetAttachment("grouping", aggregated)
+}
+
+// groups spilled by the group operators are hash partitioned on the group key
+const _GROUP_SPILL_PARTITIONS = 16
+
 var _GROUP_KEY_POOL = util.NewStringInterfacePool(16)
@@ -16,6 +16,7 @@ import (
 	"github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/schemas"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -191,6 +192,11 @@ func (this *SendInsert) flushBatch(context *Context) bool {
 
 		dpair.Options = adjustExpiration(options)
 		dpair.Value = this.setDocumentKey(dpair.Name, value.NewAnnotatedValue(val), getExpiration(dpair.Options), context)
+
+		if err := schemas.Validate(this.keyspace, dpair.Name, dpair.Value); err != nil {
+			context.Error(err)
+			continue
+		}
 		i++
 	}
 
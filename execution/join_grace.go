@@ -0,0 +1,252 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"fmt"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+const _GRACE_HASH_PARTITIONS = 16
+
+/*
+Grace hash join: once the build side exceeds the spill threshold, the
+build and probe values are hash partitioned on their join keys and
+written to disk, and each pair of partitions is joined in memory once
+all the probe values are in.
+*/
+type graceHash struct {
+	op        *base
+	threshold uint64
+	disabled  bool
+	build     []*spillFile
+	probe     []*spillFile
+}
+
+// nil if spilling is disabled
+func newGraceHash(op *base, context *Context) *graceHash {
+	threshold := spillThreshold(context)
+	if threshold == 0 {
+		return nil
+	}
+	return &graceHash{op: op, threshold: threshold}
+}
+
+func (this *graceHash) spilling() bool {
+	return this != nil && this.build != nil
+}
+
+// the hash table goes to disk if it is over the threshold
+func (this *graceHash) checkSpill(hashTab *util.HashTable, buildExprs expression.Expressions,
+	buildVals value.Values, context *Context) bool {
+	if this == nil || this.disabled || this.build != nil || hashTab.Size() <= this.threshold {
+		return true
+	}
+
+	for v := hashTab.Iterate(); v != nil; v = hashTab.Iterate() {
+		item, _ := v.(value.AnnotatedValue)
+		if this.build == nil {
+			if _, ok := encodeSpillValue(item); !ok {
+				this.disabled = true
+				return true
+			}
+			this.build = make([]*spillFile, _GRACE_HASH_PARTITIONS)
+			this.probe = make([]*spillFile, _GRACE_HASH_PARTITIONS)
+		}
+
+		buildVal := getHashVal(item, buildExprs, buildVals, "Hash Table Build Expression", context)
+		if buildVal == nil || !this.writeBuild(buildVal, item, context) {
+			return false
+		}
+	}
+
+	// the spill files have accounted for the values
+	hashTab.Drop()
+	return true
+}
+
+func (this *graceHash) writeBuild(buildVal value.Value, item value.AnnotatedValue, context *Context) bool {
+	record, ok := encodeSpillValue(item)
+	if !ok {
+		context.Error(errors.NewSpillError(fmt.Errorf("annotations cannot be spilled"), "hash join build"))
+		return false
+	}
+	return this.write(this.build, "hash join build", buildVal, record, item, context)
+}
+
+// spilled is false if the probe value cannot go to disk, and has to be joined in memory
+func (this *graceHash) writeProbe(probeVal value.Value, item value.AnnotatedValue, context *Context) (spilled, ok bool) {
+	record, ok := encodeSpillValue(item)
+	if !ok {
+		return false, true
+	}
+	return true, this.write(this.probe, "hash join probe", probeVal, record, item, context)
+}
+
+func (this *graceHash) write(files []*spillFile, what string, val value.Value, record []byte,
+	item value.AnnotatedValue, context *Context) bool {
+	bytes, e := val.MarshalJSON()
+	if e != nil {
+		context.Error(errors.NewSpillError(e, what))
+		return false
+	}
+
+	var err errors.Error
+	p := int(util.SeaHashSum64(bytes) % _GRACE_HASH_PARTITIONS)
+	if files[p] == nil {
+		files[p], err = this.op.newSpillFile(what, context)
+		if err != nil {
+			context.Error(err)
+			return false
+		}
+	}
+
+	err = files[p].writeRecord(record, item, context)
+	if err != nil {
+		context.Error(err)
+		return false
+	}
+	item.Recycle()
+	return true
+}
+
+/*
+Join each pair of partitions: the build partition is loaded in a
+hash table, which the probe partition is run against.
+*/
+func (this *graceHash) join(buildExprs expression.Expressions, buildVals value.Values,
+	newTable func() *util.HashTable, probe func(item value.AnnotatedValue) bool,
+	dropTable func(), context *Context) bool {
+
+	for p := 0; p < _GRACE_HASH_PARTITIONS; p++ {
+		hashTab := newTable()
+		if !this.loadBuild(p, hashTab, buildExprs, buildVals, context) ||
+			!this.runProbe(p, probe, context) {
+			return false
+		}
+		dropTable()
+	}
+
+	return true
+}
+
+/*
+A probe value that cannot be spilled ends grace hash: all the build
+partitions are loaded back in the hash table, and the probe values
+spilled so far are run against it. The remaining probe values are
+joined in memory.
+*/
+func (this *graceHash) unspill(hashTab *util.HashTable, buildExprs expression.Expressions,
+	buildVals value.Values, probe func(item value.AnnotatedValue) bool, context *Context) bool {
+
+	for p := 0; p < _GRACE_HASH_PARTITIONS; p++ {
+		if !this.loadBuild(p, hashTab, buildExprs, buildVals, context) {
+			return false
+		}
+	}
+	for p := 0; p < _GRACE_HASH_PARTITIONS; p++ {
+		if !this.runProbe(p, probe, context) {
+			return false
+		}
+	}
+
+	this.close()
+	this.disabled = true
+	return true
+}
+
+func (this *graceHash) loadBuild(p int, hashTab *util.HashTable, buildExprs expression.Expressions,
+	buildVals value.Values, context *Context) bool {
+
+	build := this.build[p]
+	if build == nil {
+		return true
+	}
+
+	err := build.rewind()
+	if err != nil {
+		context.Error(err)
+		return false
+	}
+
+	for {
+		item, err := build.read(context)
+		if err != nil {
+			context.Error(err)
+			return false
+		}
+		if item == nil {
+			break
+		}
+
+		buildVal := getHashVal(item, buildExprs, buildVals, "Hash Table Build Expression", context)
+		if buildVal == nil {
+			return false
+		}
+
+		var size uint64
+		if context.UseRequestQuota() {
+			size = item.Size()
+		}
+		e := hashTab.Put(buildVal, item, value.MarshalValue, value.EqualValue, size)
+		if e != nil {
+			context.Error(errors.NewHashTablePutError(e))
+			return false
+		}
+	}
+
+	build.close()
+	this.build[p] = nil
+	return true
+}
+
+func (this *graceHash) runProbe(p int, probe func(item value.AnnotatedValue) bool, context *Context) bool {
+	probeFile := this.probe[p]
+	if probeFile == nil {
+		return true
+	}
+
+	err := probeFile.rewind()
+	if err != nil {
+		context.Error(err)
+		return false
+	}
+
+	for {
+		item, err := probeFile.read(context)
+		if err != nil {
+			context.Error(err)
+			return false
+		}
+		if item == nil {
+			break
+		}
+		if !probe(item) {
+			return false
+		}
+	}
+
+	probeFile.close()
+	this.probe[p] = nil
+	return true
+}
+
+func (this *graceHash) close() {
+	if this != nil {
+		closeSpillFiles(this.build)
+		closeSpillFiles(this.probe)
+		this.build = nil
+		this.probe = nil
+	}
+}
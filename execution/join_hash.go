@@ -28,6 +28,7 @@ type HashJoin struct {
 	hashTab   *util.HashTable
 	buildVals value.Values
 	probeVals value.Values
+	grace     *graceHash
 }
 
 func NewHashJoin(plan *plan.HashJoin, context *Context, child Operator, aliasMap map[string]string) *HashJoin {
@@ -63,6 +64,9 @@ func (this *HashJoin) PlanOp() plan.Operator {
 }
 
 func (this *HashJoin) RunOnce(context *Context, parent value.Value) {
+	defer func() {
+		this.grace.close()
+	}()
 	this.runConsumer(this, context, parent)
 }
 
@@ -100,23 +104,27 @@ func (this *HashJoin) beforeItems(context *Context, parent value.Value) bool {
 
 	this.fork(this.child, context, parent)
 
+	this.grace = newGraceHash(&this.base, context)
 	ok := buildHashTab(&(this.base), this.child, this.hashTab,
-		this.plan.BuildExprs(), this.buildVals, context)
+		this.plan.BuildExprs(), this.buildVals, this.grace, context)
 	if !ok {
 		return false
 	}
 
 	// if the build side is empty and this is not an outer join,
 	// no need to activate the probe side.
-	if this.hashTab.Count() == 0 && !this.plan.Outer() {
+	if this.hashTab.Count() == 0 && !this.plan.Outer() && !this.grace.spilling() {
 		return false
 	}
 
 	return true
 }
 
+/*
+With grace hash, the build side may go to disk rather than in the hash table.
+*/
 func buildHashTab(base *base, buildOp Operator, hashTab *util.HashTable,
-	buildExprs expression.Expressions, buildVals value.Values, context *Context) bool {
+	buildExprs expression.Expressions, buildVals value.Values, grace *graceHash, context *Context) bool {
 	var err error
 	stopped := false
 	n := 1
@@ -141,7 +149,14 @@ loop:
 				} else {
 					buildVal = value.NewValue(buildVals)
 				}
-				if context.UseRequestQuota() {
+				if grace.spilling() {
+					if !grace.writeBuild(buildVal, build_item, context) {
+						return false
+					}
+					continue
+				}
+
+				if context.UseRequestQuota() || grace != nil {
 					size = build_item.Size()
 				}
 
@@ -150,6 +165,10 @@ loop:
 					context.Error(errors.NewHashTablePutError(err))
 					return false
 				}
+
+				if !grace.checkSpill(hashTab, buildExprs, buildVals, context) {
+					return false
+				}
 			} else if child >= 0 {
 				n--
 			} else {
@@ -175,26 +194,54 @@ loop:
 
 func getProbeVal(item value.AnnotatedValue, probeExprs expression.Expressions,
 	probeVals value.Values, context *Context) value.Value {
+	return getHashVal(item, probeExprs, probeVals, "Hash Table Probe Expression", context)
+}
+
+func getHashVal(item value.AnnotatedValue, exprs expression.Expressions,
+	vals value.Values, what string, context *Context) value.Value {
 
 	var err error
-	for i, pe := range probeExprs {
-		probeVals[i], err = pe.Evaluate(item, context)
+	for i, e := range exprs {
+		vals[i], err = e.Evaluate(item, context)
 		if err != nil {
-			context.Error(errors.NewEvaluationError(err, "Hash Table Probe Expression"))
+			context.Error(errors.NewEvaluationError(err, what))
 			return nil
 		}
 	}
 
-	if len(probeVals) == 1 {
-		return probeVals[0]
+	if len(vals) == 1 {
+		return vals[0]
 	} else {
-		return value.NewValue(probeVals)
+		return value.NewValue(vals)
 	}
 }
 
 func (this *HashJoin) processItem(item value.AnnotatedValue, context *Context) bool {
 	defer this.switchPhase(_EXECTIME)
 
+	if this.grace.spilling() {
+		probeVal := getProbeVal(item, this.plan.ProbeExprs(), this.probeVals, context)
+		if probeVal == nil {
+			return false
+		}
+		spilled, ok := this.grace.writeProbe(probeVal, item, context)
+		if spilled || !ok {
+			return ok
+		}
+		this.dropHashTable(context)
+		this.hashTab = util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+		if !this.grace.unspill(this.hashTab, this.plan.BuildExprs(), this.buildVals,
+			func(item value.AnnotatedValue) bool {
+				return this.probe(item, context)
+			}, context) {
+			return false
+		}
+	}
+	return this.probe(item, context)
+}
+
+func (this *HashJoin) probe(item value.AnnotatedValue, context *Context) bool {
+
 	var err error
 	var outVal interface{}
 	ok := true
@@ -248,6 +295,21 @@ func (this *HashJoin) processItem(item value.AnnotatedValue, context *Context) b
 }
 
 func (this *HashJoin) afterItems(context *Context) {
+	if this.grace.spilling() {
+		this.dropHashTable(context)
+		this.grace.join(this.plan.BuildExprs(), this.buildVals,
+			func() *util.HashTable {
+				this.hashTab = util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+				return this.hashTab
+			},
+			func(item value.AnnotatedValue) bool {
+				return this.probe(item, context)
+			},
+			func() {
+				this.dropHashTable(context)
+			}, context)
+		this.grace.close()
+	}
 	this.dropHashTable(context)
 	this.plan.Onclause().ResetMemory(context)
 }
@@ -0,0 +1,361 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"encoding/json"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/value"
+)
+
+type MergeJoin struct {
+	base
+	plan      *plan.MergeJoin
+	child     Operator
+	aliasMap  map[string]string
+	ansiFlags uint32
+	inner     mergeInner
+}
+
+func NewMergeJoin(plan *plan.MergeJoin, context *Context, child Operator, aliasMap map[string]string) *MergeJoin {
+	rv := &MergeJoin{
+		plan:     plan,
+		child:    child,
+		aliasMap: aliasMap,
+	}
+
+	newBase(&rv.base, context)
+	rv.trackChildren(1)
+	rv.execPhase = MERGE_JOIN
+	rv.output = rv
+	return rv
+}
+
+func (this *MergeJoin) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitMergeJoin(this)
+}
+
+func (this *MergeJoin) Copy() Operator {
+	rv := &MergeJoin{
+		plan:     this.plan,
+		child:    this.child.Copy(),
+		aliasMap: this.aliasMap,
+	}
+	this.base.copy(&rv.base)
+	return rv
+}
+
+func (this *MergeJoin) PlanOp() plan.Operator {
+	return this.plan
+}
+
+func (this *MergeJoin) RunOnce(context *Context, parent value.Value) {
+	this.runConsumer(this, context, parent)
+}
+
+func (this *MergeJoin) beforeItems(context *Context, parent value.Value) bool {
+	if !context.assert(this.child != nil, "MERGE JOIN has no child") {
+		return false
+	}
+	if !context.assert(this.plan.Onclause() != nil, "MERGE JOIN does not have onclause") {
+		return false
+	}
+
+	// check for constant TRUE or FALSE onclause
+	cpred := this.plan.Onclause().Value()
+	if cpred != nil {
+		if cpred.Truth() {
+			this.ansiFlags |= ANSI_ONCLAUSE_TRUE
+		} else {
+			this.ansiFlags |= ANSI_ONCLAUSE_FALSE
+		}
+	} else {
+		this.plan.Onclause().EnableInlistHash(context)
+		SetSearchInfo(this.aliasMap, parent, context, this.plan.Onclause())
+	}
+
+	this.inner.open(this, this.child, this.plan.InnerKey(), context, parent)
+	return true
+}
+
+func (this *MergeJoin) processItem(item value.AnnotatedValue, context *Context) bool {
+	defer this.switchPhase(_EXECTIME)
+
+	right_items, ok := this.inner.seek(item, this.plan.OuterKey(), context)
+	if !ok {
+		return false
+	}
+
+	matched := false
+	aliases := []string{this.plan.Alias()}
+	for _, right_item := range right_items {
+		var match bool
+		var joined value.AnnotatedValue
+		match, ok, joined = processAnsiExec(item, right_item, this.plan.Onclause(),
+			aliases, this.ansiFlags, context, "join")
+		if match && ok {
+			matched = true
+			ok = this.checkSendItem(joined, func() uint64 {
+				return joined.Size()
+			}, true, this.plan.Filter(), context)
+		} else if joined != nil {
+			joined.Recycle()
+		}
+		if !ok {
+			return false
+		}
+	}
+
+	if this.plan.Outer() && !matched {
+		return this.checkSendItem(item, func() uint64 {
+			return 0
+		}, false, this.plan.Filter(), context)
+	} else if context.UseRequestQuota() {
+		context.ReleaseValueSize(item.Size())
+	}
+
+	return true
+}
+
+func (this *MergeJoin) afterItems(context *Context) {
+	this.inner.close(context)
+	this.plan.Onclause().ResetMemory(context)
+}
+
+func (this *MergeJoin) checkSendItem(av value.AnnotatedValue, quotaFunc func() uint64, recycle bool, filter expression.Expression, context *Context) bool {
+	if filter != nil {
+		result, err := filter.Evaluate(av, context)
+		if err != nil {
+			context.Error(errors.NewEvaluationError(err, "merge join filter"))
+			if recycle {
+				av.Recycle()
+			}
+			return false
+		}
+		if !result.Truth() {
+			if recycle {
+				av.Recycle()
+			}
+			return true
+		}
+	}
+	if context.UseRequestQuota() && context.TrackValueSize(quotaFunc()) {
+		context.Error(errors.NewMemoryQuotaExceededError())
+		if recycle {
+			av.Recycle()
+		}
+		return false
+
+	}
+	return this.sendItem(av)
+}
+
+func (this *MergeJoin) MarshalJSON() ([]byte, error) {
+	r := this.plan.MarshalBase(func(r map[string]interface{}) {
+		this.marshalTimes(r)
+		r["~child"] = this.child
+	})
+	return json.Marshal(r)
+}
+
+func (this *MergeJoin) SendAction(action opAction) {
+	this.baseSendAction(action)
+	child := this.child
+	if child != nil {
+		child.SendAction(action)
+	}
+}
+
+func (this *MergeJoin) Done() {
+	this.baseDone()
+	if this.child != nil {
+		child := this.child
+		this.child = nil
+		child.Done()
+	}
+}
+
+/*
+The inner side of a merge join or nest. The child produces values in
+order of the inner key, and they are read one group of equal keys at a
+time, as the outer values, in order of the outer key, go by.
+*/
+type mergeInner struct {
+	op       *base
+	child    Operator
+	key      expression.Expression
+	group    value.AnnotatedValues
+	groupKey value.Value
+	next     value.AnnotatedValue
+	nextKey  value.Value
+	outerKey value.Value
+	running  int
+	eof      bool
+}
+
+func (this *mergeInner) open(op Operator, child Operator, key expression.Expression,
+	context *Context, parent value.Value) {
+	this.op = op.getBase()
+	this.child = child
+	this.key = key
+
+	child.SetOutput(child)
+	child.SetInput(nil)
+	child.SetParent(op)
+	child.SetStop(nil)
+
+	this.op.fork(child, context, parent)
+	this.running = 1
+}
+
+/*
+Returns the inner values whose key is equal to the outer key.
+NULL and MISSING keys do not match anything.
+*/
+func (this *mergeInner) seek(item value.AnnotatedValue, outerKey expression.Expression,
+	context *Context) (value.AnnotatedValues, bool) {
+
+	key, err := outerKey.Evaluate(item, context)
+	if err != nil {
+		context.Error(errors.NewEvaluationError(err, "merge join outer key"))
+		return nil, false
+	}
+	if key.Type() <= value.NULL {
+		return nil, true
+	}
+	if this.outerKey != nil && key.Collate(this.outerKey) < 0 {
+		context.Error(errors.NewExecutionInternalError("merge join outer values are not in order"))
+		return nil, false
+	}
+	this.outerKey = key
+
+	for this.groupKey == nil || this.groupKey.Collate(key) < 0 {
+		if this.next == nil && this.eof {
+			this.release(context)
+			return nil, true
+		}
+		if !this.nextGroup(context) {
+			return nil, false
+		}
+	}
+
+	if this.groupKey.Collate(key) == 0 {
+		return this.group, true
+	}
+	return nil, true
+}
+
+func (this *mergeInner) nextGroup(context *Context) bool {
+	this.release(context)
+	if this.next == nil {
+		if !this.readNext(context) {
+			return false
+		}
+		if this.next == nil {
+			return true
+		}
+	}
+
+	this.group = append(this.group, this.next)
+	this.groupKey = this.nextKey
+	this.next = nil
+	this.nextKey = nil
+
+	for {
+		if !this.readNext(context) {
+			return false
+		}
+		if this.next == nil {
+			return true
+		}
+
+		cmp := this.nextKey.Collate(this.groupKey)
+		if cmp < 0 {
+			context.Error(errors.NewExecutionInternalError("merge join inner values are not in order"))
+			return false
+		} else if cmp > 0 {
+			return true
+		}
+
+		this.group = append(this.group, this.next)
+		this.next = nil
+		this.nextKey = nil
+	}
+}
+
+// leaves next nil once the child has no more values
+func (this *mergeInner) readNext(context *Context) bool {
+	for !this.eof {
+		item, child, cont := this.op.getItemChildrenOp(this.child)
+		if !cont {
+			return false
+		}
+
+		if item == nil {
+			if child >= 0 {
+				this.running--
+			} else {
+				this.eof = true
+			}
+			continue
+		}
+
+		key, err := this.key.Evaluate(item, context)
+		if err != nil {
+			context.Error(errors.NewEvaluationError(err, "merge join inner key"))
+			return false
+		}
+		if key.Type() <= value.NULL {
+			if context.UseRequestQuota() {
+				context.ReleaseValueSize(item.Size())
+			}
+			continue
+		}
+
+		this.next = item
+		this.nextKey = key
+		return true
+	}
+	return true
+}
+
+func (this *mergeInner) release(context *Context) {
+	if context.UseRequestQuota() {
+		for _, item := range this.group {
+			context.ReleaseValueSize(item.Size())
+		}
+	}
+	for i := range this.group {
+		this.group[i] = nil
+	}
+	this.group = this.group[:0]
+	this.groupKey = nil
+}
+
+func (this *mergeInner) close(context *Context) {
+	this.release(context)
+	if this.next != nil {
+		if context.UseRequestQuota() {
+			context.ReleaseValueSize(this.next.Size())
+		}
+		this.next = nil
+		this.nextKey = nil
+	}
+
+	// the outer values may have run out first
+	if this.running > 0 {
+		notifyChildren(this.child)
+		this.op.childrenWaitNoStop(this.child)
+		this.running = 0
+	}
+}
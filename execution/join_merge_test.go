@@ -0,0 +1,241 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/file"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/value"
+)
+
+// opens a merge join inner side on an array of keys
+func openMergeInner(t *testing.T, keys []interface{}) (*mergeInner, *Context, *testOutput) {
+	output := &testOutput{}
+	context := newMockContext(t, output)
+	parent := NewCollect(plan.NewCollect(), context)
+	child := NewExpressionScan(plan.NewExpressionScan(expression.NewConstant(keys), "r", false, nil,
+		-1.0, -1.0, -1, -1.0), context)
+
+	inner := &mergeInner{}
+	inner.open(parent, child, expression.NewIdentifier("r"), context, nil)
+	return inner, context, output
+}
+
+func seekMergeInner(t *testing.T, inner *mergeInner, key interface{}, context *Context) (int, bool) {
+	item := value.NewAnnotatedValue(map[string]interface{}{"o": key})
+	group, ok := inner.seek(item, expression.NewIdentifier("o"), context)
+	for _, av := range group {
+		if r, _ := av.Field("r"); r.Collate(value.NewValue(key)) != 0 {
+			t.Errorf("Unexpected inner value %v for outer key %v", r, key)
+		}
+	}
+	return len(group), ok
+}
+
+func TestMergeInner(t *testing.T) {
+	inner, context, output := openMergeInner(t, []interface{}{nil, 1, 1, 2, 3, 5, 5, 5, 8})
+
+	// outer keys with the number of inner values they match
+	seeks := []struct {
+		key     interface{}
+		matches int
+	}{
+		{nil, 0},
+		{0, 0},
+		{1, 2},
+		{2, 1},
+		{2, 1},
+		{4, 0},
+		{5, 3},
+		{9, 0},
+		{10, 0},
+	}
+	for _, s := range seeks {
+		n, ok := seekMergeInner(t, inner, s.key, context)
+		if !ok {
+			t.Fatalf("Failed to seek %v: %v", s.key, output.err)
+		}
+		if n != s.matches {
+			t.Errorf("Expected %d inner values for %v, got %d", s.matches, s.key, n)
+		}
+	}
+	inner.close(context)
+
+	// the outer values run out first
+	inner, context, output = openMergeInner(t, []interface{}{1, 2, 3})
+	if n, ok := seekMergeInner(t, inner, 1, context); !ok || n != 1 {
+		t.Errorf("Expected 1 inner value, got %d: %v", n, output.err)
+	}
+	inner.close(context)
+
+	// values out of order are errors
+	inner, context, output = openMergeInner(t, []interface{}{1, 2, 3})
+	seekMergeInner(t, inner, 2, context)
+	if _, ok := seekMergeInner(t, inner, 1, context); ok || output.err == nil {
+		t.Errorf("Expected outer values out of order to fail")
+	}
+	inner.close(context)
+
+	inner, context, output = openMergeInner(t, []interface{}{1, 3, 2})
+	if _, ok := seekMergeInner(t, inner, 3, context); ok || output.err == nil {
+		t.Errorf("Expected inner values out of order to fail")
+	}
+	inner.close(context)
+}
+
+func newMergeStore(t *testing.T) (datastore.Datastore, string) {
+	dir, er := ioutil.TempDir("", "mergejoin")
+	if er != nil {
+		t.Fatalf("Failed to create directory: %v", er)
+	}
+
+	ksPath := filepath.Join(dir, "default", "game")
+	if er = os.MkdirAll(ksPath, 0777); er != nil {
+		t.Fatalf("Failed to create keyspace: %v", er)
+	}
+	for i := 0; i < 40; i++ {
+		doc := fmt.Sprintf(`{"name": "g%d", "score": %d}`, i, i%7)
+		if i%10 == 0 {
+			doc = fmt.Sprintf(`{"name": "g%d"}`, i)
+		}
+		er = ioutil.WriteFile(filepath.Join(ksPath, fmt.Sprintf("g%d.json", i)), []byte(doc), 0666)
+		if er != nil {
+			t.Fatalf("Failed to write document: %v", er)
+		}
+	}
+
+	store, err := file.NewDatastore(dir)
+	if err != nil {
+		t.Fatalf("Failed to create datastore: %v", err)
+	}
+	namespace, _ := store.NamespaceByName("default")
+	keyspace, err := namespace.KeyspaceByName("game")
+	if err != nil {
+		t.Fatalf("Failed to get keyspace: %v", err)
+	}
+	indexer, _ := keyspace.Indexer(datastore.GSI)
+	_, err = indexer.(datastore.Indexer2).CreateIndex2("", "ix_score", nil,
+		datastore.IndexKeys{&datastore.IndexKey{Expr: expression.NewIdentifier("score")}}, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to create index: %v", err)
+	}
+	return store, dir
+}
+
+// the operators of the EXPLAIN output of a statement
+func explainOperators(t *testing.T, store datastore.Datastore, stmt string) map[string]bool {
+	results, _ := runStatement(t, newTestContext(store, "default", &testOutput{}), "EXPLAIN "+stmt)
+	operators := make(map[string]bool)
+	var collect func(interface{})
+	collect = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			if op, ok := v["#operator"].(string); ok {
+				operators[op] = true
+			}
+			for _, child := range v {
+				collect(child)
+			}
+		case []interface{}:
+			for _, child := range v {
+				collect(child)
+			}
+		}
+	}
+	collect(results.Actual())
+	return operators
+}
+
+func TestMergeJoinPlan(t *testing.T) {
+	store, dir := newMergeStore(t)
+	defer os.RemoveAll(dir)
+
+	cases := []struct {
+		stmt     string
+		operator string
+		expected string
+	}{
+		// ordered covering scans on both sides
+		{"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 JOIN game AS g2 ON g1.score = g2.score " +
+			"WHERE g1.score > 0 AND g2.score > 0 ORDER BY s1, s2",
+			"MergeJoin",
+			"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 JOIN game AS g2 USE NL ON g1.score = g2.score " +
+				"WHERE g1.score > 0 AND g2.score > 0 ORDER BY s1, s2"},
+		{"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 JOIN game AS g2 USE MERGE ON g2.score = g1.score " +
+			"WHERE g1.score > 0 AND g2.score > 0 ORDER BY s1, s2",
+			"MergeJoin",
+			"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 JOIN game AS g2 USE NL ON g2.score = g1.score " +
+				"WHERE g1.score > 0 AND g2.score > 0 ORDER BY s1, s2"},
+		{"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 LEFT JOIN game AS g2 ON g1.score = g2.score + 2 " +
+			"AND g2.score > 0 WHERE g1.score > 0 ORDER BY s1, s2",
+			"",
+			""},
+		{"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 LEFT JOIN game AS g2 ON g1.score = g2.score " +
+			"AND g2.score > 3 WHERE g1.score > 0 ORDER BY s1, s2",
+			"MergeJoin",
+			"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 LEFT JOIN game AS g2 USE NL ON g1.score = g2.score " +
+				"AND g2.score > 3 WHERE g1.score > 0 ORDER BY s1, s2"},
+		{"SELECT g1.score AS s1 FROM game AS g1 NEST game AS g2 ON g1.score = g2.score " +
+			"WHERE g1.score > 0 ORDER BY s1",
+			"MergeNest",
+			"SELECT g1.score AS s1 FROM game AS g1 NEST game AS g2 USE NL ON g1.score = g2.score " +
+				"WHERE g1.score > 0 ORDER BY s1"},
+		{"SELECT g1.score AS s1, ARRAY_LENGTH(g2) AS n FROM game AS g1 NEST game AS g2 ON g1.score = g2.score " +
+			"WHERE g1.score > 0 ORDER BY s1, n",
+			"",
+			""},
+
+		// the join hint is followed
+		{"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 JOIN game AS g2 USE HASH(BUILD) ON g1.score = g2.score " +
+			"WHERE g1.score > 0 AND g2.score > 0",
+			"HashJoin",
+			""},
+		{"SELECT g1.score AS s1, g2.score AS s2 FROM game AS g1 JOIN game AS g2 USE NL ON g1.score = g2.score " +
+			"WHERE g1.score > 0 AND g2.score > 0",
+			"NestedLoopJoin",
+			""},
+
+		// the inner side is not covered
+		{"SELECT g1.score AS s1, g2.name FROM game AS g1 JOIN game AS g2 ON g1.score = g2.score " +
+			"WHERE g1.score > 0 AND g2.score > 0",
+			"NestedLoopJoin",
+			""},
+	}
+
+	for _, c := range cases {
+		operators := explainOperators(t, store, c.stmt)
+		if c.operator == "" {
+			if operators["MergeJoin"] || operators["MergeNest"] {
+				t.Errorf("Expected no merge join for %s: %v", c.stmt, operators)
+			}
+		} else if !operators[c.operator] {
+			t.Errorf("Expected %s for %s: %v", c.operator, c.stmt, operators)
+		}
+
+		if c.expected != "" {
+			if operators = explainOperators(t, store, c.expected); operators["MergeJoin"] || operators["MergeNest"] {
+				t.Errorf("Expected no merge join for %s: %v", c.expected, operators)
+			}
+			results, _ := runStatement(t, newTestContext(store, "default", &testOutput{}), c.stmt)
+			expected, _ := runStatement(t, newTestContext(store, "default", &testOutput{}), c.expected)
+			if len(results.Actual().([]interface{})) == 0 || !expected.Equals(results).Truth() {
+				t.Errorf("Unexpected results for %s: %v, expected %v", c.stmt, results, expected)
+			}
+		}
+	}
+}
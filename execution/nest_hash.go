@@ -27,6 +27,7 @@ type HashNest struct {
 	hashTab   *util.HashTable
 	buildVals value.Values
 	probeVals value.Values
+	grace     *graceHash
 }
 
 func NewHashNest(plan *plan.HashNest, context *Context, child Operator, aliasMap map[string]string) *HashNest {
@@ -62,6 +63,9 @@ func (this *HashNest) PlanOp() plan.Operator {
 }
 
 func (this *HashNest) RunOnce(context *Context, parent value.Value) {
+	defer func() {
+		this.grace.close()
+	}()
 	this.runConsumer(this, context, parent)
 }
 
@@ -99,13 +103,40 @@ func (this *HashNest) beforeItems(context *Context, parent value.Value) bool {
 
 	this.fork(this.child, context, parent)
 
+	this.grace = newGraceHash(&this.base, context)
 	return buildHashTab(&(this.base), this.child, this.hashTab,
-		this.plan.BuildExprs(), this.buildVals, context)
+		this.plan.BuildExprs(), this.buildVals, this.grace, context)
 }
 
 func (this *HashNest) processItem(item value.AnnotatedValue, context *Context) bool {
 	defer this.switchPhase(_EXECTIME)
 
+	if this.grace.spilling() {
+		probeVal := getProbeVal(item, this.plan.ProbeExprs(), this.probeVals, context)
+		if probeVal == nil {
+			return false
+		}
+		spilled, ok := this.grace.writeProbe(probeVal, item, context)
+		if spilled || !ok {
+			return ok
+		}
+		this.dropHashTable(context)
+		this.hashTab = util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+		if !this.grace.unspill(this.hashTab, this.plan.BuildExprs(), this.buildVals,
+			func(item value.AnnotatedValue) bool {
+				return this.probe(item, context)
+			}, context) {
+			return false
+		}
+	}
+	return this.probe(item, context)
+}
+
+/*
+All the values matching a probe value are in the same grace hash
+partition, so each probe value is nested against a single partition.
+*/
+func (this *HashNest) probe(item value.AnnotatedValue, context *Context) bool {
 	var err error
 	var outVal interface{}
 	var right_items value.AnnotatedValues
@@ -177,6 +208,21 @@ func (this *HashNest) processItem(item value.AnnotatedValue, context *Context) b
 }
 
 func (this *HashNest) afterItems(context *Context) {
+	if this.grace.spilling() {
+		this.dropHashTable(context)
+		this.grace.join(this.plan.BuildExprs(), this.buildVals,
+			func() *util.HashTable {
+				this.hashTab = util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+				return this.hashTab
+			},
+			func(item value.AnnotatedValue) bool {
+				return this.probe(item, context)
+			},
+			func() {
+				this.dropHashTable(context)
+			}, context)
+		this.grace.close()
+	}
 	this.dropHashTable(context)
 	this.plan.Onclause().ResetMemory(context)
 }
@@ -0,0 +1,179 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"encoding/json"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/value"
+)
+
+type MergeNest struct {
+	base
+	plan      *plan.MergeNest
+	child     Operator
+	aliasMap  map[string]string
+	ansiFlags uint32
+	inner     mergeInner
+}
+
+func NewMergeNest(plan *plan.MergeNest, context *Context, child Operator, aliasMap map[string]string) *MergeNest {
+	rv := &MergeNest{
+		plan:     plan,
+		child:    child,
+		aliasMap: aliasMap,
+	}
+
+	newBase(&rv.base, context)
+	rv.trackChildren(1)
+	rv.execPhase = MERGE_NEST
+	rv.output = rv
+	return rv
+}
+
+func (this *MergeNest) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitMergeNest(this)
+}
+
+func (this *MergeNest) Copy() Operator {
+	rv := &MergeNest{
+		plan:     this.plan,
+		child:    this.child.Copy(),
+		aliasMap: this.aliasMap,
+	}
+	this.base.copy(&rv.base)
+	return rv
+}
+
+func (this *MergeNest) PlanOp() plan.Operator {
+	return this.plan
+}
+
+func (this *MergeNest) RunOnce(context *Context, parent value.Value) {
+	this.runConsumer(this, context, parent)
+}
+
+func (this *MergeNest) beforeItems(context *Context, parent value.Value) bool {
+	if !context.assert(this.child != nil, "MERGE NEST has no child") {
+		return false
+	}
+	if !context.assert(this.plan.Onclause() != nil, "MERGE NEST does not have onclause") {
+		return false
+	}
+
+	// check for constant TRUE or FALSE onclause
+	cpred := this.plan.Onclause().Value()
+	if cpred != nil {
+		if cpred.Truth() {
+			this.ansiFlags |= ANSI_ONCLAUSE_TRUE
+		} else {
+			this.ansiFlags |= ANSI_ONCLAUSE_FALSE
+		}
+	} else {
+		this.plan.Onclause().EnableInlistHash(context)
+		SetSearchInfo(this.aliasMap, parent, context, this.plan.Onclause())
+	}
+
+	this.inner.open(this, this.child, this.plan.InnerKey(), context, parent)
+	return true
+}
+
+func (this *MergeNest) processItem(item value.AnnotatedValue, context *Context) bool {
+	defer this.switchPhase(_EXECTIME)
+
+	group, ok := this.inner.seek(item, this.plan.OuterKey(), context)
+	if !ok {
+		return false
+	}
+
+	var right_items value.AnnotatedValues
+	aliases := []string{this.plan.Alias()}
+	for _, right_item := range group {
+		// the ON clause is evaluated as for a join, with the covers of the right-hand side
+		var match bool
+		var joined value.AnnotatedValue
+		match, ok, joined = processAnsiExec(item, right_item, this.plan.Onclause(),
+			aliases, this.ansiFlags, context, "join")
+		if joined != nil {
+			joined.Recycle()
+		}
+		if !ok {
+			return false
+		}
+		if match {
+			right_items = append(right_items, right_item)
+		}
+	}
+
+	var joined value.AnnotatedValue
+	joined, ok = processAnsiNest(item, right_items, this.plan.Alias(), this.plan.Outer(), context)
+	if !ok {
+		return false
+	}
+	if joined != nil {
+		if this.plan.Filter() != nil {
+			result, err := this.plan.Filter().Evaluate(joined, context)
+			if err != nil {
+				context.Error(errors.NewEvaluationError(err, "merge nest filter"))
+				return false
+			}
+			if !result.Truth() {
+				return true
+			}
+		}
+		if context.UseRequestQuota() {
+			iSz := item.Size()
+			jSz := joined.Size()
+			if jSz > iSz {
+				if context.TrackValueSize(jSz - iSz) {
+					context.Error(errors.NewMemoryQuotaExceededError())
+					return false
+				}
+			} else {
+				context.ReleaseValueSize(iSz - jSz)
+			}
+		}
+		return this.sendItem(joined)
+	}
+
+	return true
+}
+
+func (this *MergeNest) afterItems(context *Context) {
+	this.inner.close(context)
+	this.plan.Onclause().ResetMemory(context)
+}
+
+func (this *MergeNest) MarshalJSON() ([]byte, error) {
+	r := this.plan.MarshalBase(func(r map[string]interface{}) {
+		this.marshalTimes(r)
+		r["~child"] = this.child
+	})
+	return json.Marshal(r)
+}
+
+func (this *MergeNest) SendAction(action opAction) {
+	this.baseSendAction(action)
+	child := this.child
+	if child != nil {
+		child.SendAction(action)
+	}
+}
+
+func (this *MergeNest) Done() {
+	this.baseDone()
+	if this.child != nil {
+		child := this.child
+		this.child = nil
+		child.Done()
+	}
+}
@@ -10,8 +10,10 @@
 package execution
 
 import (
+	"container/heap"
 	"encoding/json"
 
+	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/sort"
 	"github.com/couchbase/query/value"
@@ -19,10 +21,14 @@ import (
 
 type Order struct {
 	base
-	plan    *plan.Order
-	values  value.AnnotatedValues
-	context *Context
-	terms   []string
+	plan      *plan.Order
+	values    value.AnnotatedValues
+	context   *Context
+	terms     []string
+	size      uint64
+	threshold uint64
+	noSpill   bool
+	runs      []*spillFile
 }
 
 const _ORDER_CAP = 1024
@@ -60,10 +66,22 @@ func (this *Order) PlanOp() plan.Operator {
 
 func (this *Order) RunOnce(context *Context, parent value.Value) {
 	defer this.releaseValues()
+	defer this.closeRuns()
 	this.runConsumer(this, context, parent)
 }
 
 func (this *Order) processItem(item value.AnnotatedValue, context *Context) bool {
+	if !this.noSpill {
+		if this.threshold == 0 {
+			this.threshold = spillThreshold(context)
+			this.noSpill = this.threshold == 0
+		}
+		this.size += item.Size()
+		if this.size > this.threshold && !this.noSpill && !this.spillRun(context) {
+			return false
+		}
+	}
+
 	if len(this.values) == cap(this.values) {
 		values := make(value.AnnotatedValues, len(this.values), len(this.values)<<1)
 		copy(values, this.values)
@@ -98,8 +116,17 @@ func (this *Order) afterItems(context *Context) {
 	this.setupTerms(context)
 	sort.Sort(this)
 
-	context.SetSortCount(uint64(this.Len()))
-	context.AddPhaseCount(SORT, uint64(this.Len()))
+	count := uint64(this.Len())
+	for _, run := range this.runs {
+		count += uint64(run.count)
+	}
+	context.SetSortCount(count)
+	context.AddPhaseCount(SORT, count)
+
+	if len(this.runs) > 0 {
+		this.mergeRuns(context)
+		return
+	}
 
 	for _, av := range this.values {
 		if !this.sendItem(av) {
@@ -108,6 +135,160 @@ func (this *Order) afterItems(context *Context) {
 	}
 }
 
+/*
+External merge sort: values are sorted and written to disk in runs,
+which are merged once all values are in. Sort keys are evaluated
+against the original values, which are not spilled, so they are
+cached before the values are written.
+*/
+func (this *Order) spillRun(context *Context) bool {
+	this.setupTerms(context)
+	for _, av := range this.values {
+		for i, term := range this.plan.Terms() {
+			_, e := getOriginalCachedValue(av, term.Expression(), this.terms[i], context)
+			if e != nil {
+				return false
+			}
+		}
+	}
+
+	if len(this.values) > 0 {
+		if _, ok := encodeSpillValue(this.values[0]); !ok {
+			this.noSpill = true
+			return true
+		}
+	}
+
+	sort.Sort(this)
+	run, err := this.newSpillFile("sort run", context)
+	if err != nil {
+		context.Error(err)
+		return false
+	}
+	this.runs = append(this.runs, run)
+
+	for i, av := range this.values {
+		err = run.write(av, context)
+		if err != nil {
+			context.Error(err)
+			return false
+		}
+		av.Recycle()
+		this.values[i] = nil
+	}
+
+	err = run.rewind()
+	if err != nil {
+		context.Error(err)
+		return false
+	}
+
+	this.values = this.values[0:0]
+	this.size = 0
+	return true
+}
+
+func (this *Order) mergeRuns(context *Context) {
+	merge := &orderMerge{
+		order:   this,
+		cursors: make([]*orderCursor, 0, len(this.runs)+1),
+	}
+
+	for _, run := range this.runs {
+		cursor := &orderCursor{run: run}
+		if !cursor.next(context) {
+			return
+		}
+		if cursor.current != nil {
+			merge.cursors = append(merge.cursors, cursor)
+		}
+	}
+
+	if len(this.values) > 0 {
+		cursor := &orderCursor{values: this.values}
+		cursor.next(context)
+		merge.cursors = append(merge.cursors, cursor)
+	}
+
+	heap.Init(merge)
+	for merge.Len() > 0 {
+		cursor := merge.cursors[0]
+		if !this.sendItem(cursor.current) {
+			return
+		}
+
+		if !cursor.next(context) {
+			return
+		}
+		if cursor.current == nil {
+			heap.Pop(merge)
+		} else {
+			heap.Fix(merge, 0)
+		}
+	}
+}
+
+func (this *Order) closeRuns() {
+	closeSpillFiles(this.runs)
+	this.runs = nil
+	this.size = 0
+}
+
+// the next value of a sorted run, either on disk or in memory
+type orderCursor struct {
+	run     *spillFile
+	values  value.AnnotatedValues
+	pos     int
+	current value.AnnotatedValue
+}
+
+func (this *orderCursor) next(context *Context) bool {
+	if this.run == nil {
+		this.current = nil
+		if this.pos < len(this.values) {
+			this.current = this.values[this.pos]
+			this.pos++
+		}
+		return true
+	}
+
+	var err errors.Error
+	this.current, err = this.run.read(context)
+	if err != nil {
+		context.Error(err)
+		return false
+	}
+	return true
+}
+
+type orderMerge struct {
+	order   *Order
+	cursors []*orderCursor
+}
+
+func (this *orderMerge) Len() int {
+	return len(this.cursors)
+}
+
+func (this *orderMerge) Less(i, j int) bool {
+	return this.order.lessThan(this.cursors[i].current, this.cursors[j].current)
+}
+
+func (this *orderMerge) Swap(i, j int) {
+	this.cursors[i], this.cursors[j] = this.cursors[j], this.cursors[i]
+}
+
+func (this *orderMerge) Push(item interface{}) {
+	this.cursors = append(this.cursors, item.(*orderCursor))
+}
+
+func (this *orderMerge) Pop() interface{} {
+	index := len(this.cursors) - 1
+	item := this.cursors[index]
+	this.cursors = this.cursors[0:index]
+	return item
+}
+
 func (this *Order) releaseValues() {
 	_ORDER_POOL.Put(this.values)
 	this.values = nil
@@ -176,5 +357,6 @@ func (this *Order) MarshalJSON() ([]byte, error) {
 func (this *Order) reopen(context *Context) bool {
 	rv := this.baseReopen(context)
 	this.values = _ORDER_POOL.Get()
+	this.closeRuns()
 	return rv
 }
@@ -91,6 +91,7 @@ func (this *OrderLimit) PlanOp() plan.Operator {
 
 func (this *OrderLimit) RunOnce(context *Context, parent value.Value) {
 	defer this.releaseValues()
+	defer this.closeRuns()
 	this.runConsumer(this, context, parent)
 }
 
@@ -178,13 +179,18 @@ func (this *OrderLimit) afterItems(context *Context) {
 
 	// Deal with the case no data item is needed at all:
 	// when offset is too large.
-	len := len(this.values)
+	// The fallback sort may have spilled values to disk.
+	len := int64(len(this.values))
+	for _, run := range this.runs {
+		len += run.count
+	}
 	offset := int64(0)
 	if this.offset != nil {
 		offset = this.offset.offset
 	}
-	if offset >= int64(len) {
+	if offset >= len {
 		this.values = this.values[0:0]
+		this.closeRuns()
 	}
 
 	this.Order.afterItems(context)
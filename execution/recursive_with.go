@@ -0,0 +1,221 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"encoding/json"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/value"
+)
+
+// iterations allowed when the recursion is not capped by OPTIONS
+const _MAX_RECURSIVE_LEVELS = 1000
+
+type RecursiveWith struct {
+	base
+	plan  *plan.RecursiveWith
+	child Operator
+}
+
+func NewRecursiveWith(plan *plan.RecursiveWith, context *Context, child Operator) *RecursiveWith {
+	rv := &RecursiveWith{
+		plan:  plan,
+		child: child,
+	}
+
+	newBase(&rv.base, context)
+	rv.base.setInline()
+	rv.output = rv
+	return rv
+}
+
+func (this *RecursiveWith) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitRecursiveWith(this)
+}
+
+func (this *RecursiveWith) Copy() Operator {
+	rv := &RecursiveWith{plan: this.plan, child: this.child.Copy()}
+	this.base.copy(&rv.base)
+	return rv
+}
+
+func (this *RecursiveWith) PlanOp() plan.Operator {
+	return this.plan
+}
+
+func (this *RecursiveWith) RunOnce(context *Context, parent value.Value) {
+	this.once.Do(func() {
+		defer context.Recover(&this.base) // Recover from any panic
+		active := this.active()
+		this.SetKeepAlive(1, context) // terminate early
+		this.switchPhase(_EXECTIME)
+		this.setExecPhase(RUN, context)
+		defer func() { this.switchPhase(_NOTIME) }() // accrue current phase's time
+
+		if !active || !context.assert(this.child != nil, "RecursiveWith has no child") {
+			this.notify()
+			this.fail(context)
+			return
+		}
+
+		this.child.SetInput(this.input)
+		this.child.SetOutput(this.output)
+		this.child.SetStop(nil)
+		this.child.SetParent(this)
+
+		var wv value.AnnotatedValue
+
+		if parent != nil {
+			wv = value.NewAnnotatedValue(parent.Copy())
+		} else {
+			wv = value.NewAnnotatedValue(make(map[string]interface{}, 1))
+		}
+
+		results, err := this.evaluate(wv, context)
+		if err != nil {
+			context.Error(err)
+			this.notify()
+
+			// have to start the child for the output and stop
+			// operators to be set properly by sequences
+		}
+
+		wv.SetField(this.plan.With().Alias(), results)
+		this.fork(this.child, context, wv)
+	})
+}
+
+/*
+Evaluate the anchor, then the recursive member against the rows
+produced by the previous iteration, until no new rows are produced
+or a cap is reached.
+*/
+func (this *RecursiveWith) evaluate(wv value.AnnotatedValue, context *Context) (value.Value, errors.Error) {
+	with := this.plan.With()
+	alias := with.Alias()
+	levels := with.Levels()
+	documents := with.Documents()
+
+	var seen *value.Set
+	if len(with.Cycle()) > 0 || !with.UnionAll() {
+		seen = value.NewSet(_MAP_POOL_CAP, false, false)
+	}
+
+	results := make([]interface{}, 0, _MAP_POOL_CAP)
+	query := with.Anchor()
+	for level := int64(0); ; level++ {
+		rows, e := context.EvaluateSubquery(query, wv)
+		if e != nil {
+			return value.EMPTY_ARRAY_VALUE, errors.NewEvaluationError(e, "WITH RECURSIVE "+alias)
+		}
+
+		work, err := this.filter(with, rows, seen, context)
+		if err != nil {
+			return value.EMPTY_ARRAY_VALUE, err
+		}
+		if documents > 0 && int64(len(results)+len(work)) >= documents {
+			results = append(results, work[:documents-int64(len(results))]...)
+			break
+		}
+		results = append(results, work...)
+
+		if len(work) == 0 || (levels > 0 && level >= levels) {
+			break
+		}
+		if levels == 0 && level >= _MAX_RECURSIVE_LEVELS {
+			return value.EMPTY_ARRAY_VALUE, errors.NewRecursiveWithLimitError(alias, _MAX_RECURSIVE_LEVELS)
+		}
+
+		wv.SetField(alias, work)
+		query = with.Recursive()
+	}
+
+	return value.NewValue(results), nil
+}
+
+/*
+Discard the rows already produced, as determined by the CYCLE
+expressions, or by the whole row for UNION.
+*/
+func (this *RecursiveWith) filter(with *algebra.With, rows value.Value, seen *value.Set,
+	context *Context) ([]interface{}, errors.Error) {
+	items, _ := rows.Actual().([]interface{})
+	if seen == nil {
+		return items, nil
+	}
+
+	cycle := with.Cycle()
+	rv := make([]interface{}, 0, len(items))
+	for _, item := range items {
+		row := value.NewValue(item)
+		key := row
+		if len(cycle) > 0 {
+			keys := make([]interface{}, len(cycle))
+			for i, expr := range cycle {
+				v, e := expr.Evaluate(row, context)
+				if e != nil {
+					return nil, errors.NewEvaluationError(e, "CYCLE")
+				}
+				keys[i] = v
+			}
+			key = value.NewValue(keys)
+		}
+
+		if !seen.Has(key) {
+			seen.Add(key)
+			rv = append(rv, item)
+		}
+	}
+	return rv, nil
+}
+
+func (this *RecursiveWith) MarshalJSON() ([]byte, error) {
+	r := this.plan.MarshalBase(func(r map[string]interface{}) {
+		this.marshalTimes(r)
+	})
+	r["~child"] = this.child
+	return json.Marshal(r)
+}
+
+func (this *RecursiveWith) accrueTimes(o Operator) {
+	if baseAccrueTimes(this, o) {
+		return
+	}
+	copy, _ := o.(*RecursiveWith)
+	this.child.accrueTimes(copy.child)
+}
+
+func (this *RecursiveWith) SendAction(action opAction) {
+	this.baseSendAction(action)
+	child := this.child
+	if child != nil {
+		child.SendAction(action)
+	}
+}
+
+func (this *RecursiveWith) reopen(context *Context) bool {
+	rv := this.baseReopen(context)
+	if rv && this.child != nil {
+		rv = this.child.reopen(context)
+	}
+	return rv
+}
+
+func (this *RecursiveWith) Done() {
+	this.baseDone()
+	if this.child != nil {
+		child := this.child
+		this.child = nil
+		child.Done()
+	}
+}
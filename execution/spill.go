@@ -0,0 +1,334 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+// Operators that hold values in memory spill them to disk once they
+// exceed the spill threshold, or half the request memory quota.
+// Spilled values carry their annotations: covers, meta, and the
+// attachments operators rely on, such as aggregates and cached sort keys.
+
+type spillFile struct {
+	file  *util.SpillFile
+	op    *base
+	what  string
+	count int64
+}
+
+// the memory an operator can use before spilling, 0 if it can't spill
+func spillThreshold(context *Context) uint64 {
+	if util.SpillLimit() <= 0 || util.SpillThreshold() <= 0 {
+		return 0
+	}
+
+	threshold := uint64(util.SpillThreshold())
+	if context.UseRequestQuota() && context.GetMemoryQuota()/2 < threshold {
+		threshold = context.GetMemoryQuota() / 2
+	}
+	return threshold
+}
+
+func (this *base) newSpillFile(what string, context *Context) (*spillFile, errors.Error) {
+	file, err := util.NewSpillFile()
+	if err != nil {
+		return nil, spillError(err, what)
+	}
+
+	this.spills++
+	context.AddPhaseOperator(SPILL)
+	return &spillFile{file: file, op: this, what: what}, nil
+}
+
+func spillError(err error, what string) errors.Error {
+	if err == util.ErrSpillLimitExceeded {
+		return errors.NewSpillLimitError(util.SpillLimit())
+	}
+	return errors.NewSpillError(err, what)
+}
+
+// intermediate values holding sets, lists or sketches cannot be encoded
+func spillableAggregate(agg algebra.Aggregate) bool {
+	if agg.HasFlags(algebra.AGGREGATE_DISTINCT) {
+		return false
+	}
+
+	switch agg.(type) {
+	case *algebra.ArrayAgg, *algebra.StringAgg, *algebra.Median, *algebra.Mode,
+		*algebra.PercentileCont, *algebra.PercentileDisc,
+		*algebra.Stddev, *algebra.StddevPop, *algebra.StddevSamp,
+		*algebra.Variance, *algebra.VarPop, *algebra.VarSamp,
+		*algebra.ApproxCountDistinct, *algebra.ApproxPercentile,
+		*algebra.SketchHll, *algebra.SketchKll, *algebra.SketchMerge:
+		return false
+	}
+	return true
+}
+
+// the value is no longer tracked by the memory quota once on disk
+func (this *spillFile) write(av value.AnnotatedValue, context *Context) errors.Error {
+	record, ok := encodeSpillValue(av)
+	if !ok {
+		return errors.NewSpillError(fmt.Errorf("annotations cannot be spilled"), this.what)
+	}
+	return this.writeRecord(record, av, context)
+}
+
+// for values already encoded
+func (this *spillFile) writeRecord(record []byte, av value.AnnotatedValue, context *Context) errors.Error {
+	err := this.file.Write(record)
+	if err != nil {
+		return spillError(err, this.what)
+	}
+
+	this.count++
+	this.op.spillSize += int64(len(record))
+	context.AddPhaseCount(SPILL, 1)
+	if context.UseRequestQuota() {
+		context.ReleaseValueSize(av.Size())
+	}
+	return nil
+}
+
+func (this *spillFile) rewind() errors.Error {
+	err := this.file.Rewind()
+	if err != nil {
+		return spillError(err, this.what)
+	}
+	return nil
+}
+
+// returns nil after the last value
+func (this *spillFile) read(context *Context) (value.AnnotatedValue, errors.Error) {
+	record, err := this.file.Read()
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, spillError(err, this.what)
+	}
+
+	av, err := decodeSpillValue(record)
+	if err != nil {
+		return nil, spillError(err, this.what)
+	}
+
+	if context.UseRequestQuota() && context.TrackValueSize(av.Size()) {
+		return nil, errors.NewMemoryQuotaExceededError()
+	}
+	return av, nil
+}
+
+func (this *spillFile) close() {
+	if this.file != nil {
+		this.file.Close()
+		this.file = nil
+	}
+}
+
+func closeSpillFiles(files []*spillFile) {
+	for _, f := range files {
+		if f != nil {
+			f.close()
+		}
+	}
+}
+
+// values that are part of annotations, MISSING included
+func encodeSpillData(v value.Value) (interface{}, bool) {
+	if _, ok := v.(value.AnnotatedValue); ok {
+		return nil, false
+	}
+	if v.Type() == value.MISSING {
+		return map[string]interface{}{"x": true}, true
+	}
+	return map[string]interface{}{"v": v}, true
+}
+
+func decodeSpillData(v value.Value) value.Value {
+	if d, ok := v.Field("v"); ok {
+		return d
+	}
+	return value.MISSING_VALUE
+}
+
+// Covering scans set the value as a field of itself: those fields are
+// spilled by name and restored when the value is read back.
+func spillFields(av value.AnnotatedValue) (value.Value, []interface{}, bool) {
+	v := av.GetValue()
+	if v.Type() != value.OBJECT {
+		return v, nil, true
+	}
+
+	var self []interface{}
+	fields := v.Fields()
+	for n, f := range fields {
+		if f == av {
+			self = append(self, n)
+		} else if fv, ok := f.(value.AnnotatedValue); ok && fv.Covers() != nil {
+			return nil, nil, false
+		}
+	}
+	if len(self) == 0 {
+		return v, nil, true
+	}
+
+	data := make(map[string]interface{}, len(fields))
+	for n, f := range fields {
+		if f != av {
+			data[n] = f
+		}
+	}
+	return value.NewValue(data), self, true
+}
+
+func encodeSpillValue(av value.AnnotatedValue) ([]byte, bool) {
+	record := make(map[string]interface{}, 6)
+	v, self, ok := spillFields(av)
+	if !ok {
+		return nil, false
+	}
+	data, ok := encodeSpillData(v)
+	if !ok {
+		return nil, false
+	}
+	record["v"] = data
+	if len(self) > 0 {
+		record["s"] = self
+	}
+
+	if attachments := av.Attachments(); len(attachments) > 0 {
+		encoded := make(map[string]interface{}, len(attachments))
+		for k, a := range attachments {
+			switch a := a.(type) {
+			case value.Value:
+				encoded[k], ok = encodeSpillData(a)
+			case map[string]value.Value:
+				m := make(map[string]interface{}, len(a))
+				for n, v := range a {
+					m[n], ok = encodeSpillData(v)
+					if !ok {
+						break
+					}
+				}
+				encoded[k] = map[string]interface{}{"a": m}
+			case map[string]bool:
+				m := make(map[string]interface{}, len(a))
+				for n, b := range a {
+					m[n] = b
+				}
+				encoded[k] = map[string]interface{}{"b": m}
+			case int:
+				encoded[k] = map[string]interface{}{"i": a}
+			case string:
+				encoded[k] = map[string]interface{}{"s": a}
+			default:
+				ok = false
+			}
+			if !ok {
+				return nil, false
+			}
+		}
+		record["a"] = encoded
+	}
+
+	if covers := av.Covers(); covers != nil {
+		record["c"] = covers
+	}
+	if id := av.GetId(); id != nil {
+		record["id"] = id
+	}
+	if meta := av.GetMeta(); len(meta) > 0 {
+		record["m"] = meta
+	}
+
+	bytes, err := value.NewValue(record).MarshalJSON()
+	return bytes, err == nil
+}
+
+// meta data that is not stored as JSON numbers
+var _SPILL_META_TYPES = map[string]func(int64) interface{}{
+	"cas":        func(n int64) interface{} { return uint64(n) },
+	"flags":      func(n int64) interface{} { return uint32(n) },
+	"expiration": func(n int64) interface{} { return uint32(n) },
+}
+
+func decodeSpillValue(record []byte) (value.AnnotatedValue, error) {
+	rv := value.NewValue(record)
+	if rv.Type() != value.OBJECT {
+		return nil, fmt.Errorf("invalid spill record")
+	}
+
+	data, _ := rv.Field("v")
+	av := value.NewAnnotatedValue(decodeSpillData(data))
+
+	if attachments, ok := rv.Field("a"); ok {
+		for k, a := range attachments.Fields() {
+			a := value.NewValue(a)
+			if v, ok := a.Field("a"); ok {
+				m := make(map[string]value.Value, len(v.Fields()))
+				for n, d := range v.Fields() {
+					m[n] = decodeSpillData(value.NewValue(d))
+				}
+				av.SetAttachment(k, m)
+			} else if v, ok := a.Field("b"); ok {
+				m := make(map[string]bool, len(v.Fields()))
+				for n, b := range v.Fields() {
+					m[n] = value.NewValue(b).Truth()
+				}
+				av.SetAttachment(k, m)
+			} else if v, ok := a.Field("i"); ok {
+				av.SetAttachment(k, int(value.AsNumberValue(v).Int64()))
+			} else if v, ok := a.Field("s"); ok {
+				av.SetAttachment(k, v.ToString())
+			} else {
+				av.SetAttachment(k, decodeSpillData(a))
+			}
+		}
+	}
+
+	if covers, ok := rv.Field("c"); ok {
+		for k, c := range covers.Fields() {
+			av.SetCover(k, value.NewValue(c))
+		}
+	}
+	if meta, ok := rv.Field("m"); ok {
+		m := av.NewMeta()
+		for k, v := range meta.Fields() {
+			v := value.NewValue(v)
+			if f, ok := _SPILL_META_TYPES[k]; ok && v.Type() == value.NUMBER {
+				m[k] = f(value.AsNumberValue(v).Int64())
+			} else {
+				m[k] = v.Actual()
+			}
+		}
+	}
+	if id, ok := rv.Field("id"); ok {
+		av.SetId(id.Actual())
+	}
+	if self, ok := rv.Field("s"); ok {
+		for i := 0; ; i++ {
+			n, ok := self.Index(i)
+			if !ok {
+				break
+			}
+			av.SetField(n.ToString(), av)
+		}
+	}
+
+	return av, nil
+}
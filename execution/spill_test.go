@@ -0,0 +1,212 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"sort"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+// runs the statement with the given spill threshold, 0 for the default
+func runSpill(t *testing.T, stmt string, threshold int64) (value.Value, uint64) {
+	if threshold > 0 {
+		defer util.SetSpillThreshold(util.SpillThreshold())
+		util.SetSpillThreshold(threshold)
+	}
+
+	output := &testOutput{}
+	results, _ := runStatement(t, newMockContext(t, output), stmt)
+	if util.SpillUsed() != 0 {
+		t.Errorf("Expected spill files to be removed after %s, %d bytes used", stmt, util.SpillUsed())
+	}
+	return results, atomic.LoadUint64(&output.spills)
+}
+
+// the spilled results match the in memory ones
+func checkSpill(t *testing.T, stmt string, spill bool) value.Value {
+	expected, spills := runSpill(t, stmt, 0)
+	if spills != 0 {
+		t.Fatalf("Expected %s not to spill, %d operators spilled", stmt, spills)
+	}
+
+	results, spills := runSpill(t, stmt, 1024)
+	if spill && spills == 0 {
+		t.Errorf("Expected %s to spill", stmt)
+	} else if !spill && spills != 0 {
+		t.Errorf("Expected %s not to spill, %d operators spilled", stmt, spills)
+	}
+	if !strings.Contains(stmt, "ORDER BY") {
+		sortResults(expected)
+		sortResults(results)
+	}
+	if !expected.Equals(results).Truth() {
+		t.Errorf("Unexpected results for %s: %v, expected %v", stmt, results, expected)
+	}
+	return results
+}
+
+func sortResults(results value.Value) {
+	actual := results.Actual().([]interface{})
+	sort.Slice(actual, func(i, j int) bool {
+		return value.NewValue(actual[i]).Collate(value.NewValue(actual[j])) < 0
+	})
+}
+
+func TestSortSpill(t *testing.T) {
+	results := checkSpill(t, "SELECT n FROM ARRAY_RANGE(0, 2000) AS n ORDER BY n % 7, n DESC", true)
+	if len(results.Actual().([]interface{})) != 2000 {
+		t.Errorf("Expected 2000 results, got %v", len(results.Actual().([]interface{})))
+	}
+
+	// spilled runs are counted against the offset
+	results = checkSpill(t, "SELECT n FROM ARRAY_RANGE(0, 2000) AS n ORDER BY n OFFSET 1990 LIMIT 100000", true)
+	if len(results.Actual().([]interface{})) != 10 {
+		t.Errorf("Expected 10 results, got %v", results)
+	}
+}
+
+func TestGroupSpill(t *testing.T) {
+	results := checkSpill(t, "SELECT n % 500 AS k, COUNT(1) AS c, SUM(n) AS s, MAX(n) AS m "+
+		"FROM ARRAY_RANGE(0, 4000) AS n GROUP BY n % 500 ORDER BY k", true)
+	if len(results.Actual().([]interface{})) != 500 {
+		t.Errorf("Expected 500 groups, got %v", len(results.Actual().([]interface{})))
+	}
+
+	// lists of values are not spilled
+	checkSpill(t, "SELECT n % 500 AS k, ARRAY_LENGTH(ARRAY_AGG(n)) AS c "+
+		"FROM ARRAY_RANGE(0, 4000) AS n GROUP BY n % 500", false)
+	checkSpill(t, "SELECT n % 500 AS k, COUNT(DISTINCT n % 3) AS c "+
+		"FROM ARRAY_RANGE(0, 4000) AS n GROUP BY n % 500", false)
+}
+
+func TestGraceHashSpill(t *testing.T) {
+	results := checkSpill(t, "SELECT a, b FROM ARRAY_RANGE(0, 1000) AS a "+
+		"JOIN ARRAY_RANGE(0, 2000) AS b USE HASH(BUILD) ON a = b % 1000 ORDER BY a, b", true)
+	if len(results.Actual().([]interface{})) != 2000 {
+		t.Errorf("Expected 2000 results, got %v", len(results.Actual().([]interface{})))
+	}
+
+	checkSpill(t, "SELECT a, b FROM ARRAY_RANGE(0, 1000) AS a "+
+		"LEFT JOIN ARRAY_RANGE(0, 1000) AS b USE HASH(BUILD) ON a = b * 2 ORDER BY a", true)
+
+	results = checkSpill(t, "SELECT a, ARRAY_LENGTH(b) AS c FROM ARRAY_RANGE(0, 500) AS a "+
+		"NEST ARRAY_RANGE(0, 1000) AS b USE HASH(BUILD) ON a = b % 500 ORDER BY a", true)
+	for _, r := range results.Actual().([]interface{}) {
+		if c, _ := value.NewValue(r).Field("c"); c.Actual() != float64(2) {
+			t.Errorf("Expected 2 nested values, got %v", r)
+			break
+		}
+	}
+}
+
+// probe values that cannot be spilled are joined in memory
+func TestGraceHashUnspill(t *testing.T) {
+	defer util.SetSpillThreshold(util.SpillThreshold())
+	util.SetSpillThreshold(1)
+
+	output := &testOutput{}
+	context := newMockContext(t, output)
+	var op base
+	newBase(&op, context)
+	grace := newGraceHash(&op, context)
+
+	exprs := expression.Expressions{expression.NewIdentifier("k")}
+	vals := make(value.Values, 1)
+	hashTab := util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+	for i := 0; i < 100; i++ {
+		item := value.NewAnnotatedValue(map[string]interface{}{"k": i % 10, "b": i})
+		buildVal := getHashVal(item, exprs, vals, "build", context)
+		if grace.spilling() {
+			if !grace.writeBuild(buildVal, item, context) {
+				t.Fatalf("Failed to spill build value %v: %v", i, output.err)
+			}
+			continue
+		}
+		hashTab.Put(buildVal, item, value.MarshalValue, value.EqualValue, item.Size())
+		if !grace.checkSpill(hashTab, exprs, vals, context) {
+			t.Fatalf("Failed to spill the hash table: %v", output.err)
+		}
+	}
+	if !grace.spilling() {
+		t.Fatalf("Expected the build values to be spilled")
+	}
+
+	for i := 0; i < 10; i++ {
+		item := value.NewAnnotatedValue(map[string]interface{}{"k": i})
+		if spilled, ok := grace.writeProbe(getHashVal(item, exprs, vals, "probe", context), item, context); !spilled || !ok {
+			t.Fatalf("Expected probe value %v to be spilled: %v", i, output.err)
+		}
+	}
+
+	item := value.NewAnnotatedValue(map[string]interface{}{"k": 3})
+	item.SetAttachment("unsupported", []int{3})
+	if spilled, ok := grace.writeProbe(getHashVal(item, exprs, vals, "probe", context), item, context); spilled || !ok {
+		t.Fatalf("Expected the probe value not to be spilled")
+	}
+
+	hashTab = util.NewHashTable(util.HASH_TABLE_FOR_HASH_JOIN)
+	probed := 0
+	if !grace.unspill(hashTab, exprs, vals, func(item value.AnnotatedValue) bool {
+		probed++
+		return true
+	}, context) {
+		t.Fatalf("Failed to load the spilled values: %v", output.err)
+	}
+	if grace.spilling() || util.SpillUsed() != 0 {
+		t.Errorf("Expected the spill files to be removed, %d bytes used", util.SpillUsed())
+	}
+	if probed != 10 || hashTab.Count() != 100 {
+		t.Errorf("Expected 10 probe and 100 build values, got %d and %d", probed, hashTab.Count())
+	}
+
+	// the remaining probe values are joined in memory
+	outVal, _ := hashTab.Get(getHashVal(item, exprs, vals, "probe", context), value.MarshalValue, value.EqualValue)
+	if outVal == nil {
+		t.Errorf("Expected a match for %v", item)
+	}
+}
+
+// covering scans set the value as a field of itself
+func TestSpillCoveredValue(t *testing.T) {
+	av := value.NewAnnotatedValue(map[string]interface{}{})
+	av.SetCover("cover ((`g`.`a`))", value.NewValue(1))
+	av.SetField("g", av)
+	if av.Size() == 0 {
+		t.Errorf("Expected a size for %v", av)
+	}
+
+	record, ok := encodeSpillValue(av)
+	if !ok {
+		t.Fatalf("Failed to encode the covered value")
+	}
+	rv, err := decodeSpillValue(record)
+	if err != nil {
+		t.Fatalf("Failed to decode the covered value: %v", err)
+	}
+	if g, _ := rv.Field("g"); g != rv {
+		t.Errorf("Expected the value to be a field of itself, got %v", g)
+	}
+	if c := rv.GetCover("cover ((`g`.`a`))"); c == nil || c.Actual() != float64(1) {
+		t.Errorf("Expected cover 1, got %v", c)
+	}
+
+	// values joined to covered values are not spilled
+	joined := value.NewAnnotatedValue(map[string]interface{}{"b": 1})
+	joined.SetField("g", av)
+	if _, ok = encodeSpillValue(joined); ok {
+		t.Errorf("Expected the joined value not to be spilled")
+	}
+}
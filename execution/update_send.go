@@ -18,6 +18,7 @@ import (
 	"github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/schemas"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -130,7 +131,9 @@ func (this *SendUpdate) flushBatch(context *Context) bool {
 		pairs = make([]value.Pair, 0, len(this.batch))
 	}
 
-	for i, item := range this.batch {
+	// documents that fail validation are left as they are, and not returned
+	i := 0
+	for _, item := range this.batch {
 		uv, ok := item.Field(this.plan.Alias())
 		if !ok {
 			context.Error(errors.NewUpdateAliasMissingError(this.plan.Alias()))
@@ -166,6 +169,11 @@ func (this *SendUpdate) flushBatch(context *Context) bool {
 			cav.CopyAnnotations(av)
 			pairs[i].Value = cav
 
+			if err := schemas.Validate(this.keyspace, key, cav); err != nil {
+				context.Error(err)
+				continue
+			}
+
 			if mv := clone.GetAttachment("options"); mv != nil {
 				options, _ = mv.(value.Value)
 			}
@@ -182,7 +190,11 @@ func (this *SendUpdate) flushBatch(context *Context) bool {
 				"Invalid UPDATE value of type %T.", clone)))
 			return false
 		}
+		this.batch[i] = item
+		i++
 	}
+	pairs = pairs[0:i]
+	this.batch = this.batch[0:i]
 
 	this.switchPhase(_SERVTIME)
 
@@ -47,10 +47,13 @@ type Visitor interface {
 	VisitNLNest(op *NLNest) (interface{}, error)
 	VisitHashJoin(op *HashJoin) (interface{}, error)
 	VisitHashNest(op *HashNest) (interface{}, error)
+	VisitMergeJoin(op *MergeJoin) (interface{}, error)
+	VisitMergeNest(op *MergeNest) (interface{}, error)
 
 	// Let + Letting, With
 	VisitLet(op *Let) (interface{}, error)
 	VisitWith(op *With) (interface{}, error)
+	VisitRecursiveWith(op *RecursiveWith) (interface{}, error)
 
 	// Filter
 	VisitFilter(op *Filter) (interface{}, error)
@@ -51,11 +51,16 @@ type AggregateInfo struct {
 	wTerm             *algebra.WindowTerm
 	once              bool
 	incremental       bool
+	sliding           bool
 	newCollationValue bool
 	val               value.Value
 	cumVal            value.Value
+	cumStart          int64
+	cumEnd            int64
 	sWindowVal        value.Value
 	eWindowVal        value.Value
+	sInterval         *expression.Interval
+	eInterval         *expression.Interval
 	dupsPreceding     int64
 	dupsFollowing     int64
 	obyValues         value.Values
@@ -263,7 +268,7 @@ func (this *AggregateInfo) setOnce(context *Context, parent value.Value) (err er
 	}
 
 	// aggregate can be incremental
-	this.incremental = !this.once && this.agg.Incremental() && !this.agg.Distinct()
+	this.incremental = !this.once && this.agg.Incremental()
 
 	// FIRST_VALUE(), LAST_VALUE(), NTH_VALUE() need special handling for duplicates. Set flag
 	if this.wTerm.OrderBy() != nil && this.hasFlags(_WINDOW_FIRST_VALUE|_WINDOW_LAST_VALUE|_WINDOW_NTH_VALUE) &&
@@ -282,6 +287,7 @@ func (this *AggregateInfo) setOnce(context *Context, parent value.Value) (err er
 		        window exclude is present
 		        window frame is current row only
 		        RANGE/GROUPS window frame is NOT start to current row
+		   RANGE/GROUPS window frame with value_expr slides over the rows
 		*/
 		this.incremental = this.incremental && !windowFrame.WindowFrameHasExclude()
 
@@ -290,13 +296,20 @@ func (this *AggregateInfo) setOnce(context *Context, parent value.Value) (err er
 				(between && !wfes[1].HasModifier(algebra.WINDOW_FRAME_CURRENT_ROW))
 
 			if this.incremental && !rowsWindow {
-				this.incremental = wfes[0].HasModifier(algebra.WINDOW_FRAME_UNBOUNDED_PRECEDING) &&
+				this.sliding = wfes[0].HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING|algebra.WINDOW_FRAME_VALUE_FOLLOWING) ||
+					(between && wfes[1].HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING|algebra.WINDOW_FRAME_VALUE_FOLLOWING))
+				this.incremental = !this.sliding && wfes[0].HasModifier(algebra.WINDOW_FRAME_UNBOUNDED_PRECEDING) &&
 					(!between || wfes[1].HasModifier(algebra.WINDOW_FRAME_CURRENT_ROW))
 			}
 		}
 
 		// Validate semantics of start frame VALUE expression
-		if wfes[0].HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING | algebra.WINDOW_FRAME_VALUE_FOLLOWING) {
+		if wfes[0].Interval() {
+			this.sInterval, err = this.windowValidateInterval(wfes[0].ValueExpression(), context, parent)
+			if err != nil {
+				return
+			}
+		} else if wfes[0].HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING | algebra.WINDOW_FRAME_VALUE_FOLLOWING) {
 			this.sWindowVal, err = this.windowValidateValExpr(wfes[0].ValueExpression(), rangeWindow, context, parent)
 			if err != nil {
 				return
@@ -310,7 +323,12 @@ func (this *AggregateInfo) setOnce(context *Context, parent value.Value) (err er
 				!windowFrame.WindowFrameHasExclude()
 
 			// Validate semantics of end frame VALUE expression
-			if wfes[1].HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING | algebra.WINDOW_FRAME_VALUE_FOLLOWING) {
+			if wfes[1].Interval() {
+				this.eInterval, err = this.windowValidateInterval(wfes[1].ValueExpression(), context, parent)
+				if err != nil {
+					return
+				}
+			} else if wfes[1].HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING | algebra.WINDOW_FRAME_VALUE_FOLLOWING) {
 				this.eWindowVal, err = this.windowValidateValExpr(wfes[1].ValueExpression(), rangeWindow, context, parent)
 				if err != nil {
 					return
@@ -376,7 +394,13 @@ func (this *AggregateInfo) evaluate(op *WindowAggregate, wf *windowFrame, cItem
 	}
 
 	if !empty && s <= e {
-		if this.incremental {
+		if this.sliding {
+			// RANGE/GROUPS window frame slides, remove outgoing rows and add incoming rows of frame
+			err = this.evaluateSliding(op, s, e, cItem)
+			if err != nil {
+				return err
+			}
+		} else if this.incremental {
 			// incremental aggregation, start with previous cumVal
 			this.val = this.cumVal
 			if wf.cIndex > 0 {
@@ -457,6 +481,47 @@ func (this *AggregateInfo) evaluate(op *WindowAggregate, wf *windowFrame, cItem
 	return err
 }
 
+/*
+evaluate the aggregate of the window frame that slides over the rows.
+cumVal has the rows cumStart to cumEnd of the previous frame.
+*/
+
+func (this *AggregateInfo) evaluateSliding(op *WindowAggregate, s, e, cItem int64) (err error) {
+	if s < this.cumStart || e < this.cumEnd || s > this.cumEnd+1 {
+		// frames don't overlap, start over
+		this.cumVal, err = this.agg.Default(op.values[cItem], op.context)
+		if err != nil {
+			return err
+		}
+		this.cumStart = s
+		this.cumEnd = s - 1
+	}
+
+	this.val = this.cumVal
+
+	// remove the outgoing rows of frame from cumVal
+	for c := this.cumStart; c < s; c++ {
+		this.val, err = this.agg.CumulateRemove(op.values[c], this.val, op.context)
+		if err != nil {
+			return err
+		}
+	}
+
+	// add the incoming rows of frame to cumVal
+	for c := this.cumEnd + 1; c <= e; c++ {
+		this.val, err = this.agg.CumulateInitial(op.values[c], this.val, op.context)
+		if err != nil {
+			return err
+		}
+	}
+
+	// store value into cumVal for feature
+	this.cumStart = s
+	this.cumEnd = e
+	this.cumVal = this.val.Copy()
+	return nil
+}
+
 /*
 evaluate the Value aggregates
 */
@@ -623,9 +688,9 @@ func (this *AggregateInfo) windowFramePositions(op *WindowAggregate, c int64) (*
 				sIndex, empty, err = this.windowValuePeerPos(op, nil, int64(0), cIndex, int64(-1), int64(1), true)
 			}
 		} else if wfe.HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING) {
-			sIndex, empty, err = this.windowValuePos(op, this.sWindowVal, cIndex, int64(-1), true)
+			sIndex, empty, err = this.windowValuePos(op, this.sWindowVal, this.sInterval, cIndex, int64(-1), true)
 		} else if wfe.HasModifier(algebra.WINDOW_FRAME_VALUE_FOLLOWING) {
-			sIndex, empty, err = this.windowValuePos(op, this.sWindowVal, cIndex, int64(1), true)
+			sIndex, empty, err = this.windowValuePos(op, this.sWindowVal, this.sInterval, cIndex, int64(1), true)
 		}
 
 		if err == nil && !empty {
@@ -640,9 +705,9 @@ func (this *AggregateInfo) windowFramePositions(op *WindowAggregate, c int64) (*
 						eIndex, empty, err = this.windowValuePeerPos(op, nil, int64(0), cIndex, int64(1), int64(1), false)
 					}
 				} else if wfe.HasModifier(algebra.WINDOW_FRAME_VALUE_PRECEDING) {
-					eIndex, empty, err = this.windowValuePos(op, this.eWindowVal, cIndex, int64(-1), false)
+					eIndex, empty, err = this.windowValuePos(op, this.eWindowVal, this.eInterval, cIndex, int64(-1), false)
 				} else if wfe.HasModifier(algebra.WINDOW_FRAME_VALUE_FOLLOWING) {
-					eIndex, empty, err = this.windowValuePos(op, this.eWindowVal, cIndex, int64(1), false)
+					eIndex, empty, err = this.windowValuePos(op, this.eWindowVal, this.eInterval, cIndex, int64(1), false)
 				}
 			} else {
 				// default frame
@@ -674,11 +739,11 @@ func (this *AggregateInfo) windowFramePositions(op *WindowAggregate, c int64) (*
 }
 
 /*
-  calculate physical pos from the value
+  calculate physical pos from the value or the interval
 */
 
-func (this *AggregateInfo) windowValuePos(op *WindowAggregate, val value.Value, cIndex, direction int64,
-	sframe bool) (pos int64, empty bool, err error) {
+func (this *AggregateInfo) windowValuePos(op *WindowAggregate, val value.Value, interval *expression.Interval,
+	cIndex, direction int64, sframe bool) (pos int64, empty bool, err error) {
 
 	collation := int64(1)
 	if this.wTerm.WindowFrame().RangeWindowFrame() {
@@ -691,12 +756,19 @@ func (this *AggregateInfo) windowValuePos(op *WindowAggregate, val value.Value,
 
 		currentObyVal, err = getCachedValue(op.values[cIndex], op.oby[0].Expression(), op.obyTerms[0], op.context)
 		if err != nil || currentObyVal == nil ||
-			!(currentObyVal.Type() == value.NUMBER || currentObyVal.Type() <= value.NULL) {
+			!(currentObyVal.Type() == value.NUMBER || currentObyVal.Type() <= value.NULL ||
+				(interval != nil && currentObyVal.Type() == value.STRING)) {
 			return cIndex, true, err
 		}
 
 		// range add the logical offset
-		if currentObyVal.Type() == value.NUMBER {
+		if interval != nil && currentObyVal.Type() > value.NULL {
+			// date string or date in milliseconds
+			rangeVal = interval.AddTo(currentObyVal, int(direction*collation))
+			if rangeVal == nil {
+				return cIndex, true, err
+			}
+		} else if currentObyVal.Type() == value.NUMBER {
 			if (direction * collation) < 0 {
 				rangeVal = value.AsNumberValue(currentObyVal).Sub(value.AsNumberValue(val))
 			} else {
@@ -881,6 +953,24 @@ func (this *AggregateInfo) windowValidateValExpr(valExpr expression.Expression,
 	return nil, fmt.Errorf("value_expr must be a constant or expression and must evaluate to a positive numeric value.")
 }
 
+// INTERVAL value_expr must be a constant or expression and must evaluate to an interval string.
+func (this *AggregateInfo) windowValidateInterval(valExpr expression.Expression,
+	context *Context, parent value.Value) (*expression.Interval, error) {
+
+	val, err := valExpr.Evaluate(parent, context)
+	if err != nil {
+		return nil, err
+	}
+	if val != nil && val.Type() == value.STRING {
+		interval, err := expression.ParseInterval(val.ToString())
+		if err == nil {
+			return interval, nil
+		}
+	}
+
+	return nil, fmt.Errorf("INTERVAL value_expr must be a constant or expression and must evaluate to an interval string.")
+}
+
 // evalute aggregate ORDER BY terms
 func (this *AggregateInfo) evaluateObyValues(item value.AnnotatedValue, op *WindowAggregate) error {
 	if this.wTerm.OrderBy() != nil {
@@ -1070,8 +1160,10 @@ func (this *WindowAggregate) evaluatePbyValues(item value.AnnotatedValue) bool {
 		if aInfo.wTerm.OrderBy() != nil {
 			aInfo.newCollationValue = true
 		}
-		if aInfo.incremental {
+		if aInfo.incremental || aInfo.sliding {
 			aInfo.cumVal, _ = aInfo.agg.Default(nil, this.context)
+			aInfo.cumStart = 0
+			aInfo.cumEnd = -1
 		}
 	}
 
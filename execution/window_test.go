@@ -0,0 +1,105 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package execution
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+// rows ordered by x, with duplicates of x and of y
+const _WINDOW_ROWS = `[{"id": 1, "x": 1, "y": 10}, {"id": 2, "x": 1, "y": 10}, {"id": 3, "x": 2, "y": 20},
+	{"id": 4, "x": 3, "y": 10}, {"id": 5, "x": 3, "y": 30}, {"id": 6, "x": 3, "y": 30},
+	{"id": 7, "x": 5, "y": 20}, {"id": 8, "x": 6, "y": 10}]`
+
+// rows ordered by date, with duplicate dates
+const _WINDOW_DATES = `[{"id": 1, "t": "2021-01-01"}, {"id": 2, "t": "2021-01-01"}, {"id": 3, "t": "2021-01-02"},
+	{"id": 4, "t": "2021-01-05"}, {"id": 5, "t": "2021-01-06"}, {"id": 6, "t": "2021-01-06"}]`
+
+// runs the window aggregate over the rows, and checks its value for each row in id order
+func checkWindow(t *testing.T, rows, agg string, expected string) {
+	stmt := "SELECT RAW " + agg + " FROM " + rows + " AS d ORDER BY d.id"
+	results, _ := runStatement(t, newMockContext(t, &testOutput{}), stmt)
+	if !value.NewValue([]byte(expected)).EquivalentTo(results) {
+		t.Errorf("%s: expected %s, got %v", agg, expected, results)
+	}
+}
+
+// RANGE frames with value offsets slide over the rows, removing the rows that leave the frame
+func TestWindowSliding(t *testing.T) {
+	for _, c := range []struct {
+		agg      string
+		expected string
+	}{
+		{"SUM(d.y) OVER (ORDER BY d.x RANGE BETWEEN 1 PRECEDING AND 1 FOLLOWING)",
+			"[40, 40, 110, 90, 90, 90, 30, 30]"},
+		{"COUNT(d.y) OVER (ORDER BY d.x DESC RANGE BETWEEN 1 PRECEDING AND CURRENT ROW)",
+			"[3, 3, 4, 3, 3, 3, 2, 1]"},
+
+		// frames that are empty, and rows that leave together
+		{"SUM(d.y) OVER (ORDER BY d.x RANGE BETWEEN 2 PRECEDING AND 1 PRECEDING)",
+			"[null, null, 20, 40, 40, 40, 70, 20]"},
+		{"COUNT(d.y) OVER (ORDER BY d.x RANGE BETWEEN 2 PRECEDING AND 1 PRECEDING)",
+			"[0, 0, 2, 3, 3, 3, 3, 1]"},
+	} {
+		checkWindow(t, _WINDOW_ROWS, c.agg, c.expected)
+	}
+}
+
+// DISTINCT window aggregates keep their values until the last duplicate leaves the frame
+func TestWindowDistinct(t *testing.T) {
+	for _, c := range []struct {
+		agg      string
+		expected string
+	}{
+		{"COUNT(DISTINCT d.y) OVER (ORDER BY d.id ROWS BETWEEN 1 PRECEDING AND CURRENT ROW)",
+			"[1, 1, 2, 2, 2, 1, 2, 2]"},
+		{"SUM(DISTINCT d.y) OVER (ORDER BY d.id ROWS BETWEEN 2 PRECEDING AND CURRENT ROW)",
+			"[10, 10, 30, 30, 60, 40, 50, 60]"},
+		{"COUNT(DISTINCT d.y) OVER (ORDER BY d.x RANGE BETWEEN 1 PRECEDING AND 1 FOLLOWING)",
+			"[2, 2, 3, 3, 3, 3, 2, 2]"},
+		{"SUM(DISTINCT d.y) OVER (ORDER BY d.x RANGE BETWEEN 1 PRECEDING AND 1 FOLLOWING)",
+			"[30, 30, 60, 60, 60, 60, 30, 30]"},
+		{"AVG(DISTINCT d.y) OVER (ORDER BY d.x RANGE BETWEEN 1 PRECEDING AND 1 FOLLOWING)",
+			"[15, 15, 20, 20, 20, 20, 15, 15]"},
+		{"COUNT(DISTINCT d.y) OVER (ORDER BY d.x RANGE BETWEEN 2 PRECEDING AND 1 PRECEDING)",
+			"[0, 0, 1, 2, 2, 2, 2, 1]"},
+		{"SUM(DISTINCT d.y) OVER (ORDER BY d.x RANGE BETWEEN 2 PRECEDING AND 1 PRECEDING)",
+			"[null, null, 10, 30, 30, 30, 40, 20]"},
+		{"COUNTN(DISTINCT d.y) OVER (ORDER BY d.x RANGE BETWEEN UNBOUNDED PRECEDING AND CURRENT ROW)",
+			"[1, 1, 2, 3, 3, 3, 3, 3]"},
+	} {
+		checkWindow(t, _WINDOW_ROWS, c.agg, c.expected)
+	}
+}
+
+// INTERVAL offsets of RANGE frames are added to dates, as strings or in milliseconds
+func TestWindowInterval(t *testing.T) {
+	for _, c := range []struct {
+		agg      string
+		expected string
+	}{
+		{"COUNT(1) OVER (ORDER BY d.t RANGE BETWEEN INTERVAL '1 day' PRECEDING AND CURRENT ROW)",
+			"[2, 2, 3, 1, 3, 3]"},
+		{"COUNT(1) OVER (ORDER BY STR_TO_MILLIS(d.t) RANGE BETWEEN INTERVAL '1 day' PRECEDING AND CURRENT ROW)",
+			"[2, 2, 3, 1, 3, 3]"},
+		{"COUNT(1) OVER (ORDER BY d.t RANGE BETWEEN CURRENT ROW AND INTERVAL '24 hours' FOLLOWING)",
+			"[3, 3, 1, 3, 2, 2]"},
+		{"COUNT(1) OVER (ORDER BY d.t DESC RANGE BETWEEN INTERVAL '1 day' PRECEDING AND CURRENT ROW)",
+			"[3, 3, 1, 3, 2, 2]"},
+		{"COUNT(1) OVER (ORDER BY d.t RANGE BETWEEN INTERVAL '4 days' PRECEDING AND INTERVAL '2 days' PRECEDING)",
+			"[0, 0, 0, 3, 1, 1]"},
+		{"COUNT(DISTINCT d.t) OVER (ORDER BY d.t RANGE BETWEEN INTERVAL '1 day' PRECEDING AND CURRENT ROW)",
+			"[1, 1, 2, 1, 2, 2]"},
+	} {
+		checkWindow(t, _WINDOW_DATES, c.agg, c.expected)
+	}
+}
@@ -40,6 +40,7 @@ type CurlContext interface {
 	GetWhitelist() map[string]interface{}
 	UrlCredentials(urlS string) *auth.Credentials
 	DatastoreURL() string
+	TraceParent() string
 }
 
 type InlistContext interface {
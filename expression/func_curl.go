@@ -55,6 +55,11 @@ const (
 	_N1QL_USER_AGENT = "couchbase/n1ql/" + util.VERSION
 )
 
+// W3C trace context header (cant import tracing)
+const (
+	_TRACEPARENT = "traceparent"
+)
+
 // Max request size from server (cant import because of cyclic dependency)
 const (
 	MIN_RESPONSE_SIZE = 20 * (1 << 20)
@@ -172,14 +177,16 @@ func (this *Curl) DoEvaluate(context Context, arg1, arg2 value.Value) (value.Val
 
 	// Get whitelist from UI
 	var whitelist map[string]interface{}
+	var traceParent string
 
 	_curlContext := context.(CurlContext)
 	if _curlContext != nil {
 		whitelist = _curlContext.GetWhitelist()
+		traceParent = _curlContext.TraceParent()
 	}
 
 	// Now you have the URL and the options with which to call curl.
-	result, err := this.handleCurl(curl_url, options, whitelist)
+	result, err := this.handleCurl(curl_url, options, whitelist, traceParent)
 
 	if err != nil {
 		return value.NULL_VALUE, err
@@ -230,7 +237,8 @@ func (this *Curl) Constructor() FunctionConstructor {
 	return NewCurl
 }
 
-func (this *Curl) handleCurl(url string, options map[string]interface{}, whitelist map[string]interface{}) (interface{}, error) {
+func (this *Curl) handleCurl(url string, options map[string]interface{}, whitelist map[string]interface{},
+	traceParent string) (interface{}, error) {
 	// Handle different cases
 
 	// initial check for curl_whitelist.json has been completed. The file exists.
@@ -716,6 +724,9 @@ func (this *Curl) handleCurl(url string, options map[string]interface{}, whiteli
 		of the callback function to be func(buf []byte, userdata interface{}) bool {}
 	*/
 
+	// Propagate the trace of the request, unless a traceparent header was passed in.
+	header = traceHeader(header, traceParent)
+
 	// Set the header, so that the entire []string are passed in.
 	this.curlHeader(header)
 	if err := this.curlCiphers(); err != nil {
@@ -788,6 +799,18 @@ func (this *Curl) curlData(data string) {
 	myCurl.Setopt(curl.OPT_POSTFIELDS, data)
 }
 
+func traceHeader(header []string, traceParent string) []string {
+	if traceParent == "" {
+		return header
+	}
+	for _, h := range header {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(h)), _TRACEPARENT+":") {
+			return header
+		}
+	}
+	return append(header, _TRACEPARENT+": "+traceParent)
+}
+
 func (this *Curl) curlHeader(header []string) {
 
 	/*
@@ -12,6 +12,7 @@ package expression
 import (
 	"fmt"
 	"math"
+	"strconv"
 	"strings"
 	"time"
 
@@ -2872,6 +2873,94 @@ func dateAdd(t time.Time, n int, part string) (time.Time, error) {
 	}
 }
 
+/*
+Interval, or duration, of one or more date parts such as '7 days'
+or '1 hour 30 minutes'. It is used by RANGE window frames over date
+strings and dates in milliseconds.
+*/
+type Interval struct {
+	ns    []int
+	parts []string
+}
+
+/*
+Parse the interval string of pairs of non-negative integer and
+date part. The date part can be singular or plural.
+*/
+func ParseInterval(s string) (*Interval, error) {
+	fields := strings.Fields(s)
+	if len(fields) == 0 || len(fields)%2 != 0 {
+		return nil, fmt.Errorf("Invalid interval %s.", s)
+	}
+
+	rv := &Interval{
+		ns:    make([]int, 0, len(fields)/2),
+		parts: make([]string, 0, len(fields)/2),
+	}
+
+	for i := 0; i < len(fields); i += 2 {
+		n, err := strconv.Atoi(fields[i])
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("Invalid interval %s.", s)
+		}
+
+		part := intervalPart(fields[i+1])
+		if _, err = dateAdd(time.Time{}, 0, part); err != nil {
+			return nil, fmt.Errorf("Invalid interval %s.", s)
+		}
+
+		rv.ns = append(rv.ns, n)
+		rv.parts = append(rv.parts, part)
+	}
+
+	return rv, nil
+}
+
+func intervalPart(part string) string {
+	p := strings.ToLower(part)
+
+	switch p {
+	case "centuries":
+		return "century"
+	case "millennia", "millenniums":
+		return "millennium"
+	default:
+		return strings.TrimSuffix(p, "s")
+	}
+}
+
+/*
+Add the interval to the date string in a supported format, or to the
+date in milliseconds, or subtract it when direction is negative. The
+result has the representation of the date. It returns nil for values
+that are not dates.
+*/
+func (this *Interval) AddTo(date value.Value, direction int) value.Value {
+	switch date.Type() {
+	case value.STRING:
+		t, format, err := StrToTimeFormat(date.ToString())
+		if err != nil {
+			return nil
+		}
+
+		return value.NewValue(timeToStr(this.add(t, direction), format))
+	case value.NUMBER:
+		t := this.add(millisToTime(date.Actual().(float64)), direction)
+		return value.NewValue(timeToMillis(t))
+	default:
+		return nil
+	}
+}
+
+func (this *Interval) add(t time.Time, direction int) time.Time {
+	for i, part := range this.parts {
+		// parts are validated by ParseInterval()
+		t, _ = dateAdd(t, direction*this.ns[i], part)
+	}
+
+	return t
+}
+
 /*
 Truncate out the part of the date string from the output and return the
 remaining time t.
@@ -0,0 +1,38 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestInterval(t *testing.T) {
+	testInterval(t, "7 days", value.NewValue("2024-03-10T00:00:00Z"), -1, value.NewValue("2024-03-03T00:00:00Z"))
+	testInterval(t, "1 Month", value.NewValue("2024-01-31"), 1, value.NewValue("2024-03-02"))
+	testInterval(t, "1 hour 30 minutes", value.NewValue(0), 1, value.NewValue(5400000))
+	testInterval(t, "2 weeks", value.NewValue(1209600000), -1, value.NewValue(0))
+	testInterval(t, "1 day", value.NewValue("yesterday"), 1, nil)
+
+	for _, s := range []string{"", "7", "seven days", "7 fortnights", "-1 day", "1 day 2"} {
+		if _, err := ParseInterval(s); err == nil {
+			t.Errorf("%s: expected error", s)
+		}
+	}
+}
+
+func testInterval(t *testing.T, s string, date value.Value, direction int, er value.Value) {
+	interval, err := ParseInterval(s)
+	if err != nil {
+		t.Errorf("%s: received error %v", s, err)
+		return
+	}
+
+	rv := interval.AddTo(date, direction)
+	if er == nil || rv == nil {
+		if er != rv {
+			t.Errorf("%s: mismatch received %v expected %v", s, rv, er)
+		}
+	} else if er.Collate(rv) != 0 {
+		t.Errorf("%s: mismatch received %v expected %v", s, rv.Actual(), er.Actual())
+	}
+}
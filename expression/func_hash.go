@@ -0,0 +1,434 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"crypto/hmac"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"hash"
+	"hash/crc32"
+	"strings"
+
+	"github.com/couchbase/query/value"
+)
+
+/*
+The hash functions digest strings as they are, binary values as they
+are, and any other value in its encoded form, with the fields of
+objects in sorted order, so that equal values have equal digests.
+The digest is returned as a hex string, or base64 if requested.
+*/
+
+var _HASHES = map[string]func() hash.Hash{
+	"md5":    md5.New,
+	"sha1":   sha1.New,
+	"sha256": sha256.New,
+	"sha512": sha512.New,
+}
+
+///////////////////////////////////////////////////
+//
+// MD5
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function MD5(expr [, encoding]). It returns the
+MD5 digest of expr.
+*/
+type MD5 struct {
+	FunctionBase
+}
+
+func NewMD5(operands ...Expression) Function {
+	rv := &MD5{
+		*NewFunctionBase("md5", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *MD5) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *MD5) Type() value.Type { return value.STRING }
+
+func (this *MD5) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return hashEvaluate(md5.New, this.operands, item, context)
+}
+
+/*
+Minimum input arguments required is 1.
+*/
+func (this *MD5) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed is 2.
+*/
+func (this *MD5) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *MD5) Constructor() FunctionConstructor {
+	return NewMD5
+}
+
+///////////////////////////////////////////////////
+//
+// SHA1
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA1(expr [, encoding]). It returns the
+SHA-1 digest of expr.
+*/
+type SHA1 struct {
+	FunctionBase
+}
+
+func NewSHA1(operands ...Expression) Function {
+	rv := &SHA1{
+		*NewFunctionBase("sha1", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *SHA1) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *SHA1) Type() value.Type { return value.STRING }
+
+func (this *SHA1) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return hashEvaluate(sha1.New, this.operands, item, context)
+}
+
+/*
+Minimum input arguments required is 1.
+*/
+func (this *SHA1) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed is 2.
+*/
+func (this *SHA1) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *SHA1) Constructor() FunctionConstructor {
+	return NewSHA1
+}
+
+///////////////////////////////////////////////////
+//
+// SHA256
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA256(expr [, encoding]). It returns the
+SHA-256 digest of expr.
+*/
+type SHA256 struct {
+	FunctionBase
+}
+
+func NewSHA256(operands ...Expression) Function {
+	rv := &SHA256{
+		*NewFunctionBase("sha256", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *SHA256) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *SHA256) Type() value.Type { return value.STRING }
+
+func (this *SHA256) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return hashEvaluate(sha256.New, this.operands, item, context)
+}
+
+/*
+Minimum input arguments required is 1.
+*/
+func (this *SHA256) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed is 2.
+*/
+func (this *SHA256) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *SHA256) Constructor() FunctionConstructor {
+	return NewSHA256
+}
+
+///////////////////////////////////////////////////
+//
+// SHA512
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SHA512(expr [, encoding]). It returns the
+SHA-512 digest of expr.
+*/
+type SHA512 struct {
+	FunctionBase
+}
+
+func NewSHA512(operands ...Expression) Function {
+	rv := &SHA512{
+		*NewFunctionBase("sha512", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *SHA512) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *SHA512) Type() value.Type { return value.STRING }
+
+func (this *SHA512) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return hashEvaluate(sha512.New, this.operands, item, context)
+}
+
+/*
+Minimum input arguments required is 1.
+*/
+func (this *SHA512) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed is 2.
+*/
+func (this *SHA512) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *SHA512) Constructor() FunctionConstructor {
+	return NewSHA512
+}
+
+///////////////////////////////////////////////////
+//
+// CRC32
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function CRC32(expr [, encoding]). It returns the
+IEEE CRC-32 checksum of expr.
+*/
+type CRC32 struct {
+	FunctionBase
+}
+
+func NewCRC32(operands ...Expression) Function {
+	rv := &CRC32{
+		*NewFunctionBase("crc32", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *CRC32) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *CRC32) Type() value.Type { return value.STRING }
+
+func (this *CRC32) Evaluate(item value.Value, context Context) (value.Value, error) {
+	return hashEvaluate(func() hash.Hash { return crc32.NewIEEE() }, this.operands, item, context)
+}
+
+/*
+Minimum input arguments required is 1.
+*/
+func (this *CRC32) MinArgs() int { return 1 }
+
+/*
+Maximum input arguments allowed is 2.
+*/
+func (this *CRC32) MaxArgs() int { return 2 }
+
+/*
+Factory method pattern.
+*/
+func (this *CRC32) Constructor() FunctionConstructor {
+	return NewCRC32
+}
+
+///////////////////////////////////////////////////
+//
+// HMAC
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function HMAC(algorithm, key, expr [, encoding]).
+It returns the keyed message authentication code of expr, using one of
+the md5, sha1, sha256 or sha512 digests.
+*/
+type HMAC struct {
+	FunctionBase
+}
+
+func NewHMAC(operands ...Expression) Function {
+	rv := &HMAC{
+		*NewFunctionBase("hmac", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *HMAC) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *HMAC) Type() value.Type { return value.STRING }
+
+func (this *HMAC) Evaluate(item value.Value, context Context) (value.Value, error) {
+	missing := false
+	null := false
+	args := make([]value.Value, 2)
+	for i, _ := range args {
+		arg, err := this.operands[i].Evaluate(item, context)
+		if err != nil {
+			return nil, err
+		} else if arg.Type() == value.MISSING {
+			missing = true
+		} else if arg.Type() != value.STRING && (i == 0 || arg.Type() != value.BINARY) {
+			null = true
+		}
+		args[i] = arg
+	}
+	if missing {
+		return value.MISSING_VALUE, nil
+	} else if null {
+		return value.NULL_VALUE, nil
+	}
+
+	newHash, ok := _HASHES[strings.ToLower(args[0].ToString())]
+	if !ok {
+		return value.NULL_VALUE, nil
+	}
+	key := hashBytes(args[1])
+	return hashEvaluate(func() hash.Hash { return hmac.New(newHash, key) }, this.operands[2:], item, context)
+}
+
+/*
+Minimum input arguments required is 3.
+*/
+func (this *HMAC) MinArgs() int { return 3 }
+
+/*
+Maximum input arguments allowed is 4.
+*/
+func (this *HMAC) MaxArgs() int { return 4 }
+
+/*
+Factory method pattern.
+*/
+func (this *HMAC) Constructor() FunctionConstructor {
+	return NewHMAC
+}
+
+// operands are the value to digest, and optionally the encoding of the result
+func hashEvaluate(newHash func() hash.Hash, operands Expressions, item value.Value, context Context) (
+	value.Value, error) {
+
+	arg, err := operands[0].Evaluate(item, context)
+	if err != nil {
+		return nil, err
+	}
+
+	encoding := "hex"
+	if len(operands) > 1 {
+		enc, err := operands[1].Evaluate(item, context)
+		if err != nil {
+			return nil, err
+		} else if enc.Type() == value.MISSING || arg.Type() == value.MISSING {
+			return value.MISSING_VALUE, nil
+		} else if enc.Type() != value.STRING {
+			return value.NULL_VALUE, nil
+		}
+		encoding = strings.ToLower(enc.ToString())
+	}
+	if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+
+	h := newHash()
+	h.Write(hashBytes(arg))
+	sum := h.Sum(nil)
+
+	switch encoding {
+	case "hex":
+		return value.NewValue(hex.EncodeToString(sum)), nil
+	case "base64":
+		return value.NewValue(base64.StdEncoding.EncodeToString(sum)), nil
+	default:
+		return value.NULL_VALUE, nil
+	}
+}
+
+func hashBytes(arg value.Value) []byte {
+	switch arg.Type() {
+	case value.STRING:
+		return []byte(arg.ToString())
+	case value.BINARY:
+		return arg.Actual().([]byte)
+	default:
+		bytes, _ := arg.MarshalJSON()
+		return bytes
+	}
+}
@@ -0,0 +1,38 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func testHash(f Function, er value.Value, t *testing.T) {
+	rv, err := f.Evaluate(nil, nil)
+	if err != nil {
+		t.Errorf("%v: received error %v", f, err)
+	} else if er.Collate(rv) != 0 {
+		t.Errorf("%v: mismatch received %v expected %v", f, rv.Actual(), er.Actual())
+	}
+}
+
+func TestHash(t *testing.T) {
+	abc := NewConstant("abc")
+
+	testHash(NewMD5(abc), value.NewValue("900150983cd24fb0d6963f7d28e17f72"), t)
+	testHash(NewSHA1(abc), value.NewValue("a9993e364706816aba3e25717850c26c9cd0d89d"), t)
+	testHash(NewSHA256(abc), value.NewValue("ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"), t)
+	testHash(NewSHA256(abc, NewConstant("base64")), value.NewValue("ungWv48Bz+pBQUDeXa4iI7ADYaOWF3qctBD/YfIAFa0="), t)
+	testHash(NewCRC32(abc), value.NewValue("352441c2"), t)
+	testHash(NewHMAC(NewConstant("sha256"), NewConstant("key"),
+		NewConstant("The quick brown fox jumps over the lazy dog")),
+		value.NewValue("f7bc83f430538424b13298e6aa6fb143ef4d59a14946175997479dbc2d1a3cd8"), t)
+
+	/* objects are digested with their fields in order */
+	obj := NewConstant(value.NewValue(map[string]interface{}{"b": []interface{}{1, "x"}, "a": 2}))
+	testHash(NewMD5(obj), value.NewValue("6bd5a5e6390157ec3f045587b7832360"), t)
+
+	testHash(NewMD5(NewConstant(value.MISSING_VALUE)), value.MISSING_VALUE, t)
+	testHash(NewMD5(abc, NewConstant("base32")), value.NULL_VALUE, t)
+	testHash(NewHMAC(NewConstant("sha3"), NewConstant("key"), abc), value.NULL_VALUE, t)
+	testHash(NewHMAC(NewConstant("md5"), NewConstant(1), abc), value.NULL_VALUE, t)
+}
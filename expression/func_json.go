@@ -10,11 +10,15 @@
 package expression
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"io"
 	"strings"
 
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
+	"github.com/santhosh-tekuri/jsonschema/v5"
 )
 
 ///////////////////////////////////////////////////
@@ -329,6 +333,235 @@ func (this *PolyLength) Constructor() FunctionConstructor {
 	}
 }
 
+///////////////////////////////////////////////////
+//
+// IsValidJsonSchema
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function IS_VALID_JSON_SCHEMA(expr, schema).
+It returns true if the value conforms to the JSON Schema, and false
+otherwise. The schema is an object, or a boolean.
+*/
+type IsValidJsonSchema struct {
+	BinaryFunctionBase
+	schema *jsonschema.Schema
+	err    error
+}
+
+func NewIsValidJsonSchema(first, second Expression) Function {
+	rv := &IsValidJsonSchema{
+		*NewBinaryFunctionBase("is_valid_json_schema", first, second),
+		nil,
+		nil,
+	}
+
+	rv.schema, rv.err = precompileJsonSchema(second.Value())
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *IsValidJsonSchema) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *IsValidJsonSchema) Type() value.Type { return value.BOOLEAN }
+
+func (this *IsValidJsonSchema) Evaluate(item value.Value, context Context) (value.Value, error) {
+	first, second, schema, err := evaluateJsonSchema(&this.BinaryFunctionBase, this.schema, this.err, item, context)
+	if err != nil || schema == nil {
+		return nullOrMissing(first, second), err
+	}
+
+	violations, err := JsonSchemaErrors(first, schema)
+	if err != nil {
+		return nil, err
+	}
+	return value.NewValue(len(violations) == 0), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *IsValidJsonSchema) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewIsValidJsonSchema(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// JsonSchemaErrors
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the json function JSON_SCHEMA_ERRORS(expr, schema).
+It returns an array of the violations of the JSON Schema found in the
+value, each an object with the location of the offending value, the
+location of the failing keyword in the schema, and the error. The
+array is empty if the value conforms to the schema.
+*/
+type JsonSchemaErrorsFunction struct {
+	BinaryFunctionBase
+	schema *jsonschema.Schema
+	err    error
+}
+
+func NewJsonSchemaErrors(first, second Expression) Function {
+	rv := &JsonSchemaErrorsFunction{
+		*NewBinaryFunctionBase("json_schema_errors", first, second),
+		nil,
+		nil,
+	}
+
+	rv.schema, rv.err = precompileJsonSchema(second.Value())
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *JsonSchemaErrorsFunction) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *JsonSchemaErrorsFunction) Type() value.Type { return value.ARRAY }
+
+func (this *JsonSchemaErrorsFunction) Evaluate(item value.Value, context Context) (value.Value, error) {
+	first, second, schema, err := evaluateJsonSchema(&this.BinaryFunctionBase, this.schema, this.err, item, context)
+	if err != nil || schema == nil {
+		return nullOrMissing(first, second), err
+	}
+
+	violations, err := JsonSchemaErrors(first, schema)
+	if err != nil {
+		return nil, err
+	}
+	if violations == nil {
+		violations = []interface{}{}
+	}
+	return value.NewValue(violations), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *JsonSchemaErrorsFunction) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewJsonSchemaErrors(operands[0], operands[1])
+	}
+}
+
+// returns a nil schema if either operand is MISSING, or the schema is not an object or a boolean
+func evaluateJsonSchema(this *BinaryFunctionBase, precompiled *jsonschema.Schema, precompileErr error,
+	item value.Value, context Context) (value.Value, value.Value, *jsonschema.Schema, error) {
+
+	first, err := this.operands[0].Evaluate(item, context)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	second, err := this.operands[1].Evaluate(item, context)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if first.Type() == value.MISSING || second.Type() == value.MISSING ||
+		(second.Type() != value.OBJECT && second.Type() != value.BOOLEAN) {
+		return first, second, nil, nil
+	}
+	if precompiled != nil || precompileErr != nil {
+		return first, second, precompiled, precompileErr
+	}
+	schema, err := NewJsonSchema(second)
+	return first, second, schema, err
+}
+
+func nullOrMissing(first, second value.Value) value.Value {
+	if first == nil || first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE
+	}
+	return value.NULL_VALUE
+}
+
+func precompileJsonSchema(schema value.Value) (*jsonschema.Schema, error) {
+	if schema == nil || (schema.Type() != value.OBJECT && schema.Type() != value.BOOLEAN) {
+		return nil, nil
+	}
+	return NewJsonSchema(schema)
+}
+
+// schemas are compiled in isolation: references to other documents are not followed
+const _JSON_SCHEMA_URL = "mem:///schema.json"
+
+func NewJsonSchema(schema value.Value) (*jsonschema.Schema, error) {
+	b, err := schema.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	compiler := jsonschema.NewCompiler()
+	compiler.LoadURL = func(url string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("references to %s are not allowed", url)
+	}
+	err = compiler.AddResource(_JSON_SCHEMA_URL, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	return compiler.Compile(_JSON_SCHEMA_URL)
+}
+
+/*
+Returns the violations of the schema found in the value, or nil if it
+conforms. Each violation is reported where it occurs, rather than as
+the chain of schemas leading to it.
+*/
+func JsonSchemaErrors(val value.Value, schema *jsonschema.Schema) ([]interface{}, error) {
+	b, err := val.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	// numbers are kept as they are, so that integers are not mistaken for floats
+	var doc interface{}
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err = decoder.Decode(&doc)
+	if err != nil {
+		return nil, err
+	}
+
+	err = schema.Validate(doc)
+	if err == nil {
+		return nil, nil
+	}
+	ve, ok := err.(*jsonschema.ValidationError)
+	if !ok {
+		return nil, err
+	}
+
+	var violations []interface{}
+	var leaves func(*jsonschema.ValidationError)
+	leaves = func(ve *jsonschema.ValidationError) {
+		if len(ve.Causes) == 0 {
+			violations = append(violations, map[string]interface{}{
+				"instanceLocation": ve.InstanceLocation,
+				"keywordLocation":  ve.KeywordLocation,
+				"error":            ve.Message,
+			})
+		}
+		for _, cause := range ve.Causes {
+			leaves(cause)
+		}
+	}
+	leaves(ve)
+	return violations, nil
+}
+
 func traversePairs(actual interface{}, buffer []interface{}) []interface{} {
 	length := 0
 
@@ -0,0 +1,80 @@
+package expression
+
+import (
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+var _TEST_SCHEMA = map[string]interface{}{
+	"type":     "object",
+	"required": []interface{}{"a"},
+	"properties": map[string]interface{}{
+		"a": map[string]interface{}{"type": "integer"},
+		"b": map[string]interface{}{"$ref": "#/$defs/short"},
+	},
+	"$defs": map[string]interface{}{
+		"short": map[string]interface{}{"type": "string", "maxLength": 2},
+	},
+}
+
+func TestIsValidJsonSchema(t *testing.T) {
+	schema := NewConstant(value.NewValue(_TEST_SCHEMA))
+	docs := []struct {
+		doc      interface{}
+		expected value.Value
+	}{
+		{map[string]interface{}{"a": 1, "b": "x"}, value.TRUE_VALUE},
+		{map[string]interface{}{"a": 1.5}, value.FALSE_VALUE},
+		{map[string]interface{}{"b": "x"}, value.FALSE_VALUE},
+		{map[string]interface{}{"a": 1, "b": "xyz"}, value.FALSE_VALUE},
+		{value.MISSING_VALUE, value.MISSING_VALUE},
+	}
+
+	for _, d := range docs {
+		rv, err := NewIsValidJsonSchema(NewConstant(d.doc), schema).Evaluate(nil, nil)
+		if err != nil {
+			t.Errorf("received error %v", err)
+		} else if d.expected.Collate(rv) != 0 {
+			t.Errorf("%v: mismatch received %v expected %v", d.doc, rv, d.expected)
+		}
+	}
+
+	rv, err := NewIsValidJsonSchema(NewConstant(1), NewConstant("string")).Evaluate(nil, nil)
+	if err != nil || rv.Type() != value.NULL {
+		t.Errorf("expected null for a non schema, received %v %v", rv, err)
+	}
+}
+
+func TestJsonSchemaErrors(t *testing.T) {
+	schema := NewConstant(value.NewValue(_TEST_SCHEMA))
+	doc := NewConstant(value.NewValue(map[string]interface{}{"a": "one", "b": "xyz"}))
+	rv, err := NewJsonSchemaErrors(doc, schema).Evaluate(nil, nil)
+	if err != nil {
+		t.Fatalf("received error %v", err)
+	}
+	violations, ok := rv.Actual().([]interface{})
+	if !ok || len(violations) != 2 {
+		t.Fatalf("expected 2 violations, received %v", rv)
+	}
+	locations := map[string]bool{}
+	for _, v := range violations {
+		loc, _ := value.NewValue(v).Field("instanceLocation")
+		locations[loc.ToString()] = true
+	}
+	if !locations["/a"] || !locations["/b"] {
+		t.Errorf("unexpected violations %v", rv)
+	}
+
+	doc = NewConstant(value.NewValue(map[string]interface{}{"a": 1}))
+	rv, err = NewJsonSchemaErrors(doc, schema).Evaluate(nil, nil)
+	if err != nil || rv.Type() != value.ARRAY || len(rv.Actual().([]interface{})) != 0 {
+		t.Errorf("expected no violations, received %v %v", rv, err)
+	}
+
+	invalid := NewConstant(value.NewValue(map[string]interface{}{"$ref": "http://example.com/schema.json"}))
+	_, err = NewJsonSchemaErrors(doc, invalid).Evaluate(nil, nil)
+	if err == nil {
+		t.Errorf("expected error for an external reference")
+	}
+}
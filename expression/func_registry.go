@@ -295,13 +295,15 @@ var _FUNCTIONS = map[string]Function{
 	"object_values":       &ObjectValues{},
 
 	// JSON
-	"decode_json":  &JSONDecode{},
-	"encode_json":  &JSONEncode{},
-	"encoded_size": &EncodedSize{},
-	"json_decode":  &JSONDecode{},
-	"json_encode":  &JSONEncode{},
-	"pairs":        &Pairs{},
-	"poly_length":  &PolyLength{},
+	"decode_json":          &JSONDecode{},
+	"encode_json":          &JSONEncode{},
+	"encoded_size":         &EncodedSize{},
+	"is_valid_json_schema": &IsValidJsonSchema{},
+	"json_decode":          &JSONDecode{},
+	"json_encode":          &JSONEncode{},
+	"json_schema_errors":   &JsonSchemaErrorsFunction{},
+	"pairs":                &Pairs{},
+	"poly_length":          &PolyLength{},
 
 	// Base64
 	"base64":        &Base64Encode{},
@@ -310,6 +312,19 @@ var _FUNCTIONS = map[string]Function{
 	"decode_base64": &Base64Decode{},
 	"encode_base64": &Base64Encode{},
 
+	// Hash
+	"crc32":  &CRC32{},
+	"hmac":   &HMAC{},
+	"md5":    &MD5{},
+	"sha1":   &SHA1{},
+	"sha256": &SHA256{},
+	"sha512": &SHA512{},
+
+	// Sketch
+	"sketch_count_distinct": &SketchCountDistinct{},
+	"sketch_percentile":     &SketchPercentile{},
+	"sketch_union":          &SketchUnion{},
+
 	// Comparison
 	"greatest":  &Greatest{},
 	"least":     &Least{},
@@ -0,0 +1,254 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package expression
+
+import (
+	"math"
+
+	"github.com/couchbase/query/util/sketch"
+	"github.com/couchbase/query/value"
+)
+
+/*
+The sketch functions estimate from, and merge, the sketches built by the
+SKETCH_HLL(), SKETCH_KLL() and SKETCH_MERGE() aggregates, which can be
+stored in documents. Values that are not sketches of the expected kind
+give NULL.
+*/
+
+///////////////////////////////////////////////////
+//
+// SketchCountDistinct
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SKETCH_COUNT_DISTINCT(sketch). It returns
+the estimate of the number of distinct values of a HyperLogLog sketch.
+*/
+type SketchCountDistinct struct {
+	UnaryFunctionBase
+}
+
+func NewSketchCountDistinct(operand Expression) Function {
+	rv := &SketchCountDistinct{
+		*NewUnaryFunctionBase("sketch_count_distinct", operand),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *SketchCountDistinct) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *SketchCountDistinct) Type() value.Type { return value.NUMBER }
+
+func (this *SketchCountDistinct) Evaluate(item value.Value, context Context) (value.Value, error) {
+	arg, err := this.operands[0].Evaluate(item, context)
+	if err != nil {
+		return nil, err
+	} else if arg.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+
+	s, err := sketch.FromValue(arg)
+	hll, ok := s.(*sketch.HLL)
+	if err != nil || !ok {
+		return value.NULL_VALUE, nil
+	}
+	return value.NewValue(hll.Estimate()), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *SketchCountDistinct) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewSketchCountDistinct(operands[0])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// SketchPercentile
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SKETCH_PERCENTILE(sketch, fraction). It
+returns the estimate of the value at the fraction of the sorted values
+of a KLL sketch. The fraction is a number or an array of numbers
+between 0 and 1, for an array of estimates.
+*/
+type SketchPercentile struct {
+	BinaryFunctionBase
+}
+
+func NewSketchPercentile(first, second Expression) Function {
+	rv := &SketchPercentile{
+		*NewBinaryFunctionBase("sketch_percentile", first, second),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *SketchPercentile) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *SketchPercentile) Type() value.Type { return value.JSON }
+
+func (this *SketchPercentile) Evaluate(item value.Value, context Context) (value.Value, error) {
+	first, err := this.operands[0].Evaluate(item, context)
+	if err != nil {
+		return nil, err
+	}
+
+	second, err := this.operands[1].Evaluate(item, context)
+	if err != nil {
+		return nil, err
+	} else if first.Type() == value.MISSING || second.Type() == value.MISSING {
+		return value.MISSING_VALUE, nil
+	}
+
+	s, err := sketch.FromValue(first)
+	kll, ok := s.(*sketch.KLL)
+	if err != nil || !ok || kll.Count() == 0 {
+		return value.NULL_VALUE, nil
+	}
+
+	fractions := []interface{}{second}
+	if second.Type() == value.ARRAY {
+		fractions = second.Actual().([]interface{})
+	}
+
+	fs := make([]float64, len(fractions))
+	for i, f := range fractions {
+		fv := value.NewValue(f)
+		if fv.Type() != value.NUMBER {
+			return value.NULL_VALUE, nil
+		}
+		fs[i] = fv.(value.NumberValue).Float64()
+		if fs[i] < 0.0 || fs[i] > 1.0 {
+			return value.NULL_VALUE, nil
+		}
+	}
+
+	quantiles := kll.Quantiles(fs)
+	if second.Type() != value.ARRAY {
+		return value.NewValue(quantiles[0]), nil
+	}
+
+	rv := make([]interface{}, len(quantiles))
+	for i, q := range quantiles {
+		rv[i] = q
+	}
+	return value.NewValue(rv), nil
+}
+
+/*
+Factory method pattern.
+*/
+func (this *SketchPercentile) Constructor() FunctionConstructor {
+	return func(operands ...Expression) Function {
+		return NewSketchPercentile(operands[0], operands[1])
+	}
+}
+
+///////////////////////////////////////////////////
+//
+// SketchUnion
+//
+///////////////////////////////////////////////////
+
+/*
+This represents the function SKETCH_UNION(sketch1, sketch2, ...). It
+returns the merge of sketches of the same kind, skipping NULL values.
+*/
+type SketchUnion struct {
+	FunctionBase
+}
+
+func NewSketchUnion(operands ...Expression) Function {
+	rv := &SketchUnion{
+		*NewFunctionBase("sketch_union", operands...),
+	}
+
+	rv.expr = rv
+	return rv
+}
+
+/*
+Visitor pattern.
+*/
+func (this *SketchUnion) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitFunction(this)
+}
+
+func (this *SketchUnion) Type() value.Type { return value.OBJECT }
+
+func (this *SketchUnion) Evaluate(item value.Value, context Context) (value.Value, error) {
+	var rv sketch.Sketch
+	missing := false
+	null := false
+	for _, op := range this.operands {
+		arg, err := op.Evaluate(item, context)
+		if err != nil {
+			return nil, err
+		} else if arg.Type() == value.MISSING {
+			missing = true
+			continue
+		} else if arg.Type() == value.NULL || null {
+			continue
+		}
+
+		s, err := sketch.FromValue(arg)
+		if err != nil || s == nil {
+			null = true
+		} else if rv == nil {
+			rv = s
+		} else if rv.Merge(s) != nil {
+			null = true
+		}
+	}
+
+	if missing {
+		return value.MISSING_VALUE, nil
+	} else if null || rv == nil {
+		return value.NULL_VALUE, nil
+	}
+	return sketch.ToValue(rv), nil
+}
+
+/*
+Minimum input arguments required is 2.
+*/
+func (this *SketchUnion) MinArgs() int { return 2 }
+
+/*
+Maximum input arguments allowed is MaxInt16 = 1<<15 - 1.
+*/
+func (this *SketchUnion) MaxArgs() int { return math.MaxInt16 }
+
+/*
+Factory method pattern.
+*/
+func (this *SketchUnion) Constructor() FunctionConstructor {
+	return NewSketchUnion
+}
@@ -0,0 +1,42 @@
+package expression
+
+import (
+	"math"
+	"testing"
+
+	"github.com/couchbase/query/util/sketch"
+	"github.com/couchbase/query/value"
+)
+
+func TestSketch(t *testing.T) {
+	hll1, _ := sketch.NewHLL(14)
+	hll2, _ := sketch.NewHLL(12)
+	for i := 0; i < 1000; i++ {
+		hll1.AddHash(sketch.HashValue(value.NewValue(i)))
+		hll2.AddHash(sketch.HashValue(value.NewValue(i + 500)))
+	}
+	kll, _ := sketch.NewKLL(200)
+	for i := 1; i <= 5; i++ {
+		kll.Add(float64(i))
+	}
+
+	h1 := NewConstant(sketch.ToValue(hll1))
+	h2 := NewConstant(sketch.ToValue(hll2))
+	k := NewConstant(sketch.ToValue(kll))
+
+	rv, err := NewSketchCountDistinct(NewSketchUnion(h1, h2)).Evaluate(nil, nil)
+	if err != nil {
+		t.Errorf("received error %v", err)
+	} else if n, ok := rv.Actual().(float64); !ok || math.Abs(n-1500) > 1500*0.05 {
+		t.Errorf("mismatch received %v expected about 1500", rv.Actual())
+	}
+
+	testHash(NewSketchPercentile(k, NewConstant(0)), value.NewValue(1.0), t)
+	testHash(NewSketchPercentile(k, NewConstant([]interface{}{0, 1})), value.NewValue([]interface{}{1.0, 5.0}), t)
+	testHash(NewSketchPercentile(k, NewConstant(1.5)), value.NULL_VALUE, t)
+	testHash(NewSketchPercentile(h1, NewConstant(0.5)), value.NULL_VALUE, t)
+	testHash(NewSketchCountDistinct(k), value.NULL_VALUE, t)
+	testHash(NewSketchCountDistinct(NewConstant("abc")), value.NULL_VALUE, t)
+	testHash(NewSketchCountDistinct(NewConstant(value.MISSING_VALUE)), value.MISSING_VALUE, t)
+	testHash(NewSketchUnion(h1, k), value.NULL_VALUE, t)
+}
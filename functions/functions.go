@@ -89,6 +89,17 @@ type functionCache struct {
 var Constructor func(elem []string, namespace string, queryContext string) (FunctionName, errors.Error)
 var Authorize func(privileges *auth.Privileges, credentials *auth.Credentials) errors.Error
 
+// storage for the code of external functions, where the runner does not provide its own
+type LibraryStore interface {
+	Get(name string) ([]byte, errors.Error) // nil if the library does not exist
+	Set(name string, code []byte) errors.Error
+	Delete(name string) errors.Error
+	Foreach(f func(name string, code []byte) bool) errors.Error
+	Changed() int32 // changes whenever any library changes
+}
+
+var Libraries LibraryStore
+
 var languages = [_SIZER]LanguageRunner{&missing{}, &empty{}}
 var functions = &functionCache{}
 
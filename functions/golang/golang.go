@@ -7,15 +7,20 @@
 //  either express or implied. See the License for the specific language governing permissions
 //  and limitations under the License.
 
-// +build enterprise,go1.10,!windows,!solaris
+// +build !windows,!solaris
 
 package golang
 
 import (
+	"encoding/json"
 	goerrors "errors"
 	"fmt"
 	"os"
 	"plugin"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/couchbase/query/auth"
 	"github.com/couchbase/query/errors"
@@ -24,6 +29,52 @@ import (
 	"github.com/couchbase/query/value"
 )
 
+/*
+Golang functions are exported by go plugins in the udf directory: the library
+is the plugin file, and the object the exported function.
+Plugins are loaded into the query service process, and run with its
+privileges and memory, without any sandbox: a plugin can read anything the
+service can, and a crashing plugin brings the service down.
+For this reason golang functions are disabled unless an administrator enables
+them, by clearing N1QL_GOLANG_UDF in the n1ql-feat-ctrl setting, and plugins
+that are writable by anybody other than the service owner are refused.
+Three signatures are accepted:
+
+  func(args value.Value, context functions.Context) (value.Value, error)
+
+which receives the arguments as an object keyed by parameter name, or an array
+if the function is variadic,
+
+  func(args []interface{}) (interface{}, error)
+
+which receives and returns plain JSON types, so that the plugin does not need
+to be built against the query packages, and
+
+  func(args []interface{}, stop <-chan struct{}) (interface{}, error)
+
+which is also told, by closing stop, when the function has timed out.
+A function can declare itself deterministic, and hence usable in index keys,
+by exporting a boolean variable named after it, with the suffix "Deterministic".
+Plugins can't be stopped once running, so a function that times out without
+returning is abandoned, rather than cancelled; once too many abandoned
+functions are still running, further calls fail until some complete.
+*/
+
+// we won't let a golang function execute more than 2 minutes
+const _MAX_TIMEOUT = 120000 * time.Millisecond
+
+// nor leave more than this many running after timing out
+const _MAX_ABANDONED = 64
+
+const _DETERMINISTIC = "Deterministic"
+
+// states of a running function
+const (
+	_RUNNING = int32(iota)
+	_DONE
+	_ABANDONED
+)
+
 type golang struct {
 }
 
@@ -33,9 +84,17 @@ type golangBody struct {
 	object   string
 }
 
+type result struct {
+	val value.Value
+	err error
+}
+
 var _PATH string
 var enabled = true
 
+// functions that timed out and are still running
+var abandoned int32
+
 func Init() {
 	functions.FunctionsNewLanguage(functions.GOLANG, &golang{})
 
@@ -49,8 +108,6 @@ func Init() {
 }
 
 func (this *golang) Execute(name functions.FunctionName, body functions.FunctionBody, modifiers functions.Modifier, values []value.Value, context functions.Context) (value.Value, errors.Error) {
-	var args, val value.Value
-
 	funcName := name.Name()
 	funcBody, ok := body.(*golangBody)
 
@@ -62,8 +119,11 @@ func (this *golang) Execute(name functions.FunctionName, body functions.Function
 		return nil, errors.NewFunctionsDisabledError("golang")
 	}
 
-	path := _PATH + funcBody.library
-	handle, err := plugin.Open(path)
+	if funcBody.varNames != nil && len(values) != len(funcBody.varNames) {
+		return nil, errors.NewArgumentsMismatchError(funcName)
+	}
+
+	handle, err := funcBody.open()
 	if err != nil {
 		return nil, funcBody.execError(err, funcName)
 	}
@@ -72,30 +132,150 @@ func (this *golang) Execute(name functions.FunctionName, body functions.Function
 		return nil, funcBody.execError(err, funcName)
 	}
 
-	udf, ok := obj.(func(value.Value, functions.Context) (value.Value, error))
-	if !ok {
+	var run func(stop <-chan struct{}) (value.Value, error)
+	switch udf := obj.(type) {
+	case func(value.Value, functions.Context) (value.Value, error):
+		run = func(stop <-chan struct{}) (value.Value, error) {
+			return udf(funcBody.valueArgs(values), context)
+		}
+	case func([]interface{}) (interface{}, error):
+		run = func(stop <-chan struct{}) (value.Value, error) {
+			return runPlain(func(args []interface{}) (interface{}, error) {
+				return udf(args)
+			}, values)
+		}
+	case func([]interface{}, <-chan struct{}) (interface{}, error):
+		run = func(stop <-chan struct{}) (value.Value, error) {
+			return runPlain(func(args []interface{}) (interface{}, error) {
+				return udf(args, stop)
+			}, values)
+		}
+	default:
 		return nil, funcBody.execError(fmt.Errorf("invalid object"), funcName)
 	}
 
-	if funcBody.varNames != nil {
-		if len(values) != len(funcBody.varNames) {
-			return nil, errors.NewArgumentsMismatchError(funcName)
+	timeout := context.GetTimeout()
+	if timeout <= 0 || timeout > _MAX_TIMEOUT {
+		timeout = _MAX_TIMEOUT
+	}
+
+	val, err := runWithTimeout(run, timeout)
+	if err != nil {
+		return nil, funcBody.execError(err, funcName)
+	}
+	if val == nil {
+		return value.NULL_VALUE, nil
+	}
+	return val, nil
+}
+
+/*
+Runs the function in its own goroutine, and waits for it no longer than
+the timeout.
+Functions that time out are told to stop, and are abandoned.
+*/
+func runWithTimeout(run func(stop <-chan struct{}) (value.Value, error), timeout time.Duration) (value.Value, error) {
+	if atomic.LoadInt32(&abandoned) >= _MAX_ABANDONED {
+		return nil, fmt.Errorf("too many timed out functions still running")
+	}
+
+	// buffered, so that an abandoned function can still complete
+	done := make(chan result, 1)
+	stop := make(chan struct{})
+	state := _RUNNING
+	go func() {
+		var res result
+
+		defer func() {
+			r := recover()
+			if r != nil {
+				res = result{nil, fmt.Errorf("panic: %v", r)}
+			}
+			if !atomic.CompareAndSwapInt32(&state, _RUNNING, _DONE) {
+				atomic.AddInt32(&abandoned, -1)
+			}
+			done <- res
+		}()
+		res.val, res.err = run(stop)
+	}()
+
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case res := <-done:
+		return res.val, res.err
+	case <-timer.C:
+	}
+	if !atomic.CompareAndSwapInt32(&state, _RUNNING, _ABANDONED) {
+
+		// completed just as we timed out
+		res := <-done
+		return res.val, res.err
+	}
+	atomic.AddInt32(&abandoned, 1)
+	close(stop)
+	return nil, fmt.Errorf("timed out after %v", timeout)
+}
+
+// plugins are cached by the runtime, so opening them repeatedly is cheap
+func (this *golangBody) open() (*plugin.Plugin, error) {
+
+	// libraries can only come from the udf directory
+	if this.library == "" || strings.ContainsRune(this.library, os.PathSeparator) || strings.Contains(this.library, "..") {
+		return nil, fmt.Errorf("invalid library %v", this.library)
+	}
+
+	// and can only be changed by the service owner
+	for _, p := range []string{_PATH, _PATH + this.library} {
+		info, err := os.Stat(p)
+		if err != nil {
+			return nil, err
 		}
-		argsObj := make(map[string]interface{}, len(values))
-		for i, _ := range values {
-			argsObj[funcBody.varNames[i]] = values[i]
+		if info.Mode().Perm()&0022 != 0 {
+			return nil, fmt.Errorf("%v is writable by other users", p)
 		}
-		args = value.NewValue(argsObj)
-	} else {
-		args = value.NewValue(values)
+		if stat, ok := info.Sys().(*syscall.Stat_t); ok && stat.Uid != 0 && int(stat.Uid) != os.Getuid() {
+			return nil, fmt.Errorf("%v is owned by another user", p)
+		}
+	}
+	return plugin.Open(_PATH + this.library)
+}
+
+func (this *golangBody) valueArgs(values []value.Value) value.Value {
+	if this.varNames == nil {
+		return value.NewValue(values)
+	}
+	argsObj := make(map[string]interface{}, len(values))
+	for i, _ := range values {
+		argsObj[this.varNames[i]] = values[i]
 	}
+	return value.NewValue(argsObj)
+}
 
-	val, err = udf(args, context)
+// arguments and results go through JSON, so that only plain types cross the boundary
+func runPlain(udf func([]interface{}) (interface{}, error), values []value.Value) (value.Value, error) {
+	args := make([]interface{}, len(values))
+	for i, v := range values {
+		if v.Type() == value.MISSING {
+			continue
+		}
+		bytes, err := v.MarshalJSON()
+		if err == nil {
+			err = json.Unmarshal(bytes, &args[i])
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := udf(args)
 	if err != nil {
-		return nil, funcBody.execError(err, funcName)
-	} else {
-		return val, nil
+		return nil, err
 	}
+	bytes, err := json.Marshal(res)
+	if err != nil {
+		return nil, err
+	}
+	return value.NewValue(bytes), nil
 }
 
 func (this *golangBody) execError(err error, name string) errors.Error {
@@ -132,9 +312,23 @@ func (this *golangBody) Body(object map[string]interface{}) {
 	}
 }
 
+// only functions that declare themselves deterministic can be indexed
 func (this *golangBody) Indexable() value.Tristate {
-
-	// for now
+	if !enabled || !util.IsFeatureEnabled(util.GetN1qlFeatureControl(), util.N1QL_GOLANG_UDF) {
+		return value.FALSE
+	}
+	handle, err := this.open()
+	if err != nil {
+		return value.FALSE
+	}
+	obj, err := handle.Lookup(this.object + _DETERMINISTIC)
+	if err != nil {
+		return value.FALSE
+	}
+	deterministic, ok := obj.(*bool)
+	if ok && *deterministic {
+		return value.TRUE
+	}
 	return value.FALSE
 }
 
@@ -150,3 +344,26 @@ func (this *golangBody) IsExternal() bool {
 func (this *golangBody) Privileges() (*auth.Privileges, errors.Error) {
 	return nil, nil
 }
+
+// for tests
+func MakeGolang(name functions.FunctionName, body []byte) (functions.FunctionBody, errors.Error) {
+	var _unmarshalled struct {
+		_          string   `json:"#language"`
+		Parameters []string `json:"parameters"`
+		Library    string   `json:"library"`
+		Object     string   `json:"object"`
+	}
+
+	err := json.Unmarshal(body, &_unmarshalled)
+	if err != nil {
+		return nil, errors.NewFunctionEncodingError("decode body", name.Name(), err)
+	}
+	if _unmarshalled.Object == "" || _unmarshalled.Library == "" {
+		return nil, errors.NewFunctionEncodingError("decode body", name.Name(), goerrors.New("object is missing"))
+	}
+	rv, err1 := NewGolangBody(_unmarshalled.Library, _unmarshalled.Object)
+	if err1 != nil {
+		return nil, err1
+	}
+	return rv, rv.SetVarNames(_unmarshalled.Parameters)
+}
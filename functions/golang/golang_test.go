@@ -0,0 +1,298 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// +build !windows,!solaris
+
+package golang
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/functions"
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+type testContext struct {
+	timeout time.Duration
+}
+
+func (this *testContext) Now() time.Time                           { return time.Now() }
+func (this *testContext) GetTimeout() time.Duration                { return this.timeout }
+func (this *testContext) AuthenticatedUsers() []string             { return nil }
+func (this *testContext) Credentials() *auth.Credentials           { return nil }
+func (this *testContext) DatastoreVersion() string                 { return "" }
+func (this *testContext) Readonly() bool                           { return true }
+func (this *testContext) SetAdvisor()                              {}
+func (this *testContext) NewQueryContext(string, bool) interface{} { return nil }
+func (this *testContext) EvaluateStatement(string, map[string]value.Value, value.Values, bool, bool) (
+	value.Value, uint64, error) {
+	return nil, 0, nil
+}
+
+// only the name is used when executing
+type testName struct {
+	functions.FunctionName
+	name string
+}
+
+func (this *testName) Name() string { return this.name }
+
+// enables golang functions for the duration of the test
+func enableGolang(t *testing.T) func() {
+	control := util.GetN1qlFeatureControl()
+	util.SetN1qlFeatureControl(control &^ util.N1QL_GOLANG_UDF)
+	return func() { util.SetN1qlFeatureControl(control) }
+}
+
+func TestGolangDisabled(t *testing.T) {
+	name := &testName{name: "f"}
+	body := []byte(`{"#language": "golang", "library": "lib", "object": "f"}`)
+
+	control := util.GetN1qlFeatureControl()
+	defer util.SetN1qlFeatureControl(control)
+	util.SetN1qlFeatureControl(control | util.N1QL_GOLANG_UDF)
+	if _, err := MakeGolang(name, body); err == nil || err.Code() != 10108 {
+		t.Errorf("Expected golang functions to be disabled, got %v", err)
+	}
+
+	util.SetN1qlFeatureControl(control &^ util.N1QL_GOLANG_UDF)
+	fb, err := MakeGolang(name, body)
+	if err != nil {
+		t.Fatalf("Failed to create the function: %v", err)
+	}
+	util.SetN1qlFeatureControl(control | util.N1QL_GOLANG_UDF)
+	if _, err = (&golang{}).Execute(name, fb, 0, nil, &testContext{}); err == nil || err.Code() != 10108 {
+		t.Errorf("Expected golang functions to be disabled, got %v", err)
+	}
+}
+
+func TestMakeGolang(t *testing.T) {
+	defer enableGolang(t)()
+	name := &testName{name: "f"}
+
+	fb, err := MakeGolang(name, []byte(`{"#language": "golang", "library": "lib", "object": "f", "parameters": ["a", "b"]}`))
+	if err != nil {
+		t.Fatalf("Failed to create the function: %v", err)
+	}
+	object := make(map[string]interface{})
+	fb.Body(object)
+	expected := value.NewValue(map[string]interface{}{"#language": "golang", "library": "lib", "object": "f",
+		"parameters": []interface{}{"a", "b"}})
+	if !expected.Equals(value.NewValue(object)).Truth() {
+		t.Errorf("Expected %v, got %v", expected, object)
+	}
+
+	for _, body := range []string{`{"library": "lib"}`, `{"object": "f"}`, `[`} {
+		if _, err = MakeGolang(name, []byte(body)); err == nil {
+			t.Errorf("Expected %v to fail", body)
+		}
+	}
+}
+
+func TestGolangArgs(t *testing.T) {
+	values := []value.Value{value.NewValue(1), value.NewValue("a"), value.MISSING_VALUE}
+
+	args := (&golangBody{varNames: []string{"x", "y", "z"}}).valueArgs(values)
+	if x, _ := args.Field("x"); x.Actual() != int64(1) && x.Actual() != float64(1) {
+		t.Errorf("Expected x to be 1, got %v", args)
+	}
+	if args = (&golangBody{}).valueArgs(values); args.Type() != value.ARRAY {
+		t.Errorf("Expected variadic arguments to be an array, got %v", args)
+	}
+
+	rv, err := runPlain(func(args []interface{}) (interface{}, error) {
+		return map[string]interface{}{"args": args}, nil
+	}, values)
+	expected := value.NewValue(map[string]interface{}{"args": []interface{}{1, "a", nil}})
+	if err != nil || expected.Collate(rv) != 0 {
+		t.Errorf("Expected %v, got %v: %v", expected, rv, err)
+	}
+
+	_, err = runPlain(func(args []interface{}) (interface{}, error) {
+		return func() {}, nil
+	}, values)
+	if err == nil {
+		t.Errorf("Expected a result that can't be marshalled to fail")
+	}
+}
+
+func TestGolangTimeout(t *testing.T) {
+	rv, err := runWithTimeout(func(stop <-chan struct{}) (value.Value, error) {
+		return value.NewValue(1), nil
+	}, time.Second)
+	if err != nil || rv.Actual() != int64(1) && rv.Actual() != float64(1) {
+		t.Errorf("Expected 1, got %v: %v", rv, err)
+	}
+
+	_, err = runWithTimeout(func(stop <-chan struct{}) (value.Value, error) {
+		panic("oops")
+	}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "oops") {
+		t.Errorf("Expected a panic to be reported, got %v", err)
+	}
+
+	// functions that are told to stop are no longer counted once they return
+	stopped := make(chan bool)
+	_, err = runWithTimeout(func(stop <-chan struct{}) (value.Value, error) {
+		<-stop
+		stopped <- true
+		return nil, nil
+	}, 10*time.Millisecond)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	<-stopped
+	waitAbandoned(t, 0)
+
+	// functions that don't are counted until they return
+	release := make(chan bool)
+	for i := 0; i < _MAX_ABANDONED; i++ {
+		_, err = runWithTimeout(func(stop <-chan struct{}) (value.Value, error) {
+			<-release
+			return nil, nil
+		}, time.Millisecond)
+		if err == nil {
+			t.Fatalf("Expected a timeout")
+		}
+	}
+	_, err = runWithTimeout(func(stop <-chan struct{}) (value.Value, error) {
+		return nil, nil
+	}, time.Second)
+	if err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("Expected too many functions to be running, got %v", err)
+	}
+	close(release)
+	waitAbandoned(t, 0)
+}
+
+func waitAbandoned(t *testing.T, n int32) {
+	for i := 0; i < 100 && atomic.LoadInt32(&abandoned) != n; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if a := atomic.LoadInt32(&abandoned); a != n {
+		t.Errorf("Expected %v abandoned functions, got %v", n, a)
+	}
+}
+
+const _PLUGIN = `package main
+
+import "fmt"
+
+var AddDeterministic = true
+
+func Add(args []interface{}) (interface{}, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf("expected 2 arguments")
+	}
+	return args[0].(float64) + args[1].(float64), nil
+}
+
+func Wait(args []interface{}, stop <-chan struct{}) (interface{}, error) {
+	<-stop
+	return nil, nil
+}
+
+var NotFunction = 1
+`
+
+// builds a plugin in a new udf directory
+func buildPlugin(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "udf")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	src := filepath.Join(dir, "src")
+	os.Mkdir(src, 0700)
+	ioutil.WriteFile(filepath.Join(src, "go.mod"), []byte("module udfplugin\n"), 0600)
+	ioutil.WriteFile(filepath.Join(src, "plugin.go"), []byte(_PLUGIN), 0600)
+
+	cmd := exec.Command("go", "build", "-buildmode=plugin", "-o", filepath.Join(dir, "lib.so"), ".")
+	cmd.Dir = src
+	if out, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(dir)
+		t.Skipf("Plugins can't be built: %v %s", err, out)
+	}
+	return dir
+}
+
+func TestGolangPlugin(t *testing.T) {
+	defer enableGolang(t)()
+	dir := buildPlugin(t)
+	defer os.RemoveAll(dir)
+
+	path := _PATH
+	defer func() { _PATH = path }()
+	_PATH = dir + "/"
+
+	execute := func(object string, args ...interface{}) (value.Value, errors.Error) {
+		fb, err := NewGolangBody("lib.so", object)
+		if err != nil {
+			t.Fatalf("Failed to create %v: %v", object, err)
+		}
+		values := make([]value.Value, len(args))
+		for i, a := range args {
+			values[i] = value.NewValue(a)
+		}
+		return (&golang{}).Execute(&testName{name: object}, fb, 0, values, &testContext{timeout: 100 * time.Millisecond})
+	}
+
+	rv, err := execute("Add", 1, 2)
+	if err != nil || rv.Actual() != float64(3) && rv.Actual() != int64(3) {
+		t.Errorf("Expected 3, got %v: %v", rv, err)
+	}
+	if _, err = execute("Add", 1); err == nil {
+		t.Errorf("Expected Add to fail")
+	}
+	if _, err = execute("Wait"); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected Wait to time out, got %v", err)
+	}
+	waitAbandoned(t, 0)
+	for _, object := range []string{"NotFunction", "Missing"} {
+		if _, err = execute(object); err == nil {
+			t.Errorf("Expected %v to fail", object)
+		}
+	}
+
+	fb, _ := NewGolangBody("lib.so", "Add")
+	if fb.Indexable() != value.TRUE {
+		t.Errorf("Expected Add to be indexable")
+	}
+	control := util.GetN1qlFeatureControl()
+	util.SetN1qlFeatureControl(control | util.N1QL_GOLANG_UDF)
+	if fb.Indexable() != value.FALSE {
+		t.Errorf("Expected Add not to be indexable with golang functions disabled")
+	}
+	util.SetN1qlFeatureControl(control)
+	fb, _ = NewGolangBody("lib.so", "Wait")
+	if fb.Indexable() != value.FALSE {
+		t.Errorf("Expected Wait not to be indexable")
+	}
+
+	// libraries must come from the udf directory, and be protected from other users
+	for _, library := range []string{"../lib.so", "src/plugin.go", ""} {
+		if _, err := (&golangBody{library: library, object: "Add"}).open(); err == nil {
+			t.Errorf("Expected %v to be refused", library)
+		}
+	}
+	os.Chmod(filepath.Join(dir, "lib.so"), 0777)
+	if _, err := (&golangBody{library: "lib.so", object: "Add"}).open(); err == nil ||
+		!strings.Contains(err.Error(), "writable") {
+		t.Errorf("Expected a writable library to be refused, got %v", err)
+	}
+}
@@ -7,7 +7,7 @@
 //  either express or implied. See the License for the specific language governing permissions
 //  and limitations under the License.
 
-// +build !enterprise !go1.10 windows solaris
+// +build windows solaris
 
 package golang
 
@@ -17,7 +17,8 @@ import (
 	"github.com/couchbase/query/value"
 )
 
-// this body is used to fail function creation where not supported
+// go plugins are not available on these platforms
+// this body is used to fail function creation
 type golangBody struct {
 }
 
@@ -25,7 +26,7 @@ func Init() {
 }
 
 func NewGolangBody(library, object string) (functions.FunctionBody, errors.Error) {
-	return nil, errors.NewFunctionsNotSupported("golang")
+	return nil, errors.NewFunctionsDisabledError("golang")
 }
 
 func (this *golangBody) Lang() functions.Language {
@@ -47,5 +48,5 @@ func (this *golangBody) Indexable() value.Tristate {
 
 // ditto, for tests
 func MakeGolang(name functions.FunctionName, body []byte) (functions.FunctionBody, errors.Error) {
-	return nil, errors.NewFunctionsNotSupported("golang")
+	return nil, errors.NewFunctionsDisabledError("golang")
 }
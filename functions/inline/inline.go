@@ -149,8 +149,14 @@ func (this *inlineBody) Privileges() (*auth.Privileges, errors.Error) {
 
 	privileges := auth.NewPrivileges()
 	for _, s := range subqueries {
-		sub := s.(*algebra.Subquery)
-		sp, e := sub.Select().Privileges()
+		var query *algebra.Select
+		switch sub := s.(type) {
+		case *algebra.With:
+			query = sub.Select()
+		default:
+			query = sub.(*algebra.Subquery).Select()
+		}
+		sp, e := query.Privileges()
 		if e != nil {
 			return nil, e
 		}
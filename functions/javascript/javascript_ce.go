@@ -7,46 +7,416 @@
 //  either express or implied. See the License for the specific language governing permissions
 //  and limitations under the License.
 
-// +build !enterprise !go1.10
+// +build !enterprise
 
 package javascript
 
 import (
+	"encoding/json"
+	goerrors "errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/auth"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/functions"
 	"github.com/couchbase/query/value"
+	"github.com/dop251/goja"
 	"github.com/gorilla/mux"
 )
 
-// this body is used to fail function creation where not supported
+/*
+In the community edition, javascript functions are run by an embedded
+interpreter, in the query service itself.
+Libraries are stored in the functions library store, and compiled on first
+use. Each interpreter runs a library once, and is then reused for further
+calls to functions in that library made by the same request, one call at a
+time: global variables set by a call are seen by later calls of the request,
+never by other requests.
+Calls are bounded in time, in stack depth, and in how much they grow the
+query service heap, which the interpreter shares: the interpreter doesn't
+account for its own memory, so the heap is sampled while the call runs.
+*/
+
+// we won't let a javascript function execute more than 2 minutes
+const _MAX_TIMEOUT = 120000 * time.Millisecond
+
+// nor recurse deeper than this
+const _MAX_STACK = 1024
+
+// nor grow the heap by more than this
+var _MAX_MEMORY uint64 = 1024 * 1024 * 1024
+
+// how often the heap is sampled while a function runs
+const _MEMORY_CHECK = 50 * time.Millisecond
+
+// idle interpreters kept for each library
+const _MAX_IDLE = 8
+
+type javascript struct {
+}
+
 type javascriptBody struct {
+	varNames []string
+	library  string
+	object   string
+}
+
+// a compiled library, and the interpreters that have run it, for the requests they ran it for
+type libraryProgram struct {
+	program *goja.Program
+	idle    []*idleRuntime
+}
+
+type idleRuntime struct {
+	vm        *goja.Runtime
+	requestId string
+}
+
+// execution contexts identify the request functions are called for
+type requestContext interface {
+	RequestId() string
 }
 
-func Init(mix *mux.Router) {
+type programCache struct {
+	sync.Mutex
+	changed  int32
+	programs map[string]*libraryProgram
+}
+
+var programs = &programCache{programs: make(map[string]*libraryProgram)}
+
+func Init(mux *mux.Router) {
+	functions.FunctionsNewLanguage(functions.JAVASCRIPT, &javascript{})
+	if mux != nil {
+		initLibraryHandlers(mux)
+	}
+}
+
+func (this *javascript) Execute(name functions.FunctionName, body functions.FunctionBody, modifiers functions.Modifier, values []value.Value, context functions.Context) (value.Value, errors.Error) {
+	funcName := name.Name()
+	funcBody, ok := body.(*javascriptBody)
+
+	if !ok {
+		return nil, errors.NewInternalFunctionError(goerrors.New("Wrong language being executed!"), funcName)
+	}
+
+	if funcBody.varNames != nil && len(values) != len(funcBody.varNames) {
+		return nil, errors.NewArgumentsMismatchError(funcName)
+	}
+
+	lib, changed, err := loadProgram(funcBody.library)
+	if err != nil {
+		return nil, funcBody.execError(err, funcName)
+	}
+
+	timeout := context.GetTimeout()
+	if timeout <= 0 || timeout > _MAX_TIMEOUT {
+		timeout = _MAX_TIMEOUT
+	}
+
+	// without a request, interpreters are not reused
+	requestId := ""
+	if rc, ok := context.(requestContext); ok {
+		requestId = rc.RequestId()
+	}
+	var vm *goja.Runtime
+	if requestId != "" {
+		vm = programs.get(lib, requestId)
+	}
+	fresh := vm == nil
+	if fresh {
+		vm = goja.New()
+		vm.SetMaxCallStackSize(_MAX_STACK)
+	}
+	stop := watch(vm, timeout, _MAX_MEMORY)
+	rv, err := funcBody.call(vm, lib, fresh, values)
+
+	// interrupted interpreters may have been stopped half way through changing their state
+	if !stop() && requestId != "" {
+		programs.put(lib, vm, requestId, changed)
+	}
+	if err != nil {
+		return nil, funcBody.execError(err, funcName)
+	}
+	return rv, nil
+}
+
+func (this *javascriptBody) call(vm *goja.Runtime, lib *libraryProgram, fresh bool, values []value.Value) (
+	value.Value, error) {
+
+	if fresh {
+		_, err := vm.RunProgram(lib.program)
+		if err != nil {
+			return nil, err
+		}
+	}
+	f, ok := goja.AssertFunction(vm.Get(this.object))
+	if !ok {
+		return nil, fmt.Errorf("%v is not a function", this.object)
+	}
+
+	var err error
+	args := make([]goja.Value, len(values))
+	for i, v := range values {
+		args[i], err = toJavascript(vm, v)
+		if err != nil {
+			return nil, err
+		}
+	}
+	res, err := f(goja.Undefined(), args...)
+	if err != nil {
+		return nil, err
+	}
+	return fromJavascript(res)
+}
+
+/*
+Interrupts the interpreter once the function times out, or once the heap
+has grown by more than the memory limit since the function started.
+Functions that complete before the first sample are not measured, so that
+short calls don't pay for reading the memory statistics.
+The function returned stops the watch, and reports whether the interpreter
+was interrupted.
+*/
+func watch(vm *goja.Runtime, timeout time.Duration, memory uint64) func() bool {
+	done := make(chan bool)
+	interrupted := make(chan bool, 1)
+
+	go func() {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		ticker := time.NewTicker(_MEMORY_CHECK)
+		defer ticker.Stop()
+
+		var stats runtime.MemStats
+		var start uint64
+		for {
+			select {
+			case <-done:
+				interrupted <- false
+				return
+			case <-timer.C:
+				vm.Interrupt(fmt.Errorf("timed out after %v", timeout))
+				interrupted <- true
+				return
+			case <-ticker.C:
+				runtime.ReadMemStats(&stats)
+				if start == 0 {
+					start = stats.HeapAlloc
+				} else if stats.HeapAlloc > start+memory {
+					vm.Interrupt(fmt.Errorf("exceeded the memory limit of %v bytes", memory))
+					interrupted <- true
+					return
+				}
+			}
+		}
+	}()
+	return func() bool {
+		close(done)
+		return <-interrupted
+	}
+}
+
+/*
+Compile the library, or get it from the cache if it hasn't changed.
+Also returns the library change counter the program was compiled for.
+*/
+func loadProgram(library string) (*libraryProgram, int32, error) {
+	store := functions.Libraries
+	if store == nil {
+		return nil, 0, goerrors.New("library store not available")
+	}
+	changed := store.Changed()
+
+	programs.Lock()
+	if programs.changed != changed {
+		programs.programs = make(map[string]*libraryProgram)
+		programs.changed = changed
+	}
+	lib := programs.programs[library]
+	programs.Unlock()
+	if lib != nil {
+		return lib, changed, nil
+	}
+
+	code, err := store.Get(library)
+	if err != nil {
+		return nil, 0, err
+	}
+	if code == nil {
+		return nil, 0, errors.NewMissingLibraryError(library)
+	}
+	program, err1 := goja.Compile(library, string(code), false)
+	if err1 != nil {
+		return nil, 0, err1
+	}
+
+	lib = &libraryProgram{program: program}
+	programs.Lock()
+	if programs.changed == changed {
+		if cached := programs.programs[library]; cached != nil {
+			lib = cached
+		} else {
+			programs.programs[library] = lib
+		}
+	}
+	programs.Unlock()
+	return lib, changed, nil
+}
+
+// an interpreter that has already run the library for the request, if any is idle
+func (this *programCache) get(lib *libraryProgram, requestId string) *goja.Runtime {
+	this.Lock()
+	defer this.Unlock()
+	for i := len(lib.idle) - 1; i >= 0; i-- {
+		if lib.idle[i].requestId == requestId {
+			vm := lib.idle[i].vm
+			copy(lib.idle[i:], lib.idle[i+1:])
+			lib.idle[len(lib.idle)-1] = nil
+			lib.idle = lib.idle[:len(lib.idle)-1]
+			return vm
+		}
+	}
+	return nil
+}
+
+/*
+Interpreters for libraries that have changed since are dropped, and so are
+the least recently used ones, which likely belong to completed requests.
+*/
+func (this *programCache) put(lib *libraryProgram, vm *goja.Runtime, requestId string, changed int32) {
+	this.Lock()
+	defer this.Unlock()
+	if this.changed != changed {
+		return
+	}
+	if len(lib.idle) >= _MAX_IDLE {
+		copy(lib.idle, lib.idle[1:])
+		lib.idle = lib.idle[:len(lib.idle)-1]
+	}
+	lib.idle = append(lib.idle, &idleRuntime{vm: vm, requestId: requestId})
+}
+
+// values are passed as JSON, so that no go value leaks into the interpreter
+func toJavascript(vm *goja.Runtime, v value.Value) (goja.Value, error) {
+	switch v.Type() {
+	case value.MISSING:
+		return goja.Undefined(), nil
+	case value.NULL:
+		return goja.Null(), nil
+	case value.BOOLEAN, value.NUMBER, value.STRING:
+		return vm.ToValue(v.Actual()), nil
+	}
+	bytes, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+	parse, _ := goja.AssertFunction(vm.Get("JSON").ToObject(vm).Get("parse"))
+	return parse(goja.Undefined(), vm.ToValue(string(bytes)))
+}
+
+// results are converted as JSON.stringify would
+func fromJavascript(v goja.Value) (value.Value, error) {
+	if v == nil || goja.IsUndefined(v) {
+		return value.MISSING_VALUE, nil
+	}
+	if goja.IsNull(v) {
+		return value.NULL_VALUE, nil
+	}
+	switch r := v.Export().(type) {
+	case bool, string, int64:
+		return value.NewValue(r), nil
+	case float64:
+		if math.IsNaN(r) || math.IsInf(r, 0) {
+			return value.NULL_VALUE, nil
+		}
+		return value.NewValue(r), nil
+	}
+	bytes, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return value.NewValue(bytes), nil
+}
+
+func (this *javascriptBody) execError(err error, name string) errors.Error {
+	var reason error
+
+	switch e := err.(type) {
+	case *goja.InterruptedError:
+		reason = fmt.Errorf("%v", e.Value())
+	case *goja.Exception:
+		reason = fmt.Errorf("%v", e.String())
+	default:
+		reason = err
+	}
+	return errors.NewFunctionExecutionError(fmt.Sprintf("(%v:%v)", this.library, this.object), name, reason)
 }
 
 func NewJavascriptBody(library, object string) (functions.FunctionBody, errors.Error) {
-	return nil, errors.NewFunctionsNotSupported("javascript")
+	return &javascriptBody{library: library, object: object}, nil
+}
+
+func (this *javascriptBody) SetVarNames(vars []string) errors.Error {
+	this.varNames = vars
+	return nil
 }
 
 func (this *javascriptBody) Lang() functions.Language {
-	return functions.GOLANG
+	return functions.JAVASCRIPT
 }
 
-// this will never be called, just a placeholder
 func (this *javascriptBody) Body(object map[string]interface{}) {
-	object["functions_feature_disabled"] = true
+	object["#language"] = "javascript"
+	object["library"] = this.library
+	object["object"] = this.object
+	if this.varNames != nil {
+		vars := make([]value.Value, len(this.varNames))
+		for v, _ := range this.varNames {
+			vars[v] = value.NewValue(this.varNames[v])
+		}
+		object["parameters"] = vars
+	}
 }
 
-//ditto
-func (this *javascriptBody) SetVars(vars []string) {
+func (this *javascriptBody) Indexable() value.Tristate {
+
+	// functions can be changed under the index's feet by changing the library
+	return value.FALSE
 }
 
-func (this *javascriptBody) Indexable() value.Tristate {
+func (this *javascriptBody) SwitchContext() value.Tristate {
 	return value.FALSE
 }
 
-// ditto, for tests
+func (this *javascriptBody) IsExternal() bool {
+	return true
+}
+
+func (this *javascriptBody) Privileges() (*auth.Privileges, errors.Error) {
+	return nil, nil
+}
+
+// for tests
 func MakeJavascript(name functions.FunctionName, body []byte) (functions.FunctionBody, errors.Error) {
-	return nil, errors.NewFunctionsNotSupported("javascript")
+	var _unmarshalled struct {
+		_          string   `json:"#language"`
+		Parameters []string `json:"parameters"`
+		Library    string   `json:"library"`
+		Object     string   `json:"object"`
+	}
+
+	err := json.Unmarshal(body, &_unmarshalled)
+	if err != nil {
+		return nil, errors.NewFunctionEncodingError("decode body", name.Name(), err)
+	}
+	if _unmarshalled.Object == "" || _unmarshalled.Library == "" {
+		return nil, errors.NewFunctionEncodingError("decode body", name.Name(), goerrors.New("object is missing"))
+	}
+	rv, _ := NewJavascriptBody(_unmarshalled.Library, _unmarshalled.Object)
+	return rv, rv.SetVarNames(_unmarshalled.Parameters)
 }
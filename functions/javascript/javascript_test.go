@@ -0,0 +1,214 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// +build !enterprise
+
+package javascript
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/functions"
+	"github.com/couchbase/query/value"
+)
+
+type testLibraries struct {
+	sync.Mutex
+	changed   int32
+	libraries map[string][]byte
+}
+
+func (this *testLibraries) Get(name string) ([]byte, errors.Error) {
+	this.Lock()
+	defer this.Unlock()
+	return this.libraries[name], nil
+}
+
+func (this *testLibraries) Set(name string, code []byte) errors.Error {
+	this.Lock()
+	defer this.Unlock()
+	this.libraries[name] = code
+	this.changed++
+	return nil
+}
+
+func (this *testLibraries) Delete(name string) errors.Error {
+	this.Lock()
+	defer this.Unlock()
+	delete(this.libraries, name)
+	this.changed++
+	return nil
+}
+
+func (this *testLibraries) Foreach(f func(name string, code []byte) bool) errors.Error {
+	this.Lock()
+	defer this.Unlock()
+	for n, c := range this.libraries {
+		if !f(n, c) {
+			break
+		}
+	}
+	return nil
+}
+
+func (this *testLibraries) Changed() int32 {
+	this.Lock()
+	defer this.Unlock()
+	return this.changed
+}
+
+type testContext struct {
+	timeout   time.Duration
+	requestId string
+}
+
+func (this *testContext) Now() time.Time                           { return time.Now() }
+func (this *testContext) GetTimeout() time.Duration                { return this.timeout }
+func (this *testContext) RequestId() string                        { return this.requestId }
+func (this *testContext) AuthenticatedUsers() []string             { return nil }
+func (this *testContext) Credentials() *auth.Credentials           { return nil }
+func (this *testContext) DatastoreVersion() string                 { return "" }
+func (this *testContext) Readonly() bool                           { return true }
+func (this *testContext) SetAdvisor()                              {}
+func (this *testContext) NewQueryContext(string, bool) interface{} { return nil }
+func (this *testContext) EvaluateStatement(string, map[string]value.Value, value.Values, bool, bool) (
+	value.Value, uint64, error) {
+	return nil, 0, nil
+}
+
+// only the name is used when executing
+type testName struct {
+	functions.FunctionName
+	name string
+}
+
+func (this *testName) Name() string { return this.name }
+
+func initLibraries() *testLibraries {
+	store := &testLibraries{libraries: make(map[string][]byte)}
+	functions.Libraries = store
+	return store
+}
+
+func execute(t *testing.T, library, object string, context functions.Context, args ...interface{}) (
+	value.Value, errors.Error) {
+	body, err := NewJavascriptBody(library, object)
+	if err != nil {
+		t.Fatalf("Failed to create %v:%v: %v", library, object, err)
+	}
+	values := make([]value.Value, len(args))
+	for i, a := range args {
+		values[i] = value.NewValue(a)
+	}
+	return (&javascript{}).Execute(&testName{name: object}, body, 0, values, context)
+}
+
+func TestJavascriptExecute(t *testing.T) {
+	store := initLibraries()
+	store.Set("math", []byte(`
+		function add(a, b) { return a + b; }
+		function swap(o) { return {a: o.b, b: o.a, list: [o.a, o.b]}; }
+		function none() { }
+		function fail() { throw "failed"; }
+		var notfunc = 1;`))
+	context := &testContext{}
+
+	rv, err := execute(t, "math", "add", context, 1, 2)
+	if err != nil || rv.Actual() != float64(3) && rv.Actual() != int64(3) {
+		t.Errorf("Expected 3, got %v: %v", rv, err)
+	}
+	rv, err = execute(t, "math", "swap", context, map[string]interface{}{"a": "x", "b": 2})
+	expected := value.NewValue(map[string]interface{}{"a": 2, "b": "x", "list": []interface{}{"x", 2}})
+	if err != nil || !expected.Equals(rv).Truth() {
+		t.Errorf("Expected %v, got %v: %v", expected, rv, err)
+	}
+	rv, err = execute(t, "math", "none", context)
+	if err != nil || rv.Type() != value.MISSING {
+		t.Errorf("Expected MISSING, got %v: %v", rv, err)
+	}
+
+	for _, f := range []string{"fail", "notfunc", "nothere"} {
+		if _, err = execute(t, "math", f, context); err == nil {
+			t.Errorf("Expected %v to fail", f)
+		}
+	}
+	if _, err = execute(t, "nolib", "add", context, 1, 2); err == nil {
+		t.Errorf("Expected a missing library to fail")
+	}
+}
+
+func TestJavascriptTimeout(t *testing.T) {
+	store := initLibraries()
+	store.Set("loop", []byte(`function loop() { while (true) {} }`))
+
+	start := time.Now()
+	_, err := execute(t, "loop", "loop", &testContext{timeout: 100 * time.Millisecond})
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if d := time.Since(start); d > 10*time.Second {
+		t.Errorf("Expected the function to be interrupted, took %v", d)
+	}
+}
+
+func TestJavascriptMemory(t *testing.T) {
+	store := initLibraries()
+	store.Set("grow", []byte(`function grow() { var a = []; while (true) { a.push("x" + a.length); } }`))
+
+	memory := _MAX_MEMORY
+	defer func() { _MAX_MEMORY = memory }()
+	_MAX_MEMORY = 16 * 1024 * 1024
+
+	_, err := execute(t, "grow", "grow", &testContext{timeout: time.Minute, requestId: "r1"})
+	if err == nil || !strings.Contains(err.Error(), "memory limit") {
+		t.Errorf("Expected the memory limit to be exceeded, got %v", err)
+	}
+}
+
+// interpreters are reused by the same request until the library changes
+func TestJavascriptReuse(t *testing.T) {
+	store := initLibraries()
+	store.Set("count", []byte(`var calls = 0; function count() { return ++calls; }`))
+	context := &testContext{requestId: "r1"}
+
+	for i := 1; i <= 3; i++ {
+		rv, err := execute(t, "count", "count", context)
+		if err != nil || value.NewValue(i).Equals(rv) != value.TRUE_VALUE {
+			t.Errorf("Expected %v, got %v: %v", i, rv, err)
+		}
+	}
+
+	// other requests, and calls outside of requests, don't see the globals of the request
+	for _, other := range []*testContext{&testContext{requestId: "r2"}, &testContext{}, &testContext{}} {
+		rv, err := execute(t, "count", "count", other)
+		if err != nil || value.NewValue(1).Equals(rv) != value.TRUE_VALUE {
+			t.Errorf("Expected a fresh interpreter for request %q, got %v: %v", other.requestId, rv, err)
+		}
+	}
+	rv, err := execute(t, "count", "count", context)
+	if err != nil || value.NewValue(4).Equals(rv) != value.TRUE_VALUE {
+		t.Errorf("Expected 4, got %v: %v", rv, err)
+	}
+
+	store.Set("count", []byte(`var calls = 10; function count() { return ++calls; }`))
+	rv, err = execute(t, "count", "count", context)
+	if err != nil || value.NewValue(11).Equals(rv) != value.TRUE_VALUE {
+		t.Errorf("Expected the changed library to be run, got %v: %v", rv, err)
+	}
+
+	store.Delete("count")
+	if _, err = execute(t, "count", "count", context); err == nil {
+		t.Errorf("Expected a deleted library to fail")
+	}
+}
@@ -0,0 +1,143 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// +build !enterprise
+
+package javascript
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/functions"
+	"github.com/dop251/goja"
+	"github.com/gorilla/mux"
+)
+
+// same endpoints as the enterprise evaluator
+const _LIBRARIES_PREFIX = "/evaluator/v1/libraries"
+
+// maximum size of a library
+const _MAX_LIBRARY = 1024 * 1024
+
+func initLibraryHandlers(mux *mux.Router) {
+	mux.HandleFunc(_LIBRARIES_PREFIX, doLibraries).Methods("GET")
+	mux.HandleFunc(_LIBRARIES_PREFIX+"/{library}", doLibrary).Methods("GET", "POST", "PUT", "DELETE")
+}
+
+func doLibraries(w http.ResponseWriter, req *http.Request) {
+	if !authorizeLibraries(w, req) {
+		return
+	}
+	store := functions.Libraries
+	if store == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.NewFunctionsDisabledError("javascript"))
+		return
+	}
+
+	libraries := make([]map[string]interface{}, 0)
+	err := store.Foreach(func(name string, code []byte) bool {
+		libraries = append(libraries, map[string]interface{}{"name": name, "code": string(code)})
+		return true
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	writeResult(w, libraries)
+}
+
+func doLibrary(w http.ResponseWriter, req *http.Request) {
+	if !authorizeLibraries(w, req) {
+		return
+	}
+	store := functions.Libraries
+	if store == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.NewFunctionsDisabledError("javascript"))
+		return
+	}
+	library := mux.Vars(req)["library"]
+
+	switch req.Method {
+	case "GET":
+		code, err := store.Get(library)
+		if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+		} else if code == nil {
+			writeError(w, http.StatusNotFound, errors.NewMissingLibraryError(library))
+		} else {
+			writeResult(w, map[string]interface{}{"name": library, "code": string(code)})
+		}
+	case "POST", "PUT":
+		code, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, _MAX_LIBRARY))
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.NewAdminBodyError(err))
+			return
+		}
+
+		// reject libraries that can't be run
+		_, err = goja.Compile(library, string(code), false)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, errors.NewFunctionEncodingError("compile", library, err))
+			return
+		}
+		err1 := store.Set(library, code)
+		if err1 != nil {
+			writeError(w, http.StatusInternalServerError, err1)
+		} else {
+			writeResult(w, "OK")
+		}
+	case "DELETE":
+		err := store.Delete(library)
+		if err != nil && err.Code() == errors.MISSING_LIBRARY {
+			writeError(w, http.StatusNotFound, err)
+		} else if err != nil {
+			writeError(w, http.StatusInternalServerError, err)
+		} else {
+			writeResult(w, "OK")
+		}
+	}
+}
+
+// the same privilege is needed to manage libraries as to manage the functions that use them
+func authorizeLibraries(w http.ResponseWriter, req *http.Request) bool {
+	if functions.Authorize == nil {
+		writeError(w, http.StatusServiceUnavailable, errors.NewFunctionsDisabledError("javascript"))
+		return false
+	}
+	creds := auth.NewCredentials()
+	user, pass, ok := req.BasicAuth()
+	if ok {
+		creds.Users[user] = pass
+	}
+	privs := auth.NewPrivileges()
+	privs.Add("", auth.PRIV_QUERY_MANAGE_FUNCTIONS_EXTERNAL, auth.PRIV_PROPS_NONE)
+	err := functions.Authorize(privs, creds)
+	if err != nil {
+		writeError(w, http.StatusUnauthorized, err)
+		return false
+	}
+	return true
+}
+
+func writeResult(w http.ResponseWriter, res interface{}) {
+	bytes, _ := json.Marshal(res)
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(bytes)
+}
+
+func writeError(w http.ResponseWriter, status int, err errors.Error) {
+	bytes, _ := json.Marshal(err)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(bytes)
+}
@@ -0,0 +1,156 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package metaStorage
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/cbauth/metakv"
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/functions"
+)
+
+const _LIBRARY_PATH = "/query/libraries/"
+
+// libraries live in metakv, alongside the function definitions, and share their change counter
+type metaLibraries struct {
+}
+
+// when metakv is not available (no cbauth, so not part of a cluster), libraries are kept locally
+type localLibraries struct {
+	sync.RWMutex
+	libraries map[string][]byte
+	changed   int32
+}
+
+func initLibraries() {
+	if cbauth.Default == nil {
+		functions.Libraries = &localLibraries{libraries: make(map[string][]byte)}
+	} else {
+		functions.Libraries = &metaLibraries{}
+	}
+}
+
+func (this *metaLibraries) Get(name string) ([]byte, errors.Error) {
+	code, _, err := metakv.Get(_LIBRARY_PATH + name)
+	if err != nil {
+		return nil, errors.NewMetaKVError(name, err)
+	}
+	return code, nil
+}
+
+func (this *metaLibraries) Set(name string, code []byte) errors.Error {
+	err := metakv.Set(_LIBRARY_PATH+name, code, nil)
+	if err != nil {
+		return errors.NewMetaKVError(name, err)
+	}
+	setChange()
+	return nil
+}
+
+func (this *metaLibraries) Delete(name string) errors.Error {
+
+	// as with functions, Delete() does not report missing keys
+	code, _, err := metakv.Get(_LIBRARY_PATH + name)
+	if code == nil && err == nil {
+		return errors.NewMissingLibraryError(name)
+	} else if err != nil {
+		return errors.NewMetaKVError(name, err)
+	}
+
+	err = metakv.Delete(_LIBRARY_PATH+name, nil)
+	if isNotFoundError(err) {
+		return errors.NewMissingLibraryError(name)
+	} else if err != nil {
+		return errors.NewMetaKVError(name, err)
+	}
+	setChange()
+	return nil
+}
+
+func (this *metaLibraries) Foreach(f func(name string, code []byte) bool) errors.Error {
+	err := metakv.IterateChildren(_LIBRARY_PATH, func(path string, value []byte, rev interface{}) error {
+		if !f(path[len(_LIBRARY_PATH):], value) {
+			return errStop
+		}
+		return nil
+	})
+	if err != nil && err != errStop {
+		return errors.NewMetaKVIndexError(err)
+	}
+	return nil
+}
+
+func (this *metaLibraries) Changed() int32 {
+	return atomic.LoadInt32(&changeCounter)
+}
+
+type stopError struct {
+}
+
+func (this *stopError) Error() string {
+	return "stop"
+}
+
+// used to terminate metakv iterations early
+var errStop = &stopError{}
+
+func (this *localLibraries) Get(name string) ([]byte, errors.Error) {
+	this.RLock()
+	defer this.RUnlock()
+	return this.libraries[name], nil
+}
+
+func (this *localLibraries) Set(name string, code []byte) errors.Error {
+	this.Lock()
+	this.libraries[name] = code
+	this.changed++
+	this.Unlock()
+	return nil
+}
+
+func (this *localLibraries) Delete(name string) errors.Error {
+	this.Lock()
+	defer this.Unlock()
+	_, ok := this.libraries[name]
+	if !ok {
+		return errors.NewMissingLibraryError(name)
+	}
+	delete(this.libraries, name)
+	this.changed++
+	return nil
+}
+
+func (this *localLibraries) Foreach(f func(name string, code []byte) bool) errors.Error {
+	this.RLock()
+	names := make([]string, 0, len(this.libraries))
+	for name, _ := range this.libraries {
+		names = append(names, name)
+	}
+	this.RUnlock()
+	sort.Strings(names)
+
+	for _, name := range names {
+		code, _ := this.Get(name)
+		if code != nil && !f(name, code) {
+			break
+		}
+	}
+	return nil
+}
+
+func (this *localLibraries) Changed() int32 {
+	this.RLock()
+	defer this.RUnlock()
+	return this.changed
+}
@@ -32,6 +32,7 @@ const _CHANGE_COUNTER = _CHANGE_COUNTER_PATH + "counter"
 var changeCounter int32
 
 func Init() {
+	initLibraries()
 
 	// setup the change counter if not there
 	err := metakv.Add(_CHANGE_COUNTER, fmtChangeCounter())
@@ -1,6 +1,7 @@
 package inferencer
 
 import (
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
@@ -21,8 +22,18 @@ import (
 
 var desc_debug = false
 
+//
+// the result is either the flavors in the INFER format, or a single JSON Schema document
+//
+
+const (
+	OUTPUT_INFER      = "infer"
+	OUTPUT_JSONSCHEMA = "jsonschema"
+)
+
 func DescribeKeyspace(context datastore.QueryContext, conn *datastore.ValueConnection, retriever DocumentRetriever,
-	similarityMetric float32, numSampleValues, dictionary_threshold, infer_timeout, max_schema_MB int32) (result value.Value, error_msg *string, warning_msg *string) {
+	similarityMetric float32, numSampleValues, dictionary_threshold, infer_timeout, max_schema_MB int32,
+	output string) (result value.Value, error_msg *string, warning_msg *string) {
 
 	result = nil
 	error_msg = nil
@@ -136,6 +147,18 @@ func DescribeKeyspace(context datastore.QueryContext, conn *datastore.ValueConne
 		}
 	}
 
+	if output == OUTPUT_JSONSCHEMA {
+		bytes, jerr := json.Marshal(flavors.JSONSchema())
+		if jerr != nil {
+			message := fmt.Sprintf("Error creating JSON Schema: %v", jerr)
+			result = value.NewValue(map[string]interface{}{"error": message})
+			error_msg = &message
+		} else {
+			result = value.NewValue(bytes)
+		}
+		return
+	}
+
 	//
 	// put out each flavor as JSON and return the result
 	//
@@ -191,6 +214,7 @@ func (di *DefaultInferencer) InferKeyspace(context datastore.QueryContext, ks da
 	dictionary_threshold := int32(10)
 	infer_timeout := int32(60) // don't spend more than 60 seconds on any bucket
 	max_schema_MB := int32(10) // if the schema is bigger than 10MB, don't return
+	output := OUTPUT_INFER
 
 	defer close(conn.ValueChannel())
 
@@ -208,7 +232,8 @@ func (di *DefaultInferencer) InferKeyspace(context datastore.QueryContext, ks da
 				!strings.EqualFold(fieldName, "similarity_metric") &&
 				!strings.EqualFold(fieldName, "num_sample_values") &&
 				!strings.EqualFold(fieldName, "max_schema_MB") &&
-				!strings.EqualFold(fieldName, "infer_timeout") {
+				!strings.EqualFold(fieldName, "infer_timeout") &&
+				!strings.EqualFold(fieldName, "output") {
 				unrecognizedNames = append(unrecognizedNames, fieldName)
 			}
 		}
@@ -313,6 +338,21 @@ func (di *DefaultInferencer) InferKeyspace(context datastore.QueryContext, ks da
 			max_schema_MB = int32(max_schema_MB_num)
 		}
 
+		//////////////////////////////////////////////////////////////////////
+		// output parameter - the INFER format, or JSON Schema
+		output_val, output_found := with.Field("output")
+		if output_found {
+			if output_val.Type() != value.STRING {
+				conn.Error(errors.NewWarning(fmt.Sprintf("'output' option must be a string, not %s", output_val.Type().String())))
+				return
+			}
+			output = strings.ToLower(output_val.ToString())
+			if output != OUTPUT_INFER && output != OUTPUT_JSONSCHEMA {
+				conn.Error(errors.NewWarning(fmt.Sprintf("'output' option must be '%s' or '%s', not '%s'", OUTPUT_INFER, OUTPUT_JSONSCHEMA, output_val.ToString())))
+				return
+			}
+		}
+
 	}
 
 	//
@@ -388,7 +428,7 @@ func (di *DefaultInferencer) InferKeyspace(context datastore.QueryContext, ks da
 	//
 	// get the
 
-	schema, error_msg, warning_msg := DescribeKeyspace(context, conn, retriever, float32(similarity_metric), num_sample_values, dictionary_threshold, infer_timeout, max_schema_MB)
+	schema, error_msg, warning_msg := DescribeKeyspace(context, conn, retriever, float32(similarity_metric), num_sample_values, dictionary_threshold, infer_timeout, max_schema_MB, output)
 
 	if error_msg != nil {
 		conn.Error(errors.NewWarning(*error_msg))
@@ -0,0 +1,230 @@
+package inferencer
+
+/*
+ * json_schema.go renders inferred flavors as a JSON Schema (draft 2020-12) document,
+ * for use by validators and code generators.
+ *
+ * The mapping is:
+ *  - a single flavor is the schema itself, several flavors become an "anyOf", since
+ *    objects are open and a document can match more than one flavor
+ *  - the flavor descriptor becomes the "description"
+ *  - fields become "properties"; a field seen in every document of its flavor (or of
+ *    its parent object) is "required"
+ *  - a field seen with several types gets a list of types, or an "anyOf" if any of the
+ *    types is structured
+ *  - sample values become "examples", array bounds "minItems" and "maxItems"
+ *  - dictionary fields become "additionalProperties"
+ *
+ * Binary values have no JSON Schema type, and are left unconstrained.
+ */
+
+import (
+	"sort"
+
+	"github.com/couchbase/query/value"
+)
+
+const JSON_SCHEMA_DIALECT = "https://json-schema.org/draft/2020-12/schema"
+
+func (flavors SchemaFlavors) JSONSchema() map[string]interface{} {
+	var r map[string]interface{}
+
+	if len(flavors) == 1 {
+		r = flavors[0].jsonSchema()
+	} else {
+		anyOf := make([]interface{}, len(flavors))
+		for idx, _ := range flavors {
+			anyOf[idx] = flavors[idx].jsonSchema()
+		}
+		r = map[string]interface{}{"anyOf": anyOf}
+	}
+	r["$schema"] = JSON_SCHEMA_DIALECT
+	return r
+}
+
+func (sf *SchemaFlavor) jsonSchema() map[string]interface{} {
+	// as for MarshalJSON, make sure the field frequencies are up to date
+	sf.schema.UpdateFieldFrequencies(sf.fieldFreq, "", sf.schema.matchingDocCount)
+
+	r := sf.schema.jsonSchema(sf.schema.matchingDocCount)
+	descriptor := sf.descriptor()
+	if descriptor != "" {
+		r["description"] = descriptor
+	}
+	return r
+}
+
+//
+// docCount is the number of documents the schema was inferred from, used to determine
+// which fields are required, or negative if the field frequencies are not known
+//
+
+func (s *Schema) jsonSchema(docCount int64) map[string]interface{} {
+	if len(s.fields) == 0 && s.bareValue != nil {
+		return s.bareValue.jsonSchema()
+	}
+
+	r := map[string]interface{}{"type": "object"}
+	properties := make(map[string]interface{}, len(s.fields))
+	required := make([]string, 0, len(s.fields))
+
+	for idx, _ := range s.fields {
+		field := &s.fields[idx]
+
+		// dictionaries have arbitrary keys, all with the same type of value
+		if field.isDictionary {
+			r["additionalProperties"] = field.jsonSchema()
+			continue
+		}
+
+		properties[field.Name] = field.jsonSchema()
+		if docCount > 0 && field.docCount() >= docCount {
+			required = append(required, field.Name)
+		}
+	}
+
+	if len(properties) > 0 {
+		r["properties"] = properties
+	}
+	if len(required) > 0 {
+		sort.Strings(required)
+		r["required"] = required
+	}
+	return r
+}
+
+// how many documents have the field, of any type, or -1 if unknown
+
+func (f *Field) docCount() int64 {
+	var count int64
+
+	for ; f != nil; f = f.namesake {
+		if f.numMatchingDocs == nil {
+			return -1
+		}
+		count += *f.numMatchingDocs
+	}
+	return count
+}
+
+func (f *Field) jsonSchema() map[string]interface{} {
+	schemas := make([]map[string]interface{}, 0, 1)
+
+	for ; f != nil; f = f.namesake {
+		docCount := int64(-1)
+		if f.numMatchingDocs != nil {
+			docCount = *f.numMatchingDocs
+		}
+		r := f.Kind.jsonSchema(docCount)
+
+		// samples are only useful for scalars
+		if len(f.sampleValues) > 0 && f.Kind.subtype == nil && f.Kind.arrtype == nil &&
+			f.Kind.Type != value.NULL {
+			var samples value.Values
+			if f.Kind.Type == value.STRING {
+				samples = f.TruncatedSampleValues()
+			} else {
+				samples = make(value.Values, len(f.sampleValues))
+				copy(samples, f.sampleValues)
+				sort.Slice(samples, func(i, j int) bool { return samples[i].Collate(samples[j]) < 0 })
+			}
+			r["examples"] = jsonSchemaExamples(samples)
+		}
+		schemas = append(schemas, r)
+	}
+
+	// namesakes are in no particular order
+	sort.Slice(schemas, func(i, j int) bool { return jsonSchemaKey(schemas[i]) < jsonSchemaKey(schemas[j]) })
+	return jsonSchemaAnyOf(schemas)
+}
+
+func (ft *FieldType) jsonSchema(docCount int64) map[string]interface{} {
+	if ft.subtype != nil {
+		return ft.subtype.jsonSchema(docCount)
+	}
+
+	r := map[string]interface{}{}
+	if ft.Type == value.BINARY || ft.Type == value.MISSING {
+		return r
+	}
+	r["type"] = ft.Type.String()
+
+	if ft.arrtype != nil {
+		r["minItems"] = ft.arrtype.minItems
+		r["maxItems"] = ft.arrtype.maxItems
+		items := ft.arrtype.jsonSchema()
+		if items != nil {
+			r["items"] = items
+		}
+	}
+	return r
+}
+
+// as for MarshalJSON, a single type is used as is, otherwise the merged types are listed
+
+func (at *ArrayType) jsonSchema() map[string]interface{} {
+	if len(at.typesSeen) == 1 {
+		for _, theType := range at.typesSeen {
+			return theType.jsonSchema(-1)
+		}
+	}
+
+	schemas := make([]map[string]interface{}, 0, len(at.nonObjectTypes)+len(at.mergedObjectTypes))
+	for _, name := range sortedNamesFT(at.nonObjectTypes) {
+		aType := at.nonObjectTypes[name]
+		schemas = append(schemas, aType.jsonSchema(-1))
+	}
+	for idx, _ := range at.mergedObjectTypes {
+		schemas = append(schemas, at.mergedObjectTypes[idx].jsonSchema())
+	}
+
+	if len(schemas) == 0 {
+		return nil
+	}
+	return jsonSchemaAnyOf(schemas)
+}
+
+//
+// alternative scalar types are folded into a single schema with a list of types,
+// otherwise the alternatives are kept as they are
+//
+
+func jsonSchemaAnyOf(schemas []map[string]interface{}) map[string]interface{} {
+	if len(schemas) == 1 {
+		return schemas[0]
+	}
+
+	types := make([]interface{}, 0, len(schemas))
+	examples := make([]interface{}, 0)
+	for _, schema := range schemas {
+		t, ok := schema["type"].(string)
+		if !ok || t == "object" || t == "array" {
+			return map[string]interface{}{"anyOf": schemas}
+		}
+		types = append(types, t)
+		if samples, ok := schema["examples"].([]interface{}); ok {
+			examples = append(examples, samples...)
+		}
+	}
+
+	r := map[string]interface{}{"type": types}
+	if len(examples) > 0 {
+		r["examples"] = examples
+	}
+	return r
+}
+
+// examples are kept as plain values, whatever the type of the samples
+
+func jsonSchemaExamples(samples value.Values) []interface{} {
+	examples := make([]interface{}, len(samples))
+	for idx, sample := range samples {
+		examples[idx] = sample.Actual()
+	}
+	return examples
+}
+
+func jsonSchemaKey(schema map[string]interface{}) string {
+	t, _ := schema["type"].(string)
+	return t
+}
@@ -0,0 +1,119 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package inferencer
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+// infers the documents, and returns the JSON Schema as plain JSON types
+func inferJSONSchema(t *testing.T, docs ...string) map[string]interface{} {
+	collection := make(SchemaCollection)
+	for _, doc := range docs {
+		collection.AddSchema(NewSchemaFromValue(value.NewValue([]byte(doc))), 5)
+	}
+	flavors := collection.GetFlavorsFromCollection(0.6, 5, 10)
+
+	bytes, err := json.Marshal(flavors.JSONSchema())
+	if err != nil {
+		t.Fatalf("Failed to marshal the JSON Schema: %v", err)
+	}
+	var r map[string]interface{}
+	if err = json.Unmarshal(bytes, &r); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", bytes, err)
+	}
+	if r["$schema"] != JSON_SCHEMA_DIALECT {
+		t.Errorf("Expected the %v dialect, got %v", JSON_SCHEMA_DIALECT, r["$schema"])
+	}
+	delete(r, "$schema")
+	return r
+}
+
+func checkJSONSchema(t *testing.T, what string, actual interface{}, expected string) {
+	var e interface{}
+	if err := json.Unmarshal([]byte(expected), &e); err != nil {
+		t.Fatalf("Invalid expected schema %v: %v", expected, err)
+	}
+	if !value.NewValue(e).EquivalentTo(value.NewValue(actual)) {
+		bytes, _ := json.Marshal(actual)
+		t.Errorf("%v: expected %v, got %s", what, expected, bytes)
+	}
+}
+
+func TestJSONSchemaFields(t *testing.T) {
+	r := inferJSONSchema(t,
+		`{"a": 1, "b": "x", "o": {"p": true}}`,
+		`{"a": 2, "b": "y", "c": null, "o": {"p": false, "q": 1}}`)
+
+	delete(r, "description")
+	checkJSONSchema(t, "fields", r, `{
+		"type": "object",
+		"required": ["a", "b", "o"],
+		"properties": {
+			"a": {"type": "number", "examples": [1, 2]},
+			"b": {"type": "string", "examples": ["x", "y"]},
+			"c": {"type": "null"},
+			"o": {
+				"type": "object",
+				"required": ["p"],
+				"properties": {
+					"p": {"type": "boolean", "examples": [false, true]},
+					"q": {"type": "number", "examples": [1]}
+				}
+			}
+		}
+	}`)
+}
+
+func TestJSONSchemaTypes(t *testing.T) {
+	r := inferJSONSchema(t,
+		`{"m": 1, "arr": [1, 2, 3]}`,
+		`{"m": "s", "arr": [4, "t"]}`)
+
+	properties, _ := r["properties"].(map[string]interface{})
+	if properties == nil {
+		t.Fatalf("Expected properties in %v", r)
+	}
+
+	// alternative scalar types are listed, with all their examples
+	checkJSONSchema(t, "scalar types", properties["m"], `{"type": ["number", "string"], "examples": [1, "s"]}`)
+
+	arr, _ := properties["arr"].(map[string]interface{})
+	if arr == nil {
+		t.Fatalf("Expected an array schema in %v", properties)
+	}
+	if arr["minItems"] != float64(2) || arr["maxItems"] != float64(3) {
+		t.Errorf("Expected between 2 and 3 items, got %v", arr)
+	}
+}
+
+// documents can match more than one flavor
+func TestJSONSchemaFlavors(t *testing.T) {
+	r := inferJSONSchema(t,
+		`{"x": 1, "y": 2, "z": 3}`,
+		`{"p": "a", "q": "b", "r": "c"}`)
+
+	if _, ok := r["oneOf"]; ok {
+		t.Errorf("Expected overlapping flavors not to be exclusive, got %v", r)
+	}
+	anyOf, _ := r["anyOf"].([]interface{})
+	if len(anyOf) != 2 {
+		t.Fatalf("Expected 2 flavors, got %v", r)
+	}
+	for _, f := range anyOf {
+		flavor := f.(map[string]interface{})
+		if flavor["type"] != "object" || flavor["description"] == nil || len(flavor["required"].([]interface{})) != 3 {
+			t.Errorf("Unexpected flavor %v", flavor)
+		}
+	}
+}
@@ -1822,7 +1822,12 @@ func (sf *SchemaFlavor) MarshalJSON() ([]byte, error) {
 
 	// get the map for use with json.Marshall
 	schemaMap := sf.schema.getSchemaMap()
+	schemaMap["Flavor"] = sf.descriptor()
 
+	return json.Marshal(schemaMap)
+}
+
+func (sf *SchemaFlavor) descriptor() string {
 	// flavors also need descriptors - a user-visible label for the flavor, showing any field
 	// with only a single value in the flavor, such as 'type = "brewery"'.
 	// we look for fields that are string or int, and have only a single value.
@@ -1866,9 +1871,7 @@ func (sf *SchemaFlavor) MarshalJSON() ([]byte, error) {
 		}
 	}
 
-	schemaMap["Flavor"] = descriptor
-
-	return json.Marshal(schemaMap)
+	return descriptor
 }
 
 //
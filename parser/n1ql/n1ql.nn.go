@@ -6958,6 +6958,115 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, nil},
 
+	// [cC][uU][bB][eE]
+	{[]bool{false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 66:
+				return -1
+			case 67:
+				return 1
+			case 69:
+				return -1
+			case 85:
+				return -1
+			case 98:
+				return -1
+			case 99:
+				return 1
+			case 101:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 66:
+				return -1
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 85:
+				return 2
+			case 98:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 117:
+				return 2
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 66:
+				return 3
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 85:
+				return -1
+			case 98:
+				return 3
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 66:
+				return -1
+			case 67:
+				return -1
+			case 69:
+				return 4
+			case 85:
+				return -1
+			case 98:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return 4
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 66:
+				return -1
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 85:
+				return -1
+			case 98:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1}, nil},
+
 	// [cC][uU][rR][rR][eE][nN][tT]
 	{[]bool{false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -7194,6 +7303,136 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1}, nil},
 
+	// [cC][yY][cC][lL][eE]
+	{[]bool{false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 67:
+				return 1
+			case 69:
+				return -1
+			case 76:
+				return -1
+			case 89:
+				return -1
+			case 99:
+				return 1
+			case 101:
+				return -1
+			case 108:
+				return -1
+			case 121:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 76:
+				return -1
+			case 89:
+				return 2
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 108:
+				return -1
+			case 121:
+				return 2
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return 3
+			case 69:
+				return -1
+			case 76:
+				return -1
+			case 89:
+				return -1
+			case 99:
+				return 3
+			case 101:
+				return -1
+			case 108:
+				return -1
+			case 121:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 76:
+				return 4
+			case 89:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 108:
+				return 4
+			case 121:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return 5
+			case 76:
+				return -1
+			case 89:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return 5
+			case 108:
+				return -1
+			case 121:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 76:
+				return -1
+			case 89:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 108:
+				return -1
+			case 121:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1}, nil},
+
 	// [dD][aA][tT][aA][bB][aA][sS][eE]
 	{[]bool{false, false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -14007,32 +14246,36 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1}, nil},
 
-	// [gG][rR][oO][uU][pP][sS]
-	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+	// [gG][rR][oO][uU][pP][iI][nN][gG]
+	{[]bool{false, false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
 			switch r {
 			case 71:
 				return 1
+			case 73:
+				return -1
+			case 78:
+				return -1
 			case 79:
 				return -1
 			case 80:
 				return -1
 			case 82:
 				return -1
-			case 83:
-				return -1
 			case 85:
 				return -1
 			case 103:
 				return 1
+			case 105:
+				return -1
+			case 110:
+				return -1
 			case 111:
 				return -1
 			case 112:
 				return -1
 			case 114:
 				return -1
-			case 115:
-				return -1
 			case 117:
 				return -1
 			}
@@ -14042,26 +14285,30 @@ var dfas = []dfa{
 			switch r {
 			case 71:
 				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
 			case 79:
 				return -1
 			case 80:
 				return -1
 			case 82:
 				return 2
-			case 83:
-				return -1
 			case 85:
 				return -1
 			case 103:
 				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
 			case 111:
 				return -1
 			case 112:
 				return -1
 			case 114:
 				return 2
-			case 115:
-				return -1
 			case 117:
 				return -1
 			}
@@ -14071,26 +14318,30 @@ var dfas = []dfa{
 			switch r {
 			case 71:
 				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
 			case 79:
 				return 3
 			case 80:
 				return -1
 			case 82:
 				return -1
-			case 83:
-				return -1
 			case 85:
 				return -1
 			case 103:
 				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
 			case 111:
 				return 3
 			case 112:
 				return -1
 			case 114:
 				return -1
-			case 115:
-				return -1
 			case 117:
 				return -1
 			}
@@ -14100,26 +14351,30 @@ var dfas = []dfa{
 			switch r {
 			case 71:
 				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
 			case 79:
 				return -1
 			case 80:
 				return -1
 			case 82:
 				return -1
-			case 83:
-				return -1
 			case 85:
 				return 4
 			case 103:
 				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
 			case 111:
 				return -1
 			case 112:
 				return -1
 			case 114:
 				return -1
-			case 115:
-				return -1
 			case 117:
 				return 4
 			}
@@ -14129,26 +14384,30 @@ var dfas = []dfa{
 			switch r {
 			case 71:
 				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
 			case 79:
 				return -1
 			case 80:
 				return 5
 			case 82:
 				return -1
-			case 83:
-				return -1
 			case 85:
 				return -1
 			case 103:
 				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
 			case 111:
 				return -1
 			case 112:
 				return 5
 			case 114:
 				return -1
-			case 115:
-				return -1
 			case 117:
 				return -1
 			}
@@ -14158,26 +14417,30 @@ var dfas = []dfa{
 			switch r {
 			case 71:
 				return -1
+			case 73:
+				return 6
+			case 78:
+				return -1
 			case 79:
 				return -1
 			case 80:
 				return -1
 			case 82:
 				return -1
-			case 83:
-				return 6
 			case 85:
 				return -1
 			case 103:
 				return -1
+			case 105:
+				return 6
+			case 110:
+				return -1
 			case 111:
 				return -1
 			case 112:
 				return -1
 			case 114:
 				return -1
-			case 115:
-				return 6
 			case 117:
 				return -1
 			}
@@ -14187,66 +14450,65 @@ var dfas = []dfa{
 			switch r {
 			case 71:
 				return -1
+			case 73:
+				return -1
+			case 78:
+				return 7
 			case 79:
 				return -1
 			case 80:
 				return -1
 			case 82:
 				return -1
-			case 83:
-				return -1
 			case 85:
 				return -1
 			case 103:
 				return -1
+			case 105:
+				return -1
+			case 110:
+				return 7
 			case 111:
 				return -1
 			case 112:
 				return -1
 			case 114:
 				return -1
-			case 115:
-				return -1
 			case 117:
 				return -1
 			}
 			return -1
 		},
-	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, nil},
-
-	// [gG][sS][iI]
-	{[]bool{false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
 			switch r {
 			case 71:
-				return 1
+				return 8
 			case 73:
 				return -1
-			case 83:
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 85:
 				return -1
 			case 103:
-				return 1
+				return 8
 			case 105:
 				return -1
-			case 115:
+			case 110:
 				return -1
-			}
-			return -1
-		},
-		func(r rune) int {
-			switch r {
-			case 71:
+			case 111:
 				return -1
-			case 73:
+			case 112:
 				return -1
-			case 83:
-				return 2
-			case 103:
+			case 114:
 				return -1
-			case 105:
+			case 117:
 				return -1
-			case 115:
-				return 2
 			}
 			return -1
 		},
@@ -14255,153 +14517,431 @@ var dfas = []dfa{
 			case 71:
 				return -1
 			case 73:
-				return 3
-			case 83:
 				return -1
-			case 103:
+			case 78:
 				return -1
-			case 105:
-				return 3
-			case 115:
+			case 79:
 				return -1
-			}
-			return -1
-		},
-		func(r rune) int {
-			switch r {
-			case 71:
+			case 80:
 				return -1
-			case 73:
+			case 82:
 				return -1
-			case 83:
+			case 85:
 				return -1
 			case 103:
 				return -1
 			case 105:
 				return -1
-			case 115:
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 117:
 				return -1
 			}
 			return -1
 		},
-	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1}, nil},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
 
-	// [hH][aA][sS][hH]
-	{[]bool{false, false, false, false, true}, []func(rune) int{ // Transitions
+	// [gG][rR][oO][uU][pP][sS]
+	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
 			switch r {
-			case 65:
-				return -1
-			case 72:
+			case 71:
 				return 1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
 			case 83:
 				return -1
-			case 97:
+			case 85:
 				return -1
-			case 104:
+			case 103:
 				return 1
-			case 115:
-				return -1
-			}
-			return -1
-		},
-		func(r rune) int {
-			switch r {
-			case 65:
-				return 2
-			case 72:
+			case 111:
 				return -1
-			case 83:
+			case 112:
 				return -1
-			case 97:
-				return 2
-			case 104:
+			case 114:
 				return -1
 			case 115:
 				return -1
+			case 117:
+				return -1
 			}
 			return -1
 		},
 		func(r rune) int {
 			switch r {
-			case 65:
+			case 71:
 				return -1
-			case 72:
+			case 79:
+				return -1
+			case 80:
 				return -1
+			case 82:
+				return 2
 			case 83:
-				return 3
-			case 97:
 				return -1
-			case 104:
+			case 85:
 				return -1
-			case 115:
-				return 3
-			}
-			return -1
-		},
-		func(r rune) int {
-			switch r {
-			case 65:
+			case 103:
 				return -1
-			case 72:
-				return 4
-			case 83:
+			case 111:
 				return -1
-			case 97:
+			case 112:
 				return -1
-			case 104:
-				return 4
+			case 114:
+				return 2
 			case 115:
 				return -1
+			case 117:
+				return -1
 			}
 			return -1
 		},
 		func(r rune) int {
 			switch r {
-			case 65:
+			case 71:
 				return -1
-			case 72:
+			case 79:
+				return 3
+			case 80:
+				return -1
+			case 82:
 				return -1
 			case 83:
 				return -1
-			case 97:
+			case 85:
 				return -1
-			case 104:
+			case 103:
+				return -1
+			case 111:
+				return 3
+			case 112:
+				return -1
+			case 114:
 				return -1
 			case 115:
 				return -1
+			case 117:
+				return -1
 			}
 			return -1
 		},
-	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1}, nil},
-
-	// [hH][aA][vV][iI][nN][gG]
-	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
 			switch r {
-			case 65:
-				return -1
 			case 71:
 				return -1
-			case 72:
-				return 1
-			case 73:
+			case 79:
 				return -1
-			case 78:
+			case 80:
 				return -1
-			case 86:
+			case 82:
 				return -1
-			case 97:
+			case 83:
 				return -1
+			case 85:
+				return 4
 			case 103:
 				return -1
-			case 104:
-				return 1
-			case 105:
+			case 111:
 				return -1
-			case 110:
+			case 112:
 				return -1
-			case 118:
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return 4
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 71:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return 5
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 103:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return 5
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 71:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return 6
+			case 85:
+				return -1
+			case 103:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return 6
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 71:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 103:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, nil},
+
+	// [gG][sS][iI]
+	{[]bool{false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 71:
+				return 1
+			case 73:
+				return -1
+			case 83:
+				return -1
+			case 103:
+				return 1
+			case 105:
+				return -1
+			case 115:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 71:
+				return -1
+			case 73:
+				return -1
+			case 83:
+				return 2
+			case 103:
+				return -1
+			case 105:
+				return -1
+			case 115:
+				return 2
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 71:
+				return -1
+			case 73:
+				return 3
+			case 83:
+				return -1
+			case 103:
+				return -1
+			case 105:
+				return 3
+			case 115:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 71:
+				return -1
+			case 73:
+				return -1
+			case 83:
+				return -1
+			case 103:
+				return -1
+			case 105:
+				return -1
+			case 115:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1}, nil},
+
+	// [hH][aA][sS][hH]
+	{[]bool{false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 72:
+				return 1
+			case 83:
+				return -1
+			case 97:
+				return -1
+			case 104:
+				return 1
+			case 115:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return 2
+			case 72:
+				return -1
+			case 83:
+				return -1
+			case 97:
+				return 2
+			case 104:
+				return -1
+			case 115:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 72:
+				return -1
+			case 83:
+				return 3
+			case 97:
+				return -1
+			case 104:
+				return -1
+			case 115:
+				return 3
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 72:
+				return 4
+			case 83:
+				return -1
+			case 97:
+				return -1
+			case 104:
+				return 4
+			case 115:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 72:
+				return -1
+			case 83:
+				return -1
+			case 97:
+				return -1
+			case 104:
+				return -1
+			case 115:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1}, nil},
+
+	// [hH][aA][vV][iI][nN][gG]
+	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return 1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return 1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 118:
 				return -1
 			}
 			return -1
@@ -16737,6 +17277,343 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
 
+	// [iI][nN][tT][eE][rR][vV][aA][lL]
+	{[]bool{false, false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return 1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return 1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return 2
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return 2
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return 3
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return 3
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return 4
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return 4
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return 5
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return 5
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return 6
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return 6
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return 7
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return 7
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return 8
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return 8
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 65:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 76:
+				return -1
+			case 78:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 86:
+				return -1
+			case 97:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 108:
+				return -1
+			case 110:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
+
 	// [iI][nN][tT][oO]
 	{[]bool{false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -27503,6 +28380,340 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1}, nil},
 
+	// [rR][eE][cC][uU][rR][sS][iI][vV][eE]
+	{[]bool{false, false, false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return 1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return 1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return 2
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return 2
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return 3
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return 3
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return 4
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return 4
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return 5
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return 5
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return 6
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return 6
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return 7
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return 7
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return 8
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return 8
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return 9
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return 9
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 85:
+				return -1
+			case 86:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 117:
+				return -1
+			case 118:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
+
 	// [rR][eE][dD][uU][cC][eE]
 	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -28333,6 +29544,271 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1}, nil},
 
+	// [rR][eE][sS][tT][rR][iI][cC][tT]
+	{[]bool{false, false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return 1
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return 1
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return 2
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return 2
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return 3
+			case 84:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return 3
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return 4
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return 4
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return 5
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return 5
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return 6
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return 6
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return 7
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 99:
+				return 7
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return 8
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return 8
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 67:
+				return -1
+			case 69:
+				return -1
+			case 73:
+				return -1
+			case 82:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 99:
+				return -1
+			case 101:
+				return -1
+			case 105:
+				return -1
+			case 114:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
+
 	// [rR][eE][tT][uU][rR][nN]
 	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -29589,6 +31065,185 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
 
+	// [rR][oO][lL][lL][uU][pP]
+	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 76:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return 1
+			case 85:
+				return -1
+			case 108:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return 1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 76:
+				return -1
+			case 79:
+				return 2
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 85:
+				return -1
+			case 108:
+				return -1
+			case 111:
+				return 2
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 76:
+				return 3
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 85:
+				return -1
+			case 108:
+				return 3
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 76:
+				return 4
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 85:
+				return -1
+			case 108:
+				return 4
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 76:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 85:
+				return 5
+			case 108:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 117:
+				return 5
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 76:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return 6
+			case 82:
+				return -1
+			case 85:
+				return -1
+			case 108:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return 6
+			case 114:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 76:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 85:
+				return -1
+			case 108:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 117:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1}, nil},
+
 	// [rR][oO][wW]
 	{[]bool{false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -31199,6 +32854,95 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1}, nil},
 
+	// [sS][eE][tT][sS]
+	{[]bool{false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 69:
+				return -1
+			case 83:
+				return 1
+			case 84:
+				return -1
+			case 101:
+				return -1
+			case 115:
+				return 1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 69:
+				return 2
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 101:
+				return 2
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 69:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return 3
+			case 101:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return 3
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 69:
+				return -1
+			case 83:
+				return 4
+			case 84:
+				return -1
+			case 101:
+				return -1
+			case 115:
+				return 4
+			case 116:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 69:
+				return -1
+			case 83:
+				return -1
+			case 84:
+				return -1
+			case 101:
+				return -1
+			case 115:
+				return -1
+			case 116:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1}, nil},
+
 	// [sS][hH][oO][wW]
 	{[]bool{false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -37418,6 +39162,808 @@ var dfas = []dfa{
 		},
 	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1}, nil},
 
+	// [wW][iI][tT][hH][iI][nN][ \t\n\r\f]+[gG][rR][oO][uU][pP][ \t\n\r\f]*\(
+	{[]bool{false, false, false, false, false, false, false, false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return 1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return 1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return 2
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return 2
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return 3
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return 3
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return 4
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return 4
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return 5
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return 5
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return 6
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return 6
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return 7
+			case 10:
+				return 7
+			case 12:
+				return 7
+			case 13:
+				return 7
+			case 32:
+				return 7
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return 7
+			case 10:
+				return 7
+			case 12:
+				return 7
+			case 13:
+				return 7
+			case 32:
+				return 7
+			case 40:
+				return -1
+			case 71:
+				return 8
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return 8
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return 9
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return 9
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return 10
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return 10
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return 11
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return 11
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return 12
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return 12
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return 12
+			case 10:
+				return 12
+			case 12:
+				return 12
+			case 13:
+				return 12
+			case 32:
+				return 12
+			case 40:
+				return 13
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+		func(r rune) int {
+			switch r {
+			case 9:
+				return -1
+			case 10:
+				return -1
+			case 12:
+				return -1
+			case 13:
+				return -1
+			case 32:
+				return -1
+			case 40:
+				return -1
+			case 71:
+				return -1
+			case 72:
+				return -1
+			case 73:
+				return -1
+			case 78:
+				return -1
+			case 79:
+				return -1
+			case 80:
+				return -1
+			case 82:
+				return -1
+			case 84:
+				return -1
+			case 85:
+				return -1
+			case 87:
+				return -1
+			case 103:
+				return -1
+			case 104:
+				return -1
+			case 105:
+				return -1
+			case 110:
+				return -1
+			case 111:
+				return -1
+			case 112:
+				return -1
+			case 114:
+				return -1
+			case 116:
+				return -1
+			case 117:
+				return -1
+			case 119:
+				return -1
+			}
+			return -1
+		},
+	}, []int{ /* Start-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}, []int{ /* End-of-input transitions */ -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1, -1}, nil},
+
 	// [wW][iI][tT][hH][iI][nN]
 	{[]bool{false, false, false, false, false, false, true}, []func(rune) int{ // Transitions
 		func(r rune) int {
@@ -38482,912 +41028,965 @@ OUTER0:
 				return CREATE
 			}
 		case 67:
+			{
+				yylex.logToken(yylex.Text(), "CUBE")
+				lval.s = yylex.Text()
+				return CUBE
+			}
+		case 68:
 			{
 				yylex.logToken(yylex.Text(), "CURRENT")
 				return CURRENT
 			}
-		case 68:
+		case 69:
+			{
+				yylex.logToken(yylex.Text(), "CYCLE")
+				lval.s = yylex.Text()
+				return CYCLE
+			}
+		case 70:
 			{
 				yylex.logToken(yylex.Text(), "DATABASE")
 				return DATABASE
 			}
-		case 69:
+		case 71:
 			{
 				yylex.logToken(yylex.Text(), "DATASET")
 				return DATASET
 			}
-		case 70:
+		case 72:
 			{
 				yylex.logToken(yylex.Text(), "DATASTORE")
 				return DATASTORE
 			}
-		case 71:
+		case 73:
 			{
 				yylex.logToken(yylex.Text(), "DECLARE")
 				return DECLARE
 			}
-		case 72:
+		case 74:
 			{
 				yylex.logToken(yylex.Text(), "DECREMENT")
 				return DECREMENT
 			}
-		case 73:
+		case 75:
 			{
 				yylex.logToken(yylex.Text(), "DELETE")
 				return DELETE
 			}
-		case 74:
+		case 76:
 			{
 				yylex.logToken(yylex.Text(), "DERIVED")
 				return DERIVED
 			}
-		case 75:
+		case 77:
 			{
 				yylex.logToken(yylex.Text(), "DESC")
 				return DESC
 			}
-		case 76:
+		case 78:
 			{
 				yylex.logToken(yylex.Text(), "DESCRIBE")
 				return DESCRIBE
 			}
-		case 77:
+		case 79:
 			{
 				yylex.logToken(yylex.Text(), "DISTINCT")
 				return DISTINCT
 			}
-		case 78:
+		case 80:
 			{
 				yylex.logToken(yylex.Text(), "DO")
 				return DO
 			}
-		case 79:
+		case 81:
 			{
 				yylex.logToken(yylex.Text(), "DROP")
 				return DROP
 			}
-		case 80:
+		case 82:
 			{
 				yylex.logToken(yylex.Text(), "EACH")
 				return EACH
 			}
-		case 81:
+		case 83:
 			{
 				yylex.logToken(yylex.Text(), "ELEMENT")
 				return ELEMENT
 			}
-		case 82:
+		case 84:
 			{
 				yylex.logToken(yylex.Text(), "ELSE")
 				return ELSE
 			}
-		case 83:
+		case 85:
 			{
 				yylex.logToken(yylex.Text(), "END")
 				return END
 			}
-		case 84:
+		case 86:
 			{
 				yylex.logToken(yylex.Text(), "EVERY")
 				return EVERY
 			}
-		case 85:
+		case 87:
 			{
 				yylex.logToken(yylex.Text(), "EXCEPT")
 				return EXCEPT
 			}
-		case 86:
+		case 88:
 			{
 				yylex.logToken(yylex.Text(), "EXCLUDE")
 				return EXCLUDE
 			}
-		case 87:
+		case 89:
 			{
 				yylex.logToken(yylex.Text(), "EXECUTE")
 				return EXECUTE
 			}
-		case 88:
+		case 90:
 			{
 				yylex.logToken(yylex.Text(), "EXISTS")
 				return EXISTS
 			}
-		case 89:
+		case 91:
 			{
 				yylex.logToken(yylex.Text(), "EXPLAIN")
 				lval.tokOffset = yylex.curOffset
 				return EXPLAIN
 			}
-		case 90:
+		case 92:
 			{
 				yylex.logToken(yylex.Text(), "FALSE")
 				return FALSE
 			}
-		case 91:
+		case 93:
 			{
 				yylex.logToken(yylex.Text(), "FETCH")
 				return FETCH
 			}
-		case 92:
+		case 94:
 			{
 				yylex.logToken(yylex.Text(), "FILTER")
 				return FILTER
 			}
-		case 93:
+		case 95:
 			{
 				yylex.logToken(yylex.Text(), "FIRST")
 				return FIRST
 			}
-		case 94:
+		case 96:
 			{
 				yylex.logToken(yylex.Text(), "FLATTEN")
 				return FLATTEN
 			}
-		case 95:
+		case 97:
 			{
 				yylex.logToken(yylex.Text(), "FLUSH")
 				return FLUSH
 			}
-		case 96:
+		case 98:
 			{
 				yylex.logToken(yylex.Text(), "FOLLOWING")
 				return FOLLOWING
 			}
-		case 97:
+		case 99:
 			{
 				yylex.logToken(yylex.Text(), "FOR")
 				return FOR
 			}
-		case 98:
+		case 100:
 			{
 				yylex.logToken(yylex.Text(), "FORCE")
 				lval.tokOffset = yylex.curOffset
 				return FORCE
 			}
-		case 99:
+		case 101:
 			{
 				yylex.logToken(yylex.Text(), "FROM")
 				lval.tokOffset = yylex.curOffset
 				return FROM
 			}
-		case 100:
+		case 102:
 			{
 				yylex.logToken(yylex.Text(), "FTS")
 				return FTS
 			}
-		case 101:
+		case 103:
 			{
 				yylex.logToken(yylex.Text(), "FUNCTION")
 				return FUNCTION
 			}
-		case 102:
+		case 104:
 			{
 				yylex.logToken(yylex.Text(), "GOLANG")
 				return GOLANG
 			}
-		case 103:
+		case 105:
 			{
 				yylex.logToken(yylex.Text(), "GRANT")
 				return GRANT
 			}
-		case 104:
+		case 106:
 			{
 				yylex.logToken(yylex.Text(), "GROUP")
 				return GROUP
 			}
-		case 105:
+		case 107:
+			{
+				yylex.logToken(yylex.Text(), "GROUPING")
+				lval.s = yylex.Text()
+				return GROUPING
+			}
+		case 108:
 			{
 				yylex.logToken(yylex.Text(), "GROUPS")
 				return GROUPS
 			}
-		case 106:
+		case 109:
 			{
 				yylex.logToken(yylex.Text(), "GSI")
 				return GSI
 			}
-		case 107:
+		case 110:
 			{
 				yylex.logToken(yylex.Text(), "HASH")
 				return HASH
 			}
-		case 108:
+		case 111:
 			{
 				yylex.logToken(yylex.Text(), "HAVING")
 				return HAVING
 			}
-		case 109:
+		case 112:
 			{
 				yylex.logToken(yylex.Text(), "IF")
 				return IF
 			}
-		case 110:
+		case 113:
 			{
 				yylex.logToken(yylex.Text(), "IGNORE")
 				return IGNORE
 			}
-		case 111:
+		case 114:
 			{
 				yylex.logToken(yylex.Text(), "ILIKE")
 				return ILIKE
 			}
-		case 112:
+		case 115:
 			{
 				yylex.logToken(yylex.Text(), "IN")
 				return IN
 			}
-		case 113:
+		case 116:
 			{
 				yylex.logToken(yylex.Text(), "INCLUDE")
 				return INCLUDE
 			}
-		case 114:
+		case 117:
 			{
 				yylex.logToken(yylex.Text(), "INCREMENT")
 				return INCREMENT
 			}
-		case 115:
+		case 118:
 			{
 				yylex.logToken(yylex.Text(), "INDEX")
 				return INDEX
 			}
-		case 116:
+		case 119:
 			{
 				yylex.logToken(yylex.Text(), "INFER")
 				return INFER
 			}
-		case 117:
+		case 120:
 			{
 				yylex.logToken(yylex.Text(), "INLINE")
 				return INLINE
 			}
-		case 118:
+		case 121:
 			{
 				yylex.logToken(yylex.Text(), "INNER")
 				return INNER
 			}
-		case 119:
+		case 122:
 			{
 				yylex.logToken(yylex.Text(), "INSERT")
 				return INSERT
 			}
-		case 120:
+		case 123:
 			{
 				yylex.logToken(yylex.Text(), "INTERSECT")
 				return INTERSECT
 			}
-		case 121:
+		case 124:
+			{
+				yylex.logToken(yylex.Text(), "INTERVAL")
+				lval.s = yylex.Text()
+				return INTERVAL
+			}
+		case 125:
 			{
 				yylex.logToken(yylex.Text(), "INTO")
 				return INTO
 			}
-		case 122:
+		case 126:
 			{
 				yylex.logToken(yylex.Text(), "IS")
 				return IS
 			}
-		case 123:
+		case 127:
 			{
 				yylex.logToken(yylex.Text(), "ISOLATION")
 				return ISOLATION
 			}
-		case 124:
+		case 128:
 			{
 				yylex.logToken(yylex.Text(), "JAVASCRIPT")
 				return JAVASCRIPT
 			}
-		case 125:
+		case 129:
 			{
 				yylex.logToken(yylex.Text(), "JOIN")
 				return JOIN
 			}
-		case 126:
+		case 130:
 			{
 				yylex.logToken(yylex.Text(), "KEY")
 				return KEY
 			}
-		case 127:
+		case 131:
 			{
 				yylex.logToken(yylex.Text(), "KEYS")
 				return KEYS
 			}
-		case 128:
+		case 132:
 			{
 				yylex.logToken(yylex.Text(), "KEYSPACE")
 				return KEYSPACE
 			}
-		case 129:
+		case 133:
 			{
 				yylex.logToken(yylex.Text(), "KNOWN")
 				return KNOWN
 			}
-		case 130:
+		case 134:
 			{
 				yylex.logToken(yylex.Text(), "LANGUAGE")
 				return LANGUAGE
 			}
-		case 131:
+		case 135:
 			{
 				yylex.logToken(yylex.Text(), "LAST")
 				return LAST
 			}
-		case 132:
+		case 136:
 			{
 				yylex.logToken(yylex.Text(), "LEFT")
 				return LEFT
 			}
-		case 133:
+		case 137:
 			{
 				yylex.logToken(yylex.Text(), "LET")
 				return LET
 			}
-		case 134:
+		case 138:
 			{
 				yylex.logToken(yylex.Text(), "LETTING")
 				return LETTING
 			}
-		case 135:
+		case 139:
 			{
 				yylex.logToken(yylex.Text(), "LEVEL")
 				return LEVEL
 			}
-		case 136:
+		case 140:
 			{
 				yylex.logToken(yylex.Text(), "LIKE")
 				return LIKE
 			}
-		case 137:
+		case 141:
 			{
 				yylex.logToken(yylex.Text(), "LIMIT")
 				return LIMIT
 			}
-		case 138:
+		case 142:
 			{
 				yylex.logToken(yylex.Text(), "LSM")
 				return LSM
 			}
-		case 139:
+		case 143:
 			{
 				yylex.logToken(yylex.Text(), "MAP")
 				return MAP
 			}
-		case 140:
+		case 144:
 			{
 				yylex.logToken(yylex.Text(), "MAPPING")
 				return MAPPING
 			}
-		case 141:
+		case 145:
 			{
 				yylex.logToken(yylex.Text(), "MATCHED")
 				return MATCHED
 			}
-		case 142:
+		case 146:
 			{
 				yylex.logToken(yylex.Text(), "MATERIALIZED")
 				return MATERIALIZED
 			}
-		case 143:
+		case 147:
 			{
 				yylex.logToken(yylex.Text(), "MERGE")
 				return MERGE
 			}
-		case 144:
+		case 148:
 			{
 				yylex.logToken(yylex.Text(), "MISSING")
 				return MISSING
 			}
-		case 145:
+		case 149:
 			{
 				yylex.logToken(yylex.Text(), "NAMESPACE")
 				return NAMESPACE
 			}
-		case 146:
+		case 150:
 			{
 				yylex.logToken(yylex.Text(), "NEST")
 				return NEST
 			}
-		case 147:
+		case 151:
 			{
 				yylex.logToken(yylex.Text(), "NL")
 				return NL
 			}
-		case 148:
+		case 152:
 			{
 				yylex.logToken(yylex.Text(), "NO")
 				return NO
 			}
-		case 149:
+		case 153:
 			{
 				yylex.logToken(yylex.Text(), "NOT")
 				return NOT
 			}
-		case 150:
+		case 154:
 			{
 				yylex.logToken(yylex.Text(), "NTH_VALUE")
 				return NTH_VALUE
 			}
-		case 151:
+		case 155:
 			{
 				yylex.logToken(yylex.Text(), "NULL")
 				return NULL
 			}
-		case 152:
+		case 156:
 			{
 				yylex.logToken(yylex.Text(), "NULLS")
 				return NULLS
 			}
-		case 153:
+		case 157:
 			{
 				yylex.logToken(yylex.Text(), "NUMBER")
 				return NUMBER
 			}
-		case 154:
+		case 158:
 			{
 				yylex.logToken(yylex.Text(), "OBJECT")
 				return OBJECT
 			}
-		case 155:
+		case 159:
 			{
 				yylex.logToken(yylex.Text(), "OFFSET")
 				return OFFSET
 			}
-		case 156:
+		case 160:
 			{
 				yylex.logToken(yylex.Text(), "ON")
 				return ON
 			}
-		case 157:
+		case 161:
 			{
 				yylex.logToken(yylex.Text(), "OPTION")
 				return OPTION
 			}
-		case 158:
+		case 162:
 			{
 				yylex.logToken(yylex.Text(), "OPTIONS")
 				return OPTIONS
 			}
-		case 159:
+		case 163:
 			{
 				yylex.logToken(yylex.Text(), "OR")
 				return OR
 			}
-		case 160:
+		case 164:
 			{
 				yylex.logToken(yylex.Text(), "ORDER")
 				return ORDER
 			}
-		case 161:
+		case 165:
 			{
 				yylex.logToken(yylex.Text(), "OTHERS")
 				return OTHERS
 			}
-		case 162:
+		case 166:
 			{
 				yylex.logToken(yylex.Text(), "OUTER")
 				return OUTER
 			}
-		case 163:
+		case 167:
 			{
 				yylex.logToken(yylex.Text(), "OVER")
 				return OVER
 			}
-		case 164:
+		case 168:
 			{
 				yylex.logToken(yylex.Text(), "PARSE")
 				return PARSE
 			}
-		case 165:
+		case 169:
 			{
 				yylex.logToken(yylex.Text(), "PARTITION")
 				return PARTITION
 			}
-		case 166:
+		case 170:
 			{
 				yylex.logToken(yylex.Text(), "PASSWORD")
 				return PASSWORD
 			}
-		case 167:
+		case 171:
 			{
 				yylex.logToken(yylex.Text(), "PATH")
 				return PATH
 			}
-		case 168:
+		case 172:
 			{
 				yylex.logToken(yylex.Text(), "POOL")
 				return POOL
 			}
-		case 169:
+		case 173:
 			{
 				yylex.logToken(yylex.Text(), "PRECEDING")
 				return PRECEDING
 			}
-		case 170:
+		case 174:
 			{
 				yylex.logToken(yylex.Text(), "PREPARE")
 				lval.tokOffset = yylex.curOffset
 				return PREPARE
 			}
-		case 171:
+		case 175:
 			{
 				yylex.logToken(yylex.Text(), "PRIMARY")
 				return PRIMARY
 			}
-		case 172:
+		case 176:
 			{
 				yylex.logToken(yylex.Text(), "PRIVATE")
 				return PRIVATE
 			}
-		case 173:
+		case 177:
 			{
 				yylex.logToken(yylex.Text(), "PRIVILEGE")
 				return PRIVILEGE
 			}
-		case 174:
+		case 178:
 			{
 				yylex.logToken(yylex.Text(), "PROCEDURE")
 				return PROCEDURE
 			}
-		case 175:
+		case 179:
 			{
 				yylex.logToken(yylex.Text(), "PROBE")
 				return PROBE
 			}
-		case 176:
+		case 180:
 			{
 				yylex.logToken(yylex.Text(), "PUBLIC")
 				return PUBLIC
 			}
-		case 177:
+		case 181:
 			{
 				yylex.logToken(yylex.Text(), "RANGE")
 				return RANGE
 			}
-		case 178:
+		case 182:
 			{
 				yylex.logToken(yylex.Text(), "RAW")
 				return RAW
 			}
-		case 179:
+		case 183:
 			{
 				yylex.logToken(yylex.Text(), "READ")
 				return READ
 			}
-		case 180:
+		case 184:
 			{
 				yylex.logToken(yylex.Text(), "REALM")
 				return REALM
 			}
-		case 181:
+		case 185:
+			{
+				yylex.logToken(yylex.Text(), "RECURSIVE")
+				lval.s = yylex.Text()
+				return RECURSIVE
+			}
+		case 186:
 			{
 				yylex.logToken(yylex.Text(), "REDUCE")
 				return REDUCE
 			}
-		case 182:
+		case 187:
 			{
 				yylex.logToken(yylex.Text(), "RENAME")
 				return RENAME
 			}
-		case 183:
+		case 188:
 			{
 				yylex.logToken(yylex.Text(), "REPLACE")
 				lval.s = yylex.Text()
 				return REPLACE
 			}
-		case 184:
+		case 189:
 			{
 				yylex.logToken(yylex.Text(), "RESPECT")
 				return RESPECT
 			}
-		case 185:
+		case 190:
+			{
+				yylex.logToken(yylex.Text(), "RESTRICT")
+				lval.s = yylex.Text()
+				return RESTRICT
+			}
+		case 191:
 			{
 				yylex.logToken(yylex.Text(), "RETURN")
 				return RETURN
 			}
-		case 186:
+		case 192:
 			{
 				yylex.logToken(yylex.Text(), "RETURNING")
 				return RETURNING
 			}
-		case 187:
+		case 193:
 			{
 				yylex.logToken(yylex.Text(), "REVOKE")
 				return REVOKE
 			}
-		case 188:
+		case 194:
 			{
 				yylex.logToken(yylex.Text(), "RIGHT")
 				return RIGHT
 			}
-		case 189:
+		case 195:
 			{
 				yylex.logToken(yylex.Text(), "ROLE")
 				return ROLE
 			}
-		case 190:
+		case 196:
 			{
 				yylex.logToken(yylex.Text(), "ROLLBACK")
 				return ROLLBACK
 			}
-		case 191:
+		case 197:
+			{
+				yylex.logToken(yylex.Text(), "ROLLUP")
+				lval.s = yylex.Text()
+				return ROLLUP
+			}
+		case 198:
 			{
 				yylex.logToken(yylex.Text(), "ROW")
 				return ROW
 			}
-		case 192:
+		case 199:
 			{
 				yylex.logToken(yylex.Text(), "ROWS")
 				return ROWS
 			}
-		case 193:
+		case 200:
 			{
 				yylex.logToken(yylex.Text(), "SATISFIES")
 				return SATISFIES
 			}
-		case 194:
+		case 201:
 			{
 				yylex.logToken(yylex.Text(), "SAVEPOINT")
 				return SAVEPOINT
 			}
-		case 195:
+		case 202:
 			{
 				yylex.logToken(yylex.Text(), "SCHEMA")
 				return SCHEMA
 			}
-		case 196:
+		case 203:
 			{
 				yylex.logToken(yylex.Text(), "SCOPE")
 				return SCOPE
 			}
-		case 197:
+		case 204:
 			{
 				yylex.logToken(yylex.Text(), "SELECT")
 				return SELECT
 			}
-		case 198:
+		case 205:
 			{
 				yylex.logToken(yylex.Text(), "SELF")
 				return SELF
 			}
-		case 199:
+		case 206:
 			{
 				yylex.logToken(yylex.Text(), "SET")
 				return SET
 			}
-		case 200:
+		case 207:
+			{
+				yylex.logToken(yylex.Text(), "SETS")
+				lval.s = yylex.Text()
+				return SETS
+			}
+		case 208:
 			{
 				yylex.logToken(yylex.Text(), "SHOW")
 				return SHOW
 			}
-		case 201:
+		case 209:
 			{
 				yylex.logToken(yylex.Text(), "SOME")
 				return SOME
 			}
-		case 202:
+		case 210:
 			{
 				yylex.logToken(yylex.Text(), "START")
 				return START
 			}
-		case 203:
+		case 211:
 			{
 				yylex.logToken(yylex.Text(), "STATISTICS")
 				return STATISTICS
 			}
-		case 204:
+		case 212:
 			{
 				yylex.logToken(yylex.Text(), "STRING")
 				return STRING
 			}
-		case 205:
+		case 213:
 			{
 				yylex.logToken(yylex.Text(), "SYSTEM")
 				return SYSTEM
 			}
-		case 206:
+		case 214:
 			{
 				yylex.logToken(yylex.Text(), "THEN")
 				return THEN
 			}
-		case 207:
+		case 215:
 			{
 				yylex.logToken(yylex.Text(), "TIES")
 				return TIES
 			}
-		case 208:
+		case 216:
 			{
 				yylex.logToken(yylex.Text(), "TO")
 				return TO
 			}
-		case 209:
+		case 217:
 			{
 				yylex.logToken(yylex.Text(), "TRAN")
 				return TRAN
 			}
-		case 210:
+		case 218:
 			{
 				yylex.logToken(yylex.Text(), "TRANSACTION")
 				return TRANSACTION
 			}
-		case 211:
+		case 219:
 			{
 				yylex.logToken(yylex.Text(), "TRIGGER")
 				return TRIGGER
 			}
-		case 212:
+		case 220:
 			{
 				yylex.logToken(yylex.Text(), "TRUE")
 				return TRUE
 			}
-		case 213:
+		case 221:
 			{
 				yylex.logToken(yylex.Text(), "TRUNCATE")
 				return TRUNCATE
 			}
-		case 214:
+		case 222:
 			{
 				yylex.logToken(yylex.Text(), "UNBOUNDED")
 				return UNBOUNDED
 			}
-		case 215:
+		case 223:
 			{
 				yylex.logToken(yylex.Text(), "UNDER")
 				return UNDER
 			}
-		case 216:
+		case 224:
 			{
 				yylex.logToken(yylex.Text(), "UNION")
 				return UNION
 			}
-		case 217:
+		case 225:
 			{
 				yylex.logToken(yylex.Text(), "UNIQUE")
 				return UNIQUE
 			}
-		case 218:
+		case 226:
 			{
 				yylex.logToken(yylex.Text(), "UNKNOWN")
 				return UNKNOWN
 			}
-		case 219:
+		case 227:
 			{
 				yylex.logToken(yylex.Text(), "UNNEST")
 				return UNNEST
 			}
-		case 220:
+		case 228:
 			{
 				yylex.logToken(yylex.Text(), "UNSET")
 				return UNSET
 			}
-		case 221:
+		case 229:
 			{
 				yylex.logToken(yylex.Text(), "UPDATE")
 				return UPDATE
 			}
-		case 222:
+		case 230:
 			{
 				yylex.logToken(yylex.Text(), "UPSERT")
 				return UPSERT
 			}
-		case 223:
+		case 231:
 			{
 				yylex.logToken(yylex.Text(), "USE")
 				return USE
 			}
-		case 224:
+		case 232:
 			{
 				yylex.logToken(yylex.Text(), "USER")
 				return USER
 			}
-		case 225:
+		case 233:
 			{
 				yylex.logToken(yylex.Text(), "USING")
 				return USING
 			}
-		case 226:
+		case 234:
 			{
 				yylex.logToken(yylex.Text(), "VALIDATE")
 				return VALIDATE
 			}
-		case 227:
+		case 235:
 			{
 				yylex.logToken(yylex.Text(), "VALUE")
 				return VALUE
 			}
-		case 228:
+		case 236:
 			{
 				yylex.logToken(yylex.Text(), "VALUED")
 				return VALUED
 			}
-		case 229:
+		case 237:
 			{
 				yylex.logToken(yylex.Text(), "VALUES")
 				return VALUES
 			}
-		case 230:
+		case 238:
 			{
 				yylex.logToken(yylex.Text(), "VIA")
 				return VIA
 			}
-		case 231:
+		case 239:
 			{
 				yylex.logToken(yylex.Text(), "VIEW")
 				return VIEW
 			}
-		case 232:
+		case 240:
 			{
 				yylex.logToken(yylex.Text(), "WHEN")
 				return WHEN
 			}
-		case 233:
+		case 241:
 			{
 				yylex.logToken(yylex.Text(), "WHERE")
 				return WHERE
 			}
-		case 234:
+		case 242:
 			{
 				yylex.logToken(yylex.Text(), "WHILE")
 				return WHILE
 			}
-		case 235:
+		case 243:
 			{
 				yylex.logToken(yylex.Text(), "WINDOW")
 				return WINDOW
 			}
-		case 236:
+		case 244:
 			{
 				yylex.logToken(yylex.Text(), "WITH")
 				return WITH
 			}
-		case 237:
+		case 245:
+			{
+				yylex.logToken(yylex.Text(), "WITHIN_GROUP")
+				return WITHIN_GROUP
+			}
+		case 246:
 			{
 				yylex.logToken(yylex.Text(), "WITHIN")
 				return WITHIN
 			}
-		case 238:
+		case 247:
 			{
 				yylex.logToken(yylex.Text(), "WORK")
 				return WORK
 			}
-		case 239:
+		case 248:
 			{
 				yylex.logToken(yylex.Text(), "XOR")
 				return XOR
 			}
-		case 240:
+		case 249:
 			{
 				lval.s = yylex.Text()
 				yylex.logToken(yylex.Text(), "IDENT - %s", lval.s)
 				return IDENT
 			}
-		case 241:
+		case 250:
 			{
 				lval.s = yylex.Text()[1:]
 				yylex.logToken(yylex.Text(), "NAMED_PARAM - %s", lval.s)
 				return NAMED_PARAM
 			}
-		case 242:
+		case 251:
 			{
 				lval.n, _ = strconv.ParseInt(yylex.Text()[1:], 10, 64)
 				yylex.logToken(yylex.Text(), "POSITIONAL_PARAM - %d", lval.n)
 				return POSITIONAL_PARAM
 			}
-		case 243:
+		case 252:
 			{
 				lval.n = 0 // Handled by parser
 				yylex.logToken(yylex.Text(), "NEXT_PARAM - ?")
 				return NEXT_PARAM
 			}
-		case 244:
+		case 253:
 			{
 				yylex.curOffset++
 			}
-		case 245:
+		case 254:
 			{
 				yylex.curOffset++
 			}
-		case 246:
+		case 255:
 			{
 				yylex.curOffset++
 			}
-		case 247:
+		case 256:
 			{
 				/* this we don't know what it is: we'll let
 				   the parser handle it (and most probably throw a syntax error
@@ -66,6 +66,7 @@ indexKeyTerms    algebra.IndexKeyTerms
 partitionTerm   *algebra.IndexPartitionTerm
 groupTerm       *algebra.GroupTerm
 groupTerms       algebra.GroupTerms
+groupingSets     []expression.Expressions
 windowTerm      *algebra.WindowTerm
 windowTerms      algebra.WindowTerms
 windowFrame     *algebra.WindowFrame
@@ -137,7 +138,9 @@ tokOffset	 int
 %token CORRELATED
 %token COVER
 %token CREATE
+%token CUBE
 %token CURRENT
+%token CYCLE
 %token DATABASE
 %token DATASET
 %token DATASTORE
@@ -175,6 +178,7 @@ tokOffset	 int
 %token GOLANG
 %token GRANT
 %token GROUP
+%token GROUPING
 %token GROUPS
 %token GSI
 %token HASH
@@ -191,6 +195,7 @@ tokOffset	 int
 %token INNER
 %token INSERT
 %token INTERSECT
+%token INTERVAL
 %token INTO
 %token IS
 %token ISOLATION
@@ -254,16 +259,19 @@ tokOffset	 int
 %token RAW
 %token READ
 %token REALM
+%token RECURSIVE
 %token REDUCE
 %token RENAME
 %token REPLACE
 %token RESPECT
+%token RESTRICT
 %token RETURN
 %token RETURNING
 %token REVOKE
 %token RIGHT
 %token ROLE
 %token ROLLBACK
+%token ROLLUP
 %token ROW
 %token ROWS
 %token SATISFIES
@@ -274,6 +282,7 @@ tokOffset	 int
 %token SELF
 %token SEMI
 %token SET
+%token SETS
 %token SHOW
 %token SOME
 %token START
@@ -312,6 +321,7 @@ tokOffset	 int
 %token WINDOW
 %token WITH
 %token WITHIN
+%token WITHIN_GROUP
 %token WORK
 %token XOR
 
@@ -321,6 +331,9 @@ tokOffset	 int
 %token COMMA COLON
 
 /* Precedence: lowest to highest */
+%nonassoc       GROUPING                        /* GROUP BY GROUPING SETS, rather than an alias named sets */
+%nonassoc       SETS
+%nonassoc       INTERVAL                        /* window frame INTERVAL -1 PRECEDING, rather than an identifier named interval */
 %left           ORDER
 %left           UNION INTERESECT EXCEPT
 %left           JOIN NEST UNNEST FLATTEN INNER LEFT RIGHT
@@ -353,6 +366,10 @@ tokOffset	 int
 %type <s>                STR
 %type <s>                IDENT IDENT_ICASE NAMESPACE_ID
 %type <s>                REPLACE
+%type <s>                CYCLE RECURSIVE RESTRICT
+%type <s>                CUBE GROUPING ROLLUP SETS
+%type <s>                INTERVAL
+%type <s>                permitted_identifiers
 %type <s>                NAMED_PARAM
 %type <f>                NUM
 %type <n>                INT
@@ -364,7 +381,10 @@ tokOffset	 int
 
 %type <expr>             expr c_expr b_expr
 %type <exprs>            exprs opt_exprs
-%type <binding>          binding with_term
+%type <binding>          binding with_term recursive_with_term
+%type <bindings>         recursive_with_list
+%type <expr>             opt_cte_options
+%type <exprs>            opt_cte_cycle
 %type <bindings>         bindings with_list
 
 %type <s>                alias as_alias opt_as_alias variable opt_name opt_window_name
@@ -419,6 +439,8 @@ tokOffset	 int
 %type <sortTerms>        sort_terms
 %type <groupTerm>        group_term
 %type <groupTerms>       group_terms
+%type <groupingSets>     grouping_sets
+%type <exprs>            grouping_set
 %type <expr>             limit opt_limit
 %type <expr>             offset opt_offset
 %type <b>                dir opt_dir
@@ -604,7 +626,7 @@ opt_name:
     $$ = ""
 }
 |
-IDENT from_or_as
+permitted_identifiers from_or_as
 {
     $$ = $1
 }
@@ -982,7 +1004,28 @@ AS alias
 ;
 
 alias:
+permitted_identifiers
+;
+
+/* keywords that are only reserved within their own clauses can also be used as identifiers */
+permitted_identifiers:
 IDENT
+|
+CUBE
+|
+CYCLE
+|
+GROUPING
+|
+INTERVAL
+|
+RECURSIVE
+|
+RESTRICT
+|
+ROLLUP
+|
+SETS
 ;
 
 
@@ -1028,7 +1071,7 @@ from_term opt_join_type JOIN simple_from_term on_keys
     $$ = algebra.NewJoin($1, $2, ksterm)
 }
 |
-from_term opt_join_type JOIN simple_from_term on_key FOR IDENT
+from_term opt_join_type JOIN simple_from_term on_key FOR permitted_identifiers
 {
     ksterm := algebra.GetKeyspaceTerm($4)
     if ksterm == nil {
@@ -1051,7 +1094,7 @@ from_term opt_join_type NEST simple_from_term on_keys
     $$ = algebra.NewNest($1, $2, ksterm)
 }
 |
-from_term opt_join_type NEST simple_from_term on_key FOR IDENT
+from_term opt_join_type NEST simple_from_term on_key FOR permitted_identifiers
 {
     ksterm := algebra.GetKeyspaceTerm($4)
     if ksterm == nil {
@@ -1190,15 +1233,15 @@ NAMESPACE_ID COLON
 ;
 
 bucket_name:
-IDENT
+permitted_identifiers
 ;
 
 scope_name:
-IDENT
+permitted_identifiers
 ;
 
 keyspace_name:
-IDENT
+permitted_identifiers
 ;
 
 opt_use:
@@ -1269,6 +1312,11 @@ NL
 {
     $$ = algebra.NewUse(nil, nil, algebra.USE_NL)
 }
+|
+MERGE
+{
+    $$ = algebra.NewUse(nil, nil, algebra.USE_MERGE)
+}
 ;
 
 opt_primary:
@@ -1413,6 +1461,11 @@ WITH with_list
 {
     $$ = $2
 }
+|
+WITH RECURSIVE recursive_with_list
+{
+    $$ = $3
+}
 ;
 
 with_list:
@@ -1439,6 +1492,71 @@ alias AS paren_expr
 }
 ;
 
+recursive_with_list:
+recursive_with_term
+{
+    $$ = expression.Bindings{$1}
+}
+|
+recursive_with_list COMMA recursive_with_term
+{
+    $$ = append($1, $3)
+}
+;
+
+/* under WITH RECURSIVE, only terms over a UNION [ALL] are recursive,
+   all others are plain common table expressions
+ */
+recursive_with_term:
+alias AS paren_expr opt_cte_options opt_cte_cycle
+{
+    var with *algebra.With
+    if sub, ok := $3.(*algebra.Subquery); ok {
+        switch sub.Select().Subresult().(type) {
+        case *algebra.Union, *algebra.UnionAll:
+            var err error
+            with, err = algebra.NewRecursiveWith($1, sub.Select(), $4, $5)
+            if err != nil {
+                yylex.Error(err.Error())
+            }
+        }
+    }
+    if with != nil {
+        $$ = expression.NewSimpleBinding($1, with)
+    } else {
+        if $4 != nil || $5 != nil {
+            yylex.Error(fmt.Sprintf("OPTIONS and CYCLE require a recursive common table expression: %s", $1))
+        }
+        $$ = expression.NewSimpleBinding($1, $3)
+    }
+    $$.SetStatic(true)
+}
+;
+
+opt_cte_options:
+/* empty */
+{
+    $$ = nil
+}
+|
+OPTIONS object
+{
+    $$ = $2
+}
+;
+
+opt_cte_cycle:
+/* empty */
+{
+    $$ = nil
+}
+|
+CYCLE exprs RESTRICT
+{
+    $$ = $2
+}
+;
+
 
 /*************************************************
  *
@@ -1481,6 +1599,9 @@ group
 group:
 GROUP BY group_terms opt_letting opt_having
 {
+    if n := $3.GroupingSetsCount(); n > algebra.MAX_GROUPING_SETS {
+        yylex.Error(fmt.Sprintf("GROUP BY cannot have more than %d grouping sets, %d found.", algebra.MAX_GROUPING_SETS, n))
+    }
     $$ = algebra.NewGroup($3, $4, $5)
 }
 |
@@ -1507,6 +1628,55 @@ expr opt_as_alias
 {
     $$ = algebra.NewGroupTerm($1, $2)
 }
+|
+ROLLUP LPAREN exprs RPAREN
+{
+    $$ = algebra.NewRollupTerm($3)
+}
+|
+CUBE LPAREN exprs RPAREN
+{
+    if len($3) > 12 {
+        yylex.Error(fmt.Sprintf("CUBE cannot have more than 12 terms, %d found.", len($3)))
+        $$ = algebra.NewCubeTerm(nil)
+    } else {
+        $$ = algebra.NewCubeTerm($3)
+    }
+}
+|
+GROUPING SETS LPAREN grouping_sets RPAREN
+{
+    $$ = algebra.NewGroupingSetsTerm($4)
+}
+;
+
+grouping_sets:
+grouping_set
+{
+    $$ = []expression.Expressions{$1}
+}
+|
+grouping_sets COMMA grouping_set
+{
+    $$ = append($1, $3)
+}
+;
+
+grouping_set:
+LPAREN RPAREN
+{
+    $$ = expression.Expressions{}
+}
+|
+LPAREN expr COMMA exprs RPAREN
+{
+    $$ = append(expression.Expressions{$2}, $4...)
+}
+|
+expr
+{
+    $$ = expression.Expressions{$1}
+}
 ;
 
 opt_letting:
@@ -2027,7 +2197,7 @@ variable COLON variable WITHIN expr
 ;
 
 variable:
-IDENT
+permitted_identifiers
 ;
 
 opt_when:
@@ -2462,7 +2632,7 @@ index_name
 ;
 
 index_name:
-IDENT
+permitted_identifiers
 ;
 
 opt_index_name:
@@ -2813,12 +2983,12 @@ parameter_terms
 ;
 
 parameter_terms:
-IDENT
+permitted_identifiers
 {
     $$ = []string{$1}
 }
 |
-parameter_terms COMMA IDENT
+parameter_terms COMMA permitted_identifiers
 {
     $$ = append($1, string($3))
 }
@@ -2999,12 +3169,12 @@ index_term_expr
  *************************************************/
 
 path:
-IDENT
+permitted_identifiers
 {
     $$ = expression.NewIdentifier($1)
 }
 |
-path DOT IDENT
+path DOT permitted_identifiers
 {
     $$ = expression.NewField($1, expression.NewFieldName($3, false))
 }
@@ -3045,7 +3215,7 @@ expr:
 c_expr
 |
 /* Nested */
-expr DOT IDENT
+expr DOT permitted_identifiers
 {
     $$ = expression.NewField($1, expression.NewFieldName($3, false))
 }
@@ -3263,7 +3433,7 @@ literal
 construction_expr
 |
 /* Identifier */
-IDENT
+permitted_identifiers
 {
     $$ = expression.NewIdentifier($1)
 }
@@ -3320,7 +3490,7 @@ b_expr:
 c_expr
 |
 /* Nested */
-b_expr DOT IDENT
+b_expr DOT permitted_identifiers
 {
     $$ = expression.NewField($1, expression.NewFieldName($3, false))
 }
@@ -3628,6 +3798,11 @@ ELSE expr
  *************************************************/
 
 function_expr:
+GROUPING LPAREN exprs RPAREN
+{
+    $$ = algebra.NewGrouping($3...)
+}
+|
 NTH_VALUE LPAREN exprs RPAREN opt_from_first_last opt_nulls_treatment window_function_details
 {
     $$ = nil
@@ -3706,6 +3881,48 @@ function_name LPAREN opt_exprs RPAREN opt_filter opt_nulls_treatment opt_window_
     }
 }
 |
+function_name LPAREN exprs order_by RPAREN opt_filter opt_window_function
+{
+    $$ = nil
+    f, ok := algebra.GetAggregate($1, false, ($6 != nil), ($7 != nil))
+    if !ok || !algebra.AggregateHasProperty($1, algebra.AGGREGATE_ALLOWS_ORDER) {
+        yylex.Error(fmt.Sprintf("ORDER BY syntax is not valid for function %s.", $1))
+    } else if len($3) < f.MinArgs() || len($3) > f.MaxArgs() {
+         if f.MinArgs() == f.MaxArgs() {
+               yylex.Error(fmt.Sprintf("Number of arguments to function %s must be %d.", $1, f.MaxArgs()))
+         } else {
+               yylex.Error(fmt.Sprintf("Number of arguments to function %s must be between %d and %d.", $1, f.MinArgs(), f.MaxArgs()))
+        }
+    } else {
+        $$ = f.Constructor()($3...)
+        if a, ok := $$.(algebra.Aggregate); ok {
+             a.SetOrder($4.Terms(), false)
+             a.SetAggregateModifiers(uint32(0), $6, $7)
+        }
+    }
+}
+|
+function_name LPAREN opt_exprs RPAREN WITHIN_GROUP order_by RPAREN opt_filter opt_window_function
+{
+    $$ = nil
+    f, ok := algebra.GetAggregate($1, false, ($8 != nil), ($9 != nil))
+    if !ok || !algebra.AggregateHasProperty($1, algebra.AGGREGATE_ALLOWS_WITHIN_GROUP) {
+        yylex.Error(fmt.Sprintf("WITHIN GROUP syntax is not valid for function %s.", $1))
+    } else if len($3) < f.MinArgs() || len($3) > f.MaxArgs() {
+         if f.MinArgs() == f.MaxArgs() {
+               yylex.Error(fmt.Sprintf("Number of arguments to function %s must be %d.", $1, f.MaxArgs()))
+         } else {
+               yylex.Error(fmt.Sprintf("Number of arguments to function %s must be between %d and %d.", $1, f.MinArgs(), f.MaxArgs()))
+        }
+    } else {
+        $$ = f.Constructor()($3...)
+        if a, ok := $$.(algebra.Aggregate); ok {
+             a.SetOrder($6.Terms(), true)
+             a.SetAggregateModifiers(uint32(0), $8, $9)
+        }
+    }
+}
+|
 function_name LPAREN agg_quantifier expr RPAREN opt_filter opt_window_function
 {
     agg, ok := algebra.GetAggregate($1, $3 == algebra.AGGREGATE_DISTINCT, ($6 != nil), ($7 != nil))
@@ -3961,7 +4178,7 @@ window_list COMMA window_term
 ;
 
 window_term:
-IDENT AS window_specification
+permitted_identifiers AS window_specification
 {
     $$ = $3
     $$.SetAsWindowName($1)
@@ -3979,7 +4196,7 @@ opt_window_name:
 /* empty */
 { $$ = "" }
 |
-IDENT
+permitted_identifiers
 ;
 
 opt_window_partition:
@@ -4078,6 +4295,11 @@ expr window_frame_valexpr_modifier
 {
     $$ = algebra.NewWindowFrameExtent($1, $2)
 }
+|
+INTERVAL expr window_frame_valexpr_modifier
+{
+    $$ = algebra.NewWindowFrameExtent($2, $3|algebra.WINDOW_FRAME_VALUE_INTERVAL)
+}
 ;
 
 window_frame_valexpr_modifier:
@@ -4151,7 +4373,7 @@ window_function_details
 ;
 
 window_function_details:
-OVER IDENT
+OVER permitted_identifiers
 {
     $$ = algebra.NewWindowTerm($2,nil,nil, nil, true)
 }
@@ -4223,7 +4445,7 @@ TO SAVEPOINT savepoint_name
 ;
 
 savepoint_name:
-IDENT
+permitted_identifiers
 {
     $$ = $1
 }
@@ -0,0 +1,305 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package n1ql
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/semantics"
+)
+
+func TestNonReservedKeywords(t *testing.T) {
+	stmts := []string{
+		"SELECT cycle, recursive, restrict FROM b",
+		"SELECT b.cycle, b.recursive, b.restrict FROM b",
+		"SELECT 1 AS cycle, 2 recursive",
+		"SELECT r.a FROM restrict AS r",
+		"SELECT cycle FROM b UNNEST b.items AS cycle",
+		"WITH recursive AS ([1, 2]) SELECT RAW r FROM recursive AS r",
+		"SELECT cube, grouping, rollup, sets FROM b",
+		"SELECT b.grouping, b.sets FROM b GROUP BY b.grouping, b.sets",
+		"SELECT g.x FROM b AS grouping UNNEST grouping.items AS g",
+		"SELECT rollup.x FROM b AS rollup GROUP BY rollup.x AS cube",
+		"SELECT b.interval FROM b",
+		"SELECT interval.x FROM b AS interval",
+		"SELECT 1 AS interval ORDER BY interval",
+		"SELECT SUM(b.x) OVER (ORDER BY b.interval RANGE BETWEEN interval PRECEDING AND interval FOLLOWING) FROM b",
+	}
+
+	for _, s := range stmts {
+		if _, err := ParseStatement(s); err != nil {
+			t.Errorf("failed to parse %s: %v", s, err)
+		}
+	}
+}
+
+func TestGroupingSets(t *testing.T) {
+	cases := []struct {
+		group string
+		sets  [][]int
+	}{
+		{"a, b", nil},
+		{"ROLLUP(a, b)", [][]int{{0, 1}, {0}, {}}},
+		{"CUBE(a, b)", [][]int{{0, 1}, {0}, {1}, {}}},
+		{"GROUPING SETS ((a, b), a, ())", [][]int{{0, 1}, {0}, {}}},
+		{"c, ROLLUP(a)", [][]int{{0, 1}, {0}}},
+		{"ROLLUP(a), CUBE(b)", [][]int{{0, 1}, {0}, {1}, {}}},
+		{"grouping, ROLLUP(rollup)", [][]int{{0, 1}, {0}}},
+	}
+
+	for _, c := range cases {
+		s := "SELECT COUNT(*) FROM b GROUP BY " + c.group
+		stmt, err := ParseStatement(s)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", s, err)
+			continue
+		}
+		group := stmt.(*algebra.Select).Subresult().(*algebra.Subselect).Group()
+		if fmt.Sprint(group.GroupingSets()) != fmt.Sprint(c.sets) {
+			t.Errorf("%s: expected grouping sets %v, got %v", s, c.sets, group.GroupingSets())
+		}
+
+		// the string representation parses back to the same grouping sets
+		again, err := ParseStatement("SELECT COUNT(*) FROM b" + group.String())
+		if err != nil {
+			t.Errorf("failed to parse the string representation of %s: %v", s, err)
+			continue
+		}
+		sets := again.(*algebra.Select).Subresult().(*algebra.Subselect).Group().GroupingSets()
+		if fmt.Sprint(sets) != fmt.Sprint(c.sets) {
+			t.Errorf("%s: expected grouping sets %v after round trip, got %v", group, c.sets, sets)
+		}
+	}
+
+	s := "SELECT COUNT(*) FROM b GROUP BY CUBE(a1, a2, a3, a4, a5, a6, a7, a8, a9, a10, a11, a12, a13)"
+	if _, err := ParseStatement(s); err == nil {
+		t.Errorf("expected %s to fail", s)
+	}
+}
+
+func TestRecursiveWith(t *testing.T) {
+	s := "WITH RECURSIVE nums AS (SELECT 1 AS n UNION ALL SELECT x.n + 1 AS n FROM nums AS x) " +
+		"OPTIONS {\"levels\": 2} CYCLE n RESTRICT SELECT RAW y.n FROM nums AS y"
+	stmt, err := ParseStatement(s)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", s, err)
+	}
+
+	with := recursiveWith(t, stmt)
+	if with.Alias() != "nums" || !with.UnionAll() || with.Levels() != 2 || len(with.Cycle()) != 1 {
+		t.Errorf("unexpected recursive term %v: levels %v, cycle %v", with.Alias(), with.Levels(), with.Cycle())
+	}
+	if _, err = stmt.Accept(semantics.NewSemChecker(true, stmt.Type(), false)); err != nil {
+		t.Errorf("unexpected semantic error for %s: %v", s, err)
+	}
+
+	// the string representation parses back to the same clauses
+	stmt, err = ParseStatement("WITH RECURSIVE nums AS (" + with.Select().String() + ")" +
+		with.ClausesString() + " SELECT RAW y.n FROM nums AS y")
+	if err != nil {
+		t.Fatalf("failed to parse the string representation of %s: %v", s, err)
+	}
+	if again := recursiveWith(t, stmt); again.ClausesString() != with.ClausesString() {
+		t.Errorf("expected %s, got %s", with.ClausesString(), again.ClausesString())
+	}
+
+	errors := []string{
+		// options without RECURSIVE
+		"WITH nums AS (SELECT 1 AS n) OPTIONS {\"levels\": 2} SELECT RAW y.n FROM nums AS y",
+
+		// ordering the union
+		"WITH RECURSIVE nums AS (SELECT 1 AS n UNION SELECT x.n FROM nums AS x ORDER BY n) SELECT RAW y.n FROM nums AS y",
+	}
+	for _, s := range errors {
+		if _, err = ParseStatement(s); err == nil {
+			t.Errorf("expected %s to fail", s)
+		}
+	}
+
+	// the recursive member must refer to the recursive term
+	s = "WITH RECURSIVE nums AS (SELECT 1 AS n UNION ALL SELECT 2 AS n) SELECT RAW y.n FROM nums AS y"
+	stmt, err = ParseStatement(s)
+	if err != nil {
+		t.Fatalf("failed to parse %s: %v", s, err)
+	}
+	if _, err = stmt.Accept(semantics.NewSemChecker(true, stmt.Type(), false)); err == nil {
+		t.Errorf("expected a semantic error for %s", s)
+	}
+}
+
+func recursiveWith(t *testing.T, stmt algebra.Statement) *algebra.With {
+	sel, ok := stmt.(*algebra.Select)
+	if !ok {
+		t.Fatalf("expected a SELECT statement, got %T", stmt)
+	}
+	sub, ok := sel.Subresult().(*algebra.Subselect)
+	if !ok || len(sub.With()) != 1 {
+		t.Fatalf("expected a single WITH term in %v", sel)
+	}
+	with, ok := sub.With()[0].Expression().(*algebra.With)
+	if !ok {
+		t.Fatalf("expected a recursive WITH term, got %T", sub.With()[0].Expression())
+	}
+	return with
+}
+
+func TestOrderedAggregates(t *testing.T) {
+	exprs := []string{
+		"STRING_AGG(a, ', ' ORDER BY b DESC, c)",
+		"STRING_AGG(a, '-')",
+		"PERCENTILE_CONT(0.5) WITHIN GROUP (ORDER BY a)",
+		"PERCENTILE_DISC(0.25) WITHIN GROUP (ORDER BY a DESC) FILTER (WHERE b > 1)",
+		"MODE() WITHIN GROUP (ORDER BY a)",
+		"MODE(a)",
+		"CORR(a, b)",
+		"COVAR_POP(a, b)",
+		"COVAR_SAMP(a, b)",
+		"REGR_COUNT(a, b) OVER (PARTITION BY c)",
+		"REGR_AVGX(a, b)",
+		"REGR_AVGY(a, b)",
+		"REGR_SXX(a, b)",
+		"REGR_SYY(a, b)",
+		"REGR_SXY(a, b)",
+		"REGR_SLOPE(a, b) OVER (ORDER BY c ROWS BETWEEN 2 PRECEDING AND CURRENT ROW)",
+		"REGR_INTERCEPT(a, b)",
+		"REGR_R2(a, b)",
+		"BOOL_AND(a)",
+		"BOOL_OR(a) FILTER (WHERE b)",
+	}
+
+	for _, s := range exprs {
+		expr, err := ParseExpression(s)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", s, err)
+			continue
+		}
+		if _, ok := expr.(algebra.Aggregate); !ok {
+			t.Errorf("expected %s to be an aggregate, got %T", s, expr)
+			continue
+		}
+
+		// the string representation parses back to the same aggregate
+		again, err := ParseExpression(expr.String())
+		if err != nil {
+			t.Errorf("failed to parse the string representation %s of %s: %v", expr, s, err)
+		} else if again.String() != expr.String() || !again.EquivalentTo(expr) {
+			t.Errorf("%s: expected %s after round trip, got %s", s, expr, again)
+		}
+	}
+
+	errors := []string{
+		"STRING_AGG(DISTINCT a, ',')",
+		"STRING_AGG(a)",
+		"PERCENTILE_CONT(0.5, a) WITHIN GROUP (ORDER BY a)",
+		"CORR(a)",
+		"REGR_COUNT(DISTINCT a, b)",
+		"BOOL_AND(a ORDER BY b)",
+		"SUM(a) WITHIN GROUP (ORDER BY a)",
+	}
+	for _, s := range errors {
+		if _, err := ParseExpression(s); err == nil {
+			t.Errorf("expected %s to fail", s)
+		}
+	}
+}
+
+func TestApproxAggregates(t *testing.T) {
+	exprs := []string{
+		"APPROX_COUNT_DISTINCT(a)",
+		"APPROX_COUNT_DISTINCT(a) FILTER (WHERE b > 1)",
+		"APPROX_COUNT_DISTINCT(a) OVER (PARTITION BY c)",
+		"APPROX_PERCENTILE(a, 0.5)",
+		"APPROX_PERCENTILE(a, [0.1, 0.9]) OVER (ORDER BY c)",
+		"SKETCH_HLL(a)",
+		"SKETCH_HLL(a, 12)",
+		"SKETCH_KLL(a)",
+		"SKETCH_KLL(a, 100)",
+		"SKETCH_MERGE(a.s)",
+	}
+
+	for _, s := range exprs {
+		expr, err := ParseExpression(s)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", s, err)
+			continue
+		}
+		if _, ok := expr.(algebra.Aggregate); !ok {
+			t.Errorf("expected %s to be an aggregate, got %T", s, expr)
+			continue
+		}
+
+		again, err := ParseExpression(expr.String())
+		if err != nil {
+			t.Errorf("failed to parse the string representation %s of %s: %v", expr, s, err)
+		} else if again.String() != expr.String() || !again.EquivalentTo(expr) {
+			t.Errorf("%s: expected %s after round trip, got %s", s, expr, again)
+		}
+	}
+
+	// the functions on stored sketches are not aggregates
+	for _, s := range []string{
+		"SKETCH_COUNT_DISTINCT(a.s)",
+		"SKETCH_PERCENTILE(a.s, 0.5)",
+		"SKETCH_UNION(a.s, b.s, c.s)",
+	} {
+		expr, err := ParseExpression(s)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", s, err)
+		} else if _, ok := expr.(algebra.Aggregate); ok {
+			t.Errorf("expected %s not to be an aggregate", s)
+		}
+	}
+
+	errors := []string{
+		"APPROX_COUNT_DISTINCT(DISTINCT a)",
+		"APPROX_COUNT_DISTINCT(a, b)",
+		"APPROX_PERCENTILE(a)",
+		"APPROX_PERCENTILE(a, 0.5) WITHIN GROUP (ORDER BY a)",
+		"SKETCH_HLL()",
+		"SKETCH_MERGE(a, b)",
+		"SKETCH_PERCENTILE(a.s)",
+		"SKETCH_UNION(a.s)",
+	}
+	for _, s := range errors {
+		if _, err := ParseExpression(s); err == nil {
+			t.Errorf("expected %s to fail", s)
+		}
+	}
+}
+
+func TestWindowFrameInterval(t *testing.T) {
+	exprs := []string{
+		"COUNT(1) OVER (ORDER BY a RANGE BETWEEN INTERVAL '7 days' PRECEDING AND CURRENT ROW)",
+		"COUNT(1) OVER (ORDER BY a RANGE BETWEEN CURRENT ROW AND INTERVAL b FOLLOWING)",
+		"COUNT(1) OVER (ORDER BY a RANGE INTERVAL -1 PRECEDING)",
+	}
+
+	for _, s := range exprs {
+		expr, err := ParseExpression(s)
+		if err != nil {
+			t.Errorf("failed to parse %s: %v", s, err)
+			continue
+		}
+		extents := expr.(algebra.Aggregate).WindowTerm().WindowFrame().WindowFrameExtents()
+		if !extents[0].Interval() && !extents[len(extents)-1].Interval() {
+			t.Errorf("%s: expected an INTERVAL frame extent", s)
+		}
+
+		// the string representation parses back to the same window frame
+		again, err := ParseExpression(expr.String())
+		if err != nil {
+			t.Errorf("failed to parse the string representation %s of %s: %v", expr, s, err)
+		} else if again.String() != expr.String() {
+			t.Errorf("%s: expected %s after round trip, got %s", s, expr, again)
+		}
+	}
+}
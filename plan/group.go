@@ -21,15 +21,17 @@ import (
 type InitialGroup struct {
 	readonly
 	optEstimate
-	keys       expression.Expressions
-	aggregates algebra.Aggregates
+	keys         expression.Expressions
+	aggregates   algebra.Aggregates
+	groupingSets [][]int
 }
 
-func NewInitialGroup(keys expression.Expressions, aggregates algebra.Aggregates,
+func NewInitialGroup(keys expression.Expressions, aggregates algebra.Aggregates, groupingSets [][]int,
 	cost, cardinality float64, size int64, frCost float64) *InitialGroup {
 	rv := &InitialGroup{
-		keys:       keys,
-		aggregates: aggregates,
+		keys:         keys,
+		aggregates:   aggregates,
+		groupingSets: groupingSets,
 	}
 	setOptEstimate(&rv.optEstimate, cost, cardinality, size, frCost)
 	return rv
@@ -51,6 +53,10 @@ func (this *InitialGroup) Aggregates() algebra.Aggregates {
 	return this.aggregates
 }
 
+func (this *InitialGroup) GroupingSets() [][]int {
+	return this.groupingSets
+}
+
 func (this *InitialGroup) MarshalJSON() ([]byte, error) {
 	return json.Marshal(this.MarshalBase(nil))
 }
@@ -67,6 +73,9 @@ func (this *InitialGroup) MarshalBase(f func(map[string]interface{})) map[string
 		s = append(s, expression.NewStringer().Visit(agg))
 	}
 	r["aggregates"] = s
+	if this.groupingSets != nil {
+		r["grouping_sets"] = this.groupingSets
+	}
 	if optEstimate := marshalOptEstimate(&this.optEstimate); optEstimate != nil {
 		r["optimizer_estimates"] = optEstimate
 	}
@@ -78,10 +87,11 @@ func (this *InitialGroup) MarshalBase(f func(map[string]interface{})) map[string
 
 func (this *InitialGroup) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_           string                 `json:"#operator"`
-		Keys        []string               `json:"group_keys"`
-		Aggs        []string               `json:"aggregates"`
-		OptEstimate map[string]interface{} `json:"optimizer_estimates"`
+		_            string                 `json:"#operator"`
+		Keys         []string               `json:"group_keys"`
+		Aggs         []string               `json:"aggregates"`
+		GroupingSets [][]int                `json:"grouping_sets"`
+		OptEstimate  map[string]interface{} `json:"optimizer_estimates"`
 	}
 
 	err := json.Unmarshal(body, &_unmarshalled)
@@ -107,6 +117,8 @@ func (this *InitialGroup) UnmarshalJSON(body []byte) error {
 		this.aggregates[i], _ = agg_expr.(algebra.Aggregate)
 	}
 
+	this.groupingSets = _unmarshalled.GroupingSets
+
 	unmarshalOptEstimate(&this.optEstimate, _unmarshalled.OptEstimate)
 
 	return nil
@@ -116,15 +128,17 @@ func (this *InitialGroup) UnmarshalJSON(body []byte) error {
 type IntermediateGroup struct {
 	readonly
 	optEstimate
-	keys       expression.Expressions
-	aggregates algebra.Aggregates
+	keys         expression.Expressions
+	aggregates   algebra.Aggregates
+	groupingSets [][]int
 }
 
-func NewIntermediateGroup(keys expression.Expressions, aggregates algebra.Aggregates,
+func NewIntermediateGroup(keys expression.Expressions, aggregates algebra.Aggregates, groupingSets [][]int,
 	cost, cardinality float64, size int64, frCost float64) *IntermediateGroup {
 	rv := &IntermediateGroup{
-		keys:       keys,
-		aggregates: aggregates,
+		keys:         keys,
+		aggregates:   aggregates,
+		groupingSets: groupingSets,
 	}
 	setOptEstimate(&rv.optEstimate, cost, cardinality, size, frCost)
 	return rv
@@ -146,6 +160,10 @@ func (this *IntermediateGroup) Aggregates() algebra.Aggregates {
 	return this.aggregates
 }
 
+func (this *IntermediateGroup) GroupingSets() [][]int {
+	return this.groupingSets
+}
+
 func (this *IntermediateGroup) MarshalJSON() ([]byte, error) {
 	return json.Marshal(this.MarshalBase(nil))
 }
@@ -162,6 +180,9 @@ func (this *IntermediateGroup) MarshalBase(f func(map[string]interface{})) map[s
 		s = append(s, expression.NewStringer().Visit(agg))
 	}
 	r["aggregates"] = s
+	if this.groupingSets != nil {
+		r["grouping_sets"] = this.groupingSets
+	}
 	if optEstimate := marshalOptEstimate(&this.optEstimate); optEstimate != nil {
 		r["optimizer_estimates"] = optEstimate
 	}
@@ -173,10 +194,11 @@ func (this *IntermediateGroup) MarshalBase(f func(map[string]interface{})) map[s
 
 func (this *IntermediateGroup) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_           string                 `json:"#operator"`
-		Keys        []string               `json:"group_keys"`
-		Aggs        []string               `json:"aggregates"`
-		OptEstimate map[string]interface{} `json:"optimizer_estimates"`
+		_            string                 `json:"#operator"`
+		Keys         []string               `json:"group_keys"`
+		Aggs         []string               `json:"aggregates"`
+		GroupingSets [][]int                `json:"grouping_sets"`
+		OptEstimate  map[string]interface{} `json:"optimizer_estimates"`
 	}
 
 	err := json.Unmarshal(body, &_unmarshalled)
@@ -202,6 +224,8 @@ func (this *IntermediateGroup) UnmarshalJSON(body []byte) error {
 		this.aggregates[i], _ = agg_expr.(algebra.Aggregate)
 	}
 
+	this.groupingSets = _unmarshalled.GroupingSets
+
 	unmarshalOptEstimate(&this.optEstimate, _unmarshalled.OptEstimate)
 
 	return nil
@@ -211,15 +235,17 @@ func (this *IntermediateGroup) UnmarshalJSON(body []byte) error {
 type FinalGroup struct {
 	readonly
 	optEstimate
-	keys       expression.Expressions
-	aggregates algebra.Aggregates
+	keys         expression.Expressions
+	aggregates   algebra.Aggregates
+	groupingSets [][]int
 }
 
-func NewFinalGroup(keys expression.Expressions, aggregates algebra.Aggregates,
+func NewFinalGroup(keys expression.Expressions, aggregates algebra.Aggregates, groupingSets [][]int,
 	cost, cardinality float64, size int64, frCost float64) *FinalGroup {
 	rv := &FinalGroup{
-		keys:       keys,
-		aggregates: aggregates,
+		keys:         keys,
+		aggregates:   aggregates,
+		groupingSets: groupingSets,
 	}
 	setOptEstimate(&rv.optEstimate, cost, cardinality, size, frCost)
 	return rv
@@ -241,6 +267,10 @@ func (this *FinalGroup) Aggregates() algebra.Aggregates {
 	return this.aggregates
 }
 
+func (this *FinalGroup) GroupingSets() [][]int {
+	return this.groupingSets
+}
+
 func (this *FinalGroup) MarshalJSON() ([]byte, error) {
 	return json.Marshal(this.MarshalBase(nil))
 }
@@ -257,6 +287,9 @@ func (this *FinalGroup) MarshalBase(f func(map[string]interface{})) map[string]i
 		s = append(s, expression.NewStringer().Visit(agg))
 	}
 	r["aggregates"] = s
+	if this.groupingSets != nil {
+		r["grouping_sets"] = this.groupingSets
+	}
 	if optEstimate := marshalOptEstimate(&this.optEstimate); optEstimate != nil {
 		r["optimizer_estimates"] = optEstimate
 	}
@@ -268,10 +301,11 @@ func (this *FinalGroup) MarshalBase(f func(map[string]interface{})) map[string]i
 
 func (this *FinalGroup) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_           string                 `json:"#operator"`
-		Keys        []string               `json:"group_keys"`
-		Aggs        []string               `json:"aggregates"`
-		OptEstimate map[string]interface{} `json:"optimizer_estimates"`
+		_            string                 `json:"#operator"`
+		Keys         []string               `json:"group_keys"`
+		Aggs         []string               `json:"aggregates"`
+		GroupingSets [][]int                `json:"grouping_sets"`
+		OptEstimate  map[string]interface{} `json:"optimizer_estimates"`
 	}
 
 	err := json.Unmarshal(body, &_unmarshalled)
@@ -297,6 +331,8 @@ func (this *FinalGroup) UnmarshalJSON(body []byte) error {
 		this.aggregates[i], _ = agg_expr.(algebra.Aggregate)
 	}
 
+	this.groupingSets = _unmarshalled.GroupingSets
+
 	unmarshalOptEstimate(&this.optEstimate, _unmarshalled.OptEstimate)
 
 	return nil
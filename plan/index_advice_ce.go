@@ -15,12 +15,21 @@ import (
 	"encoding/json"
 )
 
+const NO_INDEX_RECOMMENDATION = "No index recommendation at this time, primary index may apply."
+
 type IndexAdvice struct {
 	execution
+	adviceInfo *IndexAdviceInfo
 }
 
-func NewIndexAdvice() *IndexAdvice {
-	return &IndexAdvice{}
+func NewIndexAdvice(curIndexes, recIndexes, coverIdxes AdvisedIndexes) *IndexAdvice {
+	return &IndexAdvice{
+		adviceInfo: &IndexAdviceInfo{
+			CurIndexes:   curIndexes,
+			RecIndexes:   recIndexes,
+			CoverIndexes: coverIdxes,
+		},
+	}
 }
 
 func (this *IndexAdvice) Accept(visitor Visitor) (interface{}, error) {
@@ -35,12 +44,19 @@ func (this *IndexAdvice) Operator() Operator {
 	return this
 }
 
+func (this *IndexAdvice) AdviceInfo() *IndexAdviceInfo {
+	return this.adviceInfo
+}
+
 func (this *IndexAdvice) MarshalJSON() ([]byte, error) {
 	return json.Marshal(this.MarshalBase(nil))
 }
 
 func (this *IndexAdvice) MarshalBase(f func(map[string]interface{})) map[string]interface{} {
 	r := map[string]interface{}{"#operator": "IndexAdvice"}
+	if this.adviceInfo != nil {
+		r["adviseinfo"] = this.adviceInfo
+	}
 
 	if f != nil {
 		f(r)
@@ -50,13 +66,179 @@ func (this *IndexAdvice) MarshalBase(f func(map[string]interface{})) map[string]
 
 func (this *IndexAdvice) UnmarshalJSON(body []byte) error {
 	var _unmarshalled struct {
-		_ string `json:"#operator"`
+		_          string          `json:"#operator"`
+		AdviceInfo json.RawMessage `json:"adviseinfo"`
+	}
+
+	err := json.Unmarshal(body, &_unmarshalled)
+	if err != nil {
+		return err
+	}
+
+	if _unmarshalled.AdviceInfo != nil {
+		r := &IndexAdviceInfo{}
+		err = r.UnmarshalJSON(_unmarshalled.AdviceInfo)
+		if err != nil {
+			return err
+		}
+		this.adviceInfo = r
+	}
+	return nil
+}
+
+/*
+The indexes used by the statement, and the secondary indexes recommended for
+it, split into those the statement would use to fetch documents and those
+that would cover the statement.
+*/
+type IndexAdviceInfo struct {
+	CurIndexes   AdvisedIndexes
+	RecIndexes   AdvisedIndexes
+	CoverIndexes AdvisedIndexes
+}
+
+func (this *IndexAdviceInfo) MarshalJSON() ([]byte, error) {
+	r := make(map[string]interface{}, 2)
+	if len(this.CurIndexes) > 0 {
+		r["current_indexes"] = this.CurIndexes
+	}
+
+	if len(this.RecIndexes) == 0 && len(this.CoverIndexes) == 0 {
+		r["recommended_indexes"] = NO_INDEX_RECOMMENDATION
+	} else {
+		rec := make(map[string]interface{}, 2)
+		if len(this.RecIndexes) > 0 {
+			rec["indexes"] = this.RecIndexes
+		}
+		if len(this.CoverIndexes) > 0 {
+			rec["covering_indexes"] = this.CoverIndexes
+		}
+		r["recommended_indexes"] = rec
+	}
+	return json.Marshal(r)
+}
+
+func (this *IndexAdviceInfo) UnmarshalJSON(body []byte) error {
+	var _unmarshalled struct {
+		CurIndexes AdvisedIndexes  `json:"current_indexes"`
+		RecIndexes json.RawMessage `json:"recommended_indexes"`
 	}
 
 	err := json.Unmarshal(body, &_unmarshalled)
 	if err != nil {
 		return err
 	}
+	this.CurIndexes = _unmarshalled.CurIndexes
 
+	var rec struct {
+		Indexes      AdvisedIndexes `json:"indexes"`
+		CoverIndexes AdvisedIndexes `json:"covering_indexes"`
+	}
+
+	// no recommendation is a message
+	if len(_unmarshalled.RecIndexes) > 0 && _unmarshalled.RecIndexes[0] == '{' {
+		err = json.Unmarshal(_unmarshalled.RecIndexes, &rec)
+		if err != nil {
+			return err
+		}
+	}
+	this.RecIndexes = rec.Indexes
+	this.CoverIndexes = rec.CoverIndexes
+	return nil
+}
+
+type AdvisedIndexes []*AdvisedIndex
+
+/*
+An index statement, with the aliases of the keyspace terms it applies to and,
+for recommended indexes, the rule used to order the index keys for each
+alias.
+*/
+type AdvisedIndex struct {
+	Statement string
+	Aliases   []string
+	Rules     []string
+	Status    string
+	Property  string
+}
+
+func (this *AdvisedIndex) MarshalJSON() ([]byte, error) {
+	r := make(map[string]interface{}, 5)
+	r["index_statement"] = this.Statement
+
+	if len(this.Aliases) == 1 {
+		r["keyspace_alias"] = this.Aliases[0]
+		if len(this.Rules) > 0 && this.Rules[0] != "" {
+			r["recommending_rule"] = this.Rules[0]
+		}
+	} else if len(this.Aliases) > 1 {
+		r["keyspace_aliases"] = this.Aliases
+		if len(this.Rules) == len(this.Aliases) {
+			rules := make(map[string]interface{}, len(this.Rules))
+			for i, rule := range this.Rules {
+				if rule != "" {
+					rules[this.Aliases[i]] = rule
+				}
+			}
+			if len(rules) > 0 {
+				r["recommending_rule"] = rules
+			}
+		}
+	}
+
+	if this.Status != "" {
+		r["index_status"] = this.Status
+	}
+	if this.Property != "" {
+		r["index_property"] = this.Property
+	}
+	return json.Marshal(r)
+}
+
+func (this *AdvisedIndex) UnmarshalJSON(body []byte) error {
+	var _unmarshalled struct {
+		Statement string          `json:"index_statement"`
+		Alias     string          `json:"keyspace_alias"`
+		Aliases   []string        `json:"keyspace_aliases"`
+		Rule      json.RawMessage `json:"recommending_rule"`
+		Status    string          `json:"index_status"`
+		Property  string          `json:"index_property"`
+	}
+
+	err := json.Unmarshal(body, &_unmarshalled)
+	if err != nil {
+		return err
+	}
+
+	this.Statement = _unmarshalled.Statement
+	this.Status = _unmarshalled.Status
+	this.Property = _unmarshalled.Property
+	this.Aliases = nil
+	this.Rules = nil
+
+	if _unmarshalled.Alias != "" {
+		this.Aliases = []string{_unmarshalled.Alias}
+		if len(_unmarshalled.Rule) > 0 {
+			var rule string
+			err = json.Unmarshal(_unmarshalled.Rule, &rule)
+			if err != nil {
+				return err
+			}
+			this.Rules = []string{rule}
+		}
+	} else if len(_unmarshalled.Aliases) > 0 {
+		this.Aliases = _unmarshalled.Aliases
+		if len(_unmarshalled.Rule) > 0 {
+			var rules map[string]string
+			err = json.Unmarshal(_unmarshalled.Rule, &rules)
+			if err != nil {
+				return err
+			}
+			this.Rules = make([]string, len(this.Aliases))
+			for i, alias := range this.Aliases {
+				this.Rules[i] = rules[alias]
+			}
+		}
+	}
 	return nil
 }
@@ -0,0 +1,163 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package plan
+
+import (
+	"encoding/json"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/expression"
+)
+
+/*
+Like MergeNest, the outer input and the child are ordered on the join keys.
+*/
+type MergeNest struct {
+	readonly
+	optEstimate
+	outer     bool
+	alias     string
+	onclause  expression.Expression
+	child     Operator
+	outerKey  expression.Expression
+	innerKey  expression.Expression
+	hintError string
+	filter    expression.Expression
+}
+
+func NewMergeNest(nest *algebra.AnsiNest, child Operator, outerKey, innerKey expression.Expression,
+	filter expression.Expression, cost, cardinality float64, size int64, frCost float64) *MergeNest {
+	rv := &MergeNest{
+		outer:     nest.Outer(),
+		alias:     nest.Alias(),
+		onclause:  nest.Onclause(),
+		child:     child,
+		outerKey:  outerKey,
+		innerKey:  innerKey,
+		hintError: nest.HintError(),
+		filter:    filter,
+	}
+	setOptEstimate(&rv.optEstimate, cost, cardinality, size, frCost)
+	return rv
+}
+
+func (this *MergeNest) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitMergeNest(this)
+}
+
+func (this *MergeNest) New() Operator {
+	return &MergeNest{}
+}
+
+func (this *MergeNest) Outer() bool {
+	return this.outer
+}
+
+func (this *MergeNest) Alias() string {
+	return this.alias
+}
+
+func (this *MergeNest) Onclause() expression.Expression {
+	return this.onclause
+}
+
+func (this *MergeNest) Child() Operator {
+	return this.child
+}
+
+func (this *MergeNest) OuterKey() expression.Expression {
+	return this.outerKey
+}
+
+func (this *MergeNest) InnerKey() expression.Expression {
+	return this.innerKey
+}
+
+func (this *MergeNest) HintError() string {
+	return this.hintError
+}
+
+func (this *MergeNest) Filter() expression.Expression {
+	return this.filter
+}
+
+func (this *MergeNest) MarshalJSON() ([]byte, error) {
+	return json.Marshal(this.MarshalBase(nil))
+}
+
+func (this *MergeNest) MarshalBase(f func(map[string]interface{})) map[string]interface{} {
+	r := map[string]interface{}{"#operator": "MergeNest"}
+	r["alias"] = this.alias
+	r["on_clause"] = expression.NewStringer().Visit(this.onclause)
+	r["outer_key"] = expression.NewStringer().Visit(this.outerKey)
+	r["inner_key"] = expression.NewStringer().Visit(this.innerKey)
+
+	if this.outer {
+		r["outer"] = this.outer
+	}
+
+	if this.hintError != "" {
+		r["hint_not_followed"] = this.hintError
+	}
+
+	if this.filter != nil {
+		r["filter"] = expression.NewStringer().Visit(this.filter)
+	}
+
+	if optEstimate := marshalOptEstimate(&this.optEstimate); optEstimate != nil {
+		r["optimizer_estimates"] = optEstimate
+	}
+
+	if f != nil {
+		f(r)
+	} else {
+		r["~child"] = this.child
+	}
+	return r
+}
+
+func (this *MergeNest) UnmarshalJSON(body []byte) error {
+	var _unmarshalled struct {
+		_           string                 `json:"#operator"`
+		Alias       string                 `json:"alias"`
+		Onclause    string                 `json:"on_clause"`
+		OuterKey    string                 `json:"outer_key"`
+		InnerKey    string                 `json:"inner_key"`
+		Outer       bool                   `json:"outer"`
+		HintError   string                 `json:"hint_not_followed"`
+		Filter      string                 `json:"filter"`
+		OptEstimate map[string]interface{} `json:"optimizer_estimates"`
+		Child       json.RawMessage        `json:"~child"`
+	}
+
+	err := json.Unmarshal(body, &_unmarshalled)
+	if err != nil {
+		return err
+	}
+
+	this.onclause, this.outerKey, this.innerKey, this.filter, err = unmarshalMergeExprs(
+		_unmarshalled.Onclause, _unmarshalled.OuterKey, _unmarshalled.InnerKey, _unmarshalled.Filter)
+	if err != nil {
+		return err
+	}
+
+	this.alias = _unmarshalled.Alias
+	this.outer = _unmarshalled.Outer
+	this.hintError = _unmarshalled.HintError
+
+	unmarshalOptEstimate(&this.optEstimate, _unmarshalled.OptEstimate)
+
+	this.child, err = unmarshalMergeChild(_unmarshalled.Child)
+	return err
+}
+
+func (this *MergeNest) verify(prepared *Prepared) bool {
+	return this.child.verify(prepared)
+}
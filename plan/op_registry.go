@@ -68,14 +68,17 @@ var _OPERATORS = map[string]Operator{
 	"IndexJoin":      &IndexJoin{},
 	"NestedLoopJoin": &NLJoin{},
 	"HashJoin":       &HashJoin{},
+	"MergeJoin":      &MergeJoin{},
 	"Nest":           &Nest{},
 	"IndexNest":      &IndexNest{},
 	"NestedLoopNest": &NLNest{},
 	"HashNest":       &HashNest{},
+	"MergeNest":      &MergeNest{},
 	"Unnest":         &Unnest{},
 
-	// Let + Letting
-	"Let": &Let{},
+	// Let + Letting, With
+	"Let":           &Let{},
+	"RecursiveWith": &RecursiveWith{},
 
 	// Infer
 	"InferKeyspace": &InferKeyspace{},
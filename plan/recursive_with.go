@@ -0,0 +1,156 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package plan
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/expression/parser"
+)
+
+type RecursiveWith struct {
+	readonly
+	optEstimate
+	with  *algebra.With
+	child Operator
+}
+
+func NewRecursiveWith(with *algebra.With, child Operator, cost, cardinality float64,
+	size int64, frCost float64) *RecursiveWith {
+	rv := &RecursiveWith{
+		with:  with,
+		child: child,
+	}
+	setOptEstimate(&rv.optEstimate, cost, cardinality, size, frCost)
+	return rv
+}
+
+func (this *RecursiveWith) Accept(visitor Visitor) (interface{}, error) {
+	return visitor.VisitRecursiveWith(this)
+}
+
+func (this *RecursiveWith) New() Operator {
+	return &RecursiveWith{}
+}
+
+func (this *RecursiveWith) With() *algebra.With {
+	return this.with
+}
+
+func (this *RecursiveWith) Readonly() bool {
+	return this.child.Readonly()
+}
+
+func (this *RecursiveWith) Child() Operator {
+	return this.child
+}
+
+func (this *RecursiveWith) MarshalJSON() ([]byte, error) {
+	return json.Marshal(this.MarshalBase(nil))
+}
+
+func (this *RecursiveWith) MarshalBase(f func(map[string]interface{})) map[string]interface{} {
+	r := map[string]interface{}{"#operator": "RecursiveWith"}
+	r["alias"] = this.with.Alias()
+	r["expr"] = this.with.String()
+	if this.with.UnionAll() {
+		r["union_all"] = true
+	}
+	if this.with.Options() != nil {
+		r["options"] = this.with.Options().String()
+	}
+	if len(this.with.Cycle()) > 0 {
+		cycle := make([]string, len(this.with.Cycle()))
+		for i, expr := range this.with.Cycle() {
+			cycle[i] = expr.String()
+		}
+		r["cycle"] = cycle
+	}
+	if optEstimate := marshalOptEstimate(&this.optEstimate); optEstimate != nil {
+		r["optimizer_estimates"] = optEstimate
+	}
+	if f != nil {
+		f(r)
+	} else {
+		r["~child"] = this.child
+	}
+	return r
+}
+
+func (this *RecursiveWith) UnmarshalJSON(body []byte) error {
+	var _unmarshalled struct {
+		_           string                 `json:"#operator"`
+		Alias       string                 `json:"alias"`
+		Expr        string                 `json:"expr"`
+		UnionAll    bool                   `json:"union_all"`
+		Options     string                 `json:"options"`
+		Cycle       []string               `json:"cycle"`
+		Child       json.RawMessage        `json:"~child"`
+		OptEstimate map[string]interface{} `json:"optimizer_estimates"`
+	}
+
+	var child_type struct {
+		Operator string `json:"#operator"`
+	}
+
+	err := json.Unmarshal(body, &_unmarshalled)
+	if err != nil {
+		return err
+	}
+
+	expr, err := parser.Parse(_unmarshalled.Expr)
+	if err != nil {
+		return err
+	}
+	subquery, ok := expr.(*algebra.Subquery)
+	if !ok {
+		return fmt.Errorf("RecursiveWith: %s is not a subquery", _unmarshalled.Expr)
+	}
+
+	var options expression.Expression
+	if _unmarshalled.Options != "" {
+		options, err = parser.Parse(_unmarshalled.Options)
+		if err != nil {
+			return err
+		}
+	}
+
+	var cycle expression.Expressions
+	if len(_unmarshalled.Cycle) > 0 {
+		cycle = make(expression.Expressions, len(_unmarshalled.Cycle))
+		for i, s := range _unmarshalled.Cycle {
+			cycle[i], err = parser.Parse(s)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	this.with, err = algebra.NewRecursiveWith(_unmarshalled.Alias, subquery.Select(), options, cycle)
+	if err != nil {
+		return err
+	}
+
+	err = json.Unmarshal(_unmarshalled.Child, &child_type)
+	if err != nil {
+		return err
+	}
+	this.child, err = MakeOperator(child_type.Operator, _unmarshalled.Child)
+	if err != nil {
+		return err
+	}
+
+	unmarshalOptEstimate(&this.optEstimate, _unmarshalled.OptEstimate)
+
+	return nil
+}
@@ -83,6 +83,7 @@ const (
 	BUILDER_HAS_GROUP
 	BUILDER_HAS_ORDER
 	BUILDER_HAS_WINDOW_AGGS
+	BUILDER_MERGE_NEST // right-hand side of a merge nest
 )
 
 type builder struct {
@@ -249,6 +250,10 @@ func (this *builder) setBuilderFlag(flag uint32) {
 	this.builderFlags |= flag
 }
 
+func (this *builder) unsetBuilderFlag(flag uint32) {
+	this.builderFlags &^= flag
+}
+
 func (this *builder) collectKeyspaceNames() {
 	if len(this.keyspaceNames) > 0 || len(this.baseKeyspaces) == 0 {
 		return
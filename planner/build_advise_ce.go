@@ -12,67 +12,447 @@
 package planner
 
 import (
-	"fmt"
-
 	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/virtual"
 	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/plan"
 	base "github.com/couchbase/query/plannerbase"
 )
 
-type collectQueryInfo struct {
+const (
+	_RECOMMEND = iota
+	_VALIDATE
+)
+
+var pushdownMap = map[PushDownProperties]string{
+	_PUSHDOWN_LIMIT:         "LIMIT pushdown",
+	_PUSHDOWN_OFFSET:        "OFFSET pushdown",
+	_PUSHDOWN_ORDER:         "ORDER pushdown",
+	_PUSHDOWN_GROUPAGGS:     "GROUPBY & AGGREGATES pushdown",
+	_PUSHDOWN_FULLGROUPAGGS: "FULL GROUPBY & AGGREGATES pushdown",
 }
 
+const _ADVISE_COVERING = "FULLY COVERING"
+
+/*
+ADVISE plans the statement twice or more.
+The recommend phase collects the predicates of every keyspace term, and the
+indexes the statement currently uses; the validate phases plan the statement
+again with the recommended indexes as virtual indexes, keeping those the
+planner chooses.
+*/
 func (this *builder) VisitAdvise(stmt *algebra.Advise) (interface{}, error) {
-	return nil, fmt.Errorf("Not supported in CE version. Use https://index-advisor.couchbase.com")
+	this.collectQueryInfo = collectQueryInfo{}
+	this.setAdvisePhase(_RECOMMEND)
+
+	// rule-based recommendation
+	considerCBO := this.useCBO
+	this.useCBO = false
+
+	this.maxParallelism = 1
+	op, _ := stmt.Statement().Accept(this)
+	if op, ok := op.(plan.Operator); ok {
+		this.collectScans(op)
+	}
+
+	recIdxes, coverIdxes := this.adviseCandidates()
+
+	this.setAdvisePhase(_VALIDATE)
+	this.useCBO = considerCBO
+	this.pushDownPropMap = make(map[datastore.Index]PushDownProperties, len(coverIdxes))
+	this.validateCandidates(stmt, recIdxes)
+	this.validateCandidates(stmt, coverIdxes)
+
+	var rec, cover plan.AdvisedIndexes
+	for _, c := range recIdxes {
+		if c.valid {
+			rec = append(rec, c.advice)
+		}
+	}
+	for _, c := range coverIdxes {
+		if c.valid {
+			c.advice.Property = pushdownProperty(this.pushDownPropMap[c.index])
+			cover = append(cover, c.advice)
+		}
+	}
+
+	return plan.NewAdvise(plan.NewIndexAdvice(this.curIndexes, rec, cover), stmt.Query()), nil
+}
+
+type collectQueryInfo struct {
+	advisePhase     int
+	queryInfo       *adviseQueryInfo
+	keyspaceInfos   []*adviseKeyspaceInfo
+	curIndexes      plan.AdvisedIndexes
+	candidates      map[datastore.Index]*adviseCandidate
+	idxCandidates   []datastore.Index
+	pushDownPropMap map[datastore.Index]PushDownProperties
+}
+
+// a recommended index, and the virtual index validating it
+type adviseCandidate struct {
+	index    datastore.Index
+	path     string
+	aliases  map[string]bool
+	covering bool
+	valid    bool
+	advice   *plan.AdvisedIndex
+}
+
+func (this *builder) setAdvisePhase(op int) {
+	this.indexAdvisor = true
+	this.advisePhase = op
+}
+
+func (this *builder) recommending() bool {
+	return this.indexAdvisor && this.advisePhase == _RECOMMEND && this.queryInfo != nil
+}
+
+/*
+The recommended indexes for the collected predicates, with and without the
+keys to cover their query blocks.
+*/
+func (this *builder) adviseCandidates() (recIdxes, coverIdxes []*adviseCandidate) {
+	this.candidates = make(map[datastore.Index]*adviseCandidate, 2*len(this.keyspaceInfos))
+	apiVersion := this.context.IndexApiVersion()
+
+	for _, info := range this.keyspaceInfos {
+		if !info.queryInfo.keyspaceFound {
+			continue
+		}
+
+		keys := info.indexKeys()
+		if len(keys) == 0 {
+			continue
+		}
+		rule := keys.rule()
+
+		array := false
+		for _, k := range keys {
+			if _, ok := k.key.(*expression.All); ok {
+				array = true
+			}
+		}
+
+		coverKeys, covering := info.coverKeys(keys)
+		covering = covering && !array && info.joinKey == nil
+		if covering {
+			exprs := append(keys.exprs(), coverKeys.exprs()...)
+			if !hasIndexKeys(info.keyspace, exprs, apiVersion) {
+				coverIdxes = this.addCandidate(coverIdxes, info, exprs, rule, true)
+			}
+			if len(coverKeys) == 0 {
+				continue
+			}
+		}
+
+		exprs := keys.exprs()
+		if !hasIndexKeys(info.keyspace, exprs, apiVersion) {
+			recIdxes = this.addCandidate(recIdxes, info, exprs, rule, false)
+		}
+	}
+	return
+}
+
+func (this *builder) addCandidate(candidates []*adviseCandidate, info *adviseKeyspaceInfo,
+	keys expression.Expressions, rule string, covering bool) []*adviseCandidate {
+
+	name := adviseIndexName(keys)
+	statement := adviseIndexStatement(name, info.path, keys)
+	for _, c := range candidates {
+		if c.advice.Statement != statement {
+			continue
+		}
+		if !c.aliases[info.alias] {
+			c.aliases[info.alias] = true
+			c.advice.Aliases = append(c.advice.Aliases, info.name)
+			c.advice.Rules = append(c.advice.Rules, rule)
+		}
+		return candidates
+	}
+
+	c := &adviseCandidate{
+		index:    virtual.NewVirtualIndex(info.keyspace, name, nil, keys, nil, nil, false, "", nil),
+		path:     info.path,
+		aliases:  map[string]bool{info.alias: true},
+		covering: covering,
+		advice: &plan.AdvisedIndex{
+			Statement: statement,
+			Aliases:   []string{info.name},
+			Rules:     []string{rule},
+		},
+	}
+	this.candidates[c.index] = c
+	return append(candidates, c)
+}
+
+// plan the statement with the candidates as virtual indexes
+func (this *builder) validateCandidates(stmt *algebra.Advise, candidates []*adviseCandidate) {
+	if len(candidates) == 0 {
+		return
+	}
+
+	this.idxCandidates = make([]datastore.Index, len(candidates))
+	for i, c := range candidates {
+		this.idxCandidates[i] = c.index
+	}
+
+	op, _ := stmt.Statement().Accept(this)
+	if op, ok := op.(plan.Operator); ok {
+		this.collectScans(op)
+	}
+	this.idxCandidates = nil
+}
+
+func pushdownProperty(property PushDownProperties) string {
+	var rv string
+	for set := _PUSHDOWN_FULLGROUPAGGS; set > _PUSHDOWN_EXACTSPANS; set >>= 1 {
+		if isPushDownProperty(property, set) {
+			if len(rv) > 0 {
+				rv += ", "
+			}
+			rv += pushdownMap[set]
+		}
+	}
+	return rv
+}
+
+// note the indexes the scans of a plan use
+func (this *builder) collectScans(op plan.Operator) {
+	switch op := op.(type) {
+	case nil:
+	case *plan.IndexJoin:
+		this.collectScan(op.Index(), op.Term(), op.Covering())
+	case *plan.IndexNest:
+		this.collectScan(op.Index(), op.Term(), false)
+	case *plan.DistinctScan:
+		this.collectScans(op.Scan())
+	case interface{ Scans() []plan.SecondaryScan }:
+		for _, scan := range op.Scans() {
+			this.collectScans(scan)
+		}
+	case interface {
+		GetIndex() datastore.Index
+		Term() *algebra.KeyspaceTerm
+	}:
+		covering := false
+		if scan, ok := op.(plan.CoveringOperator); ok {
+			covering = scan.Covering()
+		}
+		this.collectScan(op.GetIndex(), op.Term(), covering)
+	case interface{ Children() []plan.Operator }:
+		for _, child := range op.Children() {
+			this.collectScans(child)
+		}
+	case interface{ Child() plan.Operator }:
+		this.collectScans(op.Child())
+	case interface {
+		First() plan.Operator
+		Second() plan.Operator
+	}:
+		this.collectScans(op.First())
+		this.collectScans(op.Second())
+	}
+}
+
+func (this *builder) collectScan(index datastore.Index, term *algebra.KeyspaceTerm, covering bool) {
+	if index == nil || term == nil || term.Path() == nil {
+		return
+	}
+
+	if index.Type() == datastore.VIRTUAL {
+		if this.advisePhase != _VALIDATE {
+			return
+		}
+		c, ok := this.candidates[index]
+		if ok && c.aliases[term.Alias()] && c.path == advisePath(term.Path()) && (covering || !c.covering) {
+			c.valid = true
+		}
+		return
+	}
+
+	if this.advisePhase != _RECOMMEND || algebra.IsSystem(term.Path().Namespace()) {
+		return
+	}
+
+	statement := indexStatement(index, advisePath(term.Path()))
+	name := adviseKeyspaceName(term)
+	status := ""
+	if covering {
+		status = _ADVISE_COVERING
+	}
+	for _, cur := range this.curIndexes {
+		if cur.Statement == statement && cur.Status == status {
+			for _, alias := range cur.Aliases {
+				if alias == name {
+					return
+				}
+			}
+			cur.Aliases = append(cur.Aliases, name)
+			return
+		}
+	}
+	this.curIndexes = append(this.curIndexes, &plan.AdvisedIndex{
+		Statement: statement,
+		Aliases:   []string{name},
+		Status:    status,
+	})
 }
 
 func (this *builder) initialIndexAdvisor(stmt algebra.Statement) {
+	if this.indexAdvisor && this.advisePhase == _RECOMMEND && stmt != nil {
+		this.queryInfo = newAdviseQueryInfo(stmt.Type())
+	}
 }
 
 func (this *builder) extractKeyspacePredicates(where, on expression.Expression) {
+	if this.recommending() {
+		this.queryInfo.addExprs(where, on)
+	}
 }
 
 func (this *builder) extractIndexJoin(index datastore.Index, keyspace datastore.Keyspace, node *algebra.KeyspaceTerm, cover bool, cost, cardinality float64) {
+	if this.indexAdvisor {
+		this.collectScan(index, node, cover)
+	}
 }
 
 func (this *builder) appendQueryInfo(scan plan.Operator, keyspace datastore.Keyspace, node *algebra.KeyspaceTerm, uncovered bool) {
+	if this.indexAdvisor {
+		this.collectScans(scan)
+	}
 }
 
 func (this *builder) enableUnnest(alias string) {
+	if this.recommending() && this.queryInfo.unnest {
+		this.queryInfo.unnests = adviseUnnests(this.from, alias)
+	}
 }
 
 func (this *builder) collectPredicates(baseKeyspace *base.BaseKeyspace, keyspace datastore.Keyspace, node *algebra.KeyspaceTerm, pred expression.Expression, ansijoin bool) error {
+	if !this.recommending() || keyspace == nil {
+		return nil
+	}
+	// no indexes are recommended on system keyspaces
+	if algebra.IsSystem(keyspace.NamespaceId()) {
+		return nil
+	}
+	if baseKeyspace == nil {
+		baseKeyspace = this.baseKeyspaces[node.Alias()]
+		if baseKeyspace == nil {
+			return nil
+		}
+	}
+
+	if pred != nil {
+		// predicates of an index join
+		baseKeyspaces := base.CopyBaseKeyspaces(this.baseKeyspaces)
+		_, err := ClassifyExpr(pred, baseKeyspaces, this.keyspaceNames, false, false, false, this.context)
+		if err != nil {
+			return err
+		}
+		kspace := baseKeyspaces[node.Alias()]
+		info := newAdviseKeyspaceInfo(keyspace, node, kspace.Filters(), kspace.JoinFilters(), this.queryInfo)
+		info.joinKey = node.JoinKeys()
+		this.keyspaceInfos = append(this.keyspaceInfos, info)
+		return nil
+	}
+
+	or, ok := baseKeyspace.DnfPred().(*expression.Or)
+	if ok {
+		or, _ = expression.FlattenOr(or)
+	} else {
+		this.keyspaceInfos = append(this.keyspaceInfos, newAdviseKeyspaceInfo(keyspace, node,
+			baseKeyspace.Filters(), baseKeyspace.JoinFilters(), this.queryInfo))
+		return nil
+	}
+
+	// an index for every disjunct
+	for _, disjunct := range or.Operands() {
+		baseKeyspaces := base.CopyBaseKeyspaces(this.baseKeyspaces)
+		_, err := ClassifyExpr(disjunct, baseKeyspaces, this.keyspaceNames, false, false, false, this.context)
+		if err != nil {
+			return err
+		}
+		kspace := baseKeyspaces[node.Alias()]
+		this.keyspaceInfos = append(this.keyspaceInfos, newAdviseKeyspaceInfo(keyspace, node,
+			kspace.Filters(), baseKeyspace.JoinFilters(), this.queryInfo))
+	}
 	return nil
 }
 
 func (this *builder) setUnnest() {
+	if this.recommending() {
+		this.queryInfo.unnest = true
+	}
 }
 
 func (this *builder) setKeyspaceFound() {
+	if this.recommending() {
+		this.queryInfo.keyspaceFound = true
+	}
 }
 
 func (this *builder) processadviseJF(alias string) {
+	// join filters are collected with the other predicates of the keyspace
 }
 
 func (this *builder) extractLetGroupProjOrder(let expression.Bindings, group *algebra.Group, projection *algebra.Projection, order *algebra.Order, aggs algebra.Aggregates) {
+	if !this.recommending() {
+		return
+	}
+
+	q := this.queryInfo
+	if projection != nil {
+		// every branch of a set operation is a query block of its own
+		if q.projected {
+			q = newAdviseQueryInfo(q.stmtType)
+			this.queryInfo = q
+		}
+		q.projected = true
+		for _, term := range projection.Terms() {
+			if term.Star() {
+				q.star = true
+			}
+			q.addExprs(term.Expression())
+		}
+	}
+	if let != nil {
+		q.addExprs(let.Expressions()...)
+	}
+	if group != nil {
+		q.addExprs(group.By()...)
+		if group.Letting() != nil {
+			q.addExprs(group.Letting().Expressions()...)
+		}
+		q.addExprs(group.Having())
+	}
+	if order != nil {
+		q.addExprs(order.Expressions()...)
+	}
 }
 
 func (this *builder) storeCollectQueryInfo() *collectQueryInfo {
-	return nil
+	return &collectQueryInfo{
+		queryInfo: this.queryInfo,
+	}
 }
 
 func (this *builder) restoreCollectQueryInfo(info *collectQueryInfo) {
+	this.queryInfo = info.queryInfo
 }
 
 func (this *builder) collectPushdownProperty(index datastore.Index, alias string, property PushDownProperties) {
+	if this.indexAdvisor && this.advisePhase == _VALIDATE && index.Type() == datastore.VIRTUAL {
+		this.pushDownPropMap[index] = property
+	}
 }
 
 func (this *builder) getIdxCandidates() []datastore.Index {
-	return nil
+	return this.idxCandidates
 }
 
 func (this *builder) advisorValidate() bool {
-	return false
+	return this.indexAdvisor && this.advisePhase == _VALIDATE
 }
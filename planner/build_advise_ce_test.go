@@ -0,0 +1,252 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+//
+// +build !enterprise
+
+package planner
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/auth"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/mock"
+	"github.com/couchbase/query/datastore/virtual"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/parser/n1ql"
+	"github.com/couchbase/query/plan"
+	"github.com/couchbase/query/value"
+)
+
+// the mock keyspaces only have a primary index
+func adviseStore(t *testing.T) datastore.Datastore {
+	store, err := mock.NewDatastore("mock:")
+	if err != nil {
+		t.Fatalf("Failed to create the datastore: %v", err)
+	}
+	datastore.SetDatastore(store)
+	n1ql.SetNamespaces(map[string]interface{}{"p0": true})
+	return store
+}
+
+func adviseBuilder(store datastore.Datastore) *builder {
+	context := &PrepareContext{}
+	NewPrepareContext(context, "", "", nil, nil, datastore.INDEX_API_MAX, 0, false, false, nil, nil,
+		datastore.NULL_QUERY_CONTEXT)
+	return newBuilder(store, nil, "p0", false, context)
+}
+
+func advise(t *testing.T, store datastore.Datastore, stmt string) *plan.Advise {
+	s, err := n1ql.ParseStatement2(stmt, "p0", "")
+	if err != nil {
+		t.Fatalf("Failed to parse %v: %v", stmt, err)
+	}
+	op, err := s.Accept(adviseBuilder(store))
+	if err != nil {
+		t.Fatalf("Failed to plan %v: %v", stmt, err)
+	}
+	return op.(*plan.Advise)
+}
+
+func adviseStatements(indexes plan.AdvisedIndexes) []string {
+	var rv []string
+	for _, index := range indexes {
+		rv = append(rv, index.Statement)
+	}
+	return rv
+}
+
+func checkAdvice(t *testing.T, stmt string, indexes plan.AdvisedIndexes, expected ...string) {
+	actual := adviseStatements(indexes)
+	if strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("%v: expected %v, got %v", stmt, expected, actual)
+	}
+}
+
+func TestAdvisePredicates(t *testing.T) {
+	store := adviseStore(t)
+
+	for _, c := range []struct {
+		stmt  string
+		rec   []string
+		cover []string
+	}{
+		// keys follow the order of the predicate types
+		{"SELECT b.x FROM p0:b0 AS b WHERE b.c < 10 AND b.d IN [1, 2] AND b.a = 1",
+			[]string{"CREATE INDEX adv_a_d_c ON `b0`(`a`,`d`,`c`)"},
+			[]string{"CREATE INDEX adv_a_d_c_x ON `b0`(`a`,`d`,`c`,`x`)"}},
+
+		// whole documents can't be covered
+		{"SELECT * FROM p0:b0 AS b WHERE b.a = 1 AND b.e IS NOT NULL",
+			[]string{"CREATE INDEX adv_a_e ON `b0`(`a`,`e`)"},
+			nil},
+
+		// the index that covers is the only one recommended
+		{"SELECT b.a FROM p0:b0 AS b WHERE b.a LIKE 'x%'",
+			nil,
+			[]string{"CREATE INDEX adv_a ON `b0`(`a`)"}},
+
+		// an index for every disjunct
+		{"SELECT META(b).id FROM p0:b0 AS b WHERE b.a = 1 OR b.c = 2",
+			[]string{"CREATE INDEX adv_a ON `b0`(`a`)", "CREATE INDEX adv_c ON `b0`(`c`)"},
+			nil},
+
+		// nothing for the primary index to improve on
+		{"SELECT * FROM p0:b0 AS b", nil, nil},
+	} {
+		info := advise(t, store, "ADVISE "+c.stmt).Operator().(*plan.IndexAdvice).AdviceInfo()
+		checkAdvice(t, c.stmt, info.RecIndexes, c.rec...)
+		checkAdvice(t, c.stmt, info.CoverIndexes, c.cover...)
+		checkAdvice(t, c.stmt, info.CurIndexes, "CREATE PRIMARY INDEX #primary ON `b0`")
+	}
+}
+
+func TestAdviseArrays(t *testing.T) {
+	store := adviseStore(t)
+
+	for _, c := range []struct {
+		stmt string
+		rec  string
+	}{
+		{"SELECT * FROM p0:b0 AS b WHERE ANY v IN b.arr SATISFIES v.id = 1 END",
+			"CREATE INDEX adv_DISTINCT_arr_id ON `b0`(DISTINCT array (`v`.`id`) for `v` in `arr` end)"},
+		{"SELECT * FROM p0:b0 AS b WHERE ANY v IN b.arr SATISFIES v = 1 END AND b.a = 1",
+			"CREATE INDEX adv_DISTINCT_arr_a ON `b0`(DISTINCT array `v` for `v` in `arr` end,`a`)"},
+		{"SELECT * FROM p0:b0 AS b UNNEST b.arr AS u WHERE u.id = 1",
+			"CREATE INDEX adv_ALL_arr_id ON `b0`(ALL array (`u`.`id`) for `u` in `arr` end)"},
+		{"SELECT * FROM p0:b0 AS b UNNEST b.arr AS u WHERE u = 1",
+			"CREATE INDEX adv_ALL_arr ON `b0`(ALL `arr`)"},
+		{"SELECT * FROM p0:b0 AS b UNNEST b.arr AS u",
+			"CREATE INDEX adv_ALL_arr ON `b0`(ALL `arr`)"},
+	} {
+		info := advise(t, store, "ADVISE "+c.stmt).Operator().(*plan.IndexAdvice).AdviceInfo()
+		checkAdvice(t, c.stmt, info.RecIndexes, c.rec)
+		checkAdvice(t, c.stmt, info.CoverIndexes)
+	}
+}
+
+// recommendations only stand if the planner chooses their virtual index
+func TestAdviseValidate(t *testing.T) {
+	store := adviseStore(t)
+	namespace, _ := store.NamespaceByName("p0")
+	keyspace, _ := namespace.KeyspaceByName("b0")
+	stmt, err := n1ql.ParseStatement2("SELECT b.a FROM p0:b0 AS b", "p0", "")
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	term := stmt.(*algebra.Select).Subresult().(*algebra.Subselect).From().PrimaryTerm().(*algebra.KeyspaceTerm)
+
+	builder := adviseBuilder(store)
+	builder.setAdvisePhase(_VALIDATE)
+	info := &adviseKeyspaceInfo{keyspace: keyspace, alias: "b", path: "`b0`", name: "b0_b"}
+	keys := expression.Expressions{expression.NewIdentifier("a")}
+
+	builder.candidates = make(map[datastore.Index]*adviseCandidate)
+	candidates := builder.addCandidate(nil, info, keys, "", false)
+	covering := builder.addCandidate(nil, info, keys, "", true)
+	other := virtual.NewVirtualIndex(keyspace, "other", nil, keys, nil, nil, false, "", nil)
+
+	builder.collectScan(other, term, true)
+	builder.collectScan(candidates[0].index, term, false)
+	builder.collectScan(covering[0].index, term, false)
+	if !candidates[0].valid || covering[0].valid {
+		t.Errorf("Expected only the index used to be valid, and the covering index only if it covers")
+	}
+	builder.collectScan(covering[0].index, term, true)
+	if !covering[0].valid {
+		t.Errorf("Expected the covering index to be valid")
+	}
+
+	// the indexes used now are only collected while recommending
+	primary, _ := keyspace.Indexer(datastore.DEFAULT)
+	indexes, _ := primary.Indexes()
+	builder.collectScan(indexes[0], term, false)
+	if len(builder.curIndexes) != 0 {
+		t.Errorf("Expected no current index to be collected, got %v", adviseStatements(builder.curIndexes))
+	}
+	builder.setAdvisePhase(_RECOMMEND)
+	builder.collectScan(indexes[0], term, false)
+	builder.collectScan(indexes[0], term, false)
+	checkAdvice(t, "current", builder.curIndexes, "CREATE PRIMARY INDEX #primary ON `b0`")
+}
+
+// plans the ADVISE statements of the Advisor function, as the execution of a request would
+type adviseContext struct {
+	t     *testing.T
+	store datastore.Datastore
+}
+
+func (this *adviseContext) Now() time.Time                           { return time.Now() }
+func (this *adviseContext) GetTimeout() time.Duration                { return 0 }
+func (this *adviseContext) AuthenticatedUsers() []string             { return nil }
+func (this *adviseContext) Credentials() *auth.Credentials           { return nil }
+func (this *adviseContext) DatastoreVersion() string                 { return "" }
+func (this *adviseContext) Readonly() bool                           { return true }
+func (this *adviseContext) SetAdvisor()                              {}
+func (this *adviseContext) NewQueryContext(string, bool) interface{} { return this }
+func (this *adviseContext) EvaluateStatement(statement string, namedArgs map[string]value.Value,
+	positionalArgs value.Values, subquery, readonly bool) (value.Value, uint64, error) {
+	bytes, err := json.Marshal(advise(this.t, this.store, statement))
+	if err != nil {
+		return nil, 0, err
+	}
+	return value.NewValue([]interface{}{value.NewValue(bytes)}), 1, nil
+}
+
+// statements pulled from system:completed_requests are advised on together
+func TestAdviseWorkload(t *testing.T) {
+	context := &adviseContext{t: t, store: adviseStore(t)}
+	workload := value.NewValue([]interface{}{
+		"SELECT * FROM p0:b0 AS b WHERE b.a = 1",
+		map[string]interface{}{"statement": value.NewValue("SELECT * FROM p0:b0 AS b WHERE b.a = 1")},
+		"SELECT * FROM p0:b0 AS b WHERE b.a = 1 AND b.c > 2",
+	})
+
+	rv, err := expression.NewAdvisor(expression.NewConstant(workload)).Evaluate(nil, context)
+	if err != nil {
+		t.Fatalf("Failed to advise: %v", err)
+	}
+
+	var report struct {
+		Current     []map[string]interface{} `json:"current_used_indexes"`
+		Recommended []struct {
+			Index      string `json:"index"`
+			Statements []struct {
+				Statement string `json:"statement"`
+				Count     int    `json:"run_count"`
+			} `json:"statements"`
+		} `json:"recommended_indexes"`
+	}
+	bytes, _ := rv.MarshalJSON()
+	if err = json.Unmarshal(bytes, &report); err != nil {
+		t.Fatalf("Failed to unmarshal %s: %v", bytes, err)
+	}
+
+	if len(report.Current) != 1 || len(report.Recommended) != 2 {
+		t.Fatalf("Expected the primary index, and 2 recommendations, got %s", bytes)
+	}
+	for _, r := range report.Recommended {
+		switch r.Index {
+		case "CREATE INDEX adv_a ON `b0`(`a`)":
+			if len(r.Statements) != 1 || r.Statements[0].Count != 2 {
+				t.Errorf("Expected adv_a for a statement run twice, got %s", bytes)
+			}
+		case "CREATE INDEX adv_a_c ON `b0`(`a`,`c`)":
+			if len(r.Statements) != 1 || r.Statements[0].Count != 1 {
+				t.Errorf("Expected adv_a_c for a statement run once, got %s", bytes)
+			}
+		default:
+			t.Errorf("Unexpected recommendation %v", r.Index)
+		}
+	}
+}
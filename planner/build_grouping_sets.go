@@ -0,0 +1,90 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package planner
+
+import (
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/expression"
+)
+
+/*
+With grouping sets, group keys that are aggregated over are NULL in
+the groups of a set. Group keys are replaced by covers, which group
+operators set for each grouping set, everywhere past the grouping but
+inside aggregates, which are evaluated against the documents.
+*/
+type GroupingSetCoverer struct {
+	expression.MapperBase
+
+	covers []*expression.Cover
+}
+
+func NewGroupingSetCoverer(keys expression.Expressions) *GroupingSetCoverer {
+	rv := &GroupingSetCoverer{
+		covers: make([]*expression.Cover, len(keys)),
+	}
+
+	for i, key := range keys {
+		rv.covers[i] = expression.NewCover(key.Copy())
+	}
+
+	rv.SetMapper(rv)
+	rv.SetMapFunc(func(expr expression.Expression) (expression.Expression, error) {
+		if _, ok := expr.(*expression.Cover); ok {
+			return expr, nil
+		}
+
+		if agg, ok := expr.(algebra.Aggregate); ok && !agg.IsWindowAggregate() {
+			return expr, nil
+		}
+
+		for _, c := range rv.covers {
+			if c.Covered().EquivalentTo(expr) {
+				return c, nil
+			}
+		}
+
+		return expr, expr.MapChildren(rv)
+	})
+
+	return rv
+}
+
+func (this *GroupingSetCoverer) Covers() []*expression.Cover {
+	return this.covers
+}
+
+// Parameters
+
+func (this *GroupingSetCoverer) VisitNamedParameter(expr expression.NamedParameter) (interface{}, error) {
+	return expr, nil
+}
+
+func (this *GroupingSetCoverer) VisitPositionalParameter(expr expression.PositionalParameter) (interface{}, error) {
+	return expr, nil
+}
+
+func (this *builder) coverGroupingSets(node *algebra.Subselect, group *algebra.Group, order *algebra.Order) error {
+	coverer := NewGroupingSetCoverer(group.By())
+
+	err := node.Projection().MapExpressions(coverer)
+	if err != nil {
+		return err
+	}
+
+	if order != nil {
+		err = order.MapExpressions(coverer)
+		if err != nil {
+			return err
+		}
+	}
+
+	return group.MapGroupedExpressions(coverer)
+}
@@ -77,6 +77,23 @@ func (this *builder) buildAnsiJoinOp(node *algebra.AnsiJoin) (op plan.Operator,
 			return nil, err
 		}
 
+		// merge join is not costed, so without a join hint it is only used when CBO is off,
+		// in place of the nested-loop join that would be used otherwise
+		if right.PreferMerge() || (!useCBO && right.JoinHint() == algebra.JOIN_HINT_NONE) {
+			mjps := this.saveJoinPlannerState()
+			mjoin, err := this.buildMergeJoin(node, filter)
+			if err != nil {
+				return nil, err
+			}
+			if mjoin != nil {
+				return mjoin, nil
+			}
+			this.restoreJoinPlannerState(mjps)
+			if right.PreferMerge() {
+				node.SetHintError(algebra.USE_MERGE_NOT_FOLLOWED)
+			}
+		}
+
 		var hjoin *plan.HashJoin
 		var jps, hjps *joinPlannerState
 		var hjOnclause expression.Expression
@@ -223,6 +240,11 @@ func (this *builder) buildAnsiJoinOp(node *algebra.AnsiJoin) (op plan.Operator,
 			return nil, err
 		}
 
+		// merge join needs index scans on both sides
+		if right.PreferMerge() {
+			node.SetHintError(algebra.USE_MERGE_NOT_FOLLOWED)
+		}
+
 		if util.IsFeatureEnabled(this.context.FeatureControls(), util.N1QL_HASH_JOIN) {
 			// for expression term and subquery term, consider hash join
 			// even without USE HASH hint, as long as USE NL is not specified
@@ -283,6 +305,23 @@ func (this *builder) buildAnsiNestOp(node *algebra.AnsiNest) (op plan.Operator,
 			return nil, err
 		}
 
+		// merge nest is not costed, so without a join hint it is only used when CBO is off,
+		// in place of the nested-loop nest that would be used otherwise
+		if right.PreferMerge() || (!useCBO && right.JoinHint() == algebra.JOIN_HINT_NONE) {
+			mjps := this.saveJoinPlannerState()
+			mnest, err := this.buildMergeNest(node)
+			if err != nil {
+				return nil, err
+			}
+			if mnest != nil {
+				return mnest, nil
+			}
+			this.restoreJoinPlannerState(mjps)
+			if right.PreferMerge() {
+				node.SetHintError(algebra.USE_MERGE_NOT_FOLLOWED)
+			}
+		}
+
 		var hnest *plan.HashNest
 		var jps, hjps *joinPlannerState
 		var hnOnclause expression.Expression
@@ -407,6 +446,11 @@ func (this *builder) buildAnsiNestOp(node *algebra.AnsiNest) (op plan.Operator,
 			return nil, err
 		}
 
+		// merge join needs index scans on both sides
+		if right.PreferMerge() {
+			node.SetHintError(algebra.USE_MERGE_NOT_FOLLOWED)
+		}
+
 		if util.IsFeatureEnabled(this.context.FeatureControls(), util.N1QL_HASH_JOIN) {
 			// for expression term and subquery term, consider hash join
 			// even without USE HASH hint, as long as USE NL is not specified
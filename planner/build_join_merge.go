@@ -0,0 +1,255 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package planner
+
+import (
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/plan"
+)
+
+func (this *builder) buildMergeJoin(node *algebra.AnsiJoin, filter expression.Expression) (
+	mjoin *plan.MergeJoin, err error) {
+
+	child, outerKey, innerKey, newOnclause, newFilter, err := this.buildMergeJoinScan(node.Right(),
+		node.Onclause(), filter)
+	if err != nil || child == nil {
+		// cannot do merge join
+		return nil, err
+	}
+	node.SetOnclause(newOnclause)
+	return plan.NewMergeJoin(node, child, outerKey, innerKey, newFilter, OPT_COST_NOT_AVAIL,
+		OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL), nil
+}
+
+func (this *builder) buildMergeNest(node *algebra.AnsiNest) (mnest *plan.MergeNest, err error) {
+	// the nested values are arrays, which index keys only cover when they
+	// are not referenced outside the ON clause
+	if this.cover == nil {
+		return nil, nil
+	}
+	keyspaceNames := map[string]string{node.Alias(): ""}
+	for _, expr := range this.cover.Expressions() {
+		if expr != node.Onclause() && expression.HasKeyspaceReferences(expr, keyspaceNames) {
+			return nil, nil
+		}
+	}
+
+	this.setBuilderFlag(BUILDER_MERGE_NEST)
+	defer this.unsetBuilderFlag(BUILDER_MERGE_NEST)
+
+	child, outerKey, innerKey, newOnclause, _, err := this.buildMergeJoinScan(node.Right(),
+		node.Onclause(), nil)
+	if err != nil || child == nil {
+		// cannot do merge nest
+		return nil, err
+	}
+	node.SetOnclause(newOnclause)
+	return plan.NewMergeNest(node, child, outerKey, innerKey, nil, OPT_COST_NOT_AVAIL,
+		OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL), nil
+}
+
+/*
+A merge join needs both sides to come out of covering index scans, ordered
+on the two sides of an equality join predicate. The left-hand side must be
+a single keyspace, already planned; the right-hand side is planned here,
+independently of the left-hand side, as for hash join.
+
+Returns a nil child if merge join cannot be used.
+*/
+func (this *builder) buildMergeJoinScan(right algebra.SimpleFromTerm, onclause, filter expression.Expression) (
+	child plan.Operator, outerKey, innerKey, newOnclause, newFilter expression.Expression, err error) {
+
+	ksterm := algebra.GetKeyspaceTerm(right)
+	if ksterm == nil || ksterm.Keys() != nil || len(this.children) != 1 {
+		return
+	}
+
+	outerScan := this.children[0]
+	outerCover := mergeScanKey(outerScan)
+	if outerCover == nil {
+		return
+	}
+
+	alias := ksterm.Alias()
+	keyspaceNames := map[string]string{alias: ksterm.Keyspace()}
+
+	// look for equality join predicates on the leading key of the left-hand side index
+	baseKeyspace, _ := this.baseKeyspaces[alias]
+	innerExprs := make(expression.Expressions, 0, 4)
+	for _, fltr := range baseKeyspace.Filters() {
+		if !fltr.IsJoin() {
+			continue
+		}
+
+		if eqFltr, ok := fltr.FltrExpr().(*expression.Eq); ok {
+			firstRef := expression.HasKeyspaceReferences(eqFltr.First(), keyspaceNames)
+			secondRef := expression.HasKeyspaceReferences(eqFltr.Second(), keyspaceNames)
+
+			if firstRef && !secondRef && outerCover.EquivalentTo(eqFltr.Second()) {
+				innerExprs = append(innerExprs, eqFltr.First())
+			} else if !firstRef && secondRef && outerCover.EquivalentTo(eqFltr.First()) {
+				innerExprs = append(innerExprs, eqFltr.Second())
+			}
+		}
+	}
+
+	if len(innerExprs) == 0 {
+		return
+	}
+
+	// left hand side is already built, and must stay in order
+	if len(this.subChildren) > 0 {
+		this.addChildren(this.subChildren...)
+		this.subChildren = make([]plan.Operator, 0, 16)
+	}
+
+	// build right hand side
+
+	coveringScans := this.coveringScans
+	countScan := this.countScan
+	orderScan := this.orderScan
+	lastOp := this.lastOp
+	indexPushDowns := this.storeIndexPushDowns()
+	defer func() {
+		this.countScan = countScan
+		this.orderScan = orderScan
+		this.lastOp = lastOp
+		this.restoreIndexPushDowns(indexPushDowns, true)
+
+		if len(this.coveringScans) > 0 {
+			this.coveringScans = append(coveringScans, this.coveringScans...)
+		} else {
+			this.coveringScans = coveringScans
+		}
+	}()
+
+	this.coveringScans = nil
+	this.countScan = nil
+	this.order = nil
+	this.orderScan = nil
+	this.limit = nil
+	this.offset = nil
+	this.lastOp = nil
+
+	children := this.children
+	this.children = make([]plan.Operator, 0, 16)
+	this.subChildren = make([]plan.Operator, 0, 16)
+
+	// as for hash join, join filters cannot be used for index selection
+	ksterm.SetUnderHash()
+	defer ksterm.UnsetUnderHash()
+
+	_, err = right.Accept(this)
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+
+	var innerScan plan.Operator
+	var innerCover *expression.Cover
+	if len(this.children) == 1 {
+		innerScan = this.children[0]
+		innerCover = mergeScanKey(innerScan)
+	}
+
+	found := false
+	for _, expr := range innerExprs {
+		if innerCover != nil && innerCover.EquivalentTo(expr) {
+			found = true
+			break
+		}
+	}
+
+	if !found {
+		this.children = children
+		this.subChildren = make([]plan.Operator, 0, 16)
+		return nil, nil, nil, nil, nil, nil
+	}
+
+	this.addChildren(this.subChildren...)
+	child = plan.NewSequence(this.children...)
+	this.children = children
+	this.subChildren = make([]plan.Operator, 0, 16)
+
+	// perform cover transformation of the join keys, onclause and filter
+	outerCoverer := newMergeCoverer(outerScan)
+	innerCoverer := newMergeCoverer(innerScan)
+
+	outerKey = outerCover.Copy()
+	innerKey = innerCover.Copy()
+
+	newOnclause = onclause.Copy()
+	if filter != nil {
+		newFilter = filter.Copy()
+	}
+	for _, coverer := range []*expression.Coverer{innerCoverer, outerCoverer} {
+		if err == nil {
+			newOnclause, err = coverer.Map(newOnclause)
+		}
+		if err == nil && newFilter != nil {
+			newFilter, err = coverer.Map(newFilter)
+		}
+	}
+
+	if err != nil {
+		return nil, nil, nil, nil, nil, err
+	}
+
+	return child, outerKey, innerKey, newOnclause, newFilter, nil
+}
+
+/*
+The leading index key of a covering index scan, as long as the scan
+returns its entries in ascending order of that key.
+*/
+func mergeScanKey(op plan.Operator) *expression.Cover {
+	var covers expression.Covers
+	var index datastore.Index2
+	var spans plan.Spans2
+
+	switch scan := op.(type) {
+	case *plan.IndexScan3:
+		if scan.Reverse() || scan.Distinct() || scan.GroupAggs() != nil {
+			return nil
+		}
+		covers, index, spans = scan.Covers(), scan.Index(), scan.Spans()
+	case *plan.IndexScan2:
+		if scan.Reverse() || scan.Distinct() {
+			return nil
+		}
+		covers, index, spans = scan.Covers(), scan.Index(), scan.Spans()
+	default:
+		return nil
+	}
+
+	// entries from different spans or different partitions are not merged in order
+	if len(covers) == 0 || len(spans) > 1 {
+		return nil
+	}
+
+	if index3, ok := index.(datastore.Index3); ok {
+		partition, err := index3.PartitionKeys()
+		if err != nil || (partition != nil && partition.Strategy != datastore.NO_PARTITION) {
+			return nil
+		}
+	}
+
+	if keys := index.RangeKey2(); len(keys) > 0 && keys[0].HasAttribute(datastore.IK_DESC) {
+		return nil
+	}
+
+	return covers[0]
+}
+
+func newMergeCoverer(op plan.Operator) *expression.Coverer {
+	scan := op.(plan.CoveringOperator)
+	return expression.NewCoverer(scan.Covers(), scan.FilterCovers())
+}
@@ -129,7 +129,7 @@ func (this *builder) VisitMerge(stmt *algebra.Merge) (interface{}, error) {
 		switch join := join.(type) {
 		case *plan.NLJoin:
 			this.addSubChildren(join)
-		case *plan.Join, *plan.HashJoin:
+		case *plan.Join, *plan.HashJoin, *plan.MergeJoin:
 			if len(this.subChildren) > 0 {
 				this.addChildren(this.addSubchildrenParallel())
 			}
@@ -26,8 +26,8 @@ func (this *builder) buildCovering(indexes, flex map[datastore.Index]*indexEntry
 	node *algebra.KeyspaceTerm, baseKeyspace *base.BaseKeyspace, id expression.Expression,
 	searchSargables []*indexEntry) (scan plan.SecondaryScan, sargLength int, err error) {
 
-	// covering turrned off or ANSI NEST
-	if this.cover == nil || node.IsAnsiNest() {
+	// covering turrned off or ANSI NEST, other than for merge nest
+	if this.cover == nil || (node.IsAnsiNest() && !this.hasBuilderFlag(BUILDER_MERGE_NEST)) {
 		return
 	}
 
@@ -35,97 +35,32 @@ func (this *builder) visitFrom(node *algebra.Subselect, group *algebra.Group) er
 		this.from = node.From()
 		defer func() { this.from = prevFrom }()
 
-		// gather keyspace references
-		this.baseKeyspaces = make(map[string]*base.BaseKeyspace, _MAP_KEYSPACE_CAP)
-		primaryTerm := this.from.PrimaryTerm()
-		keyspaceFinder := newKeyspaceFinder(this.baseKeyspaces, primaryTerm.Alias())
-		_, err := node.From().Accept(keyspaceFinder)
+		err = this.processFrom(node)
 		if err != nil {
 			return err
 		}
-		this.pushableOnclause = keyspaceFinder.pushableOnclause
-		this.collectKeyspaceNames()
 
-		numUnnests := 0
-		for _, keyspace := range this.baseKeyspaces {
-			if keyspace.IsPrimaryUnnest() {
-				numUnnests++
-			}
-		}
-		if numUnnests > 0 {
-			primKeyspace, _ := this.baseKeyspaces[primaryTerm.Alias()]
-
-			// MB-38105 gather all unnest aliases for the primary keyspace
-			for _, keyspace := range this.baseKeyspaces {
-				if keyspace.IsPrimaryUnnest() {
-					primKeyspace.AddUnnestAlias(keyspace.Name(), keyspace.Keyspace(), numUnnests)
-				}
-			}
-
-		}
+		var op plan.Operator
 
-		// Process where clause and pushable on clause
-		if this.where != nil {
-			err = this.processWhere(this.where)
-			if err != nil {
-				return err
-			}
-		}
+		if this.useCBO && this.context.Optimizer() != nil {
+			optimizer := this.context.Optimizer()
+			optimizer.Initialize(this.Copy())
 
-		if this.pushableOnclause != nil {
-			if this.falseWhereClause() {
-				this.pushableOnclause = nil
-			} else {
-				constant, err := this.processPredicate(this.pushableOnclause, true)
+			// predicates were classified for the joins as written
+			if reorderer, ok := optimizer.(JoinReorderer); ok {
+				reordered, err := reorderer.ReorderJoins(node.From())
 				if err != nil {
 					return err
 				}
-				if constant != nil {
-					if constant.Truth() {
-						this.pushableOnclause = nil
-					} else {
-						// pushable on clause behaves like where clause
-						this.unsetTrueWhereClause()
-						this.setFalseWhereClause()
+				if reordered {
+					err = this.processFrom(node)
+					if err != nil {
+						return err
 					}
+					optimizer.Initialize(this.Copy())
 				}
 			}
-		}
-
-		// ANSI OUTER JOIN to INNER JOIN transformation
-		if !this.falseWhereClause() {
-			unnests := _UNNEST_POOL.Get()
-			defer _UNNEST_POOL.Put(unnests)
-			unnests = collectInnerUnnests(node.From(), unnests)
 
-			aoj2aij := newAnsijoinOuterToInner(this.baseKeyspaces, unnests)
-			_, err = node.From().Accept(aoj2aij)
-			if err != nil {
-				return err
-			}
-
-			if aoj2aij.pushableOnclause != nil {
-				// process on clauses from transformed inner joins
-				if this.pushableOnclause != nil {
-					this.pushableOnclause = expression.NewAnd(this.pushableOnclause, aoj2aij.pushableOnclause)
-				} else {
-					this.pushableOnclause = aoj2aij.pushableOnclause
-				}
-
-				_, err = this.processPredicate(aoj2aij.pushableOnclause, true)
-				if err != nil {
-					return err
-				}
-			}
-		}
-
-		this.extractKeyspacePredicates(this.where, nil)
-
-		var op plan.Operator
-
-		if this.useCBO && this.context.Optimizer() != nil {
-			optimizer := this.context.Optimizer()
-			optimizer.Initialize(this.Copy())
 			op, err = optimizer.OptimizeQueryBlock(node.From())
 			if err != nil {
 				return err
@@ -158,6 +93,100 @@ func (this *builder) visitFrom(node *algebra.Subselect, group *algebra.Group) er
 	return nil
 }
 
+/*
+Gather the keyspaces of the FROM clause, and classify the predicates of the
+WHERE clause and of the ON clauses by keyspace.
+*/
+func (this *builder) processFrom(node *algebra.Subselect) error {
+	// gather keyspace references
+	this.baseKeyspaces = make(map[string]*base.BaseKeyspace, _MAP_KEYSPACE_CAP)
+	primaryTerm := this.from.PrimaryTerm()
+	keyspaceFinder := newKeyspaceFinder(this.baseKeyspaces, primaryTerm.Alias())
+	_, err := node.From().Accept(keyspaceFinder)
+	if err != nil {
+		return err
+	}
+	this.pushableOnclause = keyspaceFinder.pushableOnclause
+	this.collectKeyspaceNames()
+
+	numUnnests := 0
+	for _, keyspace := range this.baseKeyspaces {
+		if keyspace.IsPrimaryUnnest() {
+			numUnnests++
+		}
+	}
+	if numUnnests > 0 {
+		primKeyspace, _ := this.baseKeyspaces[primaryTerm.Alias()]
+
+		// MB-38105 gather all unnest aliases for the primary keyspace
+		for _, keyspace := range this.baseKeyspaces {
+			if keyspace.IsPrimaryUnnest() {
+				primKeyspace.AddUnnestAlias(keyspace.Name(), keyspace.Keyspace(), numUnnests)
+			}
+		}
+
+	}
+
+	// Process where clause and pushable on clause
+	if this.where != nil {
+		err = this.processWhere(this.where)
+		if err != nil {
+			return err
+		}
+	}
+
+	if this.pushableOnclause != nil {
+		if this.falseWhereClause() {
+			this.pushableOnclause = nil
+		} else {
+			constant, err := this.processPredicate(this.pushableOnclause, true)
+			if err != nil {
+				return err
+			}
+			if constant != nil {
+				if constant.Truth() {
+					this.pushableOnclause = nil
+				} else {
+					// pushable on clause behaves like where clause
+					this.unsetTrueWhereClause()
+					this.setFalseWhereClause()
+				}
+			}
+		}
+	}
+
+	// ANSI OUTER JOIN to INNER JOIN transformation
+	if !this.falseWhereClause() {
+		unnests := _UNNEST_POOL.Get()
+		defer _UNNEST_POOL.Put(unnests)
+		unnests = collectInnerUnnests(node.From(), unnests)
+
+		aoj2aij := newAnsijoinOuterToInner(this.baseKeyspaces, unnests)
+		_, err = node.From().Accept(aoj2aij)
+		if err != nil {
+			return err
+		}
+
+		if aoj2aij.pushableOnclause != nil {
+			// process on clauses from transformed inner joins
+			if this.pushableOnclause != nil {
+				this.pushableOnclause = expression.NewAnd(this.pushableOnclause, aoj2aij.pushableOnclause)
+			} else {
+				this.pushableOnclause = aoj2aij.pushableOnclause
+			}
+
+			_, err = this.processPredicate(aoj2aij.pushableOnclause, true)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	this.extractKeyspacePredicates(this.where, nil)
+
+	return nil
+}
+
 func isValidXattrs(names []string) bool {
 	if len(names) > 2 {
 		return false
@@ -497,7 +526,7 @@ func (this *builder) VisitAnsiJoin(node *algebra.AnsiJoin) (interface{}, error)
 	switch join := join.(type) {
 	case *plan.NLJoin:
 		this.addSubChildren(join)
-	case *plan.Join, *plan.HashJoin:
+	case *plan.Join, *plan.HashJoin, *plan.MergeJoin:
 		if len(this.subChildren) > 0 {
 			this.addChildren(this.addSubchildrenParallel())
 		}
@@ -621,7 +650,7 @@ func (this *builder) VisitAnsiNest(node *algebra.AnsiNest) (interface{}, error)
 	switch nest := nest.(type) {
 	case *plan.NLNest:
 		this.addSubChildren(nest)
-	case *plan.Nest, *plan.HashNest:
+	case *plan.Nest, *plan.HashNest, *plan.MergeNest:
 		if len(this.subChildren) > 0 {
 			this.addChildren(this.addSubchildrenParallel())
 		}
@@ -217,6 +217,9 @@ func (this *builder) VisitSubselect(node *algebra.Subselect) (interface{}, error
 		this.resetOffsetLimit()
 	}
 
+	// ORDER BY is not pushed down with grouping sets, but still needs covering
+	stmtOrder := this.order
+
 	// Skip fixed values in ORDER BY
 	if this.order != nil && this.where != nil {
 		order := this.order
@@ -241,6 +244,13 @@ func (this *builder) VisitSubselect(node *algebra.Subselect) (interface{}, error
 		}
 	}
 
+	if group != nil && group.GroupingSets() != nil {
+		err = this.coverGroupingSets(node, group, stmtOrder)
+		if err != nil {
+			return nil, err
+		}
+	}
+
 	if this.aggs != nil {
 		aggs = this.aggs
 	}
@@ -320,20 +330,49 @@ func (this *builder) VisitSubselect(node *algebra.Subselect) (interface{}, error
 
 	// process with in a parent sequence
 	if node.With() != nil {
-		cost := OPT_COST_NOT_AVAIL
-		cardinality := OPT_CARD_NOT_AVAIL
-		size := OPT_SIZE_NOT_AVAIL
-		frCost := OPT_COST_NOT_AVAIL
-		if this.useCBO {
-			cost, cardinality, size, frCost = getWithCost(rv, node.With())
-		}
-		rv = plan.NewWith(node.With(), rv, cost, cardinality, size, frCost)
+		rv = buildWith(node.With(), rv, this.useCBO)
 		this.children = make([]plan.Operator, 0, 1)
 		this.addChildren(rv)
 	}
 	return rv, nil
 }
 
+/*
+Recursive common table expressions each get their own operator,
+consecutive non recursive ones share a With operator. Operators
+are nested so that the bindings are evaluated in order.
+*/
+func buildWith(with expression.Bindings, rv plan.Operator, useCBO bool) plan.Operator {
+	end := len(with)
+	for i := len(with) - 1; i >= -1; i-- {
+		var recursive *algebra.With
+		if i >= 0 {
+			recursive, _ = with[i].Expression().(*algebra.With)
+			if recursive == nil {
+				continue
+			}
+		}
+
+		if i+1 < end {
+			bindings := with[i+1 : end]
+			cost := OPT_COST_NOT_AVAIL
+			cardinality := OPT_CARD_NOT_AVAIL
+			size := OPT_SIZE_NOT_AVAIL
+			frCost := OPT_COST_NOT_AVAIL
+			if useCBO {
+				cost, cardinality, size, frCost = getWithCost(rv, bindings)
+			}
+			rv = plan.NewWith(bindings, rv, cost, cardinality, size, frCost)
+		}
+		if recursive != nil {
+			rv = plan.NewRecursiveWith(recursive, rv, OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL,
+				OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL)
+		}
+		end = i
+	}
+	return rv
+}
+
 func (this *builder) addLetAndPredicate(let expression.Bindings, pred expression.Expression) {
 	cost := OPT_COST_NOT_AVAIL
 	cardinality := OPT_CARD_NOT_AVAIL
@@ -412,12 +451,12 @@ func (this *builder) visitGroup(group *algebra.Group, aggs algebra.Aggregates) {
 			}
 		}
 		aggv := sortAggregatesSlice(aggs)
-		this.addSubChildren(plan.NewInitialGroup(group.By(), aggv,
+		this.addSubChildren(plan.NewInitialGroup(group.By(), aggv, group.GroupingSets(),
 			costInitial, cardinalityInitial, size, costInitial))
 		this.addChildren(this.addSubchildrenParallel())
-		this.addChildren(plan.NewIntermediateGroup(group.By(), aggv,
+		this.addChildren(plan.NewIntermediateGroup(group.By(), aggv, group.GroupingSets(),
 			costIntermediate, cardinalityIntermediate, size, costIntermediate))
-		this.addChildren(plan.NewFinalGroup(group.By(), aggv,
+		this.addChildren(plan.NewFinalGroup(group.By(), aggv, group.GroupingSets(),
 			costFinal, cardinalityFinal, size, costFinal))
 	}
 
@@ -874,6 +913,12 @@ func skipFixedOrderTerms(order *algebra.Order, pred expression.Expression) *alge
 func (this *builder) setIndexGroupAggs(group *algebra.Group, aggs algebra.Aggregates, let expression.Bindings) {
 
 	if group != nil {
+		// Grouping sets are not pushed to the index
+		if group.GroupingSets() != nil {
+			this.resetPushDowns()
+			return
+		}
+
 		// Group or Aggregates Depends on LET disable pushdowns
 		for _, expr := range group.By() {
 			if !expr.IndexAggregatable() || dependsOnLet(expr, let) {
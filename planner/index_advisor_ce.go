@@ -0,0 +1,695 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+//
+// +build !enterprise
+
+package planner
+
+import (
+	"fmt"
+	"hash/crc32"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	base "github.com/couchbase/query/plannerbase"
+)
+
+/*
+The community index advisor recommends secondary indexes from the predicates
+the planner classifies for each keyspace term.
+Index keys are ordered by the type of the predicate they serve, as listed in
+_ADVISE_RULES; ANY predicates and UNNEST give array index keys.
+A covering index adds the other fields the query block references on the
+keyspace term.
+*/
+
+// predicate types, in index key order
+const (
+	_ADVISE_UNNEST       = 1
+	_ADVISE_EQ           = 2
+	_ADVISE_IN           = 3
+	_ADVISE_RANGE_INCL   = 4
+	_ADVISE_RANGE        = 5
+	_ADVISE_DERIVED_JOIN = 6
+	_ADVISE_NOT_NULL     = 7
+	_ADVISE_LIKE         = 8
+	_ADVISE_JOIN         = 9
+)
+
+var _ADVISE_RULES = map[int]string{
+	_ADVISE_UNNEST:       "leading array index for unnest",
+	_ADVISE_EQ:           "equality/null/missing",
+	_ADVISE_IN:           "in",
+	_ADVISE_RANGE_INCL:   "not less than/between/not greater than",
+	_ADVISE_RANGE:        "less than/greater than",
+	_ADVISE_DERIVED_JOIN: "derived join filter as leading key",
+	_ADVISE_NOT_NULL:     "not null/not missing/valued",
+	_ADVISE_LIKE:         "like",
+	_ADVISE_JOIN:         "non-static join predicate",
+}
+
+const _ADVISE_MAX_NAME = 64
+
+// the query block a keyspace term belongs to
+type adviseQueryInfo struct {
+	stmtType      string
+	keyspaceFound bool
+	unnest        bool
+	unnests       map[string]*adviseUnnest
+	projected     bool
+	exprs         expression.Expressions // referenced by the block, for covering indexes
+	star          bool                   // the block projects whole documents
+}
+
+func newAdviseQueryInfo(stmtType string) *adviseQueryInfo {
+	return &adviseQueryInfo{
+		stmtType: stmtType,
+	}
+}
+
+func (this *adviseQueryInfo) addExprs(exprs ...expression.Expression) {
+	for _, expr := range exprs {
+		if expr != nil {
+			this.exprs = append(this.exprs, expr)
+		}
+	}
+}
+
+// an inner UNNEST, and the alias of the term it unnests
+type adviseUnnest struct {
+	expr   expression.Expression
+	parent string
+}
+
+// the predicates on a keyspace term, or on one disjunct of them
+type adviseKeyspaceInfo struct {
+	keyspace  datastore.Keyspace
+	alias     string
+	path      string
+	name      string
+	filters   expression.Expressions
+	joinFltrs expression.Expressions
+	joinKey   expression.Expression // leading key of an index join
+	innerJoin bool
+	unnests   map[string]*adviseUnnest
+	queryInfo *adviseQueryInfo
+}
+
+func newAdviseKeyspaceInfo(keyspace datastore.Keyspace, node *algebra.KeyspaceTerm,
+	filters, joinFilters base.Filters, queryInfo *adviseQueryInfo) *adviseKeyspaceInfo {
+
+	// the filters may change as the statement is planned
+	rv := &adviseKeyspaceInfo{
+		keyspace:  keyspace,
+		alias:     node.Alias(),
+		path:      advisePath(node.Path()),
+		name:      adviseKeyspaceName(node),
+		filters:   make(expression.Expressions, 0, len(filters)),
+		joinFltrs: make(expression.Expressions, 0, len(joinFilters)),
+		innerJoin: node.IsAnsiJoinOp() || node.IsIndexJoinNest(),
+		unnests:   queryInfo.unnests,
+		queryInfo: queryInfo,
+	}
+	for _, fl := range filters {
+		rv.filters = append(rv.filters, fl.FltrExpr().Copy())
+	}
+	for _, fl := range joinFilters {
+		rv.joinFltrs = append(rv.joinFltrs, fl.FltrExpr().Copy())
+	}
+	return rv
+}
+
+// a key of a recommended index, and the type of the predicate it serves
+type adviseIndexKey struct {
+	key  expression.Expression
+	rank int
+}
+
+type adviseIndexKeys []*adviseIndexKey
+
+func (this adviseIndexKeys) exprs() expression.Expressions {
+	rv := make(expression.Expressions, len(this))
+	for i, k := range this {
+		rv[i] = k.key
+	}
+	return rv
+}
+
+func (this adviseIndexKeys) rule() string {
+	var ranks []string
+
+	seen := make(map[int]bool, len(this))
+	for _, k := range this {
+		if k.rank > 0 && !seen[k.rank] {
+			seen[k.rank] = true
+			ranks = append(ranks, strconv.Itoa(k.rank)+". "+_ADVISE_RULES[k.rank])
+		}
+	}
+	if len(ranks) == 0 {
+		return ""
+	}
+	return "Index keys follow order of predicate types: " + strings.Join(ranks, ", ") + "."
+}
+
+/*
+The keys of the index recommended for the term, in index key form, or nil if
+none of the predicates can use an index.
+*/
+func (this *adviseKeyspaceInfo) indexKeys() adviseIndexKeys {
+	var keys adviseIndexKeys
+
+	owners := this.owners()
+	for _, fl := range this.filters {
+		if key, rank := adviseKey(fl, owners); key != nil {
+			keys = this.addKey(keys, key, rank)
+		}
+	}
+
+	// join predicates only serve the inner term of a join, otherwise the keys
+	// they reference may still lead an index used for the join
+	if this.innerJoin || len(keys) == 0 {
+		for _, fl := range this.joinFltrs {
+			key, _ := adviseKey(fl, owners)
+			if key == nil {
+				continue
+			}
+			rank := _ADVISE_JOIN
+			if !this.innerJoin {
+				rank = _ADVISE_DERIVED_JOIN
+			}
+			keys = this.addKey(keys, key, rank)
+		}
+	}
+
+	sort.SliceStable(keys, func(i, j int) bool {
+		return keys[i].rank < keys[j].rank
+	})
+
+	// an index join needs an index leading with its ON KEY expression
+	var rv adviseIndexKeys
+	if this.joinKey != nil {
+		key, err := unqualifyKey(this.joinKey, this.alias)
+		if err != nil {
+			return nil
+		}
+		rv = append(rv, &adviseIndexKey{key: key})
+	}
+
+	// only one array index key per index, and none for index joins
+	array := this.joinKey != nil
+	for _, k := range keys {
+		if this.joinKey != nil && rv[0].key.EquivalentTo(k.key) {
+			continue
+		}
+		if _, ok := k.key.(*expression.All); ok {
+			if array {
+				continue
+			}
+			array = true
+		}
+		rv = append(rv, k)
+	}
+	return rv
+}
+
+// the names a predicate on the term may reference: the alias and its inner unnests
+func (this *adviseKeyspaceInfo) owners() map[string]string {
+	rv := make(map[string]string, 1+len(this.unnests))
+	rv[this.alias] = this.alias
+	for alias, _ := range this.unnests {
+		rv[alias] = alias
+	}
+	return rv
+}
+
+func (this *adviseKeyspaceInfo) addKey(keys adviseIndexKeys, key expression.Expression, rank int) adviseIndexKeys {
+	refs, err := expression.CountKeySpaces(key, this.owners())
+	if err != nil || len(refs) != 1 {
+		return keys
+	}
+
+	var owner string
+	for owner, _ = range refs {
+	}
+	if owner != this.alias {
+		key = this.unnestKey(key, owner)
+		if key == nil {
+			return keys
+		}
+		// inner unnests have no missing values, so these don't lead the index
+		if rank != _ADVISE_NOT_NULL {
+			rank = _ADVISE_UNNEST
+		}
+	} else {
+		// the array of an inner unnest is filtered on being an array; keys
+		// for predicates on the unnest alias come first
+		for alias, unnest := range this.unnests {
+			if unnest.parent == this.alias && unnest.expr.EquivalentTo(key) {
+				key = this.unnestKey(expression.NewIdentifier(alias), alias)
+				break
+			}
+		}
+	}
+
+	key, err = unqualifyKey(key, this.alias)
+	if err != nil {
+		return keys
+	}
+	for _, k := range keys {
+		if k.key.EquivalentTo(key) {
+			if rank < k.rank {
+				k.rank = rank
+			}
+			return keys
+		}
+	}
+	return append(keys, &adviseIndexKey{key: key, rank: rank})
+}
+
+/*
+An array index key for a key on an unnest alias, nesting an array for every
+level of UNNEST.
+*/
+func (this *adviseKeyspaceInfo) unnestKey(key expression.Expression, owner string) expression.Expression {
+	for owner != this.alias {
+		unnest, ok := this.unnests[owner]
+		if !ok {
+			return nil
+		}
+		if ident, ok := key.(*expression.Identifier); ok && ident.Identifier() == owner && unnest.parent == this.alias {
+			return expression.NewAll(unnest.expr.Copy(), false)
+		}
+		key = expression.NewArray(key,
+			expression.Bindings{expression.NewSimpleBinding(owner, unnest.expr.Copy())}, nil)
+		owner = unnest.parent
+	}
+	return expression.NewAll(key, false)
+}
+
+/*
+Additional keys for an index with keys to cover the query block on the term,
+or false if the block can't be covered.
+*/
+func (this *adviseKeyspaceInfo) coverKeys(keys adviseIndexKeys) (adviseIndexKeys, bool) {
+	q := this.queryInfo
+	if q.stmtType != "SELECT" || q.star || len(this.unnests) > 0 {
+		return nil, false
+	}
+
+	var rv adviseIndexKeys
+	aliases := map[string]string{this.alias: this.alias}
+
+	var cover func(expr expression.Expression) bool
+	cover = func(expr expression.Expression) bool {
+		if !expression.HasKeyspaceReferences(expr, aliases) {
+			return true
+		}
+		switch expr := expr.(type) {
+		case *expression.Identifier, *expression.Meta, expression.Subquery:
+			return false
+		case *expression.Field:
+			key := statsKey(expr, this.alias)
+			if key == nil {
+				break
+			}
+			if isMetaId(key) {
+				return true
+			}
+			root := key
+			for field, ok := root.(*expression.Field); ok; field, ok = root.(*expression.Field) {
+				root = field.First()
+			}
+			if _, ok := root.(*expression.Meta); ok {
+				return false
+			}
+
+			for _, k := range keys {
+				if k.key.EquivalentTo(key) {
+					return true
+				}
+			}
+			for _, k := range rv {
+				if k.key.EquivalentTo(key) {
+					return true
+				}
+			}
+			rv = append(rv, &adviseIndexKey{key: key})
+			return true
+		}
+
+		for _, child := range expr.Children() {
+			if !cover(child) {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, expr := range q.exprs {
+		if !cover(expr) {
+			return nil, false
+		}
+	}
+	return rv, true
+}
+
+/*
+The key a predicate can use an index on, in the form of the predicate, and the
+type of the predicate. The key must reference one of owners, and the value it
+is compared to none of them.
+*/
+func adviseKey(pred expression.Expression, owners map[string]string) (expression.Expression, int) {
+	switch pred := pred.(type) {
+	case *expression.Eq:
+		return comparedKey(pred.First(), pred.Second(), owners), _ADVISE_EQ
+	case *expression.LE:
+		return comparedKey(pred.First(), pred.Second(), owners), _ADVISE_RANGE_INCL
+	case *expression.LT:
+		return comparedKey(pred.First(), pred.Second(), owners), _ADVISE_RANGE
+	case *expression.In:
+		if !expression.HasKeyspaceReferences(pred.Second(), owners) {
+			return indexableKey(pred.First(), owners), _ADVISE_IN
+		}
+	case *expression.Like:
+		if !expression.HasKeyspaceReferences(pred.Second(), owners) {
+			return indexableKey(pred.First(), owners), _ADVISE_LIKE
+		}
+	case *expression.IsNull:
+		return indexableKey(pred.Operand(), owners), _ADVISE_EQ
+	case *expression.IsMissing:
+		return indexableKey(pred.Operand(), owners), _ADVISE_EQ
+	case *expression.IsNotNull:
+		return indexableKey(pred.Operand(), owners), _ADVISE_NOT_NULL
+	case *expression.IsNotMissing:
+		return indexableKey(pred.Operand(), owners), _ADVISE_NOT_NULL
+	case *expression.IsValued:
+		return indexableKey(pred.Operand(), owners), _ADVISE_NOT_NULL
+	case *expression.And:
+		var rv expression.Expression
+		rank := 0
+		for _, op := range pred.Operands() {
+			key, r := adviseKey(op, owners)
+			if key != nil && (rv == nil || r < rank) {
+				rv, rank = key, r
+			}
+		}
+		return rv, rank
+	case *expression.Any:
+		return arrayKey(pred.Bindings(), pred.Satisfies(), owners)
+	case *expression.AnyEvery:
+		return arrayKey(pred.Bindings(), pred.Satisfies(), owners)
+	}
+	return nil, 0
+}
+
+func comparedKey(first, second expression.Expression, owners map[string]string) expression.Expression {
+	if !expression.HasKeyspaceReferences(second, owners) {
+		return indexableKey(first, owners)
+	}
+	if !expression.HasKeyspaceReferences(first, owners) {
+		return indexableKey(second, owners)
+	}
+	return nil
+}
+
+func indexableKey(key expression.Expression, owners map[string]string) expression.Expression {
+	if key.Value() != nil || !key.Indexable() || !expression.HasKeyspaceReferences(key, owners) {
+		return nil
+	}
+	return key
+}
+
+// DISTINCT ARRAY key FOR bindings END, for ANY predicates
+func arrayKey(bindings expression.Bindings, satisfies expression.Expression, owners map[string]string) (
+	expression.Expression, int) {
+
+	vars := make(map[string]string, len(bindings))
+	for _, b := range bindings {
+		if b.NameVariable() != "" || b.Descend() || !expression.HasKeyspaceReferences(b.Expression(), owners) {
+			return nil, 0
+		}
+		vars[b.Variable()] = b.Variable()
+	}
+
+	key, rank := adviseKey(satisfies, vars)
+	if key == nil || expression.HasKeyspaceReferences(key, owners) {
+		return nil, 0
+	}
+	if all, ok := key.(*expression.All); ok {
+		key = all.Array()
+	}
+	return expression.NewAll(expression.NewArray(key.Copy(), bindings.Copy(), nil), true), rank
+}
+
+/*
+Index keys don't reference the keyspace, so t.a.b becomes a.b and meta(t)
+becomes meta().
+*/
+type keyUnqualifier struct {
+	expression.MapperBase
+
+	alias string
+}
+
+func unqualifyKey(key expression.Expression, alias string) (expression.Expression, error) {
+	rv := &keyUnqualifier{
+		alias: alias,
+	}
+
+	rv.SetMapper(rv)
+	rv.SetMapFunc(func(expr expression.Expression) (expression.Expression, error) {
+		switch expr := expr.(type) {
+		case *expression.Field:
+			if ident, ok := expr.First().(*expression.Identifier); ok && ident.Identifier() == rv.alias {
+				name, ok := expr.Second().(*expression.FieldName)
+				if !ok {
+					return nil, errors.NewPlanInternalError("Index advisor: dynamic field name " + expr.String())
+				}
+				return expression.NewIdentifier(name.Alias()), nil
+			}
+		case *expression.Meta:
+			if len(expr.Operands()) > 0 {
+				if ident, ok := expr.Operands()[0].(*expression.Identifier); ok && ident.Identifier() == rv.alias {
+					return expression.NewMeta(), nil
+				}
+			}
+		case *expression.Identifier:
+			if expr.Identifier() == rv.alias {
+				return nil, errors.NewPlanInternalError("Index advisor: document is not an index key")
+			}
+		}
+		return expr, expr.MapChildren(rv)
+	})
+
+	return rv.Map(key.Copy())
+}
+
+// the keyspace of a term, qualified by the alias if the alias differs
+func adviseKeyspaceName(node *algebra.KeyspaceTerm) string {
+	name := node.Path().Keyspace()
+	if name != node.Alias() {
+		name += "_" + node.Alias()
+	}
+	return name
+}
+
+// the path of a keyspace term, for index statements
+func advisePath(path *algebra.Path) string {
+	parts := make([]string, 0, len(path.Parts())-1)
+	for _, part := range path.Parts()[1:] {
+		parts = append(parts, "`"+part+"`")
+	}
+	return strings.Join(parts, ".")
+}
+
+func adviseKeyString(key expression.Expression) string {
+	stringer := expression.NewStringer()
+	if all, ok := key.(*expression.All); ok {
+		if all.Distinct() {
+			return "DISTINCT " + stringer.Visit(all.Array())
+		}
+		return "ALL " + stringer.Visit(all.Array())
+	}
+	return stringer.Visit(key)
+}
+
+/*
+Names of recommended indexes are made of their keys, as in adv_city_type,
+with a checksum replacing the tail of long names.
+Array keys are named after the array and the fields of its elements, as in
+adv_DISTINCT_schedule_day.
+*/
+func adviseIndexName(keys expression.Expressions) string {
+	var buf strings.Builder
+
+	buf.WriteString("adv")
+	for _, key := range keys {
+		for _, word := range adviseKeyWords(key) {
+			buf.WriteByte('_')
+			buf.WriteString(word)
+		}
+	}
+
+	name := buf.String()
+	if len(name) > _ADVISE_MAX_NAME {
+		name = name[:_ADVISE_MAX_NAME-10] + fmt.Sprintf("%010d", crc32.ChecksumIEEE([]byte(name)))
+	}
+	return name
+}
+
+func adviseKeyWords(key expression.Expression) []string {
+	stringer := expression.NewStringer()
+	all, ok := key.(*expression.All)
+	if !ok {
+		return nameWords(stringer.Visit(key), nil)
+	}
+
+	rv := []string{"ALL"}
+	if all.Distinct() {
+		rv[0] = "DISTINCT"
+	}
+	array, ok := all.Array().(*expression.Array)
+	if !ok {
+		return append(rv, nameWords(stringer.Visit(all.Array()), nil)...)
+	}
+	vars := make(map[string]bool, len(array.Bindings()))
+	for _, b := range array.Bindings() {
+		rv = append(rv, nameWords(stringer.Visit(b.Expression()), vars)...)
+		vars[b.Variable()] = true
+	}
+	return append(rv, nameWords(stringer.Visit(array.ValueMapping()), vars)...)
+}
+
+// the alphanumeric words of s, other than the variables
+func nameWords(s string, vars map[string]bool) []string {
+	words := strings.FieldsFunc(s, func(c rune) bool {
+		return !((c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9'))
+	})
+	rv := words[:0]
+	for _, word := range words {
+		if !vars[word] {
+			rv = append(rv, word)
+		}
+	}
+	return rv
+}
+
+func adviseIndexStatement(name, path string, keys expression.Expressions) string {
+	strs := make([]string, len(keys))
+	for i, key := range keys {
+		strs[i] = adviseKeyString(key)
+	}
+	return "CREATE INDEX " + name + " ON " + path + "(" + strings.Join(strs, ",") + ")"
+}
+
+// the statement creating an existing index
+func indexStatement(index datastore.Index, path string) string {
+	if index.IsPrimary() {
+		return "CREATE PRIMARY INDEX " + index.Name() + " ON " + path
+	}
+
+	var keys []string
+	if index2, ok := index.(datastore.Index2); ok {
+		for _, key := range index2.RangeKey2() {
+			s := adviseKeyString(key.Expr)
+			if key.HasAttribute(datastore.IK_DESC) {
+				s += " DESC"
+			}
+			keys = append(keys, s)
+		}
+	} else {
+		for _, key := range index.RangeKey() {
+			keys = append(keys, adviseKeyString(key))
+		}
+	}
+
+	rv := "CREATE INDEX " + index.Name() + " ON " + path + "(" + strings.Join(keys, ",") + ")"
+	if index3, ok := index.(datastore.Index3); ok {
+		partition, _ := index3.PartitionKeys()
+		if partition != nil && partition.Strategy != datastore.NO_PARTITION {
+			exprs := make([]string, len(partition.Exprs))
+			for i, expr := range partition.Exprs {
+				exprs[i] = adviseKeyString(expr)
+			}
+			rv += " PARTITION BY " + string(partition.Strategy) + "(" + strings.Join(exprs, ",") + ")"
+		}
+	}
+	if cond := index.Condition(); cond != nil {
+		rv += " WHERE " + expression.NewStringer().Visit(cond)
+	}
+	return rv
+}
+
+/*
+Whether an online index without a condition already leads with the keys, in
+which case it is not recommended again.
+*/
+func hasIndexKeys(keyspace datastore.Keyspace, keys expression.Expressions, indexApiVersion int) bool {
+	indexes, err := allIndexes(keyspace, nil, nil, indexApiVersion, false)
+	if nil != indexes {
+		defer _INDEX_POOL.Put(indexes)
+	}
+	if err != nil {
+		return false
+	}
+
+outer:
+	for _, index := range indexes {
+		rangeKeys := index.RangeKey()
+		if index.IsPrimary() || index.Condition() != nil || len(rangeKeys) < len(keys) {
+			continue
+		}
+		for i, key := range keys {
+			if !rangeKeys[i].EquivalentTo(key) {
+				continue outer
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// gather the inner unnests of a term, and the unnests of those
+func adviseUnnests(from algebra.FromTerm, alias string) map[string]*adviseUnnest {
+	var rv map[string]*adviseUnnest
+
+	aliases := map[string]string{alias: alias}
+	var collect func(term algebra.FromTerm)
+	collect = func(term algebra.FromTerm) {
+		join, ok := term.(algebra.JoinTerm)
+		if !ok {
+			return
+		}
+		collect(join.Left())
+
+		unnest, ok := join.(*algebra.Unnest)
+		if !ok || unnest.Outer() {
+			return
+		}
+		refs, err := expression.CountKeySpaces(unnest.Expression(), aliases)
+		if err != nil || len(refs) != 1 {
+			return
+		}
+		for parent, _ := range refs {
+			if rv == nil {
+				rv = make(map[string]*adviseUnnest, 1)
+			}
+			rv[unnest.Alias()] = &adviseUnnest{expr: unnest.Expression(), parent: parent}
+			aliases[unnest.Alias()] = unnest.Alias()
+		}
+	}
+
+	collect(from)
+	return rv
+}
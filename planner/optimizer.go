@@ -21,6 +21,15 @@ type Optimizer interface {
 	OptimizeQueryBlock(node algebra.Node) (plan.Operator, error)
 }
 
+/*
+Optimizers that rewrite the joins of the FROM clause in place, before it is
+planned. The builder classifies the keyspaces and predicates again if the
+joins have been reordered.
+*/
+type JoinReorderer interface {
+	ReorderJoins(node algebra.Node) (bool, error)
+}
+
 type Builder interface {
 	GetBaseKeyspaces() map[string]*base.BaseKeyspace
 	GetPrepareContext() *PrepareContext
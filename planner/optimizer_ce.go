@@ -0,0 +1,337 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+//
+// +build !enterprise
+
+package planner
+
+import (
+	"math"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/plan"
+	base "github.com/couchbase/query/plannerbase"
+)
+
+/*
+The community optimizer only chooses the join order.
+A chain of inner ANSI joins of keyspaces is reordered in place, so that the
+keyspaces producing the fewest intermediate rows are joined first; the
+builder then classifies the predicates again, and plans the reordered joins
+as usual, choosing the join methods and the indexes by cost.
+Joins with hints, ON KEYS joins, outer joins and nests keep the order of
+the statement, and so do joins that would be left without an ON clause.
+*/
+type optimizer struct {
+	baseKeyspaces map[string]*base.BaseKeyspace
+	context       *PrepareContext
+}
+
+func NewOptimizer() Optimizer {
+	return &optimizer{}
+}
+
+func (this *optimizer) Initialize(builder Builder) {
+	this.baseKeyspaces = builder.GetBaseKeyspaces()
+	this.context = builder.GetPrepareContext()
+}
+
+/*
+Always returns a nil operator, for the builder to plan the FROM clause.
+*/
+func (this *optimizer) OptimizeQueryBlock(node algebra.Node) (plan.Operator, error) {
+	return nil, nil
+}
+
+/*
+Returns true if the joins have been reordered.
+*/
+func (this *optimizer) ReorderJoins(node algebra.Node) (bool, error) {
+	joins, terms := innerJoinChain(node)
+	if len(joins) < 2 {
+		return false, nil
+	}
+
+	cards := make([]float64, len(terms))
+	for i, term := range terms {
+		baseKeyspace, ok := this.baseKeyspaces[term.Alias()]
+		if !ok || baseKeyspace.DocCount() < 0 {
+			return false, nil
+		}
+		cards[i] = math.Max(float64(baseKeyspace.DocCount()), 1.0)
+		for _, fl := range baseKeyspace.Filters() {
+			cards[i] *= this.filterSelec(fl)
+		}
+	}
+
+	joinFilters := this.joinFilters(terms)
+	best, bestCost := orderCost(identityOrder(len(terms)), cards, joinFilters)
+	for start := range terms {
+		order, cost := greedyOrder(start, cards, joinFilters)
+		if order != nil && cost < bestCost {
+			best, bestCost = order, cost
+		}
+	}
+
+	return reorderJoins(joins, terms, best), nil
+}
+
+func (this *optimizer) filterSelec(fl *base.Filter) float64 {
+	if fl.Selec() > 0.0 {
+		return fl.Selec()
+	}
+	sel, _ := exprSelec(fl.FltrExpr(), filterKeyspaces(fl), this.context)
+	return sel
+}
+
+/*
+The joins of a chain of inner ANSI joins, innermost first, and the terms
+joined, leftmost first, or nil if the FROM clause is not such a chain.
+*/
+func innerJoinChain(node algebra.Node) ([]*algebra.AnsiJoin, []*algebra.KeyspaceTerm) {
+	var joins []*algebra.AnsiJoin
+	var terms []*algebra.KeyspaceTerm
+
+	for {
+		join, ok := node.(*algebra.AnsiJoin)
+		if !ok {
+			break
+		}
+		if join.Outer() || !join.Pushable() || join.IsCorrelated() {
+			return nil, nil
+		}
+		term := reorderableTerm(join.Right())
+		if term == nil {
+			return nil, nil
+		}
+		joins = append(joins, join)
+		terms = append(terms, term)
+		node = join.Left()
+	}
+
+	term := reorderableTerm(node)
+	if term == nil {
+		return nil, nil
+	}
+	terms = append(terms, term)
+
+	for i, j := 0, len(joins)-1; i < j; i, j = i+1, j-1 {
+		joins[i], joins[j] = joins[j], joins[i]
+	}
+	for i, j := 0, len(terms)-1; i < j; i, j = i+1, j-1 {
+		terms[i], terms[j] = terms[j], terms[i]
+	}
+	return joins, terms
+}
+
+func reorderableTerm(node algebra.Node) *algebra.KeyspaceTerm {
+	term, ok := node.(*algebra.KeyspaceTerm)
+	if !ok || term.Keys() != nil || term.JoinHint() != algebra.JOIN_HINT_NONE || term.IsInCorrSubq() {
+		return nil
+	}
+	return term
+}
+
+// a join filter, by the positions of the terms it references
+type orderFilter struct {
+	terms    []int
+	selec    float64
+	onclause bool
+}
+
+func (this *optimizer) joinFilters(terms []*algebra.KeyspaceTerm) []*orderFilter {
+	pos := make(map[string]int, len(terms))
+	for i, term := range terms {
+		pos[term.Alias()] = i
+	}
+
+	seen := make(map[string]bool)
+	rv := make([]*orderFilter, 0, len(terms))
+	for _, term := range terms {
+		for _, fl := range this.baseKeyspaces[term.Alias()].JoinFilters() {
+			s := fl.FltrExpr().String()
+			if seen[s] {
+				continue
+			}
+			seen[s] = true
+
+			ofl := &orderFilter{selec: this.filterSelec(fl), onclause: fl.IsOnclause()}
+			for alias, _ := range filterKeyspaces(fl) {
+				if i, ok := pos[alias]; ok {
+					ofl.terms = append(ofl.terms, i)
+				}
+			}
+			if len(ofl.terms) > 1 {
+				rv = append(rv, ofl)
+			}
+		}
+	}
+	return rv
+}
+
+// whether a filter applies once next joins the terms in joined
+func (this *orderFilter) appliesTo(joined []bool, next int) bool {
+	hasNext := false
+	for _, t := range this.terms {
+		if t == next {
+			hasNext = true
+		} else if !joined[t] {
+			return false
+		}
+	}
+	return hasNext
+}
+
+func identityOrder(n int) []int {
+	rv := make([]int, n)
+	for i := range rv {
+		rv[i] = i
+	}
+	return rv
+}
+
+// the sum of the intermediate results of an order
+func orderCost(order []int, cards []float64, filters []*orderFilter) ([]int, float64) {
+	joined := make([]bool, len(cards))
+	joined[order[0]] = true
+	card := cards[order[0]]
+	cost := 0.0
+	for _, next := range order[1:] {
+		card *= cards[next]
+		for _, fl := range filters {
+			if fl.appliesTo(joined, next) {
+				card *= fl.selec
+			}
+		}
+		joined[next] = true
+		cost += card
+	}
+	return order, cost
+}
+
+/*
+Starting from a term, repeatedly join the term joined by the ON-clauses that
+gives the fewest rows; nil if the ON-clauses don't join all the terms.
+*/
+func greedyOrder(start int, cards []float64, filters []*orderFilter) ([]int, float64) {
+	joined := make([]bool, len(cards))
+	joined[start] = true
+	order := []int{start}
+	card := cards[start]
+	cost := 0.0
+
+	for len(order) < len(cards) {
+		best := -1
+		bestCard := 0.0
+		for next := range cards {
+			if joined[next] {
+				continue
+			}
+			connected := false
+			nextCard := card * cards[next]
+			for _, fl := range filters {
+				if fl.appliesTo(joined, next) {
+					nextCard *= fl.selec
+					connected = connected || fl.onclause
+				}
+			}
+			if connected && (best < 0 || nextCard < bestCard) {
+				best, bestCard = next, nextCard
+			}
+		}
+		if best < 0 {
+			return nil, 0.0
+		}
+		joined[best] = true
+		order = append(order, best)
+		card = bestCard
+		cost += card
+	}
+	return order, cost
+}
+
+/*
+Rearrange the terms of the joins, keeping the join objects, and move every
+ON-clause term to the first join where its keyspaces are available.
+Nothing is changed, and false returned, if an ON-clause term can't be placed,
+or if a join would be left without an ON clause.
+*/
+func reorderJoins(joins []*algebra.AnsiJoin, terms []*algebra.KeyspaceTerm, order []int) bool {
+	reordered := false
+	for i, t := range order {
+		if i != t {
+			reordered = true
+			break
+		}
+	}
+	if !reordered {
+		return false
+	}
+
+	var conjuncts expression.Expressions
+	for _, join := range joins {
+		if and, ok := join.Onclause().(*expression.And); ok {
+			conjuncts = append(conjuncts, and.Operands()...)
+		} else {
+			conjuncts = append(conjuncts, join.Onclause())
+		}
+	}
+
+	pos := make(map[string]int, len(order))
+	for i, t := range order {
+		pos[terms[t].Alias()] = i
+	}
+	keyspaceNames := make(map[string]string, len(terms))
+	for _, term := range terms {
+		keyspaceNames[term.Alias()] = term.Alias()
+	}
+
+	onclauses := make([]expression.Expressions, len(joins))
+	for _, conjunct := range conjuncts {
+		refs, err := expression.CountKeySpaces(conjunct, keyspaceNames)
+		if err != nil {
+			return false
+		}
+		last := 1
+		for alias, _ := range refs {
+			if pos[alias] > last {
+				last = pos[alias]
+			}
+		}
+		onclauses[last-1] = append(onclauses[last-1], conjunct)
+	}
+	for _, onclause := range onclauses {
+		if len(onclause) == 0 {
+			return false
+		}
+	}
+
+	newFirst := terms[order[0]]
+	if oldFirst := terms[0]; oldFirst != newFirst {
+		newFirst.SetProperty(newFirst.Property() &^ algebra.TERM_ANSI_JOIN)
+		oldFirst.SetAnsiJoin()
+	}
+
+	for i, join := range joins {
+		if i == 0 {
+			join.SetLeft(newFirst)
+		} else {
+			join.SetLeft(joins[i-1])
+		}
+		join.SetRight(terms[order[i+1]])
+
+		if len(onclauses[i]) == 1 {
+			join.SetOnclause(onclauses[i][0])
+		} else {
+			join.SetOnclause(expression.NewAnd(onclauses[i]...))
+		}
+	}
+	return true
+}
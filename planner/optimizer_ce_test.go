@@ -0,0 +1,229 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+//
+// +build !enterprise
+
+package planner
+
+import (
+	"fmt"
+	"math"
+	"testing"
+	"time"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/parser/n1ql"
+	"github.com/couchbase/query/value"
+)
+
+func TestOrderCost(t *testing.T) {
+	cards := []float64{100, 10, 1000}
+	filters := []*orderFilter{
+		&orderFilter{terms: []int{0, 1}, selec: 0.01, onclause: true},
+		&orderFilter{terms: []int{1, 2}, selec: 0.001, onclause: true},
+	}
+
+	// 100 x 10 x 0.01, then 10 x 1000 x 0.001
+	if _, cost := orderCost([]int{0, 1, 2}, cards, filters); !closeTo(cost, 20) {
+		t.Errorf("Expected a cost of 20, got %v", cost)
+	}
+
+	// joining 0 and 2 first has no filter to apply
+	if _, cost := orderCost([]int{0, 2, 1}, cards, filters); !closeTo(cost, 100010) {
+		t.Errorf("Expected a cost of 100010, got %v", cost)
+	}
+}
+
+func TestGreedyOrder(t *testing.T) {
+	cards := []float64{100, 10, 1000}
+	filters := []*orderFilter{
+		&orderFilter{terms: []int{0, 1}, selec: 0.01, onclause: true},
+		&orderFilter{terms: []int{1, 2}, selec: 0.001, onclause: true},
+	}
+
+	// 2 is only joined to 1
+	order, cost := greedyOrder(2, cards, filters)
+	if fmt.Sprint(order) != "[2 1 0]" || !closeTo(cost, 20) {
+		t.Errorf("Expected [2 1 0] with a cost of 20, got %v with %v", order, cost)
+	}
+
+	// terms are only joined by ON-clause filters
+	filters[1].onclause = false
+	if order, _ = greedyOrder(2, cards, filters); order != nil {
+		t.Errorf("Expected no order without an ON clause to join 2, got %v", order)
+	}
+	if order, _ = greedyOrder(0, cards, filters[:1]); order != nil {
+		t.Errorf("Expected no order with 2 unconnected, got %v", order)
+	}
+}
+
+// a FROM clause of keyspaces, with the joins pushable as the keyspace finder would leave them
+func parseFrom(t *testing.T, from string) algebra.FromTerm {
+	n1ql.SetNamespaces(map[string]interface{}{"default": true})
+	stmt, err := n1ql.ParseStatement("SELECT * FROM " + from)
+	if err != nil {
+		t.Fatalf("Failed to parse %v: %v", from, err)
+	}
+	node := stmt.(*algebra.Select).Subresult().(*algebra.Subselect).From()
+	for n := algebra.FromTerm(node); ; {
+		join, ok := n.(*algebra.AnsiJoin)
+		if !ok {
+			break
+		}
+		join.SetPushable(true)
+		n = join.Left()
+	}
+	return node
+}
+
+func parseJoins(t *testing.T, from string) ([]*algebra.AnsiJoin, []*algebra.KeyspaceTerm) {
+	joins, terms := innerJoinChain(parseFrom(t, from))
+	if joins == nil {
+		t.Fatalf("Expected %v to be a chain of inner joins", from)
+	}
+	return joins, terms
+}
+
+func joinsString(joins []*algebra.AnsiJoin) string {
+	s := joins[0].Left().Alias()
+	for _, join := range joins {
+		s += fmt.Sprintf(" JOIN %v ON %v", join.Right().Alias(), join.Onclause())
+	}
+	return s
+}
+
+func TestReorderJoins(t *testing.T) {
+	from := "default:a JOIN default:b ON a.x = b.x JOIN default:c ON b.y = c.y AND a.z = c.z"
+
+	// ON-clause terms move to the first join that has their keyspaces
+	joins, terms := parseJoins(t, from)
+	if !reorderJoins(joins, terms, []int{2, 1, 0}) {
+		t.Fatalf("Expected the joins to be reordered")
+	}
+	expected := "c JOIN b ON ((`b`.`y`) = (`c`.`y`)) JOIN a ON (((`a`.`x`) = (`b`.`x`)) and ((`a`.`z`) = (`c`.`z`)))"
+	if s := joinsString(joins); s != expected {
+		t.Errorf("Expected %v, got %v", expected, s)
+	}
+	if !terms[0].IsAnsiJoin() || terms[2].IsAnsiJoin() {
+		t.Errorf("Expected the first term to change")
+	}
+
+	// the order of the statement is left alone
+	joins, terms = parseJoins(t, from)
+	before := joinsString(joins)
+	if reorderJoins(joins, terms, []int{0, 1, 2}) || joinsString(joins) != before {
+		t.Errorf("Expected the joins not to be reordered, got %v", joinsString(joins))
+	}
+
+	// joins are never left without an ON clause
+	joins, terms = parseJoins(t, "default:a JOIN default:b ON a.x = b.x JOIN default:c ON b.y = c.y")
+	before = joinsString(joins)
+	if reorderJoins(joins, terms, []int{0, 2, 1}) || joinsString(joins) != before {
+		t.Errorf("Expected a cross join to be refused, got %v", joinsString(joins))
+	}
+
+	// nor are ON-clause terms that can't be placed
+	joins, terms = parseJoins(t, from)
+	joins[1].SetOnclause(expression.NewAnd(joins[1].Onclause(),
+		expression.NewEq(expression.NewIdentifier(""), expression.NewConstant(1))))
+	before = joinsString(joins)
+	if reorderJoins(joins, terms, []int{2, 1, 0}) || joinsString(joins) != before {
+		t.Errorf("Expected the joins not to be reordered, got %v", joinsString(joins))
+	}
+}
+
+func TestInnerJoinChain(t *testing.T) {
+	for _, from := range []string{
+		"default:a LEFT JOIN default:b ON a.x = b.x JOIN default:c ON b.y = c.y",
+		"default:a JOIN default:b USE HASH(BUILD) ON a.x = b.x JOIN default:c ON b.y = c.y",
+		"default:a JOIN default:b ON KEYS a.k JOIN default:c ON b.y = c.y",
+	} {
+		if joins, _ := innerJoinChain(parseFrom(t, from)); joins != nil {
+			t.Errorf("Expected %v not to be reordered", from)
+		}
+	}
+}
+
+// statistics for t, with 10 distinct values of a spread evenly between 0 and 20, and for u
+func setTestStats(t *testing.T) map[string]string {
+	h := &datastore.Histogram{}
+	h.SetHistogram(0, "default:t", expression.NewIdentifier("a"), 1000, 100, 0.0, 0.1, 0.0, 0.0, 0.0,
+		datastore.DistBins{datastore.NewDistBin(0.5, 0.5, value.NewValue(10)),
+			datastore.NewDistBin(0.5, 0.5, value.NewValue(20))},
+		nil)
+
+	// keys without a histogram are not looked up
+	histograms := make(map[string]*datastore.Histogram)
+	for _, k := range []string{"t.a", "t.b", "META(t).id"} {
+		expr, err := n1ql.ParseExpression(k)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", k, err)
+		}
+		histograms[statsKey(expr, "t").String()] = nil
+	}
+	histograms[h.Key().String()] = h
+
+	optStatsCache.Lock()
+	optStatsCache.keyspaces["default:t"] = &optStats{keyspace: "default:t", loaded: time.Now().Add(time.Hour),
+		hasStats: true, docCount: 1000, docSize: _DEF_DOC_SIZE, histograms: histograms}
+	optStatsCache.keyspaces["default:u"] = &optStats{keyspace: "default:u", loaded: time.Now().Add(time.Hour),
+		docCount: 100, docSize: _DEF_DOC_SIZE}
+	optStatsCache.Unlock()
+	return map[string]string{"t": "default:t", "u": "default:u"}
+}
+
+func closeTo(a, b float64) bool {
+	return math.Abs(a-b) < 1.0e-9
+}
+
+func TestExprSelec(t *testing.T) {
+	keyspaces := setTestStats(t)
+	defer func() {
+		optStatsCache.Lock()
+		delete(optStatsCache.keyspaces, "default:t")
+		delete(optStatsCache.keyspaces, "default:u")
+		optStatsCache.Unlock()
+	}()
+
+	for _, c := range []struct {
+		pred  string
+		selec float64
+		def   bool
+	}{
+		{"t.a = 5", 0.1, false},
+		{"t.a < 15", 0.75, false},
+		{"15 < t.a", 0.25, false},
+		{"t.a <= 20", 1.0, false},
+		{"t.a IN [5, 15]", 0.2, false},
+		{"t.a = 5 AND t.a < 15", 0.075, false},
+		{"t.a = 5 OR t.a = 15", 0.19, false},
+		{"NOT t.a < 15", 0.25, false},
+		{"t.a IS NULL", _MIN_SELEC, false},
+		{"t.a IS NOT MISSING", 1.0, false},
+		{"META(t).id = 'k'", 0.001, false},
+		{"t.b = 5", _DEF_EQ_SELEC, true},
+		{"t.b < 5", _DEF_RANGE_SELEC, true},
+		{"t.b LIKE 'x%'", _DEF_LIKE_SELEC, true},
+		{"t.b IS NULL", _DEF_NULL_SELEC, true},
+		{"t.a = u.a", 0.01, false},
+		{"TRUE", 1.0, false},
+		{"FALSE", _MIN_SELEC, false},
+	} {
+		pred, err := n1ql.ParseExpression(c.pred)
+		if err != nil {
+			t.Fatalf("Failed to parse %v: %v", c.pred, err)
+		}
+		selec, def := exprSelec(pred, keyspaces, nil)
+		if !closeTo(selec, c.selec) || def != c.def {
+			t.Errorf("%v: expected %v (guess %v), got %v (guess %v)", c.pred, c.selec, c.def, selec, def)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+//
+// +build !enterprise
+
+package planner
+
+import (
+	"math"
+	"unicode/utf8"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/expression"
+	base "github.com/couchbase/query/plannerbase"
+	"github.com/couchbase/query/value"
+)
+
+// selectivities used when there is no histogram to go by
+const (
+	_DEF_EQ_SELEC    = 0.005
+	_DEF_RANGE_SELEC = 0.33
+	_DEF_IN_SELEC    = 0.05
+	_DEF_LIKE_SELEC  = 0.1
+	_DEF_NULL_SELEC  = 0.01
+	_DEF_SELEC       = 0.5
+	_MIN_SELEC       = 1.0e-9
+)
+
+// a keyspace field a predicate applies to
+type selecKey struct {
+	alias  string
+	stats  *optStats
+	hist   *datastore.Histogram
+	metaId bool
+}
+
+/*
+Selectivity of a predicate over the keyspaces, and whether it is a guess.
+Conjuncts are assumed to be independent.
+*/
+func exprSelec(pred expression.Expression, keyspaces map[string]string, context *PrepareContext) (
+	float64, bool) {
+
+	if context != nil && (len(context.NamedArgs()) > 0 || len(context.PositionalArgs()) > 0) {
+		replaced, err := base.ReplaceParameters(pred, context.NamedArgs(), context.PositionalArgs())
+		if err == nil {
+			pred = replaced
+		}
+	}
+	sel, def := predSelec(pred, keyspaces)
+	return math.Max(math.Min(sel, 1.0), _MIN_SELEC), def
+}
+
+func predSelec(pred expression.Expression, keyspaces map[string]string) (float64, bool) {
+	switch pred := pred.(type) {
+	case *expression.And:
+		sel, def := 1.0, false
+		for _, op := range pred.Operands() {
+			s, d := predSelec(op, keyspaces)
+			sel *= s
+			def = def || d
+		}
+		return sel, def
+	case *expression.Or:
+		sel, def := 0.0, false
+		for _, op := range pred.Operands() {
+			s, d := predSelec(op, keyspaces)
+			sel = sel + s - sel*s
+			def = def || d
+		}
+		return sel, def
+	case *expression.Not:
+		sel, def := predSelec(pred.Operand(), keyspaces)
+		return 1.0 - sel, def
+	case *expression.Eq:
+		return eqSelec(pred.First(), pred.Second(), keyspaces)
+	case *expression.LT:
+		return ltSelec(pred.First(), pred.Second(), false, keyspaces)
+	case *expression.LE:
+		return ltSelec(pred.First(), pred.Second(), true, keyspaces)
+	case *expression.In:
+		return inSelec(pred.First(), pred.Second(), keyspaces)
+	case *expression.Like:
+		return likeSelec(pred, keyspaces)
+	case *expression.IsNull:
+		return nullSelec(pred.Operand(), keyspaces, false)
+	case *expression.IsNotNull:
+		sel, def := nullSelec(pred.Operand(), keyspaces, false)
+		return 1.0 - sel, def
+	case *expression.IsMissing:
+		sel, def := valuedSelec(pred.Operand(), keyspaces)
+		return 1.0 - sel, def
+	case *expression.IsNotMissing:
+		return valuedSelec(pred.Operand(), keyspaces)
+	case *expression.IsValued:
+		return nullSelec(pred.Operand(), keyspaces, true)
+	case *expression.IsNotValued:
+		sel, def := nullSelec(pred.Operand(), keyspaces, true)
+		return 1.0 - sel, def
+	}
+
+	if val := pred.Value(); val != nil {
+		if val.Truth() {
+			return 1.0, false
+		}
+		return 0.0, false
+	}
+	return _DEF_SELEC, true
+}
+
+// the keyspace field expr refers to, nil if it refers to none or to several
+func getSelecKey(expr expression.Expression, keyspaces map[string]string) *selecKey {
+	refs, err := expression.CountKeySpaces(expr, keyspaces)
+	if err != nil || len(refs) != 1 {
+		return nil
+	}
+
+	rv := &selecKey{}
+	for alias, keyspace := range refs {
+		rv.alias = alias
+		rv.stats = getOptStats(keyspace)
+	}
+	if key := statsKey(expr, rv.alias); key != nil {
+		rv.metaId = isMetaId(key)
+		if rv.stats != nil {
+			rv.hist = rv.stats.histogram(key)
+		}
+	}
+	return rv
+}
+
+func (this *selecKey) docCount() float64 {
+	if this.stats == nil || this.stats.docCount <= 0 {
+		return 1.0
+	}
+	return float64(this.stats.docCount)
+}
+
+// number of distinct values, assumed unique if unknown
+func (this *selecKey) ndv() float64 {
+	if this.hist != nil && !this.metaId {
+		return histNdv(this.hist, int64(this.docCount()))
+	}
+	return this.docCount()
+}
+
+// separate the keyspace field from the value it is compared to
+func compared(first, second expression.Expression, keyspaces map[string]string) (
+	*selecKey, expression.Expression, bool) {
+
+	if key := getSelecKey(first, keyspaces); key != nil && !expression.HasKeyspaceReferences(second, keyspaces) {
+		return key, second, false
+	}
+	if key := getSelecKey(second, keyspaces); key != nil && !expression.HasKeyspaceReferences(first, keyspaces) {
+		return key, first, true
+	}
+	return nil, nil, false
+}
+
+func eqSelec(first, second expression.Expression, keyspaces map[string]string) (float64, bool) {
+
+	// join predicate
+	k1 := getSelecKey(first, keyspaces)
+	k2 := getSelecKey(second, keyspaces)
+	if k1 != nil && k2 != nil && k1.alias != k2.alias {
+		return 1.0 / math.Max(k1.ndv(), k2.ndv()), k1.hist == nil && k2.hist == nil
+	}
+
+	key, other, _ := compared(first, second, keyspaces)
+	if key == nil {
+		return _DEF_EQ_SELEC, true
+	}
+	if key.metaId {
+		return 1.0 / key.docCount(), false
+	}
+	if key.hist == nil {
+		return _DEF_EQ_SELEC, true
+	}
+	if val := other.Value(); val != nil {
+		return histEq(key.hist, val), false
+	}
+	return 1.0 / key.ndv(), false
+}
+
+// selectivity of first < second, or first <= second
+func ltSelec(first, second expression.Expression, inclusive bool, keyspaces map[string]string) (
+	float64, bool) {
+
+	key, other, swapped := compared(first, second, keyspaces)
+	if key == nil || key.hist == nil {
+		return _DEF_RANGE_SELEC, true
+	}
+	val := other.Value()
+	if val == nil {
+		return _DEF_RANGE_SELEC, true
+	}
+
+	h := key.hist
+	if val.Type() <= value.NULL {
+		return 0.0, false
+	}
+	if !swapped {
+		return histBelow(h, val, inclusive) - histNull(h), false
+	}
+	return histValued(h) - histBelow(h, val, !inclusive), false
+}
+
+func inSelec(first, second expression.Expression, keyspaces map[string]string) (float64, bool) {
+	var vals value.Values
+
+	if acons, ok := second.(*expression.ArrayConstruct); ok {
+		for _, op := range acons.Operands() {
+			vals = append(vals, op.Value())
+		}
+	} else if val := second.Value(); val != nil && val.Type() == value.ARRAY {
+		for _, v := range val.Actual().([]interface{}) {
+			vals = append(vals, value.NewValue(v))
+		}
+	} else {
+		return _DEF_IN_SELEC, true
+	}
+
+	sel, def := 0.0, false
+	for _, v := range vals {
+		var s float64
+		var d bool
+
+		if v == nil {
+			s, d = _DEF_EQ_SELEC, true
+		} else {
+			s, d = eqSelec(first, expression.NewConstant(v), keyspaces)
+		}
+		sel += s
+		def = def || d
+	}
+	return math.Min(sel, 1.0), def
+}
+
+func likeSelec(pred *expression.Like, keyspaces map[string]string) (float64, bool) {
+	re := pred.Regexp()
+	if re == nil {
+		return _DEF_LIKE_SELEC, true
+	}
+	prefix, complete := re.LiteralPrefix()
+	if complete {
+		return eqSelec(pred.First(), expression.NewConstant(prefix), keyspaces)
+	}
+
+	key := getSelecKey(pred.First(), keyspaces)
+	if key == nil || key.hist == nil || prefix == "" {
+		return _DEF_LIKE_SELEC, true
+	}
+	low := value.NewValue(prefix)
+	high := value.NewValue(prefix + string(utf8.MaxRune))
+	return histBelow(key.hist, high, false) - histBelow(key.hist, low, false), false
+}
+
+// fraction of NULL values, or of non NULL values if valued
+func nullSelec(expr expression.Expression, keyspaces map[string]string, valued bool) (float64, bool) {
+	key := getSelecKey(expr, keyspaces)
+	if key == nil || key.hist == nil {
+		if valued {
+			return 1.0 - _DEF_NULL_SELEC, true
+		}
+		return _DEF_NULL_SELEC, true
+	}
+	if valued {
+		return histValued(key.hist) - histNull(key.hist), false
+	}
+	return histNull(key.hist), false
+}
+
+func valuedSelec(expr expression.Expression, keyspaces map[string]string) (float64, bool) {
+	key := getSelecKey(expr, keyspaces)
+	if key == nil || key.hist == nil {
+		return 1.0 - _DEF_NULL_SELEC, true
+	}
+	return histValued(key.hist), false
+}
@@ -0,0 +1,310 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+//
+// +build !enterprise
+
+package planner
+
+import (
+	"math"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/algebra"
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/statistics"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/value"
+)
+
+/*
+The community cost model works off the document counts and sizes reported by
+the keyspaces, and the histograms gathered by UPDATE STATISTICS.
+Only keyspaces that have statistics are planned by cost, the others are
+planned by rules, as they would without the cost model.
+Statistics are cached for a little while, so that planning a statement does
+not fetch them for every predicate.
+*/
+
+const _STATS_REFRESH = 30 * time.Second
+
+type optStats struct {
+	sync.Mutex
+	keyspace   string
+	loaded     time.Time
+	hasStats   bool
+	docCount   int64
+	docSize    int64
+	histograms map[string]*datastore.Histogram // nil for keys without a histogram
+}
+
+var optStatsCache = struct {
+	sync.RWMutex
+	keyspaces map[string]*optStats
+	indexers  map[string]string // indexer to keyspace
+}{
+	keyspaces: make(map[string]*optStats),
+	indexers:  make(map[string]string),
+}
+
+// the statistics of a keyspace, or nil if the keyspace can't be found
+func getOptStats(keyspace string) *optStats {
+	optStatsCache.RLock()
+	stats, ok := optStatsCache.keyspaces[keyspace]
+	optStatsCache.RUnlock()
+	if ok && time.Since(stats.loaded) < _STATS_REFRESH {
+		return stats
+	}
+
+	stats = loadOptStats(keyspace)
+	optStatsCache.Lock()
+	if stats == nil {
+		delete(optStatsCache.keyspaces, keyspace)
+	} else {
+		optStatsCache.keyspaces[keyspace] = stats
+	}
+	optStatsCache.Unlock()
+	return stats
+}
+
+func loadOptStats(keyspace string) *optStats {
+	parts := algebra.ParsePath(keyspace)
+	if len(parts) == 0 || parts[0] == datastore.SYSTEM_NAMESPACE {
+		return nil
+	}
+	ks, err := datastore.GetKeyspace(parts...)
+	if err != nil || ks == nil {
+		return nil
+	}
+	counts, err := ks.Stats(datastore.NULL_QUERY_CONTEXT,
+		[]datastore.KeyspaceStats{datastore.KEYSPACE_COUNT, datastore.KEYSPACE_SIZE})
+	if err != nil {
+		logging.Debugf("cost model: unable to get document count for %s: %v", keyspace, err)
+		return nil
+	}
+
+	rv := &optStats{
+		keyspace:   keyspace,
+		loaded:     time.Now(),
+		docCount:   counts[0],
+		docSize:    _DEF_DOC_SIZE,
+		histograms: make(map[string]*datastore.Histogram),
+	}
+	if counts[0] > 0 && counts[1] > 0 {
+		rv.docSize = counts[1] / counts[0]
+	}
+	if store := datastore.GetDatastore(); store != nil {
+		rv.hasStats, err = statistics.HasStatistics(store, keyspace)
+		if err != nil {
+			logging.Debugf("cost model: unable to get statistics for %s: %v", keyspace, err)
+		}
+	}
+
+	// indexes only know the ids of their keyspace
+	if indexers, err := ks.Indexers(); err == nil {
+		optStatsCache.Lock()
+		for _, indexer := range indexers {
+			optStatsCache.indexers[indexerKey(indexer)] = keyspace
+		}
+		optStatsCache.Unlock()
+	}
+	return rv
+}
+
+func indexerKey(indexer datastore.Indexer) string {
+	return indexer.BucketId() + "." + indexer.ScopeId() + "." + indexer.KeyspaceId()
+}
+
+// the statistics of the keyspace an index belongs to
+func getIndexOptStats(index datastore.Index) *optStats {
+	optStatsCache.RLock()
+	keyspace, ok := optStatsCache.indexers[indexerKey(index.Indexer())]
+	optStatsCache.RUnlock()
+	if !ok {
+		return nil
+	}
+	return getOptStats(keyspace)
+}
+
+// the histogram of a key, in index key form
+func (this *optStats) histogram(key expression.Expression) *datastore.Histogram {
+	if !this.hasStats {
+		return nil
+	}
+
+	name := key.String()
+	this.Lock()
+	defer this.Unlock()
+	h, ok := this.histograms[name]
+	if !ok {
+		var err error
+
+		h, err = statistics.GetHistogram(datastore.GetDatastore(), this.keyspace, key)
+		if err != nil {
+			logging.Debugf("cost model: unable to get histogram for %s in %s: %v", name, this.keyspace, err)
+		}
+		this.histograms[name] = h
+	}
+	return h
+}
+
+/*
+Histograms are kept for index keys, which don't reference the keyspace, so
+t.a.b is looked up as a.b, and meta(t).id as meta().id.
+Only field references have histograms.
+*/
+func statsKey(expr expression.Expression, alias string) expression.Expression {
+	switch expr := expr.(type) {
+	case *expression.Field:
+		if ident, ok := expr.First().(*expression.Identifier); ok {
+			if ident.Identifier() != alias {
+				return nil
+			}
+			return expression.NewIdentifier(expr.Second().Alias())
+		}
+		first := statsKey(expr.First(), alias)
+		if first == nil {
+			return nil
+		}
+		return expression.NewField(first, expr.Second())
+	case *expression.Meta:
+		if len(expr.Operands()) == 0 {
+			return expr
+		}
+		if ident, ok := expr.Operands()[0].(*expression.Identifier); ok && ident.Identifier() == alias {
+			return expression.NewMeta()
+		}
+	}
+	return nil
+}
+
+func isMetaId(expr expression.Expression) bool {
+	if field, ok := expr.(*expression.Field); ok {
+		_, meta := field.First().(*expression.Meta)
+		return meta && field.Second().Alias() == "id"
+	}
+	return false
+}
+
+/*
+Histogram arithmetic.
+Bin sizes are fractions of the sampled documents; distribution bins hold
+the values above the maximum of the previous bin, up to their own maximum,
+while overflow bins hold a single frequent value.
+*/
+
+// fraction of documents with a value for the key
+func histValued(h *datastore.Histogram) float64 {
+	valued := 0.0
+	for _, b := range h.Distrib() {
+		valued += b.Size()
+	}
+	for _, b := range h.Ovrflow() {
+		valued += b.Size()
+	}
+	return math.Min(valued, 1.0)
+}
+
+// number of distinct values seen in the sample
+func histDistincts(h *datastore.Histogram) float64 {
+	return math.Max(h.Fdistincts()*histValued(h)*float64(h.SampleSize()), 1.0)
+}
+
+// estimated number of distinct values in the keyspace
+func histNdv(h *datastore.Histogram, docCount int64) float64 {
+	distincts := histDistincts(h)
+
+	// mostly unique values are assumed to stay so past the sample
+	if h.SampleSize() < docCount && h.Fdistincts() > 0.5 {
+		distincts *= float64(docCount) / float64(h.SampleSize())
+	}
+	return distincts
+}
+
+// selectivity of a value not seen in the sample
+func histMinSelec(h *datastore.Histogram) float64 {
+	if h.SampleSize() > 0 {
+		return 0.5 / float64(h.SampleSize())
+	}
+	return _MIN_SELEC
+}
+
+func histEq(h *datastore.Histogram, val value.Value) float64 {
+	for _, b := range h.Ovrflow() {
+		if b.Val().Collate(val) == 0 {
+			return b.Size()
+		}
+	}
+	distincts := histDistincts(h)
+	for _, b := range h.Distrib() {
+		if val.Collate(b.Max()) <= 0 {
+			return b.Size() / math.Max(b.Distinct()*distincts, 1.0)
+		}
+	}
+	return histMinSelec(h)
+}
+
+// fraction of documents with a value below val, or up to val if inclusive
+func histBelow(h *datastore.Histogram, val value.Value, inclusive bool) float64 {
+	rv := 0.0
+	for _, b := range h.Ovrflow() {
+		c := b.Val().Collate(val)
+		if c < 0 || (c == 0 && inclusive) {
+			rv += b.Size()
+		}
+	}
+
+	var prev value.Value
+	distincts := histDistincts(h)
+	for _, b := range h.Distrib() {
+		c := val.Collate(b.Max())
+		if c > 0 {
+			rv += b.Size()
+			prev = b.Max()
+			continue
+		}
+		if c == 0 {
+			rv += b.Size()
+			if !inclusive {
+				rv -= b.Size() / math.Max(b.Distinct()*distincts, 1.0)
+			}
+		} else {
+			rv += b.Size() * binFraction(prev, b.Max(), val)
+		}
+		break
+	}
+	return math.Min(rv, 1.0)
+}
+
+// where val falls within a bin, interpolating numbers, or halfway otherwise
+func binFraction(low, high, val value.Value) float64 {
+	if low != nil && low.Type() == value.NUMBER && high.Type() == value.NUMBER && val.Type() == value.NUMBER {
+		l := value.AsNumberValue(low).Float64()
+		h := value.AsNumberValue(high).Float64()
+		v := value.AsNumberValue(val).Float64()
+		if h > l {
+			return math.Max(math.Min((v-l)/(h-l), 1.0), 0.0)
+		}
+	}
+	return 0.5
+}
+
+// comparisons only hold for non null values
+func histNull(h *datastore.Histogram) float64 {
+	for _, b := range h.Ovrflow() {
+		if b.Val().Type() == value.NULL {
+			return b.Size()
+		}
+	}
+	if d := h.Distrib(); len(d) > 0 && d[0].Max().Type() == value.NULL {
+		return d[0].Size()
+	}
+	return 0.0
+}
@@ -12,45 +12,131 @@
 package planner
 
 import (
+	"math"
+	"sort"
+
 	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/plan"
 	base "github.com/couchbase/query/plannerbase"
+	"github.com/couchbase/query/value"
+)
+
+/*
+Costs are in arbitrary units, roughly the time taken to process an index
+entry, so only their relative sizes matter.
+*/
+const (
+	_COST_SCAN_START  = 1.0   // starting an index scan
+	_COST_INDEX_ENTRY = 0.01  // per index entry scanned
+	_COST_KEY         = 0.01  // per document key produced
+	_COST_FETCH_DOC   = 0.2   // per document fetched
+	_COST_FETCH_KB    = 0.05  // per KB of document fetched
+	_COST_EVAL        = 0.002 // per expression evaluated
+	_COST_HASH_BUILD  = 0.01  // per row inserted in a hash table
+	_COST_HASH_PROBE  = 0.005 // per row looked up in a hash table
+	_COST_SORT        = 0.002 // per row and term compared
+	_COST_WRITE       = 0.5   // per document written
+)
+
+const (
+	_INDEX_KEY_SIZE = 32   // average size of an index key
+	_DEF_DOC_SIZE   = 1024 // document size when the keyspace does not report one
+	_DEF_ARRAY_LEN  = 10   // elements in an array of unknown length
+	_DEF_KEYS       = 10   // keys in a list of unknown length
 )
 
 func checkCostModel(featureControls uint64) {
 	// no-op
 }
 
+/*
+Keyspaces without statistics are planned by rules.
+*/
 func optDocCount(keyspace string) int64 {
-	return -1
+	stats := getOptStats(keyspace)
+	if stats == nil || !stats.hasStats {
+		return -1
+	}
+	return stats.docCount
 }
 
 func optFilterSelectivity(filter *base.Filter, advisorValidate bool, context *PrepareContext) {
+	if filter.IsSelecDone() {
+		return
+	}
+
+	sel, def := exprSelec(filter.FltrExpr(), filterKeyspaces(filter), context)
+	filter.SetSelec(sel)
+	filter.SetArraySelec(sel)
+	if def {
+		filter.SetDefSelec()
+	}
+	filter.SetSelecDone()
 	return
 }
+
+// all the keyspaces a filter references, including those already joined
+func filterKeyspaces(filter *base.Filter) map[string]string {
+	if len(filter.OrigKeyspaces()) > 0 {
+		return filter.OrigKeyspaces()
+	}
+	return filter.Keyspaces()
+}
+
 func optExprSelec(keyspaces map[string]string, pred expression.Expression, advisorValidate bool,
 	context *PrepareContext) (float64, float64) {
-	return OPT_SELEC_NOT_AVAIL, OPT_SELEC_NOT_AVAIL
+	sel, _ := exprSelec(pred, keyspaces, context)
+	return sel, sel
 }
 
 func optDefInSelec(keyspace, key string, advisorValidate bool) float64 {
-	return OPT_SELEC_NOT_AVAIL
+	return _DEF_IN_SELEC
 }
 
 func optDefLikeSelec(keyspace, key string, advisorValidate bool) float64 {
-	return OPT_SELEC_NOT_AVAIL
+	return _DEF_LIKE_SELEC
 }
 
+/*
+Mark the filters on the keys used by an index scan, since their selectivity
+is accounted for by the scan.
+*/
 func optMarkIndexFilters(keys expression.Expressions, spans plan.Spans2,
 	condition expression.Expression, filters base.Filters) {
-	// no-op
+
+	used := make(expression.Expressions, 0, len(keys))
+	for i, key := range keys {
+		for _, span := range spans {
+			if i < len(span.Ranges) && !span.Ranges[i].HasSpecialSpan() {
+				used = append(used, key)
+				break
+			}
+		}
+	}
+
+	for _, fl := range filters {
+		if fl.IsJoin() {
+			continue
+		}
+		expr := fl.FltrExpr()
+		if condition != nil && base.SubsetOf(condition, expr) {
+			fl.SetIndexFlag()
+			continue
+		}
+		for _, key := range used {
+			if expr.DependsOn(key) {
+				fl.SetIndexFlag()
+				break
+			}
+		}
+	}
 }
 
 func optMinCost() float64 {
-	return OPT_COST_NOT_AVAIL
+	return _COST_EVAL
 }
 
 func optCheckRangeExprs(baseKeyspaces map[string]*base.BaseKeyspace, advisorValidate bool,
@@ -60,215 +146,711 @@ func optCheckRangeExprs(baseKeyspaces map[string]*base.BaseKeyspace, advisorVali
 
 func primaryIndexScanCost(primary datastore.PrimaryIndex, requestId string, context *PrepareContext) (
 	float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	stats := getIndexOptStats(primary)
+	if stats == nil {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	card := math.Max(float64(stats.docCount), 1.0)
+	return _COST_SCAN_START + card*_COST_INDEX_ENTRY, card, _INDEX_KEY_SIZE, _COST_SCAN_START + _COST_INDEX_ENTRY
 }
 
 func indexScanCost(index datastore.Index, sargKeys expression.Expressions, requestId string,
 	spans SargSpans, alias string, advisorValidate bool, context *PrepareContext) (
 	float64, float64, float64, int64, float64, error) {
-	return OPT_COST_NOT_AVAIL, OPT_SELEC_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL, errors.NewPlanInternalError("indexScanCost: unexpected in community edition")
+	switch spans := spans.(type) {
+	case *TermSpans:
+		return termIndexCost(index, sargKeys, spans.spans)
+	case *IntersectSpans:
+		return multiIndexCost(index, sargKeys, requestId, spans.spans, alias, false, advisorValidate, context)
+	case *UnionSpans:
+		return multiIndexCost(index, sargKeys, requestId, spans.spans, alias, true, advisorValidate, context)
+	}
+
+	return OPT_COST_NOT_AVAIL, OPT_SELEC_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL, errors.NewPlanInternalError("indexScanCost: unexpected span type")
+}
+
+func termIndexCost(index datastore.Index, sargKeys expression.Expressions, spans plan.Spans2) (
+	float64, float64, float64, int64, float64, error) {
+	stats := getIndexOptStats(index)
+	if stats == nil {
+		return OPT_COST_NOT_AVAIL, OPT_SELEC_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL, errors.NewPlanInternalError("indexScanCost: no statistics for index " + index.Name())
+	}
+
+	// spans are alternatives, the ranges within a span apply together
+	sel := 0.0
+	for _, span := range spans {
+		ssel := 1.0
+		for _, r := range span.Ranges {
+			ssel *= rangeSelec(r)
+		}
+		sel += ssel
+	}
+	sel = math.Max(math.Min(sel, 1.0), _MIN_SELEC)
+
+	entries := float64(stats.docCount)
+	for _, key := range sargKeys {
+		if _, ok := key.(*expression.All); ok {
+			entries *= _DEF_ARRAY_LEN
+			break
+		}
+	}
+	card := math.Max(entries*sel, 1.0)
+	size := int64(_INDEX_KEY_SIZE * (len(sargKeys) + 1))
+	cost := _COST_SCAN_START + card*_COST_INDEX_ENTRY*float64(len(spans))
+	return cost, sel, card, size, _COST_SCAN_START + _COST_INDEX_ENTRY, nil
+}
+
+func rangeSelec(r *plan.Range2) float64 {
+	switch {
+	case r.Selec1 > 0.0 && r.Selec2 > 0.0:
+		// both bounds are fractions of the valued keys
+		return math.Max(r.Selec1+r.Selec2-1.0, _MIN_SELEC)
+	case r.Selec1 > 0.0:
+		return r.Selec1
+	case r.Selec2 > 0.0:
+		return r.Selec2
+	case r.HasFlag(plan.RANGE_EMPTY_SPAN):
+		return _MIN_SELEC
+	case r.HasFlag(plan.RANGE_NULL_SPAN | plan.RANGE_MISSING_SPAN):
+		return _DEF_NULL_SELEC
+	case r.HasSpecialSpan():
+		return 1.0
+	case r.EqualRange():
+		return _DEF_EQ_SELEC
+	case r.High == nil && (r.Low == nil || expression.Equivalent(r.Low, expression.NULL_EXPR)):
+		return 1.0
+	}
+	return _DEF_RANGE_SELEC
+}
+
+func multiIndexCost(index datastore.Index, sargKeys expression.Expressions, requestId string,
+	spans []SargSpans, alias string, union, advisorValidate bool, context *PrepareContext) (
+	float64, float64, float64, int64, float64, error) {
+	var cost, sel, frCost, nrows float64
+	var size int64
+	for i, span := range spans {
+		tcost, tsel, tcard, tsize, tfrCost, e := indexScanCost(index, sargKeys, requestId, span, alias, advisorValidate, context)
+		if e != nil {
+			return tcost, tsel, tcard, tsize, tfrCost, e
+		}
+		cost += tcost
+		tnrows := tcard / tsel
+		if i == 0 {
+			sel = tsel
+			nrows = tnrows
+			frCost = tfrCost
+			size = tsize
+		} else {
+			tsel = tsel * (tnrows / nrows)
+			if union {
+				sel = sel + tsel - (sel * tsel)
+			} else {
+				sel = sel * tsel
+			}
+			if tsize > size {
+				size = tsize
+			}
+		}
+	}
+
+	return cost, sel, math.Max(sel*nrows, 1.0), size, frCost, nil
 }
 
 func getIndexProjectionCost(index datastore.Index, indexProjection *plan.IndexProjection,
 	cardinality float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	nterms := 1
+	if indexProjection != nil {
+		nterms += len(indexProjection.EntryKeys)
+	}
+	return cardinality * _COST_EVAL * float64(nterms), cardinality, int64(nterms), _COST_EVAL
 }
 
 func getIndexGroupAggsCost(index datastore.Index, indexGroupAggs *plan.IndexGroupAggregates,
 	indexProjection *plan.IndexProjection, keyspaces map[string]string,
 	cardinality float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	exprs := make(expression.Expressions, 0, len(indexGroupAggs.Group))
+	for _, g := range indexGroupAggs.Group {
+		exprs = append(exprs, g.Expr)
+	}
+	groups := groupCount(exprs, keyspaces, cardinality)
+	nterms := len(indexGroupAggs.Group) + len(indexGroupAggs.Aggregates)
+	cost := cardinality * _COST_EVAL * float64(nterms+1)
+	return cost, groups, int64(_INDEX_KEY_SIZE * (nterms + 1)), cost
 }
 
 func getKeyScanCost(keys expression.Expression) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	n := float64(_DEF_KEYS)
+	if acons, ok := keys.(*expression.ArrayConstruct); ok {
+		n = float64(len(acons.Operands()))
+	} else if val := keys.Value(); val != nil {
+		if arr, ok := val.Actual().([]interface{}); ok {
+			n = float64(len(arr))
+		} else {
+			n = 1.0
+		}
+	}
+	n = math.Max(n, 1.0)
+	return n * _COST_KEY, n, _INDEX_KEY_SIZE, _COST_KEY
 }
 
 func getFetchCost(keyspace datastore.Keyspace, cardinality float64) (float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	docSize := int64(_DEF_DOC_SIZE)
+	if stats := getOptStats(keyspace.QualifiedName()); stats != nil {
+		docSize = stats.docSize
+	}
+	docCost := _COST_FETCH_DOC + float64(docSize)/1024.0*_COST_FETCH_KB
+	return cardinality * docCost, docSize, docCost
 }
 
 func getDistinctScanCost(index datastore.Index, cardinality float64) (float64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL
+	return cardinality * _COST_HASH_BUILD, cardinality
 }
 
 func getExpressionScanCost(expr expression.Expression) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	n := float64(_DEF_ARRAY_LEN)
+	if val := expr.Value(); val != nil {
+		if arr, ok := val.Actual().([]interface{}); ok {
+			n = math.Max(float64(len(arr)), 1.0)
+		} else {
+			n = 1.0
+		}
+	}
+	return n * _COST_EVAL, n, _INDEX_KEY_SIZE, _COST_EVAL
 }
 
 func getValueScanCost(pairs algebra.Pairs) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	n := math.Max(float64(len(pairs)), 1.0)
+	return n * _COST_EVAL, n, _DEF_DOC_SIZE, _COST_EVAL
 }
 
 func getDummyScanCost() (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return _COST_EVAL, 1.0, 1, _COST_EVAL
 }
 
 func getCountScanCost() (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return _COST_SCAN_START, 1.0, _INDEX_KEY_SIZE, _COST_SCAN_START
 }
 
-func getNLJoinCost(left, right plan.Operator, filters base.Filters, outer bool, op string) (
-	float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+func validOp(op plan.Operator) bool {
+	return op != nil && op.Cost() > 0.0 && op.Cardinality() > 0.0 && op.Size() > 0 && op.FrCost() > 0.0
 }
 
+// rows out of a join, given the rows the join would produce when inner
+func joinCard(left, joined float64, outer bool, op string) float64 {
+	if op == "nest" {
+		return left
+	}
+	if outer {
+		return math.Max(left, joined)
+	}
+	return math.Max(joined, _MIN_SELEC)
+}
+
+/*
+The right hand side of a nested loop join is built for, and costed as,
+a single probe, with the join filters applied as index spans or filters.
+*/
+func getNLJoinCost(left, right plan.Operator, filters base.Filters, outer bool, op string) (
+	float64, float64, int64, float64) {
+	if !validOp(left) || !validOp(right) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	cost := left.Cost() + left.Cardinality()*right.Cost()
+	card := joinCard(left.Cardinality(), left.Cardinality()*right.Cardinality(), outer, op)
+	return cost, card, left.Size() + right.Size(), left.FrCost() + right.FrCost()
+}
+
+/*
+The right hand side of a hash join is built without the join filters,
+whose selectivity is applied here.
+*/
 func getHashJoinCost(left, right plan.Operator, buildExprs, probeExprs expression.Expressions,
 	buildRight, force bool, filters base.Filters, outer bool, op string) (
 	float64, float64, int64, float64, bool) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL, false
+	if !validOp(left) || !validOp(right) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL, false
+	}
+
+	sel := 1.0
+	for _, fl := range filters {
+		if fl.HasHJFlag() && fl.Selec() > 0.0 {
+			sel *= fl.Selec()
+		}
+	}
+
+	if !force {
+		buildRight = right.Cardinality() <= left.Cardinality()
+	}
+	build, probe := left, right
+	if buildRight {
+		build, probe = right, left
+	}
+
+	cost := left.Cost() + right.Cost() + build.Cardinality()*_COST_HASH_BUILD +
+		probe.Cardinality()*_COST_HASH_PROBE
+	frCost := build.Cost() + probe.FrCost() + _COST_HASH_PROBE
+	card := joinCard(left.Cardinality(), left.Cardinality()*right.Cardinality()*sel, outer, op)
+	return cost, card, left.Size() + right.Size(), frCost, buildRight
+}
+
+// ON KEYS joins fetch a document for each key
+func lookupCost(left plan.Operator, right *algebra.KeyspaceTerm, rightKeyspace string) (
+	float64, float64, int64, float64) {
+	if !validOp(left) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	docSize := int64(_DEF_DOC_SIZE)
+	if stats := getOptStats(rightKeyspace); stats != nil {
+		docSize = stats.docSize
+	}
+	keys := 1.0
+	if right.Keys() != nil {
+		_, keys, _, _ = getKeyScanCost(right.Keys())
+	}
+	card := left.Cardinality() * keys
+	docCost := _COST_FETCH_DOC + float64(docSize)/1024.0*_COST_FETCH_KB
+	return left.Cost() + card*docCost, card, left.Size() + docSize, left.FrCost() + docCost
 }
 
 func getLookupJoinCost(left plan.Operator, outer bool, right *algebra.KeyspaceTerm,
 	rightKeyspace string) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	cost, card, size, frCost := lookupCost(left, right, rightKeyspace)
+	if cost > 0.0 {
+		card = joinCard(left.Cardinality(), card, outer, "join")
+	}
+	return cost, card, size, frCost
 }
 
 func getIndexJoinCost(left plan.Operator, outer bool, right *algebra.KeyspaceTerm,
 	rightKeyspace string, covered bool, index datastore.Index, requestId string,
 	advisorValidate bool, context *PrepareContext) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(left) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	probe := _COST_SCAN_START + _COST_INDEX_ENTRY
+	cost, card, size, frCost := left.Cost()+left.Cardinality()*probe, left.Cardinality(), left.Size()+_INDEX_KEY_SIZE, left.FrCost()+probe
+	if !covered {
+		docCost := _COST_FETCH_DOC
+		if stats := getOptStats(rightKeyspace); stats != nil {
+			docCost += float64(stats.docSize) / 1024.0 * _COST_FETCH_KB
+			size += stats.docSize
+		}
+		cost += card * docCost
+		frCost += docCost
+	}
+	return cost, joinCard(left.Cardinality(), card, outer, "join"), size, frCost
 }
 
 func getLookupNestCost(left plan.Operator, outer bool, right *algebra.KeyspaceTerm,
 	rightKeyspace string) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	cost, card, size, frCost := lookupCost(left, right, rightKeyspace)
+	if cost > 0.0 {
+		card = joinCard(left.Cardinality(), card, outer, "nest")
+	}
+	return cost, card, size, frCost
 }
 
 func getIndexNestCost(left plan.Operator, outer bool, right *algebra.KeyspaceTerm,
 	rightKeyspace string, index datastore.Index, requestId string, advisorValidate bool,
 	context *PrepareContext) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	cost, card, size, frCost := getIndexJoinCost(left, outer, right, rightKeyspace, false, index,
+		requestId, advisorValidate, context)
+	if cost > 0.0 {
+		card = left.Cardinality()
+	}
+	return cost, card, size, frCost
 }
 
 func getUnnestCost(node *algebra.Unnest, lastOp plan.Operator, keyspaces map[string]string,
 	advisorValidate bool) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(lastOp) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	card := lastOp.Cardinality() * _DEF_ARRAY_LEN
+	if node.Outer() {
+		card = math.Max(card, lastOp.Cardinality())
+	}
+	return lastOp.Cost() + card*_COST_EVAL, card, lastOp.Size(), lastOp.FrCost() + _COST_EVAL
 }
 
 func getSimpleFromTermCost(left, right plan.Operator, filters base.Filters) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return getNLJoinCost(left, right, filters, false, "join")
 }
 
 func getSimpleFilterCost(alias string, cost, cardinality, selec float64, size int64, frCost float64) (
 	float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return cost + cardinality*_COST_EVAL, math.Max(cardinality*selec, _MIN_SELEC), size, frCost + _COST_EVAL
 }
 
 func getFilterCost(lastOp plan.Operator, expr expression.Expression,
 	baseKeyspaces map[string]*base.BaseKeyspace, keyspaceNames map[string]string,
 	alias string, advisorValidate bool, context *PrepareContext) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(lastOp) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	return getFilterCostWithInput(expr, baseKeyspaces, keyspaceNames, alias, lastOp.Cost(),
+		lastOp.Cardinality(), lastOp.Size(), lastOp.FrCost(), advisorValidate, context)
 }
 
+/*
+Filters already applied by an index scan or a hash join don't reduce the
+cardinality again.
+*/
 func getFilterCostWithInput(expr expression.Expression, baseKeyspaces map[string]*base.BaseKeyspace,
 	keyspaceNames map[string]string, alias string, cost, cardinality float64, size int64, frCost float64,
 	advisorValidate bool, context *PrepareContext) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if expr == nil || cost <= 0.0 || cardinality <= 0.0 {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+
+	var filters base.Filters
+	if baseKeyspace, ok := baseKeyspaces[alias]; ok {
+		filters = baseKeyspace.Filters()
+	}
+
+	terms := expression.Expressions{expr}
+	if and, ok := expr.(*expression.And); ok {
+		terms = and.Operands()
+	}
+
+	sel := 1.0
+	for _, term := range terms {
+		found := false
+		for _, fl := range filters {
+			if term.EquivalentTo(fl.FltrExpr()) {
+				if !fl.HasPlanFlags() && fl.Selec() > 0.0 {
+					sel *= fl.Selec()
+				}
+				found = true
+				break
+			}
+		}
+		if !found {
+			s, _ := exprSelec(term, keyspaceNames, context)
+			sel *= s
+		}
+	}
+
+	return getSimpleFilterCost(alias, cost, cardinality, sel, size, frCost)
 }
 
 func getLetCost(lastOp plan.Operator) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(lastOp) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	return lastOp.Cost() + lastOp.Cardinality()*_COST_EVAL, lastOp.Cardinality(), lastOp.Size(),
+		lastOp.FrCost() + _COST_EVAL
 }
 
 func getWithCost(lastOp plan.Operator, with expression.Bindings) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(lastOp) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	cost := float64(len(with)) * _COST_EVAL
+	return lastOp.Cost() + cost, lastOp.Cardinality(), lastOp.Size(), lastOp.FrCost() + cost
 }
 
 func getOffsetCost(lastOp plan.Operator, noffset int64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(lastOp) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	card := lastOp.Cardinality()
+	if noffset > 0 {
+		card = math.Max(card-float64(noffset), 1.0)
+	}
+	return lastOp.Cost(), card, lastOp.Size(), lastOp.FrCost()
 }
 
+// a limit stops the rows from coming once it has been reached
 func getLimitCost(lastOp plan.Operator, nlimit, noffset int64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(lastOp) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	cost, card := lastOp.Cost(), lastOp.Cardinality()
+	if nlimit >= 0 {
+		if noffset < 0 {
+			noffset = 0
+		}
+		needed := float64(nlimit + noffset)
+		if needed < card {
+			cost = lastOp.FrCost() + (cost-lastOp.FrCost())*needed/card
+			card = math.Max(float64(nlimit), 1.0)
+		}
+	}
+	return cost, card, lastOp.Size(), lastOp.FrCost()
 }
 
 func getUnnestPredSelec(pred expression.Expression, variable string, mapping expression.Expression,
 	keyspaces map[string]string, advisorValidate bool, context *PrepareContext) float64 {
-	return OPT_SELEC_NOT_AVAIL
+	sel, _ := exprSelec(pred, keyspaces, context)
+	return sel
 }
 
+/*
+Choose the cheapest index, then add the indexes that save more in fetches
+than it takes to scan them, for an intersect scan.
+*/
 func optChooseIntersectScan(keyspace datastore.Keyspace, sargables map[datastore.Index]*indexEntry,
 	nTerms int, alias string, advisorValidate bool, context *PrepareContext) map[datastore.Index]*indexEntry {
-	return sargables
+
+	stats := getOptStats(keyspace.QualifiedName())
+	if stats == nil || stats.docCount <= 0 || len(sargables) == 0 {
+		return sargables
+	}
+	docCount := float64(stats.docCount)
+
+	type candidate struct {
+		index datastore.Index
+		cost  float64
+		selec float64
+	}
+	candidates := make([]*candidate, 0, len(sargables))
+	hasOrder := false
+	for s, e := range sargables {
+		if e.IsPushDownProperty(_PUSHDOWN_ORDER) {
+			hasOrder = true
+		}
+		candidates = append(candidates, &candidate{s, e.cost, e.selectivity})
+	}
+
+	// plans without order pushdown will need a sort
+	if hasOrder && nTerms > 0 {
+		for _, c := range candidates {
+			e := sargables[c.index]
+			if !e.IsPushDownProperty(_PUSHDOWN_ORDER) {
+				sortCost, _, _, _ := getSortCost(e.size, nTerms, e.cardinality, 0, 0)
+				c.cost += sortCost
+			}
+		}
+	}
+
+	fetchCost := func(selec float64) float64 {
+		cost, _, _ := getFetchCost(keyspace, math.Max(docCount*selec, 1.0))
+		return cost
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ci := candidates[i].cost + fetchCost(candidates[i].selec)
+		cj := candidates[j].cost + fetchCost(candidates[j].selec)
+		return ci < cj || (ci == cj && candidates[i].index.Name() < candidates[j].index.Name())
+	})
+
+	chosen := map[datastore.Index]*indexEntry{candidates[0].index: sargables[candidates[0].index]}
+	cost := candidates[0].cost
+	selec := candidates[0].selec
+	for _, c := range candidates[1:] {
+		if cost+c.cost+fetchCost(selec*c.selec) < cost+fetchCost(selec) {
+			chosen[c.index] = sargables[c.index]
+			cost += c.cost
+			selec *= c.selec
+		}
+	}
+
+	return chosen
 }
 
 func getSortCost(totalSize int64, nterms int, cardinality float64, limit, offset int64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if cardinality <= 0.0 {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+
+	// a limit keeps only the top rows
+	kept := cardinality
+	if limit >= 0 {
+		if offset < 0 {
+			offset = 0
+		}
+		kept = math.Min(cardinality, float64(limit+offset))
+	}
+	kept = math.Max(kept, 1.0)
+	cost := math.Max(cardinality*math.Log2(kept+1.0)*float64(nterms)*_COST_SORT, _COST_SORT)
+	card := cardinality
+	if limit >= 0 {
+		card = math.Max(math.Min(cardinality, float64(limit)), 1.0)
+	}
+	return cost, card, totalSize, cost
 }
 
 func getInitialProjectCost(projection *algebra.Projection, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	nterms := float64(len(projection.Terms()))
+	return cost + cardinality*nterms*_COST_EVAL, cardinality, size, frCost + nterms*_COST_EVAL
+}
+
+// groups formed by expressions, from their distinct values where known
+func groupCount(exprs expression.Expressions, keyspaces map[string]string, cardinality float64) float64 {
+	groups := 1.0
+	for _, expr := range exprs {
+		if key := getSelecKey(expr, keyspaces); key != nil && key.hist != nil {
+			groups *= key.ndv()
+		} else {
+			groups *= 1.0 / _DEF_EQ_SELEC
+		}
+		if groups >= cardinality {
+			return math.Max(cardinality, 1.0)
+		}
+	}
+	return math.Max(groups, 1.0)
 }
 
 func getGroupCosts(group *algebra.Group, aggregates algebra.Aggregates, cost, cardinality float64,
 	size int64, keyspaces map[string]string, maxParallelism int) (
 	float64, float64, float64, float64, float64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL
+	if maxParallelism <= 0 {
+		maxParallelism = plan.GetMaxParallelism()
+	}
+
+	var groups float64
+	if group != nil && len(group.By()) > 0 {
+		groups = groupCount(group.By(), keyspaces, cardinality)
+	} else {
+		groups = 1.0
+	}
+
+	// each of the parallel initial groups may see every group
+	nterms := float64(len(aggregates) + 1)
+	cardInitial := math.Min(cardinality, groups*float64(maxParallelism))
+	costInitial := cost + cardinality*nterms*_COST_EVAL
+	costIntermediate := costInitial + cardInitial*nterms*_COST_EVAL
+	costFinal := costIntermediate + groups*nterms*_COST_EVAL
+	return costInitial, cardInitial, costIntermediate, groups, costFinal, groups
 }
 
 func getDistinctCost(terms algebra.ResultTerms, cost, cardinality float64, size int64, frCost float64,
 	keyspaces map[string]string) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	exprs := make(expression.Expressions, 0, len(terms))
+	for _, term := range terms {
+		if term.Expression() == nil {
+			// star terms are as distinct as the rows
+			return cost + cardinality*_COST_HASH_BUILD, cardinality, size, frCost + _COST_HASH_BUILD
+		}
+		exprs = append(exprs, term.Expression())
+	}
+	return cost + cardinality*_COST_HASH_BUILD, groupCount(exprs, keyspaces, cardinality), size,
+		frCost + _COST_HASH_BUILD
 }
 
 func getUnionDistinctCost(cost, cardinality float64, first, second plan.Operator, compatible bool) (float64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL
+	if cost <= 0.0 || cardinality <= 0.0 {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL
+	}
+	return cost + cardinality*_COST_HASH_BUILD, cardinality
+}
+
+func setOpCost(first, second plan.Operator, card float64, hashed bool) (float64, float64, int64, float64) {
+	if !validOp(first) || !validOp(second) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	cost := first.Cost() + second.Cost()
+	frCost := first.FrCost()
+	if hashed {
+		cost += second.Cardinality()*_COST_HASH_BUILD + first.Cardinality()*_COST_HASH_PROBE
+		frCost = second.Cost() + first.FrCost()
+	}
+	size := first.Size()
+	if second.Size() > size {
+		size = second.Size()
+	}
+	return cost, math.Max(card, 1.0), size, frCost
 }
 
 func getUnionAllCost(first, second plan.Operator, compatible bool) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(first) || !validOp(second) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	return setOpCost(first, second, first.Cardinality()+second.Cardinality(), false)
 }
 
 func getIntersectAllCost(first, second plan.Operator, compatible bool) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(first) || !validOp(second) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	return setOpCost(first, second, math.Min(first.Cardinality(), second.Cardinality()), true)
 }
 
 func getExceptAllCost(first, second plan.Operator, compatible bool) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	if !validOp(first) || !validOp(second) {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	return setOpCost(first, second, first.Cardinality(), true)
+}
+
+// rows left by a LIMIT clause, if it is a constant
+func limitCard(limit expression.Expression, cardinality float64) float64 {
+	if limit != nil {
+		if val := limit.Value(); val != nil && val.Type() == value.NUMBER {
+			if n := value.AsNumberValue(val).Float64(); n >= 0.0 && n < cardinality {
+				return math.Max(n, 1.0)
+			}
+		}
+	}
+	return cardinality
+}
+
+func writeCost(limit expression.Expression, nexprs int, cost, cardinality float64, size int64,
+	frCost float64) (float64, float64, int64, float64) {
+	if cost <= 0.0 || cardinality <= 0.0 {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	card := limitCard(limit, cardinality)
+	if size <= 0 {
+		size = _DEF_DOC_SIZE
+	}
+	rowCost := _COST_WRITE + float64(nexprs)*_COST_EVAL
+	return cost + card*rowCost, card, size, frCost + rowCost
 }
 
 func getInsertCost(key, value, options, limit expression.Expression, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return writeCost(limit, 3, cost, cardinality, size, frCost)
 }
 
 func getUpsertCost(key, value, options expression.Expression, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return writeCost(nil, 3, cost, cardinality, size, frCost)
 }
 
 func getDeleteCost(limit expression.Expression, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return writeCost(limit, 0, cost, cardinality, size, frCost)
+}
+
+func evalCost(nexprs int, cost, cardinality float64, size int64, frCost float64) (
+	float64, float64, int64, float64) {
+	if cost <= 0.0 || cardinality <= 0.0 {
+		return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	}
+	rowCost := float64(nexprs) * _COST_EVAL
+	return cost + cardinality*rowCost, cardinality, size, frCost + rowCost
 }
 
 func getCloneCost(cost, cardinality float64, size int64, frCost float64) (
 	float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return evalCost(1, cost, cardinality, size, frCost)
 }
 
 func getUpdateSetCost(set *algebra.Set, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return evalCost(len(set.Terms()), cost, cardinality, size, frCost)
 }
 
 func getUpdateUnsetCost(unset *algebra.Unset, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return evalCost(len(unset.Terms()), cost, cardinality, size, frCost)
 }
 
 func getUpdateSendCost(limit expression.Expression, cost, cardinality float64,
 	size int64, frCost float64) (float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return writeCost(limit, 0, cost, cardinality, size, frCost)
 }
 
 func getWindowAggCost(aggs algebra.Aggregates, cost, cardinality float64, size int64, frCost float64) (
 	float64, float64, int64, float64) {
-	return OPT_COST_NOT_AVAIL, OPT_CARD_NOT_AVAIL, OPT_SIZE_NOT_AVAIL, OPT_COST_NOT_AVAIL
+	return evalCost(len(aggs), cost, cardinality, size, frCost)
 }
 
 func getKeyspaceSize(keyspace string) int64 {
+	if stats := getOptStats(keyspace); stats != nil {
+		return stats.docSize
+	}
 	return OPT_SIZE_NOT_AVAIL
 }
@@ -233,6 +233,14 @@ func (this *scanIdxCol) VisitHashNest(op *plan.HashNest) (interface{}, error) {
 	return nil, nil
 }
 
+func (this *scanIdxCol) VisitMergeJoin(op *plan.MergeJoin) (interface{}, error) {
+	return nil, nil
+}
+
+func (this *scanIdxCol) VisitMergeNest(op *plan.MergeNest) (interface{}, error) {
+	return nil, nil
+}
+
 // Let + Letting, With
 func (this *scanIdxCol) VisitLet(op *plan.Let) (interface{}, error) {
 	return nil, nil
@@ -242,6 +250,10 @@ func (this *scanIdxCol) VisitWith(op *plan.With) (interface{}, error) {
 	return nil, nil
 }
 
+func (this *scanIdxCol) VisitRecursiveWith(op *plan.RecursiveWith) (interface{}, error) {
+	return nil, nil
+}
+
 // Filter
 func (this *scanIdxCol) VisitFilter(op *plan.Filter) (interface{}, error) {
 	return nil, nil
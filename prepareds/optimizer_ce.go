@@ -16,5 +16,5 @@ import (
 )
 
 func getNewOptimizer() planner.Optimizer {
-	return nil
+	return planner.NewOptimizer()
 }
@@ -0,0 +1,284 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package prepareds
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+	json "github.com/couchbase/go_json"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/plan"
+)
+
+/*
+Prepared statements can be written back to disk periodically, and
+reloaded at startup, so that a restarted node does not depend on its
+peers, if any, to recover them.
+Only statements prepared with an encoded plan are persisted: auto
+prepared statements are planned again on first use anyway.
+*/
+
+const _PERSIST_FILE = "prepareds.json"
+const DEF_PERSIST_INTERVAL = time.Minute
+
+// what is written for each statement
+type persistedPrepared struct {
+	Name            string `json:"name"`
+	QueryContext    string `json:"queryContext,omitempty"`
+	Namespace       string `json:"namespace"`
+	Text            string `json:"text"`
+	Type            string `json:"type,omitempty"`
+	IndexApiVersion int    `json:"indexApiVersion"`
+	FeatureControls uint64 `json:"featureControls"`
+	UseFts          bool   `json:"useFts,omitempty"`
+	UseCBO          bool   `json:"useCBO,omitempty"`
+	EncodedPlan     string `json:"encoded_plan"`
+}
+
+type persister struct {
+	// Aligned ints need to be declared right at the top
+	// of the struct to avoid alignment issues on x86 platforms
+	generation atomic.AlignedUint64 // bumped on every cache change
+	written    uint64               // generation last written
+
+	sync.Mutex
+	dir      string
+	interval time.Duration
+	running  bool
+	wake     chan bool
+}
+
+// nothing has been written yet
+const _NOT_WRITTEN = ^uint64(0)
+
+var persist = &persister{interval: DEF_PERSIST_INTERVAL, written: _NOT_WRITTEN}
+
+// note that the cache has changed
+func persistChanged() {
+	atomic.AddUint64(&persist.generation, 1)
+}
+
+func PreparedsPersistDir() string {
+	persist.Lock()
+	defer persist.Unlock()
+	return persist.dir
+}
+
+/*
+Set the directory prepared statements are persisted to.
+An empty directory disables persistence.
+*/
+func PreparedsSetPersistDir(dir string) errors.Error {
+	if dir != "" {
+		var err error
+
+		dir, err = filepath.Abs(dir)
+		if err == nil {
+			err = os.MkdirAll(dir, 0700)
+		}
+		if err != nil {
+			return errors.NewPreparedPersistError(err, dir)
+		}
+	}
+
+	persist.Lock()
+	defer persist.Unlock()
+	if dir == persist.dir {
+		return nil
+	}
+	persist.dir = dir
+	persist.written = _NOT_WRITTEN
+	if dir != "" && !persist.running {
+		persist.running = true
+		persist.wake = make(chan bool, 1)
+		go persist.run()
+	} else if dir == "" && persist.running {
+		persist.running = false
+		close(persist.wake)
+	}
+	return nil
+}
+
+func PreparedsPersistInterval() time.Duration {
+	persist.Lock()
+	defer persist.Unlock()
+	return persist.interval
+}
+
+func PreparedsSetPersistInterval(interval time.Duration) {
+	if interval <= 0 {
+		interval = DEF_PERSIST_INTERVAL
+	}
+	persist.Lock()
+	persist.interval = interval
+	if persist.running {
+		select {
+		case persist.wake <- true:
+		default:
+		}
+	}
+	persist.Unlock()
+}
+
+// write back loop
+func (this *persister) run() {
+	this.Lock()
+	wake := this.wake
+	this.Unlock()
+
+	for {
+		timer := time.NewTimer(PreparedsPersistInterval())
+		select {
+		case _, ok := <-wake:
+			timer.Stop()
+			if !ok {
+				return
+			}
+
+			// the interval has changed
+			continue
+		case <-timer.C:
+		}
+		err := PreparedsPersist()
+		if err != nil {
+			logging.Errorf("Unable to persist prepared statements: %v", err)
+		}
+	}
+}
+
+/*
+Write all the prepared statements with an encoded plan to the persistence
+directory, if there have been changes since the last write.
+The file is replaced atomically, so that a crash never leaves a partial file.
+*/
+func PreparedsPersist() errors.Error {
+	persist.Lock()
+	defer persist.Unlock()
+
+	if persist.dir == "" {
+		return nil
+	}
+	generation := atomic.LoadUint64(&persist.generation)
+	if generation == persist.written {
+		return nil
+	}
+
+	entries := make([]*persistedPrepared, 0, CountPrepareds())
+	PreparedsForeach(func(name string, ce *CacheEntry) bool {
+		p := ce.Prepared
+		if p == nil || p.EncodedPlan() == "" || p.EncodedPlan() == EmptyPlan {
+			return true
+		}
+		entries = append(entries, &persistedPrepared{
+			Name:            p.Name(),
+			QueryContext:    p.QueryContext(),
+			Namespace:       p.Namespace(),
+			Text:            p.Text(),
+			Type:            p.Type(),
+			IndexApiVersion: p.IndexApiVersion(),
+			FeatureControls: p.FeatureControls(),
+			UseFts:          p.UseFts(),
+			UseCBO:          p.UseCBO(),
+			EncodedPlan:     p.EncodedPlan(),
+		})
+		return true
+	}, nil)
+
+	bytes, err := json.Marshal(entries)
+	if err != nil {
+		return errors.NewPreparedPersistError(err, persist.dir)
+	}
+	path := filepath.Join(persist.dir, _PERSIST_FILE)
+	err = ioutil.WriteFile(path+".tmp", bytes, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		return errors.NewPreparedPersistError(err, path)
+	}
+	persist.written = generation
+	return nil
+}
+
+/*
+Reload the prepared statements from the persistence directory.
+Plans are decoded and verified as if they came from another node, and
+prepared again if the metadata has changed in the meantime; if a plan
+cannot be decoded at all, the statement is prepared from its text.
+Returns the number of statements loaded.
+*/
+func PreparedsLoad() (int, errors.Error) {
+	dir := PreparedsPersistDir()
+	if dir == "" {
+		return 0, nil
+	}
+
+	path := filepath.Join(dir, _PERSIST_FILE)
+	bytes, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	} else if err != nil {
+		return 0, errors.NewPreparedPersistError(err, path)
+	}
+
+	var entries []*persistedPrepared
+	err = json.Unmarshal(bytes, &entries)
+	if err != nil {
+		return 0, errors.NewPreparedPersistError(err, path)
+	}
+
+	count := 0
+	for _, entry := range entries {
+		fullName := encodeName(entry.Name, entry.QueryContext)
+		_, err1 := DecodePreparedWithContext(fullName, "", entry.EncodedPlan, false, nil, true)
+		if err1 != nil {
+			err1 = loadFromText(entry)
+		}
+		if err1 != nil {
+			logging.Infof("Unable to reload prepared statement <ud>%v</ud>: %v", fullName, err1)
+			continue
+		}
+		count++
+	}
+	return count, nil
+}
+
+func loadFromText(entry *persistedPrepared) errors.Error {
+	if entry.Text == "" {
+		return errors.NewPreparedDecodingError(fmt.Errorf("no statement text"))
+	}
+
+	prepared := plan.NewPrepared(nil, nil, nil)
+	prepared.SetName(entry.Name)
+	prepared.SetText(entry.Text)
+	prepared.SetType(entry.Type)
+	prepared.SetNamespace(entry.Namespace)
+	prepared.SetQueryContext(entry.QueryContext)
+	prepared.SetIndexApiVersion(entry.IndexApiVersion)
+	prepared.SetFeatureControls(entry.FeatureControls)
+	prepared.SetUseFts(entry.UseFts)
+	prepared.SetUseCBO(entry.UseCBO)
+
+	prepared, err := reprepare(prepared, nil, nil)
+	if err != nil {
+		return err
+	}
+	prepareds.add(prepared, true, false, func(ce *CacheEntry) bool {
+		return ce.Prepared.Text() == prepared.Text()
+	})
+	return nil
+}
@@ -0,0 +1,158 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package prepareds
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/datastore/mock"
+	"github.com/couchbase/query/plan"
+)
+
+func initPersist(t *testing.T) string {
+	store, err := mock.NewDatastore("mock:")
+	if err != nil {
+		t.Fatalf("Failed to create datastore: %v", err)
+	}
+	datastore.SetDatastore(store)
+	PreparedsInit(10)
+	PreparedsReprepareInit(store, nil)
+
+	dir, err1 := ioutil.TempDir("", "prepareds")
+	if err1 != nil {
+		t.Fatalf("Failed to create directory: %v", err1)
+	}
+	if err := PreparedsSetPersistDir(dir); err != nil {
+		t.Fatalf("Failed to set the persistence directory: %v", err)
+	}
+	return dir
+}
+
+func closePersist(dir string) {
+	PreparedsSetPersistDir("")
+	os.RemoveAll(dir)
+}
+
+func addPersisted(t *testing.T, name, text string) {
+	prepared := plan.NewPrepared(nil, nil, nil)
+	prepared.SetName(name)
+	prepared.SetText("PREPARE " + name + " FROM " + text)
+	prepared.SetNamespace("p0")
+	prepared, err := reprepare(prepared, nil, nil)
+	if err != nil {
+		t.Fatalf("Failed to prepare %v: %v", text, err)
+	}
+	if err = AddPrepared(prepared); err != nil {
+		t.Fatalf("Failed to add %v: %v", name, err)
+	}
+}
+
+// the statements in the persistence file, by name
+func persisted(t *testing.T, dir string) string {
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, _PERSIST_FILE))
+	if err != nil {
+		t.Fatalf("Failed to read the persisted statements: %v", err)
+	}
+	return string(bytes)
+}
+
+func TestPersistPrepareds(t *testing.T) {
+	dir := initPersist(t)
+	defer closePersist(dir)
+
+	addPersisted(t, "p1", "SELECT 1")
+	addPersisted(t, "p2", "SELECT 2")
+	if err := PreparedsPersist(); err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+	if out := persisted(t, dir); !strings.Contains(out, `"p1"`) || !strings.Contains(out, `"p2"`) {
+		t.Errorf("Expected p1 and p2 to be persisted, got %v", out)
+	}
+
+	// nothing is written if nothing has changed
+	os.Remove(filepath.Join(dir, _PERSIST_FILE))
+	if err := PreparedsPersist(); err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, _PERSIST_FILE)); !os.IsNotExist(err) {
+		t.Errorf("Expected the statements not to be written again")
+	}
+
+	// deleted statements are no longer persisted
+	if err := DeletePrepared("p1"); err != nil {
+		t.Fatalf("Failed to delete p1: %v", err)
+	}
+	if err := PreparedsPersist(); err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+	if out := persisted(t, dir); strings.Contains(out, `"p1"`) || !strings.Contains(out, `"p2"`) {
+		t.Errorf("Expected only p2 to be persisted, got %v", out)
+	}
+
+	// statements evicted by a lower limit are no longer persisted
+	addPersisted(t, "p3", "SELECT 3")
+	if err := PreparedsPersist(); err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+	PreparedsSetLimit(1)
+	if err := PreparedsPersist(); err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+	if out := persisted(t, dir); strings.Count(out, `"name"`) != 1 {
+		t.Errorf("Expected one statement to be persisted, got %v", out)
+	}
+}
+
+func TestReloadPrepareds(t *testing.T) {
+	dir := initPersist(t)
+	defer closePersist(dir)
+
+	addPersisted(t, "r1", "SELECT 1")
+	addPersisted(t, "r2", "SELECT 2")
+	if err := PreparedsPersist(); err != nil {
+		t.Fatalf("Failed to persist: %v", err)
+	}
+
+	// plans that cannot be decoded are prepared again from the text
+	out := persisted(t, dir)
+	start := strings.Index(out, `"encoded_plan":"`) + len(`"encoded_plan":"`)
+	out = out[:start] + "x" + out[start:]
+	if err := ioutil.WriteFile(filepath.Join(dir, _PERSIST_FILE), []byte(out), 0600); err != nil {
+		t.Fatalf("Failed to write the persisted statements: %v", err)
+	}
+
+	PreparedsInit(10)
+	count, err := PreparedsLoad()
+	if err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("Expected 2 statements to be reloaded, got %v", count)
+	}
+	for _, name := range []string{"r1", "r2"} {
+		prepared, err := GetPrepared(name, nil)
+		if err != nil || prepared == nil {
+			t.Errorf("Expected %v to be reloaded: %v", name, err)
+		} else if !strings.HasSuffix(prepared.Text(), "SELECT "+name[1:]) {
+			t.Errorf("Unexpected statement for %v: %v", name, prepared.Text())
+		}
+	}
+
+	// no file, nothing to reload
+	os.Remove(filepath.Join(dir, _PERSIST_FILE))
+	if count, err = PreparedsLoad(); err != nil || count != 0 {
+		t.Errorf("Expected nothing to be reloaded, got %v: %v", count, err)
+	}
+}
@@ -247,7 +247,11 @@ func PreparedsLimit() int {
 }
 
 func PreparedsSetLimit(limit int) {
+	size := prepareds.cache.Size()
 	prepareds.cache.SetLimit(limit)
+	if prepareds.cache.Size() != size {
+		persistChanged()
+	}
 }
 
 func (this *preparedCache) get(fullName string, track bool) *CacheEntry {
@@ -310,6 +314,9 @@ func (this *preparedCache) add(prepared *plan.Prepared, populated bool, track bo
 		}
 		return op
 	})
+
+	// after the entry is in, and any least recently used one evicted
+	persistChanged()
 }
 
 // Auto Prepare
@@ -450,6 +457,7 @@ func AddPrepared(prepared *plan.Prepared) errors.Error {
 
 func DeletePrepared(name string) errors.Error {
 	if prepareds.cache.Delete(name, nil) {
+		persistChanged()
 		return nil
 	}
 	return errors.NewNoSuchPreparedError(name)
@@ -23,7 +23,10 @@ func (this *Rewrite) VisitSubselect(node *algebra.Subselect) (r interface{}, err
 }
 
 func (this *Rewrite) VisitSubquery(expr expression.Subquery) (r interface{}, err error) {
-	if node, ok := expr.(*algebra.Subquery); ok {
+	switch node := expr.(type) {
+	case *algebra.Subquery:
+		_, err = node.Select().Accept(this)
+	case *algebra.With:
 		_, err = node.Select().Accept(this)
 	}
 	return expr, err
@@ -0,0 +1,144 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package schemas
+
+import (
+	"sync"
+
+	"github.com/couchbase/query/datastore"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
+	"github.com/santhosh-tekuri/jsonschema/v5"
+)
+
+/*
+Validation schemas are JSON Schema documents attached to keyspaces: documents
+written to a keyspace by INSERT, UPSERT and UPDATE must conform to its schema.
+Schemas are stored in metakv, so that all the query nodes share them, or
+locally if the node is not part of a cluster, and are keyed by the qualified
+name of the keyspace. They are compiled on first use, and recompiled when
+the store changes.
+A document that fails validation is not written: the violations are reported
+as an error for that document, and the statement goes on with the remaining
+documents, as it does for the other errors specific to a document, such as an
+INSERT of an existing key. UPDATE ... RETURNING does not return documents
+that failed validation.
+*/
+
+type schemaStore interface {
+	get(keyspace string) ([]byte, errors.Error)
+	set(keyspace string, schema []byte) errors.Error
+	delete(keyspace string) (bool, errors.Error)
+	foreach(f func(keyspace string, schema []byte) bool) errors.Error
+	changed() int32
+}
+
+// compiled schemas, nil for keyspaces that don't have one
+type schemaCache struct {
+	sync.RWMutex
+	changed int32
+	schemas map[string]*jsonschema.Schema
+}
+
+var store schemaStore = newLocalSchemas()
+var cache = &schemaCache{schemas: make(map[string]*jsonschema.Schema)}
+
+// the keyspace's schema, if any, is checked before it is stored
+func Set(keyspace datastore.Keyspace, schema value.Value) errors.Error {
+	_, err := expression.NewJsonSchema(schema)
+	if err != nil {
+		return errors.NewInvalidSchemaError(keyspace.QualifiedName(), err)
+	}
+	bytes, err := schema.MarshalJSON()
+	if err != nil {
+		return errors.NewInvalidSchemaError(keyspace.QualifiedName(), err)
+	}
+	return store.set(keyspace.QualifiedName(), bytes)
+}
+
+func Get(keyspace datastore.Keyspace) (value.Value, errors.Error) {
+	bytes, err := store.get(keyspace.QualifiedName())
+	if err != nil || bytes == nil {
+		return nil, err
+	}
+	return value.NewValue(bytes), nil
+}
+
+// returns false if the keyspace had no schema
+func Delete(keyspace datastore.Keyspace) (bool, errors.Error) {
+	return store.delete(keyspace.QualifiedName())
+}
+
+func Foreach(f func(keyspace string, schema value.Value) bool) errors.Error {
+	return store.foreach(func(keyspace string, schema []byte) bool {
+		return f(keyspace, value.NewValue(schema))
+	})
+}
+
+/*
+Checks a document about to be written to the keyspace against the keyspace's
+schema, if it has one.
+The error lists all the violations found, for the client to act upon.
+*/
+func Validate(keyspace datastore.Keyspace, key string, doc value.Value) errors.Error {
+	name := keyspace.QualifiedName()
+	schema, err := load(name)
+	if err != nil {
+		return err
+	}
+	if schema == nil {
+		return nil
+	}
+
+	violations, err1 := expression.JsonSchemaErrors(doc, schema)
+	if err1 != nil {
+		return errors.NewSchemaValidationError(name, key, nil, err1)
+	}
+	if len(violations) > 0 {
+		return errors.NewSchemaValidationError(name, key, violations, nil)
+	}
+	return nil
+}
+
+// get the keyspace's schema from the cache, or compile it
+func load(keyspace string) (*jsonschema.Schema, errors.Error) {
+	changed := store.changed()
+
+	cache.RLock()
+	schema, ok := cache.schemas[keyspace]
+	current := cache.changed == changed
+	cache.RUnlock()
+	if ok && current {
+		return schema, nil
+	}
+
+	bytes, err := store.get(keyspace)
+	if err != nil {
+		return nil, err
+	}
+	if bytes != nil {
+		var err1 error
+
+		schema, err1 = expression.NewJsonSchema(value.NewValue(bytes))
+		if err1 != nil {
+			return nil, errors.NewInvalidSchemaError(keyspace, err1)
+		}
+	}
+
+	cache.Lock()
+	if cache.changed != changed {
+		cache.schemas = make(map[string]*jsonschema.Schema)
+		cache.changed = changed
+	}
+	cache.schemas[keyspace] = schema
+	cache.Unlock()
+	return schema, nil
+}
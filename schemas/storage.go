@@ -0,0 +1,257 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package schemas
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+
+	"github.com/couchbase/cbauth"
+	"github.com/couchbase/cbauth/metakv"
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/errors"
+)
+
+const _SCHEMA_PATH = "/query/schemas/"
+
+// local schemas are written to this file in the schema directory, if any
+const _SCHEMA_FILE = "schemas.json"
+
+// schemas shared through metakv, with changes made by any node counted as they are observed
+type metaSchemas struct {
+	changeCounter int32
+}
+
+/*
+When metakv is not available (no cbauth, so not part of a cluster), schemas
+are kept locally, and written to a file in the schema directory, if one is set,
+on every change, so that they survive restarts.
+*/
+type localSchemas struct {
+	sync.RWMutex
+	schemas       map[string][]byte
+	changeCounter int32
+	dir           string
+}
+
+func newLocalSchemas() *localSchemas {
+	return &localSchemas{schemas: make(map[string][]byte)}
+}
+
+// dir is where the schemas are kept when not part of a cluster, memory only if empty
+func Init(dir string) errors.Error {
+	if cbauth.Default != nil {
+		meta := &metaSchemas{}
+		store = meta
+
+		// fire callback runner. It won't ever return
+		go metakv.RunObserveChildren(_SCHEMA_PATH, meta.callback, make(chan struct{}))
+		return nil
+	}
+
+	local := newLocalSchemas()
+	if dir != "" {
+		err := local.load(dir)
+		if err != nil {
+			return err
+		}
+	}
+	store = local
+	return nil
+}
+
+func (this *metaSchemas) callback(path string, val []byte, rev interface{}) error {
+	atomic.AddInt32(&this.changeCounter, 1)
+	return nil
+}
+
+func (this *metaSchemas) get(keyspace string) ([]byte, errors.Error) {
+	schema, _, err := metakv.Get(_SCHEMA_PATH + keyspace)
+	if err != nil {
+		return nil, errors.NewMetaKVError(keyspace, err)
+	}
+	return schema, nil
+}
+
+func (this *metaSchemas) set(keyspace string, schema []byte) errors.Error {
+	err := metakv.Set(_SCHEMA_PATH+keyspace, schema, nil)
+	if err != nil {
+		return errors.NewMetaKVError(keyspace, err)
+	}
+
+	// don't wait for the change to be observed
+	atomic.AddInt32(&this.changeCounter, 1)
+	return nil
+}
+
+func (this *metaSchemas) delete(keyspace string) (bool, errors.Error) {
+
+	// Delete() does not report missing keys
+	schema, _, err := metakv.Get(_SCHEMA_PATH + keyspace)
+	if schema == nil && err == nil {
+		return false, nil
+	} else if err != nil {
+		return false, errors.NewMetaKVError(keyspace, err)
+	}
+
+	err = metakv.Delete(_SCHEMA_PATH+keyspace, nil)
+	if err != nil {
+		return false, errors.NewMetaKVError(keyspace, err)
+	}
+	atomic.AddInt32(&this.changeCounter, 1)
+	return true, nil
+}
+
+func (this *metaSchemas) foreach(f func(keyspace string, schema []byte) bool) errors.Error {
+	stopped := false
+	err := metakv.IterateChildren(_SCHEMA_PATH, func(path string, schema []byte, rev interface{}) error {
+		if !stopped && !f(path[len(_SCHEMA_PATH):], schema) {
+			stopped = true
+		}
+		return nil
+	})
+	if err != nil {
+		return errors.NewMetaKVIndexError(err)
+	}
+	return nil
+}
+
+func (this *metaSchemas) changed() int32 {
+	return atomic.LoadInt32(&this.changeCounter)
+}
+
+func (this *localSchemas) get(keyspace string) ([]byte, errors.Error) {
+	this.RLock()
+	defer this.RUnlock()
+	return this.schemas[keyspace], nil
+}
+
+func (this *localSchemas) set(keyspace string, schema []byte) errors.Error {
+	this.Lock()
+	defer this.Unlock()
+	old, ok := this.schemas[keyspace]
+	this.schemas[keyspace] = schema
+	err := this.persist()
+	if err != nil {
+
+		// the change is only made if it can be persisted
+		if ok {
+			this.schemas[keyspace] = old
+		} else {
+			delete(this.schemas, keyspace)
+		}
+		return err
+	}
+	this.changeCounter++
+	return nil
+}
+
+func (this *localSchemas) delete(keyspace string) (bool, errors.Error) {
+	this.Lock()
+	defer this.Unlock()
+	old, ok := this.schemas[keyspace]
+	if !ok {
+		return false, nil
+	}
+	delete(this.schemas, keyspace)
+	err := this.persist()
+	if err != nil {
+		this.schemas[keyspace] = old
+		return false, err
+	}
+	this.changeCounter++
+	return true, nil
+}
+
+func (this *localSchemas) foreach(f func(keyspace string, schema []byte) bool) errors.Error {
+	this.RLock()
+	keyspaces := make([]string, 0, len(this.schemas))
+	for keyspace, _ := range this.schemas {
+		keyspaces = append(keyspaces, keyspace)
+	}
+	this.RUnlock()
+	sort.Strings(keyspaces)
+
+	for _, keyspace := range keyspaces {
+		schema, _ := this.get(keyspace)
+		if schema != nil && !f(keyspace, schema) {
+			break
+		}
+	}
+	return nil
+}
+
+func (this *localSchemas) changed() int32 {
+	this.RLock()
+	defer this.RUnlock()
+	return this.changeCounter
+}
+
+// read the schemas written to the directory, if any
+func (this *localSchemas) load(dir string) errors.Error {
+	dir, err := filepath.Abs(dir)
+	if err == nil {
+		err = os.MkdirAll(dir, 0700)
+	}
+	if err != nil {
+		return errors.NewSchemaPersistError(err, dir)
+	}
+
+	bytes, err := ioutil.ReadFile(filepath.Join(dir, _SCHEMA_FILE))
+	if err != nil && !os.IsNotExist(err) {
+		return errors.NewSchemaPersistError(err, dir)
+	}
+	schemas := make(map[string]json.RawMessage)
+	if len(bytes) > 0 {
+		err = json.Unmarshal(bytes, &schemas)
+		if err != nil {
+			return errors.NewSchemaPersistError(err, dir)
+		}
+	}
+
+	this.Lock()
+	defer this.Unlock()
+	this.dir = dir
+	this.schemas = make(map[string][]byte, len(schemas))
+	for keyspace, schema := range schemas {
+		this.schemas[keyspace] = []byte(schema)
+	}
+	this.changeCounter++
+	return nil
+}
+
+// write all the schemas, replacing the file once complete, with the lock held
+func (this *localSchemas) persist() errors.Error {
+	if this.dir == "" {
+		return nil
+	}
+	schemas := make(map[string]json.RawMessage, len(this.schemas))
+	for keyspace, schema := range this.schemas {
+		schemas[keyspace] = json.RawMessage(schema)
+	}
+	bytes, err := json.Marshal(schemas)
+	if err != nil {
+		return errors.NewSchemaPersistError(err, this.dir)
+	}
+	path := filepath.Join(this.dir, _SCHEMA_FILE)
+	err = ioutil.WriteFile(path+".tmp", bytes, 0600)
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return errors.NewSchemaPersistError(err, this.dir)
+	}
+	return nil
+}
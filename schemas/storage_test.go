@@ -0,0 +1,75 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package schemas
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLocalSchemasPersist(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schemas")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	local := newLocalSchemas()
+	if err := local.load(dir); err != nil {
+		t.Fatalf("Failed to load from an empty directory: %v", err)
+	}
+	local.set("p:a", []byte(`{"type": "object"}`))
+	local.set("p:b", []byte(`{"type": "array"}`))
+	if ok, err := local.delete("p:a"); !ok || err != nil {
+		t.Fatalf("Failed to delete p:a: %v", err)
+	}
+
+	// schemas survive a restart
+	reloaded := newLocalSchemas()
+	if err := reloaded.load(dir); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	var keyspaces []string
+	reloaded.foreach(func(keyspace string, schema []byte) bool {
+		keyspaces = append(keyspaces, keyspace+" "+string(schema))
+		return true
+	})
+	if len(keyspaces) != 1 || keyspaces[0] != `p:b {"type":"array"}` {
+		t.Errorf("Expected only p:b to be reloaded, got %v", keyspaces)
+	}
+	if reloaded.changed() == 0 {
+		t.Errorf("Expected loading to count as a change")
+	}
+
+	// changes that can't be written are not made
+	os.RemoveAll(dir)
+	changed := reloaded.changed()
+	if err := reloaded.set("p:c", []byte(`{}`)); err == nil {
+		t.Errorf("Expected the change not to be persisted")
+	}
+	if ok, err := reloaded.delete("p:b"); ok || err == nil {
+		t.Errorf("Expected the delete not to be persisted")
+	}
+	if schema, _ := reloaded.get("p:b"); schema == nil || reloaded.changed() != changed {
+		t.Errorf("Expected the schemas not to change")
+	}
+	if schema, _ := reloaded.get("p:c"); schema != nil {
+		t.Errorf("Expected p:c not to be set")
+	}
+
+	// files that can't be read are reported
+	os.MkdirAll(dir, 0700)
+	ioutil.WriteFile(filepath.Join(dir, _SCHEMA_FILE), []byte("{"), 0600)
+	if err := newLocalSchemas().load(dir); err == nil {
+		t.Errorf("Expected a corrupted file to fail")
+	}
+}
@@ -14,6 +14,7 @@ import (
 
 	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/value"
 )
 
@@ -51,6 +52,33 @@ func (this *SemChecker) visitAggregateFunction(agg algebra.Aggregate) (err error
 			"semantics.visit_aggregate_function.filter")
 	}
 
+	// Aggregate syntax has ORDER BY or WITHIN GROUP, but aggregate doesn't support it
+	if len(agg.Order()) > 0 {
+		if agg.WithinGroup() {
+			if !algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_ALLOWS_WITHIN_GROUP) {
+				return errors.NewWindowSemanticError(aggName, "WITHIN GROUP clause ", "is not allowed.",
+					"semantics.visit_aggregate_function.order")
+			} else if len(agg.Order()) != 1 {
+				return errors.NewWindowSemanticError(aggName, "WITHIN GROUP clause ", "must have one ORDER BY term.",
+					"semantics.visit_aggregate_function.order")
+			}
+		} else if !algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_ALLOWS_ORDER) {
+			return errors.NewWindowSemanticError(aggName, "ORDER BY clause ", "is not allowed.",
+				"semantics.visit_aggregate_function.order")
+		}
+	} else if algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_REQUIRES_WITHIN_GROUP) {
+		return errors.NewWindowSemanticError(aggName, "WITHIN GROUP clause ", "is required.",
+			"semantics.visit_aggregate_function.order")
+	}
+
+	// Aggregate with optional WITHIN GROUP aggregates either its argument or the ORDER BY term
+	if algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_ALLOWS_WITHIN_GROUP) &&
+		!algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_REQUIRES_WITHIN_GROUP) &&
+		(len(agg.Operands()) == 0) != agg.WithinGroup() {
+		return errors.NewWindowSemanticError(aggName, "", "requires either one argument or WITHIN GROUP clause.",
+			"semantics.visit_aggregate_function.order")
+	}
+
 	wTerm := agg.WindowTerm()
 	if wTerm == nil {
 		if algebra.AggregateHasProperty(aggName, algebra.AGGREGATE_ALLOWS_REGULAR) {
@@ -145,7 +173,27 @@ func (this *SemChecker) visitAggregateFunction(agg algebra.Aggregate) (err error
 	}
 
 	for _, wfe := range wfes {
-		if wfe.HasModifier(algebra.WINDOW_FRAME_VALUE_FOLLOWING | algebra.WINDOW_FRAME_VALUE_PRECEDING) {
+		if wfe.Interval() {
+			// INTERVAL value_expr must be a constant or expression and must evaluate to an interval string.
+			// Only RANGE window frame over dates.
+			valExpr := wfe.ValueExpression()
+			ok := windowFrame.RangeWindowFrame() && valExpr != nil && valExpr.Static() != nil
+			if ok {
+				val := valExpr.Value()
+				if val != nil {
+					ok = val.Type() == value.STRING
+					if ok {
+						_, err := expression.ParseInterval(val.ToString())
+						ok = err == nil
+					}
+				}
+			}
+
+			if !ok {
+				return errors.NewWindowSemanticError(aggName, "window frame ", "interval expression is invalid.",
+					"semantics.visit_aggregate_function.windowframe")
+			}
+		} else if wfe.HasModifier(algebra.WINDOW_FRAME_VALUE_FOLLOWING | algebra.WINDOW_FRAME_VALUE_PRECEDING) {
 
 			// value_expr must be a constant or expression and must evaluate to a positive numeric value.
 			valExpr := wfe.ValueExpression()
@@ -48,10 +48,6 @@ func (this *SemChecker) visitSearchFunction(search *search.Search) (err error) {
 }
 
 func (this *SemChecker) visitAdvisorFunction(advisor *expression.Advisor) (err error) {
-	if !this.hasSemFlag(_SEM_ENTERPRISE) {
-		return errors.NewEnterpriseFeature("Advisor Function", "semantics.visit_advisor_function")
-	}
-
 	if !this.hasSemFlag(_SEM_PROJECTION) {
 		return errors.NewAdvisorProjOnly()
 	}
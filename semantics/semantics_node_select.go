@@ -13,6 +13,7 @@ import (
 	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/expression"
+	"github.com/couchbase/query/value"
 )
 
 func (this *SemChecker) VisitSelectTerm(node *algebra.SelectTerm) (interface{}, error) {
@@ -73,8 +74,64 @@ func (this *SemChecker) VisitSubselect(node *algebra.Subselect) (r interface{},
 }
 
 func (this *SemChecker) VisitSubquery(expr expression.Subquery) (r interface{}, err error) {
-	if node, ok := expr.(*algebra.Subquery); ok {
+	switch node := expr.(type) {
+	case *algebra.Subquery:
 		_, err = node.Select().Accept(this)
+	case *algebra.With:
+		if err = checkRecursiveWith(node); err == nil {
+			_, err = node.Select().Accept(this)
+		}
 	}
 	return expr, err
 }
+
+func checkRecursiveWith(node *algebra.With) error {
+	alias := node.Alias()
+	if options := node.Options(); options != nil {
+		if options.Value() == nil || options.Value().Type() != value.OBJECT {
+			return errors.NewRecursiveWithSemanticError("OPTIONS of " + alias + " must be a constant object")
+		}
+		for name, v := range options.Value().Fields() {
+			if name != "levels" && name != "documents" {
+				return errors.NewRecursiveWithSemanticError("unknown option " + name + " for " + alias)
+			}
+			val := value.NewValue(v)
+			if val.Type() != value.NUMBER || !value.IsInt(value.AsNumberValue(val).Float64()) ||
+				value.AsNumberValue(val).Int64() < 0 {
+				return errors.NewRecursiveWithSemanticError("option " + name + " of " + alias +
+					" must be a non-negative integer")
+			}
+		}
+	}
+
+	if refersTo(node.Anchor().Expressions(), alias) {
+		return errors.NewRecursiveWithSemanticError("anchor member of " + alias + " cannot reference " + alias)
+	}
+	if !refersTo(node.Recursive().Expressions(), alias) {
+		return errors.NewRecursiveWithSemanticError("recursive member of " + alias + " must reference " + alias)
+	}
+
+	sub, ok := node.Recursive().Subresult().(*algebra.Subselect)
+	if !ok {
+		return errors.NewRecursiveWithSemanticError("recursive member of " + alias + " must be a single SELECT")
+	}
+	if sub.Group() != nil {
+		return errors.NewRecursiveWithSemanticError("GROUP BY is not allowed in the recursive member of " + alias)
+	}
+	return nil
+}
+
+func refersTo(exprs expression.Expressions, alias string) bool {
+	for _, expr := range exprs {
+		if expr == nil {
+			continue
+		}
+		if ident, ok := expr.(*expression.Identifier); ok && ident.Identifier() == alias {
+			return true
+		}
+		if refersTo(expr.Children(), alias) {
+			return true
+		}
+	}
+	return false
+}
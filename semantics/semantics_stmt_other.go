@@ -28,9 +28,6 @@ func (this *SemChecker) VisitExplain(stmt *algebra.Explain) (interface{}, error)
 }
 
 func (this *SemChecker) VisitAdvise(stmt *algebra.Advise) (interface{}, error) {
-	if !this.hasSemFlag(_SEM_ENTERPRISE) {
-		return nil, errors.NewEnterpriseFeature("Advise", "semantics.visit_advise")
-	}
 	switch stmt.Statement().Type() {
 	case "SELECT", "DELETE", "MERGE", "UPDATE":
 		return stmt.Statement().Accept(this)
@@ -52,9 +49,6 @@ func (this *SemChecker) VisitInferKeyspace(stmt *algebra.InferKeyspace) (interfa
 }
 
 func (this *SemChecker) VisitUpdateStatistics(stmt *algebra.UpdateStatistics) (interface{}, error) {
-	if !this.hasSemFlag(_SEM_ENTERPRISE) {
-		return nil, errors.NewEnterpriseFeature("Update Statistics", "semantics.visit_update_statistics")
-	}
 	if (stmt.IndexAll() || len(stmt.Indexes()) > 0) &&
 		(stmt.Using() != datastore.GSI && stmt.Using() != datastore.DEFAULT) {
 		return nil, errors.NewUpdateStatInvalidIndexTypeError()
@@ -14,6 +14,7 @@ import (
 	"fmt"
 	"os"
 	"os/signal"
+	"path/filepath"
 	"runtime"
 	"runtime/debug"
 	"runtime/pprof"
@@ -36,8 +37,10 @@ import (
 	log_resolver "github.com/couchbase/query/logging/resolver"
 	"github.com/couchbase/query/prepareds"
 	"github.com/couchbase/query/scheduler"
+	"github.com/couchbase/query/schemas"
 	server_package "github.com/couchbase/query/server"
 	"github.com/couchbase/query/server/http"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 )
 
@@ -101,9 +104,17 @@ var MEM_PROFILE = flag.String("memprofile", "", "write memory profile to this fi
 // Monitoring API
 var COMPLETED_THRESHOLD = flag.Int("completed-threshold", _DEF_COMPLETED_THRESHOLD, "cache completed query lasting longer than this many milliseconds")
 var COMPLETED_LIMIT = flag.Int("completed-limit", _DEF_COMPLETED_LIMIT, "maximum number of completed requests")
+var COMPLETED_ARCHIVE_DIR = flag.String("completed-archive-dir", "", "Directory completed requests are archived to, no archive if not set")
+var COMPLETED_ARCHIVE_SIZE = flag.Int64("completed-archive-size", server_package.DEF_ARCHIVE_FILE_SIZE/(1024*1024), "Maximum size of a completed requests archive file, in MB")
+var COMPLETED_ARCHIVE_FILES = flag.Int("completed-archive-files", server_package.DEF_ARCHIVE_FILES, "Maximum number of completed requests archive files kept")
 
 var PREPARED_LIMIT = flag.Int("prepared-limit", _DEF_PREPARED_LIMIT, "maximum number of prepared statements")
 var AUTO_PREPARE = flag.Bool("auto-prepare", false, "Silently prepare ad hoc statements if possible")
+var PREPARED_PERSIST_DIR = flag.String("prepared-persist-dir", "", "Directory prepared statements are persisted to, and reloaded from at startup, no persistence if not set")
+var PREPARED_PERSIST_INTERVAL = flag.Duration("prepared-persist-interval", prepareds.DEF_PERSIST_INTERVAL, "How often changes to the prepared statements are written back to disk")
+
+// Validation schemas
+var SCHEMA_DIR = flag.String("schema-dir", "", "Directory validation schemas are kept in when not part of a cluster, memory only if not set")
 
 var FUNCTIONS_LIMIT = flag.Int("functions-limit", _DEF_FUNCTIONS_LIMIT, "maximum number of cached functions")
 var TASKS_LIMIT = flag.Int("tasks-limit", _DEF_TASKS_LIMIT, "maximum number of cached tasks")
@@ -117,6 +128,21 @@ var _GOGC_PERCENT = 150
 // Dictionary Cache
 var DICTIONARY_CACHE_LIMIT = flag.Int("dictionary-cache-limit", _DEF_DICTIONARY_CACHE_LIMIT, "maximum number of entries in dictionary cache")
 
+// Spilling to disk
+var SPILL_DIR = flag.String("spill-dir", "", "Directory for the files operators spill to, defaults to the system temporary directory")
+var SPILL_LIMIT = flag.Int64("spill-limit", util.DEF_SPILL_LIMIT/(1024*1024), "Maximum amount of disk space spill files can take, in MB, 0 disables spilling")
+var SPILL_THRESHOLD = flag.Int64("spill-threshold", util.DEF_SPILL_THRESHOLD/(1024*1024), "Amount of memory an operator can use before spilling to disk, in MB")
+
+// Tracing
+var TRACE_COLLECTOR = flag.String("trace-collector", "", "OTLP/HTTP endpoint of the collector request traces are exported to, tracing is off if not set")
+
+// Auditing to local files
+var AUDIT_DIR = flag.String("audit-dir", "", "Directory audit records are written to, in place of the audit daemon, if set")
+var AUDIT_DESCRIPTOR = flag.String("audit-descriptor", "", "Audit event descriptor file, defaults to etc/audit_descriptor.json in the installation directory")
+var AUDIT_EVENTS = flag.String("audit-events", "", "Comma separated ids of audit events to record in addition to the default ones, or all")
+var AUDIT_FILE_SIZE = flag.Int64("audit-file-size", audit.DEF_AUDIT_FILE_SIZE/(1024*1024), "Maximum size of an audit file, in MB")
+var AUDIT_FILES = flag.Int("audit-files", audit.DEF_AUDIT_FILES, "Maximum number of rotated audit files kept")
+
 func init() {
 	debug.SetGCPercent(_GOGC_PERCENT)
 }
@@ -264,6 +290,15 @@ func main() {
 
 	// Start the completed requests log
 	server_package.RequestsInit(*COMPLETED_THRESHOLD, *COMPLETED_LIMIT)
+	server_package.RequestsSetArchiveFileSize(*COMPLETED_ARCHIVE_SIZE * 1024 * 1024)
+	server_package.RequestsSetArchiveFiles(*COMPLETED_ARCHIVE_FILES)
+	// the archive directory is only ever created from the command line
+	if *COMPLETED_ARCHIVE_DIR != "" {
+		os.MkdirAll(*COMPLETED_ARCHIVE_DIR, 0700)
+	}
+	if err := server_package.RequestsSetArchiveDir(*COMPLETED_ARCHIVE_DIR); err != nil {
+		logging.Errorf("Ignoring invalid completed requests archive directory %v: %v", *COMPLETED_ARCHIVE_DIR, err)
+	}
 
 	// Initialized the prepared statement cache
 	if *PREPARED_LIMIT <= 0 {
@@ -271,6 +306,7 @@ func main() {
 		*PREPARED_LIMIT = _DEF_PREPARED_LIMIT
 	}
 	prepareds.PreparedsInit(*PREPARED_LIMIT)
+	prepareds.PreparedsSetPersistInterval(*PREPARED_PERSIST_INTERVAL)
 	functions.FunctionsSetLimit(*FUNCTIONS_LIMIT)
 	scheduler.SchedulerSetLimit(*TASKS_LIMIT)
 
@@ -281,6 +317,16 @@ func main() {
 	// Initialize dictionary cache
 	server_package.InitDictionaryCache(*DICTIONARY_CACHE_LIMIT)
 
+	if err := util.SetSpillDir(*SPILL_DIR); err != nil {
+		logging.Errorf("Ignoring invalid spill directory %v: %v", *SPILL_DIR, err)
+	}
+	util.SetSpillLimit(*SPILL_LIMIT * 1024 * 1024)
+	util.SetSpillThreshold(*SPILL_THRESHOLD * 1024 * 1024)
+
+	if err := tracing.SetCollector(*TRACE_COLLECTOR); err != nil {
+		logging.Errorf("Ignoring invalid trace collector %v: %v", *TRACE_COLLECTOR, err)
+	}
+
 	numProcs := runtime.GOMAXPROCS(0)
 
 	sys, err := system.NewDatastore(datastore)
@@ -301,6 +347,15 @@ func main() {
 	datastore_package.SetSystemstore(server.Systemstore())
 	prepareds.PreparedsReprepareInit(datastore, sys)
 
+	// reload persisted prepared statements, now that they can be planned again
+	if err := prepareds.PreparedsSetPersistDir(*PREPARED_PERSIST_DIR); err != nil {
+		logging.Errorf("Ignoring invalid prepared statements directory %v: %v", *PREPARED_PERSIST_DIR, err)
+	} else if count, err := prepareds.PreparedsLoad(); err != nil {
+		logging.Errorf("Unable to reload prepared statements: %v", err)
+	} else if count > 0 {
+		logging.Infof("Reloaded %v prepared statements", count)
+	}
+
 	server.SetCpuProfile(*CPU_PROFILE)
 	server.SetKeepAlive(*KEEP_ALIVE_LENGTH)
 	server.SetMemProfile(*MEM_PROFILE)
@@ -321,7 +376,20 @@ func main() {
 	}
 	server.SetMemoryQuota(*MEMORY_QUOTA)
 
-	audit.StartAuditService(*DATASTORE, *SERVICERS+*PLUS_SERVICERS)
+	if *AUDIT_DIR != "" {
+		descriptor := *AUDIT_DESCRIPTOR
+		if descriptor == "" {
+			descriptor = auditDescriptor()
+		}
+		err := audit.StartFileAuditService(*AUDIT_DIR, descriptor, *AUDIT_EVENTS, *AUDIT_FILE_SIZE*1024*1024,
+			*AUDIT_FILES, *SERVICERS+*PLUS_SERVICERS)
+		if err != nil {
+			logging.Errorf("Audit service not started: %v", err)
+			os.Exit(1)
+		}
+	} else {
+		audit.StartAuditService(*DATASTORE, *SERVICERS+*PLUS_SERVICERS)
+	}
 
 	ll := logging.LogLevel().String() // extract first
 	logging.Infoa(func() string {
@@ -373,6 +441,10 @@ func main() {
 	}
 	server.SetSettingsCallback(endpoint.SettingsCallback)
 	constructor.Init(endpoint.Mux())
+	if err := schemas.Init(*SCHEMA_DIR); err != nil {
+		logging.Errorf("Unable to load validation schemas: %v", err)
+		os.Exit(1)
+	}
 
 	// Now that we are up and running, try to prime the prepareds cache
 	prepareds.PreparedsRemotePrime()
@@ -409,9 +481,13 @@ func signalCatcher(server *server_package.Server, endpoint *http.HttpEndpoint) {
 			f.Close()
 		}
 	}
+	if err := prepareds.PreparedsPersist(); err != nil {
+		logging.Errorf("Unable to persist prepared statements: %v", err)
+	}
 	if s == os.Interrupt {
 		// Interrupt (ctrl-C) => Immediate (ungraceful) exit
 		logging.Infof("Shutting down immediately")
+		audit.CloseAuditService()
 		os.Exit(0)
 	}
 	logging.Infof("Attempting graceful exit")
@@ -424,4 +500,19 @@ func signalCatcher(server *server_package.Server, endpoint *http.HttpEndpoint) {
 	if err != nil {
 		logging.Errorf("error closing https listener: %v", err)
 	}
+	audit.CloseAuditService()
+}
+
+// the descriptor is installed alongside the binary, or found in the source tree
+func auditDescriptor() string {
+	const descriptor = "audit_descriptor.json"
+
+	exe, err := os.Executable()
+	if err == nil {
+		path := filepath.Join(filepath.Dir(exe), "..", "etc", descriptor)
+		if _, err = os.Stat(path); err == nil {
+			return path
+		}
+	}
+	return filepath.Join("etc", descriptor)
 }
@@ -10,6 +10,8 @@
 package server
 
 import (
+	"os"
+	"path/filepath"
 	"time"
 
 	"github.com/couchbase/query/algebra"
@@ -54,6 +56,15 @@ const (
 	CLEANUPWINDOW         = "cleanupwindow"
 	CLEANUPCLIENTATTEMPTS = "cleanupclientattempts"
 	CLEANUPLOSTATTEMPTS   = "cleanuplostattempts"
+	SPILLDIR              = "spill-dir"
+	SPILLLIMIT            = "spill-limit"
+	SPILLTHRESHOLD        = "spill-threshold"
+	TRACECOLLECTOR        = "trace-collector"
+	PREPAREDPERSISTDIR    = "prepared-persist-dir"
+	PREPAREDPERSISTINT    = "prepared-persist-interval"
+	CMPARCHIVEDIR         = "completed-archive-dir"
+	CMPARCHIVESIZE        = "completed-archive-size"
+	CMPARCHIVEFILES       = "completed-archive-files"
 )
 
 type Checker func(interface{}) (bool, errors.Error)
@@ -85,6 +96,11 @@ var CHECKERS = map[string]Checker{
 	CLEANUPWINDOW:         checkDuration,
 	CLEANUPCLIENTATTEMPTS: checkBool,
 	CLEANUPLOSTATTEMPTS:   checkBool,
+	SPILLDIR:              checkString,
+	TRACECOLLECTOR:        checkString,
+	PREPAREDPERSISTDIR:    checkDirectory,
+	PREPAREDPERSISTINT:    checkDuration,
+	CMPARCHIVEDIR:         checkDirectory,
 }
 
 var CHECKERS_MIN = map[string]int{
@@ -98,6 +114,10 @@ var CHECKERS_MIN = map[string]int{
 	TASKLIMIT:       2,
 	MEMORYQUOTA:     0,
 	NUMATRS:         2,
+	SPILLLIMIT:      0,
+	SPILLTHRESHOLD:  0,
+	CMPARCHIVESIZE:  1,
+	CMPARCHIVEFILES: 1,
 }
 
 func checkBool(val interface{}) (bool, errors.Error) {
@@ -177,6 +197,21 @@ func checkString(val interface{}) (bool, errors.Error) {
 	return ok, nil
 }
 
+// an existing directory, as a clean absolute path, or empty
+func checkDirectory(val interface{}) (bool, errors.Error) {
+	s, ok := val.(string)
+	if ok && s != "" {
+		if !filepath.IsAbs(s) || filepath.Clean(s) != s {
+			return false, nil
+		}
+		info, err := os.Stat(s)
+		if err != nil || !info.IsDir() {
+			return false, nil
+		}
+	}
+	return ok, nil
+}
+
 func checkDuration(val interface{}) (bool, errors.Error) {
 	switch val := val.(type) {
 	case string:
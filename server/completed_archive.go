@@ -0,0 +1,409 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+/*
+ The completed requests archive appends every entry added to the completed requests log
+ to newline delimited JSON files, so that it survives both eviction from the log and
+ restarts.
+ Files are rotated once they reach a maximum size, and only a maximum number of files
+ is kept.
+ Archived entries are identified by file sequence number and offset, which is what
+ system:completed_requests_history uses as document keys.
+*/
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/couchbase/query/distributed"
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/logging"
+)
+
+const (
+	DEF_ARCHIVE_FILE_SIZE = 64 * 1024 * 1024
+	DEF_ARCHIVE_FILES     = 10
+)
+
+const _ARCHIVE_HANDLER = "archive"
+const _ARCHIVE_PREFIX = "completed_requests."
+const _ARCHIVE_SUFFIX = ".ndjson"
+
+type requestArchive struct {
+	sync.Mutex
+	dir      string
+	fileSize int64
+	files    int
+	current  *os.File
+	seq      int
+	size     int64
+}
+
+var archive = &requestArchive{fileSize: DEF_ARCHIVE_FILE_SIZE, files: DEF_ARCHIVE_FILES}
+
+func RequestsArchiveDir() string {
+	archive.Lock()
+	defer archive.Unlock()
+	return archive.dir
+}
+
+/*
+Set the directory the completed requests are archived to, which must exist.
+An empty directory disables the archive, but leaves the files in place.
+*/
+func RequestsSetArchiveDir(dir string) errors.Error {
+	if dir != "" {
+		var err error
+		var info os.FileInfo
+
+		dir, err = filepath.Abs(dir)
+		if err == nil {
+			info, err = os.Stat(dir)
+		}
+		if err == nil && !info.IsDir() {
+			err = fmt.Errorf("not a directory")
+		}
+		if err != nil {
+			return errors.NewCompletedArchiveError(err, dir)
+		}
+	}
+
+	archive.Lock()
+	if dir == archive.dir {
+		archive.Unlock()
+		return nil
+	}
+	oldDir := archive.dir
+	archive.closeCurrent()
+	archive.dir = dir
+	archive.seq = 0
+	if dir != "" {
+		seqs, err := archive.sequences()
+		if err != nil {
+			archive.dir = ""
+			archive.Unlock()
+			if oldDir != "" {
+				RequestsRemoveHandler(_ARCHIVE_HANDLER)
+			}
+			return errors.NewCompletedArchiveError(err, dir)
+		}
+		if len(seqs) > 0 {
+			archive.seq = seqs[len(seqs)-1]
+		}
+	}
+	archive.Unlock()
+
+	if oldDir == "" && dir != "" {
+		RequestsAddHandler(archiveRequest, _ARCHIVE_HANDLER)
+	} else if oldDir != "" && dir == "" {
+		RequestsRemoveHandler(_ARCHIVE_HANDLER)
+	}
+	return nil
+}
+
+// maximum size of an archive file, in bytes
+func RequestsArchiveFileSize() int64 {
+	archive.Lock()
+	defer archive.Unlock()
+	return archive.fileSize
+}
+
+func RequestsSetArchiveFileSize(size int64) {
+	if size <= 0 {
+		size = DEF_ARCHIVE_FILE_SIZE
+	}
+	archive.Lock()
+	archive.fileSize = size
+	archive.Unlock()
+}
+
+// maximum number of archive files kept
+func RequestsArchiveFiles() int {
+	archive.Lock()
+	defer archive.Unlock()
+	return archive.files
+}
+
+func RequestsSetArchiveFiles(files int) {
+	if files <= 0 {
+		files = DEF_ARCHIVE_FILES
+	}
+	archive.Lock()
+	archive.files = files
+	archive.prune()
+	archive.Unlock()
+}
+
+// completed requests log handler
+func archiveRequest(entry *RequestLogEntry) {
+	doc := entry.Format()
+	doc["node"] = distributed.RemoteAccess().WhoAmI()
+	if entry.Timings != nil {
+		doc["plan"] = entry.Timings
+		if entry.OptEstimates != nil {
+			doc["optimizerEstimates"] = entry.OptEstimates
+		}
+	}
+	bytes, err := json.Marshal(doc)
+	if err != nil {
+		logging.Infof("Unable to archive completed request %v: %v", entry.RequestId, err)
+		return
+	}
+	bytes = append(bytes, '\n')
+
+	archive.Lock()
+	defer archive.Unlock()
+	err = archive.write(bytes)
+	if err != nil {
+		logging.Errorf("Unable to archive completed request %v: %v", entry.RequestId, err)
+	}
+}
+
+func (this *requestArchive) write(bytes []byte) error {
+	if this.dir == "" {
+		return nil
+	}
+	if this.current == nil {
+		err := this.open()
+		if err != nil {
+			return err
+		}
+	}
+	n, err := this.current.Write(bytes)
+	this.size += int64(n)
+	if err != nil {
+		return err
+	}
+
+	// rotate
+	if this.size >= this.fileSize {
+		this.closeCurrent()
+		this.seq++
+		this.prune()
+	}
+	return nil
+}
+
+func (this *requestArchive) open() error {
+	if this.seq == 0 {
+		this.seq = 1
+	}
+	f, err := os.OpenFile(this.fileName(this.seq), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	this.current = f
+	this.size = info.Size()
+	return nil
+}
+
+func (this *requestArchive) closeCurrent() {
+	if this.current != nil {
+		this.current.Close()
+		this.current = nil
+	}
+	this.size = 0
+}
+
+// remove the oldest files in excess of the retention limit
+func (this *requestArchive) prune() {
+	if this.dir == "" {
+		return
+	}
+	seqs, err := this.sequences()
+	if err != nil {
+		logging.Errorf("Unable to prune completed requests archive: %v", err)
+		return
+	}
+
+	// the file being written next counts towards the limit
+	keep := this.files
+	if len(seqs) == 0 || seqs[len(seqs)-1] != this.seq {
+		keep--
+	}
+	for len(seqs) > keep {
+		os.Remove(this.fileName(seqs[0]))
+		seqs = seqs[1:]
+	}
+}
+
+func (this *requestArchive) fileName(seq int) string {
+	return filepath.Join(this.dir, fmt.Sprintf("%s%06d%s", _ARCHIVE_PREFIX, seq, _ARCHIVE_SUFFIX))
+}
+
+// the sequence numbers of the archive files, oldest first
+func (this *requestArchive) sequences() ([]int, error) {
+	names, err := filepath.Glob(filepath.Join(this.dir, _ARCHIVE_PREFIX+"*"+_ARCHIVE_SUFFIX))
+	if err != nil {
+		return nil, err
+	}
+	seqs := make([]int, 0, len(names))
+	for _, name := range names {
+		seq, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(filepath.Base(name), _ARCHIVE_PREFIX),
+			_ARCHIVE_SUFFIX))
+		if err == nil && seq > 0 {
+			seqs = append(seqs, seq)
+		}
+	}
+	sort.Ints(seqs)
+	return seqs, nil
+}
+
+func archiveKey(seq int, offset int64) string {
+	return strconv.Itoa(seq) + ":" + strconv.FormatInt(offset, 10)
+}
+
+func splitArchiveKey(key string) (int, int64, bool) {
+	i := strings.IndexByte(key, ':')
+	if i < 0 {
+		return 0, 0, false
+	}
+	seq, err := strconv.Atoi(key[:i])
+	if err != nil || seq <= 0 {
+		return 0, 0, false
+	}
+	offset, err := strconv.ParseInt(key[i+1:], 10, 64)
+	if err != nil || offset < 0 {
+		return 0, 0, false
+	}
+	return seq, offset, true
+}
+
+/*
+Calls f with the key of each archived request, oldest first, until it
+returns false.
+Partially written entries are skipped.
+*/
+func RequestsArchiveForeach(f func(key string) bool) errors.Error {
+	archive.Lock()
+	dir := archive.dir
+	seqs, err := archive.sequences()
+	archive.Unlock()
+	if dir == "" {
+		return nil
+	}
+	if err != nil {
+		return errors.NewCompletedArchiveError(err, dir)
+	}
+
+	for _, seq := range seqs {
+		file, err := os.Open(filepath.Join(dir, fmt.Sprintf("%s%06d%s", _ARCHIVE_PREFIX, seq, _ARCHIVE_SUFFIX)))
+		if err != nil {
+
+			// pruned in the meantime
+			if os.IsNotExist(err) {
+				continue
+			}
+			return errors.NewCompletedArchiveError(err, dir)
+		}
+		cont, err := foreachArchiveLine(file, seq, f)
+		file.Close()
+		if err != nil {
+			return errors.NewCompletedArchiveError(err, dir)
+		}
+		if !cont {
+			break
+		}
+	}
+	return nil
+}
+
+func foreachArchiveLine(file *os.File, seq int, f func(key string) bool) (bool, error) {
+	var offset int64
+
+	// entries longer than the buffer are read in chunks, only the length matters
+	reader := bufio.NewReader(file)
+	for {
+		line, err := reader.ReadSlice('\n')
+		length := int64(len(line))
+		for err == bufio.ErrBufferFull {
+			line, err = reader.ReadSlice('\n')
+			length += int64(len(line))
+		}
+		if err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		if !f(archiveKey(seq, offset)) {
+			return false, nil
+		}
+		offset += length
+	}
+}
+
+// Count the archived requests
+func RequestsArchiveCount() (int64, errors.Error) {
+	var count int64
+
+	err := RequestsArchiveForeach(func(key string) bool {
+		count++
+		return true
+	})
+	return count, err
+}
+
+// Fetch an archived request, nil if it is no longer there
+func RequestsArchiveFetch(key string) (map[string]interface{}, errors.Error) {
+	seq, offset, ok := splitArchiveKey(key)
+	if !ok {
+		return nil, nil
+	}
+
+	archive.Lock()
+	dir := archive.dir
+	name := archive.fileName(seq)
+	archive.Unlock()
+	if dir == "" {
+		return nil, nil
+	}
+
+	file, err := os.Open(name)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, errors.NewCompletedArchiveError(err, dir)
+	}
+	defer file.Close()
+
+	_, err = file.Seek(offset, io.SeekStart)
+	if err != nil {
+		return nil, errors.NewCompletedArchiveError(err, dir)
+	}
+	line, err := bufio.NewReader(file).ReadBytes('\n')
+	if err == io.EOF {
+		return nil, nil
+	} else if err != nil {
+		return nil, errors.NewCompletedArchiveError(err, dir)
+	}
+
+	var doc map[string]interface{}
+	err = json.Unmarshal(line, &doc)
+	if err != nil {
+
+		// not the start of an entry
+		return nil, nil
+	}
+	return doc, nil
+}
@@ -0,0 +1,97 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRequestsArchive(t *testing.T) {
+	RequestsInit(0, 10)
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	if err := RequestsSetArchiveDir(dir); err != nil {
+		t.Fatalf("Failed to set the archive directory: %v", err)
+	}
+	defer RequestsSetArchiveDir("")
+
+	// entries longer than the read buffer
+	statements := []string{
+		"SELECT 1",
+		"SELECT \"" + strings.Repeat("a", 5000) + "\"",
+		"SELECT 2",
+		"SELECT \"" + strings.Repeat("b", 20000) + "\"",
+		"SELECT 3",
+	}
+	for i, s := range statements {
+		archiveRequest(&RequestLogEntry{RequestId: string('a' + rune(i)), Statement: s})
+	}
+
+	var keys []string
+	if err := RequestsArchiveForeach(func(key string) bool {
+		keys = append(keys, key)
+		return true
+	}); err != nil {
+		t.Fatalf("Failed to list the archive: %v", err)
+	}
+	if len(keys) != len(statements) {
+		t.Fatalf("Expected %v archived requests, got %v", len(statements), keys)
+	}
+	for i, key := range keys {
+		doc, err := RequestsArchiveFetch(key)
+		if err != nil || doc == nil {
+			t.Errorf("Failed to fetch %v: %v", key, err)
+		} else if doc["statement"] != statements[i] {
+			t.Errorf("Unexpected statement for %v: %.20v", key, doc["statement"])
+		}
+	}
+
+	if count, err := RequestsArchiveCount(); err != nil || count != int64(len(statements)) {
+		t.Errorf("Expected %v archived requests, got %v: %v", len(statements), count, err)
+	}
+}
+
+// the settings only archive to existing directories, and never create them
+func TestRequestsArchiveDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	missing := filepath.Join(dir, "missing")
+	if ok, _ := CHECKERS[CMPARCHIVEDIR](missing); ok {
+		t.Errorf("Expected a missing directory to be rejected")
+	}
+	if ok, _ := CHECKERS[CMPARCHIVEDIR]("archive"); ok {
+		t.Errorf("Expected a relative directory to be rejected")
+	}
+	if ok, _ := CHECKERS[CMPARCHIVEDIR](dir); !ok {
+		t.Errorf("Expected %v to be accepted", dir)
+	}
+
+	if err := RequestsSetArchiveDir(missing); err == nil {
+		RequestsSetArchiveDir("")
+		t.Errorf("Expected archiving to a missing directory to fail")
+	}
+	if _, err := os.Stat(missing); !os.IsNotExist(err) {
+		t.Errorf("Expected %v not to be created", missing)
+	}
+	if RequestsArchiveDir() != "" {
+		t.Errorf("Expected the archive to stay disabled, got %v", RequestsArchiveDir())
+	}
+}
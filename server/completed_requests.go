@@ -26,6 +26,7 @@ import (
 	"github.com/couchbase/query/datastore"
 	"github.com/couchbase/query/errors"
 	"github.com/couchbase/query/execution"
+	"github.com/couchbase/query/expression"
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
@@ -77,6 +78,96 @@ type RequestLogEntry struct {
 	Tag                      string
 }
 
+/*
+The document for an entry, as seen in system:completed_requests.
+The plan and optimizer estimates are not part of it.
+*/
+func (entry *RequestLogEntry) Format() map[string]interface{} {
+	item := map[string]interface{}{
+		"requestId":       entry.RequestId,
+		"state":           entry.State,
+		"elapsedTime":     entry.ElapsedTime.String(),
+		"serviceTime":     entry.ServiceTime.String(),
+		"resultCount":     entry.ResultCount,
+		"resultSize":      entry.ResultSize,
+		"errorCount":      entry.ErrorCount,
+		"requestTime":     entry.Time.Format(expression.DEFAULT_FORMAT),
+		"scanConsistency": entry.ScanConsistency,
+	}
+	if entry.ClientId != "" {
+		item["clientContextID"] = entry.ClientId
+	}
+	if entry.Statement != "" {
+		item["statement"] = entry.Statement
+	}
+	if entry.QueryContext != "" {
+		item["queryContext"] = entry.QueryContext
+	}
+	if entry.UseFts {
+		item["useFts"] = entry.UseFts
+	}
+	if entry.UseCBO {
+		item["useCBO"] = entry.UseCBO
+	}
+	if entry.TxId != "" {
+		item["txid"] = entry.TxId
+	}
+	if entry.TransactionElapsedTime > 0 {
+		item["transactionElapsedTime"] = entry.TransactionElapsedTime.String()
+	}
+	if entry.TransactionRemainingTime > 0 {
+		item["transactionRemainingTime"] = entry.TransactionRemainingTime.String()
+	}
+	if entry.PreparedName != "" {
+		item["preparedName"] = entry.PreparedName
+		item["preparedText"] = entry.PreparedText
+	}
+	if entry.Mutations != 0 {
+		item["mutations"] = entry.Mutations
+	}
+	if entry.PhaseTimes != nil {
+		item["phaseTimes"] = entry.PhaseTimes
+	}
+	if entry.PhaseCounts != nil {
+		item["phaseCounts"] = entry.PhaseCounts
+	}
+	if entry.PhaseOperators != nil {
+		item["phaseOperators"] = entry.PhaseOperators
+	}
+	if entry.UsedMemory != 0 {
+		item["usedMemory"] = entry.UsedMemory
+	}
+	if entry.PositionalArgs != nil {
+		item["positionalArgs"] = entry.PositionalArgs
+	}
+	if entry.NamedArgs != nil {
+		item["namedArgs"] = entry.NamedArgs
+	}
+	if entry.Users != "" {
+		item["users"] = entry.Users
+	}
+	if entry.RemoteAddr != "" {
+		item["remoteAddr"] = entry.RemoteAddr
+	}
+	if entry.UserAgent != "" {
+		item["userAgent"] = entry.UserAgent
+	}
+	if entry.Tag != "" {
+		item["~tag"] = entry.Tag
+	}
+	if entry.MemoryQuota != 0 {
+		item["memoryQuota"] = entry.MemoryQuota
+	}
+	if entry.Errors != nil {
+		errors := make([]interface{}, len(entry.Errors))
+		for i, e := range entry.Errors {
+			errors[i] = e.Object()
+		}
+		item["errors"] = errors
+	}
+	return item
+}
+
 type qualifier interface {
 	name() string
 	unique() bool
@@ -18,6 +18,7 @@ import (
 
 	"github.com/couchbase/cbauth"
 	"github.com/couchbase/query/accounting"
+	"github.com/couchbase/query/algebra"
 	"github.com/couchbase/query/audit"
 	"github.com/couchbase/query/auth"
 	"github.com/couchbase/query/datastore"
@@ -28,6 +29,7 @@ import (
 	"github.com/couchbase/query/functions"
 	"github.com/couchbase/query/prepareds"
 	"github.com/couchbase/query/scheduler"
+	"github.com/couchbase/query/schemas"
 	"github.com/couchbase/query/server"
 	"github.com/couchbase/query/transactions"
 	"github.com/couchbase/query/value"
@@ -47,7 +49,9 @@ const (
 	expvarsRoute       = "/debug/vars"
 	prometheusLow      = "/_prometheusMetrics"
 	prometheusHigh     = "/_prometheusMetricsHigh"
+	metricsRoute       = "/metrics"
 	transactionsPrefix = adminPrefix + "/transactions"
+	schemasPrefix      = adminPrefix + "/schemas"
 )
 
 func expvarsHandler(w http.ResponseWriter, req *http.Request) {
@@ -131,6 +135,9 @@ func (this *HttpEndpoint) registerAccountingHandlers() {
 	prometheusHighHandler := func(w http.ResponseWriter, req *http.Request) {
 		this.wrapAPI(w, req, doEmpty)
 	}
+	metricsHandler := func(w http.ResponseWriter, req *http.Request) {
+		this.wrapAPI(w, req, doMetrics)
+	}
 	transactionsIndexHandler := func(w http.ResponseWriter, req *http.Request) {
 		this.wrapAPI(w, req, doTransactionsIndex)
 	}
@@ -140,6 +147,12 @@ func (this *HttpEndpoint) registerAccountingHandlers() {
 	transactionsHandler := func(w http.ResponseWriter, req *http.Request) {
 		this.wrapAPI(w, req, doTransactions)
 	}
+	schemasHandler := func(w http.ResponseWriter, req *http.Request) {
+		this.wrapAPI(w, req, doSchemas)
+	}
+	schemaHandler := func(w http.ResponseWriter, req *http.Request) {
+		this.wrapAPI(w, req, doSchema)
+	}
 	routeMap := map[string]struct {
 		handler handlerFunc
 		methods []string
@@ -169,7 +182,10 @@ func (this *HttpEndpoint) registerAccountingHandlers() {
 		indexesPrefix + "/tasks_cache":        {handler: tasksIndexHandler, methods: []string{"GET"}},
 		prometheusLow:                         {handler: prometheusLowHandler, methods: []string{"GET"}},
 		prometheusHigh:                        {handler: prometheusHighHandler, methods: []string{"GET"}},
+		metricsRoute:                          {handler: metricsHandler, methods: []string{"GET"}},
 		indexesPrefix + "/transactions":       {handler: transactionsIndexHandler, methods: []string{"GET"}},
+		schemasPrefix:                         {handler: schemasHandler, methods: []string{"GET"}},
+		schemasPrefix + "/{keyspace}":         {handler: schemaHandler, methods: []string{"GET", "PUT", "DELETE"}},
 	}
 
 	for route, h := range routeMap {
@@ -280,6 +296,30 @@ func doPrometheusLow(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Re
 	return textPlain(""), nil
 }
 
+// all metrics and vital signs, in the Prometheus text exposition format
+func doMetrics(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af *audit.ApiAuditFields) (interface{}, errors.Error) {
+	af.EventTypeId = audit.API_DO_NOT_AUDIT
+	err, _ := endpoint.verifyCredentialsFromRequest("", auth.PRIV_QUERY_STATS, req, nil)
+	if err != nil {
+		return nil, err
+	}
+	acctStore := endpoint.server.AccountingStore()
+	vitals, err := acctStore.Vitals()
+	if err != nil {
+		return nil, err
+	}
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	accounting.WritePrometheus(w, acctStore.MetricRegistry(), vitals)
+	for name, metric := range localData {
+		w.Write([]byte("# TYPE " + accounting.PROMETHEUS_PREFIX + name + " " + metric + "\n"))
+		w.Write([]byte(accounting.PROMETHEUS_PREFIX + name + " "))
+		w.Write([]byte(fmt.Sprintf("%v\n", localValue(endpoint.server, name))))
+	}
+
+	return textPlain(""), nil
+}
+
 func doEmpty(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af *audit.ApiAuditFields) (interface{}, errors.Error) {
 	af.EventTypeId = audit.API_DO_NOT_AUDIT
 	err, _ := endpoint.verifyCredentialsFromRequest("", auth.PRIV_QUERY_STATS, req, nil)
@@ -1432,3 +1472,103 @@ func getMetricData(metric accounting.Metric) map[string]interface{} {
 	}
 	return values
 }
+
+// the keyspace is given as a path, as in namespace:bucket.scope.collection, with the namespace optional
+func doSchema(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af *audit.ApiAuditFields) (interface{}, errors.Error) {
+	vars := mux.Vars(req)
+	name := vars["keyspace"]
+
+	af.EventTypeId = audit.API_ADMIN_SETTINGS
+	af.Name = name
+
+	var body []byte
+	var err1 error
+	if req.Method == "PUT" {
+		body, err1 = ioutil.ReadAll(req.Body)
+		defer req.Body.Close()
+	}
+
+	parts := algebra.ParsePath(name)
+	if parts[0] == "" {
+		parts[0] = "default"
+	}
+	keyspace, err := datastore.GetKeyspace(parts...)
+	if err != nil {
+		return nil, err
+	}
+
+	// http.BasicAuth eats the body, so verify credentials after getting the body.
+	priv := auth.PRIV_QUERY_BUCKET_ADMIN
+	if req.Method == "GET" {
+		priv = auth.PRIV_QUERY_SELECT
+	}
+	err, _ = endpoint.verifyCredentialsFromRequest(keyspace.QualifiedName(), priv, req, af)
+	if err != nil {
+		return nil, err
+	}
+
+	switch req.Method {
+	case "GET":
+		schema, err := schemas.Get(keyspace)
+		if err != nil || schema == nil {
+			return nil, err
+		}
+		return schema, nil
+	case "PUT":
+		if err1 != nil {
+			return nil, errors.NewAdminBodyError(err1)
+		}
+		schema := value.NewValue(body)
+		if schema.Type() != value.OBJECT && schema.Type() != value.BOOLEAN {
+			return nil, errors.NewInvalidSchemaError(keyspace.QualifiedName(),
+				fmt.Errorf("the schema must be an object or a boolean"))
+		}
+		err = schemas.Set(keyspace, schema)
+		if err != nil {
+			return nil, err
+		}
+		return schema, nil
+	case "DELETE":
+		found, err := schemas.Delete(keyspace)
+		if err != nil || !found {
+			return nil, err
+		}
+		return true, nil
+	default:
+		return nil, errors.NewServiceErrorHttpMethod(req.Method)
+	}
+}
+
+// only the schemas of keyspaces the user can read are listed
+func doSchemas(endpoint *HttpEndpoint, w http.ResponseWriter, req *http.Request, af *audit.ApiAuditFields) (interface{}, errors.Error) {
+	af.EventTypeId = audit.API_ADMIN_SETTINGS
+	switch req.Method {
+	case "GET":
+		ds := datastore.GetDatastore()
+		creds, err, _ := endpoint.getCredentialsFromRequest(ds, req)
+		if err != nil {
+			return nil, err
+		}
+		users := make([]string, 0, len(creds.Users))
+		for user := range creds.Users {
+			users = append(users, user)
+		}
+		af.Users = users
+
+		data := make([]map[string]interface{}, 0)
+		err = schemas.Foreach(func(keyspace string, schema value.Value) bool {
+			privs := auth.NewPrivileges()
+			privs.Add(keyspace, auth.PRIV_QUERY_SELECT, auth.PRIV_PROPS_NONE)
+			if _, err := ds.Authorize(privs, creds); err == nil {
+				data = append(data, map[string]interface{}{"keyspace": keyspace, "schema": schema})
+			}
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+		return data, nil
+	default:
+		return nil, errors.NewServiceErrorHttpMethod(req.Method)
+	}
+}
@@ -28,6 +28,7 @@ import (
 	"github.com/couchbase/query/logging"
 	"github.com/couchbase/query/prepareds"
 	"github.com/couchbase/query/server"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/gorilla/mux"
 )
@@ -474,6 +475,15 @@ func fillSettings(settings map[string]interface{}, srvr *server.Server) map[stri
 	settings[server.USECBO] = srvr.UseCBO()
 	settings[server.ATRCOLLECTION] = srvr.AtrCollection()
 	settings[server.NUMATRS] = srvr.NumAtrs()
+	settings[server.SPILLDIR] = util.SpillDir()
+	settings[server.SPILLLIMIT] = util.SpillLimit() / (1024 * 1024)
+	settings[server.SPILLTHRESHOLD] = util.SpillThreshold() / (1024 * 1024)
+	settings[server.TRACECOLLECTOR] = tracing.Collector()
+	settings[server.PREPAREDPERSISTDIR] = prepareds.PreparedsPersistDir()
+	settings[server.PREPAREDPERSISTINT] = prepareds.PreparedsPersistInterval().String()
+	settings[server.CMPARCHIVEDIR] = server.RequestsArchiveDir()
+	settings[server.CMPARCHIVESIZE] = server.RequestsArchiveFileSize() / (1024 * 1024)
+	settings[server.CMPARCHIVEFILES] = server.RequestsArchiveFiles()
 
 	tranSettings := datastore.GetTransactionSettings()
 	settings[server.CLEANUPWINDOW] = tranSettings.CleanupWindow().String()
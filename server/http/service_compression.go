@@ -0,0 +1,127 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package http
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/query/util"
+)
+
+// compressWriter is what gzip.Writer and zlib.Writer have in common
+type compressWriter interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+var gzipPool util.FastPool
+var zlibPool util.FastPool
+
+func init() {
+	util.NewFastPool(&gzipPool, func() interface{} {
+		return gzip.NewWriter(ioutil.Discard)
+	})
+	util.NewFastPool(&zlibPool, func() interface{} {
+		return zlib.NewWriter(ioutil.Discard)
+	})
+}
+
+// the value of the Content-Encoding header for a compression,
+// or "" for compressions not available as a response encoding
+func (c Compression) contentEncoding() string {
+	switch c {
+	case ZIP, GZIP:
+		return "gzip"
+	case DEFLATE:
+		return "deflate"
+	default:
+		return ""
+	}
+}
+
+// obtain a compressor writing to w
+func newCompressor(c Compression, w io.Writer) compressWriter {
+	var rv compressWriter
+
+	switch c {
+	case ZIP, GZIP:
+		rv = gzipPool.Get().(*gzip.Writer)
+	case DEFLATE:
+		rv = zlibPool.Get().(*zlib.Writer)
+	default:
+		return nil
+	}
+	rv.Reset(w)
+	return rv
+}
+
+// return a closed compressor to its pool
+func releaseCompressor(w compressWriter) {
+	w.Reset(ioutil.Discard)
+	switch w := w.(type) {
+	case *gzip.Writer:
+		gzipPool.Put(w)
+	case *zlib.Writer:
+		zlibPool.Put(w)
+	}
+}
+
+// choose a compression from the Accept-Encoding header of a request
+// the preferred compression is gzip, then deflate, and no compression
+// is used unless the client explicitly accepts one of them
+func acceptedCompression(req *http.Request) Compression {
+	var gzipQ, deflateQ, anyQ float64 = -1, -1, -1
+
+	for _, header := range req.Header["Accept-Encoding"] {
+		for _, coding := range strings.Split(header, ",") {
+			q := 1.0
+			params := strings.Split(coding, ";")
+			for _, p := range params[1:] {
+				p = strings.TrimSpace(p)
+				if strings.HasPrefix(p, "q=") {
+					f, err := strconv.ParseFloat(p[2:], 64)
+					if err == nil {
+						q = f
+					}
+				}
+			}
+			switch strings.ToLower(strings.TrimSpace(params[0])) {
+			case "gzip", "x-gzip":
+				gzipQ = q
+			case "deflate":
+				deflateQ = q
+			case "*":
+				anyQ = q
+			}
+		}
+	}
+
+	if gzipQ < 0 {
+		gzipQ = anyQ
+	}
+	if deflateQ < 0 {
+		deflateQ = anyQ
+	}
+	switch {
+	case gzipQ > 0 && gzipQ >= deflateQ:
+		return GZIP
+	case deflateQ > 0:
+		return DEFLATE
+	default:
+		return NONE
+	}
+}
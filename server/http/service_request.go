@@ -33,6 +33,7 @@ import (
 	"github.com/couchbase/query/prepareds"
 	"github.com/couchbase/query/server"
 	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -58,6 +59,10 @@ type httpRequest struct {
 	executionTime          time.Duration
 	transactionElapsedTime time.Duration
 
+	format      Format
+	columns     []string
+	compression Compression
+
 	stmtCnt  int
 	consCnt  int
 	jsonArgs jsonArgs // ESCAPE analysis workaround
@@ -80,7 +85,7 @@ func newHttpRequest(rv *httpRequest, resp http.ResponseWriter, req *http.Request
 		err = errors.NewServiceErrorHTTPMethod(req.Method)
 	}
 
-	err = contentNegotiation(resp, req)
+	accepted, err := contentNegotiation(resp, req)
 
 	if err == nil {
 		const (
@@ -121,9 +126,22 @@ func newHttpRequest(rv *httpRequest, resp http.ResponseWriter, req *http.Request
 	rv.SetUserAgent(userAgent)
 	rv.SetRemoteAddr(req.RemoteAddr)
 
+	rv.StartTrace(req.Header.Get(tracing.TRACEPARENT))
+	if traceParent := rv.TraceParent(); traceParent != "" {
+		resp.Header().Set(tracing.TRACEPARENT, traceParent)
+	}
+
+	// the compression parameter takes precedence over Accept-Encoding
+	rv.compression = UNDEFINED_COMPRESSION
 	if err == nil {
 		err = httpArgs.processParameters(rv)
 	}
+	if rv.compression == UNDEFINED_COMPRESSION {
+		rv.compression = acceptedCompression(req)
+	}
+	if err == nil && accepted != JSON && accepted != rv.format {
+		err = errors.NewServiceErrorMediaType(req.Header["Accept"][0])
+	}
 
 	if err == nil {
 		if rv.stmtCnt == 0 {
@@ -347,8 +365,11 @@ func handleFormat(rv *httpRequest, httpArgs httpRequestArgs, parm string, val in
 		format := newFormat(format_field)
 		if format == UNDEFINED_FORMAT {
 			err = errors.NewServiceErrorUnrecognizedValue(FORMAT, format_field)
-		} else if format != JSON {
-			err = errors.NewServiceErrorNotImplemented(FORMAT, format_field)
+		} else {
+			rv.format = format
+			if format != JSON {
+				rv.resp.Header().Set("Content-Type", format.contentType())
+			}
 		}
 	}
 	return err
@@ -368,8 +389,10 @@ func handleCompression(rv *httpRequest, httpArgs httpRequestArgs, parm string, v
 		compression := newCompression(compression_field)
 		if compression == UNDEFINED_COMPRESSION {
 			err = errors.NewServiceErrorUnrecognizedValue(COMPRESSION, compression_field)
-		} else if compression != NONE {
+		} else if compression != NONE && compression.contentEncoding() == "" {
 			err = errors.NewServiceErrorNotImplemented(COMPRESSION, compression_field)
+		} else {
+			rv.compression = compression
 		}
 	}
 	return err
@@ -1005,31 +1028,36 @@ const acceptType = "application/json"
 const versionTag = "version="
 const version = acceptType + "; " + versionTag + util.VERSION
 
-func contentNegotiation(resp http.ResponseWriter, req *http.Request) errors.Error {
+// returns the result format of the accepted media type, JSON if any format is accepted
+func contentNegotiation(resp http.ResponseWriter, req *http.Request) (Format, errors.Error) {
 	// set content type to current version
 	resp.Header().Set("Content-Type", version)
 	accept := req.Header["Accept"]
 	// if no media type specified, default to current version
 	if accept == nil || accept[0] == "*/*" {
-		return nil
+		return JSON, nil
 	}
 	desiredContent := accept[0]
+	// the media types of the other formats must match the format parameter
+	if format := mediaTypeFormat(desiredContent); format != UNDEFINED_FORMAT {
+		return format, nil
+	}
 	// media type must be application/json at least
 	if !strings.HasPrefix(desiredContent, acceptType) {
-		return errors.NewServiceErrorMediaType(desiredContent)
+		return JSON, errors.NewServiceErrorMediaType(desiredContent)
 	}
 	versionIndex := strings.Index(desiredContent, versionTag)
 	// no version specified, default to current version
 	if versionIndex == -1 {
-		return nil
+		return JSON, nil
 	}
 	// check if requested version is supported
 	requestVersion := desiredContent[versionIndex+len(versionTag):]
 	if requestVersion >= util.MIN_VERSION && requestVersion <= util.VERSION {
 		resp.Header().Set("Content-Type", desiredContent)
-		return nil
+		return JSON, nil
 	}
-	return errors.NewServiceErrorMediaType(desiredContent)
+	return JSON, errors.NewServiceErrorMediaType(desiredContent)
 }
 
 // httpRequestArgs is an interface for getting the arguments in a http request
@@ -1810,6 +1838,8 @@ const (
 	RLE
 	LZMA
 	LZO
+	GZIP
+	DEFLATE
 	UNDEFINED_COMPRESSION
 )
 
@@ -1825,6 +1855,10 @@ func newCompression(s string) Compression {
 		return LZMA
 	case "LZO":
 		return LZO
+	case "GZIP":
+		return GZIP
+	case "DEFLATE":
+		return DEFLATE
 	default:
 		return UNDEFINED_COMPRESSION
 	}
@@ -1843,6 +1877,10 @@ func (c Compression) String() string {
 		s = "LZMA"
 	case LZO:
 		s = "LZO"
+	case GZIP:
+		s = "GZIP"
+	case DEFLATE:
+		s = "DEFLATE"
 	default:
 		s = "UNDEFINED_COMPRESSION"
 	}
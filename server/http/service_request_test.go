@@ -11,7 +11,11 @@ package http
 
 import (
 	"bytes"
+	"compress/gzip"
+	"compress/zlib"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -484,3 +488,75 @@ func doJsonEncodedPost(payload map[string]interface{}) (*http.Response, error) {
 
 	return res, nil
 }
+
+func TestAcceptedCompression(t *testing.T) {
+	tests := map[string]Compression{
+		"":                         NONE,
+		"identity":                 NONE,
+		"gzip":                     GZIP,
+		"deflate":                  DEFLATE,
+		"deflate, gzip":            GZIP,
+		"gzip;q=0.5, deflate":      DEFLATE,
+		"gzip;q=0, deflate;q=0":    NONE,
+		"*":                        GZIP,
+		"*;q=0.2, deflate;q=0.8":   DEFLATE,
+		"br, x-gzip;q=0.9, *;q=0":  GZIP,
+		"compress, identity;q=0.1": NONE,
+	}
+	for header, expected := range tests {
+		req := httptest.NewRequest("GET", "/query/service", nil)
+		if header != "" {
+			req.Header.Set("Accept-Encoding", header)
+		}
+		if c := acceptedCompression(req); c != expected {
+			t.Errorf("Accept-Encoding %q: expected %v, got %v", header, expected, c)
+		}
+	}
+}
+
+func TestCompressedWriter(t *testing.T) {
+	for _, compression := range []Compression{GZIP, DEFLATE} {
+		for _, size := range []int{100, 10000} {
+			recorder := httptest.NewRecorder()
+			request := &httpRequest{
+				resp:         recorder,
+				req:          httptest.NewRequest("GET", "/query/service", nil),
+				compression:  compression,
+				httpRespCode: http.StatusOK,
+			}
+			NewBufferedWriter(&request.writer, request, NewSyncPool(1024))
+
+			var expected bytes.Buffer
+			for i := 0; i < size; i++ {
+				s := fmt.Sprintf("line %d\n", i)
+				expected.WriteString(s)
+				if !request.writer.writeBytes([]byte(s)) {
+					t.Fatalf("Failed writing to compressed writer")
+				}
+			}
+			request.writer.noMoreData()
+
+			if e := recorder.Header().Get("Content-Encoding"); e != compression.contentEncoding() {
+				t.Errorf("Unexpected Content-Encoding %q for %v", e, compression)
+			}
+
+			var reader io.Reader
+			var err error
+			if compression == GZIP {
+				reader, err = gzip.NewReader(recorder.Body)
+			} else {
+				reader, err = zlib.NewReader(recorder.Body)
+			}
+			if err != nil {
+				t.Fatalf("Unable to decompress %v response: %v", compression, err)
+			}
+			actual, err := ioutil.ReadAll(reader)
+			if err != nil {
+				t.Fatalf("Unable to decompress %v response: %v", compression, err)
+			}
+			if !bytes.Equal(actual, expected.Bytes()) {
+				t.Errorf("Decompressed %v response does not match for %v lines", compression, size)
+			}
+		}
+	}
+}
@@ -105,6 +105,14 @@ func (this *httpRequest) setHttpCode(httpRespCode int) {
 }
 
 func (this *httpRequest) Failed(srvr *server.Server) {
+	if this.format != JSON {
+		this.markTimeOfCompletion(time.Now())
+		this.writeFormattedFailure(srvr)
+		this.writer.noMoreData()
+		this.Stop(server.FATAL)
+		return
+	}
+
 	prefix, indent := this.prettyStrings(srvr.Pretty(), false)
 	this.writeString("{\n")
 	this.writeRequestID(prefix)
@@ -179,6 +187,9 @@ func (this *httpRequest) Expire(state server.State, timeout time.Duration) {
 }
 
 func (this *httpRequest) writePrefix(srvr *server.Server, signature value.Value, prefix, indent string) bool {
+	if this.format != JSON {
+		return this.writeFormattedPrefix(srvr, signature)
+	}
 	return this.writeString("{\n") &&
 		this.writeRequestID(prefix) &&
 		this.writeClientContextID(prefix) &&
@@ -246,6 +257,9 @@ func (this *httpRequest) Result(item value.AnnotatedValue) bool {
 		this.resultCount++
 		return true
 	}
+	if this.format != JSON {
+		return this.writeFormattedResult(item)
+	}
 
 	this.writer.timeFlush()
 	beforeWrites := this.writer.mark()
@@ -296,6 +310,9 @@ func (this *httpRequest) writeValue(item value.Value, prefix, indent string, fas
 }
 
 func (this *httpRequest) writeSuffix(srvr *server.Server, state server.State, prefix, indent string) bool {
+	if this.format != JSON {
+		return this.writeFormattedSuffix(srvr, state)
+	}
 	return this.writeString("\n") && this.writeString(prefix) && this.writeString("]") &&
 		this.writeErrors(prefix, indent) &&
 		this.writeWarnings(prefix, indent) &&
@@ -740,10 +757,15 @@ func (this *httpRequest) writeProfile(profile server.Profile, prefix, indent str
 // note that the access to the buffered writer is not controlled,
 // and the executor and stream have to coordinate in between them
 // not to mess up the output
+// if the response is compressed, the buffer still holds uncompressed
+// data, so that marking and truncating work as usual, and data is
+// compressed as it is flushed
 type bufferedWriter struct {
-	req         *httpRequest  // the request for the response we are writing
-	buffer      *bytes.Buffer // buffer for writing response data to
-	buffer_pool BufferPool    // buffer manager for our buffers
+	req         *httpRequest   // the request for the response we are writing
+	buffer      *bytes.Buffer  // buffer for writing response data to
+	buffer_pool BufferPool     // buffer manager for our buffers
+	compressor  compressWriter // compressor for the response, if any
+	compression Compression
 	closed      bool
 	header      bool // headers required
 	lastFlush   util.Time
@@ -755,9 +777,18 @@ func NewBufferedWriter(w *bufferedWriter, r *httpRequest, bp BufferPool) {
 	w.req = r
 	w.buffer = bp.GetBuffer()
 	w.buffer_pool = bp
+	w.compressor = nil
+	w.compression = NONE
 	w.closed = false
 	w.header = true
 	w.lastFlush = util.Now()
+
+	encoding := r.compression.contentEncoding()
+	if encoding != "" {
+		w.compression = r.compression
+		r.resp.Header().Set("Content-Encoding", encoding)
+		r.resp.Header().Add("Vary", "Accept-Encoding")
+	}
 }
 
 func (this *bufferedWriter) writeBytes(s []byte) bool {
@@ -770,21 +801,7 @@ func (this *bufferedWriter) writeBytes(s []byte) bool {
 
 	// threshold exceeded
 	if len(s)+this.buffer.Len() > this.buffer_pool.BufferCapacity() {
-		w := this.req.resp // our request's response writer
-
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
-
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
-
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+		this.flush()
 	}
 
 	// under threshold - write the string to our buffer
@@ -799,21 +816,7 @@ func (this *bufferedWriter) printf(f string, args ...interface{}) bool {
 
 	// threshold exceeded
 	if _PRINTF_THRESHOLD+this.buffer.Len() > this.buffer_pool.BufferCapacity() {
-		w := this.req.resp // our request's response writer
-
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
-
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
-
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+		this.flush()
 	}
 
 	// under threshold - write the string to our buffer
@@ -838,21 +841,7 @@ func (this *bufferedWriter) timeFlush() {
 
 	// flush only if time has exceeded
 	if util.Since(this.lastFlush) > 100*time.Millisecond {
-		w := this.req.resp // our request's response writer
-
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
-
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
-
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+		this.flush()
 	}
 }
 
@@ -864,22 +853,37 @@ func (this *bufferedWriter) sizeFlush() {
 
 	// beyond capacity
 	if this.buffer.Len() > this.buffer_pool.BufferCapacity() {
-		w := this.req.resp // our request's response writer
+		this.flush()
+	}
+}
 
-		// write response header and data buffered so far using request's response writer:
-		if this.header {
-			w.WriteHeader(this.req.httpCode())
-			this.header = false
-		}
+// write out the data buffered so far
+func (this *bufferedWriter) flush() {
+	w := this.req.resp // our request's response writer
 
-		// write out and empty the buffer
-		io.Copy(w, this.buffer)
-		this.buffer.Reset()
+	// write response header and data buffered so far using request's response writer:
+	if this.header {
+		w.WriteHeader(this.req.httpCode())
+		this.header = false
+	}
 
-		// do the flushing
-		this.lastFlush = util.Now()
-		w.(http.Flusher).Flush()
+	// write out and empty the buffer
+	// a compressed stream is synced, so that the client can decompress
+	// everything sent so far
+	if this.compression != NONE {
+		if this.compressor == nil {
+			this.compressor = newCompressor(this.compression, w)
+		}
+		this.compressor.Write(this.buffer.Bytes())
+		this.compressor.Flush()
+	} else {
+		io.Copy(w, this.buffer)
 	}
+	this.buffer.Reset()
+
+	// do the flushing
+	this.lastFlush = util.Now()
+	w.(http.Flusher).Flush()
 }
 
 // mark the current write position
@@ -905,15 +909,35 @@ func (this *bufferedWriter) noMoreData() {
 	r := this.req.req  // our request's http request
 
 	if this.header {
+
+		// nothing sent yet: compress everything in one go,
+		// so that we can still send the length
+		if this.compression != NONE {
+			out := this.buffer_pool.GetBuffer()
+			compressor := newCompressor(this.compression, out)
+			compressor.Write(this.buffer.Bytes())
+			compressor.Close()
+			releaseCompressor(compressor)
+			this.buffer_pool.PutBuffer(this.buffer)
+			this.buffer = out
+		}
+
 		// calculate and set the Content-Length header:
 		content_len := strconv.Itoa(len(this.buffer.Bytes()))
 		w.Header().Set("Content-Length", content_len)
 		// write response header and data buffered so far:
 		w.WriteHeader(this.req.httpCode())
 		this.header = false
+		io.Copy(w, this.buffer)
+	} else if this.compressor != nil {
+		this.compressor.Write(this.buffer.Bytes())
+		this.compressor.Close()
+		releaseCompressor(this.compressor)
+		this.compressor = nil
+	} else {
+		io.Copy(w, this.buffer)
 	}
 
-	io.Copy(w, this.buffer)
 	// no more data in the response => return buffer to pool:
 	this.buffer_pool.PutBuffer(this.buffer)
 	r.Body.Close()
@@ -0,0 +1,514 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package http
+
+import (
+	"encoding/xml"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/couchbase/query/errors"
+	"github.com/couchbase/query/server"
+	"github.com/couchbase/query/value"
+)
+
+/*
+Streaming writers for the CSV, TSV and XML result formats.
+
+CSV and TSV responses are made of a header record, one record per
+result and, after an empty record, a trailer of name / value
+records carrying the request id, errors, warnings, status and metrics.
+The header is taken from the statement signature, with the projection
+aliases in alphabetical order. When the signature does not name the
+columns (SELECT *, SELECT RAW of non scalar values), the header is
+taken from the fields of the first result, and fields only appearing
+in later results are dropped.
+The flattening rule for a column value is:
+  - MISSING and NULL are written as an empty field
+  - strings are written verbatim
+  - numbers and booleans are written in their JSON representation
+  - objects and arrays are written as compact JSON text
+Results that are not objects are written under a single "$1" column.
+CSV follows RFC 4180 quoting; TSV escapes backslash, tab, carriage
+return and newline as \\, \t, \r and \n.
+
+XML responses mirror the JSON response document: results are
+<result> elements, object fields become child elements (or <field
+name="..."> elements if the name is not a valid XML name), array
+elements become <item> elements, and NULL values are empty elements
+with a null="true" attribute.
+
+The Accept header may name the media type of the format, which must
+then be the format of the format parameter.
+*/
+
+const (
+	_CSV_CONTENT = "text/csv; charset=utf-8"
+	_TSV_CONTENT = "text/tab-separated-values; charset=utf-8"
+	_XML_CONTENT = "application/xml; charset=utf-8"
+
+	_RAW_COLUMN = "$1"
+)
+
+func (f Format) contentType() string {
+	switch f {
+	case XML:
+		return _XML_CONTENT
+	case CSV:
+		return _CSV_CONTENT
+	case TSV:
+		return _TSV_CONTENT
+	default:
+		return version
+	}
+}
+
+// the format served with the media type, UNDEFINED_FORMAT if it is not one of CSV, TSV and XML
+func mediaTypeFormat(mediaType string) Format {
+	mediaType = strings.ToLower(strings.TrimSpace(strings.Split(mediaType, ";")[0]))
+	for _, f := range []Format{XML, CSV, TSV} {
+		if mediaType == strings.Split(f.contentType(), ";")[0] {
+			return f
+		}
+	}
+	return UNDEFINED_FORMAT
+}
+
+func (this *httpRequest) writeFormattedPrefix(srvr *server.Server, signature value.Value) bool {
+	switch this.format {
+	case XML:
+		return this.writeString(xml.Header) &&
+			this.writeString("<response>\n") &&
+			this.writeXMLRequestID() &&
+			this.writeXMLSignature(srvr.Signature(), signature) &&
+			this.writeString("<results>\n")
+	default:
+		this.columns = signatureColumns(signature)
+		if this.columns == nil {
+			return true
+		}
+		return this.writeRecord(this.columns)
+	}
+}
+
+func (this *httpRequest) writeFormattedResult(item value.AnnotatedValue) bool {
+	this.writer.timeFlush()
+	beforeWrites := this.writer.mark()
+
+	success := true
+	switch this.format {
+	case XML:
+		success = this.writeXMLElement("result", "", item)
+	default:
+		if this.columns == nil {
+			this.columns = valueColumns(item)
+			success = this.writeRecord(this.columns)
+		}
+		if success {
+			success = this.writeRecord(flattenColumns(item, this.columns))
+		}
+	}
+
+	if success {
+		this.resultSize += (this.writer.mark() - beforeWrites)
+		this.resultCount++
+		this.writer.sizeFlush()
+	} else {
+		this.writer.truncate(beforeWrites)
+		this.SetState(server.CLOSED)
+	}
+	return success
+}
+
+func (this *httpRequest) writeFormattedSuffix(srvr *server.Server, state server.State) bool {
+	switch this.format {
+	case XML:
+		return this.writeString("</results>\n") &&
+			this.writeXMLTrailer(srvr, state)
+	default:
+		return this.writeDelimitedTrailer(srvr, state)
+	}
+}
+
+func (this *httpRequest) writeFormattedFailure(srvr *server.Server) bool {
+	switch this.format {
+	case XML:
+		return this.writeString(xml.Header) &&
+			this.writeString("<response>\n") &&
+			this.writeXMLRequestID() &&
+			this.writeXMLTrailer(srvr, this.State())
+	default:
+		return this.writeDelimitedTrailer(srvr, this.State())
+	}
+}
+
+// CSV and TSV
+
+func (this *httpRequest) writeDelimitedTrailer(srvr *server.Server, state server.State) bool {
+	if !(this.writeRecord(nil) &&
+		this.writeRecord([]string{"requestID", this.Id().String()})) {
+		return false
+	}
+	if this.ClientID().IsValid() && !this.writeRecord([]string{"clientContextID", this.ClientID().String()}) {
+		return false
+	}
+
+	rv := this.writeFormattedErrors(func(kind string, err errors.Error) bool {
+		return this.writeRecord([]string{kind, strconv.Itoa(int(err.Code())), err.Error()})
+	})
+	if !rv || !this.writeRecord([]string{"status", this.finalStateName(state)}) {
+		return false
+	}
+	for _, m := range this.formattedMetrics(srvr.Metrics()) {
+		if !this.writeRecord(m[:]) {
+			return false
+		}
+	}
+	return true
+}
+
+func (this *httpRequest) writeRecord(fields []string) bool {
+	var sep, eol string
+
+	if this.format == TSV {
+		sep = "\t"
+		eol = "\n"
+	} else {
+		sep = ","
+		eol = "\r\n"
+	}
+
+	w := this.writer.buf()
+	for i, f := range fields {
+		if i > 0 && !this.writeToBuffer(w, sep) {
+			return false
+		}
+		if this.format == TSV {
+			f = tsvEscape(f)
+		} else {
+			f = csvQuote(f)
+		}
+		if !this.writeToBuffer(w, f) {
+			return false
+		}
+	}
+	return this.writeToBuffer(w, eol)
+}
+
+func (this *httpRequest) writeToBuffer(w io.Writer, s string) bool {
+	_, err := io.WriteString(w, s)
+	return err == nil
+}
+
+func csvQuote(f string) string {
+	if f == "" || (!strings.ContainsAny(f, ",\"\r\n") && f[0] != ' ' && f[0] != '\t') {
+		return f
+	}
+	return "\"" + strings.Replace(f, "\"", "\"\"", -1) + "\""
+}
+
+var _TSV_ESCAPER = strings.NewReplacer("\\", "\\\\", "\t", "\\t", "\r", "\\r", "\n", "\\n")
+
+func tsvEscape(f string) string {
+	return _TSV_ESCAPER.Replace(f)
+}
+
+// column names from the statement signature, or nil if they can only be
+// determined from the results
+func signatureColumns(signature value.Value) []string {
+	if signature == nil {
+		return nil
+	}
+	switch signature.Type() {
+	case value.OBJECT:
+		if _, ok := signature.Field("*"); ok {
+			return nil
+		}
+		names := signature.FieldNames(nil)
+		if len(names) == 0 {
+			return nil
+		}
+		return names
+	case value.STRING:
+
+		// SELECT RAW: a scalar goes in a single column,
+		// anything else needs the first result to decide
+		switch signature.ToString() {
+		case value.OBJECT.String(), value.JSON.String():
+			return nil
+		}
+		return []string{_RAW_COLUMN}
+	}
+	return nil
+}
+
+// column names from a result
+func valueColumns(item value.Value) []string {
+	if item.Type() == value.OBJECT {
+		names := item.FieldNames(nil)
+		if len(names) > 0 {
+			return names
+		}
+	}
+	return []string{_RAW_COLUMN}
+}
+
+func flattenColumns(item value.Value, columns []string) []string {
+	fields := make([]string, len(columns))
+	isObject := item.Type() == value.OBJECT
+	for i, c := range columns {
+		if isObject {
+			v, ok := item.Field(c)
+			if ok {
+				fields[i] = flattenValue(v)
+			}
+		} else if c == _RAW_COLUMN {
+			fields[i] = flattenValue(item)
+		}
+	}
+	return fields
+}
+
+func flattenValue(v value.Value) string {
+	switch v.Type() {
+	case value.MISSING, value.NULL:
+		return ""
+	case value.STRING, value.NUMBER, value.BOOLEAN:
+		return v.ToString()
+	default:
+		bytes, err := v.MarshalJSON()
+		if err != nil {
+			return ""
+		}
+		return string(bytes)
+	}
+}
+
+// XML
+
+func (this *httpRequest) writeXMLRequestID() bool {
+	if !this.writeXMLText("requestID", this.Id().String()) {
+		return false
+	}
+	if !this.ClientID().IsValid() {
+		return true
+	}
+	return this.writeXMLText("clientContextID", this.ClientID().String())
+}
+
+func (this *httpRequest) writeXMLSignature(server_flag bool, signature value.Value) bool {
+	s := this.Signature()
+	if s == value.FALSE || (s == value.NONE && !server_flag) || signature == nil {
+		return true
+	}
+	return this.writeXMLElement("signature", "", signature)
+}
+
+func (this *httpRequest) writeXMLTrailer(srvr *server.Server, state server.State) bool {
+	kinds := map[string]bool{}
+	rv := this.writeFormattedErrors(func(kind string, err errors.Error) bool {
+		if !kinds[kind] {
+			if len(kinds) > 0 && !this.writeString("</errors>\n") {
+				return false
+			}
+			kinds[kind] = true
+			if !this.writeString("<" + kind + "s>\n") {
+				return false
+			}
+		}
+		return this.writeString("<"+kind+">") &&
+			this.writeXMLText("code", strconv.Itoa(int(err.Code()))) &&
+			this.writeXMLText("msg", err.Error()) &&
+			this.writeString("</"+kind+">\n")
+	})
+	if !rv {
+		return false
+	}
+	if kinds["warning"] {
+		rv = this.writeString("</warnings>\n")
+	} else if kinds["error"] {
+		rv = this.writeString("</errors>\n")
+	}
+	if !rv || !this.writeXMLText("status", this.finalStateName(state)) {
+		return false
+	}
+
+	metrics := this.formattedMetrics(srvr.Metrics())
+	if len(metrics) > 0 {
+		if !this.writeString("<metrics>\n") {
+			return false
+		}
+		for _, m := range metrics {
+			if !this.writeXMLText(m[0], m[1]) {
+				return false
+			}
+		}
+		if !this.writeString("</metrics>\n") {
+			return false
+		}
+	}
+	return this.writeString("</response>\n")
+}
+
+func (this *httpRequest) writeXMLText(name, text string) bool {
+	w := this.writer.buf()
+	return this.writeString("<"+name+">") &&
+		xml.EscapeText(w, []byte(text)) == nil &&
+		this.writeString("</"+name+">\n")
+}
+
+// write a value as an XML element. attr, if not empty, is written verbatim
+// as the element attributes
+func (this *httpRequest) writeXMLElement(name, attr string, v value.Value) bool {
+	w := this.writer.buf()
+	open := "<" + name
+	if attr != "" {
+		open += " " + attr
+	}
+
+	switch v.Type() {
+	case value.MISSING:
+		return true
+	case value.NULL:
+		return this.writeToBuffer(w, open+" null=\"true\"/>\n")
+	case value.OBJECT:
+		if !this.writeToBuffer(w, open+">\n") {
+			return false
+		}
+		for _, n := range v.FieldNames(nil) {
+			f, _ := v.Field(n)
+			var ok bool
+			if validXMLName(n) {
+				ok = this.writeXMLElement(n, "", f)
+			} else {
+				ok = this.writeXMLElement("field", "name=\""+xmlAttrEscape(n)+"\"", f)
+			}
+			if !ok {
+				return false
+			}
+		}
+	case value.ARRAY:
+		if !this.writeToBuffer(w, open+">\n") {
+			return false
+		}
+		for _, e := range v.Actual().([]interface{}) {
+			if !this.writeXMLElement("item", "", value.NewValue(e)) {
+				return false
+			}
+		}
+	default:
+		if !this.writeToBuffer(w, open+">") || xml.EscapeText(w, []byte(v.ToString())) != nil {
+			return false
+		}
+	}
+	return this.writeToBuffer(w, "</"+name+">\n")
+}
+
+func validXMLName(n string) bool {
+	if n == "" || strings.HasPrefix(strings.ToLower(n), "xml") {
+		return false
+	}
+	for i, c := range n {
+		switch {
+		case c == '_', c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c == '-' || c == '.' || (c >= '0' && c <= '9')):
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+var _XML_ATTR_ESCAPER = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\"", "&quot;",
+	"\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+
+func xmlAttrEscape(s string) string {
+	return _XML_ATTR_ESCAPER.Replace(s)
+}
+
+// common to all formats
+
+// write errors and warnings through the supplied function, keeping
+// track of counts and http status as writeErrors() and writeWarnings() do
+func (this *httpRequest) writeFormattedErrors(write func(kind string, err errors.Error) bool) bool {
+	for _, err := range this.Errors() {
+		if this.errorCount == 0 && this.State() != server.FATAL {
+			this.setHttpCode(mapErrorToHttpResponse(err, http.StatusOK))
+		}
+		if !write("error", err) {
+			return false
+		}
+		this.errorCount++
+	}
+
+	alreadySeen := make(map[string]bool)
+	for _, err := range this.Warnings() {
+		if err.OnceOnly() && alreadySeen[err.Error()] {
+			continue
+		}
+		if !write("warning", err) {
+			return false
+		}
+		this.warningCount++
+		alreadySeen[err.Error()] = true
+	}
+	return true
+}
+
+func (this *httpRequest) finalStateName(state server.State) string {
+	if state == server.COMPLETED {
+		if this.errorCount == 0 {
+			state = server.SUCCESS
+		} else {
+			state = server.ERRORS
+		}
+	}
+	return state.StateName()
+}
+
+// metrics names and values, in the same order as writeMetrics()
+func (this *httpRequest) formattedMetrics(metrics bool) [][2]string {
+	m := this.Metrics()
+	if m == value.FALSE || (m == value.NONE && !metrics) {
+		return nil
+	}
+
+	rv := [][2]string{
+		{"elapsedTime", this.elapsedTime.String()},
+		{"executionTime", this.executionTime.String()},
+		{"resultCount", strconv.Itoa(this.resultCount)},
+		{"resultSize", strconv.Itoa(this.resultSize)},
+		{"serviceLoad", strconv.Itoa(server.ActiveRequestsLoad())},
+	}
+	if this.UsedMemory() > 0 {
+		rv = append(rv, [2]string{"usedMemory", strconv.FormatUint(this.UsedMemory(), 10)})
+	}
+	if this.MutationCount() > 0 {
+		rv = append(rv, [2]string{"mutationCount", strconv.FormatUint(this.MutationCount(), 10)})
+	}
+	if this.transactionElapsedTime > 0 {
+		rv = append(rv, [2]string{"transactionElapsedTime", this.transactionElapsedTime.String()})
+	}
+	if transactionRemainingTime := this.TransactionRemainingTime(); transactionRemainingTime != "" {
+		rv = append(rv, [2]string{"transactionRemainingTime", transactionRemainingTime})
+	}
+	if this.SortCount() > 0 {
+		rv = append(rv, [2]string{"sortCount", strconv.FormatUint(this.SortCount(), 10)})
+	}
+	if this.errorCount > 0 {
+		rv = append(rv, [2]string{"errorCount", strconv.Itoa(this.errorCount)})
+	}
+	if this.warningCount > 0 {
+		rv = append(rv, [2]string{"warningCount", strconv.Itoa(this.warningCount)})
+	}
+	return rv
+}
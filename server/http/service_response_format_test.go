@@ -0,0 +1,163 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package http
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/couchbase/query/value"
+)
+
+func TestSignatureColumns(t *testing.T) {
+	sig := value.NewValue(map[string]interface{}{"b": "json", "a": "number"})
+	cols := signatureColumns(sig)
+	if len(cols) != 2 || cols[0] != "a" || cols[1] != "b" {
+		t.Errorf("Unexpected columns %v", cols)
+	}
+
+	sig = value.NewValue(map[string]interface{}{"*": "*"})
+	if cols = signatureColumns(sig); cols != nil {
+		t.Errorf("Expected no columns for SELECT *, got %v", cols)
+	}
+
+	if cols = signatureColumns(value.NewValue("number")); len(cols) != 1 || cols[0] != _RAW_COLUMN {
+		t.Errorf("Unexpected columns for SELECT RAW %v", cols)
+	}
+
+	if cols = signatureColumns(value.NewValue("object")); cols != nil {
+		t.Errorf("Expected no columns for SELECT RAW object, got %v", cols)
+	}
+}
+
+func TestFlattenColumns(t *testing.T) {
+	item := value.NewValue(map[string]interface{}{
+		"s": "a,b",
+		"n": 1.5,
+		"z": nil,
+		"o": map[string]interface{}{"x": []interface{}{1, 2}},
+	})
+	fields := flattenColumns(item, []string{"m", "n", "o", "s", "z"})
+	expected := []string{"", "1.5", `{"x":[1,2]}`, "a,b", ""}
+	for i := range expected {
+		if fields[i] != expected[i] {
+			t.Errorf("Column %d: expected %q, got %q", i, expected[i], fields[i])
+		}
+	}
+
+	fields = flattenColumns(value.NewValue("raw"), []string{_RAW_COLUMN})
+	if len(fields) != 1 || fields[0] != "raw" {
+		t.Errorf("Unexpected raw columns %v", fields)
+	}
+}
+
+func TestDelimitedEscaping(t *testing.T) {
+	csv := map[string]string{
+		"plain":       "plain",
+		"a,b":         `"a,b"`,
+		`say "hi"`:    `"say ""hi"""`,
+		"two\nlines":  "\"two\nlines\"",
+		" leading":    `" leading"`,
+		"":            "",
+		"trailing \t": "trailing \t",
+	}
+	for in, out := range csv {
+		if r := csvQuote(in); r != out {
+			t.Errorf("CSV quoting %q: expected %q, got %q", in, out, r)
+		}
+	}
+
+	if r := tsvEscape("a\tb\nc\\d"); r != `a\tb\nc\\d` {
+		t.Errorf("Unexpected TSV escaping %q", r)
+	}
+}
+
+func TestValidXMLName(t *testing.T) {
+	for _, n := range []string{"a", "_a", "a1", "a-b.c"} {
+		if !validXMLName(n) {
+			t.Errorf("Expected %q to be a valid XML name", n)
+		}
+	}
+	for _, n := range []string{"", "1a", "-a", "a b", "xmlfield", "$1", "a:b"} {
+		if validXMLName(n) {
+			t.Errorf("Expected %q to be an invalid XML name", n)
+		}
+	}
+}
+
+// runs the statement through the test server, asking for the format and metrics with the media type
+func doFormatRequest(t *testing.T, statement, format, accept string) (*http.Response, string) {
+	payload := url.Values{}
+	payload.Set("statement", statement)
+	payload.Set("format", format)
+	payload.Set("metrics", "true")
+
+	req, err := http.NewRequest("POST", test_server.URL()+"/", bytes.NewBufferString(payload.Encode()))
+	if err != nil {
+		t.Fatalf("Failed to create the request: %v", err)
+	}
+	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Add("Accept", accept)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Unexpected error in HTTP request: %v", err)
+	}
+	defer res.Body.Close()
+	body, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("Failed to read the response: %v", err)
+	}
+	return res, string(body)
+}
+
+func checkFormatResponse(t *testing.T, what string, res *http.Response, body, contentType string, contains ...string) {
+	if ct := res.Header.Get("Content-Type"); ct != contentType {
+		t.Errorf("%v: expected content type %v, got %v", what, contentType, ct)
+	}
+	for _, s := range contains {
+		if !strings.Contains(body, s) {
+			t.Errorf("%v: expected %q in %q", what, s, body)
+		}
+	}
+}
+
+func TestFormattedResponses(t *testing.T) {
+	res, body := doFormatRequest(t, "SELECT 1 AS a, 'x,y' AS b", "CSV", "text/csv")
+	checkFormatResponse(t, "CSV", res, body, _CSV_CONTENT,
+		"a,b\r\n1,\"x,y\"\r\n\r\nrequestID,", "\r\nstatus,success\r\n", "\r\nelapsedTime,", "\r\nresultCount,1\r\n")
+
+	res, body = doFormatRequest(t, "SELECT 1 AS a", "TSV", "text/tab-separated-values; charset=utf-8")
+	checkFormatResponse(t, "TSV", res, body, _TSV_CONTENT, "a\n1\n\nrequestID\t", "\nstatus\tsuccess\n")
+
+	res, body = doFormatRequest(t, "SELECT 1 AS a", "XML", "application/xml")
+	checkFormatResponse(t, "XML", res, body, _XML_CONTENT,
+		"<results>\n<result>\n<a>1</a>\n</result>\n</results>", "<status>success</status>", "<resultCount>1</resultCount>")
+
+	// errors go in the trailer
+	res, body = doFormatRequest(t, "SELECT * FROM p0:nosuchkeyspace", "CSV", "text/csv")
+	checkFormatResponse(t, "CSV errors", res, body, _CSV_CONTENT, "\r\nerror,", "\r\nstatus,fatal\r\n", "\r\nerrorCount,1\r\n")
+	res, body = doFormatRequest(t, "SELECT * FROM p0:nosuchkeyspace", "XML", "application/xml")
+	checkFormatResponse(t, "XML errors", res, body, _XML_CONTENT, "<errors>", "<status>fatal</status>")
+
+	// the media type must be that of the format
+	res, body = doFormatRequest(t, "SELECT 1 AS a", "XML", "text/csv")
+	if errs := test_server.request().Errors(); len(errs) != 1 || errs[0].Code() != 1120 {
+		t.Errorf("Expected a media type error, got %v: %v", errs, body)
+	}
+	res, body = doFormatRequest(t, "SELECT 1 AS a", "JSON", "application/xml")
+	if errs := test_server.request().Errors(); len(errs) != 1 || errs[0].Code() != 1120 {
+		t.Errorf("Expected a media type error, got %v: %v", errs, body)
+	}
+}
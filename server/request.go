@@ -22,6 +22,7 @@ import (
 	"github.com/couchbase/query/execution"
 	"github.com/couchbase/query/plan"
 	"github.com/couchbase/query/timestamp"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 	"github.com/couchbase/query/value"
 )
@@ -164,6 +165,7 @@ type Request interface {
 	SetTimings(o execution.Operator)
 	GetTimings() execution.Operator
 	IsAdHoc() bool
+	TraceParent() string
 
 	setSleep() // internal methods for load control
 	sleep()
@@ -224,7 +226,11 @@ type ActiveRequests interface {
 
 var actives ActiveRequests
 
+// no active requests before the request store is set up
 func ActiveRequestsCount() (int, errors.Error) {
+	if actives == nil {
+		return 0, nil
+	}
 	return actives.Count()
 }
 
@@ -241,6 +247,9 @@ func ActiveRequestsForEach(nonBlocking func(string, Request) bool, blocking func
 }
 
 func ActiveRequestsLoad() int {
+	if actives == nil {
+		return 0
+	}
 	return actives.Load()
 }
 
@@ -316,6 +325,7 @@ type BaseRequest struct {
 	atrCollection        string
 	numAtrs              int
 	executionContext     *execution.Context
+	span                 *tracing.Span
 }
 
 type requestIDImpl struct {
@@ -1054,6 +1064,7 @@ func (this *BaseRequest) CompleteRequest(requestTime, serviceTime, transaction_t
 		this.timer.Stop()
 		this.timer = nil
 	}
+	this.finishTrace(requestTime, resultCount, resultSize, errorCount)
 	LogRequest(requestTime, serviceTime, transaction_time, resultCount,
 		resultSize, errorCount, req, this, server)
 
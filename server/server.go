@@ -589,6 +589,7 @@ func (this *Server) setupRequestContext(request Request) bool {
 		request.ScanVectorSource(), request.Output(), nil, request.IndexApiVersion(), request.FeatureControls(),
 		request.QueryContext(), request.UseFts(), request.UseCBO(), optimizer, request.KvTimeout(), request.Timeout())
 	context.SetWhitelist(this.whitelist)
+	context.SetTraceParent(request.TraceParent())
 	context.SetDurability(request.DurabilityLevel(), request.DurabilityTimeout())
 	context.SetScanConsistency(request.ScanConsistency(), request.OriginalScanConsistency())
 
@@ -22,6 +22,7 @@ import (
 	"github.com/couchbase/query/prepareds"
 	"github.com/couchbase/query/scheduler"
 	queryMetakv "github.com/couchbase/query/server/settings/couchbase"
+	"github.com/couchbase/query/tracing"
 	"github.com/couchbase/query/util"
 )
 
@@ -200,6 +201,52 @@ var _SETTERS = map[string]Setter{
 		}
 		return nil
 	},
+	SPILLDIR: func(s *Server, o interface{}) errors.Error {
+		value, _ := o.(string)
+		if util.SetSpillDir(value) != nil {
+			return errors.NewAdminSettingTypeError(SPILLDIR, value)
+		}
+		return nil
+	},
+	SPILLLIMIT: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		util.SetSpillLimit(int64(value) * 1024 * 1024)
+		return nil
+	},
+	SPILLTHRESHOLD: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		util.SetSpillThreshold(int64(value) * 1024 * 1024)
+		return nil
+	},
+	PREPAREDPERSISTDIR: func(s *Server, o interface{}) errors.Error {
+		value, _ := o.(string)
+		return prepareds.PreparedsSetPersistDir(value)
+	},
+	PREPAREDPERSISTINT: func(s *Server, o interface{}) errors.Error {
+		prepareds.PreparedsSetPersistInterval(getDuration(o))
+		return nil
+	},
+	CMPARCHIVEDIR: func(s *Server, o interface{}) errors.Error {
+		value, _ := o.(string)
+		return RequestsSetArchiveDir(value)
+	},
+	CMPARCHIVESIZE: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		RequestsSetArchiveFileSize(int64(value) * 1024 * 1024)
+		return nil
+	},
+	CMPARCHIVEFILES: func(s *Server, o interface{}) errors.Error {
+		value := getNumber(o)
+		RequestsSetArchiveFiles(int(value))
+		return nil
+	},
+	TRACECOLLECTOR: func(s *Server, o interface{}) errors.Error {
+		value, _ := o.(string)
+		if tracing.SetCollector(value) != nil {
+			return errors.NewAdminSettingTypeError(TRACECOLLECTOR, value)
+		}
+		return nil
+	},
 }
 
 func getNumber(o interface{}) float64 {
@@ -0,0 +1,167 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package server
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+	"github.com/couchbase/query/execution"
+	"github.com/couchbase/query/logging"
+	"github.com/couchbase/query/tracing"
+)
+
+/*
+Opens the request span, if tracing is enabled, as a child of the caller's
+span, if any. Requests the caller has chosen not to sample are not traced.
+*/
+func (this *BaseRequest) StartTrace(parent string) {
+	if !tracing.Enabled() {
+		return
+	}
+	var parentContext tracing.SpanContext
+	if parent != "" {
+		var ok bool
+		parentContext, ok = tracing.ParseTraceParent(parent)
+		if ok && !parentContext.IsSampled() {
+			return
+		}
+	}
+	this.span = tracing.NewRequestSpan("query", parentContext, this.requestTime)
+}
+
+// The traceparent of the request span, for propagation
+func (this *BaseRequest) TraceParent() string {
+	if this.span == nil {
+		return ""
+	}
+	return this.span.Context.TraceParent()
+}
+
+/*
+Closes the request span and exports it, together with a child span per
+execution operator, starting when the operator started running.
+*/
+func (this *BaseRequest) finishTrace(requestTime time.Duration, resultCount int, resultSize int,
+	errorCount int) {
+
+	span := this.span
+	if span == nil {
+		return
+	}
+	this.span = nil
+
+	span.Finish(this.requestTime.Add(requestTime))
+	span.SetAttribute("db.system", "couchbase")
+	span.SetAttribute("db.statement", this.Statement())
+	span.SetAttribute("n1ql.request_id", this.Id().String())
+	if clientId := this.ClientID().String(); clientId != "" {
+		span.SetAttribute("n1ql.client_context_id", clientId)
+	}
+	if this.Type() != "" {
+		span.SetAttribute("n1ql.statement_type", this.Type())
+	}
+	span.SetAttribute("n1ql.state", this.State().StateName())
+	span.SetAttribute("n1ql.result_count", resultCount)
+	span.SetAttribute("n1ql.result_size", resultSize)
+	span.SetAttribute("n1ql.error_count", errorCount)
+	span.Error = errorCount > 0
+
+	for i := range this.phaseStats {
+		phase := execution.Phases(i).String()
+		if count := atomic.LoadUint64(&this.phaseStats[i].count); count > 0 {
+			span.SetAttribute("n1ql.phase_counts."+phase, count)
+		}
+		if operators := atomic.LoadUint64(&this.phaseStats[i].operators); operators > 0 {
+			span.SetAttribute("n1ql.phase_operators."+phase, operators)
+		}
+		if duration := atomic.LoadUint64(&this.phaseStats[i].duration); duration > 0 {
+			span.SetAttribute("n1ql.phase_times."+phase, time.Duration(duration))
+		}
+	}
+
+	// tracing may have been turned off while the request was running
+	if !tracing.Enabled() {
+		return
+	}
+
+	spans := []*tracing.Span{span}
+	if this.timings != nil && !this.execTime.IsZero() {
+		bytes, err := json.Marshal(this.timings)
+		if err != nil {
+			logging.Debugf("Tracing: unable to marshal operators for request %v: %v", this.Id().String(), err)
+		} else {
+			var ops interface{}
+			if json.Unmarshal(bytes, &ops) == nil {
+				spans = addOperatorSpans(spans, span, ops, this.execTime)
+			}
+		}
+	}
+
+	tracing.Export(spans)
+}
+
+/*
+Walks the profile of the execution tree, adding a span for each operator,
+with its counters and phase times as attributes.
+*/
+func addOperatorSpans(spans []*tracing.Span, parent *tracing.Span, node interface{},
+	start time.Time) []*tracing.Span {
+
+	switch node := node.(type) {
+	case []interface{}:
+		for _, n := range node {
+			spans = addOperatorSpans(spans, parent, n, start)
+		}
+	case map[string]interface{}:
+		if name, ok := node["#operator"].(string); ok {
+			stats, _ := node["#stats"].(map[string]interface{})
+
+			// operators that never ran start with their parent
+			opStart := start
+			if t, ok := stats["startTime"].(string); ok {
+				if t, err := time.Parse(time.RFC3339Nano, t); err == nil {
+					opStart = t
+				}
+			}
+
+			span := parent.NewChild(name, opStart)
+			var elapsed time.Duration
+			for k, v := range stats {
+				switch v := v.(type) {
+				case float64:
+					span.SetAttribute("n1ql."+strings.TrimPrefix(k, "#"), int64(v))
+				case string:
+					if k == "startTime" {
+						continue
+					}
+					if d, err := time.ParseDuration(v); err == nil {
+						span.SetAttribute("n1ql."+k, d)
+						elapsed += d
+					} else {
+						span.SetAttribute("n1ql."+k, v)
+					}
+				}
+			}
+			span.Finish(opStart.Add(elapsed))
+			spans = append(spans, span)
+			parent = span
+			start = opStart
+		}
+		for k, v := range node {
+			if k != "#stats" {
+				spans = addOperatorSpans(spans, parent, v, start)
+			}
+		}
+	}
+	return spans
+}
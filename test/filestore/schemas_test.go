@@ -0,0 +1,77 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/couchbase/query/schemas"
+	"github.com/couchbase/query/value"
+)
+
+// documents that fail validation are skipped, whatever the statement
+func TestSchemaValidation(t *testing.T) {
+	dir, err := ioutil.TempDir("", "schemas")
+	if err != nil {
+		t.Fatalf("Failed to create directory: %v", err)
+	}
+	defer os.RemoveAll(dir)
+	os.MkdirAll(filepath.Join(dir, "json", _NAMESPACE, "vs"), 0700)
+	ioutil.WriteFile(filepath.Join(dir, "json", _NAMESPACE, "vs", "k1.json"), []byte(`{"a": 1}`), 0600)
+
+	qc := Start("dir:", filepath.Join(dir, "json"), _NAMESPACE)
+	namespace, err1 := qc.dstore.NamespaceByName(_NAMESPACE)
+	if err1 != nil {
+		t.Fatalf("Failed to get the namespace: %v", err1)
+	}
+	keyspace, err1 := namespace.KeyspaceByName("vs")
+	if err1 != nil {
+		t.Fatalf("Failed to get the keyspace: %v", err1)
+	}
+	if err1 = schemas.Set(keyspace, value.NewValue([]byte(`{"type": "object", "properties": {"a": {"type": "number"}}}`))); err1 != nil {
+		t.Fatalf("Failed to set the schema: %v", err1)
+	}
+	defer schemas.Delete(keyspace)
+
+	run := func(stmt string) []interface{} {
+		r, _, err := Run(qc, true, stmt, nil, nil, _NAMESPACE)
+		if err != nil {
+			t.Fatalf("Failed to run %v: %v", stmt, err)
+		}
+		return r
+	}
+	check := func(what string, expected string) {
+		docs := run("SELECT META(v).id, v.a FROM vs AS v ORDER BY META(v).id")
+		r := make([]interface{}, len(docs))
+		for i, d := range docs {
+			doc := d.(map[string]interface{})
+			r[i] = []interface{}{doc["id"], doc["a"]}
+		}
+		if fmt.Sprint(r) != expected {
+			t.Errorf("%v: expected %v, got %v", what, expected, r)
+		}
+	}
+
+	run(`INSERT INTO vs VALUES ("k2", {"a": 2}), ("k3", {"a": "x"}), ("k4", {"a": 4})`)
+	check("INSERT", "[[k1 1] [k2 2] [k4 4]]")
+
+	run(`UPSERT INTO vs VALUES ("k2", {"a": "y"}), ("k5", {"a": 5})`)
+	check("UPSERT", "[[k1 1] [k2 2] [k4 4] [k5 5]]")
+
+	r := run(`UPDATE vs AS v SET v.a = CASE WHEN META(v).id = "k4" THEN "z" ELSE v.a * 10 END RETURNING META(v).id`)
+	if len(r) != 3 {
+		t.Errorf("Expected the documents that failed validation not to be returned, got %v", r)
+	}
+	check("UPDATE", "[[k1 10] [k2 20] [k4 4] [k5 50]]")
+}
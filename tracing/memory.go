@@ -0,0 +1,62 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package tracing
+
+import (
+	"sync"
+)
+
+// Keeps exported spans in memory, for tests
+type MemoryExporter struct {
+	sync.Mutex
+	spans []*Span
+	added *sync.Cond
+}
+
+func NewMemoryExporter() *MemoryExporter {
+	rv := &MemoryExporter{}
+	rv.added = sync.NewCond(&rv.Mutex)
+	return rv
+}
+
+func (this *MemoryExporter) Export(spans []*Span) error {
+	this.Lock()
+	this.spans = append(this.spans, spans...)
+	this.added.Broadcast()
+	this.Unlock()
+	return nil
+}
+
+func (this *MemoryExporter) Shutdown() {
+}
+
+func (this *MemoryExporter) Spans() []*Span {
+	this.Lock()
+	rv := make([]*Span, len(this.spans))
+	copy(rv, this.spans)
+	this.Unlock()
+	return rv
+}
+
+// Waits until at least n spans have been exported
+func (this *MemoryExporter) Wait(n int) []*Span {
+	this.Lock()
+	for len(this.spans) < n {
+		this.added.Wait()
+	}
+	this.Unlock()
+	return this.Spans()
+}
+
+func (this *MemoryExporter) Reset() {
+	this.Lock()
+	this.spans = nil
+	this.Unlock()
+}
@@ -0,0 +1,196 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package tracing
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/couchbase/query/util"
+)
+
+const _OTLP_TRACES_PATH = "/v1/traces"
+const _OTLP_TIMEOUT = 10 * time.Second
+const _SERVICE_NAME = "couchbase-query"
+const _SCOPE_NAME = "github.com/couchbase/query"
+
+/*
+Exports spans to an OpenTelemetry collector using OTLP/HTTP, with
+the JSON encoding of the protocol.
+*/
+type OTLPExporter struct {
+	endpoint string
+	client   *http.Client
+}
+
+/*
+The collector endpoint is a URL; if it has no path, the standard
+traces path is used.
+*/
+func NewOTLPExporter(endpoint string) (*OTLPExporter, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("Invalid collector URL %v: scheme must be http or https", endpoint)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("Invalid collector URL %v: no host", endpoint)
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = _OTLP_TRACES_PATH
+	}
+	return &OTLPExporter{
+		endpoint: u.String(),
+		client:   &http.Client{Timeout: _OTLP_TIMEOUT},
+	}, nil
+}
+
+/*
+Export spans to the collector at the given endpoint.
+An empty endpoint disables tracing.
+*/
+func SetCollector(endpoint string) error {
+	if endpoint == "" {
+		SetExporter(nil)
+		return nil
+	}
+	exporter, err := NewOTLPExporter(endpoint)
+	if err != nil {
+		return err
+	}
+	SetExporter(exporter)
+	return nil
+}
+
+// The collector endpoint spans are exported to, if any
+func Collector() string {
+	if exporter, ok := GetExporter().(*OTLPExporter); ok {
+		return exporter.endpoint
+	}
+	return ""
+}
+
+func (this *OTLPExporter) Endpoint() string {
+	return this.endpoint
+}
+
+func (this *OTLPExporter) Export(spans []*Span) error {
+	body, err := json.Marshal(otlpRequest(spans))
+	if err != nil {
+		return err
+	}
+	resp, err := this.client.Post(this.endpoint, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("collector %v returned %v", this.endpoint, resp.Status)
+	}
+	return nil
+}
+
+func (this *OTLPExporter) Shutdown() {
+	this.client.CloseIdleConnections()
+}
+
+func otlpRequest(spans []*Span) map[string]interface{} {
+	ospans := make([]interface{}, 0, len(spans))
+	for _, s := range spans {
+		ospans = append(ospans, otlpSpan(s))
+	}
+	resource := map[string]interface{}{
+		"attributes": otlpAttributes(map[string]interface{}{
+			"service.name":    _SERVICE_NAME,
+			"service.version": util.VERSION,
+		}),
+	}
+	scopeSpans := map[string]interface{}{
+		"scope": map[string]interface{}{"name": _SCOPE_NAME},
+		"spans": ospans,
+	}
+	return map[string]interface{}{
+		"resourceSpans": []interface{}{
+			map[string]interface{}{
+				"resource":   resource,
+				"scopeSpans": []interface{}{scopeSpans},
+			},
+		},
+	}
+}
+
+func otlpSpan(s *Span) map[string]interface{} {
+	rv := map[string]interface{}{
+		"traceId":           s.Context.TraceId.String(),
+		"spanId":            s.Context.SpanId.String(),
+		"name":              s.Name,
+		"kind":              int(s.Kind),
+		"startTimeUnixNano": strconv.FormatInt(s.Start.UnixNano(), 10),
+		"endTimeUnixNano":   strconv.FormatInt(s.End.UnixNano(), 10),
+		"attributes":        otlpAttributes(s.Attributes),
+	}
+	if s.ParentId.IsValid() {
+		rv["parentSpanId"] = s.ParentId.String()
+	}
+	if s.Error {
+		rv["status"] = map[string]interface{}{"code": 2}
+	}
+	return rv
+}
+
+func otlpAttributes(attrs map[string]interface{}) []interface{} {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	rv := make([]interface{}, 0, len(attrs))
+	for _, k := range keys {
+		rv = append(rv, map[string]interface{}{
+			"key":   k,
+			"value": otlpValue(attrs[k]),
+		})
+	}
+	return rv
+}
+
+// 64 bit integers are strings in the JSON encoding
+func otlpValue(val interface{}) map[string]interface{} {
+	switch val := val.(type) {
+	case string:
+		return map[string]interface{}{"stringValue": val}
+	case bool:
+		return map[string]interface{}{"boolValue": val}
+	case int:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	case int64:
+		return map[string]interface{}{"intValue": strconv.FormatInt(val, 10)}
+	case uint64:
+		return map[string]interface{}{"intValue": strconv.FormatUint(val, 10)}
+	case float64:
+		return map[string]interface{}{"doubleValue": val}
+	case time.Duration:
+		return map[string]interface{}{"intValue": strconv.FormatInt(int64(val), 10)}
+	default:
+		return map[string]interface{}{"stringValue": fmt.Sprintf("%v", val)}
+	}
+}
@@ -0,0 +1,245 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+// Package tracing records request spans, following the OpenTelemetry
+// data model, and hands them to an exporter.
+package tracing
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/couchbase/query/logging"
+)
+
+type TraceId [16]byte
+type SpanId [8]byte
+
+func (this TraceId) String() string {
+	return hex.EncodeToString(this[:])
+}
+
+func (this TraceId) IsValid() bool {
+	return this != TraceId{}
+}
+
+func (this SpanId) String() string {
+	return hex.EncodeToString(this[:])
+}
+
+func (this SpanId) IsValid() bool {
+	return this != SpanId{}
+}
+
+const FLAG_SAMPLED = 0x01
+
+// The identity of a span, as carried by a W3C traceparent header
+type SpanContext struct {
+	TraceId TraceId
+	SpanId  SpanId
+	Flags   byte
+}
+
+func (this SpanContext) IsValid() bool {
+	return this.TraceId.IsValid() && this.SpanId.IsValid()
+}
+
+func (this SpanContext) IsSampled() bool {
+	return this.Flags&FLAG_SAMPLED != 0
+}
+
+const TRACEPARENT = "traceparent"
+
+// version 00: 00-<32 hex trace id>-<16 hex span id>-<2 hex flags>
+const _TRACEPARENT_LEN = 55
+
+/*
+Parse a W3C traceparent header. Versions later than 00 are accepted,
+as long as they start with the version 00 fields.
+*/
+func ParseTraceParent(header string) (SpanContext, bool) {
+	var rv SpanContext
+
+	header = strings.TrimSpace(header)
+	if len(header) < _TRACEPARENT_LEN || (len(header) > _TRACEPARENT_LEN && header[_TRACEPARENT_LEN] != '-') {
+		return rv, false
+	}
+	if header[2] != '-' || header[35] != '-' || header[52] != '-' {
+		return rv, false
+	}
+	version, err := hex.DecodeString(header[0:2])
+	if err != nil || version[0] == 0xff || (version[0] == 0 && len(header) != _TRACEPARENT_LEN) {
+		return rv, false
+	}
+	if strings.ToLower(header) != header {
+		return rv, false
+	}
+	if _, err = hex.Decode(rv.TraceId[:], []byte(header[3:35])); err != nil {
+		return rv, false
+	}
+	if _, err = hex.Decode(rv.SpanId[:], []byte(header[36:52])); err != nil {
+		return rv, false
+	}
+	flags, err := hex.DecodeString(header[53:55])
+	if err != nil {
+		return rv, false
+	}
+	rv.Flags = flags[0]
+	return rv, rv.IsValid()
+}
+
+func (this SpanContext) TraceParent() string {
+	return "00-" + this.TraceId.String() + "-" + this.SpanId.String() + "-" + hex.EncodeToString([]byte{this.Flags})
+}
+
+type SpanKind int
+
+const (
+	SPAN_KIND_INTERNAL SpanKind = 1
+	SPAN_KIND_SERVER   SpanKind = 2
+)
+
+type Span struct {
+	Context    SpanContext
+	ParentId   SpanId
+	Name       string
+	Kind       SpanKind
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	Error      bool
+}
+
+/*
+Start a request span: a child of the given parent if it is valid,
+or the root of a new trace.
+*/
+func NewRequestSpan(name string, parent SpanContext, start time.Time) *Span {
+	rv := &Span{
+		Name:       name,
+		Kind:       SPAN_KIND_SERVER,
+		Start:      start,
+		Attributes: make(map[string]interface{}),
+	}
+	if parent.IsValid() {
+		rv.Context.TraceId = parent.TraceId
+		rv.Context.Flags = parent.Flags
+		rv.ParentId = parent.SpanId
+	} else {
+		rand.Read(rv.Context.TraceId[:])
+		rv.Context.Flags = FLAG_SAMPLED
+	}
+	rand.Read(rv.Context.SpanId[:])
+	return rv
+}
+
+func (this *Span) NewChild(name string, start time.Time) *Span {
+	rv := &Span{
+		Name:       name,
+		Kind:       SPAN_KIND_INTERNAL,
+		ParentId:   this.Context.SpanId,
+		Start:      start,
+		Attributes: make(map[string]interface{}),
+	}
+	rv.Context.TraceId = this.Context.TraceId
+	rv.Context.Flags = this.Context.Flags
+	rand.Read(rv.Context.SpanId[:])
+	return rv
+}
+
+func (this *Span) SetAttribute(key string, val interface{}) {
+	this.Attributes[key] = val
+}
+
+func (this *Span) Finish(end time.Time) {
+	this.End = end
+}
+
+// An Exporter ships finished spans out of the process
+type Exporter interface {
+	Export(spans []*Span) error
+	Shutdown()
+}
+
+const _QUEUE_SIZE = 256
+
+type tracer struct {
+	sync.RWMutex
+	exporter Exporter
+	queue    chan []*Span
+	done     chan bool
+}
+
+var theTracer tracer
+
+func Enabled() bool {
+	theTracer.RLock()
+	rv := theTracer.exporter != nil
+	theTracer.RUnlock()
+	return rv
+}
+
+/*
+Set the exporter spans are sent to. A nil exporter disables tracing.
+Spans are exported in the background; if the exporter falls behind,
+spans are dropped rather than holding up requests.
+*/
+func SetExporter(exporter Exporter) {
+	theTracer.Lock()
+	oldExporter := theTracer.exporter
+	if oldExporter != nil {
+		close(theTracer.queue)
+		<-theTracer.done
+	}
+	theTracer.exporter = exporter
+	if exporter != nil {
+		theTracer.queue = make(chan []*Span, _QUEUE_SIZE)
+		theTracer.done = make(chan bool)
+		go export(exporter, theTracer.queue, theTracer.done)
+	}
+	theTracer.Unlock()
+
+	if oldExporter != nil {
+		oldExporter.Shutdown()
+	}
+}
+
+func GetExporter() Exporter {
+	theTracer.RLock()
+	rv := theTracer.exporter
+	theTracer.RUnlock()
+	return rv
+}
+
+// Hand the spans of a request to the exporter
+func Export(spans []*Span) {
+	theTracer.RLock()
+	defer theTracer.RUnlock()
+	if theTracer.exporter == nil || len(spans) == 0 {
+		return
+	}
+	select {
+	case theTracer.queue <- spans:
+	default:
+		logging.Debugf("Tracing queue full: dropping %v spans", len(spans))
+	}
+}
+
+func export(exporter Exporter, queue chan []*Span, done chan bool) {
+	for spans := range queue {
+		err := exporter.Export(spans)
+		if err != nil {
+			logging.Errorf("Tracing: unable to export spans: %v", err)
+		}
+	}
+	close(done)
+}
@@ -0,0 +1,145 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package tracing
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestTraceParent(t *testing.T) {
+	header := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	sc, ok := ParseTraceParent(header)
+	if !ok {
+		t.Fatalf("Failed to parse %v", header)
+	}
+	if sc.TraceId.String() != "4bf92f3577b34da6a3ce929d0e0e4736" || sc.SpanId.String() != "00f067aa0ba902b7" {
+		t.Errorf("Unexpected ids: %v %v", sc.TraceId, sc.SpanId)
+	}
+	if !sc.IsSampled() {
+		t.Errorf("Expected %v to be sampled", header)
+	}
+	if sc.TraceParent() != header {
+		t.Errorf("Expected %v, got %v", header, sc.TraceParent())
+	}
+
+	for _, bad := range []string{
+		"",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7",
+		"00-00000000000000000000000000000000-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-0000000000000000-01",
+		"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01",
+		"ff-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01",
+		"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra",
+		"00-4bf92f3577b34da6a3ce929d0e0e473x-00f067aa0ba902b7-01",
+	} {
+		if _, ok := ParseTraceParent(bad); ok {
+			t.Errorf("Expected %q to be rejected", bad)
+		}
+	}
+
+	// later versions may add fields
+	if _, ok := ParseTraceParent("01-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01-extra"); !ok {
+		t.Errorf("Expected a later version to be accepted")
+	}
+}
+
+func TestSpans(t *testing.T) {
+	parent, _ := ParseTraceParent("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	start := time.Now()
+	request := NewRequestSpan("query", parent, start)
+	if request.Context.TraceId != parent.TraceId || request.ParentId != parent.SpanId {
+		t.Errorf("Expected request span to be a child of the caller's span")
+	}
+	child := request.NewChild("scan", start)
+	if child.Context.TraceId != parent.TraceId || child.ParentId != request.Context.SpanId {
+		t.Errorf("Expected operator span to be a child of the request span")
+	}
+	if child.Context.SpanId == request.Context.SpanId {
+		t.Errorf("Expected different span ids")
+	}
+
+	root := NewRequestSpan("query", SpanContext{}, start)
+	if !root.Context.IsValid() || root.ParentId.IsValid() || !root.Context.IsSampled() {
+		t.Errorf("Expected a new sampled trace")
+	}
+}
+
+func TestMemoryExporter(t *testing.T) {
+	exporter := NewMemoryExporter()
+	SetExporter(exporter)
+	defer SetExporter(nil)
+
+	if !Enabled() {
+		t.Fatalf("Expected tracing to be enabled")
+	}
+	request := NewRequestSpan("query", SpanContext{}, time.Now())
+	Export([]*Span{request, request.NewChild("scan", time.Now())})
+
+	spans := exporter.Wait(2)
+	if len(spans) != 2 || spans[0].Name != "query" || spans[1].Name != "scan" {
+		t.Errorf("Unexpected spans: %v", spans)
+	}
+
+	SetExporter(nil)
+	if Enabled() {
+		t.Errorf("Expected tracing to be disabled")
+	}
+}
+
+func TestOTLPExporter(t *testing.T) {
+	var body map[string]interface{}
+	var path string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		path = req.URL.Path
+		b, _ := ioutil.ReadAll(req.Body)
+		json.Unmarshal(b, &body)
+	}))
+	defer srv.Close()
+
+	exporter, err := NewOTLPExporter(srv.URL)
+	if err != nil {
+		t.Fatalf("Failed to create exporter: %v", err)
+	}
+
+	start := time.Unix(0, 1000)
+	span := NewRequestSpan("query", SpanContext{}, start)
+	span.SetAttribute("n1ql.result_count", 10)
+	span.Finish(start.Add(time.Microsecond))
+	err = exporter.Export([]*Span{span})
+	if err != nil {
+		t.Fatalf("Failed to export: %v", err)
+	}
+
+	if path != "/v1/traces" {
+		t.Errorf("Expected spans to be posted to /v1/traces, got %v", path)
+	}
+	rs := body["resourceSpans"].([]interface{})[0].(map[string]interface{})
+	ss := rs["scopeSpans"].([]interface{})[0].(map[string]interface{})
+	s := ss["spans"].([]interface{})[0].(map[string]interface{})
+	if s["traceId"] != span.Context.TraceId.String() || s["name"] != "query" {
+		t.Errorf("Unexpected span: %v", s)
+	}
+	if s["startTimeUnixNano"] != "1000" || s["endTimeUnixNano"] != "2000" {
+		t.Errorf("Unexpected span times: %v %v", s["startTimeUnixNano"], s["endTimeUnixNano"])
+	}
+	attr := s["attributes"].([]interface{})[0].(map[string]interface{})
+	if attr["key"] != "n1ql.result_count" || attr["value"].(map[string]interface{})["intValue"] != "10" {
+		t.Errorf("Unexpected attribute: %v", attr)
+	}
+
+	if _, err = NewOTLPExporter("collector:4318"); err == nil {
+		t.Errorf("Expected a URL without a scheme to be rejected")
+	}
+}
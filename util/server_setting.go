@@ -38,7 +38,7 @@ const (
 )
 
 const DEF_N1QL_FEAT_CTRL = (N1QL_ENCODED_PLAN | N1QL_GOLANG_UDF | N1QL_CBO_NEW)
-const CE_N1QL_FEAT_CTRL = (N1QL_GROUPAGG_PUSHDOWN | N1QL_HASH_JOIN | N1QL_ENCODED_PLAN | N1QL_GOLANG_UDF | N1QL_CBO | N1QL_FLEXINDEX | N1QL_CBO_NEW)
+const CE_N1QL_FEAT_CTRL = (N1QL_GROUPAGG_PUSHDOWN | N1QL_HASH_JOIN | N1QL_ENCODED_PLAN | N1QL_GOLANG_UDF | N1QL_FLEXINDEX | N1QL_CBO_NEW)
 
 func SetN1qlFeatureControl(control uint64) {
 	atomic.StoreInt64(&N1qlFeatureControl, int64(control))
@@ -0,0 +1,321 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package sketch
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+)
+
+const (
+	HLL_MIN_PRECISION     = 4
+	HLL_MAX_PRECISION     = 18
+	HLL_DEFAULT_PRECISION = 14
+)
+
+/*
+HyperLogLog sketch of 64-bit hashes. The first precision bits of a
+hash select one of 2^precision registers, which keeps the highest rank,
+the position of the first 1-bit, of the remaining bits. The standard
+error of the estimate is about 1.04 / sqrt(2^precision), 0.8% for the
+default precision.
+
+Sketches of few values keep the registers that are set in a map, and
+switch to an array of all the registers as they grow.
+*/
+type HLL struct {
+	precision uint8
+	sparse    map[uint32]uint8
+	registers []uint8
+}
+
+func NewHLL(precision int) (*HLL, error) {
+	if precision < HLL_MIN_PRECISION || precision > HLL_MAX_PRECISION {
+		return nil, fmt.Errorf("HyperLogLog precision must be between %d and %d: %d.",
+			HLL_MIN_PRECISION, HLL_MAX_PRECISION, precision)
+	}
+
+	return &HLL{
+		precision: uint8(precision),
+		sparse:    make(map[uint32]uint8),
+	}, nil
+}
+
+func (this *HLL) Kind() string {
+	return HLL_SKETCH
+}
+
+func (this *HLL) Precision() int {
+	return int(this.precision)
+}
+
+/*
+Add the hash of a value.
+*/
+func (this *HLL) AddHash(hash uint64) {
+	p := uint(this.precision)
+	index := uint32(hash >> (64 - p))
+	rank := uint8(bits.LeadingZeros64((hash<<p)|(1<<(p-1)))) + 1
+	this.set(index, rank)
+}
+
+func (this *HLL) set(index uint32, rank uint8) {
+	if this.registers != nil {
+		if rank > this.registers[index] {
+			this.registers[index] = rank
+		}
+		return
+	}
+
+	if rank > this.sparse[index] {
+		this.sparse[index] = rank
+		if len(this.sparse) > this.size()/8 {
+			this.densify()
+		}
+	}
+}
+
+func (this *HLL) size() int {
+	return 1 << this.precision
+}
+
+func (this *HLL) densify() {
+	this.registers = make([]uint8, this.size())
+	for index, rank := range this.sparse {
+		this.registers[index] = rank
+	}
+	this.sparse = nil
+}
+
+func (this *HLL) forEach(f func(index uint32, rank uint8)) {
+	if this.registers != nil {
+		for index, rank := range this.registers {
+			if rank > 0 {
+				f(uint32(index), rank)
+			}
+		}
+	} else {
+		for index, rank := range this.sparse {
+			f(index, rank)
+		}
+	}
+}
+
+/*
+Merge another HyperLogLog sketch. A sketch of a higher precision is
+folded to the precision of this sketch; if this sketch has the higher
+precision, it is folded first.
+*/
+func (this *HLL) Merge(other Sketch) error {
+	o, ok := other.(*HLL)
+	if !ok {
+		return fmt.Errorf("Cannot merge %s sketch into %s sketch.", other.Kind(), this.Kind())
+	}
+
+	if o.precision < this.precision {
+		this.fold(o.precision)
+	}
+
+	shift := uint(o.precision - this.precision)
+	o.forEach(func(index uint32, rank uint8) {
+		this.set(foldRegister(index, rank, shift))
+	})
+	return nil
+}
+
+/*
+Lower the precision of the sketch. The bits dropped from the register
+index become the first bits of the rest of the hash.
+*/
+func (this *HLL) fold(precision uint8) {
+	old := &HLL{precision: this.precision, sparse: this.sparse, registers: this.registers}
+	shift := uint(this.precision - precision)
+
+	this.precision = precision
+	this.sparse = make(map[uint32]uint8)
+	this.registers = nil
+	old.forEach(func(index uint32, rank uint8) {
+		this.set(foldRegister(index, rank, shift))
+	})
+}
+
+func foldRegister(index uint32, rank uint8, shift uint) (uint32, uint8) {
+	if shift == 0 {
+		return index, rank
+	}
+
+	dropped := index & (1<<shift - 1)
+	if dropped != 0 {
+		rank = uint8(int(shift)-bits.Len32(dropped)) + 1
+	} else {
+		rank += uint8(shift)
+	}
+	return index >> shift, rank
+}
+
+func (this *HLL) Copy() Sketch {
+	rv := &HLL{precision: this.precision}
+	if this.registers != nil {
+		rv.registers = make([]uint8, len(this.registers))
+		copy(rv.registers, this.registers)
+	} else {
+		rv.sparse = make(map[uint32]uint8, len(this.sparse))
+		for index, rank := range this.sparse {
+			rv.sparse[index] = rank
+		}
+	}
+	return rv
+}
+
+/*
+Estimate the number of distinct hashes, with the improved raw estimator
+of O. Ertl, "New cardinality estimation algorithms for HyperLogLog
+sketches", which needs no empirical bias correction.
+*/
+func (this *HLL) Estimate() float64 {
+	m := float64(this.size())
+	q := 64 - int(this.precision)
+	counts := make([]float64, q+2)
+	set := 0
+	this.forEach(func(index uint32, rank uint8) {
+		counts[rank]++
+		set++
+	})
+
+	if set == 0 {
+		return 0.0
+	}
+	counts[0] = m - float64(set)
+
+	z := m * hllTau(1.0-counts[q+1]/m)
+	for k := q; k >= 1; k-- {
+		z = 0.5 * (z + counts[k])
+	}
+	z += m * hllSigma(counts[0]/m)
+
+	return math.Round(m * m / (2.0 * math.Ln2 * z))
+}
+
+func hllSigma(x float64) float64 {
+	if x == 1.0 {
+		return math.Inf(1)
+	}
+
+	y := 1.0
+	z := x
+	for {
+		x *= x
+		prev := z
+		z += x * y
+		y += y
+		if z == prev {
+			return z
+		}
+	}
+}
+
+func hllTau(x float64) float64 {
+	if x == 0.0 || x == 1.0 {
+		return 0.0
+	}
+
+	y := 1.0
+	z := 1.0 - x
+	for {
+		x = math.Sqrt(x)
+		prev := z
+		y *= 0.5
+		z -= (1.0 - x) * (1.0 - x) * y
+		if z == prev {
+			return z / 3.0
+		}
+	}
+}
+
+/*
+The registers are encoded in base64, all of them, or the index and the
+rank of each register that is set while the sketch is sparse.
+*/
+func (this *HLL) Encode() map[string]interface{} {
+	rv := map[string]interface{}{
+		"sketch":    HLL_SKETCH,
+		"precision": int64(this.precision),
+	}
+
+	if this.registers != nil {
+		rv["registers"] = base64.StdEncoding.EncodeToString(this.registers)
+		return rv
+	}
+
+	indexes := make([]uint32, 0, len(this.sparse))
+	for index, _ := range this.sparse {
+		indexes = append(indexes, index)
+	}
+	sort.Slice(indexes, func(i, j int) bool { return indexes[i] < indexes[j] })
+
+	buf := make([]byte, 5*len(indexes))
+	for i, index := range indexes {
+		binary.BigEndian.PutUint32(buf[5*i:], index)
+		buf[5*i+4] = this.sparse[index]
+	}
+	rv["sparse"] = base64.StdEncoding.EncodeToString(buf)
+	return rv
+}
+
+func decodeHLL(m map[string]interface{}) (*HLL, error) {
+	precision, err := decodeNumber(m, "precision")
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := NewHLL(int(precision))
+	if err != nil {
+		return nil, err
+	}
+
+	maxRank := uint8(64 - rv.precision + 1)
+	if registers, ok := m["registers"].(string); ok {
+		buf, err := base64.StdEncoding.DecodeString(registers)
+		if err != nil || len(buf) != rv.size() {
+			return nil, fmt.Errorf("Invalid hll sketch: invalid registers.")
+		}
+		for _, rank := range buf {
+			if rank > maxRank {
+				return nil, fmt.Errorf("Invalid hll sketch: invalid registers.")
+			}
+		}
+		rv.sparse = nil
+		rv.registers = buf
+		return rv, nil
+	}
+
+	sparse, ok := m["sparse"].(string)
+	if !ok {
+		return nil, fmt.Errorf("Invalid hll sketch: missing registers.")
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(sparse)
+	if err != nil || len(buf)%5 != 0 {
+		return nil, fmt.Errorf("Invalid hll sketch: invalid sparse registers.")
+	}
+	for i := 0; i < len(buf); i += 5 {
+		index := binary.BigEndian.Uint32(buf[i:])
+		rank := buf[i+4]
+		if index >= uint32(rv.size()) || rank == 0 || rank > maxRank {
+			return nil, fmt.Errorf("Invalid hll sketch: invalid sparse registers.")
+		}
+		rv.set(index, rank)
+	}
+	return rv, nil
+}
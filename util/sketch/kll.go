@@ -0,0 +1,317 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package sketch
+
+import (
+	"fmt"
+	"math"
+	"sort"
+)
+
+const (
+	KLL_MIN_K     = 8
+	KLL_MAX_K     = 65535
+	KLL_DEFAULT_K = 200
+)
+
+/*
+KLL quantile sketch of numbers, after Z. Karnin, K. Lang, E. Liberty,
+"Optimal Quantile Approximation in Streams". Values are kept in levels
+of compactors, a value at level h standing for 2^h of the values added.
+When the sketch is full, the lowest full level is sorted and every
+other value of it moves up one level. The rank error is about 1.65 / k,
+0.8% for the default k, whatever the number of values.
+*/
+type KLL struct {
+	k      int
+	n      int64
+	min    float64
+	max    float64
+	levels [][]float64
+	coin   bool
+}
+
+func NewKLL(k int) (*KLL, error) {
+	if k < KLL_MIN_K || k > KLL_MAX_K {
+		return nil, fmt.Errorf("KLL k must be between %d and %d: %d.", KLL_MIN_K, KLL_MAX_K, k)
+	}
+
+	return &KLL{
+		k:      k,
+		levels: [][]float64{make([]float64, 0, k)},
+	}, nil
+}
+
+func (this *KLL) Kind() string {
+	return KLL_SKETCH
+}
+
+/*
+Number of values added.
+*/
+func (this *KLL) Count() int64 {
+	return this.n
+}
+
+func (this *KLL) Add(f float64) {
+	if math.IsNaN(f) {
+		return
+	}
+
+	if this.n == 0 || f < this.min {
+		this.min = f
+	}
+	if this.n == 0 || f > this.max {
+		this.max = f
+	}
+	this.n++
+
+	this.levels[0] = append(this.levels[0], f)
+	this.compress()
+}
+
+/*
+Capacity of a level; the levels below the top shrink geometrically.
+*/
+func (this *KLL) capacity(h int) int {
+	depth := len(this.levels) - h - 1
+	return int(math.Max(2.0, math.Ceil(float64(this.k)*math.Pow(2.0/3.0, float64(depth)))))
+}
+
+func (this *KLL) size() (size, capacity int) {
+	for h, level := range this.levels {
+		size += len(level)
+		capacity += this.capacity(h)
+	}
+	return
+}
+
+func (this *KLL) compress() {
+	for {
+		size, capacity := this.size()
+		if size < capacity {
+			return
+		}
+
+		for h, level := range this.levels {
+			if len(level) < this.capacity(h) {
+				continue
+			}
+
+			if h+1 == len(this.levels) {
+				this.levels = append(this.levels, nil)
+			}
+
+			sort.Float64s(level)
+
+			// an odd value out stays at this level
+			keep := len(level) % 2
+			offset := keep
+			if this.coin {
+				offset++
+			}
+			this.coin = !this.coin
+
+			for i := offset; i < len(level); i += 2 {
+				this.levels[h+1] = append(this.levels[h+1], level[i])
+			}
+			this.levels[h] = level[:keep]
+			break
+		}
+	}
+}
+
+/*
+Merge another KLL sketch. The result has the smaller k of the two.
+*/
+func (this *KLL) Merge(other Sketch) error {
+	o, ok := other.(*KLL)
+	if !ok {
+		return fmt.Errorf("Cannot merge %s sketch into %s sketch.", other.Kind(), this.Kind())
+	}
+
+	if o.n == 0 {
+		return nil
+	}
+
+	if this.n == 0 || o.min < this.min {
+		this.min = o.min
+	}
+	if this.n == 0 || o.max > this.max {
+		this.max = o.max
+	}
+	this.n += o.n
+
+	if o.k < this.k {
+		this.k = o.k
+	}
+
+	for h, level := range o.levels {
+		if h == len(this.levels) {
+			this.levels = append(this.levels, nil)
+		}
+		this.levels[h] = append(this.levels[h], level...)
+	}
+	this.compress()
+	return nil
+}
+
+func (this *KLL) Copy() Sketch {
+	rv := &KLL{
+		k:      this.k,
+		n:      this.n,
+		min:    this.min,
+		max:    this.max,
+		levels: make([][]float64, len(this.levels)),
+		coin:   this.coin,
+	}
+
+	for h, level := range this.levels {
+		rv.levels[h] = make([]float64, len(level))
+		copy(rv.levels[h], level)
+	}
+	return rv
+}
+
+/*
+Estimate the value at the fraction of the sorted values: the first
+value whose rank reaches the fraction, the minimum for 0 and the
+maximum for 1. Returns NaN if there are no values.
+*/
+func (this *KLL) Quantile(fraction float64) float64 {
+	return this.Quantiles([]float64{fraction})[0]
+}
+
+func (this *KLL) Quantiles(fractions []float64) []float64 {
+	rv := make([]float64, len(fractions))
+	if this.n == 0 {
+		for i, _ := range rv {
+			rv[i] = math.NaN()
+		}
+		return rv
+	}
+
+	type weighted struct {
+		value  float64
+		weight int64
+	}
+
+	items := make([]weighted, 0, this.k*2)
+	total := int64(0)
+	for h, level := range this.levels {
+		for _, f := range level {
+			items = append(items, weighted{f, 1 << uint(h)})
+			total += 1 << uint(h)
+		}
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].value < items[j].value })
+
+	for i, fraction := range fractions {
+		if fraction <= 0.0 {
+			rv[i] = this.min
+			continue
+		} else if fraction >= 1.0 {
+			rv[i] = this.max
+			continue
+		}
+
+		target := fraction * float64(total)
+		cum := int64(0)
+		rv[i] = this.max
+		for _, item := range items {
+			cum += item.weight
+			if float64(cum) >= target {
+				rv[i] = item.value
+				break
+			}
+		}
+	}
+	return rv
+}
+
+func (this *KLL) Encode() map[string]interface{} {
+	levels := make([]interface{}, len(this.levels))
+	for h, level := range this.levels {
+		values := make([]interface{}, len(level))
+		for i, f := range level {
+			values[i] = f
+		}
+		levels[h] = values
+	}
+
+	rv := map[string]interface{}{
+		"sketch": KLL_SKETCH,
+		"k":      int64(this.k),
+		"n":      this.n,
+		"levels": levels,
+	}
+	if this.n > 0 {
+		rv["min"] = this.min
+		rv["max"] = this.max
+	}
+	return rv
+}
+
+func decodeKLL(m map[string]interface{}) (*KLL, error) {
+	k, err := decodeNumber(m, "k")
+	if err != nil {
+		return nil, err
+	}
+
+	rv, err := NewKLL(int(k))
+	if err != nil {
+		return nil, err
+	}
+
+	n, err := decodeNumber(m, "n")
+	if err != nil {
+		return nil, err
+	}
+	rv.n = int64(n)
+
+	if rv.n > 0 {
+		rv.min, err = decodeNumber(m, "min")
+		if err != nil {
+			return nil, err
+		}
+		rv.max, err = decodeNumber(m, "max")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	levels, ok := m["levels"].([]interface{})
+	if !ok || len(levels) == 0 {
+		return nil, fmt.Errorf("Invalid kll sketch: missing or invalid levels.")
+	}
+
+	total := int64(0)
+	rv.levels = make([][]float64, len(levels))
+	for h, level := range levels {
+		values, ok := level.([]interface{})
+		if !ok {
+			return nil, fmt.Errorf("Invalid kll sketch: invalid level %d.", h)
+		}
+
+		rv.levels[h] = make([]float64, len(values))
+		for i, v := range values {
+			rv.levels[h][i], ok = toFloat(v)
+			if !ok {
+				return nil, fmt.Errorf("Invalid kll sketch: invalid value %v in level %d.", v, h)
+			}
+		}
+		total += int64(len(values)) << uint(h)
+	}
+
+	if total != rv.n {
+		return nil, fmt.Errorf("Invalid kll sketch: levels do not add up to %d values.", rv.n)
+	}
+	return rv, nil
+}
@@ -0,0 +1,84 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+/*
+Package sketch provides mergeable summaries of large sets of values:
+HyperLogLog sketches to estimate the number of distinct values, and KLL
+sketches to estimate quantiles of numbers.
+
+Sketches built separately, for instance by parallel aggregation or at
+different times, can be merged into the sketch of all the values. They
+are encoded as JSON objects, so that they can be stored in documents.
+*/
+package sketch
+
+import (
+	"fmt"
+)
+
+const (
+	HLL_SKETCH = "hll"
+	KLL_SKETCH = "kll"
+)
+
+type Sketch interface {
+	/*
+	   HLL_SKETCH or KLL_SKETCH.
+	*/
+	Kind() string
+
+	/*
+	   Merge another sketch of the same kind into this sketch.
+	*/
+	Merge(other Sketch) error
+
+	Copy() Sketch
+
+	/*
+	   The sketch as a JSON object.
+	*/
+	Encode() map[string]interface{}
+}
+
+/*
+Decode a sketch encoded as a JSON object.
+*/
+func Decode(m map[string]interface{}) (Sketch, error) {
+	switch m["sketch"] {
+	case HLL_SKETCH:
+		return decodeHLL(m)
+	case KLL_SKETCH:
+		return decodeKLL(m)
+	default:
+		return nil, fmt.Errorf("Not a sketch: missing or invalid sketch field %v.", m["sketch"])
+	}
+}
+
+/*
+Numbers of decoded sketches can be float64 or int64.
+*/
+func decodeNumber(m map[string]interface{}, field string) (float64, error) {
+	if f, ok := toFloat(m[field]); ok {
+		return f, nil
+	}
+	return 0.0, fmt.Errorf("Invalid %s sketch: missing or invalid %s field %v.", m["sketch"], field, m[field])
+}
+
+func toFloat(n interface{}) (float64, bool) {
+	switch n := n.(type) {
+	case float64:
+		return n, true
+	case int64:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	default:
+		return 0.0, false
+	}
+}
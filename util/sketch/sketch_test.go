@@ -0,0 +1,144 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package sketch
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func hash(i int) uint64 {
+	// splitmix64 finalizer
+	z := uint64(i) + 0x9e3779b97f4a7c15
+	z = (z ^ (z >> 30)) * 0xbf58476d1ce4e5b9
+	z = (z ^ (z >> 27)) * 0x94d049bb133111eb
+	return z ^ (z >> 31)
+}
+
+func TestHLLEstimate(t *testing.T) {
+	for _, n := range []int{0, 1, 10, 1000, 100000, 1000000} {
+		h, _ := NewHLL(HLL_DEFAULT_PRECISION)
+		for i := 0; i < n; i++ {
+			h.AddHash(hash(i))
+			h.AddHash(hash(i))
+		}
+
+		est := h.Estimate()
+		if math.Abs(est-float64(n)) > 0.03*float64(n)+1 {
+			t.Errorf("Expected about %d distinct values, got %v", n, est)
+		}
+	}
+}
+
+func TestHLLMerge(t *testing.T) {
+	h1, _ := NewHLL(14)
+	h2, _ := NewHLL(12)
+	for i := 0; i < 60000; i++ {
+		h1.AddHash(hash(i))
+	}
+	for i := 40000; i < 100000; i++ {
+		h2.AddHash(hash(i))
+	}
+
+	err := h1.Merge(h2)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+	if h1.Precision() != 12 {
+		t.Errorf("Expected precision 12, got %d", h1.Precision())
+	}
+	if est := h1.Estimate(); math.Abs(est-100000) > 5000 {
+		t.Errorf("Expected about 100000 distinct values, got %v", est)
+	}
+}
+
+func TestHLLEncode(t *testing.T) {
+	for _, n := range []int{10, 100000} {
+		h, _ := NewHLL(HLL_DEFAULT_PRECISION)
+		for i := 0; i < n; i++ {
+			h.AddHash(hash(i))
+		}
+
+		bytes, _ := json.Marshal(h.Encode())
+		var m map[string]interface{}
+		json.Unmarshal(bytes, &m)
+		d, err := Decode(m)
+		if err != nil {
+			t.Errorf("Unexpected error %v", err)
+		} else if d.(*HLL).Estimate() != h.Estimate() {
+			t.Errorf("Expected estimate %v, got %v", h.Estimate(), d.(*HLL).Estimate())
+		}
+	}
+
+	_, err := Decode(map[string]interface{}{"sketch": "hll", "precision": 14.0, "sparse": "AAAA"})
+	if err == nil {
+		t.Errorf("Expected error decoding invalid sketch")
+	}
+}
+
+func TestKLLQuantile(t *testing.T) {
+	k, _ := NewKLL(KLL_DEFAULT_K)
+	n := 100000
+	for _, i := range rand.New(rand.NewSource(1)).Perm(n) {
+		k.Add(float64(i))
+	}
+
+	if k.Count() != int64(n) {
+		t.Errorf("Expected count %d, got %d", n, k.Count())
+	}
+	if q := k.Quantile(0.0); q != 0 {
+		t.Errorf("Expected minimum 0, got %v", q)
+	}
+	if q := k.Quantile(1.0); q != float64(n-1) {
+		t.Errorf("Expected maximum %d, got %v", n-1, q)
+	}
+	for _, f := range []float64{0.01, 0.25, 0.5, 0.9, 0.99} {
+		if q := k.Quantile(f); math.Abs(q-f*float64(n)) > 0.02*float64(n) {
+			t.Errorf("Expected quantile %v about %v, got %v", f, f*float64(n), q)
+		}
+	}
+}
+
+func TestKLLMergeEncode(t *testing.T) {
+	k1, _ := NewKLL(KLL_DEFAULT_K)
+	k2, _ := NewKLL(100)
+	for i := 0; i < 50000; i++ {
+		k1.Add(float64(i))
+		k2.Add(float64(50000 + i))
+	}
+
+	err := k1.Merge(k2)
+	if err != nil {
+		t.Errorf("Unexpected error %v", err)
+	}
+
+	bytes, _ := json.Marshal(k1.Encode())
+	var m map[string]interface{}
+	json.Unmarshal(bytes, &m)
+	d, err := Decode(m)
+	if err != nil {
+		t.Fatalf("Unexpected error %v", err)
+	}
+
+	kd := d.(*KLL)
+	if kd.Count() != 100000 {
+		t.Errorf("Expected count 100000, got %d", kd.Count())
+	}
+	if q := kd.Quantile(0.5); math.Abs(q-50000) > 2500 {
+		t.Errorf("Expected median about 50000, got %v", q)
+	}
+
+	h, _ := NewHLL(HLL_DEFAULT_PRECISION)
+	if err := kd.Merge(h); err == nil {
+		t.Errorf("Expected error merging hll sketch into kll sketch")
+	}
+}
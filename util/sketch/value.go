@@ -0,0 +1,57 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package sketch
+
+import (
+	"encoding/json"
+
+	"github.com/couchbase/query/util"
+	"github.com/couchbase/query/value"
+)
+
+/*
+The hash of a value added to a sketch, from its encoded form, with the
+fields of objects in sorted order, so that equal values have equal hashes.
+*/
+func HashValue(v value.Value) uint64 {
+	bytes, _ := v.MarshalJSON()
+	return util.SeaHashSum64(bytes)
+}
+
+/*
+The sketch as a JSON object value.
+*/
+func ToValue(s Sketch) value.Value {
+	return value.NewValue(s.Encode())
+}
+
+/*
+Decode a sketch from an object value. Returns nil if the value is not
+an object with a sketch field.
+*/
+func FromValue(v value.Value) (Sketch, error) {
+	if v.Type() != value.OBJECT {
+		return nil, nil
+	} else if _, ok := v.Field("sketch"); !ok {
+		return nil, nil
+	}
+
+	bytes, err := v.MarshalJSON()
+	if err != nil {
+		return nil, err
+	}
+
+	var m map[string]interface{}
+	err = json.Unmarshal(bytes, &m)
+	if err != nil {
+		return nil, err
+	}
+	return Decode(m)
+}
@@ -0,0 +1,175 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package util
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	atomic "github.com/couchbase/go-couchbase/platform"
+)
+
+// spill files hold data that operators cannot keep in memory
+// they live in the spill directory, and the space they take on a node is capped
+// the limit and the threshold are in bytes, a limit of 0 disables spilling
+
+const (
+	DEF_SPILL_LIMIT     = 5 * 1024 * 1024 * 1024
+	DEF_SPILL_THRESHOLD = 128 * 1024 * 1024
+)
+
+var ErrSpillLimitExceeded = fmt.Errorf("spill limit exceeded")
+
+var spillDir string
+var spillDirLock sync.RWMutex
+var spillLimit atomic.AlignedInt64 = DEF_SPILL_LIMIT
+var spillThreshold atomic.AlignedInt64 = DEF_SPILL_THRESHOLD
+var spillUsed atomic.AlignedInt64
+
+func SetSpillDir(dir string) error {
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			return fmt.Errorf("%s is not a directory", dir)
+		}
+	}
+	spillDirLock.Lock()
+	spillDir = dir
+	spillDirLock.Unlock()
+	return nil
+}
+
+func SpillDir() string {
+	spillDirLock.RLock()
+	defer spillDirLock.RUnlock()
+	if spillDir == "" {
+		return os.TempDir()
+	}
+	return spillDir
+}
+
+func SetSpillLimit(limit int64) {
+	atomic.StoreInt64(&spillLimit, limit)
+}
+
+func SpillLimit() int64 {
+	return atomic.LoadInt64(&spillLimit)
+}
+
+func SetSpillThreshold(threshold int64) {
+	atomic.StoreInt64(&spillThreshold, threshold)
+}
+
+func SpillThreshold() int64 {
+	return atomic.LoadInt64(&spillThreshold)
+}
+
+// space currently taken by spill files
+func SpillUsed() int64 {
+	return atomic.LoadInt64(&spillUsed)
+}
+
+// a temporary file of length prefixed records, written first, then read back
+type SpillFile struct {
+	file   *os.File
+	writer *bufio.Writer
+	reader *bufio.Reader
+	size   int64
+}
+
+func NewSpillFile() (*SpillFile, error) {
+	if SpillLimit() <= 0 {
+		return nil, ErrSpillLimitExceeded
+	}
+	file, err := ioutil.TempFile(SpillDir(), "query_spill_")
+	if err != nil {
+		return nil, err
+	}
+	return &SpillFile{
+		file:   file,
+		writer: bufio.NewWriter(file),
+	}, nil
+}
+
+func (this *SpillFile) Write(record []byte) error {
+	size := int64(len(record) + 4)
+	if atomic.AddInt64(&spillUsed, size) > SpillLimit() {
+		atomic.AddInt64(&spillUsed, -size)
+		return ErrSpillLimitExceeded
+	}
+	this.size += size
+
+	var length [4]byte
+	binary.LittleEndian.PutUint32(length[:], uint32(len(record)))
+	_, err := this.writer.Write(length[:])
+	if err == nil {
+		_, err = this.writer.Write(record)
+	}
+	return err
+}
+
+// switch to reading, from the first record
+func (this *SpillFile) Rewind() error {
+	if this.writer != nil {
+		err := this.writer.Flush()
+		if err != nil {
+			return err
+		}
+		this.writer = nil
+	}
+	_, err := this.file.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+	this.reader = bufio.NewReader(this.file)
+	return nil
+}
+
+// returns io.EOF after the last record
+func (this *SpillFile) Read() ([]byte, error) {
+	var length [4]byte
+	_, err := io.ReadFull(this.reader, length[:])
+	if err != nil {
+		return nil, err
+	}
+	record := make([]byte, binary.LittleEndian.Uint32(length[:]))
+	_, err = io.ReadFull(this.reader, record)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return record, err
+}
+
+func (this *SpillFile) Size() int64 {
+	return this.size
+}
+
+// the file is removed, and its space given back
+func (this *SpillFile) Close() {
+	if this.file == nil {
+		return
+	}
+	name := this.file.Name()
+	this.file.Close()
+	os.Remove(name)
+	this.file = nil
+	this.writer = nil
+	this.reader = nil
+	atomic.AddInt64(&spillUsed, -this.size)
+	this.size = 0
+}
@@ -0,0 +1,82 @@
+//  Copyright (c) 2021 Couchbase, Inc.
+//  Licensed under the Apache License, Version 2.0 (the "License"); you may not use this file
+//  except in compliance with the License. You may obtain a copy of the License at
+//    http://www.apache.org/licenses/LICENSE-2.0
+//  Unless required by applicable law or agreed to in writing, software distributed under the
+//  License is distributed on an "AS IS" BASIS, WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND,
+//  either express or implied. See the License for the specific language governing permissions
+//  and limitations under the License.
+
+package util
+
+import (
+	"fmt"
+	"io"
+	"testing"
+)
+
+func TestSpillFile(t *testing.T) {
+	file, err := NewSpillFile()
+	if err != nil {
+		t.Fatalf("Failed to create spill file: %v", err)
+	}
+
+	for i := 0; i < 1000; i++ {
+		err = file.Write([]byte(fmt.Sprintf("record %d", i)))
+		if err != nil {
+			t.Fatalf("Failed to write record %d: %v", i, err)
+		}
+	}
+	if SpillUsed() != file.Size() {
+		t.Errorf("Expected %d bytes used, got %d", file.Size(), SpillUsed())
+	}
+
+	err = file.Rewind()
+	if err != nil {
+		t.Fatalf("Failed to rewind spill file: %v", err)
+	}
+	for i := 0; i < 1000; i++ {
+		record, err := file.Read()
+		if err != nil {
+			t.Fatalf("Failed to read record %d: %v", i, err)
+		}
+		if string(record) != fmt.Sprintf("record %d", i) {
+			t.Errorf("Expected record %d, got %s", i, record)
+		}
+	}
+	_, err = file.Read()
+	if err != io.EOF {
+		t.Errorf("Expected EOF, got %v", err)
+	}
+
+	file.Close()
+	if SpillUsed() != 0 {
+		t.Errorf("Expected no space used, got %d", SpillUsed())
+	}
+}
+
+func TestSpillLimit(t *testing.T) {
+	defer SetSpillLimit(SpillLimit())
+	SetSpillLimit(100)
+
+	file, err := NewSpillFile()
+	if err != nil {
+		t.Fatalf("Failed to create spill file: %v", err)
+	}
+	defer file.Close()
+
+	err = file.Write(make([]byte, 64))
+	if err != nil {
+		t.Fatalf("Failed to write record: %v", err)
+	}
+	err = file.Write(make([]byte, 64))
+	if err != ErrSpillLimitExceeded {
+		t.Errorf("Expected spill limit exceeded, got %v", err)
+	}
+
+	SetSpillLimit(0)
+	_, err = NewSpillFile()
+	if err != ErrSpillLimitExceeded {
+		t.Errorf("Expected spill limit exceeded, got %v", err)
+	}
+}
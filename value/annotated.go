@@ -129,6 +129,36 @@ func (this *annotatedValue) MarshalJSON() ([]byte, error) {
 	return this.Value.MarshalJSON()
 }
 
+/*
+Covering scans set the value as a field of itself, which is not
+counted again.
+*/
+func (this *annotatedValue) Size() uint64 {
+	if this.covers == nil {
+		return this.Value.Size()
+	}
+
+	var obj objectValue
+	switch val := this.Value.(type) {
+	case objectValue:
+		obj = val
+	case *ScopeValue:
+		obj, _ = val.Value.(objectValue)
+	}
+	if obj == nil {
+		return this.Value.Size()
+	}
+
+	var size uint64
+	for n, v := range obj {
+		if v != this {
+			size += NewValue(v).Size()
+		}
+		size += uint64(len(n))
+	}
+	return size
+}
+
 func (this *annotatedValue) WriteJSON(w io.Writer, prefix, indent string, fast bool) error {
 	return this.Value.WriteJSON(w, prefix, indent, fast)
 }
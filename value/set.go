@@ -215,6 +215,60 @@ func (this *Set) Has(key Value) bool {
 	return ok
 }
 
+/*
+Get returns the item put for the key, which is nil unless the set
+collects items, and whether the key is in the set.
+*/
+func (this *Set) Get(key Value) (Value, bool) {
+	if key == nil {
+		return nil, this.nills
+	}
+
+	if this.numeric && key.Type() != NUMBER {
+		panic(fmt.Sprintf("Numeric set will not support value type %T.", key))
+		return nil, false
+	}
+
+	var rv Value
+	ok := false
+	switch key.Type() {
+	case MISSING:
+		return this.missings, this.missings != nil
+	case NULL:
+		return this.nulls, this.nulls != nil
+	case BOOLEAN:
+		rv, ok = this.booleans[key.Actual().(bool)]
+	case NUMBER:
+		num := key.unwrap()
+		switch num := num.(type) {
+		case floatValue:
+			f := float64(num)
+			if IsInt(f) {
+				rv, ok = this.ints[int64(f)]
+			} else {
+				rv, ok = this.floats[f]
+			}
+		case intValue:
+			rv, ok = this.ints[int64(num)]
+		default:
+			panic(fmt.Sprintf("Unsupported value type %T.", key))
+		}
+	case STRING:
+		rv, ok = this.strings[key.Actual().(string)]
+	case ARRAY:
+		rv, ok = this.arrays[key.String()]
+	case OBJECT:
+		rv, ok = this.objects[key.String()]
+	case BINARY:
+		str := base64.StdEncoding.EncodeToString(key.Actual().([]byte))
+		rv, ok = this.binaries[str]
+	default:
+		panic(fmt.Sprintf("Unsupported value type %T.", key))
+	}
+
+	return rv, ok
+}
+
 func (this *Set) Len() int {
 	rv := len(this.booleans) + len(this.floats) + len(this.ints) + len(this.strings) +
 		len(this.arrays) + len(this.objects) + len(this.binaries)
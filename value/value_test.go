@@ -615,3 +615,20 @@ func TestActualForIndex(t *testing.T) {
 		t.Errorf("Expected int64, got %v of type %T", i, i)
 	}
 }
+
+func TestCoveredValueSize(t *testing.T) {
+	av := NewAnnotatedValue(map[string]interface{}{"a": 1})
+	size := av.Size()
+
+	// covering scans set the value as a field of itself
+	av.SetCover("cover ((`g`.`a`))", NewValue(1))
+	av.SetField("g", av)
+	if av.Size() != size+uint64(len("g")) {
+		t.Errorf("Expected size %v, got %v", size+uint64(len("g")), av.Size())
+	}
+
+	joined := NewAnnotatedValue(map[string]interface{}{"g": av})
+	if joined.Size() != av.Size()+uint64(len("g")) {
+		t.Errorf("Expected size %v, got %v", av.Size()+uint64(len("g")), joined.Size())
+	}
+}